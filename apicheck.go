@@ -0,0 +1,140 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jmoiron/sqlx"
+)
+
+// md5Hash matches the hashes RapidPro's archives API expects, the lowercase hex MD5 of the archive file
+var md5Hash = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// CheckOrgAPICompatibility checks that every archive of the passed in org and type is one RapidPro's archives API
+// (/api/v2/archives.json) can list and link to, as an archive written by one version of Archiver may not be what
+// another version of RapidPro expects. The API lists every archive row with its period, start date, record count, size
+// and hash, and signs a download link from its URL by taking the bucket from the first label of its host and the key
+// from its path. If an S3 client is passed in, the object at each URL is also checked to exist and match its hash.
+func CheckOrgAPICompatibility(ctx context.Context, db *sqlx.DB, s3Client s3iface.S3API, org Org, archiveType ArchiveType) ([]*ConsistencyIssue, error) {
+	archives, err := ListArchives(ctx, db, org, archiveType, DateRange{Start: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), End: time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		return nil, err
+	}
+
+	issues := apiIssues(archives)
+	if s3Client == nil {
+		return issues, nil
+	}
+
+	for _, a := range archives {
+		if a.URL == "" || a.PurgedOn != nil {
+			continue
+		}
+		etag, err := GetS3FileETAG(ctx, s3Client, a.URL)
+		if err != nil {
+			issues = append(issues, newAPIIssue(a, "download link would be to a missing object %s: %s", a.URL, err.Error()))
+		} else if !strings.Contains(etag, "-") && etag != a.Hash {
+			issues = append(issues, newAPIIssue(a, "hash %s doesn't match the object at its URL, whose MD5 is %s", a.Hash, etag))
+		}
+	}
+	return issues, nil
+}
+
+// apiIssues returns the problems RapidPro's archives API would have with the passed in archives of an org and type
+func apiIssues(archives []*Archive) []*ConsistencyIssue {
+	byID := make(map[int]*Archive, len(archives))
+	for _, a := range archives {
+		byID[a.ID] = a
+	}
+
+	issues := make([]*ConsistencyIssue, 0)
+	add := func(a *Archive, detail string, args ...interface{}) {
+		issues = append(issues, newAPIIssue(a, detail, args...))
+	}
+
+	for _, a := range archives {
+		start := a.StartDate.In(time.UTC)
+		if a.Period != DayPeriod && a.Period != MonthPeriod {
+			add(a, "period %s isn't daily or monthly", a.Period)
+		}
+		if start.Hour() != 0 || start.Minute() != 0 || start.Second() != 0 || start.Nanosecond() != 0 {
+			add(a, "start date %s isn't midnight UTC", start.Format(time.RFC3339))
+		} else if a.Period == MonthPeriod && start.Day() != 1 {
+			add(a, "monthly archive doesn't start on the first of its month")
+		}
+
+		if a.RecordCount < 0 || a.Size < 0 {
+			add(a, "negative record count %d or size %d", a.RecordCount, a.Size)
+		}
+		if a.RecordCount > 0 && a.Size == 0 {
+			add(a, "has %d records but a size of zero", a.RecordCount)
+		}
+		if a.URL != "" && !md5Hash.MatchString(a.Hash) {
+			add(a, "hash %q isn't a lowercase hex MD5", a.Hash)
+		}
+
+		// archives without a URL are listed without a download link, which is only right if they have no records
+		if a.URL == "" {
+			if a.RecordCount > 0 {
+				add(a, "has %d records but no URL to download them from", a.RecordCount)
+			}
+		} else if detail := apiURLIssue(a.URL); detail != "" {
+			add(a, "%s", detail)
+		}
+
+		if a.PurgedOn != nil && a.URL != "" {
+			add(a, "purged on %s but still listed with a download link", a.PurgedOn.In(time.UTC).Format("2006-01-02"))
+		}
+
+		// dailies can only be rolled up into the monthly of their month, and monthlies aren't rolled up
+		if a.Rollup != nil {
+			rollup := byID[*a.Rollup]
+			switch {
+			case a.Period != DayPeriod:
+				add(a, "monthly archive is rolled up into archive %d", *a.Rollup)
+			case rollup == nil:
+				add(a, "rolled up into archive %d which doesn't exist", *a.Rollup)
+			case rollup.Period != MonthPeriod || !rollup.coversDate(a.StartDate):
+				add(a, "rolled up into archive %d which isn't the monthly of its month", *a.Rollup)
+			}
+		}
+	}
+	return issues
+}
+
+// apiURLIssue returns why the API can't sign a download link from the passed in URL, empty if it can
+func apiURLIssue(archiveURL string) string {
+	u, err := url.Parse(archiveURL)
+	if err != nil {
+		return fmt.Sprintf("URL %s can't be parsed", archiveURL)
+	}
+	if u.Scheme != "https" {
+		return fmt.Sprintf("URL %s isn't https", archiveURL)
+	}
+
+	// the bucket is read from the first label of the host, so path style URLs give the wrong bucket
+	labels := strings.Split(u.Hostname(), ".")
+	if len(labels) < 3 || labels[0] == "s3" || strings.HasPrefix(labels[0], "s3-") {
+		return fmt.Sprintf("URL %s doesn't have its bucket in its host", archiveURL)
+	}
+	if strings.Trim(u.Path, "/") == "" {
+		return fmt.Sprintf("URL %s has no key", archiveURL)
+	}
+	return ""
+}
+
+func newAPIIssue(a *Archive, detail string, args ...interface{}) *ConsistencyIssue {
+	return &ConsistencyIssue{
+		OrgID:       a.OrgID,
+		ArchiveType: a.ArchiveType,
+		Kind:        IssueAPIIncompatible,
+		ArchiveID:   a.ID,
+		StartDate:   a.StartDate,
+		Detail:      fmt.Sprintf(detail, args...),
+	}
+}
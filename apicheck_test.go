@@ -0,0 +1,63 @@
+package archiver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPICompatibility(t *testing.T) {
+	monthlyID := 10
+	otherID := 11
+	missingID := 99
+	purgedOn := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	hash := "e7ed8e6a3d2b6c8b2b5a7f0ad3c96f01"
+
+	archive := func(id int, period ArchivePeriod, start time.Time, count int, url string) *Archive {
+		return &Archive{ID: id, OrgID: 2, ArchiveType: MessageType, Period: period, StartDate: start, RecordCount: count, Size: int64(count * 10), Hash: hash, URL: url}
+	}
+	url := "https://dl-archiver-test.s3.amazonaws.com/2/message_D20170812_" + hash + ".jsonl.gz"
+
+	monthly := archive(monthlyID, MonthPeriod, time.Date(2017, 8, 1, 0, 0, 0, 0, time.UTC), 10, url)
+	other := archive(otherID, MonthPeriod, time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC), 0, "")
+	good := archive(1, DayPeriod, time.Date(2017, 8, 12, 0, 0, 0, 0, time.UTC), 5, url)
+	good.Rollup = &monthlyID
+
+	// archives the API is happy with have no issues, including empty ones without a file
+	assert.Equal(t, 0, len(apiIssues([]*Archive{monthly, other, good})))
+
+	badHash := archive(2, DayPeriod, time.Date(2017, 8, 13, 0, 0, 0, 0, time.UTC), 5, url)
+	badHash.Hash = "E7ED8E6A"
+	notMidnight := archive(3, DayPeriod, time.Date(2017, 8, 14, 3, 0, 0, 0, time.UTC), 5, url)
+	midMonth := archive(4, MonthPeriod, time.Date(2017, 10, 2, 0, 0, 0, 0, time.UTC), 5, url)
+	noFile := archive(5, DayPeriod, time.Date(2017, 8, 15, 0, 0, 0, 0, time.UTC), 5, "")
+	pathStyle := archive(6, DayPeriod, time.Date(2017, 8, 16, 0, 0, 0, 0, time.UTC), 5, "https://s3.amazonaws.com/dl-archiver-test/2/message_D20170816.jsonl.gz")
+	wrongRollup := archive(7, DayPeriod, time.Date(2017, 8, 17, 0, 0, 0, 0, time.UTC), 5, url)
+	wrongRollup.Rollup = &otherID
+	goneRollup := archive(8, DayPeriod, time.Date(2017, 8, 18, 0, 0, 0, 0, time.UTC), 5, url)
+	goneRollup.Rollup = &missingID
+	purged := archive(9, DayPeriod, time.Date(2017, 8, 19, 0, 0, 0, 0, time.UTC), 5, url)
+	purged.PurgedOn = &purgedOn
+
+	issues := apiIssues([]*Archive{monthly, other, badHash, notMidnight, midMonth, noFile, pathStyle, wrongRollup, goneRollup, purged})
+	details := make(map[int]string, len(issues))
+	for _, i := range issues {
+		assert.Equal(t, IssueAPIIncompatible, i.Kind)
+		details[i.ArchiveID] = i.Detail
+	}
+	assert.Equal(t, map[int]string{
+		2: `hash "E7ED8E6A" isn't a lowercase hex MD5`,
+		3: "start date 2017-08-14T03:00:00Z isn't midnight UTC",
+		4: "monthly archive doesn't start on the first of its month",
+		5: "has 5 records but no URL to download them from",
+		6: "URL https://s3.amazonaws.com/dl-archiver-test/2/message_D20170816.jsonl.gz doesn't have its bucket in its host",
+		7: "rolled up into archive 11 which isn't the monthly of its month",
+		8: "rolled up into archive 99 which doesn't exist",
+		9: "purged on 2018-03-01 but still listed with a download link",
+	}, details)
+
+	assert.Equal(t, "URL http://dl-archiver-test.s3.amazonaws.com/2/foo.jsonl.gz isn't https", apiURLIssue("http://dl-archiver-test.s3.amazonaws.com/2/foo.jsonl.gz"))
+	assert.Equal(t, "URL https://dl-archiver-test.s3.amazonaws.com/ has no key", apiURLIssue("https://dl-archiver-test.s3.amazonaws.com/"))
+	assert.Equal(t, "", apiURLIssue("https://dl-archiver-test.s3.eu-west-1.amazonaws.com/2/foo.jsonl.gz"))
+}
@@ -14,6 +14,7 @@ import (
 
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/jmoiron/sqlx"
@@ -47,6 +48,30 @@ const (
 	MonthPeriod = ArchivePeriod("M")
 )
 
+// ParseArchiveType parses an archive type, accepting both singular and plural forms
+func ParseArchiveType(value string) (ArchiveType, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "message", "messages", "msg", "msgs":
+		return MessageType, nil
+	case "run", "runs":
+		return RunType, nil
+	default:
+		return "", fmt.Errorf("invalid archive type '%s', must be message or run", value)
+	}
+}
+
+// ParseArchivePeriod parses an archive period, accepting either its name or its code
+func ParseArchivePeriod(value string) (ArchivePeriod, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "day", "daily", "d":
+		return DayPeriod, nil
+	case "month", "monthly", "m":
+		return MonthPeriod, nil
+	default:
+		return "", fmt.Errorf("invalid archive period '%s', must be day or month", value)
+	}
+}
+
 // Org represents the model for an org
 type Org struct {
 	ID              int       `db:"id"`
@@ -55,6 +80,19 @@ type Org struct {
 	IsAnon          bool      `db:"is_anon"`
 	Language        *string   `db:"language"`
 	RetentionPeriod int
+	RetentionRules  map[ArchiveType]*RetentionRule
+
+	// the profile of the fields redacted from this org's records, if any
+	Redaction *Redaction
+
+	// the schema profile of the fields included in this org's records, if any
+	Schema SchemaProfile
+
+	// the salt the URNs of this anonymous org are hashed with rather than being left out, if they are
+	urnSalt []byte
+
+	// the prefix of the keys of this org's archives in our bucket, if any
+	S3Prefix string
 }
 
 // Archive represents the model for an archive
@@ -76,10 +114,30 @@ type Archive struct {
 	NeedsDeletion bool       `db:"needs_deletion"`
 	DeletedOn     *time.Time `db:"deleted_date"`
 	Rollup        *int       `db:"rollup_id"`
+	PurgedOn      *time.Time `db:"purged_on"`
+
+	// the id of the last record deleted for this archive, set as we go so an interrupted deletion can resume
+	DeletionLastID *int64 `db:"deletion_last_id"`
+
+	// the hash of the chain of records in this archive, if enabled, see RecordChain
+	ChainHash *string `db:"chain_hash"`
+
+	// the redaction profile applied to the records of this archive, if any, see Redaction
+	Redaction *string `db:"redaction"`
+
+	// the stage of archiving this archive has reached and why it failed if it did, see ArchiveState
+	State      ArchiveState `db:"state"`
+	StateError *string      `db:"state_error"`
 
 	Org         Org
 	ArchiveFile string
 	Dailies     []*Archive
+
+	// the reason this archive couldn't be created, if it failed
+	BuildError error
+
+	// the number of records in this archive's period if they were counted before it was built, see precountArchives
+	counted *int
 }
 
 func (a *Archive) endDate() time.Time {
@@ -117,7 +175,7 @@ func GetActiveOrgs(ctx context.Context, db *sqlx.DB, conf *Config) ([]Org, error
 
 	orgs := make([]Org, 0, 10)
 	for rows.Next() {
-		org := Org{RetentionPeriod: conf.RetentionPeriod}
+		org := Org{RetentionPeriod: conf.RetentionPeriod, S3Prefix: conf.s3Prefix()}
 		err = rows.StructScan(&org)
 		if err != nil {
 			return nil, errors.Wrapf(err, "error scanning active org")
@@ -125,6 +183,21 @@ func GetActiveOrgs(ctx context.Context, db *sqlx.DB, conf *Config) ([]Org, error
 		orgs = append(orgs, org)
 	}
 
+	err = applyRetentionPolicy(conf, orgs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error applying retention policy")
+	}
+
+	err = applyRedactionPolicy(conf, orgs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error applying redaction policy")
+	}
+
+	err = applySchemaProfile(conf, orgs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error applying schema profile")
+	}
+
 	return orgs, nil
 }
 
@@ -149,8 +222,9 @@ func GetCurrentArchives(ctx context.Context, db *sqlx.DB, org Org, archiveType A
 }
 
 const lookupArchivesNeedingDeletion = `
-SELECT id, org_id, start_date::timestamp with time zone as start_date, period, archive_type, hash, size, record_count, url, rollup_id, needs_deletion 
-FROM archives_archive WHERE org_id = $1 AND archive_type = $2 AND needs_deletion = TRUE
+SELECT id, org_id, start_date::timestamp with time zone as start_date, period, archive_type, hash, size, record_count, url, rollup_id, needs_deletion, deletion_last_id,
+	coalesce(state, '') as state, state_error
+FROM archives_archive WHERE org_id = $1 AND archive_type = $2 AND needs_deletion = TRUE AND purged_on IS NULL
 ORDER BY start_date asc, period desc
 `
 
@@ -191,7 +265,7 @@ func GetCurrentArchiveCount(ctx context.Context, db *sqlx.DB, org Org, archiveTy
 
 // between is inclusive on both sides
 const lookupOrgDailyArchivesForDateRange = `
-SELECT id, start_date::timestamp with time zone as start_date, period, archive_type, hash, size, record_count, url, rollup_id
+SELECT id, start_date::timestamp with time zone as start_date, period, archive_type, hash, size, record_count, url, rollup_id, redaction
 FROM archives_archive
 WHERE org_id = $1 AND archive_type = $2 AND period = $3 AND start_date BETWEEN $4 AND $5
 ORDER BY start_date asc
@@ -218,7 +292,7 @@ func GetMissingDailyArchives(ctx context.Context, db *sqlx.DB, now time.Time, or
 	defer cancel()
 
 	// our first archive would be active days from today
-	endDate := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -org.RetentionPeriod)
+	endDate := newestEligibleDay(now, org)
 	orgUTC := org.CreatedOn.In(time.UTC)
 	startDate := time.Date(orgUTC.Year(), orgUTC.Month(), orgUTC.Day(), 0, 0, 0, 0, time.UTC)
 
@@ -326,9 +400,11 @@ func GetMissingMonthlyArchives(ctx context.Context, db *sqlx.DB, now time.Time,
 }
 
 // BuildRollupArchive builds a monthly archive from the files present on S3
-func BuildRollupArchive(ctx context.Context, db *sqlx.DB, conf *Config, s3Client s3iface.S3API, monthlyArchive *Archive, now time.Time, org Org, archiveType ArchiveType) error {
-	ctx, cancel := context.WithTimeout(ctx, time.Hour)
+func BuildRollupArchive(ctx context.Context, db *sqlx.DB, conf *Config, s3Client s3iface.S3API, monthlyArchive *Archive, now time.Time, org Org, archiveType ArchiveType) (err error) {
+	deadline := conf.buildDeadline()
+	ctx, cancel := context.WithTimeout(ctx, deadline)
 	defer cancel()
+	defer func() { err = checkDeadline(ctx, err, "rollup build", deadline) }()
 
 	start := time.Now()
 
@@ -361,6 +437,14 @@ func BuildRollupArchive(ctx context.Context, db *sqlx.DB, conf *Config, s3Client
 	writer := bufio.NewWriter(gzWriter)
 	defer file.Close()
 
+	// if enabled, chain our records as they are copied from each daily
+	var records io.Writer = writer
+	var chain *RecordChain
+	if conf.RecordChainHash {
+		chain = NewRecordChain()
+		records = io.MultiWriter(writer, chain)
+	}
+
 	recordCount := 0
 
 	dailies, err := GetDailyArchivesForDateRange(ctx, db, org, archiveType, startDate, endDate)
@@ -368,11 +452,15 @@ func BuildRollupArchive(ctx context.Context, db *sqlx.DB, conf *Config, s3Client
 		return err
 	}
 
-	// calculate total expected size
+	// calculate total expected size, failing early if we don't have room for it
 	estimatedSize := int64(0)
 	for _, d := range dailies {
 		estimatedSize += d.Size
 	}
+	err = checkDiskSpace(conf.TempDir, estimatedSize, conf.TempReserveMB)
+	if err != nil {
+		return err
+	}
 
 	// for each daily
 	for _, daily := range dailies {
@@ -395,7 +483,7 @@ func BuildRollupArchive(ctx context.Context, db *sqlx.DB, conf *Config, s3Client
 		}
 
 		// copy this daily file (uncompressed) to our new monthly file
-		_, err = io.Copy(writer, gzipReader)
+		_, err = io.Copy(records, gzipReader)
 		if err != nil {
 			return errors.Wrapf(err, "error copying from s3 to disk for URL: %s", daily.URL)
 		}
@@ -425,6 +513,10 @@ func BuildRollupArchive(ctx context.Context, db *sqlx.DB, conf *Config, s3Client
 
 	// calculate our size and hash
 	monthlyArchive.Hash = hex.EncodeToString(writerHash.Sum(nil))
+	if chain != nil {
+		chainHash := chain.Hash()
+		monthlyArchive.ChainHash = &chainHash
+	}
 	stat, err := file.Stat()
 	if err != nil {
 		return errors.Wrapf(err, "error statting file: %s", file.Name())
@@ -433,6 +525,7 @@ func BuildRollupArchive(ctx context.Context, db *sqlx.DB, conf *Config, s3Client
 	monthlyArchive.RecordCount = recordCount
 	monthlyArchive.BuildTime = int(time.Since(start) / time.Millisecond)
 	monthlyArchive.Dailies = dailies
+	monthlyArchive.Redaction = rollupRedaction(dailies)
 	monthlyArchive.NeedsDeletion = false
 
 	return nil
@@ -470,12 +563,12 @@ func EnsureTempArchiveDirectory(path string) error {
 }
 
 const lookupMsgs = `
-SELECT rec.visibility, row_to_json(rec) FROM (
+SELECT rec.visibility, rec.created_on, rec.id, row_to_json(rec), CASE WHEN $2 THEN (SELECT metadata FROM msgs_msg WHERE id = rec.id) ELSE NULL END FROM (
 	SELECT
 	  mm.id,
 	  broadcast_id as broadcast,
 	  row_to_json(contact) as contact,
-	  CASE WHEN oo.is_anon = False THEN ccu.identity ELSE null END as urn,
+	  CASE WHEN $1 THEN null ELSE ccu.identity END as urn,
 	  row_to_json(channel) as channel,
 	  CASE WHEN direction = 'I' THEN 'in'
 		WHEN direction = 'O' THEN 'out'
@@ -514,50 +607,139 @@ SELECT rec.visibility, row_to_json(rec) FROM (
 	  sent_on,
 	  mm.modified_on as modified_on
 	FROM msgs_msg mm 
-	  JOIN orgs_org oo ON mm.org_id = oo.id
 	  JOIN LATERAL (select uuid, name from contacts_contact cc where cc.id = mm.contact_id) as contact ON True
 	  LEFT JOIN contacts_contacturn ccu ON mm.contact_urn_id = ccu.id
 	  LEFT JOIN LATERAL (select uuid, name from channels_channel ch where ch.id = mm.channel_id) as channel ON True
 	  LEFT JOIN LATERAL (select coalesce(jsonb_agg(label_row), '[]'::jsonb) as data from (select uuid, name from msgs_label ml INNER JOIN msgs_msg_labels mml ON ml.id = mml.label_id AND mml.msg_id = mm.id) as label_row) as labels_agg ON True
 
-	  WHERE mm.org_id = $1 AND mm.created_on >= $2 AND mm.created_on < $3
-	ORDER BY created_on ASC, id ASC) rec; 
+	  WHERE mm.org_id = $3 AND mm.created_on >= $4 AND mm.created_on < $5 AND (mm.created_on, mm.id) > ($6, $7)
+	ORDER BY created_on ASC, id ASC
+	LIMIT $8) rec; 
 `
 
-// writeMessageRecords writes the messages in the archive's date range to the passed in writer
-func writeMessageRecords(ctx context.Context, db *sqlx.DB, archive *Archive, writer *bufio.Writer) (int, error) {
-	var rows *sqlx.Rows
+// writeMessageRecords writes the messages in the archive's date range to the passed in writer, a page at a time,
+// building their JSON ourselves if asked to
+func writeMessageRecords(ctx context.Context, db sqlx.ExtContext, archive *Archive, pageSize int, fetchSize int, goJSON bool, serialize func(string) (string, error), writer *bufio.Writer, progress *progressLogger, pace *pacer) (int, error) {
 	recordCount := 0
 
 	// first write our normal records
 	var record, visibility string
+	var metadata *string
+	var key exportKey
+	var fields msgFields
+	includeMetadata := archive.Org.Schema.includes(MessageType, "metadata")
 
-	rows, err := db.QueryxContext(ctx, lookupMsgs, archive.Org.ID, archive.StartDate, archive.endDate())
+	write, err := messageRecordWriter(ctx, db, archive, serialize, writer, progress, pace)
 	if err != nil {
-		return 0, errors.Wrapf(err, "error querying messages for org: %d", archive.Org.ID)
+		return 0, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		err = rows.Scan(&visibility, &record)
+	query := lookupMsgs
+	if goJSON {
+		query = lookupMsgFields
+	}
+
+	params := []interface{}{archive.Org.leavesOutURNs(), includeMetadata, archive.Org.ID, archive.StartDate, archive.endDate()}
+	err = exportPages(ctx, db, query, params, archive.StartDate, pageSize, fetchSize, func(rows *sqlx.Rows) (exportKey, error) {
+		var err error
+		if goJSON {
+			err = scanMsgFields(rows, &fields)
+			key, visibility, metadata = exportKey{at: fields.CreatedOn, id: fields.ID}, fields.visibility(), fields.Metadata
+			if err == nil && visibility != "deleted" {
+				record = fields.record()
+			}
+		} else {
+			err = rows.Scan(&visibility, &key.at, &key.id, &record, &metadata)
+		}
 		if err != nil {
-			return 0, errors.Wrapf(err, "error scanning message row for org: %d", archive.Org.ID)
+			return key, errors.Wrapf(err, "error scanning message row for org: %d", archive.Org.ID)
 		}
 
-		if visibility == "deleted" {
-			continue
-		}
-		writer.WriteString(record)
-		writer.WriteString("\n")
-		recordCount++
+		written, err := write(visibility, record, metadata)
+		recordCount += written
+		return key, err
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "error exporting messages for org: %d", archive.Org.ID)
 	}
 
 	logrus.WithField("record_count", recordCount).Debug("Done Writing")
 	return recordCount, nil
 }
 
+// messageRecordWriter returns the function which writes each exported message of the passed in archive, with its
+// visibility and metadata, to the passed in writer, pacing, skipping deleted messages, and adding to, projecting,
+// pseudonymizing, archiving the attachments of, redacting and serializing the rest as configured, returning how many
+// records it wrote
+func messageRecordWriter(ctx context.Context, db sqlx.ExtContext, archive *Archive, serialize func(string) (string, error), writer *bufio.Writer, progress *progressLogger, pace *pacer) (func(string, string, *string) (int, error), error) {
+	attachments := contextAttachmentArchiver(ctx)
+	redact := archive.Org.Redaction.redactor(archive.Org.ID, MessageType)
+	includeMetadata := archive.Org.Schema.includes(MessageType, "metadata")
+	project := archive.Org.Schema.projector(MessageType)
+
+	enrich, err := archive.Org.Schema.contactEnricher(ctx, db, archive)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading contacts for org: %d", archive.Org.ID)
+	}
+
+	return func(visibility string, record string, metadata *string) (int, error) {
+		err := pace.wait(ctx, 1)
+		if err != nil {
+			return 0, errors.Wrapf(err, "error pacing message export for org: %d", archive.Org.ID)
+		}
+
+		if visibility == "deleted" {
+			return 0, nil
+		}
+		if includeMetadata {
+			record, err = withField(record, "metadata", metadata)
+			if err != nil {
+				return 0, errors.Wrapf(err, "error adding metadata to message for org: %d", archive.Org.ID)
+			}
+		}
+		if enrich != nil {
+			record, err = enrich(record)
+			if err != nil {
+				return 0, errors.Wrapf(err, "error adding contact fields to message for org: %d", archive.Org.ID)
+			}
+		}
+		if project != nil {
+			record, err = project(record)
+			if err != nil {
+				return 0, errors.Wrapf(err, "error projecting message for org: %d", archive.Org.ID)
+			}
+		}
+		record, err = archive.Org.pseudonymizeURN(record)
+		if err != nil {
+			return 0, errors.Wrapf(err, "error pseudonymizing message for org: %d", archive.Org.ID)
+		}
+		if attachments != nil {
+			record, err = attachments.archiveRecord(ctx, archive.Org, record)
+			if err != nil {
+				return 0, errors.Wrapf(err, "error archiving attachments for org: %d", archive.Org.ID)
+			}
+		}
+		if redact != nil {
+			record, err = redact(record)
+			if err != nil {
+				return 0, errors.Wrapf(err, "error redacting message for org: %d", archive.Org.ID)
+			}
+		}
+		if serialize != nil {
+			record, err = serialize(record)
+			if err != nil {
+				return 0, errors.Wrapf(err, "error serializing message for org: %d", archive.Org.ID)
+			}
+		}
+		writer.WriteString(record)
+		writer.WriteString("\n")
+		progress.add(1, int64(len(record)+1))
+		return 1, nil
+	}, nil
+}
+
 const lookupFlowRuns = `
-SELECT rec.exited_on, row_to_json(rec)
+SELECT rec.exited_on, rec.modified_on, rec.id, row_to_json(rec)
 FROM (
    SELECT
 	 fr.id as id,
@@ -570,7 +752,14 @@ FROM (
 		FROM jsonb_array_elements(fr.path::jsonb) AS path_row) as path_data
      ) as path,
      (SELECT coalesce(jsonb_object_agg(values_data.key, values_data.value), '{}'::jsonb) from (
-		SELECT key, jsonb_build_object('name', value -> 'name', 'value', value -> 'value', 'input', value -> 'input', 'time', (value -> 'created_on')::text::timestamptz, 'category', value -> 'category', 'node', value -> 'node_uuid') as value
+		SELECT key, jsonb_build_object(
+			'name', value -> 'name',
+			'value', value -> 'value',
+			'input', CASE WHEN value ? 'input' THEN value -> 'input' ELSE value -> 'text' END,
+			'time', (value -> 'created_on')::text::timestamptz,
+			'category', CASE WHEN jsonb_typeof(value -> 'category') = 'object' THEN value -> 'category' -> 'base' WHEN value ? 'category' THEN value -> 'category' ELSE value -> 'category_localized' END,
+			'node', CASE WHEN value ? 'node_uuid' THEN value -> 'node_uuid' ELSE value -> 'node' END
+		) as value
 		FROM jsonb_each(fr.results::jsonb)) AS values_data
 	 ) as values,
 	 CASE
@@ -599,47 +788,200 @@ FROM (
      JOIN LATERAL (SELECT uuid, name FROM flows_flow WHERE flows_flow.id = fr.flow_id) AS flow_struct ON True
      JOIN LATERAL (SELECT uuid, name FROM contacts_contact cc WHERE cc.id = fr.contact_id) AS contact_struct ON True
    
-   WHERE fr.org_id = $2 AND fr.modified_on >= $3 AND fr.modified_on < $4
+   WHERE fr.org_id = $2 AND fr.modified_on >= $3 AND fr.modified_on < $4 AND (fr.modified_on, fr.id) > ($5, $6)
    ORDER BY fr.modified_on ASC, id ASC
+   LIMIT $7
 ) as rec;
 `
 
-// writeRunRecords writes the runs in the archive's date range to the passed in writer
-func writeRunRecords(ctx context.Context, db *sqlx.DB, archive *Archive, writer *bufio.Writer) (int, error) {
-	var rows *sqlx.Rows
-	rows, err := db.QueryxContext(ctx, lookupFlowRuns, archive.Org.IsAnon, archive.Org.ID, archive.StartDate, archive.endDate())
-	if err != nil {
-		return 0, errors.Wrapf(err, "error querying run records for org: %d", archive.Org.ID)
+// exportQuery returns the SQL and parameters of the query used to export the first page of the records of the passed
+// in archive, with no limit on the page size
+func exportQuery(archive *Archive, goJSON bool) (string, []interface{}) {
+	switch archive.ArchiveType {
+	case MessageType:
+		query := lookupMsgs
+		if goJSON {
+			query = lookupMsgFields
+		}
+		return query, []interface{}{archive.Org.leavesOutURNs(), archive.Org.Schema.includes(MessageType, "metadata"), archive.Org.ID, archive.StartDate, archive.endDate(), archive.StartDate, 0, nil}
+	case RunType:
+		query := lookupFlowRuns
+		if goJSON {
+			query = lookupRunFields
+		}
+		return query, []interface{}{archive.Org.IsAnon, archive.Org.ID, archive.StartDate, archive.endDate(), archive.StartDate, 0, nil}
 	}
-	defer rows.Close()
+	return "", nil
+}
+
+// exportQueryFields returns the SQL and parameters of the query used to export the records of the passed in archive
+func exportQueryFields(archive *Archive, goJSON bool) logrus.Fields {
+	query, params := exportQuery(archive, goJSON)
+	if query == "" {
+		return logrus.Fields{}
+	}
+	return logrus.Fields{"sql": query, "params": params}
+}
 
+// writeRunRecords writes the runs in the archive's date range to the passed in writer, a page at a time, building
+// their JSON ourselves if asked to
+func writeRunRecords(ctx context.Context, db sqlx.ExtContext, archive *Archive, pageSize int, fetchSize int, goJSON bool, serialize func(string) (string, error), writer *bufio.Writer, progress *progressLogger, pace *pacer) (int, error) {
 	recordCount := 0
 	var record string
 	var exitedOn *time.Time
-	for rows.Next() {
-		err = rows.Scan(&exitedOn, &record)
+	var key exportKey
+	var fields runFields
+
+	write, err := runRecordWriter(ctx, db, archive, serialize, writer, progress, pace)
+	if err != nil {
+		return 0, err
+	}
+
+	query := lookupFlowRuns
+	if goJSON {
+		query = lookupRunFields
+	}
+
+	params := []interface{}{archive.Org.IsAnon, archive.Org.ID, archive.StartDate, archive.endDate()}
+	err = exportPages(ctx, db, query, params, archive.StartDate, pageSize, fetchSize, func(rows *sqlx.Rows) (exportKey, error) {
+		var err error
+		if goJSON {
+			err = scanRunFields(rows, &fields)
+			if err == nil {
+				key, exitedOn = exportKey{at: fields.ModifiedOn, id: fields.ID}, fields.ExitedOn
+				record, err = fields.record()
+			}
+		} else {
+			err = rows.Scan(&exitedOn, &key.at, &key.id, &record)
+		}
+		if err != nil {
+			return key, errors.Wrapf(err, "error scanning run record for org: %d", archive.Org.ID)
+		}
+
+		written, err := write(record, exitedOn != nil)
+		recordCount += written
+		return key, err
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "error exporting runs for org: %d", archive.Org.ID)
+	}
+
+	return recordCount, nil
+}
+
+// runRecordWriter returns the function which writes each exported run of the passed in archive, with whether it has
+// exited, to the passed in writer, failing for runs which are still active and otherwise pacing, adding to,
+// projecting, redacting and serializing them as configured, returning how many records it wrote
+func runRecordWriter(ctx context.Context, db sqlx.ExtContext, archive *Archive, serialize func(string) (string, error), writer *bufio.Writer, progress *progressLogger, pace *pacer) (func(string, bool) (int, error), error) {
+	redact := archive.Org.Redaction.redactor(archive.Org.ID, RunType)
+	project := archive.Org.Schema.projector(RunType)
+
+	enrich, err := archive.Org.Schema.contactEnricher(ctx, db, archive)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error loading contacts for org: %d", archive.Org.ID)
+	}
 
+	return func(record string, exited bool) (int, error) {
 		// shouldn't be archiving an active run, that's an error
-		if exitedOn == nil {
+		if !exited {
 			return 0, fmt.Errorf("run still active, cannot archive: %s", record)
 		}
 
+		err := pace.wait(ctx, 1)
 		if err != nil {
-			return 0, errors.Wrapf(err, "error scanning run record for org: %d", archive.Org.ID)
+			return 0, errors.Wrapf(err, "error pacing run export for org: %d", archive.Org.ID)
 		}
 
+		if enrich != nil {
+			record, err = enrich(record)
+			if err != nil {
+				return 0, errors.Wrapf(err, "error adding contact fields to run for org: %d", archive.Org.ID)
+			}
+		}
+		if project != nil {
+			record, err = project(record)
+			if err != nil {
+				return 0, errors.Wrapf(err, "error projecting run for org: %d", archive.Org.ID)
+			}
+		}
+		if redact != nil {
+			record, err = redact(record)
+			if err != nil {
+				return 0, errors.Wrapf(err, "error redacting run for org: %d", archive.Org.ID)
+			}
+		}
+		if serialize != nil {
+			record, err = serialize(record)
+			if err != nil {
+				return 0, errors.Wrapf(err, "error serializing run for org: %d", archive.Org.ID)
+			}
+		}
 		writer.WriteString(record)
 		writer.WriteString("\n")
-		recordCount++
+		progress.add(1, int64(len(record)+1))
+		return 1, nil
+	}, nil
+}
+
+const countArchivableMessages = `
+SELECT count(*)
+FROM msgs_msg mm
+WHERE mm.org_id = $1 AND mm.created_on >= $2 AND mm.created_on < $3 AND mm.visibility != 'D'
+`
+
+const countArchivableRuns = `
+SELECT count(*)
+FROM flows_flowrun fr
+WHERE fr.org_id = $1 AND fr.modified_on >= $2 AND fr.modified_on < $3
+`
+
+// countArchivableRecords counts the records in the database for the passed in archive using the same predicates we use
+// to write them
+func countArchivableRecords(ctx context.Context, db *sqlx.DB, archive *Archive) (int, error) {
+	var query string
+	switch archive.ArchiveType {
+	case MessageType:
+		query = countArchivableMessages
+	case RunType:
+		query = countArchivableRuns
+	default:
+		return 0, fmt.Errorf("unknown archive type: %s", archive.ArchiveType)
 	}
 
-	return recordCount, nil
+	count := 0
+	err := sqlx.GetContext(ctx, preparing(db, nil), &count, query, archive.Org.ID, archive.StartDate, archive.endDate())
+	if err != nil {
+		return 0, errors.Wrapf(err, "error counting records for org: %d", archive.Org.ID)
+	}
+	return count, nil
+}
+
+// checkRecordCount counts the records in the database for the passed in archive, returning an error if that differs
+// from the number we wrote, ie: if records were added or modified in the archive's period while we were writing it
+func checkRecordCount(ctx context.Context, db *sqlx.DB, archive *Archive, written int) error {
+	count, err := countArchivableRecords(ctx, db, archive)
+	if err != nil {
+		return err
+	}
+
+	if count != written {
+		return classify(ErrVerification, fmt.Errorf("record count mismatch, wrote %d records but database has %d", written, count))
+	}
+	return nil
 }
 
 // CreateArchiveFile is responsible for writing an archive file for the passed in archive from our database
 func CreateArchiveFile(ctx context.Context, db *sqlx.DB, archive *Archive, archivePath string) error {
-	ctx, cancel := context.WithTimeout(ctx, time.Hour*3)
+	return createArchiveFile(ctx, db, archive, archivePath, &Config{}, nil)
+}
+
+// createArchiveFile writes the archive file for the passed in archive, with the record chain, free space check, pacing,
+// slow query warning and statement timeout of the export as configured in the passed in config
+func createArchiveFile(ctx context.Context, db *sqlx.DB, archive *Archive, archivePath string, config *Config, opts *Options) (err error) {
+	deadline := config.buildDeadline()
+	ctx, cancel := context.WithTimeout(ctx, deadline)
 	defer cancel()
+	defer func() { err = checkDeadline(ctx, err, "archive build", deadline) }()
 
 	start := time.Now()
 
@@ -660,8 +1002,7 @@ func CreateArchiveFile(ctx context.Context, db *sqlx.DB, archive *Archive, archi
 	defer func() {
 		// we only set the archive filename when we succeed
 		if archive.ArchiveFile == "" {
-			err = os.Remove(file.Name())
-			if err != nil {
+			if err := os.Remove(file.Name()); err != nil {
 				log.WithError(err).WithField("filename", file.Name()).Error("error cleaning up archive file")
 			}
 		}
@@ -669,25 +1010,43 @@ func CreateArchiveFile(ctx context.Context, db *sqlx.DB, archive *Archive, archi
 
 	hash := md5.New()
 	gzWriter := gzip.NewWriter(io.MultiWriter(file, hash))
-	writer := bufio.NewWriter(gzWriter)
+	var chain *RecordChain
+	var records io.Writer = gzWriter
+	if config.RecordChainHash {
+		chain = NewRecordChain()
+		records = io.MultiWriter(records, chain)
+	}
+	var pii *PIIScanner
+	if config.ScanPII && archive.Org.IsAnon {
+		pii = NewPIIScanner(archive.ArchiveType)
+		records = io.MultiWriter(records, pii)
+	}
+	writer := bufio.NewWriter(records)
 	defer file.Close()
 
 	log.WithFields(logrus.Fields{
 		"filename": file.Name(),
 	}).Debug("creating new archive file")
 
-	recordCount := 0
-	switch archive.ArchiveType {
-	case MessageType:
-		recordCount, err = writeMessageRecords(ctx, db, archive, writer)
-	case RunType:
-		recordCount, err = writeRunRecords(ctx, db, archive, writer)
-	default:
-		err = fmt.Errorf("unknown archive type: %s", archive.ArchiveType)
+	// count what we expect to write so we can estimate how long is left as we go, unless we already have
+	source := opts.recordSource(db, archivePath, config)
+	expected := 0
+	if archive.counted != nil {
+		expected = *archive.counted
+	} else {
+		expected, err = source.CountRecords(ctx, archive)
+		if err != nil {
+			return err
+		}
 	}
 
-	if err != nil {
-		return errors.Wrapf(err, "error writing archive")
+	// if we counted no records in this period before we started, there's nothing to export
+	recordCount := 0
+	if archive.counted == nil || expected > 0 {
+		recordCount, err = source.WriteRecords(ctx, archive, writer, expected)
+		if err != nil {
+			return err
+		}
 	}
 
 	err = writer.Flush()
@@ -702,6 +1061,13 @@ func CreateArchiveFile(ctx context.Context, db *sqlx.DB, archive *Archive, archi
 
 	// calculate our size and hash
 	archive.Hash = hex.EncodeToString(hash.Sum(nil))
+	if chain != nil {
+		chainHash := chain.Hash()
+		archive.ChainHash = &chainHash
+	}
+	if pii != nil {
+		pii.logFound(log)
+	}
 	stat, err := file.Stat()
 	if err != nil {
 		return errors.Wrapf(err, "error calculating archive hash")
@@ -714,6 +1080,7 @@ func CreateArchiveFile(ctx context.Context, db *sqlx.DB, archive *Archive, archi
 	archive.ArchiveFile = file.Name()
 	archive.Size = stat.Size()
 	archive.RecordCount = recordCount
+	archive.Redaction = archive.Org.Redaction.profileFor(archive.ArchiveType)
 	archive.BuildTime = int(time.Since(start) / time.Millisecond)
 
 	log.WithFields(logrus.Fields{
@@ -727,11 +1094,94 @@ func CreateArchiveFile(ctx context.Context, db *sqlx.DB, archive *Archive, archi
 	return nil
 }
 
-// UploadArchive uploads the passed archive file to S3
-func UploadArchive(ctx context.Context, s3Client s3iface.S3API, bucket string, archive *Archive) error {
-	ctx, cancel := context.WithTimeout(ctx, time.Minute*15)
-	defer cancel()
+// exportRecords writes the records of the passed in archive to the passed in writer, returning how many were written
+// once it has checked that is how many the database has for its period
+func exportRecords(ctx context.Context, db *sqlx.DB, archive *Archive, archivePath string, config *Config, serialize func(string) (string, error), log *logrus.Entry, writer *bufio.Writer, expected int) (int, error) {
+	progress := newProgressLogger(log, "writing archive file", expected)
+
+	// fail early if we don't have room for this archive, rather than part way through writing it
+	if config.TempReserveMB > 0 {
+		estimated, err := estimateArchiveSize(ctx, db, archive, expected)
+		if err != nil {
+			return 0, err
+		}
+		err = checkDiskSpace(archivePath, estimated, config.TempReserveMB)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// when paging, our export queries run in a read only repeatable read transaction so that every page sees the same
+	// snapshot as a single query would, and when reading through a cursor or with an export timeout they run in one so
+	// that we can declare it or set the timeout
+	var exportTx *sqlx.Tx
+	if !config.ExportWithCopy && (config.ExportPageSize > 0 || config.ExportFetchSize > 0 || config.ExportTimeoutSeconds > 0) {
+		var err error
+		exportTx, err = db.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true, Isolation: sql.LevelRepeatableRead})
+		if err != nil {
+			return 0, errors.Wrapf(err, "error starting export transaction")
+		}
+		defer exportTx.Rollback()
+
+		err = setStatementTimeout(ctx, exportTx, time.Duration(config.ExportTimeoutSeconds)*time.Second)
+		if err != nil {
+			return 0, err
+		}
+	}
+	exporter := preparing(db, exportTx)
+
+	// when exporting with copy, every record is read with a single COPY over a read only connection of its own, which
+	// has the export timeout instead
+	var copier *pgConn
+	if config.ExportWithCopy {
+		var err error
+		copier, err = dialCopy(ctx, db, config)
+		if err != nil {
+			return 0, err
+		}
+		defer copier.Close()
+	}
+
+	// warn with the query we're running if the export is taking longer than it should
+	watchdog := startWatchdog(log.WithFields(exportQueryFields(archive, config.exportsGoJSON())), "export query", config.slowTaskDuration())
+	pace := newPacer(config.MaxExportRate)
+
+	var recordCount int
+	var err error
+	switch {
+	case copier != nil && archive.ArchiveType == MessageType:
+		recordCount, err = copyMessageRecords(ctx, exporter, copier, archive, serialize, writer, progress, pace)
+	case copier != nil && archive.ArchiveType == RunType:
+		recordCount, err = copyRunRecords(ctx, exporter, copier, archive, serialize, writer, progress, pace)
+	case archive.ArchiveType == MessageType:
+		recordCount, err = writeMessageRecords(ctx, exporter, archive, config.ExportPageSize, config.ExportFetchSize, config.exportsGoJSON(), serialize, writer, progress, pace)
+	case archive.ArchiveType == RunType:
+		recordCount, err = writeRunRecords(ctx, exporter, archive, config.ExportPageSize, config.ExportFetchSize, config.exportsGoJSON(), serialize, writer, progress, pace)
+	default:
+		err = fmt.Errorf("unknown archive type: %s", archive.ArchiveType)
+	}
+	watchdog.stop()
+
+	// release our export transaction's connection before we need another to check our count
+	if exportTx != nil {
+		exportTx.Rollback()
+	}
+
+	if err != nil {
+		return 0, errors.Wrapf(err, "error writing archive")
+	}
+
+	// make sure nothing changed underneath us while we were writing
+	err = checkRecordCount(ctx, db, archive, recordCount)
+	if err != nil {
+		return 0, err
+	}
+
+	return recordCount, nil
+}
 
+// archiveS3Path returns the key of the passed in archive in our bucket, which includes its hash
+func archiveS3Path(archive *Archive) string {
 	archivePath := ""
 	if archive.Period == DayPeriod {
 		archivePath = fmt.Sprintf(
@@ -746,10 +1196,23 @@ func UploadArchive(ctx context.Context, s3Client s3iface.S3API, bucket string, a
 			archive.StartDate.Year(), archive.StartDate.Month(),
 			archive.Hash)
 	}
+	return archive.Org.s3Path(archivePath)
+}
+
+// UploadArchive uploads the passed archive file to S3
+func UploadArchive(ctx context.Context, s3Client s3iface.S3API, bucket string, archive *Archive) error {
+	return uploadArchiveWithin(ctx, s3Client, bucket, archive, defaultUploadDeadline)
+}
+
+// uploadArchiveWithin uploads the passed archive file to S3, abandoning the upload if it takes longer than the passed
+// in deadline
+func uploadArchiveWithin(ctx context.Context, s3Client s3iface.S3API, bucket string, archive *Archive, deadline time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, deadline)
+	defer cancel()
 
-	err := UploadToS3(ctx, s3Client, bucket, archivePath, archive)
+	err := UploadToS3(ctx, s3Client, bucket, archiveS3Path(archive), archive)
 	if err != nil {
-		return errors.Wrapf(err, "error uploading archive to S3")
+		return classify(ErrUploadFailed, errors.Wrapf(checkDeadline(ctx, err, "archive upload", deadline), "error uploading archive to S3"))
 	}
 
 	archive.NeedsDeletion = true
@@ -767,9 +1230,25 @@ func UploadArchive(ctx context.Context, s3Client s3iface.S3API, bucket string, a
 	return nil
 }
 
+// uploadArchive uploads the passed in archive to our configured bucket, warning if the upload is taking too long
+func uploadArchive(ctx context.Context, s3Client s3iface.S3API, config *Config, archive *Archive) error {
+	log := logrus.WithFields(logrus.Fields{
+		"org_id":       archive.Org.ID,
+		"archive_type": archive.ArchiveType,
+		"start_date":   archive.StartDate,
+		"period":       archive.Period,
+		"file_size":    archive.Size,
+	})
+
+	watchdog := startWatchdog(log, "archive upload", config.slowTaskDuration())
+	defer watchdog.stop()
+
+	return uploadArchiveWithin(ctx, s3Client, config.S3Bucket, archive, config.uploadDeadline())
+}
+
 const insertArchive = `
-INSERT INTO archives_archive(archive_type, org_id, created_on, start_date, period, record_count, size, hash, url, needs_deletion, build_time, rollup_id)
-VALUES(:archive_type, :org_id, :created_on, :start_date, :period, :record_count, :size, :hash, :url, :needs_deletion, :build_time, :rollup_id)
+INSERT INTO archives_archive(archive_type, org_id, created_on, start_date, period, record_count, size, hash, url, needs_deletion, build_time, rollup_id, chain_hash, redaction, state)
+VALUES(:archive_type, :org_id, :created_on, :start_date, :period, :record_count, :size, :hash, :url, :needs_deletion, :build_time, :rollup_id, :chain_hash, :redaction, 'committed')
 RETURNING id
 `
 
@@ -779,8 +1258,25 @@ SET rollup_id = $1
 WHERE ARRAY[id] <@ $2
 `
 
+// ErrArchiveExists is returned when writing an archive for a period which has already been archived, ie: by another
+// archiver instance running at the same time
+var ErrArchiveExists = errors.New("archive already exists for period")
+
+const lockArchivePeriod = `SELECT pg_advisory_xact_lock(hashtext($1))`
+
+const lookupArchiveForPeriod = `
+SELECT count(*)
+FROM archives_archive
+WHERE org_id = $1 AND archive_type = $2 AND period = $3 AND start_date = $4
+`
+
 // WriteArchiveToDB write an archive to the Database
 func WriteArchiveToDB(ctx context.Context, db *sqlx.DB, archive *Archive) error {
+	return writeArchiveToDB(ctx, db, archive, 0)
+}
+
+// writeArchiveToDB writes an archive to the database, with the passed in statement timeout if it isn't zero
+func writeArchiveToDB(ctx context.Context, db *sqlx.DB, archive *Archive, timeout time.Duration) error {
 	ctx, cancel := context.WithTimeout(ctx, time.Minute)
 	defer cancel()
 
@@ -792,9 +1288,41 @@ func WriteArchiveToDB(ctx context.Context, db *sqlx.DB, archive *Archive) error
 		return errors.Wrapf(err, "error starting transaction")
 	}
 
-	rows, err := tx.NamedQuery(insertArchive, archive)
+	err = setStatementTimeout(ctx, tx, timeout)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	ext := preparing(db, tx)
+
+	// lock this period until we commit so that concurrent writers of the same archive are serialized
+	lockKey := fmt.Sprintf("archive:%d:%s:%s:%s", archive.OrgID, archive.ArchiveType, archive.Period, archive.StartDate.Format("2006-01-02"))
+	_, err = ext.ExecContext(ctx, lockArchivePeriod, lockKey)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrapf(err, "error locking archive period")
+	}
+
+	// then check nobody beat us to it
+	existing := 0
+	err = sqlx.GetContext(ctx, ext, &existing, lookupArchiveForPeriod, archive.OrgID, archive.ArchiveType, archive.Period, archive.StartDate)
+	if err != nil {
+		tx.Rollback()
+		return errors.Wrapf(err, "error checking for existing archive")
+	}
+	if existing > 0 {
+		tx.Rollback()
+		return ErrArchiveExists
+	}
+
+	rows, err := sqlx.NamedQueryContext(ctx, ext, insertArchive, archive)
 	if err != nil {
 		tx.Rollback()
+
+		// a unique constraint on the period, if there is one, has the same effect as our lock
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return ErrArchiveExists
+		}
 		return errors.Wrapf(err, "error inserting archive")
 	}
 
@@ -805,6 +1333,7 @@ func WriteArchiveToDB(ctx context.Context, db *sqlx.DB, archive *Archive) error
 		return errors.Wrapf(err, "error reading new archive id")
 	}
 	rows.Close()
+	archive.State = ArchiveCommitted
 
 	// if we have children to update do so
 	if len(archive.Dailies) > 0 {
@@ -854,56 +1383,147 @@ func DeleteArchiveFile(archive *Archive) error {
 		"org_id":        archive.Org.ID,
 		"archive_type":  archive.ArchiveType,
 		"start_date":    archive.StartDate,
-		"periond":       archive.Period,
+		"period":        archive.Period,
 		"db_archive_id": archive.ID,
 		"filename":      archive.ArchiveFile,
 	}).Debug("deleted temporary archive file")
 	return nil
 }
 
-// CreateOrgArchives builds all the missing archives for the passed in org
-func CreateOrgArchives(ctx context.Context, now time.Time, config *Config, db *sqlx.DB, s3Client s3iface.S3API, org Org, archiveType ArchiveType) ([]*Archive, error) {
-	log := logrus.WithFields(logrus.Fields{
-		"org":    org.Name,
-		"org_id": org.ID,
-	})
-	records := 0
-	start := time.Now()
+// newestEligibleDay returns the newest day whose records are old enough to be archived for the passed in org as of the
+// passed in time, this is the last day we look for missing daily archives for
+func newestEligibleDay(now time.Time, org Org) time.Time {
+	nowUTC := now.In(time.UTC)
+	return time.Date(nowUTC.Year(), nowUTC.Month(), nowUTC.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -org.RetentionPeriod)
+}
 
-	archiveCount, err := GetCurrentArchiveCount(ctx, db, org, archiveType)
-	if err != nil {
-		return nil, errors.Wrapf(err, "error getting current archive count")
+// CreateNewlyEligibleArchives builds the daily archives for the passed in org which have become eligible to be built
+// since the passed in time, without looking for any others which are missing. As days only become eligible at
+// midnight UTC, this usually has nothing to do and doesn't touch the database.
+func CreateNewlyEligibleArchives(ctx context.Context, since time.Time, now time.Time, config *Config, db *sqlx.DB, s3Client s3iface.S3API, org Org, archiveType ArchiveType, opts *Options) ([]*Archive, error) {
+	if !config.buildsPeriod(DayPeriod) {
+		return []*Archive{}, nil
 	}
 
-	archives := make([]*Archive, 0)
+	startDate := newestEligibleDay(since, org).AddDate(0, 0, 1)
+	endDate := newestEligibleDay(now, org)
+
+	// nothing before the org existed can be missing
+	orgUTC := org.CreatedOn.In(time.UTC)
+	orgStart := time.Date(orgUTC.Year(), orgUTC.Month(), orgUTC.Day(), 0, 0, 0, 0, time.UTC)
+	if startDate.Before(orgStart) {
+		startDate = orgStart
+	}
+
+	if endDate.Before(startDate) {
+		return []*Archive{}, nil
+	}
+
+	daily, err := GetMissingDailyArchivesForDateRange(ctx, db, startDate, endDate, org, archiveType)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting newly eligible daily archives")
+	}
+
+	err = createArchives(ctx, db, config, s3Client, org, daily, opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating newly eligible daily archives")
+	}
+	return daily, nil
+}
+
+// CreateDateRangeArchives builds the missing daily archives for the passed in org and type whose days fall within the
+// passed in date range, leaving out any days which aren't yet eligible to be archived as of the passed in time. Days
+// already covered by a daily or monthly archive aren't built again, and the dailies built are rolled up as usual by the
+// next run.
+func CreateDateRangeArchives(ctx context.Context, now time.Time, config *Config, db *sqlx.DB, s3Client s3iface.S3API, org Org, archiveType ArchiveType, dates DateRange, opts *Options) ([]*Archive, error) {
+	if !config.buildsPeriod(DayPeriod) {
+		return []*Archive{}, nil
+	}
+
+	// nothing before the org existed can be missing, and our missing archives query is inclusive of its end date
+	orgUTC := org.CreatedOn.In(time.UTC)
+	startDate := dates.Start.In(time.UTC)
+	if orgStart := time.Date(orgUTC.Year(), orgUTC.Month(), orgUTC.Day(), 0, 0, 0, 0, time.UTC); startDate.Before(orgStart) {
+		startDate = orgStart
+	}
+	endDate := dates.End.In(time.UTC).AddDate(0, 0, -1)
+	if newest := newestEligibleDay(now, org); endDate.After(newest) {
+		endDate = newest
+	}
+	if endDate.Before(startDate) {
+		return []*Archive{}, nil
+	}
+
+	daily, err := GetMissingDailyArchivesForDateRange(ctx, db, startDate, endDate, org, archiveType)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting missing daily archives")
+	}
+
+	err = createArchives(ctx, db, config, s3Client, org, daily, opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating daily archives")
+	}
+	return daily, nil
+}
+
+// CreateOrgArchives builds all the missing archives for the passed in org
+func CreateOrgArchives(ctx context.Context, now time.Time, config *Config, db *sqlx.DB, s3Client s3iface.S3API, org Org, archiveType ArchiveType, opts *Options) ([]*Archive, error) {
+	log := logrus.WithFields(logrus.Fields{
+		"org":    org.Name,
+		"org_id": org.ID,
+	})
+	records := 0
+	start := time.Now()
+
+	// if the missing archives of all orgs were found at the start of this run, we don't need to look up this org's
+	store := opts.archiveStore(db, config)
+	dailies, archived, found := opts.takeMissingArchives(now, org, archiveType)
+	if !found {
+		archiveCount, err := store.GetCurrentArchiveCount(ctx, org, archiveType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting current archive count")
+		}
+		archived = archiveCount > 0
+	}
+
+	archives := make([]*Archive, 0)
+	var err error
 
 	// no existing archives means this might be a backfill, figure out if there are full months we can build first
-	if archiveCount == 0 {
-		archives, err = GetMissingMonthlyArchives(ctx, db, now, org, archiveType)
+	if !archived && config.buildsPeriod(MonthPeriod) {
+		archives, err = store.GetMissingMonthlyArchives(ctx, now, org, archiveType)
 		if err != nil {
 			return nil, errors.Wrapf(err, "error getting missing monthly archives")
 		}
 
 		// we first create monthly archives
-		err = createArchives(ctx, db, config, s3Client, org, archives)
+		err = createArchives(ctx, db, config, s3Client, org, archives, opts)
 		if err != nil {
 			return nil, errors.Wrapf(err, "error creating new monthly archives")
 		}
+
+		// which cover some of the dailies we found missing
+		found = found && len(archives) == 0
 	}
 
 	// then add in daily archives taking into account the monthly that have been built
-	daily, err := GetMissingDailyArchives(ctx, db, now, org, archiveType)
-	if err != nil {
-		return nil, errors.Wrapf(err, "error getting missing daily archives")
-	}
-	// we then create missing daily archives
-	err = createArchives(ctx, db, config, s3Client, org, daily)
-	if err != nil {
-		return nil, errors.Wrapf(err, "error creating new daily archives")
-	}
+	if config.buildsPeriod(DayPeriod) {
+		daily := dailies
+		if !found {
+			daily, err = store.GetMissingDailyArchives(ctx, now, org, archiveType)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error getting missing daily archives")
+			}
+		}
+		// we then create missing daily archives
+		err = createArchives(ctx, db, config, s3Client, org, daily, opts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error creating new daily archives")
+		}
 
-	// append daily archives to any monthly archives
-	archives = append(archives, daily...)
+		// append daily archives to any monthly archives
+		archives = append(archives, daily...)
+	}
 	defer ctx.Done()
 
 	// sum all records in the archives
@@ -923,11 +1543,29 @@ func CreateOrgArchives(ctx context.Context, now time.Time, config *Config, db *s
 	return archives, nil
 }
 
-func createArchive(ctx context.Context, db *sqlx.DB, config *Config, s3Client s3iface.S3API, archive *Archive) error {
-	err := CreateArchiveFile(ctx, db, archive, config.TempDir)
+// buildArchive writes, validates and uploads the file for the passed in archive, but doesn't write it to the database
+func buildArchive(ctx context.Context, db *sqlx.DB, config *Config, s3Client s3iface.S3API, archive *Archive, opts *Options) error {
+	archive.State = ArchiveBuilding
+
+	// a period we know has no records doesn't need a file if we don't keep them for empty archives
+	if archive.counted != nil && *archive.counted == 0 && config.SkipEmptyArchiveFiles {
+		skipArchiveFile(archive)
+		archive.State = ArchiveBuilt
+		return nil
+	}
+
+	// copy the attachments of messages into our bucket as we export them if asked to
+	if config.ArchiveAttachments && config.UploadToS3 && archive.ArchiveType == MessageType {
+		ctx = withAttachmentArchiver(ctx, newAttachmentArchiver(config, s3Client))
+	}
+
+	err := createArchiveFile(ctx, exportDB(ctx, db, opts.replica(), archive), archive, config.TempDir, config, opts)
 	if err != nil {
 		return errors.Wrap(err, "error writing archive file")
 	}
+	archive.State = ArchiveBuilt
+	hooks := opts.hooks()
+	hooks.archiveBuilt(ctx, archive)
 
 	defer func() {
 		if !config.KeepFiles {
@@ -938,48 +1576,123 @@ func createArchive(ctx context.Context, db *sqlx.DB, config *Config, s3Client s3
 		}
 	}()
 
-	if config.UploadToS3 {
-		err = UploadArchive(ctx, s3Client, config.S3Bucket, archive)
+	if config.ValidateArchives {
+		err = ValidateArchiveFile(archive)
 		if err != nil {
-			return errors.Wrap(err, "error writing archive to s3")
+			return classify(ErrVerification, errors.Wrap(err, "error validating archive file"))
 		}
 	}
 
-	err = WriteArchiveToDB(ctx, db, archive)
-	if err != nil {
-		return errors.Wrap(err, "error writing record to db")
+	if config.UploadToS3 {
+		archive.State = ArchiveUploading
+		err = uploadArchive(ctx, s3Client, config, archive)
+		if err != nil {
+			return errors.Wrap(err, "error writing archive to s3")
+		}
+		archive.State = ArchiveUploaded
+		hooks.uploaded(ctx, archive)
 	}
 
 	return nil
 }
 
-func createArchives(ctx context.Context, db *sqlx.DB, config *Config, s3Client s3iface.S3API, org Org, archives []*Archive) error {
-	log := logrus.WithFields(logrus.Fields{
-		"org":    org.Name,
-		"org_id": org.ID,
-	})
+// createArchives builds the passed in archives, up to config.ArchiveWorkers at a time as they are independent of each
+// other, but writes them to the database one at a time in the order they were passed in
+func createArchives(ctx context.Context, db *sqlx.DB, config *Config, s3Client s3iface.S3API, org Org, archives []*Archive, opts *Options) error {
+	workers := config.ArchiveWorkers
+	if workers < 1 {
+		workers = 1
+	}
 
-	for _, archive := range archives {
-		log = logrus.WithFields(logrus.Fields{
+	// count the records of every period at once, so those without any can be built without querying for them, unless
+	// they aren't read from our database
+	if !opts.customSource() {
+		err := precountArchives(ctx, db, org, archives)
+		if err != nil {
+			logrus.WithError(err).WithField("org_id", org.ID).Error("error counting records, counting each archive as it is built instead")
+		}
+	}
+	store := opts.archiveStore(db, config)
+	hooks := opts.hooks()
+
+	// start our builds in order, only starting one once there is a free worker
+	results := make([]chan error, len(archives))
+	for i := range archives {
+		results[i] = make(chan error, 1)
+	}
+	starts := make([]time.Time, len(archives))
+	slots := make(chan bool, workers)
+
+	go func() {
+		for i, archive := range archives {
+			slots <- true
+
+			// if we're paused, wait until we're resumed, and if we're shutting down or out of time, don't start anything new
+			WaitWhilePaused(ctx, opts.pauser())
+			if reason := DrainReason(ctx); reason != nil {
+				<-slots
+				results[i] <- reason
+				continue
+			}
+
+			logrus.WithFields(logrus.Fields{
+				"org_id":       org.ID,
+				"start_date":   archive.StartDate,
+				"end_date":     archive.endDate(),
+				"period":       archive.Period,
+				"archive_type": archive.ArchiveType,
+			}).Info("starting archive")
+			starts[i] = time.Now()
+			hooks.taskStarted(ctx, archive)
+
+			go func(archive *Archive, result chan error) {
+				defer func() { <-slots }()
+				result <- buildArchive(ctx, db, config, s3Client, archive, opts)
+			}(archive, results[i])
+		}
+	}()
+
+	for i, archive := range archives {
+		log := logrus.WithFields(logrus.Fields{
+			"org":          org.Name,
+			"org_id":       org.ID,
 			"start_date":   archive.StartDate,
 			"end_date":     archive.endDate(),
 			"period":       archive.Period,
 			"archive_type": archive.ArchiveType,
 		})
-		log.Info("starting archive")
-		start := time.Now()
 
-		err := createArchive(ctx, db, config, s3Client, archive)
+		err := <-results[i]
+		if isDrainReason(err) {
+			archive.BuildError = err
+			continue
+		}
+		if err == nil {
+			err = store.WriteArchive(ctx, archive)
+			if err == ErrArchiveExists {
+				log.Info("archive already created by another instance, skipping")
+				continue
+			}
+			if err != nil {
+				err = errors.Wrap(err, "error writing record to db")
+			}
+		}
 		if err != nil {
-			log.WithError(err).Error("error creating archive")
+			err = newArchiveError(archive, err)
+			log.WithError(err).WithField("error_class", errorClassName(err)).WithField("state", archive.State).Error("error creating archive")
+			archive.BuildError = err
+			archive.failed()
+			hooks.failed(ctx, archive, err)
 			continue
 		}
+		archive.State = ArchiveCommitted
+		hooks.committed(ctx, archive)
+		logPublishError(archive, publishArchive(ctx, config, s3Client, opts.publisher(), archive))
 
-		elapsed := time.Since(start)
 		log.WithFields(logrus.Fields{
 			"id":           archive.ID,
 			"record_count": archive.RecordCount,
-			"elapsed":      elapsed,
+			"elapsed":      time.Since(starts[i]),
 		}).Info("archive complete")
 	}
 
@@ -987,7 +1700,7 @@ func createArchives(ctx context.Context, db *sqlx.DB, config *Config, s3Client s
 }
 
 // RollupOrgArchives rolls up monthly archives from our daily archives
-func RollupOrgArchives(ctx context.Context, now time.Time, config *Config, db *sqlx.DB, s3Client s3iface.S3API, org Org, archiveType ArchiveType) ([]*Archive, error) {
+func RollupOrgArchives(ctx context.Context, now time.Time, config *Config, db *sqlx.DB, s3Client s3iface.S3API, org Org, archiveType ArchiveType, opts *Options) ([]*Archive, error) {
 	ctx, cancel := context.WithTimeout(ctx, time.Hour*3)
 	defer cancel()
 
@@ -996,6 +1709,7 @@ func RollupOrgArchives(ctx context.Context, now time.Time, config *Config, db *s
 		"org_id": org.ID,
 	})
 	created := make([]*Archive, 0, 1)
+	hooks := opts.hooks()
 
 	// get our missing monthly archives
 	archives, err := GetMissingMonthlyArchives(ctx, db, now, org, archiveType)
@@ -1011,26 +1725,58 @@ func RollupOrgArchives(ctx context.Context, now time.Time, config *Config, db *s
 		})
 		start := time.Now()
 		log.Info("starting rollup")
+		hooks.taskStarted(ctx, archive)
 
+		archive.State = ArchiveBuilding
 		err = BuildRollupArchive(ctx, db, config, s3Client, archive, now, org, archiveType)
 		if err != nil {
 			log.WithError(err).Error("error building monthly archive")
+			archive.failed()
+			hooks.failed(ctx, archive, newArchiveError(archive, err))
 			continue
 		}
+		archive.State = ArchiveBuilt
+		hooks.archiveBuilt(ctx, archive)
+
+		if config.ValidateArchives {
+			err = ValidateArchiveFile(archive)
+			if err != nil {
+				log.WithError(err).Error("error validating monthly archive file")
+				archive.failed()
+				hooks.failed(ctx, archive, newArchiveError(archive, classify(ErrVerification, err)))
+				continue
+			}
+		}
 
-		if config.UploadToS3 {
-			err = UploadArchive(ctx, s3Client, config.S3Bucket, archive)
+		// a month without records doesn't need a file if we don't keep them for empty archives
+		if archive.RecordCount == 0 && config.SkipEmptyArchiveFiles {
+			skipArchiveFile(archive)
+		} else if config.UploadToS3 {
+			archive.State = ArchiveUploading
+			err = uploadArchive(ctx, s3Client, config, archive)
 			if err != nil {
 				log.WithError(err).Error("error writing archive to s3")
+				archive.failed()
+				hooks.failed(ctx, archive, newArchiveError(archive, err))
 				continue
 			}
+			archive.State = ArchiveUploaded
+			hooks.uploaded(ctx, archive)
 		}
 
-		err = WriteArchiveToDB(ctx, db, archive)
+		err = writeArchiveToDB(ctx, db, archive, time.Duration(config.WriteTimeoutSeconds)*time.Second)
+		if err == ErrArchiveExists {
+			log.Info("rollup already created by another instance, skipping")
+			continue
+		}
 		if err != nil {
 			log.WithError(err).Error("error writing record to db")
+			archive.failed()
+			hooks.failed(ctx, archive, newArchiveError(archive, err))
 			continue
 		}
+		hooks.committed(ctx, archive)
+		logPublishError(archive, publishArchive(ctx, config, s3Client, opts.publisher(), archive))
 
 		if !config.KeepFiles {
 			err := DeleteArchiveFile(archive)
@@ -1055,8 +1801,8 @@ const selectOrgMessagesInRange = `
 SELECT mm.id, mm.visibility
 FROM msgs_msg mm
 LEFT JOIN contacts_contact cc ON cc.id = mm.contact_id
-WHERE mm.org_id = $1 AND mm.created_on >= $2 AND mm.created_on < $3
-ORDER BY mm.created_on ASC, mm.id ASC
+WHERE mm.org_id = $1 AND mm.created_on >= $2 AND mm.created_on < $3 AND mm.id > $4
+ORDER BY mm.id ASC
 `
 
 const setMessageDeleteReason = `
@@ -1088,13 +1834,27 @@ WHERE id IN(?)
 
 const setArchiveDeleted = `
 UPDATE archives_archive 
-SET needs_deletion = FALSE, deleted_on = $2
+SET needs_deletion = FALSE, deleted_on = $2, deletion_last_id = NULL, state = 'done', state_error = NULL
+WHERE id = $1
+`
+
+const setArchiveDeletionLastID = `
+UPDATE archives_archive
+SET deletion_last_id = $2
 WHERE id = $1
 `
 
+// deletionCheckpoint returns the id after which we should resume deleting records for the passed in archive
+func deletionCheckpoint(archive *Archive) int64 {
+	if archive.DeletionLastID != nil {
+		return *archive.DeletionLastID
+	}
+	return 0
+}
+
 // helper method to safely execute an IN query in the passed in transaction
-func executeInQuery(ctx context.Context, tx *sqlx.Tx, query string, ids []int64) error {
-	q, vs, err := sqlx.In(query, ids)
+func executeInQuery(ctx context.Context, tx *sqlx.Tx, query string, args ...interface{}) error {
+	q, vs, err := sqlx.In(query, args...)
 	if err != nil {
 		return err
 	}
@@ -1109,13 +1869,42 @@ func executeInQuery(ctx context.Context, tx *sqlx.Tx, query string, ids []int64)
 
 var deleteTransactionSize = 100
 
+// deleteInBatches calls the passed in function with each batch of deleteTransactionSize of the passed in ids in turn,
+// pacing them with the passed in pacer. Each batch gets its own timeout within the passed in context, so the deadline
+// of the whole deletion bounds every batch rather than only the work done before the first.
+func deleteInBatches(ctx context.Context, ids []int64, pace *pacer, recordType string, deleteBatch func(context.Context, []int64) error) error {
+	for startIdx := 0; startIdx < len(ids); startIdx += deleteTransactionSize {
+		endIdx := startIdx + deleteTransactionSize
+		if endIdx > len(ids) {
+			endIdx = len(ids)
+		}
+		batchIDs := ids[startIdx:endIdx]
+
+		// no single batch should take more than a few minutes
+		batchCtx, cancel := context.WithTimeout(ctx, time.Minute*15)
+		err := deleteBatch(batchCtx, batchIDs)
+		cancel()
+		if err != nil {
+			return err
+		}
+
+		err = pace.wait(ctx, len(batchIDs))
+		if err != nil {
+			return errors.Wrapf(err, "error pacing %s deletion", recordType)
+		}
+	}
+	return nil
+}
+
 // DeleteArchivedMessages takes the passed in archive, verifies the S3 file is still present (and correct), then selects
 // all the messages in the archive date range, and if equal or fewer than the number archived, deletes them 100 at a time
 //
 // Upon completion it updates the needs_deletion flag on the archive
-func DeleteArchivedMessages(ctx context.Context, config *Config, db *sqlx.DB, s3Client s3iface.S3API, archive *Archive) error {
-	outer, cancel := context.WithTimeout(ctx, time.Hour*3)
+func DeleteArchivedMessages(ctx context.Context, config *Config, db *sqlx.DB, s3Client s3iface.S3API, archive *Archive) (err error) {
+	deadline := config.deleteDeadline()
+	outer, cancel := context.WithTimeout(ctx, deadline)
 	defer cancel()
+	defer func(phase context.Context) { err = checkDeadline(phase, err, "message deletion", deadline) }(outer)
 
 	start := time.Now()
 	log := logrus.WithFields(logrus.Fields{
@@ -1136,11 +1925,23 @@ func DeleteArchivedMessages(ctx context.Context, config *Config, db *sqlx.DB, s3
 
 	// if our etag and archive md5 don't match, that's an error, return
 	if md5 != archive.Hash {
-		return fmt.Errorf("archive md5: %s and s3 etag: %s do not match", archive.Hash, md5)
+		return classify(ErrVerification, fmt.Errorf("archive md5: %s and s3 etag: %s do not match", archive.Hash, md5))
+	}
+
+	// make sure nothing we don't know how to clean up will stop us deleting messages
+	err = checkMessageReferences(outer, db)
+	if err != nil {
+		return err
+	}
+
+	// if a previous deletion was interrupted, pick up where it left off
+	lastID := deletionCheckpoint(archive)
+	if lastID > 0 {
+		log.WithField("last_id", lastID).Info("resuming interrupted deletion")
 	}
 
 	// ok, archive file looks good, let's build up our list of message ids, this may be big but we are int64s so shouldn't be too big
-	rows, err := db.QueryxContext(outer, selectOrgMessagesInRange, archive.OrgID, archive.StartDate, archive.endDate())
+	rows, err := db.QueryxContext(outer, selectOrgMessagesInRange, archive.OrgID, archive.StartDate, archive.endDate(), lastID)
 	if err != nil {
 		return err
 	}
@@ -1173,25 +1974,43 @@ func DeleteArchivedMessages(ctx context.Context, config *Config, db *sqlx.DB, s3
 		return fmt.Errorf("more messages in the database: %d than in archive: %d", visibleCount, archive.RecordCount)
 	}
 
-	// ok, delete our messages in batches, we do this in transactions as it spans a few different queries
-	for startIdx := 0; startIdx < len(msgIDs); startIdx += deleteTransactionSize {
-		// no single batch should take more than a few minutes
-		ctx, cancel := context.WithTimeout(ctx, time.Minute*15)
-		defer cancel()
+	// the labels applied to the messages we delete, whose counts we recompute once done
+	labelIDs := make(map[int64]bool)
 
-		start := time.Now()
+	progress := newProgressLogger(log, "deleting messages", len(msgIDs))
+	pace := newPacer(config.MaxDeleteRate)
 
-		endIdx := startIdx + deleteTransactionSize
-		if endIdx > len(msgIDs) {
-			endIdx = len(msgIDs)
-		}
-		batchIDs := msgIDs[startIdx:endIdx]
+	// ok, delete our messages in batches, we do this in transactions as it spans a few different queries
+	err = deleteInBatches(outer, msgIDs, pace, "message", func(ctx context.Context, batchIDs []int64) error {
+		start := time.Now()
 
-		// start our transaction
+		// start our transaction, bounding how long any of its queries can run
 		tx, err := db.BeginTxx(ctx, nil)
 		if err != nil {
 			return err
 		}
+		err = setStatementTimeout(ctx, tx, time.Duration(config.DeleteTimeoutSeconds)*time.Second)
+		if err != nil {
+			return err
+		}
+
+		// if we quarantine deleted records, copy them aside before touching them so they can be restored
+		if config.DeleteQuarantineDays > 0 {
+			err = executeInQuery(ctx, tx, quarantineMessages, archive.ID, batchIDs)
+			if err != nil {
+				return fmt.Errorf("error quarantining messages: %s", err.Error())
+			}
+
+			err = executeInQuery(ctx, tx, quarantineMessageLabels, archive.ID, batchIDs)
+			if err != nil {
+				return fmt.Errorf("error quarantining message labels: %s", err.Error())
+			}
+
+			err = executeInQuery(ctx, tx, quarantineMessageLogs, archive.ID, batchIDs)
+			if err != nil {
+				return fmt.Errorf("error quarantining channel logs: %s", err.Error())
+			}
+		}
 
 		// first update our delete_reason
 		err = executeInQuery(ctx, tx, setMessageDeleteReason, batchIDs)
@@ -1205,7 +2024,15 @@ func DeleteArchivedMessages(ctx context.Context, config *Config, db *sqlx.DB, s3
 			return fmt.Errorf("error removing channel logs: %s", err.Error())
 		}
 
-		// then any labels
+		// then any labels, remembering which they were
+		batchLabelIDs, err := selectBatchLabelIDs(ctx, tx, batchIDs)
+		if err != nil {
+			return fmt.Errorf("error selecting message labels: %s", err.Error())
+		}
+		for _, labelID := range batchLabelIDs {
+			labelIDs[labelID] = true
+		}
+
 		err = executeInQuery(ctx, tx, deleteMessageLabels, batchIDs)
 		if err != nil {
 			return fmt.Errorf("error removing message labels: %s", err.Error())
@@ -1223,23 +2050,54 @@ func DeleteArchivedMessages(ctx context.Context, config *Config, db *sqlx.DB, s3
 			return fmt.Errorf("error deleting messages: %s", err.Error())
 		}
 
+		// add this batch to our audit log of deletions
+		err = recordDeletionBatch(ctx, tx, archive, batchIDs, time.Since(start))
+		if err != nil {
+			return fmt.Errorf("error recording deletion batch: %s", err.Error())
+		}
+
+		// record how far we got, so if we are interrupted we can resume from here
+		lastID = batchIDs[len(batchIDs)-1]
+		_, err = tx.ExecContext(ctx, setArchiveDeletionLastID, archive.ID, lastID)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error recording deletion progress: %s", err.Error())
+		}
+
 		// commit our transaction
 		err = tx.Commit()
 		if err != nil {
 			return fmt.Errorf("error committing message delete transaction: %s", err.Error())
 		}
+		archive.DeletionLastID = &lastID
 
 		log.WithFields(logrus.Fields{
 			"elapsed": time.Since(start),
 			"count":   len(batchIDs),
 		}).Debug("deleted batch of messages")
-
-		cancel()
+		progress.add(len(batchIDs), 0)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	outer, cancel = context.WithTimeout(ctx, time.Minute)
+	outer, cancel = context.WithTimeout(ctx, time.Minute*15)
 	defer cancel()
 
+	// bring the counts of the labels we removed back in line
+	if len(labelIDs) > 0 {
+		ids := make([]int64, 0, len(labelIDs))
+		for labelID := range labelIDs {
+			ids = append(ids, labelID)
+		}
+		corrected, err := RecomputeLabelCounts(outer, db, ids)
+		if err != nil {
+			return err
+		}
+		log.WithField("labels", len(ids)).WithField("corrected", corrected).Debug("recomputed label counts")
+	}
+
 	deletedOn := time.Now()
 
 	// all went well! mark our archive as no longer needing deletion
@@ -1249,6 +2107,8 @@ func DeleteArchivedMessages(ctx context.Context, config *Config, db *sqlx.DB, s3
 	}
 	archive.NeedsDeletion = false
 	archive.DeletedOn = &deletedOn
+	archive.State = ArchiveDone
+	archive.StateError = nil
 
 	logrus.WithFields(logrus.Fields{
 		"elapsed": time.Since(start),
@@ -1287,7 +2147,7 @@ func DeleteBroadcasts(ctx context.Context, now time.Time, config *Config, db *sq
 
 		// make sure we have no active messages
 		var msgCount int64
-		err = db.Get(&msgCount, `SELECT count(*) FROM msgs_msg WHERE broadcast_id = $1`, broadcastID)
+		err = db.GetContext(ctx, &msgCount, `SELECT count(*) FROM msgs_msg WHERE broadcast_id = $1`, broadcastID)
 		if err != nil {
 			return errors.Wrapf(err, "unable to select number of msgs for broadcast: %d", broadcastID)
 		}
@@ -1304,35 +2164,35 @@ func DeleteBroadcasts(ctx context.Context, now time.Time, config *Config, db *sq
 		}
 
 		// delete contacts M2M
-		_, err = tx.Exec(`DELETE from msgs_broadcast_contacts WHERE broadcast_id = $1`, broadcastID)
+		_, err = tx.ExecContext(ctx, `DELETE from msgs_broadcast_contacts WHERE broadcast_id = $1`, broadcastID)
 		if err != nil {
 			tx.Rollback()
 			return errors.Wrapf(err, "error deleting related contacts for broadcast: %d", broadcastID)
 		}
 
 		// delete groups M2M
-		_, err = tx.Exec(`DELETE from msgs_broadcast_groups WHERE broadcast_id = $1`, broadcastID)
+		_, err = tx.ExecContext(ctx, `DELETE from msgs_broadcast_groups WHERE broadcast_id = $1`, broadcastID)
 		if err != nil {
 			tx.Rollback()
 			return errors.Wrapf(err, "error deleting related groups for broadcast: %d", broadcastID)
 		}
 
 		// delete URNs M2M
-		_, err = tx.Exec(`DELETE from msgs_broadcast_urns WHERE broadcast_id = $1`, broadcastID)
+		_, err = tx.ExecContext(ctx, `DELETE from msgs_broadcast_urns WHERE broadcast_id = $1`, broadcastID)
 		if err != nil {
 			tx.Rollback()
 			return errors.Wrapf(err, "error deleting related urns for broadcast: %d", broadcastID)
 		}
 
 		// delete counts associated with this broadcast
-		_, err = tx.Exec(`DELETE from msgs_broadcastmsgcount WHERE broadcast_id = $1`, broadcastID)
+		_, err = tx.ExecContext(ctx, `DELETE from msgs_broadcastmsgcount WHERE broadcast_id = $1`, broadcastID)
 		if err != nil {
 			tx.Rollback()
 			return errors.Wrapf(err, "error deleting counts for broadcast: %d", broadcastID)
 		}
 
 		// finally, delete our broadcast
-		_, err = tx.Exec(`DELETE from msgs_broadcast WHERE id = $1`, broadcastID)
+		_, err = tx.ExecContext(ctx, `DELETE from msgs_broadcast WHERE id = $1`, broadcastID)
 		if err != nil {
 			tx.Rollback()
 			return errors.Wrapf(err, "error deleting broadcast: %d", broadcastID)
@@ -1373,11 +2233,12 @@ LIMIT 1000000;
 `
 
 const selectOrgRunsInRange = `
-SELECT fr.id, fr.is_active
+SELECT fr.id, fr.is_active, coalesce(fs.status = 'W', FALSE) as session_waiting
 FROM flows_flowrun fr
 LEFT JOIN contacts_contact cc ON cc.id = fr.contact_id
-WHERE fr.org_id = $1 AND fr.modified_on >= $2 AND fr.modified_on < $3
-ORDER BY fr.modified_on ASC, fr.id ASC
+LEFT JOIN flows_flowsession fs ON fs.id = fr.session_id
+WHERE fr.org_id = $1 AND fr.modified_on >= $2 AND fr.modified_on < $3 AND NOT (fr.flow_id = ANY($4)) AND fr.id > $5
+ORDER BY fr.id ASC
 `
 
 const setRunDeleteReason = `
@@ -1402,13 +2263,47 @@ DELETE FROM flows_flowrun
 WHERE id IN(?)
 `
 
+// skippedRuns tracks the runs we didn't delete because they are still in progress
+type skippedRuns struct {
+	active  []int64
+	waiting []int64
+}
+
+// add records the passed in run if it should be skipped, returning whether it was
+func (s *skippedRuns) add(runID int64, isActive bool, sessionWaiting bool) bool {
+	if isActive {
+		s.active = append(s.active, runID)
+		return true
+	}
+	if sessionWaiting {
+		s.waiting = append(s.waiting, runID)
+		return true
+	}
+	return false
+}
+
+func (s *skippedRuns) count() int {
+	return len(s.active) + len(s.waiting)
+}
+
+// sample returns up to the passed in number of skipped run ids, for logging
+func (s *skippedRuns) sample(max int) []int64 {
+	ids := append(append([]int64{}, s.active...), s.waiting...)
+	if len(ids) > max {
+		ids = ids[:max]
+	}
+	return ids
+}
+
 // DeleteArchivedRuns takes the passed in archive, verifies the S3 file is still present (and correct), then selects
 // all the runs in the archive date range, and if equal or fewer than the number archived, deletes them 100 at a time
 //
 // Upon completion it updates the needs_deletion flag on the archive
-func DeleteArchivedRuns(ctx context.Context, config *Config, db *sqlx.DB, s3Client s3iface.S3API, archive *Archive) error {
-	outer, cancel := context.WithTimeout(ctx, time.Hour*3)
+func DeleteArchivedRuns(ctx context.Context, config *Config, db *sqlx.DB, s3Client s3iface.S3API, archive *Archive) (err error) {
+	deadline := config.deleteDeadline()
+	outer, cancel := context.WithTimeout(ctx, deadline)
 	defer cancel()
+	defer func(phase context.Context) { err = checkDeadline(phase, err, "run deletion", deadline) }(outer)
 
 	start := time.Now()
 	log := logrus.WithFields(logrus.Fields{
@@ -1429,65 +2324,94 @@ func DeleteArchivedRuns(ctx context.Context, config *Config, db *sqlx.DB, s3Clie
 
 	// if our etag and archive md5 don't match, that's an error, return
 	if md5 != archive.Hash {
-		return fmt.Errorf("archive md5: %s and s3 etag: %s do not match", archive.Hash, md5)
+		return classify(ErrVerification, fmt.Errorf("archive md5: %s and s3 etag: %s do not match", archive.Hash, md5))
+	}
+
+	// runs for flows our retention rule says to keep are never deleted
+	keptFlowIDs, err := RetentionRuleFor(config, archive.Org, RunType).keptFlowIDs(outer, db, archive.OrgID)
+	if err != nil {
+		return err
+	}
+
+	// if a previous deletion was interrupted, pick up where it left off
+	lastID := deletionCheckpoint(archive)
+	if lastID > 0 {
+		log.WithField("last_id", lastID).Info("resuming interrupted deletion")
 	}
 
 	// ok, archive file looks good, let's build up our list of run ids, this may be big but we are int64s so shouldn't be too big
-	rows, err := db.QueryxContext(outer, selectOrgRunsInRange, archive.OrgID, archive.StartDate, archive.endDate())
+	rows, err := db.QueryxContext(outer, selectOrgRunsInRange, archive.OrgID, archive.StartDate, archive.endDate(), pq.Array(keptFlowIDs), lastID)
 	if err != nil {
 		return err
 	}
 	defer rows.Close()
 
 	var runID int64
-	var isActive bool
+	var isActive, sessionWaiting bool
 	runCount := 0
 	runIDs := make([]int64, 0, archive.RecordCount)
+	skipped := &skippedRuns{}
 	for rows.Next() {
-		err = rows.Scan(&runID, &isActive)
+		err = rows.Scan(&runID, &isActive, &sessionWaiting)
 		if err != nil {
 			return err
 		}
 
-		// if this run is still active, something has gone wrong, throw an error
-		if isActive {
-			return fmt.Errorf("run %d in archive is still active", runID)
-		}
-
 		// increment our count
 		runCount++
+
+		// runs which are still in progress are never deleted, finishing them will update their modified_on so their
+		// final state ends up in a later archive and they'll be deleted with that
+		if skipped.add(runID, isActive, sessionWaiting) {
+			continue
+		}
+
 		runIDs = append(runIDs, runID)
 	}
 	rows.Close()
 
 	log.WithFields(logrus.Fields{
-		"run_count": len(runIDs),
+		"run_count":  len(runIDs),
+		"kept_flows": len(keptFlowIDs),
 	}).Debug("found runs")
 
+	if skipped.count() > 0 {
+		log.WithFields(logrus.Fields{
+			"skipped_active":  len(skipped.active),
+			"skipped_waiting": len(skipped.waiting),
+			"skipped_ids":     skipped.sample(10),
+		}).Warn("skipped deleting runs which are still in progress")
+	}
+
 	// verify we don't see more runs than there are in our archive (fewer is ok)
 	if runCount > archive.RecordCount {
 		return fmt.Errorf("more runs in the database: %d than in archive: %d", runCount, archive.RecordCount)
 	}
 
-	// ok, delete our runs in batches, we do this in transactions as it spans a few different queries
-	for startIdx := 0; startIdx < len(runIDs); startIdx += deleteTransactionSize {
-		// no single batch should take more than a few minutes
-		ctx, cancel := context.WithTimeout(ctx, time.Minute*15)
-		defer cancel()
+	progress := newProgressLogger(log, "deleting runs", len(runIDs))
+	pace := newPacer(config.MaxDeleteRate)
 
+	// ok, delete our runs in batches, we do this in transactions as it spans a few different queries
+	err = deleteInBatches(outer, runIDs, pace, "run", func(ctx context.Context, batchIDs []int64) error {
 		start := time.Now()
 
-		endIdx := startIdx + deleteTransactionSize
-		if endIdx > len(runIDs) {
-			endIdx = len(runIDs)
-		}
-		batchIDs := runIDs[startIdx:endIdx]
-
-		// start our transaction
+		// start our transaction, bounding how long any of its queries can run
 		tx, err := db.BeginTxx(ctx, nil)
 		if err != nil {
 			return err
 		}
+		err = setStatementTimeout(ctx, tx, time.Duration(config.DeleteTimeoutSeconds)*time.Second)
+		if err != nil {
+			return err
+		}
+
+		// if we quarantine deleted records, copy them aside before touching them so they can be restored
+		if config.DeleteQuarantineDays > 0 {
+			err = executeInQuery(ctx, tx, quarantineRuns, archive.ID, batchIDs)
+			if err != nil {
+				return fmt.Errorf("error quarantining runs: %s", err.Error())
+			}
+		}
 
 		// first update our delete_reason
 		err = executeInQuery(ctx, tx, setRunDeleteReason, batchIDs)
@@ -1513,18 +2437,36 @@ func DeleteArchivedRuns(ctx context.Context, config *Config, db *sqlx.DB, s3Clie
 			return fmt.Errorf("error deleting runs: %s", err.Error())
 		}
 
+		// add this batch to our audit log of deletions
+		err = recordDeletionBatch(ctx, tx, archive, batchIDs, time.Since(start))
+		if err != nil {
+			return fmt.Errorf("error recording deletion batch: %s", err.Error())
+		}
+
+		// record how far we got, so if we are interrupted we can resume from here
+		lastID = batchIDs[len(batchIDs)-1]
+		_, err = tx.ExecContext(ctx, setArchiveDeletionLastID, archive.ID, lastID)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("error recording deletion progress: %s", err.Error())
+		}
+
 		// commit our transaction
 		err = tx.Commit()
 		if err != nil {
 			return fmt.Errorf("error committing run delete transaction: %s", err.Error())
 		}
+		archive.DeletionLastID = &lastID
 
 		log.WithFields(logrus.Fields{
 			"elapsed": time.Since(start),
 			"count":   len(batchIDs),
 		}).Debug("deleted batch of runs")
-
-		cancel()
+		progress.add(len(batchIDs), 0)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	outer, cancel = context.WithTimeout(ctx, time.Minute)
@@ -1539,6 +2481,8 @@ func DeleteArchivedRuns(ctx context.Context, config *Config, db *sqlx.DB, s3Clie
 	}
 	archive.NeedsDeletion = false
 	archive.DeletedOn = &deletedOn
+	archive.State = ArchiveDone
+	archive.StateError = nil
 
 	logrus.WithFields(logrus.Fields{
 		"elapsed": time.Since(start),
@@ -1548,16 +2492,31 @@ func DeleteArchivedRuns(ctx context.Context, config *Config, db *sqlx.DB, s3Clie
 }
 
 // DeleteArchivedOrgRecords deletes all the records for the passeg in org based on archives already created
-func DeleteArchivedOrgRecords(ctx context.Context, now time.Time, config *Config, db *sqlx.DB, s3Client s3iface.S3API, org Org, archiveType ArchiveType) ([]*Archive, error) {
+func DeleteArchivedOrgRecords(ctx context.Context, now time.Time, config *Config, db *sqlx.DB, s3Client s3iface.S3API, org Org, archiveType ArchiveType, opts *Options) ([]*Archive, error) {
 	// get all the archives that haven't yet been deleted
 	archives, err := GetArchivesNeedingDeletion(ctx, db, org, archiveType)
 	if err != nil {
 		return nil, fmt.Errorf("error finding archives needing deletion '%s'", archiveType)
 	}
 
+	rule := RetentionRuleFor(config, org, archiveType)
+
+	holds, err := GetActiveLegalHolds(ctx, db, org, archiveType)
+	if err != nil {
+		return nil, err
+	}
+
 	// for each archive
 	deleted := make([]*Archive, 0, len(archives))
+	hooks := opts.hooks()
 	for _, a := range archives {
+		// if we're paused, wait until we're resumed, and if we're shutting down or out of time, don't start another
+		WaitWhilePaused(ctx, opts.pauser())
+		if Draining(ctx) {
+			break
+		}
+
+		a.Org = org
 		log := logrus.WithFields(logrus.Fields{
 			"archive_id": a.ID,
 			"org_id":     a.OrgID,
@@ -1567,12 +2526,36 @@ func DeleteArchivedOrgRecords(ctx context.Context, now time.Time, config *Config
 			"period":     a.Period,
 		})
 
+		// our retention rule may require we keep records around for longer
+		if !rule.eligibleForDeletion(now, a) {
+			log.WithField("delete_after_days", rule.DeleteAfterDays).Debug("archive not yet eligible for deletion")
+			continue
+		}
+
+		// records under a legal hold are never deleted, whatever our retention rule says
+		if hold := heldBy(holds, a); hold != nil {
+			log.WithField("hold_id", hold.ID).Info("archive under legal hold, not deleting records")
+			continue
+		}
+
+		// an archive left deleting by a crash, or which failed, is resumed from the last record it deleted
+		if state := a.CurrentState(); state == ArchiveDeleting || state == ArchiveFailed {
+			log.WithField("state", state).Info("resuming deletion of archive records")
+		}
+		err = recordArchiveState(ctx, db, a, ArchiveDeleting, nil)
+		if err != nil {
+			log.WithError(err).Error("error recording archive as deleting")
+			continue
+		}
+
 		start := time.Now()
 
 		switch a.ArchiveType {
 		case MessageType:
 			err = DeleteArchivedMessages(ctx, config, db, s3Client, a)
-			if err == nil {
+
+			// broadcasts aren't tied to an archive period, so leave them all alone while any message hold is active
+			if err == nil && len(holds) == 0 {
 				err = DeleteBroadcasts(ctx, now, config, db, org)
 			}
 
@@ -1584,10 +2567,14 @@ func DeleteArchivedOrgRecords(ctx context.Context, now time.Time, config *Config
 
 		if err != nil {
 			log.WithError(err).Error("error deleting archive")
+			// record the failure even if it was our context being cancelled
+			logStateError(a, recordArchiveState(context.Background(), db, a, ArchiveFailed, err))
+			hooks.failed(ctx, a, err)
 			continue
 		}
 
 		deleted = append(deleted, a)
+		hooks.deleted(ctx, a)
 		log.WithFields(logrus.Fields{
 			"elapsed": time.Since(start),
 		}).Info("deleted archive records")
@@ -1596,30 +2583,221 @@ func DeleteArchivedOrgRecords(ctx context.Context, now time.Time, config *Config
 	return deleted, nil
 }
 
+const countOrgMessagesInRange = `
+SELECT count(*)
+FROM msgs_msg mm
+WHERE mm.org_id = $1 AND mm.created_on >= $2 AND mm.created_on < $3
+`
+
+const countOrgRunsInRange = `
+SELECT count(*)
+FROM flows_flowrun fr
+LEFT JOIN flows_flowsession fs ON fs.id = fr.session_id
+WHERE fr.org_id = $1 AND fr.modified_on >= $2 AND fr.modified_on < $3 AND NOT (fr.flow_id = ANY($4)) AND 
+	NOT fr.is_active AND coalesce(fs.status, '') != 'W'
+`
+
+const countSkippedOrgRunsInRange = `
+SELECT count(*)
+FROM flows_flowrun fr
+LEFT JOIN flows_flowsession fs ON fs.id = fr.session_id
+WHERE fr.org_id = $1 AND fr.modified_on >= $2 AND fr.modified_on < $3 AND NOT (fr.flow_id = ANY($4)) AND 
+	(fr.is_active OR coalesce(fs.status, '') = 'W')
+`
+
+// DeletionReport describes what deleting the records for an archive would do
+type DeletionReport struct {
+	Archive    *Archive
+	Count      int
+	Skipped    int
+	Statements []string
+}
+
+// ReportArchivedOrgDeletions reports how many records would be deleted for each archive needing deletion for the passed
+// in org, along with the statements which would be run, without modifying anything
+func ReportArchivedOrgDeletions(ctx context.Context, now time.Time, config *Config, db *sqlx.DB, org Org, archiveType ArchiveType) ([]*DeletionReport, error) {
+	archives, err := GetArchivesNeedingDeletion(ctx, db, org, archiveType)
+	if err != nil {
+		return nil, fmt.Errorf("error finding archives needing deletion '%s'", archiveType)
+	}
+
+	var countQuery string
+	var statements []string
+	switch archiveType {
+	case MessageType:
+		countQuery = countOrgMessagesInRange
+		statements = []string{selectOrgMessagesInRange, setMessageDeleteReason, deleteMessageLogs, deleteMessageLabels, unlinkResponses, deleteMessages}
+	case RunType:
+		countQuery = countOrgRunsInRange
+		statements = []string{selectOrgRunsInRange, setRunDeleteReason, deleteRecentRuns, unlinkParents, deleteRuns}
+	default:
+		return nil, fmt.Errorf("unknown archive type: %s", archiveType)
+	}
+
+	for i := range statements {
+		statements[i] = strings.TrimSpace(statements[i])
+	}
+
+	rule := RetentionRuleFor(config, org, archiveType)
+	keptFlowIDs, err := rule.keptFlowIDs(ctx, db, org.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	holds, err := GetActiveLegalHolds(ctx, db, org, archiveType)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]*DeletionReport, 0, len(archives))
+	total := 0
+	for _, a := range archives {
+		if !rule.eligibleForDeletion(now, a) {
+			continue
+		}
+		if hold := heldBy(holds, a); hold != nil {
+			logrus.WithFields(logrus.Fields{
+				"archive_id":   a.ID,
+				"org_id":       a.OrgID,
+				"archive_type": a.ArchiveType,
+				"start_date":   a.StartDate,
+				"hold_id":      hold.ID,
+			}).Info("dry run, archive under legal hold, would not delete records")
+			continue
+		}
+
+		args := []interface{}{a.OrgID, a.StartDate, a.endDate()}
+		if archiveType == RunType {
+			args = append(args, pq.Array(keptFlowIDs))
+		}
+
+		report := &DeletionReport{Archive: a, Statements: statements}
+		err = db.GetContext(ctx, &report.Count, countQuery, args...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error counting records for archive: %d", a.ID)
+		}
+
+		// runs still in progress are skipped, so count those separately
+		if archiveType == RunType {
+			err = db.GetContext(ctx, &report.Skipped, countSkippedOrgRunsInRange, args...)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error counting skipped records for archive: %d", a.ID)
+			}
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"archive_id":   a.ID,
+			"org_id":       a.OrgID,
+			"archive_type": a.ArchiveType,
+			"period":       a.Period,
+			"start_date":   a.StartDate,
+			"end_date":     a.endDate(),
+			"record_count": a.RecordCount,
+			"delete_count": report.Count,
+			"skip_count":   report.Skipped,
+			"batches":      (report.Count + deleteTransactionSize - 1) / deleteTransactionSize,
+		}).Info("dry run, would delete records")
+
+		reports = append(reports, report)
+		total += report.Count
+	}
+
+	if len(reports) > 0 {
+		logrus.WithFields(logrus.Fields{
+			"org_id":       org.ID,
+			"archive_type": archiveType,
+			"archives":     len(reports),
+			"delete_count": total,
+			"enabled":      rule.Delete,
+			"sql":          strings.Join(statements, ";\n") + ";",
+		}).Info("dry run, completed deletion report for org")
+	}
+
+	return reports, nil
+}
+
 // ArchiveOrg looks for any missing archives for the passed in org, creating and uploading them as necessary, returning the created archives
-func ArchiveOrg(ctx context.Context, now time.Time, config *Config, db *sqlx.DB, s3Client s3iface.S3API, org Org, archiveType ArchiveType) ([]*Archive, []*Archive, error) {
-	created, err := CreateOrgArchives(ctx, now, config, db, s3Client, org, archiveType)
+func ArchiveOrg(ctx context.Context, now time.Time, config *Config, db *sqlx.DB, s3Client s3iface.S3API, org Org, archiveType ArchiveType, opts *Options) ([]*Archive, []*Archive, error) {
+	created, err := CreateOrgArchives(ctx, now, config, db, s3Client, org, archiveType, opts)
 	if err != nil {
 		return nil, nil, errors.Wrapf(err, "error creating archives")
 	}
 
-	monthlies, err := RollupOrgArchives(ctx, now, config, db, s3Client, org, archiveType)
-	if err != nil {
-		return nil, nil, errors.Wrapf(err, "error rolling up archives")
+	// if we're shutting down or out of time, leave rollups, deletions and purges for next time
+	if Draining(ctx) {
+		return created, nil, nil
 	}
 
-	for _, m := range monthlies {
-		created = append(created, m)
+	if config.buildsPeriod(MonthPeriod) {
+		monthlies, err := RollupOrgArchives(ctx, now, config, db, s3Client, org, archiveType, opts)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "error rolling up archives")
+		}
+
+		for _, m := range monthlies {
+			created = append(created, m)
+		}
 	}
 
 	// finally delete any archives not yet actually archived
 	deleted := make([]*Archive, 0, 1)
-	if config.Delete {
-		deleted, err = DeleteArchivedOrgRecords(ctx, now, config, db, s3Client, org, archiveType)
+	if config.DeleteDryRun {
+		_, err = ReportArchivedOrgDeletions(ctx, now, config, db, org, archiveType)
+		if err != nil {
+			return created, deleted, errors.Wrapf(err, "error reporting archived records to delete")
+		}
+	} else if RetentionRuleFor(config, org, archiveType).Delete {
+		// a mis-set retention rule could delete a lot, so above a limit we need to be told that's really what we want
+		if config.ConfirmAbove > 0 && !config.Yes {
+			count, err := countPendingDeletion(ctx, now, config, db, org, archiveType)
+			if err == nil {
+				err = confirmRemoval(config, org, archiveType, "deleted", count)
+			}
+			if err != nil {
+				return created, deleted, errors.Wrapf(err, "error confirming deletion")
+			}
+		}
+
+		deleted, err = DeleteArchivedOrgRecords(ctx, now, config, db, s3Client, org, archiveType, opts)
 		if err != nil {
 			return created, deleted, errors.Wrapf(err, "error deleting archived records")
 		}
 	}
 
+	// permanently remove any deleted records whose quarantine has expired, if quarantine has been turned off we leave
+	// them be rather than treat them all as expired, and a dry run leaves them be too
+	if config.DeleteQuarantineDays > 0 && !config.DeleteDryRun {
+		_, err = ExpireQuarantinedRecords(ctx, now, config, db, org, archiveType)
+		if err != nil {
+			return created, deleted, errors.Wrapf(err, "error expiring quarantined records")
+		}
+	}
+
+	// purge any archives which have outlived our storage retention, or on a dry run only report which we would
+	if config.PurgeDailiesOnRollup || config.PurgeDailiesAfter > 0 || config.PurgeMonthliesAfter > 0 {
+		if config.DeleteDryRun {
+			_, err = ReportOrgPurges(ctx, now, config, db, org, archiveType)
+			if err != nil {
+				return created, deleted, errors.Wrapf(err, "error reporting archives to purge")
+			}
+			return created, deleted, nil
+		}
+
+		if config.ConfirmAbove > 0 && !config.Yes {
+			count, err := countPendingPurge(ctx, now, config, db, org, archiveType)
+			if err == nil {
+				err = confirmRemoval(config, org, archiveType, "purged", count)
+			}
+			if err != nil {
+				return created, deleted, errors.Wrapf(err, "error confirming purge")
+			}
+		}
+
+		_, err = PurgeOrgArchives(ctx, now, config, db, s3Client, org, archiveType)
+		if err != nil {
+			return created, deleted, errors.Wrapf(err, "error purging archives")
+		}
+	}
+
 	return created, deleted, nil
 }
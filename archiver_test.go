@@ -24,6 +24,10 @@ func setup(t *testing.T) *sqlx.DB {
 
 	_, err = db.Exec(string(testDB))
 	assert.NoError(t, err)
+
+	_, err = MigrateSchema(context.Background(), db)
+	assert.NoError(t, err)
+
 	logrus.SetLevel(logrus.DebugLevel)
 
 	return db
@@ -281,6 +285,13 @@ func TestWriteArchiveToDB(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 30, len(tasks))
 	assert.Equal(t, time.Date(2017, 8, 12, 0, 0, 0, 0, time.UTC), tasks[0].StartDate)
+
+	// writing the same period again, ie: from another archiver instance, is detected
+	duplicate := &Archive{Org: orgs[2], ArchiveType: MessageType, StartDate: task.StartDate, Period: task.Period}
+	err = WriteArchiveToDB(ctx, db, duplicate)
+	assert.Equal(t, ErrArchiveExists, err)
+	assert.Equal(t, 0, duplicate.ID)
+	assertCount(t, db, 1, `SELECT count(*) FROM archives_archive WHERE org_id = $1 AND start_date = $2`, orgs[2].ID, task.StartDate)
 }
 
 const getMsgCount = `
@@ -322,7 +333,7 @@ func TestArchiveOrgMessages(t *testing.T) {
 
 		assertCount(t, db, 4, `SELECT count(*) from msgs_broadcast WHERE org_id = $1`, 2)
 
-		created, deleted, err := ArchiveOrg(ctx, now, config, db, s3Client, orgs[1], MessageType)
+		created, deleted, err := ArchiveOrg(ctx, now, config, db, s3Client, orgs[1], MessageType, nil)
 		assert.NoError(t, err)
 
 		assert.Equal(t, 63, len(created))
@@ -461,7 +472,7 @@ func TestArchiveOrgRuns(t *testing.T) {
 		s3Client, err := NewS3Client(config)
 		assert.NoError(t, err)
 
-		created, deleted, err := ArchiveOrg(ctx, now, config, db, s3Client, orgs[2], RunType)
+		created, deleted, err := ArchiveOrg(ctx, now, config, db, s3Client, orgs[2], RunType, nil)
 		assert.NoError(t, err)
 
 		assert.Equal(t, 12, len(created))
@@ -531,3 +542,222 @@ func TestArchiveOrgRuns(t *testing.T) {
 		assert.Equal(t, 1, count)
 	}
 }
+
+func TestCreateOrgArchivesConcurrently(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	config.UploadToS3 = false
+	config.ArchiveWorkers = 4
+
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	created, err := CreateOrgArchives(ctx, now, config, db, nil, orgs[2], RunType, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 12, len(created))
+
+	// archives are built concurrently but still written to the database in order
+	for i, archive := range created {
+		assert.NotEqual(t, 0, archive.ID)
+		assert.Nil(t, archive.BuildError)
+		if i > 0 {
+			assert.True(t, archive.ID > created[i-1].ID)
+		}
+	}
+	assert.Equal(t, 1, created[0].RecordCount)
+	assert.Equal(t, "074de71dfb619c78dbac5b6709dd66c2", created[0].Hash)
+	assert.Equal(t, 1, created[11].RecordCount)
+	assert.Equal(t, "bf08041cef314492fee2910357ec4189", created[11].Hash)
+}
+
+func TestCreateOrgArchivesOfPeriods(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	config.UploadToS3 = false
+	config.Periods = "month"
+
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	// only building monthlies, we only get the monthlies of the backfill
+	created, err := CreateOrgArchives(ctx, now, config, db, nil, orgs[2], RunType, nil)
+	assert.NoError(t, err)
+	assert.True(t, len(created) > 0)
+	for _, archive := range created {
+		assert.Equal(t, MonthPeriod, archive.Period)
+	}
+
+	// and only building dailies, we get the rest as dailies
+	config.Periods = "day"
+	created, err = CreateOrgArchives(ctx, now, config, db, nil, orgs[2], RunType, nil)
+	assert.NoError(t, err)
+	assert.True(t, len(created) > 0)
+	for _, archive := range created {
+		assert.Equal(t, DayPeriod, archive.Period)
+	}
+}
+
+func TestCreateNewlyEligibleArchives(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	config.UploadToS3 = false
+
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	// nothing becomes eligible during the same day
+	created, err := CreateNewlyEligibleArchives(ctx, now.Add(-time.Hour), now, config, db, nil, orgs[1], MessageType, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(created))
+
+	// two days later the 9th and 10th of October are eligible, nothing older is looked for
+	created, err = CreateNewlyEligibleArchives(ctx, now.AddDate(0, 0, -2), now, config, db, nil, orgs[1], MessageType, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(created))
+	assert.Equal(t, time.Date(2017, 10, 9, 0, 0, 0, 0, time.UTC), created[0].StartDate.In(time.UTC))
+	assert.Equal(t, time.Date(2017, 10, 10, 0, 0, 0, 0, time.UTC), created[1].StartDate.In(time.UTC))
+	for _, archive := range created {
+		assert.NotEqual(t, 0, archive.ID)
+		assert.Equal(t, DayPeriod, archive.Period)
+	}
+
+	// and once built they aren't built again
+	created, err = CreateNewlyEligibleArchives(ctx, now.AddDate(0, 0, -2), now, config, db, nil, orgs[1], MessageType, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(created))
+
+	// org 1 was created after the newest eligible day so has nothing to build
+	created, err = CreateNewlyEligibleArchives(ctx, now.AddDate(0, 0, -30), now, config, db, nil, orgs[0], MessageType, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(created))
+}
+
+func TestReportArchivedOrgDeletions(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	reports, err := ReportArchivedOrgDeletions(ctx, now, config, db, orgs[1], MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(reports))
+	assert.Equal(t, 4, reports[0].Archive.ID)
+	assert.Equal(t, 1, reports[0].Count)
+	assert.Equal(t, 6, len(reports[0].Statements))
+
+	// nothing should have actually been deleted
+	assertCount(t, db, 1, `SELECT count(*) FROM msgs_msg WHERE id = 6`)
+	assertCount(t, db, 1, `SELECT count(*) FROM archives_archive WHERE id = 4 AND needs_deletion = TRUE`)
+
+	reports, err = ReportArchivedOrgDeletions(ctx, now, config, db, orgs[1], RunType)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(reports))
+
+	// add a run archive for the day with our two org 2 runs
+	db.MustExec(`INSERT INTO archives_archive(archive_type, created_on, start_date, period, record_count, size, hash, url, needs_deletion, build_time, org_id) 
+	VALUES('run', '2017-08-13', '2017-08-12', 'D', 2, 0, '', '', TRUE, 0, 2)`)
+
+	reports, err = ReportArchivedOrgDeletions(ctx, now, config, db, orgs[1], RunType)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(reports))
+	assert.Equal(t, 2, reports[0].Count)
+	assert.Equal(t, 0, reports[0].Skipped)
+
+	// runs which are active or in a waiting session are skipped
+	db.MustExec(`INSERT INTO flows_flowsession(id, status, org_id) VALUES(1, 'W', 2)`)
+	db.MustExec(`UPDATE flows_flowrun SET session_id = 1 WHERE id = 2`)
+
+	reports, err = ReportArchivedOrgDeletions(ctx, now, config, db, orgs[1], RunType)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reports[0].Count)
+	assert.Equal(t, 1, reports[0].Skipped)
+
+	db.MustExec(`UPDATE flows_flowrun SET is_active = TRUE WHERE id = 1`)
+
+	reports, err = ReportArchivedOrgDeletions(ctx, now, config, db, orgs[1], RunType)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, reports[0].Count)
+	assert.Equal(t, 2, reports[0].Skipped)
+}
+
+func TestSkippedRuns(t *testing.T) {
+	skipped := &skippedRuns{}
+	assert.False(t, skipped.add(1, false, false))
+	assert.True(t, skipped.add(2, true, false))
+	assert.True(t, skipped.add(3, false, true))
+	assert.True(t, skipped.add(4, true, true))
+
+	assert.Equal(t, []int64{2, 4}, skipped.active)
+	assert.Equal(t, []int64{3}, skipped.waiting)
+	assert.Equal(t, 3, skipped.count())
+	assert.Equal(t, []int64{2, 4}, skipped.sample(2))
+	assert.Equal(t, []int64{2, 4, 3}, skipped.sample(10))
+}
+
+func TestDeletionCheckpoint(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+
+	archives, err := GetArchivesNeedingDeletion(ctx, db, orgs[1], MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(archives))
+	assert.Nil(t, archives[0].DeletionLastID)
+	assert.Equal(t, int64(0), deletionCheckpoint(archives[0]))
+
+	// our october daily has a single message
+	countRemaining := `SELECT count(*) FROM (` + selectOrgMessagesInRange + `) m`
+	assertCount(t, db, 1, countRemaining, archives[0].OrgID, archives[0].StartDate, archives[0].endDate(), 0)
+
+	// pretend we were interrupted after deleting it
+	db.MustExec(setArchiveDeletionLastID, archives[0].ID, 6)
+
+	archives, err = GetArchivesNeedingDeletion(ctx, db, orgs[1], MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), deletionCheckpoint(archives[0]))
+
+	// so resuming doesn't select it again
+	assertCount(t, db, 0, countRemaining, archives[0].OrgID, archives[0].StartDate, archives[0].endDate(), deletionCheckpoint(archives[0]))
+
+	// completing deletion clears our checkpoint
+	db.MustExec(setArchiveDeleted, archives[0].ID, time.Now())
+	archive, err := GetArchive(ctx, db, archives[0].ID)
+	assert.NoError(t, err)
+	assert.Nil(t, archive.DeletionLastID)
+}
+
+func TestCheckRecordCount(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+
+	// 2017-08-12 has three non-deleted messages and two runs for org 2
+	msgs := &Archive{Org: orgs[1], ArchiveType: MessageType, StartDate: time.Date(2017, 8, 12, 0, 0, 0, 0, time.UTC), Period: DayPeriod}
+	assert.NoError(t, checkRecordCount(ctx, db, msgs, 3))
+	assert.EqualError(t, checkRecordCount(ctx, db, msgs, 2), "record count mismatch, wrote 2 records but database has 3")
+
+	runs := &Archive{Org: orgs[1], ArchiveType: RunType, StartDate: time.Date(2017, 8, 12, 0, 0, 0, 0, time.UTC), Period: DayPeriod}
+	assert.NoError(t, checkRecordCount(ctx, db, runs, 2))
+
+	// a message arriving in the period after we've written it is caught
+	db.MustExec(`UPDATE msgs_msg SET created_on = '2017-08-12 10:00:00+00' WHERE id = 4`)
+	assert.EqualError(t, checkRecordCount(ctx, db, msgs, 3), "record count mismatch, wrote 3 records but database has 4")
+}
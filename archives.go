@@ -0,0 +1,107 @@
+package archiver
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// DateRange is a range of dates, inclusive of Start and exclusive of End
+type DateRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Contains returns whether the passed in time falls within this range
+func (r DateRange) Contains(t time.Time) bool {
+	return !t.Before(r.Start) && t.Before(r.End)
+}
+
+// Days returns the number of days in this range
+func (r DateRange) Days() int {
+	return int(r.End.Sub(r.Start).Hours() / 24)
+}
+
+// EndDate returns the exclusive end of the period covered by this archive
+func (a *Archive) EndDate() time.Time {
+	return a.endDate()
+}
+
+const selectArchiveFields = `
+SELECT id, org_id, archive_type, created_on, start_date::timestamp with time zone as start_date, period, record_count, size, hash, url,
+	build_time, needs_deletion, deleted_on as deleted_date, rollup_id, purged_on, deletion_last_id,
+	chain_hash, redaction, coalesce(state, '') as state, state_error
+FROM archives_archive
+`
+
+const lookupArchive = selectArchiveFields + `WHERE id = $1`
+
+// GetArchive returns the archive with the passed in id, or nil if no such archive exists
+func GetArchive(ctx context.Context, db *sqlx.DB, archiveID int) (*Archive, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	archive := &Archive{}
+	err := db.GetContext(ctx, archive, lookupArchive, archiveID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error fetching archive: %d", archiveID)
+	}
+	return archive, nil
+}
+
+const lookupArchivesForDateRange = selectArchiveFields + `
+WHERE org_id = $1 AND archive_type = $2 AND start_date < $4 AND
+	start_date + CASE WHEN period = 'M' THEN '1 month'::interval ELSE '1 day'::interval END > $3
+ORDER BY start_date asc, period desc
+`
+
+// ListArchives returns all the archives, daily and monthly, for the passed in org and type which overlap the passed
+// in date range. Note that this includes daily archives which have been rolled up into monthlies.
+func ListArchives(ctx context.Context, db *sqlx.DB, org Org, archiveType ArchiveType, dates DateRange) ([]*Archive, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	archives := make([]*Archive, 0, 1)
+	err := db.SelectContext(ctx, &archives, lookupArchivesForDateRange, org.ID, archiveType, dates.Start, dates.End)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing archives for org: %d and type: %s", org.ID, archiveType)
+	}
+
+	for _, a := range archives {
+		a.Org = org
+	}
+	return archives, nil
+}
+
+// CoverageGaps returns the ranges of days within the passed in date range which aren't covered by any daily or monthly
+// archive for the passed in org and type
+func CoverageGaps(ctx context.Context, db *sqlx.DB, org Org, archiveType ArchiveType, dates DateRange) ([]DateRange, error) {
+	if !dates.Start.Before(dates.End) {
+		return nil, nil
+	}
+
+	// our missing archives query is inclusive of its end date
+	missing, err := GetMissingDailyArchivesForDateRange(ctx, db, dates.Start, dates.End.AddDate(0, 0, -1), org, archiveType)
+	if err != nil {
+		return nil, err
+	}
+
+	// merge consecutive missing days into ranges
+	gaps := make([]DateRange, 0, 1)
+	for _, m := range missing {
+		day := m.StartDate.In(time.UTC)
+		if len(gaps) > 0 && gaps[len(gaps)-1].End.Equal(day) {
+			gaps[len(gaps)-1].End = day.AddDate(0, 0, 1)
+		} else {
+			gaps = append(gaps, DateRange{Start: day, End: day.AddDate(0, 0, 1)})
+		}
+	}
+
+	return gaps, nil
+}
@@ -0,0 +1,50 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchivesSDK(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+	config := NewConfig()
+
+	archive, err := GetArchive(ctx, db, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, archive.OrgID)
+	assert.Equal(t, MonthPeriod, archive.Period)
+	assert.Equal(t, time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC), archive.StartDate)
+	assert.Equal(t, time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC), archive.EndDate())
+	assert.Nil(t, archive.DeletedOn)
+
+	archive, err = GetArchive(ctx, db, 1000)
+	assert.NoError(t, err)
+	assert.Nil(t, archive)
+
+	org, err := GetOrg(ctx, db, config, 3)
+	assert.NoError(t, err)
+
+	dates := DateRange{Start: time.Date(2017, 8, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)}
+	assert.Equal(t, 61, dates.Days())
+	assert.True(t, dates.Contains(time.Date(2017, 8, 1, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, dates.Contains(time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)))
+
+	// all archives are listed, even dailies covered by monthlies
+	archives, err := ListArchives(ctx, db, org, MessageType, dates)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(archives))
+	assert.Equal(t, 1, archives[0].ID)
+	assert.Equal(t, 3, archives[1].ID)
+	assert.Equal(t, 2, archives[2].ID)
+
+	gaps, err := CoverageGaps(ctx, db, org, MessageType, dates)
+	assert.NoError(t, err)
+	assert.Equal(t, []DateRange{
+		{Start: time.Date(2017, 8, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2017, 8, 10, 0, 0, 0, 0, time.UTC)},
+		{Start: time.Date(2017, 8, 11, 0, 0, 0, 0, time.UTC), End: time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC)},
+	}, gaps)
+}
@@ -0,0 +1,257 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/glue"
+	"github.com/aws/aws-sdk-go/service/glue/glueiface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Archives can be queried with Athena through a table for each archive type, partitioned by org and month. As the
+// archives of an org are all in the same folder, each partition is a symlink manifest listing the archive files which
+// cover its month, the monthly archive once there is one or otherwise the dailies, so records are never counted twice.
+// Manifests are written under a Hive style path so partitions can also be found with MSCK REPAIR TABLE.
+
+// athenaTables are the names of our Athena tables for each archive type
+var athenaTables = map[ArchiveType]string{MessageType: "messages", RunType: "runs"}
+
+// the columns of the records of each archive type, timestamps are strings as they aren't in a format Hive can read,
+// from_iso8601_timestamp converts them, and the events of runs are left out as they don't have a fixed shape
+var athenaColumns = map[ArchiveType][]string{
+	MessageType: {
+		"`id` bigint",
+		"`broadcast` bigint",
+		"`contact` " + athenaContact,
+		"`urn` string",
+		"`channel` struct<uuid:string,name:string>",
+		"`direction` string",
+		"`type` string",
+		"`status` string",
+		"`visibility` string",
+		"`text` string",
+		"`attachments` array<struct<content_type:string,url:string>>",
+		"`labels` array<struct<uuid:string,name:string>>",
+		"`created_on` string",
+		"`sent_on` string",
+		"`modified_on` string",
+	},
+	RunType: {
+		"`id` bigint",
+		"`uuid` string",
+		"`flow` struct<uuid:string,name:string>",
+		"`contact` " + athenaContact,
+		"`responded` boolean",
+		"`path` array<struct<node:string,time:string>>",
+		"`values` map<string,struct<name:string,value:string,input:string,category:string,node:string,time:string>>",
+		"`created_on` string",
+		"`modified_on` string",
+		"`exited_on` string",
+		"`exit_type` string",
+		"`submitted_by` string",
+	},
+}
+
+const athenaContact = "struct<uuid:string,name:string,language:string,groups:array<struct<uuid:string,name:string>>,created_on:string>"
+
+// athenaTableDDL is the statement which creates the Athena table of an archive type
+const athenaTableDDL = `CREATE EXTERNAL TABLE IF NOT EXISTS %s (
+  %s
+)
+PARTITIONED BY (org_id int, month string)
+ROW FORMAT SERDE 'org.openx.data.jsonserde.JsonSerDe'
+STORED AS INPUTFORMAT 'org.apache.hadoop.hive.ql.io.SymlinkTextInputFormat'
+OUTPUTFORMAT 'org.apache.hadoop.hive.ql.io.HiveIgnoreKeyTextOutputFormat'
+LOCATION '%s'`
+
+// AthenaTableDDL returns the CREATE EXTERNAL TABLE statement of the Athena table of the passed in archive type, in the
+// Glue database we are configured with
+func AthenaTableDDL(config *Config, archiveType ArchiveType) (string, error) {
+	table, found := athenaTables[archiveType]
+	if !found {
+		return "", errors.Errorf("unknown archive type: %s", archiveType)
+	}
+
+	name := table
+	if config.AthenaDatabase != "" {
+		name = fmt.Sprintf("%s.%s", config.AthenaDatabase, table)
+	}
+	return fmt.Sprintf(athenaTableDDL, name, strings.Join(athenaColumns[archiveType], ",\n  "), athenaTableLocation(config, archiveType)), nil
+}
+
+// athenaTableLocation returns the S3 location of the Athena table of the passed in archive type
+func athenaTableLocation(config *Config, archiveType ArchiveType) string {
+	return fmt.Sprintf("s3://%s/%s/", config.S3Bucket, strings.Trim(athenaKey(config, archiveType, ""), "/"))
+}
+
+// athenaKey returns the key of the passed in path within the location of the Athena table of the passed in archive type
+func athenaKey(config *Config, archiveType ArchiveType, path string) string {
+	key := "/" + strings.Trim(config.AthenaPrefix, "/") + "/" + athenaTables[archiveType] + path
+	if config.S3Prefix != "" {
+		key = "/" + strings.Trim(config.S3Prefix, "/") + key
+	}
+	return key
+}
+
+// athenaPartitionPath returns the path of the partition of the passed in org and month within its table
+func athenaPartitionPath(orgID int, month time.Time) string {
+	return fmt.Sprintf("/org_id=%d/month=%s/", orgID, month.Format("2006-01"))
+}
+
+// athenaManifest returns the contents of the symlink manifest of the month starting at the passed in time, listing the
+// files of the passed in archives which cover it, the monthly archive if there is one, otherwise the dailies. Archives
+// without a file, or whose file has been purged, are left out.
+func athenaManifest(archives []*Archive, month time.Time) (string, error) {
+	monthly := make([]string, 0, 1)
+	dailies := make([]string, 0, 31)
+	for _, a := range archives {
+		if a.URL == "" || a.PurgedOn != nil || a.StartDate.Year() != month.Year() || a.StartDate.Month() != month.Month() {
+			continue
+		}
+		location, err := s3Location(a.URL)
+		if err != nil {
+			return "", err
+		}
+		if a.Period == MonthPeriod {
+			monthly = append(monthly, location)
+		} else {
+			dailies = append(dailies, location)
+		}
+	}
+
+	files := dailies
+	if len(monthly) > 0 {
+		files = monthly
+	}
+	if len(files) == 0 {
+		return "", nil
+	}
+	return strings.Join(files, "\n") + "\n", nil
+}
+
+// s3Location returns the s3:// location of the object at the passed in URL
+func s3Location(fileURL string) (string, error) {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "error parsing S3 URL: %s", fileURL)
+	}
+	bucket := strings.Split(u.Host, ".")[0]
+	return fmt.Sprintf("s3://%s/%s", bucket, strings.TrimLeft(u.Path, "/")), nil
+}
+
+// NewGlueClient creates a new Glue client from the passed in config, in the same region and with the same credentials
+// as our S3 client
+func NewGlueClient(config *Config) (glueiface.GlueAPI, error) {
+	glueSession, err := session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials(config.AWSAccessKeyID, config.AWSSecretAccessKey, ""),
+		Region:      aws.String(config.S3Region),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return glue.New(glueSession), nil
+}
+
+// RegisterAthenaPartitions writes the symlink manifests of the months covered by the passed in archives of an org and
+// type, which have just been written, and registers a partition for each with Glue if it doesn't already have one.
+// Manifests are rebuilt from all the archives of each month, so a new monthly replaces the dailies it rolled up.
+func RegisterAthenaPartitions(ctx context.Context, db *sqlx.DB, s3Client s3iface.S3API, glueClient glueiface.GlueAPI, config *Config, org Org, archiveType ArchiveType, archives []*Archive) error {
+	months := make([]time.Time, 0, 1)
+	seen := make(map[time.Time]bool)
+	for _, a := range archives {
+		month := time.Date(a.StartDate.Year(), a.StartDate.Month(), 1, 0, 0, 0, 0, time.UTC)
+		if !seen[month] {
+			seen[month] = true
+			months = append(months, month)
+		}
+	}
+	if len(months) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+
+	partitions := make([]time.Time, 0, len(months))
+	for _, month := range months {
+		covering, err := ListArchives(ctx, db, org, archiveType, DateRange{Start: month, End: month.AddDate(0, 1, 0)})
+		if err != nil {
+			return err
+		}
+		manifest, err := athenaManifest(covering, month)
+		if err != nil {
+			return err
+		}
+
+		// months without any records don't need a partition
+		if manifest == "" {
+			continue
+		}
+
+		key := athenaKey(config, archiveType, athenaPartitionPath(org.ID, month)+"symlink.txt")
+		_, err = UploadStreamToS3(ctx, s3Client, config.S3Bucket, key, "text/plain", bytes.NewReader([]byte(manifest)))
+		if err != nil {
+			return errors.Wrapf(err, "error writing athena manifest for org: %d", org.ID)
+		}
+		partitions = append(partitions, month)
+	}
+
+	if glueClient == nil || len(partitions) == 0 {
+		return nil
+	}
+	return addGluePartitions(ctx, glueClient, config, org, archiveType, partitions)
+}
+
+// addGluePartitions adds partitions for the passed in months of an org to the Glue table of the passed in type, using
+// the storage of the table for each with its own location. Partitions which already exist are left as they are.
+func addGluePartitions(ctx context.Context, glueClient glueiface.GlueAPI, config *Config, org Org, archiveType ArchiveType, months []time.Time) error {
+	table, err := glueClient.GetTableWithContext(ctx, &glue.GetTableInput{
+		DatabaseName: aws.String(config.AthenaDatabase),
+		Name:         aws.String(athenaTables[archiveType]),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error looking up glue table: %s.%s", config.AthenaDatabase, athenaTables[archiveType])
+	}
+
+	inputs := make([]*glue.PartitionInput, len(months))
+	for i, month := range months {
+		storage := *table.Table.StorageDescriptor
+		storage.Location = aws.String(athenaTableLocation(config, archiveType) + strings.TrimLeft(athenaPartitionPath(org.ID, month), "/"))
+		inputs[i] = &glue.PartitionInput{
+			Values:            []*string{aws.String(fmt.Sprint(org.ID)), aws.String(month.Format("2006-01"))},
+			StorageDescriptor: &storage,
+		}
+	}
+
+	output, err := glueClient.BatchCreatePartitionWithContext(ctx, &glue.BatchCreatePartitionInput{
+		DatabaseName:       aws.String(config.AthenaDatabase),
+		TableName:          aws.String(athenaTables[archiveType]),
+		PartitionInputList: inputs,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error adding glue partitions for org: %d", org.ID)
+	}
+
+	added := len(inputs)
+	for _, e := range output.Errors {
+		if e.ErrorDetail != nil && aws.StringValue(e.ErrorDetail.ErrorCode) == glue.ErrCodeAlreadyExistsException {
+			added--
+			continue
+		}
+		return errors.Errorf("error adding glue partition %s for org: %d: %s", aws.StringValueSlice(e.PartitionValues), org.ID, e.ErrorDetail)
+	}
+
+	logrus.WithField("org_id", org.ID).WithField("archive_type", archiveType).WithField("added", added).Debug("registered athena partitions")
+	return nil
+}
@@ -0,0 +1,98 @@
+package archiver
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/glue"
+	"github.com/aws/aws-sdk-go/service/glue/glueiface"
+	"github.com/stretchr/testify/assert"
+)
+
+type testGlue struct {
+	glueiface.GlueAPI
+	added []*glue.PartitionInput
+}
+
+func (g *testGlue) GetTableWithContext(ctx aws.Context, input *glue.GetTableInput, opts ...request.Option) (*glue.GetTableOutput, error) {
+	return &glue.GetTableOutput{Table: &glue.Table{
+		Name:              input.Name,
+		StorageDescriptor: &glue.StorageDescriptor{Location: aws.String("s3://archives/athena/messages/")},
+	}}, nil
+}
+
+func (g *testGlue) BatchCreatePartitionWithContext(ctx aws.Context, input *glue.BatchCreatePartitionInput, opts ...request.Option) (*glue.BatchCreatePartitionOutput, error) {
+	output := &glue.BatchCreatePartitionOutput{}
+	for _, p := range input.PartitionInputList {
+		if aws.StringValue(p.Values[1]) == "2017-08" {
+			output.Errors = append(output.Errors, &glue.PartitionError{
+				PartitionValues: p.Values,
+				ErrorDetail:     &glue.ErrorDetail{ErrorCode: aws.String(glue.ErrCodeAlreadyExistsException)},
+			})
+			continue
+		}
+		g.added = append(g.added, p)
+	}
+	return output, nil
+}
+
+func TestAthena(t *testing.T) {
+	config := NewConfig()
+	config.S3Bucket = "archives"
+	config.S3Prefix = "rapidpro/"
+	config.AthenaDatabase = "rapidpro"
+
+	ddl, err := AthenaTableDDL(config, MessageType)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(ddl, "CREATE EXTERNAL TABLE IF NOT EXISTS rapidpro.messages (\n  `id` bigint,\n"))
+	assert.Contains(t, ddl, "PARTITIONED BY (org_id int, month string)")
+	assert.True(t, strings.HasSuffix(ddl, "LOCATION 's3://archives/rapidpro/athena/messages/'"))
+
+	ddl, err = AthenaTableDDL(config, RunType)
+	assert.NoError(t, err)
+	assert.Contains(t, ddl, "`values` map<string,struct<")
+
+	_, err = AthenaTableDDL(config, ArchiveType("foo"))
+	assert.EqualError(t, err, "unknown archive type: foo")
+
+	assert.Equal(t, "/rapidpro/athena/runs/org_id=5/month=2017-08/symlink.txt", athenaKey(config, RunType, athenaPartitionPath(5, time.Date(2017, 8, 1, 0, 0, 0, 0, time.UTC))+"symlink.txt"))
+
+	// manifests list the monthly archive of a month if there is one, otherwise its dailies
+	aug := time.Date(2017, 8, 1, 0, 0, 0, 0, time.UTC)
+	purgedOn := time.Now()
+	dailies := []*Archive{
+		{Period: DayPeriod, StartDate: aug, URL: "https://archives.s3.amazonaws.com/5/message_D20170801_aaa.jsonl.gz"},
+		{Period: DayPeriod, StartDate: aug.AddDate(0, 0, 1), URL: ""},
+		{Period: DayPeriod, StartDate: aug.AddDate(0, 0, 2), URL: "https://archives.s3.amazonaws.com/5/message_D20170803_bbb.jsonl.gz"},
+		{Period: DayPeriod, StartDate: aug.AddDate(0, 0, 3), URL: "https://archives.s3.amazonaws.com/5/message_D20170804_ccc.jsonl.gz", PurgedOn: &purgedOn},
+		{Period: DayPeriod, StartDate: aug.AddDate(0, 1, 0), URL: "https://archives.s3.amazonaws.com/5/message_D20170901_ddd.jsonl.gz"},
+	}
+	manifest, err := athenaManifest(dailies, aug)
+	assert.NoError(t, err)
+	assert.Equal(t, "s3://archives/5/message_D20170801_aaa.jsonl.gz\ns3://archives/5/message_D20170803_bbb.jsonl.gz\n", manifest)
+
+	monthly := &Archive{Period: MonthPeriod, StartDate: aug, URL: "https://archives.s3.amazonaws.com/5/message_M201708_eee.jsonl.gz"}
+	manifest, err = athenaManifest(append(dailies, monthly), aug)
+	assert.NoError(t, err)
+	assert.Equal(t, "s3://archives/5/message_M201708_eee.jsonl.gz\n", manifest)
+
+	manifest, err = athenaManifest(dailies, aug.AddDate(0, 2, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "", manifest)
+
+	// partitions which already exist are left alone
+	glueClient := &testGlue{}
+	err = addGluePartitions(context.Background(), glueClient, config, Org{ID: 5}, MessageType, []time.Time{aug, aug.AddDate(0, 1, 0)})
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(glueClient.added)) {
+		assert.Equal(t, []string{"5", "2017-09"}, aws.StringValueSlice(glueClient.added[0].Values))
+		assert.Equal(t, "s3://archives/rapidpro/athena/messages/org_id=5/month=2017-09/", *glueClient.added[0].StorageDescriptor.Location)
+	}
+
+	config.AthenaPrefix = ""
+	assert.EqualError(t, config.Validate()[0], "cannot register athena partitions without uploading to s3 and an athena prefix")
+}
@@ -0,0 +1,147 @@
+package archiver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// attachmentArchiver copies the attachments of the messages of an org from the media bucket into our bucket as they
+// are archived, so that archives don't point at attachments which are later deleted from the media bucket
+type attachmentArchiver struct {
+	s3Client    s3iface.S3API
+	mediaBucket string
+	mediaURL    string
+	bucket      string
+	prefix      string
+
+	// the URLs of the attachments we've already copied, to their new URLs
+	copied map[string]string
+}
+
+// newAttachmentArchiver returns an attachment archiver which copies attachments with the passed in config and client
+func newAttachmentArchiver(config *Config, s3Client s3iface.S3API) *attachmentArchiver {
+	mediaURL := config.MediaURL
+	if mediaURL == "" {
+		mediaURL = fmt.Sprintf(s3BucketURL, config.MediaBucket, "/")
+	}
+	if !strings.HasSuffix(mediaURL, "/") {
+		mediaURL += "/"
+	}
+
+	return &attachmentArchiver{
+		s3Client:    s3Client,
+		mediaBucket: config.MediaBucket,
+		mediaURL:    mediaURL,
+		bucket:      config.S3Bucket,
+		prefix:      strings.Trim(config.AttachmentsPrefix, "/"),
+		copied:      make(map[string]string),
+	}
+}
+
+// archiveURL copies the attachment at the passed in URL into our bucket for the passed in org, returning the URL of
+// the copy. Attachments which aren't in the media bucket, or which no longer exist there, are left where they are and
+// their URL returned as is.
+func (a *attachmentArchiver) archiveURL(ctx context.Context, org Org, attachmentURL string) (string, error) {
+	if !strings.HasPrefix(attachmentURL, a.mediaURL) {
+		return attachmentURL, nil
+	}
+	if copied, found := a.copied[attachmentURL]; found {
+		return copied, nil
+	}
+
+	// the URL of the copy is escaped the same as the original
+	escaped := strings.TrimPrefix(attachmentURL, a.mediaURL)
+	key, err := url.PathUnescape(escaped)
+	if err != nil || key == "" {
+		return attachmentURL, nil
+	}
+	path := org.s3Path(fmt.Sprintf("/%d/%s/%s", org.ID, a.prefix, key))
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+
+	_, err = a.s3Client.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(a.bucket),
+		Key:        aws.String(path),
+		CopySource: aws.String(copySource(a.mediaBucket, key)),
+		ACL:        aws.String(s3.BucketCannedACLPrivate),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			logrus.WithField("org_id", org.ID).WithField("url", attachmentURL).Warn("attachment no longer exists, leaving its url as is")
+			a.copied[attachmentURL] = attachmentURL
+			return attachmentURL, nil
+		}
+		return "", errors.Wrapf(err, "error copying attachment: %s", attachmentURL)
+	}
+
+	copied := fmt.Sprintf(s3BucketURL, a.bucket, org.s3Path(fmt.Sprintf("/%d/%s/%s", org.ID, a.prefix, escaped)))
+	a.copied[attachmentURL] = copied
+	return copied, nil
+}
+
+// archiveRecord copies the attachments of the passed in message record into our bucket, returning the record with
+// their URLs replaced by those of the copies. Only the attachments of the record are changed, the rest of it is
+// returned exactly as it was built.
+func (a *attachmentArchiver) archiveRecord(ctx context.Context, org Org, record string) (string, error) {
+	start := strings.Index(record, `"attachments":`)
+	if start < 0 || strings.HasPrefix(record[start:], `"attachments":[]`) {
+		return record, nil
+	}
+
+	msg := struct {
+		Attachments []struct {
+			URL string `json:"url"`
+		} `json:"attachments"`
+	}{}
+	err := json.Unmarshal([]byte(record), &msg)
+	if err != nil {
+		return "", errors.Wrapf(err, "error parsing attachments of message record")
+	}
+
+	attachments := record[start:]
+	for _, attachment := range msg.Attachments {
+		copied, err := a.archiveURL(ctx, org, attachment.URL)
+		if err != nil {
+			return "", err
+		}
+		if copied != attachment.URL {
+			attachments = strings.Replace(attachments, jsonbString(attachment.URL), jsonbString(copied), 1)
+		}
+	}
+	return record[:start] + attachments, nil
+}
+
+// copySource returns the URL encoded source of a copy of the object with the passed in key in the passed in bucket
+func copySource(bucket string, key string) string {
+	parts := strings.Split(bucket+"/"+key, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return strings.Join(parts, "/")
+}
+
+type attachmentsKey struct{}
+
+// withAttachmentArchiver returns a context which carries the passed in attachment archiver, which the export of
+// message archives uses to copy the attachments of their records
+func withAttachmentArchiver(ctx context.Context, archiver *attachmentArchiver) context.Context {
+	return context.WithValue(ctx, attachmentsKey{}, archiver)
+}
+
+// contextAttachmentArchiver returns the attachment archiver carried by the passed in context, nil if there isn't one
+func contextAttachmentArchiver(ctx context.Context) *attachmentArchiver {
+	archiver, _ := ctx.Value(attachmentsKey{}).(*attachmentArchiver)
+	return archiver
+}
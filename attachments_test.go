@@ -0,0 +1,125 @@
+package archiver
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+)
+
+// copyingS3 is an S3 client which records the objects copied with it, failing to copy any it is told are missing
+type copyingS3 struct {
+	s3iface.S3API
+	copies  []*s3.CopyObjectInput
+	missing map[string]bool
+}
+
+func (c *copyingS3) CopyObjectWithContext(ctx aws.Context, input *s3.CopyObjectInput, opts ...request.Option) (*s3.CopyObjectOutput, error) {
+	if c.missing[*input.CopySource] {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "not found", nil)
+	}
+	c.copies = append(c.copies, input)
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func TestArchiveAttachments(t *testing.T) {
+	ctx := context.Background()
+	client := &copyingS3{missing: map[string]bool{"media/gone.png": true}}
+
+	config := NewConfig()
+	config.ArchiveAttachments = true
+	config.MediaBucket = "media"
+	config.MediaURL = "https://foo.bar"
+	assert.Equal(t, 0, len(config.Validate()))
+
+	org := Org{ID: 2}
+	attachments := newAttachmentArchiver(config, client)
+
+	// records without attachments are left as they are
+	record := `{"id":1,"text":"\"attachments\":","attachments":[],"labels":[]}`
+	archived, err := attachments.archiveRecord(ctx, org, record)
+	assert.NoError(t, err)
+	assert.Equal(t, record, archived)
+	assert.Equal(t, 0, len(client.copies))
+
+	// attachments in the media bucket are copied to our bucket, others are left where they are
+	record = `{"id":3,"text":"see https://foo.bar/image 1.png","attachments":[{"url": "https://foo.bar/image%201.png", "content_type": "image/png"}, {"url": "https://elsewhere.com/image2.png", "content_type": "image/png"}, {"url": "https://foo.bar/gone.png", "content_type": "image/png"}],"labels":[]}`
+	archived, err = attachments.archiveRecord(ctx, org, record)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":3,"text":"see https://foo.bar/image 1.png","attachments":[{"url": "https://dl-archiver-test.s3.amazonaws.com/2/media/image%201.png", "content_type": "image/png"}, {"url": "https://elsewhere.com/image2.png", "content_type": "image/png"}, {"url": "https://foo.bar/gone.png", "content_type": "image/png"}],"labels":[]}`, archived)
+	if assert.Equal(t, 1, len(client.copies)) {
+		assert.Equal(t, "dl-archiver-test", *client.copies[0].Bucket)
+		assert.Equal(t, "/2/media/image 1.png", *client.copies[0].Key)
+		assert.Equal(t, "media/image%201.png", *client.copies[0].CopySource)
+	}
+
+	// attachments are only copied once
+	_, err = attachments.archiveRecord(ctx, org, record)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(client.copies))
+
+	// within the org's prefix if it has one
+	org.S3Prefix = "tenants/acme"
+	copied, err := newAttachmentArchiver(config, client).archiveURL(ctx, org, "https://foo.bar/attachments/2/a.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://dl-archiver-test.s3.amazonaws.com/tenants/acme/2/media/attachments/2/a.jpg", copied)
+
+	// we need somewhere to copy from and to
+	config.MediaBucket = ""
+	assert.Equal(t, 1, len(config.Validate()))
+}
+
+func TestCreateArchiveFileWithAttachments(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	config.ArchiveAttachments = true
+	config.MediaBucket = "media"
+	config.MediaURL = "https://foo.bar/"
+
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	for _, recordJSON := range []string{RecordJSONDB, RecordJSONGo} {
+		config.RecordJSON = recordJSON
+		client := &copyingS3{}
+
+		tasks, err := GetMissingDailyArchives(ctx, db, now, orgs[1], MessageType)
+		assert.NoError(t, err)
+		task := tasks[2]
+
+		err = createArchiveFile(withAttachmentArchiver(ctx, newAttachmentArchiver(config, client)), db, task, "/tmp", config, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, task.RecordCount)
+		assert.Equal(t, 2, len(client.copies))
+
+		// our records are the same except for the URLs of their attachments
+		f, err := os.Open(task.ArchiveFile)
+		assert.NoError(t, err)
+		gz, err := gzip.NewReader(f)
+		assert.NoError(t, err)
+
+		expected, err := os.Open("testdata/messages1.jsonl")
+		assert.NoError(t, err)
+
+		actual, wanted := bufio.NewScanner(gz), bufio.NewScanner(expected)
+		for actual.Scan() && wanted.Scan() {
+			assert.Equal(t, strings.Replace(wanted.Text(), "https://foo.bar/", "https://dl-archiver-test.s3.amazonaws.com/2/media/", -1), actual.Text())
+		}
+		f.Close()
+		expected.Close()
+		DeleteArchiveFile(task)
+	}
+}
@@ -0,0 +1,96 @@
+package archiver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/pkg/errors"
+)
+
+// SQSPublisher publishes messages to an SQS queue, those of FIFO queues are grouped by their key, so that the events of
+// each org are received in order, and deduplicated by their contents
+type SQSPublisher struct {
+	client   sqsiface.SQSAPI
+	queueURL string
+}
+
+// Publish sends the passed in message to our queue
+func (p *SQSPublisher) Publish(ctx context.Context, key string, value []byte) error {
+	input := &sqs.SendMessageInput{QueueUrl: aws.String(p.queueURL), MessageBody: aws.String(string(value))}
+	if strings.HasSuffix(p.queueURL, ".fifo") {
+		hash := sha256.Sum256(value)
+		input.MessageGroupId = aws.String(key)
+		input.MessageDeduplicationId = aws.String(hex.EncodeToString(hash[:]))
+	}
+
+	_, err := p.client.SendMessageWithContext(ctx, input)
+	if err != nil {
+		return errors.Wrapf(err, "error sending message to SQS queue: %s", p.queueURL)
+	}
+	return nil
+}
+
+// SNSPublisher publishes messages to an SNS topic
+type SNSPublisher struct {
+	client   snsiface.SNSAPI
+	topicARN string
+}
+
+// Publish publishes the passed in message to our topic
+func (p *SNSPublisher) Publish(ctx context.Context, key string, value []byte) error {
+	_, err := p.client.PublishWithContext(ctx, &sns.PublishInput{TopicArn: aws.String(p.topicARN), Message: aws.String(string(value))})
+	if err != nil {
+		return errors.Wrapf(err, "error publishing message to SNS topic: %s", p.topicARN)
+	}
+	return nil
+}
+
+// NewAWSPublisher creates a publisher to the SQS queue or SNS topic of the passed in config, returning nil if neither
+// is configured. Both are called in their own region, which is read from the queue URL or topic ARN, with the same
+// credentials as our S3 client.
+func NewAWSPublisher(config *Config) (Publisher, error) {
+	if config.EventsQueueURL == "" && config.EventsTopicARN == "" {
+		return nil, nil
+	}
+
+	awsSession, err := session.NewSession(&aws.Config{
+		Credentials: credentials.NewStaticCredentials(config.AWSAccessKeyID, config.AWSSecretAccessKey, ""),
+		Region:      aws.String(eventsRegion(config)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if config.EventsQueueURL != "" {
+		return &SQSPublisher{client: sqs.New(awsSession), queueURL: config.EventsQueueURL}, nil
+	}
+	return &SNSPublisher{client: sns.New(awsSession), topicARN: config.EventsTopicARN}, nil
+}
+
+// eventsRegion returns the region of the SQS queue or SNS topic of the passed in config, that of our bucket if it
+// can't be read from its URL or ARN
+func eventsRegion(config *Config) string {
+	if config.EventsQueueURL != "" {
+		// queue URLs are of the form https://sqs.us-east-1.amazonaws.com/123456789012/archives
+		host := strings.Split(strings.TrimPrefix(strings.TrimPrefix(config.EventsQueueURL, "https://"), "http://"), "/")[0]
+		if parts := strings.Split(host, "."); len(parts) > 2 && parts[0] == "sqs" {
+			return parts[1]
+		}
+		return config.S3Region
+	}
+
+	// topic ARNs are of the form arn:aws:sns:us-east-1:123456789012:archives
+	if parts := strings.Split(config.EventsTopicARN, ":"); len(parts) > 3 && parts[3] != "" {
+		return parts[3]
+	}
+	return config.S3Region
+}
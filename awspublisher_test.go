@@ -0,0 +1,87 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/stretchr/testify/assert"
+)
+
+type testSQS struct {
+	sqsiface.SQSAPI
+	sent []*sqs.SendMessageInput
+}
+
+func (c *testSQS) SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+	c.sent = append(c.sent, input)
+	return &sqs.SendMessageOutput{}, nil
+}
+
+type testSNS struct {
+	snsiface.SNSAPI
+	published []*sns.PublishInput
+}
+
+func (c *testSNS) PublishWithContext(ctx aws.Context, input *sns.PublishInput, opts ...request.Option) (*sns.PublishOutput, error) {
+	c.published = append(c.published, input)
+	return &sns.PublishOutput{}, nil
+}
+
+func TestAWSPublisher(t *testing.T) {
+	ctx := context.Background()
+
+	config := NewConfig()
+	publisher, err := NewAWSPublisher(config)
+	assert.NoError(t, err)
+	assert.Nil(t, publisher)
+
+	// records can be published without a configured publisher, as one can be passed in our options
+	config.PublishRecords = true
+	assert.False(t, config.PublishesEvents())
+	assert.Equal(t, 0, len(config.Validate()))
+	config.PublishRecords = false
+
+	config.EventsQueueURL = "https://sqs.eu-west-1.amazonaws.com/123456789012/archives"
+	config.EventsTopicARN = "arn:aws:sns:us-east-2:123456789012:archives"
+	assert.True(t, config.PublishesEvents())
+	assert.Contains(t, config.Validate()[0].Error(), "cannot publish archive events to more than one of an SQS queue, an SNS topic and Kafka")
+
+	// each is called in its own region
+	assert.Equal(t, "eu-west-1", eventsRegion(config))
+	config.EventsQueueURL = ""
+	assert.Equal(t, "us-east-2", eventsRegion(config))
+	config.EventsTopicARN = "archives"
+	assert.Equal(t, config.S3Region, eventsRegion(config))
+
+	config.EventsTopicARN = "arn:aws:sns:us-east-2:123456789012:archives"
+	publisher, err = NewAWSPublisher(config)
+	assert.NoError(t, err)
+	assert.IsType(t, &SNSPublisher{}, publisher)
+
+	snsClient := &testSNS{}
+	publisher = &SNSPublisher{client: snsClient, topicARN: config.EventsTopicARN}
+	assert.NoError(t, publisher.Publish(ctx, "2", []byte(`{"event":"archive_committed"}`)))
+	assert.Equal(t, []*sns.PublishInput{{TopicArn: aws.String(config.EventsTopicARN), Message: aws.String(`{"event":"archive_committed"}`)}}, snsClient.published)
+
+	// messages sent to standard queues are just their body
+	sqsClient := &testSQS{}
+	publisher = &SQSPublisher{client: sqsClient, queueURL: "https://sqs.eu-west-1.amazonaws.com/123456789012/archives"}
+	assert.NoError(t, publisher.Publish(ctx, "2", []byte(`{"event":"archive_committed"}`)))
+	assert.Nil(t, sqsClient.sent[0].MessageGroupId)
+	assert.Equal(t, `{"event":"archive_committed"}`, *sqsClient.sent[0].MessageBody)
+
+	// but those sent to FIFO queues are grouped by org and deduplicated by their contents
+	publisher = &SQSPublisher{client: sqsClient, queueURL: "https://sqs.eu-west-1.amazonaws.com/123456789012/archives.fifo"}
+	assert.NoError(t, publisher.Publish(ctx, "2", []byte(`{"event":"archive_committed"}`)))
+	assert.NoError(t, publisher.Publish(ctx, "3", []byte(`{"event":"archive_committed","org_id":3}`)))
+	assert.Equal(t, "2", *sqsClient.sent[1].MessageGroupId)
+	assert.Equal(t, "3", *sqsClient.sent[2].MessageGroupId)
+	assert.Len(t, *sqsClient.sent[1].MessageDeduplicationId, 64)
+	assert.NotEqual(t, *sqsClient.sent[1].MessageDeduplicationId, *sqsClient.sent[2].MessageDeduplicationId)
+}
@@ -0,0 +1,218 @@
+package archiver
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+const selectBoundaryMessages = `
+SELECT mm.id, mm.created_on
+FROM msgs_msg mm
+WHERE mm.org_id = $1 AND mm.created_on >= $2 AND mm.created_on < $3 AND mm.visibility != 'D' AND
+	date_trunc('day', mm.created_on AT TIME ZONE 'UTC') = mm.created_on AT TIME ZONE 'UTC'
+ORDER BY mm.created_on, mm.id
+`
+
+const selectBoundaryRuns = `
+SELECT fr.id, fr.modified_on
+FROM flows_flowrun fr
+WHERE fr.org_id = $1 AND fr.modified_on >= $2 AND fr.modified_on < $3 AND
+	date_trunc('day', fr.modified_on AT TIME ZONE 'UTC') = fr.modified_on AT TIME ZONE 'UTC'
+ORDER BY fr.modified_on, fr.id
+`
+
+// boundaryRecord is a record whose timestamp falls exactly on the boundary between two days, and the archives it was
+// found in
+type boundaryRecord struct {
+	id       int64
+	time     time.Time
+	archives []int
+}
+
+// auditedRecord is the part of an archived record we need to check which archive it belongs in
+type auditedRecord struct {
+	ID         int64      `json:"id"`
+	CreatedOn  *time.Time `json:"created_on"`
+	ModifiedOn *time.Time `json:"modified_on"`
+}
+
+// timestamp returns the time which decides which archive this record belongs in for the passed in archive type
+func (r *auditedRecord) timestamp(archiveType ArchiveType) *time.Time {
+	if archiveType == RunType {
+		return r.ModifiedOn
+	}
+	return r.CreatedOn
+}
+
+// isDayBoundary returns whether the passed in time is exactly midnight UTC
+func isDayBoundary(t time.Time) bool {
+	t = t.In(time.UTC)
+	return t.Equal(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC))
+}
+
+// AuditOrgBoundaries downloads the archives for the passed in org and type covering the passed in date range, checking
+// that every record in each archive has a timestamp within that archive's period, and that every record whose
+// timestamp is exactly on a day boundary appears in exactly one archive. Boundary records are found both in the
+// database and in the archives themselves so that those since deleted are still checked.
+func AuditOrgBoundaries(ctx context.Context, db *sqlx.DB, s3Client s3iface.S3API, org Org, archiveType ArchiveType, dates DateRange) ([]*ConsistencyIssue, error) {
+	issues := make([]*ConsistencyIssue, 0)
+	newIssue := func(kind IssueKind, archiveID int, startDate time.Time, detail string, args ...interface{}) {
+		issues = append(issues, &ConsistencyIssue{
+			OrgID:       org.ID,
+			ArchiveType: archiveType,
+			Kind:        kind,
+			ArchiveID:   archiveID,
+			StartDate:   startDate,
+			Detail:      fmt.Sprintf(detail, args...),
+		})
+	}
+
+	var query string
+	switch archiveType {
+	case MessageType:
+		query = selectBoundaryMessages
+	case RunType:
+		query = selectBoundaryRuns
+	default:
+		return nil, fmt.Errorf("unknown archive type: %s", archiveType)
+	}
+
+	// first find the boundary records still in our database
+	boundaries := make(map[int64]*boundaryRecord)
+	rows, err := db.QueryxContext(ctx, query, org.ID, dates.Start, dates.End)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error selecting boundary records for org: %d", org.ID)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		record := &boundaryRecord{}
+		err = rows.Scan(&record.id, &record.time)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error scanning boundary record for org: %d", org.ID)
+		}
+		boundaries[record.id] = record
+	}
+	rows.Close()
+
+	// include the day before our range so that a boundary record at the start of our range in both archives is caught
+	archives, err := GetCoveringArchives(ctx, db, org, archiveType, dates.Start.AddDate(0, 0, -1), dates.End)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, archive := range archives {
+		if archive.RecordCount == 0 {
+			continue
+		}
+		if archive.PurgedOn != nil {
+			newIssue(IssueMissingObject, archive.ID, archive.StartDate, "archive has been purged from S3 and can't be audited")
+			continue
+		}
+
+		reader, err := GetS3File(ctx, s3Client, archive.URL)
+		if err != nil {
+			newIssue(IssueMissingObject, archive.ID, archive.StartDate, "unable to read %s: %s", archive.URL, err.Error())
+			continue
+		}
+
+		outside, first, err := auditArchiveRecords(archive, reader, dates, boundaries)
+		reader.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "error auditing records of archive: %d", archive.ID)
+		}
+
+		if outside > 0 {
+			newIssue(IssueOutOfRange, archive.ID, archive.StartDate, "%d records outside of archive period %s to %s, first is record %d at %s",
+				outside, archive.StartDate.Format("2006-01-02"), archive.endDate().Format("2006-01-02"), first.ID, first.timestamp(archiveType).Format(time.RFC3339Nano))
+		}
+	}
+
+	// then report boundary records which weren't archived exactly once
+	records := make([]*boundaryRecord, 0, len(boundaries))
+	for _, r := range boundaries {
+		if len(r.archives) != 1 {
+			records = append(records, r)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].time.Equal(records[j].time) {
+			return records[i].id < records[j].id
+		}
+		return records[i].time.Before(records[j].time)
+	})
+
+	for _, r := range records {
+		day := r.time.In(time.UTC)
+		if len(r.archives) == 0 {
+			newIssue(IssueBoundaryRecord, 0, day, "record %d at %s is not in any archive", r.id, day.Format(time.RFC3339Nano))
+		} else {
+			ids := make([]string, len(r.archives))
+			for i, id := range r.archives {
+				ids[i] = fmt.Sprint(id)
+			}
+			newIssue(IssueBoundaryRecord, r.archives[0], day, "record %d at %s is in %d archives: %s", r.id, day.Format(time.RFC3339Nano), len(r.archives), strings.Join(ids, ", "))
+		}
+	}
+
+	return issues, nil
+}
+
+// auditArchiveRecords reads the passed in gzipped archive contents, noting which archive each boundary record within the
+// passed in dates is found in, and returning the number of records outside of the archive's period and the first of them
+func auditArchiveRecords(archive *Archive, reader io.Reader, dates DateRange, boundaries map[int64]*boundaryRecord) (int, *auditedRecord, error) {
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "error creating gzip reader")
+	}
+	defer gzipReader.Close()
+
+	scanner := bufio.NewScanner(gzipReader)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+
+	period := DateRange{Start: archive.StartDate, End: archive.endDate()}
+	outside := 0
+	var first *auditedRecord
+
+	for scanner.Scan() {
+		record := &auditedRecord{}
+		err = json.Unmarshal(scanner.Bytes(), record)
+		if err != nil {
+			return 0, nil, errors.Wrapf(err, "error parsing record")
+		}
+
+		t := record.timestamp(archive.ArchiveType)
+		if t == nil {
+			return 0, nil, fmt.Errorf("record %d has no timestamp", record.ID)
+		}
+
+		if !period.Contains(*t) {
+			outside++
+			if first == nil {
+				first = record
+			}
+		}
+
+		if isDayBoundary(*t) && dates.Contains(*t) {
+			boundary := boundaries[record.ID]
+			if boundary == nil {
+				boundary = &boundaryRecord{id: record.ID, time: *t}
+				boundaries[record.ID] = boundary
+			}
+			boundary.archives = append(boundary.archives, archive.ID)
+		}
+	}
+
+	return outside, first, scanner.Err()
+}
@@ -0,0 +1,91 @@
+package archiver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditArchiveRecords(t *testing.T) {
+	contents := &bytes.Buffer{}
+	gz := gzip.NewWriter(contents)
+	gz.Write([]byte(`{"id": 1, "created_on": "2017-08-12T00:00:00+00:00"}` + "\n"))
+	gz.Write([]byte(`{"id": 2, "created_on": "2017-08-12T13:45:10.123456+00:00"}` + "\n"))
+	gz.Write([]byte(`{"id": 3, "created_on": "2017-08-13T00:00:00+00:00"}` + "\n"))
+	gz.Write([]byte(`{"id": 4, "created_on": "2017-08-12T23:30:00-02:00"}` + "\n"))
+	gz.Close()
+
+	archive := &Archive{ID: 5, ArchiveType: MessageType, StartDate: time.Date(2017, 8, 12, 0, 0, 0, 0, time.UTC), Period: DayPeriod}
+	dates := DateRange{Start: time.Date(2017, 8, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC)}
+
+	boundaries := map[int64]*boundaryRecord{
+		1: {id: 1, time: time.Date(2017, 8, 12, 0, 0, 0, 0, time.UTC), archives: []int{4}},
+	}
+
+	outside, first, err := auditArchiveRecords(archive, bytes.NewReader(contents.Bytes()), dates, boundaries)
+	assert.NoError(t, err)
+
+	// our next day's boundary record and the one in the wrong timezone are both outside our period
+	assert.Equal(t, 2, outside)
+	assert.Equal(t, int64(3), first.ID)
+
+	// our boundary records have been noted, including the one we didn't already know about
+	assert.Equal(t, 2, len(boundaries))
+	assert.Equal(t, []int{4, 5}, boundaries[1].archives)
+	assert.Equal(t, []int{5}, boundaries[3].archives)
+
+	// runs are checked by their modified_on
+	contents = &bytes.Buffer{}
+	gz = gzip.NewWriter(contents)
+	gz.Write([]byte(`{"id": 1, "created_on": "2017-07-01T00:00:00+00:00", "modified_on": "2017-08-12T10:00:00+00:00"}` + "\n"))
+	gz.Close()
+
+	archive = &Archive{ID: 6, ArchiveType: RunType, StartDate: time.Date(2017, 8, 1, 0, 0, 0, 0, time.UTC), Period: MonthPeriod}
+	outside, first, err = auditArchiveRecords(archive, bytes.NewReader(contents.Bytes()), dates, map[int64]*boundaryRecord{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, outside)
+	assert.Nil(t, first)
+
+	// records without a timestamp are an error
+	contents = &bytes.Buffer{}
+	gz = gzip.NewWriter(contents)
+	gz.Write([]byte(`{"id": 1, "created_on": "2017-08-12T10:00:00+00:00"}` + "\n"))
+	gz.Close()
+
+	_, _, err = auditArchiveRecords(archive, bytes.NewReader(contents.Bytes()), dates, map[int64]*boundaryRecord{})
+	assert.EqualError(t, err, "record 1 has no timestamp")
+}
+
+func TestAuditOrgBoundaries(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+
+	// a message exactly at midnight on a day whose archive is empty
+	db.MustExec(`UPDATE msgs_msg SET created_on = '2017-10-08 00:00:00+00' WHERE id = 6`)
+
+	dates := DateRange{Start: time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2017, 11, 1, 0, 0, 0, 0, time.UTC)}
+	issues, err := AuditOrgBoundaries(ctx, db, nil, orgs[1], MessageType, dates)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(issues))
+	assert.Equal(t, IssueBoundaryRecord, issues[0].Kind)
+	assert.Equal(t, 0, issues[0].ArchiveID)
+	assert.Equal(t, time.Date(2017, 10, 8, 0, 0, 0, 0, time.UTC), issues[0].StartDate)
+	assert.Equal(t, "record 6 at 2017-10-08T00:00:00Z is not in any archive", issues[0].Detail)
+
+	// no runs are on a boundary
+	issues, err = AuditOrgBoundaries(ctx, db, nil, orgs[1], RunType, dates)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(issues))
+
+	assert.True(t, isDayBoundary(time.Date(2017, 10, 8, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, isDayBoundary(time.Date(2017, 10, 8, 0, 0, 0, 1000, time.UTC)))
+	assert.False(t, isDayBoundary(time.Date(2017, 10, 8, 0, 0, 0, 0, time.FixedZone("", 7200))))
+}
@@ -0,0 +1,138 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// MessageReference is a foreign key from another table to msgs_msg
+type MessageReference struct {
+	Table    string `db:"table_name"`
+	Column   string `db:"column_name"`
+	OnDelete string `db:"on_delete"`
+}
+
+func (r *MessageReference) String() string {
+	return fmt.Sprintf("%s.%s", r.Table, r.Column)
+}
+
+// blocksDeletion returns whether this reference will cause deleting a message to fail rather than being cascaded or nulled
+func (r *MessageReference) blocksDeletion() bool {
+	return r.OnDelete == "a" || r.OnDelete == "r"
+}
+
+// the references to msgs_msg that message deletion cleans up itself, see DeleteArchivedMessages
+var handledMessageReferences = map[string]bool{
+	"channels_channellog.msg_id": true,
+	"msgs_msg_labels.msg_id":     true,
+	"msgs_msg.response_to_id":    true,
+}
+
+const selectMessageReferences = `
+SELECT c.conrelid::regclass::text as table_name, a.attname as column_name, c.confdeltype as on_delete
+FROM pg_constraint c
+JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = c.conkey[1]
+WHERE c.contype = 'f' AND c.confrelid = 'msgs_msg'::regclass
+ORDER BY 1, 2
+`
+
+// GetMessageReferences returns all the foreign keys in the database which reference msgs_msg
+func GetMessageReferences(ctx context.Context, db *sqlx.DB) ([]*MessageReference, error) {
+	refs := make([]*MessageReference, 0, 4)
+	err := db.SelectContext(ctx, &refs, selectMessageReferences)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up references to msgs_msg")
+	}
+	return refs, nil
+}
+
+// checkMessageReferences verifies that every foreign key to msgs_msg is either cleaned up by us or handled by the
+// database itself, so that we fail before deleting anything rather than partway through an archive
+func checkMessageReferences(ctx context.Context, db *sqlx.DB) error {
+	refs, err := GetMessageReferences(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	unhandled := make([]string, 0)
+	for _, ref := range refs {
+		if ref.blocksDeletion() && !handledMessageReferences[ref.String()] {
+			unhandled = append(unhandled, ref.String())
+		}
+	}
+
+	if len(unhandled) > 0 {
+		sort.Strings(unhandled)
+		return errors.Errorf("messages referenced by %s which would block deletion", strings.Join(unhandled, ", "))
+	}
+	return nil
+}
+
+const selectMessageLabelIDs = `
+SELECT DISTINCT label_id
+FROM msgs_msg_labels
+WHERE msg_id IN(?)
+`
+
+// selectBatchLabelIDs returns the ids of the labels applied to the passed in messages
+func selectBatchLabelIDs(ctx context.Context, tx *sqlx.Tx, msgIDs []int64) ([]int64, error) {
+	q, vs, err := sqlx.In(selectMessageLabelIDs, msgIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	labelIDs := make([]int64, 0)
+	err = tx.SelectContext(ctx, &labelIDs, tx.Rebind(q), vs...)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return labelIDs, nil
+}
+
+const hasLabelCounts = `SELECT to_regclass('msgs_labelcount') IS NOT NULL`
+
+const recomputeLabelCounts = `
+INSERT INTO msgs_labelcount(label_id, count, is_squashed)
+SELECT label_id, actual - current, FALSE
+FROM (
+	SELECT
+		l.id as label_id,
+		(SELECT count(*) FROM msgs_msg_labels ml JOIN msgs_msg mm ON mm.id = ml.msg_id WHERE ml.label_id = l.id AND mm.visibility = 'V') as actual,
+		(SELECT coalesce(sum(lc.count), 0) FROM msgs_labelcount lc WHERE lc.label_id = l.id) as current
+	FROM msgs_label l
+	WHERE l.id = ANY($1)
+) c
+WHERE actual != current
+`
+
+// RecomputeLabelCounts brings the squashable counts of the passed in labels back in line with the number of visible
+// messages they are applied to, by adding a correcting count for any that have drifted. Databases without label
+// counts are left alone. Returns the number of labels corrected.
+func RecomputeLabelCounts(ctx context.Context, db *sqlx.DB, labelIDs []int64) (int, error) {
+	if len(labelIDs) == 0 {
+		return 0, nil
+	}
+
+	var exists bool
+	err := db.GetContext(ctx, &exists, hasLabelCounts)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error checking for label counts")
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	result, err := db.ExecContext(ctx, recomputeLabelCounts, pq.Array(labelIDs))
+	if err != nil {
+		return 0, errors.Wrapf(err, "error recomputing label counts")
+	}
+	corrected, _ := result.RowsAffected()
+	return int(corrected), nil
+}
@@ -0,0 +1,58 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageReferences(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	refs, err := GetMessageReferences(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(refs))
+	assert.Equal(t, "channels_channellog.msg_id", refs[0].String())
+	assert.Equal(t, "msgs_msg.response_to_id", refs[1].String())
+	assert.Equal(t, "msgs_msg_labels.msg_id", refs[2].String())
+
+	// all of which we clean up ourselves
+	assert.NoError(t, checkMessageReferences(ctx, db))
+
+	// but a new table referencing messages would block us
+	db.MustExec(`CREATE TABLE msgs_msg_notes (id serial primary key, msg_id integer NOT NULL references msgs_msg(id))`)
+	defer db.MustExec(`DROP TABLE msgs_msg_notes`)
+	assert.EqualError(t, checkMessageReferences(ctx, db), "messages referenced by msgs_msg_notes.msg_id which would block deletion")
+
+	// unless it cascades
+	db.MustExec(`CREATE TABLE msgs_msg_tags (id serial primary key, msg_id integer NOT NULL references msgs_msg(id) on delete cascade)`)
+	defer db.MustExec(`DROP TABLE msgs_msg_tags`)
+	assert.EqualError(t, checkMessageReferences(ctx, db), "messages referenced by msgs_msg_notes.msg_id which would block deletion")
+}
+
+func TestRecomputeLabelCounts(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	// our counts start correct
+	corrected, err := RecomputeLabelCounts(ctx, db, []int64{1, 2, 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, corrected)
+
+	// remove label 2 from one of its visible messages
+	db.MustExec(`DELETE FROM msgs_msg_labels WHERE msg_id = 3`)
+
+	corrected, err = RecomputeLabelCounts(ctx, db, []int64{1, 2, 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, corrected)
+	assertCount(t, db, 1, `SELECT sum(count) FROM msgs_labelcount WHERE label_id = 2`)
+	assertCount(t, db, 1, `SELECT count(*) FROM msgs_labelcount WHERE label_id = 2 AND count = -1 AND is_squashed = FALSE`)
+
+	// databases without label counts are left alone
+	db.MustExec(`DROP TABLE msgs_labelcount`)
+	corrected, err = RecomputeLabelCounts(ctx, db, []int64{2})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, corrected)
+}
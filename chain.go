@@ -0,0 +1,74 @@
+package archiver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+)
+
+// RecordChain computes a rolling SHA-256 over the records of an archive, each record being hashed together with the
+// hash of all the records before it. Unlike the MD5 of the whole file, this lets tampering be detected independently
+// of how the records were compressed or which archive they ended up in after a rollup.
+//
+// It is written the uncompressed JSONL of an archive, one record per line.
+type RecordChain struct {
+	state   [sha256.Size]byte
+	pending []byte
+	records int
+}
+
+// NewRecordChain returns a new record chain with no records
+func NewRecordChain() *RecordChain {
+	return &RecordChain{}
+}
+
+// Write adds the records in the passed in bytes to our chain, records which span writes are buffered until complete
+func (c *RecordChain) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		idx := bytes.IndexByte(p, '\n')
+		if idx < 0 {
+			c.pending = append(c.pending, p...)
+			break
+		}
+
+		if len(c.pending) > 0 {
+			c.pending = append(c.pending, p[:idx]...)
+			c.add(c.pending)
+			c.pending = c.pending[:0]
+		} else {
+			c.add(p[:idx])
+		}
+		p = p[idx+1:]
+	}
+	return written, nil
+}
+
+func (c *RecordChain) add(record []byte) {
+	h := sha256.New()
+	h.Write(c.state[:])
+	h.Write(record)
+	h.Sum(c.state[:0])
+	c.records++
+}
+
+// Records returns the number of complete records added to this chain
+func (c *RecordChain) Records() int {
+	return c.records
+}
+
+// Hash returns the hex encoded hash of the chain so far, any incomplete trailing record is ignored
+func (c *RecordChain) Hash() string {
+	return hex.EncodeToString(c.state[:])
+}
+
+// ComputeRecordChain returns the hash of the record chain for the uncompressed JSONL read from the passed in reader
+func ComputeRecordChain(reader io.Reader) (string, error) {
+	chain := NewRecordChain()
+	_, err := io.Copy(chain, reader)
+	if err != nil {
+		return "", err
+	}
+	return chain.Hash(), nil
+}
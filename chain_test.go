@@ -0,0 +1,72 @@
+package archiver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordChain(t *testing.T) {
+	// an empty chain is all zeros
+	chain := NewRecordChain()
+	assert.Equal(t, strings.Repeat("0", 64), chain.Hash())
+	assert.Equal(t, 0, chain.Records())
+
+	// compute what we expect by hand
+	state := make([]byte, sha256.Size)
+	for _, record := range []string{`{"id":1}`, `{"id":2}`, `{"id":3}`} {
+		h := sha256.Sum256(append(state, []byte(record)...))
+		state = h[:]
+	}
+	expected := hex.EncodeToString(state)
+
+	// records written in one go
+	chain.Write([]byte("{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n"))
+	assert.Equal(t, expected, chain.Hash())
+	assert.Equal(t, 3, chain.Records())
+
+	// or split across writes
+	chain = NewRecordChain()
+	chain.Write([]byte("{\"id\""))
+	chain.Write([]byte(":1}\n{\"id\":2"))
+	chain.Write([]byte("}\n{\"id\":3}"))
+	assert.Equal(t, 2, chain.Records())
+	chain.Write([]byte("\n"))
+	assert.Equal(t, expected, chain.Hash())
+
+	hash, err := ComputeRecordChain(strings.NewReader("{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, hash)
+
+	// changing any record changes the hash
+	hash, err = ComputeRecordChain(strings.NewReader("{\"id\":1}\n{\"id\":4}\n{\"id\":3}\n"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, expected, hash)
+}
+
+func TestCreateArchiveFileWithChain(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+
+	task := &Archive{Org: orgs[1], ArchiveType: MessageType, StartDate: time.Date(2017, 8, 12, 0, 0, 0, 0, time.UTC), Period: DayPeriod}
+	err = CreateArchiveFile(ctx, db, task, "/tmp")
+	assert.NoError(t, err)
+	assert.Nil(t, task.ChainHash)
+	DeleteArchiveFile(task)
+
+	task = &Archive{Org: orgs[1], ArchiveType: MessageType, StartDate: time.Date(2017, 8, 12, 0, 0, 0, 0, time.UTC), Period: DayPeriod}
+	err = createArchiveFile(ctx, db, task, "/tmp", &Config{RecordChainHash: true}, nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, task.ChainHash)
+	assert.Equal(t, 64, len(*task.ChainHash))
+	DeleteArchiveFile(task)
+}
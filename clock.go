@@ -0,0 +1,68 @@
+package archiver
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Clock tells us what time it is, which the days and months we can archive, and so our missing archives, are worked out
+// from. Anything timing how long something took still uses the system clock.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is a clock which reads the time from the system
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now().In(time.UTC) }
+
+// FixedClock is a clock which is always the same time, ie: in tests
+type FixedClock time.Time
+
+// Now returns our fixed time
+func (c FixedClock) Now() time.Time { return time.Time(c).In(time.UTC) }
+
+// offsetClock is a clock which is offset from the system clock, advancing as it does
+type offsetClock struct {
+	offset time.Duration
+}
+
+func (c offsetClock) Now() time.Time { return time.Now().Add(c.offset).In(time.UTC) }
+
+// clockStartedOn is when we started, clocks offset from the system clock are offset from this, so that all those created
+// for the same time agree with each other
+var clockStartedOn = time.Now()
+
+// NewOffsetClock returns a clock which reads the passed in time when we started, and advances from it as the system
+// clock does, ie: to run as of a day in the past
+func NewOffsetClock(startedOn time.Time) Clock {
+	return offsetClock{offset: startedOn.Sub(clockStartedOn)}
+}
+
+// ParseNow parses a time to run as of, either a RFC 3339 time or a date, which is taken as its midnight in UTC
+func ParseNow(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.In(time.UTC), nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, errors.Errorf("invalid time '%s', format: 2006-01-02 or 2006-01-02T15:04:05Z", value)
+	}
+	return t, nil
+}
+
+// Clock returns the clock we work out what to archive with, the system clock unless we've been asked to run as of
+// another time
+func (c *Config) Clock() Clock {
+	if c.Now == "" {
+		return SystemClock
+	}
+	now, err := ParseNow(c.Now)
+	if err != nil {
+		return SystemClock
+	}
+	return NewOffsetClock(now)
+}
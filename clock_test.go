@@ -0,0 +1,52 @@
+package archiver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClock(t *testing.T) {
+	tuesday := time.Date(2018, 1, 9, 15, 30, 0, 0, time.UTC)
+
+	clock := FixedClock(tuesday)
+	assert.Equal(t, tuesday, clock.Now())
+	assert.Equal(t, tuesday, clock.Now())
+
+	// an offset clock starts at the time given and advances from there
+	clock2 := NewOffsetClock(tuesday)
+	now := clock2.Now()
+	assert.False(t, now.Before(tuesday))
+	assert.True(t, now.Sub(tuesday) < time.Minute)
+	assert.Equal(t, time.UTC, now.Location())
+
+	// clocks for the same time agree with each other
+	assert.True(t, NewOffsetClock(tuesday).Now().Sub(clock2.Now()) < time.Second)
+
+	// which is what archives are worked out from
+	org := Org{ID: 1, RetentionPeriod: 7}
+	assert.Equal(t, time.Date(2018, 1, 2, 0, 0, 0, 0, time.UTC), newestEligibleDay(FixedClock(tuesday).Now(), org))
+
+	parsed, err := ParseNow("2018-01-09")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2018, 1, 9, 0, 0, 0, 0, time.UTC), parsed)
+
+	parsed, err = ParseNow("2018-01-09T17:30:00+02:00")
+	assert.NoError(t, err)
+	assert.Equal(t, tuesday, parsed)
+
+	_, err = ParseNow("last tuesday")
+	assert.EqualError(t, err, "invalid time 'last tuesday', format: 2006-01-02 or 2006-01-02T15:04:05Z")
+
+	config := NewConfig()
+	assert.Equal(t, SystemClock, config.Clock())
+
+	config.Now = "2018-01-09"
+	assert.False(t, config.Clock().Now().Before(time.Date(2018, 1, 9, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, config.Clock().Now().Before(tuesday))
+	assert.Empty(t, config.Validate())
+
+	config.Now = "last tuesday"
+	assert.EqualError(t, config.Validate()[0], "invalid now 'last tuesday', format: 2006-01-02 or 2006-01-02T15:04:05Z")
+}
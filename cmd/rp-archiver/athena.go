@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/glue/glueiface"
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "athena",
+		usage: "[ddl|sync] [flags]",
+		help:  "Prints the DDL of the Athena tables of archived messages and runs, or writes the manifests of and registers the partitions of every archive.",
+		run:   runAthena,
+	})
+}
+
+func runAthena(config *archiver.Config, db *sqlx.DB, args []string) error {
+	action := "ddl"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		action, args = args[0], args[1:]
+	}
+
+	flags := newFlagSet(commands["athena"])
+	orgID := flags.Int("org", 0, "the id of the org to register the partitions of, defaults to all active orgs")
+	typeName := flags.String("type", "", "the type of archives, message or run, defaults to both")
+	flags.Parse(args)
+
+	types := []archiver.ArchiveType{archiver.MessageType, archiver.RunType}
+	if *typeName != "" {
+		archiveType, err := archiver.ParseArchiveType(*typeName)
+		if err != nil {
+			return err
+		}
+		types = []archiver.ArchiveType{archiveType}
+	}
+
+	switch action {
+	case "ddl":
+		for _, archiveType := range types {
+			ddl, err := archiver.AthenaTableDDL(config, archiveType)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s;\n\n", ddl)
+		}
+		return nil
+
+	case "sync":
+		return syncAthena(config, db, *orgID, types)
+
+	default:
+		return fmt.Errorf("unknown athena action: %s", action)
+	}
+}
+
+// syncAthena writes the manifests of every month of the archives of the passed in org, or all active orgs, and
+// registers their partitions with Glue if we have a database to register them in
+func syncAthena(config *archiver.Config, db *sqlx.DB, orgID int, types []archiver.ArchiveType) error {
+	ctx := context.Background()
+
+	s3Client, err := archiver.NewS3Client(config)
+	if err != nil {
+		return err
+	}
+	var glueClient glueiface.GlueAPI
+	if config.AthenaDatabase != "" {
+		glueClient, err = archiver.NewGlueClient(config)
+		if err != nil {
+			return err
+		}
+	}
+
+	var orgs []archiver.Org
+	if orgID != 0 {
+		org, err := archiver.GetOrg(ctx, db, config, orgID)
+		if err != nil {
+			return err
+		}
+		orgs = []archiver.Org{org}
+	} else {
+		orgs, err = archiver.GetActiveOrgs(ctx, db, config)
+		if err != nil {
+			return err
+		}
+	}
+
+	dates := archiver.DateRange{Start: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), End: time.Now().AddDate(1, 0, 0)}
+	for _, org := range orgs {
+		for _, archiveType := range types {
+			archives, err := archiver.ListArchives(ctx, db, org, archiveType, dates)
+			if err != nil {
+				return err
+			}
+			err = archiver.RegisterAthenaPartitions(ctx, db, s3Client, glueClient, config, org, archiveType, archives)
+			if err != nil {
+				return err
+			}
+			logrus.WithField("org_id", org.ID).WithField("archive_type", archiveType).WithField("archives", len(archives)).Info("synced athena partitions")
+		}
+	}
+	return nil
+}
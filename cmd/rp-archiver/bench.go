@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/pkg/errors"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "bench",
+		usage: "--org <id> --type <message|run> --date <date> [--runs <n>]",
+		help:  "Exports a single daily or monthly archive with its record JSON built by Postgres, then by the archiver, then by Postgres with COPY, and prints how long each took, to choose the record-json and export-with-copy settings. Nothing is recorded or uploaded.",
+		run:   runBench,
+	})
+}
+
+func runBench(config *archiver.Config, db *sqlx.DB, args []string) error {
+	flags := newFlagSet(commands["bench"])
+	orgID := flags.Int("org", 0, "the id of the org to export an archive for")
+	typeName := flags.String("type", "message", "the type of archive to export, message or run")
+	date := flags.String("date", "", "the month (YYYY-MM) or day (YYYY-MM-DD) to export an archive for")
+	runs := flags.Int("runs", 3, "the number of times to export the archive each way, the fastest of which is reported")
+	flags.Parse(args)
+
+	if *runs < 1 {
+		return fmt.Errorf("runs must be at least 1")
+	}
+	archiveType, err := archiver.ParseArchiveType(*typeName)
+	if err != nil {
+		return err
+	}
+	startDate, period, err := parseDate(*date)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	org, err := archiver.GetOrg(ctx, db, config, *orgID)
+	if err != nil {
+		return err
+	}
+
+	err = archiver.EnsureTempArchiveDirectory(config.TempDir)
+	if err != nil {
+		return errors.Wrapf(err, "cannot write to temp directory")
+	}
+
+	archive := &archiver.Archive{
+		Org:         org,
+		OrgID:       org.ID,
+		StartDate:   startDate,
+		ArchiveType: archiveType,
+		Period:      period,
+	}
+
+	benchmarks, err := archiver.BenchmarkRecordJSON(ctx, db, config, archive, *runs)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RECORD JSON\tEXPORT\tRECORDS\tSIZE\tTIME\tRECORDS/SEC\tHASH")
+	for _, b := range benchmarks {
+		export := "query"
+		if b.Copy {
+			export = "copy"
+		}
+		rate := float64(b.Records) / b.Elapsed.Seconds()
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%.0f\t%s\n", b.RecordJSON, export, b.Records, formatSize(b.Size), b.Elapsed.Round(time.Millisecond), rate, b.Hash)
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	if benchmarks[0].Hash != benchmarks[1].Hash {
+		fmt.Println("\nWARNING: archives differ, the records built by the archiver don't match those built by Postgres")
+	}
+	if len(benchmarks) > 2 && benchmarks[0].Hash != benchmarks[2].Hash {
+		fmt.Println("\nWARNING: archives differ, the records exported with COPY don't match those exported with queries")
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "build",
+		usage: "--org <id> --type <message|run> --date <date> [--output <path>] [--decompress]",
+		help:  "Builds a single daily or monthly archive from the database and writes it to a local file or stdout, without recording it in the archives table or uploading it to S3.",
+		run:   runBuild,
+	})
+}
+
+func runBuild(config *archiver.Config, db *sqlx.DB, args []string) error {
+	flags := newFlagSet(commands["build"])
+	orgID := flags.Int("org", 0, "the id of the org to build an archive for")
+	typeName := flags.String("type", "message", "the type of archive to build, message or run")
+	date := flags.String("date", "", "the month (YYYY-MM) or day (YYYY-MM-DD) to build an archive for")
+	output := flags.String("output", "-", "the file to write the archive to, - for stdout")
+	decompress := flags.Bool("decompress", false, "whether to write the archive decompressed as JSONL rather than gzipped")
+	flags.Parse(args)
+
+	archiveType, err := archiver.ParseArchiveType(*typeName)
+	if err != nil {
+		return err
+	}
+	startDate, period, err := parseDate(*date)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	org, err := archiver.GetOrg(ctx, db, config, *orgID)
+	if err != nil {
+		return err
+	}
+
+	err = archiver.EnsureTempArchiveDirectory(config.TempDir)
+	if err != nil {
+		return errors.Wrapf(err, "cannot write to temp directory")
+	}
+
+	archive := &archiver.Archive{
+		Org:         org,
+		OrgID:       org.ID,
+		StartDate:   startDate,
+		ArchiveType: archiveType,
+		Period:      period,
+	}
+
+	// this only reads from the database, the archive is never written to it or uploaded
+	err = archiver.CreateArchiveFile(ctx, db, archive, config.TempDir)
+	if err != nil {
+		return err
+	}
+	defer archiver.DeleteArchiveFile(archive)
+
+	err = writeBuiltArchive(archive, *output, *decompress)
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"org_id":       org.ID,
+		"archive_type": archiveType,
+		"start_date":   archive.StartDate,
+		"period":       archive.Period,
+		"record_count": archive.RecordCount,
+		"size":         archive.Size,
+		"hash":         archive.Hash,
+		"output":       *output,
+	}).Info("built archive")
+	return nil
+}
+
+// writeBuiltArchive copies the file of the passed in built archive to the passed in output file or stdout
+func writeBuiltArchive(archive *archiver.Archive, output string, decompress bool) error {
+	file, err := os.Open(archive.ArchiveFile)
+	if err != nil {
+		return errors.Wrapf(err, "error opening archive file: %s", archive.ArchiveFile)
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+	if decompress {
+		gzReader, err := gzip.NewReader(file)
+		if err != nil {
+			return errors.Wrapf(err, "error decompressing archive file: %s", archive.ArchiveFile)
+		}
+		defer gzReader.Close()
+		reader = gzReader
+	}
+
+	if output == "-" {
+		writer := bufio.NewWriter(os.Stdout)
+		_, err = io.Copy(writer, reader)
+		if err != nil {
+			return errors.Wrapf(err, "error writing archive to stdout")
+		}
+		return writer.Flush()
+	}
+
+	out, err := os.Create(output)
+	if err != nil {
+		return errors.Wrapf(err, "error creating output file: %s", output)
+	}
+	_, err = io.Copy(out, reader)
+	if err == nil {
+		err = out.Close()
+	} else {
+		out.Close()
+	}
+	if err != nil {
+		os.Remove(output)
+		return errors.Wrapf(err, "error writing archive to: %s", output)
+	}
+	return nil
+}
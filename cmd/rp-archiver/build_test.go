@@ -0,0 +1,63 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteBuiltArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	contents := "{\"id\": 1}\n{\"id\": 2}\n"
+	archiveFile := filepath.Join(dir, "archive.jsonl.gz")
+	file, err := os.Create(archiveFile)
+	assert.NoError(t, err)
+	gz := gzip.NewWriter(file)
+	gz.Write([]byte(contents))
+	gz.Close()
+	file.Close()
+
+	archive := &archiver.Archive{ArchiveFile: archiveFile}
+
+	// written as is, our output is the same gzipped file
+	output := filepath.Join(dir, "output.jsonl.gz")
+	err = writeBuiltArchive(archive, output, false)
+	assert.NoError(t, err)
+
+	built, err := ioutil.ReadFile(archiveFile)
+	assert.NoError(t, err)
+	written, err := ioutil.ReadFile(output)
+	assert.NoError(t, err)
+	assert.Equal(t, built, written)
+
+	// or it can be decompressed
+	output = filepath.Join(dir, "output.jsonl")
+	err = writeBuiltArchive(archive, output, true)
+	assert.NoError(t, err)
+
+	written, err = ioutil.ReadFile(output)
+	assert.NoError(t, err)
+	assert.Equal(t, contents, string(written))
+
+	// a file that isn't gzipped can't be decompressed, and leaves no output behind
+	archive.ArchiveFile = output
+	output = filepath.Join(dir, "invalid.jsonl")
+	err = writeBuiltArchive(archive, output, true)
+	assert.Error(t, err)
+	_, err = os.Stat(output)
+	assert.True(t, os.IsNotExist(err))
+
+	// nor can an archive whose file is missing
+	archive.ArchiveFile = filepath.Join(dir, "missing.jsonl.gz")
+	err = writeBuiltArchive(archive, output, false)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "error opening archive file")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "cat",
+		usage: "<archive id>... | --org <id> --type <message|run> --from <date> [--to <date>]",
+		help:  "Writes the decompressed JSONL of the given archives, or those covering a date range, to stdout in date order, verifying each archive's hash before writing it.",
+		run:   runCat,
+	})
+}
+
+func runCat(config *archiver.Config, db *sqlx.DB, args []string) error {
+	flags := newFlagSet(commands["cat"])
+	orgID := flags.Int("org", 0, "the id of the org to write archives of, if not listing archive ids")
+	typeName := flags.String("type", "message", "the type of archives to write, message or run")
+	from := flags.String("from", "", "the first month (YYYY-MM) or day (YYYY-MM-DD) to write")
+	to := flags.String("to", "", "the last month (YYYY-MM) or day (YYYY-MM-DD) to write, inclusive")
+	flags.Parse(args)
+
+	ctx := context.Background()
+
+	var archives []*archiver.Archive
+	var err error
+	if flags.NArg() > 0 {
+		archives, err = lookupCatArchives(ctx, db, flags.Args())
+	} else {
+		archives, err = lookupCatRange(ctx, config, db, *orgID, *typeName, *from, *to)
+	}
+	if err != nil {
+		return err
+	}
+
+	s3Client, err := archiver.NewS3Client(config)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(os.Stdout)
+	_, err = archiver.StreamArchives(ctx, s3Client, archives, config.TempDir, writer)
+	if err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// lookupCatRange looks up the archives of the passed in org and type which cover the passed in date range
+func lookupCatRange(ctx context.Context, config *archiver.Config, db *sqlx.DB, orgID int, typeName string, from string, to string) ([]*archiver.Archive, error) {
+	if orgID == 0 {
+		return nil, fmt.Errorf("missing archive ids or org id")
+	}
+	archiveType, err := archiver.ParseArchiveType(typeName)
+	if err != nil {
+		return nil, err
+	}
+	start, end, err := parseDateRange(from, to)
+	if err != nil {
+		return nil, err
+	}
+	org, err := archiver.GetOrg(ctx, db, config, orgID)
+	if err != nil {
+		return nil, err
+	}
+	return archiver.GetCoveringArchives(ctx, db, org, archiveType, start, end)
+}
+
+// lookupCatArchives looks up the archives with the passed in ids, see archiver.GetStreamableArchives
+func lookupCatArchives(ctx context.Context, db *sqlx.DB, values []string) ([]*archiver.Archive, error) {
+	ids := make([]int, 0, len(values))
+	for _, value := range values {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive id: %s", value)
+		}
+		ids = append(ids, id)
+	}
+	return archiver.GetStreamableArchives(ctx, db, ids)
+}
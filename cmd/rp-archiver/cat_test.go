@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupCatArchives(t *testing.T) {
+	ctx := context.Background()
+
+	// ids are checked before any are looked up
+	_, err := lookupCatArchives(ctx, nil, []string{"1", "abc"})
+	assert.EqualError(t, err, "invalid archive id: abc")
+}
+
+func TestLookupCatRange(t *testing.T) {
+	ctx := context.Background()
+
+	// flags are checked before the org is looked up
+	_, err := lookupCatRange(ctx, nil, nil, 0, "message", "2017-09", "")
+	assert.EqualError(t, err, "missing archive ids or org id")
+
+	_, err = lookupCatRange(ctx, nil, nil, 3, "flow", "2017-09", "")
+	assert.Error(t, err)
+
+	_, err = lookupCatRange(ctx, nil, nil, 3, "message", "", "")
+	assert.EqualError(t, err, "missing start date")
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "check",
+		usage: "[flags]",
+		help:  "Checks the archives of each org for gaps, overlaps, incomplete rollups and, optionally, missing S3 objects, misplaced records and incompatibilities with RapidPro's archives API.",
+		run:   runCheck,
+	})
+}
+
+func runCheck(config *archiver.Config, db *sqlx.DB, args []string) error {
+	flags := newFlagSet(commands["check"])
+	orgID := flags.Int("org", 0, "the id of the org to check, defaults to all active orgs")
+	checkS3 := flags.Bool("s3", false, "whether to also check that the S3 object of every archive exists")
+	api := flags.Bool("api", false, "whether to also check that every archive is one RapidPro's archives API can list and link to, with --s3 checking its URL is reachable")
+	boundaries := flags.Bool("boundaries", false, "whether to also download archives to check records are in the right archive, requires --from")
+	from := flags.String("from", "", "the first day or month to check records for when checking boundaries, ie: 2017-08")
+	to := flags.String("to", "", "the last day or month to check records for when checking boundaries, defaults to --from")
+	flags.Parse(args)
+
+	var dates archiver.DateRange
+	if *boundaries {
+		start, end, err := parseDateRange(*from, *to)
+		if err != nil {
+			return err
+		}
+		dates = archiver.DateRange{Start: start, End: end}
+	}
+
+	ctx := context.Background()
+	now := config.Clock().Now()
+
+	var orgs []archiver.Org
+	if *orgID != 0 {
+		org, err := archiver.GetOrg(ctx, db, config, *orgID)
+		if err != nil {
+			return err
+		}
+		orgs = []archiver.Org{org}
+	} else {
+		var err error
+		orgs, err = archiver.GetActiveOrgs(ctx, db, config)
+		if err != nil {
+			return err
+		}
+	}
+
+	var s3Client s3iface.S3API
+	if *checkS3 || *boundaries {
+		var err error
+		s3Client, err = archiver.NewS3Client(config)
+		if err != nil {
+			return err
+		}
+	}
+
+	types, err := config.ArchiveTypes()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ORG\tTYPE\tISSUE\tARCHIVE\tDATE\tDETAIL")
+
+	total := 0
+	for _, org := range orgs {
+		for _, archiveType := range types {
+			var checkClient s3iface.S3API
+			if *checkS3 {
+				checkClient = s3Client
+			}
+			issues, err := archiver.CheckOrgConsistency(ctx, now, db, checkClient, org, archiveType)
+			if err != nil {
+				return err
+			}
+
+			if *api {
+				apiIssues, err := archiver.CheckOrgAPICompatibility(ctx, db, checkClient, org, archiveType)
+				if err != nil {
+					return err
+				}
+				issues = append(issues, apiIssues...)
+			}
+
+			if *boundaries {
+				boundaryIssues, err := archiver.AuditOrgBoundaries(ctx, db, s3Client, org, archiveType, dates)
+				if err != nil {
+					return err
+				}
+				issues = append(issues, boundaryIssues...)
+			}
+
+			for _, i := range issues {
+				archiveID := "-"
+				if i.ArchiveID != 0 {
+					archiveID = fmt.Sprint(i.ArchiveID)
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", i.OrgID, i.ArchiveType, i.Kind, archiveID, i.StartDate.Format("2006-01-02"), i.Detail)
+			}
+			total += len(issues)
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	logrus.WithField("orgs", len(orgs)).WithField("issues", total).Info("consistency check complete")
+
+	if total > 0 {
+		return fmt.Errorf("found %d consistency issues", total)
+	}
+	return nil
+}
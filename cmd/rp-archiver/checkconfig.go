@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "check-config",
+		usage: "",
+		help:  "Validates the configuration, checks the database can be reached and has the schema we need, and that S3 can be written to, exiting non-zero if anything is wrong.",
+		run:   runCheckConfig,
+	})
+}
+
+// runCheckConfig is run before our config is validated or the database opened, so that every problem is reported
+// rather than just the first, and so it opens its own connection rather than using the one it is passed
+func runCheckConfig(config *archiver.Config, _ *sqlx.DB, args []string) error {
+	flags := newFlagSet(commands["check-config"])
+	flags.Parse(args)
+
+	errs, warnings := 0, 0
+	fail := func(format string, args ...interface{}) {
+		fmt.Printf("ERROR: "+format+"\n", args...)
+		errs++
+	}
+	warn := func(format string, args ...interface{}) {
+		fmt.Printf("WARNING: "+format+"\n", args...)
+		warnings++
+	}
+
+	for _, problem := range config.Validate() {
+		fail("%s", problem)
+	}
+	if config.PublishRecords && !config.PublishesEvents() {
+		fail("cannot publish records without an SQS queue, SNS topic or Kafka topic to publish them to")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	// check each of the databases we archive, problems loading them are reported by validation
+	databases, _ := loadDatabases(config)
+	for _, d := range databases {
+		prefix := ""
+		if d.Name != "" {
+			prefix = fmt.Sprintf("database %s: ", d.Name)
+		}
+		dFail := func(format string, args ...interface{}) { fail(prefix+format, args...) }
+		dWarn := func(format string, args ...interface{}) { warn(prefix+format, args...) }
+
+		if d.Config.DB == "" || strings.Contains(d.Config.DB, "TimeZone") {
+			dFail("skipped database checks, fix the db connection string first")
+		} else {
+			checkDatabase(ctx, d.Config, dFail, dWarn)
+		}
+
+		if d.Config.ReplicaDB != "" && !strings.Contains(d.Config.ReplicaDB, "TimeZone") {
+			checkReplica(ctx, d.Config, dFail, dWarn)
+		}
+	}
+
+	if config.UploadToS3 {
+		checkS3(ctx, config, fail)
+	}
+
+	if errs > 0 {
+		return fmt.Errorf("found %d errors and %d warnings", errs, warnings)
+	}
+
+	fmt.Printf("OK: configuration is valid with %d warnings\n", warnings)
+	return nil
+}
+
+// checkDatabase checks that we can connect to the database and that it has the tables we need
+func checkDatabase(ctx context.Context, config *archiver.Config, fail func(string, ...interface{}), warn func(string, ...interface{})) {
+	db, err := sqlx.Open("postgres", archiver.UTCConnectionString(config.DB))
+	if err != nil {
+		fail("invalid db connection string: %s", err)
+		return
+	}
+	defer db.Close()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		fail("unable to connect to database, check the db connection string and that the database is reachable: %s", err)
+		return
+	}
+
+	status, err := archiver.CheckSchema(ctx, db)
+	if err != nil {
+		fail("unable to check database schema: %s", err)
+		return
+	}
+	for _, table := range status.MissingTables {
+		fail("database is missing RapidPro table %s, is it pointed at a RapidPro database and fully migrated?", table)
+	}
+	for _, column := range status.MissingColumns {
+		fail("database is missing column %s, this archiver supports RapidPro %s to %s", column, archiver.MinRapidProVersion, archiver.MaxRapidProVersion)
+	}
+	for _, addition := range status.PendingAdditions {
+		fail("database is missing archiver addition %s, run the migrate command to apply it", addition)
+	}
+
+	if config.ExportWithCopy {
+		err = archiver.CheckCopy(ctx, config.DB)
+		if err != nil {
+			fail("unable to export with copy from database: %s", err)
+		}
+	}
+}
+
+// checkReplica checks that we can connect to our read replica and warns if it is more than a day behind
+func checkReplica(ctx context.Context, config *archiver.Config, fail func(string, ...interface{}), warn func(string, ...interface{})) {
+	replica, err := sqlx.Open("postgres", archiver.UTCConnectionString(config.ReplicaDB))
+	if err != nil {
+		fail("invalid replica db connection string: %s", err)
+		return
+	}
+	defer replica.Close()
+
+	replayed, err := archiver.GetReplicaReplayed(ctx, replica)
+	if err != nil {
+		fail("unable to connect to replica database, check the replica db connection string and that it is reachable: %s", err)
+		return
+	}
+	if replayed == nil || time.Since(*replayed) > time.Hour*24 {
+		warn("replica database is more than a day behind, archives will be exported from the primary until it catches up")
+	}
+
+	if config.ExportWithCopy {
+		err = archiver.CheckCopy(ctx, config.ReplicaDB)
+		if err != nil {
+			fail("unable to export with copy from replica database: %s", err)
+		}
+	}
+}
+
+// checkS3 checks that we can reach our bucket and write to and delete from it
+func checkS3(ctx context.Context, config *archiver.Config, fail func(string, ...interface{})) {
+	s3Client, err := archiver.NewS3Client(config)
+	if err != nil {
+		fail("unable to initialize s3 client, check the s3 endpoint, region, credentials and that bucket %s exists: %s", config.S3Bucket, err)
+		return
+	}
+
+	err = archiver.TestS3Write(ctx, s3Client, config.S3Bucket)
+	if err != nil {
+		fail("unable to write to s3 bucket %s, check the bucket exists and the credentials can put and delete objects in it: %s", config.S3Bucket, err)
+	}
+}
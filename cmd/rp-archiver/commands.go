@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+)
+
+// command is a one off operation which can be run instead of our normal archiving loop, ie: `rp-archiver indexes create`
+type command struct {
+	name  string
+	usage string
+	help  string
+	run   func(config *archiver.Config, db *sqlx.DB, args []string) error
+}
+
+var commands = make(map[string]*command)
+
+// registerCommand registers the passed in command so it can be invoked by name
+func registerCommand(c *command) {
+	commands[c.name] = c
+}
+
+// parseCommand looks for a command as our first argument, if one is found it is returned along with its arguments and
+// removed from os.Args so that the remaining flags can be loaded as config
+func parseCommand() (*command, []string) {
+	if len(os.Args) < 2 {
+		return nil, nil
+	}
+
+	cmd := commands[os.Args[1]]
+	if cmd == nil {
+		return nil, nil
+	}
+
+	args := os.Args[2:]
+	os.Args = os.Args[:1]
+	return cmd, args
+}
+
+// mergeRepeatedFlags merges the values of each of the passed in flags which are repeated in the passed in arguments
+// into a single comma separated value, ie: --org 1 --org=2 becomes --org=1,2
+func mergeRepeatedFlags(args []string, names ...string) []string {
+	for _, name := range names {
+		merged := make([]string, 0, len(args))
+		values := make([]string, 0)
+		first := -1
+
+		for i := 0; i < len(args); i++ {
+			arg := args[i]
+			flagName := strings.TrimLeft(arg, "-")
+			if !strings.HasPrefix(arg, "-") || (flagName != name && !strings.HasPrefix(flagName, name+"=")) {
+				merged = append(merged, arg)
+				continue
+			}
+
+			if flagName == name {
+				if i+1 < len(args) {
+					values = append(values, args[i+1])
+					i++
+				}
+			} else {
+				values = append(values, strings.TrimPrefix(flagName, name+"="))
+			}
+			if first == -1 {
+				first = len(merged)
+				merged = append(merged, "")
+			}
+		}
+
+		if first != -1 {
+			merged[first] = "--" + name + "=" + strings.Join(values, ",")
+		}
+		args = merged
+	}
+	return args
+}
+
+// newFlagSet creates a flag set for the passed in command, with usage that mentions configuration comes from the environment
+func newFlagSet(cmd *command) *flag.FlagSet {
+	flags := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: rp-archiver %s %s\n\n%s\n\n", cmd.name, cmd.usage, cmd.help)
+		flags.PrintDefaults()
+		fmt.Fprintf(flags.Output(), "\nConfiguration is read from archiver.toml and ARCHIVER_ environment variables.\n\nAvailable commands: %v\n", commandNames())
+	}
+	return flags
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for n := range commands {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseDateRange parses the passed in inclusive from and to dates, which can be either months (2017-08) or days
+// (2017-08-12), returning the equivalent [start, end) range. If to is empty the range ends after from.
+func parseDateRange(from string, to string) (time.Time, time.Time, error) {
+	if from == "" {
+		return time.Time{}, time.Time{}, fmt.Errorf("missing start date")
+	}
+	if to == "" {
+		to = from
+	}
+
+	start, _, err := parseDate(from)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	toStart, period, err := parseDate(to)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	end := toStart.AddDate(0, 0, 1)
+	if period == archiver.MonthPeriod {
+		end = toStart.AddDate(0, 1, 0)
+	}
+
+	if !start.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("start date %s is after end date %s", from, to)
+	}
+	return start, end, nil
+}
+
+// parseDate parses a month (2017-08) or day (2017-08-12) returning its start and whether it was a month or a day
+func parseDate(value string) (time.Time, archiver.ArchivePeriod, error) {
+	d, err := time.Parse("2006-01-02", value)
+	if err == nil {
+		return d, archiver.DayPeriod, nil
+	}
+	d, err = time.Parse("2006-01", value)
+	if err == nil {
+		return d, archiver.MonthPeriod, nil
+	}
+	return time.Time{}, "", fmt.Errorf("invalid date '%s', must be YYYY-MM or YYYY-MM-DD", value)
+}
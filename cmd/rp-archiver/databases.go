@@ -0,0 +1,24 @@
+package main
+
+import (
+	archiver "github.com/nyaruka/rp-archiver"
+)
+
+// loadDatabases returns the databases we've been asked to archive, those listed in our databases file if we have one,
+// otherwise just the one in our config, which is unnamed. Their connections are opened by the caller.
+func loadDatabases(config *archiver.Config) ([]*archiver.RunDatabase, error) {
+	if config.Databases == "" {
+		return []*archiver.RunDatabase{{Config: config}}, nil
+	}
+
+	listed, err := archiver.LoadDatabases(config.Databases)
+	if err != nil {
+		return nil, err
+	}
+
+	databases := make([]*archiver.RunDatabase, len(listed))
+	for i, d := range listed {
+		databases[i] = &archiver.RunDatabase{Name: d.Name, Config: d.ConfigFor(config)}
+	}
+	return databases, nil
+}
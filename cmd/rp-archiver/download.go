@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "download",
+		usage: "--org <id> --type <message|run> --date <date> [--output <dir>] [--decompress]",
+		help:  "Downloads the archives covering a month or day to a local directory, verifying each archive's hash and size as it goes.",
+		run:   runDownload,
+	})
+}
+
+func runDownload(config *archiver.Config, db *sqlx.DB, args []string) error {
+	flags := newFlagSet(commands["download"])
+	orgID := flags.Int("org", 0, "the id of the org to download archives for")
+	typeName := flags.String("type", "message", "the type of archives to download, message or run")
+	date := flags.String("date", "", "the month (YYYY-MM) or day (YYYY-MM-DD) to download archives for")
+	output := flags.String("output", ".", "the directory to download archives to")
+	decompress := flags.Bool("decompress", false, "whether to decompress archives as they are downloaded")
+	flags.Parse(args)
+
+	archiveType, err := archiver.ParseArchiveType(*typeName)
+	if err != nil {
+		return err
+	}
+	start, end, err := parseDateRange(*date, "")
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	org, err := archiver.GetOrg(ctx, db, config, *orgID)
+	if err != nil {
+		return err
+	}
+
+	archives, err := archiver.GetCoveringArchives(ctx, db, org, archiveType, start, end)
+	if err != nil {
+		return err
+	}
+	if len(archives) == 0 {
+		return fmt.Errorf("no %s archives found for org %d covering %s", archiveType, org.ID, *date)
+	}
+
+	s3Client, err := archiver.NewS3Client(config)
+	if err != nil {
+		return err
+	}
+
+	for _, archive := range archives {
+		// archives of periods without records may not have a file
+		if archive.URL == "" {
+			logrus.WithFields(logrus.Fields{"archive_id": archive.ID, "start_date": archive.StartDate, "period": archive.Period}).Info("archive has no file, skipping")
+			continue
+		}
+
+		filename, err := downloadFilename(archive, *decompress)
+		if err != nil {
+			return err
+		}
+		filename = filepath.Join(*output, filename)
+
+		err = downloadToFile(ctx, s3Client, archive, filename, *decompress)
+		if err != nil {
+			return err
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"archive_id":   archive.ID,
+			"org_id":       org.ID,
+			"archive_type": archiveType,
+			"start_date":   archive.StartDate,
+			"period":       archive.Period,
+			"record_count": archive.RecordCount,
+			"file":         filename,
+		}).Info("downloaded archive")
+	}
+	return nil
+}
+
+// downloadFilename returns the name of the local file the passed in archive is downloaded to, the same as its S3 object
+func downloadFilename(archive *archiver.Archive, decompress bool) (string, error) {
+	u, err := url.Parse(archive.URL)
+	if err != nil || path.Base(u.Path) == "/" || path.Base(u.Path) == "." {
+		return "", fmt.Errorf("archive %d has invalid URL: %s", archive.ID, archive.URL)
+	}
+
+	filename := path.Base(u.Path)
+	if decompress {
+		filename = strings.TrimSuffix(filename, ".gz")
+	}
+	return filename, nil
+}
+
+// downloadToFile downloads the passed in archive to the passed in local file, removing it if the download fails
+func downloadToFile(ctx context.Context, s3Client s3iface.S3API, archive *archiver.Archive, filename string, decompress bool) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return errors.Wrapf(err, "error creating output file: %s", filename)
+	}
+
+	err = archiver.DownloadArchive(ctx, s3Client, archive, file, decompress)
+	if err == nil {
+		err = file.Close()
+	} else {
+		file.Close()
+	}
+	if err != nil {
+		os.Remove(filename)
+		return err
+	}
+	return nil
+}
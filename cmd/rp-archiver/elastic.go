@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "elastic",
+		usage: "[--org <id>] [--type <message|run>] [--from <date>] [--to <date>]",
+		help:  "Indexes the records of existing archives in Elasticsearch, monthlies in preference to their dailies, for every active org or a single org.",
+		run:   runElastic,
+	})
+}
+
+func runElastic(config *archiver.Config, db *sqlx.DB, args []string) error {
+	flags := newFlagSet(commands["elastic"])
+	orgID := flags.Int("org", 0, "the id of the org to index the archives of, defaults to all active orgs")
+	typeName := flags.String("type", "", "the type of archives to index, message or run, defaults to both")
+	from := flags.String("from", "", "the first month (YYYY-MM) or day (YYYY-MM-DD) to index archives for, defaults to all time")
+	to := flags.String("to", "", "the last month or day to index archives for, inclusive, defaults to the from date")
+	flags.Parse(args)
+
+	elastic := archiver.NewElastic(config)
+	if elastic == nil {
+		return fmt.Errorf("no elasticsearch URL configured")
+	}
+
+	types := []archiver.ArchiveType{archiver.MessageType, archiver.RunType}
+	if *typeName != "" {
+		archiveType, err := archiver.ParseArchiveType(*typeName)
+		if err != nil {
+			return err
+		}
+		types = []archiver.ArchiveType{archiveType}
+	}
+
+	start, end := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), time.Now().AddDate(1, 0, 0)
+	if *from != "" {
+		var err error
+		start, end, err = parseDateRange(*from, *to)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx := context.Background()
+	s3Client, err := archiver.NewS3Client(config)
+	if err != nil {
+		return err
+	}
+
+	var orgs []archiver.Org
+	if *orgID != 0 {
+		org, err := archiver.GetOrg(ctx, db, config, *orgID)
+		if err != nil {
+			return err
+		}
+		orgs = []archiver.Org{org}
+	} else {
+		orgs, err = archiver.GetActiveOrgs(ctx, db, config)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, org := range orgs {
+		for _, archiveType := range types {
+			archives, err := archiver.GetCoveringArchives(ctx, db, org, archiveType, start, end)
+			if err != nil {
+				return err
+			}
+			err = elastic.IndexArchives(ctx, s3Client, archives)
+			if err != nil {
+				return err
+			}
+			logrus.WithField("org_id", org.ID).WithField("archive_type", archiveType).WithField("archives", len(archives)).Info("indexed archives")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "erase",
+		usage: "--org <id> --contact <uuid> [--redact] [--dry-run]",
+		help:  "Rewrites every archive of an org with records of a contact without them, writing a report of what was erased to stdout as JSON.",
+		run:   runErase,
+	})
+}
+
+func runErase(config *archiver.Config, db *sqlx.DB, args []string) error {
+	flags := newFlagSet(commands["erase"])
+	orgID := flags.Int("org", 0, "the id of the org whose archives should be rewritten")
+	contact := flags.String("contact", "", "the UUID of the contact whose records should be erased")
+	redact := flags.Bool("redact", false, "whether to mask the contact's records rather than remove them")
+	dryRun := flags.Bool("dry-run", false, "whether to only report which archives have the contact's records")
+	flags.Parse(args)
+
+	if *contact == "" {
+		return fmt.Errorf("missing contact to erase")
+	}
+
+	ctx := context.Background()
+	org, err := archiver.GetOrg(ctx, db, config, *orgID)
+	if err != nil {
+		return err
+	}
+
+	s3Client, err := archiver.NewS3Client(config)
+	if err != nil {
+		return err
+	}
+
+	log := logrus.WithFields(logrus.Fields{
+		"org_id":       org.ID,
+		"contact_uuid": *contact,
+		"redact":       *redact,
+		"dry_run":      *dryRun,
+	})
+	log.Info("starting erasure")
+	eraseStart := time.Now()
+
+	options := &archiver.ErasureOptions{ContactUUID: *contact, Redact: *redact, DryRun: *dryRun}
+	report, eraseErr := archiver.EraseContact(ctx, db, s3Client, config, org, options)
+
+	// we always write our report, even if we failed part way through, so what was rewritten is known
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	if eraseErr != nil {
+		return eraseErr
+	}
+
+	log.WithFields(logrus.Fields{
+		"archives": len(report.Archives),
+		"found":    report.Found,
+		"erased":   report.Erased,
+		"elapsed":  time.Since(eraseStart),
+	}).Info("completed erasure")
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "explain",
+		usage: "[flags]",
+		help:  "Explains the export query of the next archive of each org without running it, failing if any would read msgs_msg or flows_flowrun with a sequential scan.",
+		run:   runExplain,
+	})
+}
+
+func runExplain(config *archiver.Config, db *sqlx.DB, args []string) error {
+	flags := newFlagSet(commands["explain"])
+	orgID := flags.Int("org", 0, "the id of the org to explain, defaults to all active orgs")
+	typeName := flags.String("type", "", "the type of archive to explain, message or run, defaults to the configured types")
+	verbose := flags.Bool("verbose", false, "whether to print the full plan of each query which has sequential scans")
+	flags.Parse(args)
+
+	ctx := context.Background()
+	now := config.Clock().Now()
+
+	var orgs []archiver.Org
+	if *orgID != 0 {
+		org, err := archiver.GetOrg(ctx, db, config, *orgID)
+		if err != nil {
+			return err
+		}
+		orgs = []archiver.Org{org}
+	} else {
+		var err error
+		orgs, err = archiver.GetActiveOrgs(ctx, db, config)
+		if err != nil {
+			return err
+		}
+	}
+
+	types, err := config.ArchiveTypes()
+	if err != nil {
+		return err
+	}
+	if *typeName != "" {
+		archiveType, err := archiver.ParseArchiveType(*typeName)
+		if err != nil {
+			return err
+		}
+		types = []archiver.ArchiveType{archiveType}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ORG\tTYPE\tSTART\tCOST\tROWS\tSEQ SCANS")
+
+	plans := make([]*archiver.ExportPlan, 0)
+	for _, org := range orgs {
+		for _, t := range types {
+			plan, err := archiver.ExplainOrgExport(ctx, now, config, db, org, t)
+			if err != nil {
+				w.Flush()
+				return err
+			}
+
+			scans := "-"
+			if plan.HasSeqScans() {
+				scans = strings.Join(plan.SeqScans, ", ") + " !"
+				plans = append(plans, plan)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%.0f\t%.0f\t%s\n", org.ID, t, plan.Archive.StartDate.Format("2006-01-02"), plan.Cost, plan.Rows, scans)
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	for _, plan := range plans {
+		logrus.WithFields(logrus.Fields{
+			"org_id":       plan.Archive.Org.ID,
+			"archive_type": plan.Archive.ArchiveType,
+			"seq_scans":    plan.SeqScans,
+			"suggestion":   plan.Suggestion(),
+		}).Warn("export query plans a sequential scan")
+
+		if *verbose {
+			fmt.Println(plan.Plan)
+		}
+	}
+
+	logrus.WithField("orgs", len(orgs)).WithField("seq_scans", len(plans)).Info("explain complete")
+
+	if len(plans) > 0 {
+		return fmt.Errorf("%d export queries plan sequential scans", len(plans))
+	}
+	return nil
+}
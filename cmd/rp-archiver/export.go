@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "export",
+		usage: "--org <id> --type <message|run> --from <date> [--to <date>] [--output <path|s3://bucket/key>] [--allow-gaps]",
+		help:  "Streams the decompressed contents of all the archives covering a date range as one JSONL file, verifying each archive's hash before writing it, and failing if any days of the range haven't been archived unless --allow-gaps is set.",
+		run:   runExport,
+	})
+}
+
+func runExport(config *archiver.Config, db *sqlx.DB, args []string) error {
+	flags := newFlagSet(commands["export"])
+	orgID := flags.Int("org", 0, "the id of the org to export")
+	typeName := flags.String("type", "message", "the type of archives to export, message or run")
+	from := flags.String("from", "", "the first month (YYYY-MM) or day (YYYY-MM-DD) to export")
+	to := flags.String("to", "", "the last month (YYYY-MM) or day (YYYY-MM-DD) to export, inclusive")
+	output := flags.String("output", "-", "where to write the export, - for stdout, a local path or an s3://bucket/key URL")
+	allowGaps := flags.Bool("allow-gaps", false, "whether to export the archives there are when some days of the range haven't been archived")
+	flags.Parse(args)
+
+	archiveType, err := archiver.ParseArchiveType(*typeName)
+	if err != nil {
+		return err
+	}
+	start, end, err := parseDateRange(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	org, err := archiver.GetOrg(ctx, db, config, *orgID)
+	if err != nil {
+		return err
+	}
+
+	archives, err := archiver.GetCoveringArchives(ctx, db, org, archiveType, start, end)
+	if err != nil {
+		return err
+	}
+
+	// an export missing days would look complete to whoever reads it, so we only allow that when asked to
+	gaps, err := archiver.CoverageGaps(ctx, db, org, archiveType, archiver.DateRange{Start: start, End: end})
+	if err != nil {
+		return err
+	}
+	if len(gaps) > 0 {
+		missing := make([]string, len(gaps))
+		for i, gap := range gaps {
+			missing[i] = fmt.Sprintf("%s to %s", gap.Start.Format("2006-01-02"), gap.End.AddDate(0, 0, -1).Format("2006-01-02"))
+		}
+		if !*allowGaps {
+			return errors.Errorf("range isn't fully archived, missing: %s, use --allow-gaps to export it anyway", strings.Join(missing, ", "))
+		}
+		logrus.WithField("missing", strings.Join(missing, ", ")).Warn("range isn't fully archived, exporting the archives there are")
+	}
+
+	s3Client, err := archiver.NewS3Client(config)
+	if err != nil {
+		return err
+	}
+
+	log := logrus.WithFields(logrus.Fields{
+		"org_id":       org.ID,
+		"archive_type": archiveType,
+		"start_date":   start,
+		"end_date":     end,
+		"archives":     len(archives),
+		"output":       *output,
+	})
+	log.Info("starting export")
+	exportStart := time.Now()
+
+	var records int
+	if strings.HasPrefix(*output, "s3://") {
+		var exportURL string
+		records, exportURL, err = exportToS3(ctx, s3Client, archives, config.TempDir, *output)
+		log = log.WithField("url", exportURL)
+	} else {
+		records, err = exportToFile(ctx, s3Client, archives, config.TempDir, *output)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.WithField("record_count", records).WithField("elapsed", time.Since(exportStart)).Info("completed export")
+	return nil
+}
+
+// exportToFile streams the passed in archives to a local file, or stdout if path is -
+func exportToFile(ctx context.Context, s3Client s3iface.S3API, archives []*archiver.Archive, tempDir string, path string) (int, error) {
+	out := os.Stdout
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return 0, errors.Wrapf(err, "error creating output file: %s", path)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	writer := bufio.NewWriter(out)
+	records, err := archiver.StreamArchives(ctx, s3Client, archives, tempDir, writer)
+	if err != nil {
+		return records, err
+	}
+	return records, writer.Flush()
+}
+
+// exportToS3 streams the passed in archives to the passed in s3://bucket/key URL, returning the URL of the new object
+func exportToS3(ctx context.Context, s3Client s3iface.S3API, archives []*archiver.Archive, tempDir string, s3URL string) (int, string, error) {
+	u, err := url.Parse(s3URL)
+	if err != nil {
+		return 0, "", errors.Wrapf(err, "invalid S3 URL: %s", s3URL)
+	}
+
+	// stream through a pipe so we only hold one archive of the export on disk at a time
+	records := 0
+	reader, writer := io.Pipe()
+	go func() {
+		var streamErr error
+		records, streamErr = archiver.StreamArchives(ctx, s3Client, archives, tempDir, writer)
+		writer.CloseWithError(streamErr)
+	}()
+
+	exportURL, err := archiver.UploadStreamToS3(ctx, s3Client, u.Host, u.Path, "application/json", reader)
+	if err != nil {
+		reader.CloseWithError(err)
+		return 0, "", errors.Wrapf(err, "error uploading export to: %s", s3URL)
+	}
+	return records, exportURL, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "holds",
+		usage: "[list|add|release] [flags]",
+		help:  "Manages legal holds which prevent records and archives being deleted or purged for an org.",
+		run:   runHolds,
+	})
+}
+
+func runHolds(config *archiver.Config, db *sqlx.DB, args []string) error {
+	action := "list"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		action, args = args[0], args[1:]
+	}
+
+	flags := newFlagSet(commands["holds"])
+	orgID := flags.Int("org", 0, "the id of the org to hold, or to list holds for")
+	typeName := flags.String("type", "", "the type of archive to hold, message or run, defaults to both")
+	from := flags.String("from", "", "the first month (YYYY-MM) or day (YYYY-MM-DD) to hold, defaults to all time")
+	to := flags.String("to", "", "the last month or day to hold, inclusive, defaults to the from date")
+	reason := flags.String("reason", "", "why the records are being held")
+	holdID := flags.Int("id", 0, "the id of the hold to release")
+	all := flags.Bool("all", false, "whether to list released holds as well")
+	flags.Parse(args)
+
+	ctx := context.Background()
+
+	switch action {
+	case "list":
+		holds, err := archiver.ListLegalHolds(ctx, db, *orgID, *all)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tORG\tTYPE\tFROM\tUNTIL\tCREATED\tRELEASED\tREASON")
+		for _, h := range holds {
+			archiveType := "all"
+			if h.ArchiveType != nil {
+				archiveType = string(*h.ArchiveType)
+			}
+			released := ""
+			if h.ReleasedOn != nil {
+				released = h.ReleasedOn.Format("2006-01-02")
+			}
+			fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n", h.ID, h.OrgID, archiveType, formatHoldDate(h.StartDate),
+				formatHoldDate(h.EndDate), h.CreatedOn.Format("2006-01-02"), released, h.Reason)
+		}
+		return w.Flush()
+
+	case "add":
+		if *orgID == 0 {
+			return fmt.Errorf("missing org id")
+		}
+		if *reason == "" {
+			return fmt.Errorf("missing reason")
+		}
+
+		hold := &archiver.LegalHold{OrgID: *orgID, Reason: *reason}
+		if *typeName != "" {
+			archiveType, err := archiver.ParseArchiveType(*typeName)
+			if err != nil {
+				return err
+			}
+			hold.ArchiveType = &archiveType
+		}
+		if *from != "" {
+			start, end, err := parseDateRange(*from, *to)
+			if err != nil {
+				return err
+			}
+			hold.StartDate, hold.EndDate = &start, &end
+		}
+
+		err := archiver.CreateLegalHold(ctx, db, hold)
+		if err != nil {
+			return err
+		}
+		fmt.Println(hold.ID)
+		return nil
+
+	case "release":
+		if *holdID == 0 {
+			return fmt.Errorf("missing hold id")
+		}
+		return archiver.ReleaseLegalHold(ctx, db, *holdID)
+
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown holds action: %s", action)
+	}
+}
+
+// formatHoldDate formats an optional hold date, a nil date meaning the hold has no limit in that direction
+func formatHoldDate(d *time.Time) string {
+	if d == nil {
+		return "-"
+	}
+	return d.Format("2006-01-02")
+}
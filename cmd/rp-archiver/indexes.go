@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "indexes",
+		usage: "[status|create|drop] [flags]",
+		help:  "Manages the temporary partial indexes which speed up archiving of large tables during a backfill.",
+		run:   runIndexes,
+	})
+}
+
+func runIndexes(config *archiver.Config, db *sqlx.DB, args []string) error {
+	action := "status"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		action, args = args[0], args[1:]
+	}
+
+	flags := newFlagSet(commands["indexes"])
+	whenComplete := flags.Bool("when-complete", false, "only drop indexes if no active org has missing daily archives")
+	flags.Parse(args)
+
+	ctx := context.Background()
+
+	switch action {
+	case "status":
+		statuses, err := archiver.GetArchiveIndexStatus(ctx, db)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "INDEX\tTABLE\tEXISTS\tVALID\tSIZE")
+		for _, s := range statuses {
+			fmt.Fprintf(w, "%s\t%s\t%t\t%t\t%d\n", s.Name, s.Table, s.Exists, s.Valid, s.Size)
+		}
+		return w.Flush()
+
+	case "create":
+		return archiver.CreateArchiveIndexes(ctx, db, archiver.IndexCutoff(config.Clock().Now(), config.RetentionPeriod))
+
+	case "drop":
+		if *whenComplete {
+			complete, err := backfillComplete(ctx, config, db)
+			if err != nil {
+				return err
+			}
+			if !complete {
+				logrus.Info("backfill not yet complete, leaving indexes in place")
+				return nil
+			}
+		}
+		return archiver.DropArchiveIndexes(ctx, db)
+
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown indexes action: %s", action)
+	}
+}
+
+// backfillComplete returns whether every active org has all its daily archives built for the types we archive
+func backfillComplete(ctx context.Context, config *archiver.Config, db *sqlx.DB) (bool, error) {
+	orgs, err := archiver.GetActiveOrgs(ctx, db, config)
+	if err != nil {
+		return false, err
+	}
+
+	types, err := config.ArchiveTypes()
+	if err != nil {
+		return false, err
+	}
+
+	now := config.Clock().Now()
+	for _, org := range orgs {
+		for _, archiveType := range types {
+			missing, err := archiver.GetMissingDailyArchives(ctx, db, now, org, archiveType)
+			if err != nil {
+				return false, errors.Wrapf(err, "error checking missing archives for org: %d", org.ID)
+			}
+			if len(missing) > 0 {
+				logrus.WithField("org_id", org.ID).WithField("archive_type", archiveType).WithField("missing", len(missing)).Info("org still has missing archives")
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "inventory",
+		usage: "[--format <json|csv>] [--diff] [--bucket <bucket>] [--prefix <prefix>]",
+		help:  "Outputs the S3 key of every committed archive, optionally diffed against a listing of the bucket to find missing objects and orphaned archive files.",
+		run:   runInventory,
+	})
+}
+
+func runInventory(config *archiver.Config, db *sqlx.DB, args []string) error {
+	flags := newFlagSet(commands["inventory"])
+	format := flags.String("format", "json", "the format to output the inventory in, json or csv")
+	diff := flags.Bool("diff", false, "whether to diff the inventory against a listing of the bucket, marking objects present, missing or orphaned")
+	bucket := flags.String("bucket", config.S3Bucket, "the bucket to list when diffing")
+	prefix := flags.String("prefix", config.S3Prefix, "the prefix of the keys to list when diffing")
+	flags.Parse(args)
+
+	if *format != "json" && *format != "csv" {
+		return fmt.Errorf("invalid format %s, must be json or csv", *format)
+	}
+
+	ctx := context.Background()
+	objects, err := archiver.GetArchiveInventory(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	missing, orphaned := 0, 0
+	if *diff {
+		s3Client, err := archiver.NewS3Client(config)
+		if err != nil {
+			return err
+		}
+		objects, err = archiver.DiffArchiveInventory(ctx, s3Client, *bucket, *prefix, objects)
+		if err != nil {
+			return err
+		}
+		for _, o := range objects {
+			if o.Status == archiver.InventoryMissing {
+				missing++
+			} else if o.Status == archiver.InventoryOrphaned {
+				orphaned++
+			}
+		}
+	}
+
+	if *format == "csv" {
+		err = writeInventoryCSV(objects)
+	} else {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(objects)
+	}
+	if err != nil {
+		return err
+	}
+
+	logrus.WithField("objects", len(objects)).WithField("missing", missing).WithField("orphaned", orphaned).Info("inventory complete")
+	if missing > 0 || orphaned > 0 {
+		return fmt.Errorf("found %d missing objects and %d orphaned archive files", missing, orphaned)
+	}
+	return nil
+}
+
+// writeInventoryCSV writes the passed in inventory to stdout as CSV with a header row
+func writeInventoryCSV(objects []*archiver.InventoryObject) error {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"bucket", "key", "archive_id", "org_id", "archive_type", "period", "start_date", "size", "hash", "status"})
+	for _, o := range objects {
+		archiveID, orgID := "", ""
+		if o.ArchiveID != 0 {
+			archiveID, orgID = strconv.Itoa(o.ArchiveID), strconv.Itoa(o.OrgID)
+		}
+		w.Write([]string{o.Bucket, o.Key, archiveID, orgID, string(o.ArchiveType), string(o.Period), o.StartDate, strconv.FormatInt(o.Size, 10), o.Hash, string(o.Status)})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "lag",
+		usage: "[flags]",
+		help:  "Lists how many days behind its newest possible archive each org is, failing if any are more than the maximum lag.",
+		run:   runLag,
+	})
+}
+
+func runLag(config *archiver.Config, db *sqlx.DB, args []string) error {
+	flags := newFlagSet(commands["lag"])
+	orgID := flags.Int("org", 0, "the id of the org to check, defaults to all active orgs")
+	maxDays := flags.Int("max-days", config.MaxLagDays, "the maximum number of days an org can be behind, 0 for no maximum")
+	flags.Parse(args)
+
+	ctx := context.Background()
+	now := config.Clock().Now()
+
+	var orgs []archiver.Org
+	if *orgID != 0 {
+		org, err := archiver.GetOrg(ctx, db, config, *orgID)
+		if err != nil {
+			return err
+		}
+		orgs = []archiver.Org{org}
+	} else {
+		var err error
+		orgs, err = archiver.GetActiveOrgs(ctx, db, config)
+		if err != nil {
+			return err
+		}
+	}
+
+	types, err := config.ArchiveTypes()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ORG\tTYPE\tNEWEST ARCHIVED\tNEWEST POSSIBLE\tLAG DAYS")
+
+	lagging := 0
+	for _, org := range orgs {
+		for _, archiveType := range types {
+			lag, err := archiver.GetOrgArchiveLag(ctx, db, now, org, archiveType)
+			if err != nil {
+				return err
+			}
+
+			newest := "-"
+			if lag.NewestArchived != nil {
+				newest = lag.NewestArchived.Format("2006-01-02")
+			}
+			flag := ""
+			if lag.Exceeds(*maxDays) {
+				flag = " !"
+				lagging++
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d%s\n", org.ID, archiveType, newest, lag.NewestPossible.Format("2006-01-02"), lag.Days, flag)
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	logrus.WithField("orgs", len(orgs)).WithField("lagging", lagging).Info("lag check complete")
+
+	if lagging > 0 {
+		return fmt.Errorf("%d orgs and types are more than %d days behind", lagging, *maxDays)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "list",
+		usage: "--org <id> [--type <message|run>] [--from <date>] [--to <date>] [--json]",
+		help:  "Lists the archives of an org, daily and monthly, optionally only those of a type or overlapping a date range.",
+		run:   runList,
+	})
+}
+
+// listedArchive is how an archive is output by our list command as JSON
+type listedArchive struct {
+	ID          int                    `json:"id"`
+	ArchiveType archiver.ArchiveType   `json:"archive_type"`
+	Period      archiver.ArchivePeriod `json:"period"`
+	StartDate   string                 `json:"start_date"`
+	EndDate     string                 `json:"end_date"`
+	Size        int64                  `json:"size"`
+	RecordCount int                    `json:"record_count"`
+	Hash        string                 `json:"hash"`
+	URL         string                 `json:"url"`
+	RollupID    *int                   `json:"rollup_id"`
+	DeletedOn   *time.Time             `json:"deleted_on"`
+	PurgedOn    *time.Time             `json:"purged_on"`
+	State       archiver.ArchiveState  `json:"state"`
+	StateError  *string                `json:"state_error"`
+}
+
+func runList(config *archiver.Config, db *sqlx.DB, args []string) error {
+	flags := newFlagSet(commands["list"])
+	orgID := flags.Int("org", 0, "the id of the org to list archives for")
+	typeName := flags.String("type", "", "the type of archives to list, message or run, defaults to both")
+	from := flags.String("from", "", "the first month (YYYY-MM) or day (YYYY-MM-DD) to list archives for, defaults to all time")
+	to := flags.String("to", "", "the last month or day to list archives for, inclusive, defaults to the from date")
+	asJSON := flags.Bool("json", false, "whether to output the archives as JSON rather than a table")
+	flags.Parse(args)
+
+	if *orgID == 0 {
+		return fmt.Errorf("missing org id")
+	}
+
+	types := []archiver.ArchiveType{archiver.MessageType, archiver.RunType}
+	if *typeName != "" {
+		archiveType, err := archiver.ParseArchiveType(*typeName)
+		if err != nil {
+			return err
+		}
+		types = []archiver.ArchiveType{archiveType}
+	}
+
+	dates := archiver.DateRange{Start: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), End: time.Now().AddDate(1, 0, 0)}
+	if *from != "" {
+		start, end, err := parseDateRange(*from, *to)
+		if err != nil {
+			return err
+		}
+		dates = archiver.DateRange{Start: start, End: end}
+	}
+
+	ctx := context.Background()
+	org, err := archiver.GetOrg(ctx, db, config, *orgID)
+	if err != nil {
+		return err
+	}
+
+	listed := make([]*listedArchive, 0)
+	for _, archiveType := range types {
+		archives, err := archiver.ListArchives(ctx, db, org, archiveType, dates)
+		if err != nil {
+			return err
+		}
+		for _, a := range archives {
+			listed = append(listed, newListedArchive(a))
+		}
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(listed)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTYPE\tPERIOD\tSTART\tEND\tSIZE\tRECORDS\tHASH\tURL\tROLLUP\tDELETED\tPURGED\tSTATE")
+	for _, a := range listed {
+		rollup := "-"
+		if a.RollupID != nil {
+			rollup = fmt.Sprint(*a.RollupID)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n", a.ID, a.ArchiveType, a.Period, a.StartDate, a.EndDate,
+			a.Size, a.RecordCount, a.Hash, a.URL, rollup, formatListDate(a.DeletedOn), formatListDate(a.PurgedOn), a.State)
+	}
+	return w.Flush()
+}
+
+// newListedArchive creates the listed version of the passed in archive, with inclusive start and end dates
+func newListedArchive(a *archiver.Archive) *listedArchive {
+	return &listedArchive{
+		ID:          a.ID,
+		ArchiveType: a.ArchiveType,
+		Period:      a.Period,
+		StartDate:   a.StartDate.In(time.UTC).Format("2006-01-02"),
+		EndDate:     a.EndDate().In(time.UTC).AddDate(0, 0, -1).Format("2006-01-02"),
+		Size:        a.Size,
+		RecordCount: a.RecordCount,
+		Hash:        a.Hash,
+		URL:         a.URL,
+		RollupID:    a.Rollup,
+		DeletedOn:   a.DeletedOn,
+		PurgedOn:    a.PurgedOn,
+		State:       a.CurrentState(),
+		StateError:  a.StateError,
+	}
+}
+
+// formatListDate formats an optional date for our list command, a nil date being shown as -
+func formatListDate(d *time.Time) string {
+	if d == nil {
+		return "-"
+	}
+	return d.In(time.UTC).Format("2006-01-02")
+}
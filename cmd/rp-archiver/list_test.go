@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewListedArchive(t *testing.T) {
+	rollupID := 7
+	deletedOn := time.Date(2017, 10, 3, 12, 30, 0, 0, time.UTC)
+
+	// a daily archive starts and ends on the same day
+	listed := newListedArchive(&archiver.Archive{
+		ID:          5,
+		ArchiveType: archiver.MessageType,
+		Period:      archiver.DayPeriod,
+		StartDate:   time.Date(2017, 9, 10, 0, 0, 0, 0, time.UTC),
+		Size:        120,
+		RecordCount: 3,
+		Hash:        "f0d79988b7772c003d04a28bd7417a62",
+		URL:         "https://s3.amazonaws.com/temba-archives/3/message_D20170910_f0d79988b7772c003d04a28bd7417a62.jsonl.gz",
+		Rollup:      &rollupID,
+		DeletedOn:   &deletedOn,
+	})
+	assert.Equal(t, 5, listed.ID)
+	assert.Equal(t, archiver.MessageType, listed.ArchiveType)
+	assert.Equal(t, "2017-09-10", listed.StartDate)
+	assert.Equal(t, "2017-09-10", listed.EndDate)
+	assert.Equal(t, int64(120), listed.Size)
+	assert.Equal(t, 3, listed.RecordCount)
+	assert.Equal(t, &rollupID, listed.RollupID)
+	assert.Equal(t, archiver.ArchiveDone, listed.State)
+
+	// a monthly archive ends on the last day of its month
+	listed = newListedArchive(&archiver.Archive{
+		ID:          6,
+		ArchiveType: archiver.RunType,
+		Period:      archiver.MonthPeriod,
+		StartDate:   time.Date(2017, 2, 1, 0, 0, 0, 0, time.UTC),
+	})
+	assert.Equal(t, "2017-02-01", listed.StartDate)
+	assert.Equal(t, "2017-02-28", listed.EndDate)
+	assert.Nil(t, listed.RollupID)
+	assert.Equal(t, archiver.ArchiveCommitted, listed.State)
+}
+
+func TestFormatListDate(t *testing.T) {
+	purgedOn := time.Date(2018, 1, 5, 23, 0, 0, 0, time.FixedZone("", -3*60*60))
+
+	assert.Equal(t, "-", formatListDate(nil))
+	assert.Equal(t, "2018-01-06", formatListDate(&purgedOn))
+}
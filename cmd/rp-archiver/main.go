@@ -2,31 +2,109 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
+	"runtime"
+	"runtime/debug"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/evalphobia/logrus_sentry"
-	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"github.com/nyaruka/ezconf"
 	archiver "github.com/nyaruka/rp-archiver"
 	"github.com/sirupsen/logrus"
 )
 
+// exit codes when running once, 1 is used by any fatal error and 2 by the Go runtime on panics
+const (
+	exitSuccess        = 0
+	exitPartialFailure = 3
+)
+
+// set at build time by goreleaser
+var (
+	version = "dev"
+	commit  = "none"
+	date    = "unknown"
+)
+
 func main() {
+	if len(os.Args) == 2 && (os.Args[1] == "--version" || os.Args[1] == "-version") {
+		printVersion()
+		return
+	}
+
 	config := archiver.NewConfig()
-	loader := ezconf.NewLoader(&config, "archiver", "Archives RapidPro runs and msgs to S3", []string{"archiver.toml"})
+
+	// if we've been invoked with a command, pull it and its arguments out so our loader only sees config flags
+	cmd, cmdArgs := parseCommand()
+
+	// our org selection flags can be repeated, but our loader only keeps the last value of a flag
+	os.Args = mergeRepeatedFlags(os.Args, "org", "exclude-org", "org-uuid")
+
+	// our config file has to be known before we can load the rest of our config, YAML ones we load ourselves
+	files := []string{"archiver.toml"}
+	if configFile := findConfigFile(os.Args); configFile != "" {
+		if _, err := os.Stat(configFile); err != nil {
+			logrus.WithError(err).Fatal("unable to read config file")
+		}
+		files = []string{configFile}
+
+		if archiver.IsYAMLConfigFile(configFile) {
+			err := archiver.LoadYAMLConfig(config, configFile)
+			if err != nil {
+				logrus.WithError(err).Fatal("error loading config file")
+			}
+			files = []string{}
+		}
+	}
+
+	loader := ezconf.NewLoader(&config, "archiver", "Archives RapidPro runs and msgs to S3", files)
 	loader.MustLoad()
 
-	if config.KeepFiles && !config.UploadToS3 {
-		logrus.Fatal("cannot delete archives and also not upload to s3")
+	// replace any references to secrets with their values
+	if err := config.ResolveSecrets(); err != nil {
+		logrus.WithError(err).Fatal("invalid secret")
+	}
+
+	// checking our config reports every problem with it rather than just the first, so has to run before we validate it
+	if cmd != nil && cmd.name == "check-config" {
+		err := cmd.run(config, nil, cmdArgs)
+		if err != nil {
+			logrus.WithError(err).Fatal("configuration check failed")
+		}
+		return
 	}
 
-	// configure our logger
+	for _, problem := range config.Validate() {
+		logrus.Fatal(problem)
+	}
+
+	// programs embedding us can publish records with a publisher of their own, but we can only use one we've configured
+	if config.PublishRecords && !config.PublishesEvents() {
+		logrus.Fatal("cannot publish records without an SQS queue, SNS topic or Kafka topic to publish them to")
+	}
+
+	orgSelection, err := archiver.ParseOrgSelection(config)
+	if err != nil {
+		logrus.WithError(err).Fatal("invalid org selection")
+	}
+
+	// configure our logger, commands log to stderr so that their output can be piped
 	logrus.SetOutput(os.Stdout)
-	logrus.SetFormatter(&logrus.TextFormatter{})
+	if cmd != nil {
+		logrus.SetOutput(os.Stderr)
+	}
+	formatter, err := archiver.NewLogFormatter(config.LogFormat)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	logrus.SetFormatter(formatter)
 
 	level, err := logrus.ParseLevel(config.LogLevel)
 	if err != nil {
@@ -47,23 +125,100 @@ func main() {
 		logrus.StandardLogger().Hooks.Add(hook)
 	}
 
-	// our settings shouldn't contain a timezone, nothing will work right with this not being a constant UTC
-	if strings.Contains(config.DB, "TimeZone") {
-		logrus.WithField("db", config.DB).Fatalf("invalid db connection string, do not specify a timezone, archiver always uses UTC")
+	// if we have a webhook, make sure it hears about fatal errors
+	webhook := archiver.NewWebhook(config)
+	if webhook != nil {
+		logrus.StandardLogger().Hooks.Add(webhook)
 	}
 
-	// force our DB connection to be in UTC
-	if strings.Contains(config.DB, "?") {
-		config.DB += "&TimeZone=UTC"
-	} else {
-		config.DB += "?TimeZone=UTC"
+	// we archive each of the databases in our databases file in turn, or just the one in our config
+	databases, err := loadDatabases(config)
+	if err != nil {
+		logrus.WithError(err).Fatal("invalid databases")
 	}
 
-	db, err := sqlx.Open("postgres", config.DB)
-	if err != nil {
-		logrus.Fatal(err)
+	for _, d := range databases {
+		// force our DB connection to be in UTC, our settings can't contain a timezone as nothing would work right with
+		// it not being a constant UTC, and unless told otherwise, size our pool so no worker waits for a connection, each
+		// archive being built needs at most two, one to stream records and one to write to, and each org worker and our
+		// instance lock hold one more for their locks
+		d.DB, err = archiver.OpenDB(d.Config.DB, config, config.OrgWorkers*(2*config.ArchiveWorkers+1)+1)
+		if err != nil {
+			d.Log().Fatal(err)
+		}
+		d.Config.DB = archiver.UTCConnectionString(d.Config.DB)
+
+		// fail fast if this isn't a RapidPro database we know how to archive, rather than with SQL errors part way through
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err = archiver.CheckSchemaCompatibility(ctx, d.DB)
+		cancel()
+		if err != nil {
+			d.Log().WithError(err).Fatal("error checking database schema")
+		}
+	}
+
+	// if this is a dry run, print what we would do without touching anything, even before our own schema is migrated
+	if config.DryRun && cmd == nil {
+		for i, d := range databases {
+			if d.Name != "" {
+				if i > 0 {
+					fmt.Println()
+				}
+				fmt.Printf("Database %s:\n\n", d.Name)
+			}
+			err = printPlan(d.Config, d.DB, orgSelection)
+			if err != nil {
+				d.Log().WithError(err).Fatal("error planning run")
+			}
+		}
+		return
+	}
+
+	// our own additions to the schema are applied by the migrate command, which is run against each of our databases
+	if cmd != nil && cmd.name == "migrate" {
+		for _, d := range databases {
+			err = cmd.run(d.Config, d.DB, cmdArgs)
+			if err != nil {
+				d.Log().WithError(err).Fatal("error migrating archiver schema")
+			}
+		}
+		return
+	}
+
+	// otherwise make sure they have been applied, we never alter RapidPro's tables as we run
+	for _, d := range databases {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err = archiver.CheckSchemaMigrated(ctx, d.DB)
+		cancel()
+		if err != nil {
+			d.Log().WithError(err).Fatal("error checking archiver schema")
+		}
+
+		// prepare the queries we run for every archive once, now we know our schema has everything they need
+		if d.Config.PrepareStatements && cmd == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			err = archiver.PrepareStatements(ctx, d.DB, d.Config)
+			cancel()
+			if err != nil {
+				d.Log().WithError(err).Fatal("error preparing statements")
+			}
+		}
+	}
+
+	// our pause table, instance lock and status server are those of our first database
+	db := databases[0].DB
+
+	// if we have a command, run that instead of archiving
+	if cmd != nil {
+		if len(databases) > 1 {
+			logrus.Fatalf("the %s command can only be run against a single database, use db rather than databases", cmd.name)
+		}
+		err = cmd.run(databases[0].Config, db, cmdArgs)
+		if err != nil {
+			logrus.WithError(err).Fatalf("error running %s command", cmd.name)
+		}
+		return
 	}
-	db.SetMaxOpenConns(2)
 
 	var s3Client s3iface.S3API
 	if config.UploadToS3 {
@@ -73,14 +228,174 @@ func main() {
 		}
 	}
 
+	stats, err := archiver.NewStatsd(config)
+	if err != nil {
+		logrus.WithError(err).Fatal("unable to initialize statsd client")
+	}
+	defer stats.Close()
+
+	// publish an event for each archive we commit to Kafka, SQS or SNS, register it with Athena, load it into Snowflake and
+	// index it in Elasticsearch if asked to, whether built by a run or in between them
+	for _, d := range databases {
+		d.Publisher, err = archiver.NewPublisher(d.Config)
+		if err != nil {
+			d.Log().WithError(err).Error("error creating archive event publisher")
+		}
+		d.Sinks = archiver.NewSinks(d.Config, d.Log())
+	}
+
 	// ensure that we can actually write to the temp directory
 	err = archiver.EnsureTempArchiveDirectory(config.TempDir)
 	if err != nil {
 		logrus.WithError(err).Fatal("cannot write to temp directory")
 	}
 
+	// remove any archive files left behind by archivers which were killed or ran out of space, unless we keep them
+	if !config.KeepFiles {
+		removed, size, err := archiver.CleanTempArchiveDirectory(config.TempDir, time.Hour)
+		if err != nil {
+			logrus.WithError(err).Error("error cleaning temp directory")
+		}
+		if removed > 0 {
+			logrus.WithField("files", removed).WithField("size", size).Info("removed orphaned archive files from temp directory")
+		}
+	}
+
+	// each database has its own queue, as the ids of their orgs can be the same
+	if config.RedisURL != "" {
+		redis, err := archiver.NewRedis(config.RedisURL)
+		if err != nil {
+			logrus.WithError(err).Fatal("unable to initialize redis client")
+		}
+		defer redis.Close()
+		for _, d := range databases {
+			d.Queue = archiver.NewTaskQueue(redis, d.QueueName())
+		}
+	}
+
+	archiveTypes, err := config.ArchiveTypes()
+	if err != nil {
+		logrus.WithError(err).Fatal("invalid archive types")
+	}
+	_, err = config.ArchivePeriods()
+	if err != nil {
+		logrus.WithError(err).Fatal("invalid archive periods")
+	}
+
+	// on SIGTERM or SIGINT, stop starting new work, and if in flight work hasn't finished by the end of our grace period,
+	// or we're signalled again, abort it. Aborted archives are never written to the database and their files are removed.
+	drain := make(chan struct{})
+	workCtx, abort := context.WithCancel(context.Background())
+	workCtx = archiver.WithDrain(workCtx, drain, archiver.ErrShuttingDown)
+
+	// on SIGUSR1 stop starting new work until SIGUSR2, as we also do while there are rows in archiver_pause
+	pauser := archiver.NewPauser(db)
+
+	// if we have a read replica, export records from it rather than the primary, writes and deletions still go to the
+	// primary and archives are exported from it when the replica is behind
+	for _, d := range databases {
+		if d.Config.ReplicaDB == "" {
+			continue
+		}
+		d.Replica, err = archiver.OpenDB(d.Config.ReplicaDB, config, config.OrgWorkers*config.ArchiveWorkers+1)
+		if err != nil {
+			d.Log().WithError(err).Fatal("invalid replica db connection string")
+		}
+		defer d.Replica.Close()
+
+		if d.Config.PrepareStatements {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			err = archiver.PrepareReplicaStatements(ctx, d.Replica, d.Config)
+			cancel()
+			if err != nil {
+				d.Log().WithError(err).Fatal("error preparing replica statements")
+			}
+		}
+	}
+
+	status := archiver.NewStatus()
+	trigger := archiver.NewRunTrigger()
+	if config.StatusAddress != "" {
+		// on-demand archive jobs are run against our first database, with the same context and options as our runs, so
+		// they pause, drain, export from the replica and publish events like our runs do, and runs can be requested
+		// early and we can be paused and resumed
+		var jobs *archiver.Jobs
+		var control *archiver.Control
+		if config.StatusToken != "" {
+			jobs = archiver.NewJobs(workCtx, databases[0].Config, db, s3Client, databases[0].Options(pauser))
+			control = archiver.NewControl(config, status, pauser, trigger)
+		}
+
+		server := archiver.NewStatusServer(config.StatusAddress, config, db, s3Client, status, jobs, control)
+		go func() {
+			err := server.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				logrus.WithError(err).Error("error running status server")
+			}
+		}()
+		logrus.WithField("address", config.StatusAddress).Info("status server started")
+	}
+
+	notifyPauseSignals(pauser)
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-signals
+		logrus.WithField("signal", sig.String()).WithField("grace_seconds", config.ShutdownGraceSeconds).Warn("shutting down, finishing in flight archives")
+		close(drain)
+
+		select {
+		case <-signals:
+			logrus.Warn("signalled again, aborting in flight archives")
+		case <-time.After(time.Duration(config.ShutdownGraceSeconds) * time.Second):
+			logrus.Warn("shutdown grace period over, aborting in flight archives")
+		}
+		abort()
+	}()
+
+	// if asked to, wait until we're the only instance running, holding that lock until we exit
+	var instanceLock *archiver.Lock
+	if config.InstanceLock {
+		for {
+			instanceLock, err = archiver.TryLock(context.Background(), db, archiver.InstanceLockKey)
+			if err != nil {
+				logrus.WithError(err).Fatal("error taking instance lock")
+			}
+			if instanceLock != nil {
+				logrus.Info("took instance lock")
+				break
+			}
+
+			if config.Once || config.ExitOnCompletion {
+				logrus.Warn("instance lock held by another archiver, exiting")
+				os.Exit(exitSuccess)
+			}
+			logrus.Warn("instance lock held by another archiver, waiting")
+			select {
+			case <-drain:
+				os.Exit(exitSuccess)
+			case <-time.After(time.Minute):
+			}
+		}
+	}
+
+	// our runner archives each of our databases in turn with pools of workers, we only decide when it runs
+	runner := &archiver.Runner{
+		Databases:    databases,
+		S3Client:     s3Client,
+		Pauser:       pauser,
+		Stats:        stats,
+		Status:       status,
+		Webhook:      webhook,
+		Selection:    orgSelection,
+		ArchiveTypes: archiveTypes,
+		Trigger:      trigger,
+	}
+
 	for {
 		start := time.Now().In(time.UTC)
+		asOf := config.Clock().Now()
 
 		// convert the starttime to time.Time
 		layout := "15:04"
@@ -89,40 +404,48 @@ func main() {
 			logrus.WithError(err).Fatal("invalid start time supplied, format: HH:mm")
 		}
 
-		// get our active orgs
-		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
-		orgs, err := archiver.GetActiveOrgs(ctx, db, config)
-		cancel()
+		// instances sharing a queue identify this run by when it was scheduled, the last start time before now
+		scheduled := time.Date(start.Year(), start.Month(), start.Day(), hour.Hour(), hour.Minute(), 0, 0, time.UTC)
+		if scheduled.After(start) {
+			scheduled = scheduled.AddDate(0, 0, -1)
+		}
+		runKey := scheduled.Format("2006-01-02T15:04")
 
+		// archive each of our databases in turn, if we can't get the orgs of our only database we try again later
+		result, err := runner.Run(workCtx, start, runKey)
 		if err != nil {
+			if config.Once {
+				logrus.WithError(err).Fatal("error getting active orgs")
+			}
 			logrus.WithError(err).Error("error getting active orgs")
 			time.Sleep(time.Minute * 5)
 			continue
 		}
 
-		// for each org, do our export
-		for _, org := range orgs {
-			// no single org should take more than 12 hours
-			ctx, cancel := context.WithTimeout(context.Background(), time.Hour*12)
-			log := logrus.WithField("org", org.Name).WithField("org_id", org.ID)
+		// if we were shut down, anything we didn't get to is a partial failure
+		if archiver.Draining(workCtx) {
+			stats.Close()
 
-			if config.ArchiveMessages {
-				_, _, err = archiver.ArchiveOrg(ctx, time.Now(), config, db, s3Client, org, archiver.MessageType)
-				if err != nil {
-					log.WithError(err).WithField("archive_type", archiver.MessageType).Error("error archiving org messages")
-				}
-			}
-			if config.ArchiveRuns {
-				_, _, err = archiver.ArchiveOrg(ctx, time.Now(), config, db, s3Client, org, archiver.RunType)
-				if err != nil {
-					log.WithError(err).WithField("archive_type", archiver.RunType).Error("error archiving org runs")
-				}
+			if result.ErrorCount > 0 || result.LaggingCount > 0 || !result.Completed {
+				logrus.Warn("shut down before completing run")
+				os.Exit(exitPartialFailure)
 			}
-
-			cancel()
+			logrus.Info("shut down after completing run")
+			os.Exit(exitSuccess)
 		}
 
 		// ok, we did all our work for our orgs, quit if so configured or sleep until the next day
+		if config.Once {
+			stats.Close()
+
+			if result.ErrorCount > 0 || result.LaggingCount > 0 || result.RemainingCount > 0 {
+				logrus.WithField("max_lag_days", config.MaxLagDays).Errorf("%d orgs and types failed, %d lagging behind and %d not archived", result.ErrorCount, result.LaggingCount, result.RemainingCount)
+				os.Exit(exitPartialFailure)
+			}
+			os.Exit(exitSuccess)
+		}
+
+		// exiting on completion predates running once, and always exits successfully once a run is done
 		if config.ExitOnCompletion {
 			break
 		}
@@ -138,11 +461,55 @@ func main() {
 
 		napTime := nextDay.Sub(time.Now().In(time.UTC))
 
-		if napTime > time.Duration(0) {
+		if napTime > time.Duration(0) && config.ContinuousMinutes > 0 {
+			logrus.WithField("next_start", nextDay).WithField("every_minutes", config.ContinuousMinutes).Info("Archiving newly eligible days until next UTC day")
+			// only our first database is archived continuously
+			if !runner.ArchiveIncrementally(workCtx, drain, result.Orgs, asOf, nextDay) {
+				logrus.Info("shut down while archiving incrementally")
+				stats.Close()
+				os.Exit(exitSuccess)
+			}
+		} else if napTime > time.Duration(0) {
 			logrus.WithField("time", napTime).WithField("next_start", nextDay).Info("Sleeping until next UTC day")
-			time.Sleep(napTime)
+			select {
+			case <-drain:
+				logrus.Info("shut down while sleeping")
+				stats.Close()
+				os.Exit(exitSuccess)
+			case <-trigger.C():
+				logrus.Info("run requested, starting early")
+			case <-time.After(napTime):
+			}
 		} else {
 			logrus.WithField("next_start", nextDay).Info("Rebuilding immediately without sleep")
 		}
 	}
 }
+
+// findConfigFile returns the config file we've been asked to load settings from, if any, by flag or environment variable
+func findConfigFile(args []string) string {
+	for i, arg := range args {
+		name := strings.TrimLeft(arg, "-")
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if name == "config-file" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(name, "config-file=") {
+			return strings.TrimPrefix(name, "config-file=")
+		}
+	}
+	return os.Getenv("ARCHIVER_CONFIG_FILE")
+}
+
+// printVersion prints our version and build info, and the RapidPro versions whose schema we support
+func printVersion() {
+	if version == "dev" {
+		if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+			version = info.Main.Version
+		}
+	}
+	fmt.Printf("rp-archiver %s (commit: %s, built: %s, %s)\n", version, commit, date, runtime.Version())
+	fmt.Printf("supports RapidPro %s to %s\n", archiver.MinRapidProVersion, archiver.MaxRapidProVersion)
+}
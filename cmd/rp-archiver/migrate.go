@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "migrate",
+		usage: "",
+		help:  "Applies the archiver's own additions to the database schema which don't already exist. This alters archives_archive so needs a db user which can alter tables, and should be run once after each upgrade before the archiver is started.",
+		run:   runMigrate,
+	})
+}
+
+func runMigrate(config *archiver.Config, db *sqlx.DB, args []string) error {
+	flags := newFlagSet(commands["migrate"])
+	flags.Parse(args)
+
+	applied, err := archiver.MigrateSchema(context.Background(), db)
+	if err != nil {
+		return err
+	}
+
+	for _, addition := range applied {
+		logrus.WithField("addition", addition).Info("applied archiver schema addition")
+	}
+	logrus.WithField("applied", len(applied)).Info("archiver schema migrated")
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "pause",
+		usage: "[status|on|off] [flags]",
+		help:  "Pauses all archivers using this database, which stop starting new work until resumed, letting work in flight finish.",
+		run:   runPause,
+	})
+}
+
+func runPause(config *archiver.Config, db *sqlx.DB, args []string) error {
+	action := "status"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		action, args = args[0], args[1:]
+	}
+
+	flags := newFlagSet(commands["pause"])
+	reason := flags.String("reason", "", "why archivers are being paused")
+	flags.Parse(args)
+
+	ctx := context.Background()
+
+	switch action {
+	case "status":
+		pause, err := archiver.GetArchiverPause(ctx, db)
+		if err != nil {
+			return err
+		}
+		if pause == nil {
+			fmt.Println("not paused")
+		} else {
+			fmt.Printf("paused since %s: %s\n", pause.PausedOn.Format("2006-01-02 15:04:05"), pause.Reason)
+		}
+		return nil
+
+	case "on":
+		if *reason == "" {
+			return fmt.Errorf("missing reason")
+		}
+		_, err := archiver.PauseArchiver(ctx, db, *reason)
+		return err
+
+	case "off":
+		removed, err := archiver.ResumeArchiver(ctx, db)
+		if err != nil {
+			return err
+		}
+		if removed == 0 {
+			fmt.Println("not paused")
+		}
+		return nil
+
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown pause action: %s", action)
+	}
+}
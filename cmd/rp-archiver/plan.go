@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+)
+
+// printPlan prints what a run would do for the orgs we've been asked to archive, which archives it would build and how
+// many records it would delete, without writing files, uploading or modifying the database
+func printPlan(config *archiver.Config, db *sqlx.DB, selection *archiver.OrgSelection) error {
+	archiveTypes, err := config.ArchiveTypes()
+	if err != nil {
+		return err
+	}
+	_, err = config.ArchivePeriods()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	now := config.Clock().Now()
+
+	orgs, err := archiver.GetActiveOrgs(ctx, db, config)
+	if err != nil {
+		return err
+	}
+	orgs, err = archiver.FilterOrgs(ctx, db, selection, orgs)
+	if err != nil {
+		return err
+	}
+	err = archiver.SortOrgs(ctx, db, config, now, orgs, archiveTypes)
+	if err != nil {
+		return err
+	}
+
+	builds, records, deletes := 0, 0, 0
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ORG\tTYPE\tPERIOD\tSTART\tACTION\tRECORDS\tDELETES")
+	for _, org := range orgs {
+		org, err := archiver.ApplyOrgConfig(ctx, db, config, org)
+		if err != nil {
+			w.Flush()
+			return err
+		}
+
+		for _, archiveType := range archiveTypes {
+			planned, err := archiver.PlanOrgArchives(ctx, now, config, db, org, archiveType)
+			if err != nil {
+				w.Flush()
+				return err
+			}
+
+			for _, p := range planned {
+				action := "delete"
+				if p.Rollup {
+					action = "rollup"
+				} else if p.Build {
+					action = "build"
+				}
+				if p.Build {
+					builds++
+					records += p.Records
+				}
+				deletes += p.Deletes
+
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%d\n", org.ID, archiveType, p.Archive.Period, p.Archive.StartDate.Format("2006-01-02"), action, p.Records, p.Deletes)
+			}
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%d orgs, %d archives to build with ~%d records, ~%d records to delete\n", len(orgs), builds, records, deletes)
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "quarantine",
+		usage: "[status|restore] [flags]",
+		help:  "Lists or restores deleted records which are still in quarantine.",
+		run:   runQuarantine,
+	})
+}
+
+func runQuarantine(config *archiver.Config, db *sqlx.DB, args []string) error {
+	action := "status"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		action, args = args[0], args[1:]
+	}
+
+	flags := newFlagSet(commands["quarantine"])
+	orgID := flags.Int("org", 0, "the id of the org to show quarantined records for, defaults to all orgs")
+	archiveID := flags.Int("archive", 0, "the id of the archive whose records should be restored")
+	flags.Parse(args)
+
+	ctx := context.Background()
+
+	switch action {
+	case "status":
+		statuses, err := archiver.GetQuarantineStatus(ctx, db, *orgID)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ARCHIVE\tORG\tTABLE\tRECORDS\tQUARANTINED ON\tEXPIRES ON")
+		for _, s := range statuses {
+			fmt.Fprintf(w, "%d\t%d\t%s\t%d\t%s\t%s\n", s.ArchiveID, s.OrgID, s.Table, s.Count, s.QuarantinedOn.Format("2006-01-02"),
+				s.QuarantinedOn.AddDate(0, 0, config.DeleteQuarantineDays).Format("2006-01-02"))
+		}
+		return w.Flush()
+
+	case "restore":
+		if *archiveID == 0 {
+			return fmt.Errorf("missing archive id")
+		}
+
+		restored, err := archiver.RestoreQuarantinedRecords(ctx, db, *archiveID)
+		if err != nil {
+			return err
+		}
+		logrus.WithField("archive_id", *archiveID).WithField("count", restored).Info("restored quarantined records")
+		return nil
+
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown quarantine action: %s", action)
+	}
+}
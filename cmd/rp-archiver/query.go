@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "query",
+		usage: "--org <id> [--type <message|run>] [--from <date>] [--to <date>] [--dir <dir>] [--format <box|csv|json>] <sql>",
+		help:  "Downloads the archives of an org and runs SQL over them locally with DuckDB, as messages and runs views.",
+		run:   runQuery,
+	})
+}
+
+// the output formats of the DuckDB CLI we support
+var duckdbFormats = map[string]string{"box": "-box", "csv": "-csv", "json": "-json"}
+
+func runQuery(config *archiver.Config, db *sqlx.DB, args []string) error {
+	flags := newFlagSet(commands["query"])
+	orgID := flags.Int("org", 0, "the id of the org to query the archives of")
+	typeName := flags.String("type", "", "the type of archives to query, message or run, defaults to both")
+	from := flags.String("from", "", "the first month (YYYY-MM) or day (YYYY-MM-DD) to query archives for, defaults to all time")
+	to := flags.String("to", "", "the last month or day to query archives for, inclusive, defaults to the from date")
+	dir := flags.String("dir", "", "the directory to download archives to and keep them in, archives already there aren't downloaded again, defaults to a temporary directory")
+	format := flags.String("format", "box", "the format of the results, box, csv or json")
+	duckdb := flags.String("duckdb", "duckdb", "the path of the DuckDB CLI")
+	flags.Parse(args)
+
+	if *orgID == 0 {
+		return fmt.Errorf("missing org id")
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected a single SQL query after the flags")
+	}
+	query := flags.Arg(0)
+
+	formatFlag, found := duckdbFormats[*format]
+	if !found {
+		return fmt.Errorf("unknown output format: %s", *format)
+	}
+	duckdbPath, err := exec.LookPath(*duckdb)
+	if err != nil {
+		return fmt.Errorf("DuckDB CLI not found, install it from https://duckdb.org or pass its path with --duckdb")
+	}
+
+	types := []archiver.ArchiveType{archiver.MessageType, archiver.RunType}
+	if *typeName != "" {
+		archiveType, err := archiver.ParseArchiveType(*typeName)
+		if err != nil {
+			return err
+		}
+		types = []archiver.ArchiveType{archiveType}
+	}
+
+	start, end := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), time.Now().AddDate(1, 0, 0)
+	if *from != "" {
+		start, end, err = parseDateRange(*from, *to)
+		if err != nil {
+			return err
+		}
+	}
+
+	if *dir == "" {
+		*dir, err = ioutil.TempDir(config.TempDir, "query")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(*dir)
+	} else if err := os.MkdirAll(*dir, 0755); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	org, err := archiver.GetOrg(ctx, db, config, *orgID)
+	if err != nil {
+		return err
+	}
+	s3Client, err := archiver.NewS3Client(config)
+	if err != nil {
+		return err
+	}
+
+	files := make(map[archiver.ArchiveType][]string, len(types))
+	for _, archiveType := range types {
+		archives, err := archiver.GetCoveringArchives(ctx, db, org, archiveType, start, end)
+		if err != nil {
+			return err
+		}
+
+		for _, archive := range archives {
+			// archives of periods without records may not have a file
+			if archive.URL == "" || archive.RecordCount == 0 {
+				continue
+			}
+
+			filename, err := downloadFilename(archive, false)
+			if err != nil {
+				return err
+			}
+			filename = filepath.Join(*dir, filename)
+
+			// archive filenames include their hash, so one already downloaded is the same archive
+			if _, err := os.Stat(filename); os.IsNotExist(err) {
+				err = downloadToFile(ctx, s3Client, archive, filename, false)
+				if err != nil {
+					return err
+				}
+				logrus.WithField("archive_id", archive.ID).WithField("file", filename).Debug("downloaded archive")
+			}
+			files[archiveType] = append(files[archiveType], filename)
+		}
+		logrus.WithField("org_id", org.ID).WithField("archive_type", archiveType).WithField("files", len(files[archiveType])).Info("archives ready to query")
+	}
+
+	cmd := exec.Command(duckdbPath, formatFlag)
+	cmd.Stdin = strings.NewReader(archiver.DuckDBScript(files, query))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "restore",
+		usage: "--org <id> --type <message|run> --from <date> [--to <date>] [--schema <name>] [--fail-on-conflict]",
+		help:  "Downloads the archives covering a date range and inserts their records back into the database, or into copies of its tables in a staging schema.",
+		run:   runRestore,
+	})
+}
+
+func runRestore(config *archiver.Config, db *sqlx.DB, args []string) error {
+	flags := newFlagSet(commands["restore"])
+	orgID := flags.Int("org", 0, "the id of the org to restore records for")
+	typeName := flags.String("type", "message", "the type of records to restore, message or run")
+	from := flags.String("from", "", "the first month (YYYY-MM) or day (YYYY-MM-DD) to restore")
+	to := flags.String("to", "", "the last month (YYYY-MM) or day (YYYY-MM-DD) to restore, inclusive")
+	schema := flags.String("schema", "public", "the schema to restore records to, public for the live tables")
+	failOnConflict := flags.Bool("fail-on-conflict", false, "whether to fail rather than skip records whose id already exists")
+	flags.Parse(args)
+
+	archiveType, err := archiver.ParseArchiveType(*typeName)
+	if err != nil {
+		return err
+	}
+	start, end, err := parseDateRange(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	org, err := archiver.GetOrg(ctx, db, config, *orgID)
+	if err != nil {
+		return err
+	}
+
+	archives, err := archiver.GetCoveringArchives(ctx, db, org, archiveType, start, end)
+	if err != nil {
+		return err
+	}
+	if len(archives) == 0 {
+		return fmt.Errorf("no %s archives found for org %d covering %s to %s", archiveType, org.ID, *from, *to)
+	}
+
+	s3Client, err := archiver.NewS3Client(config)
+	if err != nil {
+		return err
+	}
+
+	if org.IsAnon {
+		logrus.WithField("org_id", org.ID).Warn("org is anonymous, URNs and run events weren't archived so can't be restored")
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ARCHIVE\tPERIOD\tSTART\tRECORDS\tRESTORED\tSKIPPED")
+	for _, archive := range archives {
+		result, err := archiver.RestoreArchive(ctx, db, s3Client, archive, *schema, *failOnConflict)
+		if err != nil {
+			w.Flush()
+			return err
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\t%d\n", archive.ID, archive.Period, archive.StartDate.Format("2006-01-02"), result.Records, result.Restored, result.Skipped)
+	}
+	return w.Flush()
+}
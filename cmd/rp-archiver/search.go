@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "search",
+		usage: "--org <id> --type <message|run> --from <date> [--to <date>] [--contact <uuid>] [--urn <urn>]",
+		help:  "Scans the archives covering a date range, writing the records of a contact or URN within it to stdout as JSONL.",
+		run:   runSearch,
+	})
+}
+
+func runSearch(config *archiver.Config, db *sqlx.DB, args []string) error {
+	flags := newFlagSet(commands["search"])
+	orgID := flags.Int("org", 0, "the id of the org to search")
+	typeName := flags.String("type", "message", "the type of archives to search, message or run")
+	from := flags.String("from", "", "the first month (YYYY-MM) or day (YYYY-MM-DD) to search")
+	to := flags.String("to", "", "the last month (YYYY-MM) or day (YYYY-MM-DD) to search, inclusive")
+	contact := flags.String("contact", "", "the UUID of the contact whose records should be written")
+	urn := flags.String("urn", "", "the URN whose messages should be written, ie: tel:+12065551212")
+	flags.Parse(args)
+
+	if *contact == "" && *urn == "" {
+		return fmt.Errorf("missing contact or urn to search for")
+	}
+
+	archiveType, err := archiver.ParseArchiveType(*typeName)
+	if err != nil {
+		return err
+	}
+	start, end, err := parseDateRange(*from, *to)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	org, err := archiver.GetOrg(ctx, db, config, *orgID)
+	if err != nil {
+		return err
+	}
+	if *urn != "" && org.IsAnon {
+		return fmt.Errorf("org %d is anonymous so URNs weren't archived, search by contact instead", org.ID)
+	}
+
+	archives, err := archiver.GetCoveringArchives(ctx, db, org, archiveType, start, end)
+	if err != nil {
+		return err
+	}
+
+	s3Client, err := archiver.NewS3Client(config)
+	if err != nil {
+		return err
+	}
+
+	log := logrus.WithFields(logrus.Fields{
+		"org_id":       org.ID,
+		"archive_type": archiveType,
+		"start_date":   start,
+		"end_date":     end,
+		"archives":     len(archives),
+	})
+	log.Info("starting search")
+	searchStart := time.Now()
+
+	filter := &archiver.SearchFilter{ContactUUID: *contact, URN: *urn, Start: start, End: end}
+	writer := bufio.NewWriter(os.Stdout)
+	matched, err := archiver.SearchArchives(ctx, s3Client, archives, filter, config.TempDir, writer)
+	if err != nil {
+		return err
+	}
+	err = writer.Flush()
+	if err != nil {
+		return err
+	}
+
+	log.WithField("matched", matched).WithField("elapsed", time.Since(searchStart)).Info("completed search")
+	return nil
+}
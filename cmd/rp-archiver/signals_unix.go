@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/sirupsen/logrus"
+)
+
+// notifyPauseSignals pauses the passed in pauser on SIGUSR1 and resumes it on SIGUSR2
+func notifyPauseSignals(pauser *archiver.Pauser) {
+	pauseSignals := make(chan os.Signal, 2)
+	signal.Notify(pauseSignals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range pauseSignals {
+			if sig == syscall.SIGUSR1 {
+				logrus.Warn("pausing on signal")
+				pauser.Pause()
+			} else {
+				logrus.Info("resuming on signal")
+				pauser.Resume()
+			}
+		}
+	}()
+}
@@ -0,0 +1,9 @@
+package main
+
+import (
+	archiver "github.com/nyaruka/rp-archiver"
+)
+
+// notifyPauseSignals does nothing on Windows which has no SIGUSR1 or SIGUSR2, archivers there can only be paused with
+// the pause command
+func notifyPauseSignals(pauser *archiver.Pauser) {}
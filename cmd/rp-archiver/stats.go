@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "stats",
+		usage: "[--org <id>]",
+		help:  "Prints the totals of the archives of each org and type, the records in them, the storage they use, how many days behind they are and the result of the last run.",
+		run:   runStats,
+	})
+}
+
+func runStats(config *archiver.Config, db *sqlx.DB, args []string) error {
+	flags := newFlagSet(commands["stats"])
+	orgID := flags.Int("org", 0, "the id of the org to print stats for, defaults to all active orgs")
+	flags.Parse(args)
+
+	ctx := context.Background()
+	now := config.Clock().Now()
+
+	var orgs []archiver.Org
+	if *orgID != 0 {
+		org, err := archiver.GetOrg(ctx, db, config, *orgID)
+		if err != nil {
+			return err
+		}
+		orgs = []archiver.Org{org}
+	} else {
+		var err error
+		orgs, err = archiver.GetActiveOrgs(ctx, db, config)
+		if err != nil {
+			return err
+		}
+	}
+
+	types, err := config.ArchiveTypes()
+	if err != nil {
+		return err
+	}
+
+	var totalRecords, totalBytes int64
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ORG\tTYPE\tDAILIES\tMONTHLIES\tRECORDS\tDAILY SIZE\tMONTHLY SIZE\tBACKLOG DAYS\tLAST RUN\tRESULT")
+	for _, org := range orgs {
+		stats, err := archiver.GetOrgArchiveStats(ctx, db, org)
+		if err != nil {
+			return err
+		}
+		history, err := archiver.GetOrgRunHistory(ctx, db, org.ID, 10)
+		if err != nil {
+			return err
+		}
+
+		for _, archiveType := range types {
+			lag, err := archiver.GetOrgArchiveLag(ctx, db, now, org, archiveType)
+			if err != nil {
+				return err
+			}
+
+			s := &archiver.ArchiveStats{OrgID: org.ID, ArchiveType: archiveType}
+			for _, typeStats := range stats {
+				if typeStats.ArchiveType == archiveType {
+					s = typeStats
+				}
+			}
+			totalRecords += s.Records
+			totalBytes += s.DailyBytes + s.MonthlyBytes
+
+			lastRun, result := "-", "-"
+			for _, outcome := range history {
+				if outcome.ArchiveType == archiveType {
+					lastRun = outcome.FinishedOn.In(time.UTC).Format("2006-01-02 15:04")
+					result = fmt.Sprintf("%d built, %d failed, %d deleted", outcome.ArchivesCreated, outcome.ArchivesFailed, outcome.ArchivesDeleted)
+					if !outcome.Succeeded {
+						result = "FAILED: " + result
+					}
+					break
+				}
+			}
+
+			fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%d\t%s\t%s\t%d\t%s\t%s\n", org.ID, archiveType, s.Dailies, s.Monthlies, s.Records,
+				formatSize(s.DailyBytes), formatSize(s.MonthlyBytes), lag.Days, lastRun, result)
+		}
+	}
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%d orgs, %d records archived using %s\n", len(orgs), totalRecords, formatSize(totalBytes))
+	return nil
+}
+
+// formatSize formats the passed in number of bytes for humans, ie: 1.5 GB
+func formatSize(bytes int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	size := float64(bytes)
+	unit := 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	return fmt.Sprintf("%.1f %s", size, units[unit])
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	archiver "github.com/nyaruka/rp-archiver"
+	"github.com/sirupsen/logrus"
+)
+
+func init() {
+	registerCommand(&command{
+		name:  "verify",
+		usage: "[flags]",
+		help:  "Downloads archives from S3 and checks their hash, size and record count, flagging any mismatches on the archive.",
+		run:   runVerify,
+	})
+}
+
+func runVerify(config *archiver.Config, db *sqlx.DB, args []string) error {
+	flags := newFlagSet(commands["verify"])
+	orgID := flags.Int("org", 0, "the id of the org to verify archives for, defaults to all orgs")
+	since := flags.String("since", "", "only verify archives created on or after this month (YYYY-MM) or day (YYYY-MM-DD)")
+	flags.Parse(args)
+
+	var sinceDate time.Time
+	if *since != "" {
+		d, _, err := parseDate(*since)
+		if err != nil {
+			return err
+		}
+		sinceDate = d
+	}
+
+	ctx := context.Background()
+
+	s3Client, err := archiver.NewS3Client(config)
+	if err != nil {
+		return err
+	}
+
+	archives, err := archiver.GetArchivesToVerify(ctx, db, *orgID, sinceDate)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ARCHIVE\tORG\tTYPE\tPERIOD\tSTART\tPROBLEMS")
+
+	failed := 0
+	for _, archive := range archives {
+		start := time.Now()
+
+		v := archiver.VerifyArchive(ctx, s3Client, archive)
+		err = archiver.RecordArchiveVerification(ctx, db, v)
+		if err != nil {
+			return err
+		}
+
+		log := logrus.WithFields(logrus.Fields{
+			"archive_id":   archive.ID,
+			"org_id":       archive.OrgID,
+			"archive_type": archive.ArchiveType,
+			"start_date":   archive.StartDate,
+			"period":       archive.Period,
+			"elapsed":      time.Since(start),
+		})
+
+		if v.OK() {
+			log.Debug("archive verified")
+			continue
+		}
+
+		failed++
+		log.WithField("problems", v.Problems).Error("archive failed verification")
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\n", archive.ID, archive.OrgID, archive.ArchiveType, archive.Period,
+			archive.StartDate.Format("2006-01-02"), strings.Join(v.Problems, "; "))
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	logrus.WithField("verified", len(archives)).WithField("failed", failed).Info("verification complete")
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d archives failed verification", failed, len(archives))
+	}
+	return nil
+}
@@ -1,37 +1,176 @@
 package archiver
 
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
 // Config is our top level configuration object
 type Config struct {
-	DB        string `help:"the connection string for our database"`
+	ConfigFile string `help:"the path of a TOML or YAML file to load settings from, defaults to archiver.toml"`
+
+	DB        string `help:"the connection string for our database, can be a file:// or env: reference"`
 	LogLevel  string `help:"the log level, one of error, warn, info, debug"`
-	SentryDSN string `help:"the sentry configuration to log errors to, if any"`
+	LogFormat string `help:"the log format, one of text, json"`
+	SentryDSN string `help:"the sentry configuration to log errors to, if any, can be a file:// or env: reference"`
+
+	Databases string `help:"the path of a JSON file listing RapidPro databases to archive in turn, each with its own S3 prefix, instead of db"`
+
+	DBPassword string `help:"the password for our database, replacing any in the connection string, can be a file:// or env: reference"`
+	ReplicaDB  string `help:"the connection string for a read replica of our database to export records from, can be a file:// or env: reference, disabled if empty"`
+
+	DBMaxOpenConns           int `help:"the maximum number of open connections to the database, 0 to size the pool for our workers"`
+	DBMaxIdleConns           int `help:"the maximum number of idle connections to the database kept open, 0 for the default of 2"`
+	DBConnMaxLifetimeMinutes int `help:"the number of minutes a connection to the database can be reused for, 0 for no limit"`
+
+	ExportTimeoutSeconds int `help:"the statement timeout in seconds of the queries which export the records of each archive, 0 for the database default"`
+	WriteTimeoutSeconds  int `help:"the statement timeout in seconds of the queries which write each archive to the database, 0 for the database default"`
+	DeleteTimeoutSeconds int `help:"the statement timeout in seconds of the queries which delete each batch of archived records, 0 for the database default"`
+
+	BuildDeadlineMinutes  int `help:"the longest in minutes exporting and writing the file of each archive can take before it is abandoned, 0 for the default of 180"`
+	UploadDeadlineMinutes int `help:"the longest in minutes uploading each archive to S3 can take before it is abandoned, 0 for the default of 15"`
+	DeleteDeadlineMinutes int `help:"the longest in minutes deleting the archived records of each archive can take before it is abandoned, 0 for the default of 180"`
 
 	S3Endpoint       string `help:"the S3 endpoint we will write archives to"`
 	S3Region         string `help:"the S3 region we will write archives to"`
 	S3Bucket         string `help:"the S3 bucket we will write archives to"`
 	S3DisableSSL     bool   `help:"whether we disable SSL when accessing S3. Should always be set to False unless you're hosting an S3 compatible service within a secure internal network"`
 	S3ForcePathStyle bool   `help:"whether we force S3 path style. Should generally need to default to False unless you're hosting an S3 compatible service"`
+	S3Prefix         string `help:"the prefix of the keys of all archives in the S3 bucket, if any"`
 
-	AWSAccessKeyID     string `help:"the access key id to use when authenticating S3"`
-	AWSSecretAccessKey string `help:"the secret access key id to use when authenticating S3"`
+	AWSAccessKeyID     string `help:"the access key id to use when authenticating S3, can be a file:// or env: reference"`
+	AWSSecretAccessKey string `help:"the secret access key id to use when authenticating S3, can be a file:// or env: reference"`
 
 	TempDir    string `help:"directory where temporary archive files are written"`
 	KeepFiles  bool   `help:"whether we should keep local archive files after upload (default false)"`
 	UploadToS3 bool   `help:"whether we should upload archive to S3"`
 
-	ArchiveMessages  bool   `help:"whether we should archive messages"`
-	ArchiveRuns      bool   `help:"whether we should archive runs"`
-	RetentionPeriod  int    `help:"the number of days to keep before archiving"`
-	Delete           bool   `help:"whether to delete messages and runs from the db after archival (default false)"`
+	SkipEmptyArchiveFiles bool `help:"whether to record archives of periods without any records in the database only, without building or uploading a file for them (default false)"`
+
+	TempReserveMB int `help:"the megabytes of free space to leave in the temp directory beyond the estimated size of each archive, checked before building it, 0 to not check"`
+
+	ArchiveMessages bool   `help:"whether we should archive messages"`
+	ArchiveRuns     bool   `help:"whether we should archive runs"`
+	Types           string `help:"the types of archives to build, a comma separated list of message and run, defaults to those enabled by archive-messages and archive-runs"`
+	Periods         string `help:"the periods of archives to build, a comma separated list of day and month, defaults to both"`
+	RetentionPeriod int    `help:"the number of days to keep before archiving"`
+	Delete          bool   `help:"whether to delete messages and runs from the db after archival (default false)"`
+	DeleteDryRun    bool   `help:"whether to report the messages and runs which would be deleted, and the archives which would be purged, without deleting or purging them (default false)"`
+	RetentionPolicy string `help:"the path of a JSON file of per org and type retention rules, which can keep records that delete would delete but never delete those it keeps"`
+	Redaction       string `help:"the path of a JSON file of redaction profiles and which orgs they apply to, which drop, mask or hash fields of archived records"`
+	RedactionSalt   string `help:"the secret which fields are hashed with when redacting, combined with the id of each org, can be a file:// or env: reference"`
+
+	PseudonymizeAnonURNs bool `help:"whether to replace the URNs of the messages of anonymous orgs with a hash of them keyed by the redaction salt, rather than leaving them out (default false)"`
+	ScanPII              bool `help:"whether to scan the free text of the records of anonymous orgs for likely PII such as emails, phone numbers and national ids, logging how many records of each archive have any (default false)"`
+
+	SchemaProfile string `help:"the path of a JSON file of the fields included in the records of each archive type, which can exclude fields or include optional ones such as message metadata"`
+
+	DeleteQuarantineDays int `help:"the number of days deleted records are kept in quarantine, where they can be restored from, 0 to delete immediately"`
+
+	ConfirmAbove int  `help:"the number of records which can be deleted or purged for an org and type in a run without confirming with yes, 0 for no limit"`
+	Yes          bool `help:"whether to confirm deleting or purging more records for an org and type than confirm above (default false)"`
+
+	RecordChainHash  bool `help:"whether to record a rolling SHA-256 over the records of each new archive for tamper evidence (default false)"`
+	ValidateArchives bool `help:"whether to re-read and validate every record of each new archive file before it is uploaded (default false)"`
+	WriteManifests   bool `help:"whether to write a manifest.json listing all of its archives to S3 for each org after archiving it (default false)"`
+
+	AthenaDatabase string `help:"the Glue database of the Athena tables of archived messages and runs, whose partitions are written and registered as archives are created, disabled if empty"`
+	AthenaPrefix   string `help:"the folder in our bucket the manifests of the partitions of our Athena tables are written to"`
+
+	ElasticURL    string `help:"the URL of an Elasticsearch cluster to index the records of new archives in, which can include credentials, disabled if empty, can be a file:// or env: reference"`
+	ElasticPrefix string `help:"the prefix of the names of the Elasticsearch indexes records are indexed in, one for each org, type and month"`
+
+	EventsQueueURL string `help:"the URL of an SQS queue to send an event to for each archive once it is committed, disabled if empty"`
+	EventsTopicARN string `help:"the ARN of an SNS topic to publish an event to for each archive once it is committed, disabled if empty"`
+	KafkaBrokers   string `help:"the comma separated host:port addresses of Kafka brokers to publish an event to for each archive once it is committed, disabled if empty"`
+	KafkaTopic     string `help:"the Kafka topic events are published to, keyed by org"`
+	PublishRecords bool   `help:"whether to publish each record of new archives, as well as an event for each archive, to our SQS queue, SNS topic or Kafka topic (default false)"`
+
+	SnowflakeAccount    string `help:"the identifier of the Snowflake account new archives are loaded into, ie: myorg-myaccount, disabled if empty"`
+	SnowflakeUser       string `help:"the Snowflake user archives are loaded as, authenticated with its key pair"`
+	SnowflakePrivateKey string `help:"the PEM encoded, unencrypted, RSA private key of the Snowflake user, can be a file:// or env: reference"`
+	SnowflakeWarehouse  string `help:"the Snowflake warehouse archives are loaded with, the user's default if empty"`
+	SnowflakeDatabase   string `help:"the Snowflake database of the tables archives are loaded into, the user's default if empty"`
+	SnowflakeSchema     string `help:"the Snowflake schema of the tables archives are loaded into, the user's default if empty"`
+	SnowflakeStage      string `help:"the Snowflake external stage over our bucket which archives are loaded from"`
+
+	ArchiveAttachments bool   `help:"whether to copy the attachments of archived messages from the media bucket into our bucket and point their archived records at the copies (default false)"`
+	MediaBucket        string `help:"the S3 bucket the attachments of messages are stored in, which they are copied from when archiving attachments"`
+	MediaURL           string `help:"the URL attachments in the media bucket are served from, defaults to the bucket's S3 URL, attachments elsewhere are left as they are"`
+	AttachmentsPrefix  string `help:"the folder within each org's folder in our bucket that archived attachments are copied to"`
+
+	PurgeDailiesOnRollup bool `help:"whether to purge daily archives from S3 as soon as the monthly archive they were rolled up into is verified, rather than after purge dailies after days (default false)"`
+	PurgeDailiesAfter    int  `help:"the number of days after being rolled up that daily archives are purged from S3, 0 to never purge"`
+	PurgeMonthliesAfter  int  `help:"the number of days after the end of their period that monthly archives are purged from S3, 0 to never purge"`
+
+	StatsdAddress string `help:"the host:port of a StatsD or Datadog agent to send metrics to, if any"`
+	StatsdPrefix  string `help:"the prefix of the names of all metrics sent to StatsD"`
+	StatsdTags    bool   `help:"whether to tag metrics sent to StatsD with org and archive type, using the Datadog extension (default false)"`
+
+	StatusAddress string `help:"the address to serve /health and /status on, ie: :8080, disabled if empty"`
+	StatusToken   string `help:"the bearer token required to queue on-demand archive jobs on /archive of the status server, and to start runs and pause and resume us on /run, /pause and /resume, disabled if empty, can be a file:// or env: reference"`
+	MaxLagDays    int    `help:"the number of days an org can fall behind its newest possible archive before it is reported as an error, 0 for no maximum"`
+	RunSummary    string `help:"where to write a JSON summary at the end of each run, - for stdout, a file path or s3://bucket/key, disabled if empty"`
+	RunHistory    bool   `help:"whether to record each run and its outcome for every org in the archiver_runs and archiver_run_orgs tables (default false)"`
+	WebhookURL    string `help:"the URL to post a JSON payload to after each run and on fatal errors, disabled if empty"`
+	WebhookSecret string `help:"the secret used to sign webhook payloads with HMAC-SHA256, unsigned if empty, can be a file:// or env: reference"`
+
+	EmailReports bool   `help:"whether to email the administrators of each org a monthly report of what was archived and purged (default false)"`
+	EmailFrom    string `help:"the address org reports are sent from"`
+	SMTPServer   string `help:"the host:port of the SMTP server org reports are sent with"`
+	SMTPUsername string `help:"the username to authenticate to the SMTP server with, if any"`
+	SMTPPassword string `help:"the password to authenticate to the SMTP server with, if any, can be a file:// or env: reference"`
+
+	SlowTaskMinutes int `help:"the number of minutes after which a warning is logged for an export query or upload which is still running, 0 to disable"`
+
+	ExportPageSize    int    `help:"the number of records each export query reads, paging through an archive's records in order, 0 to read them all with a single query"`
+	ExportFetchSize   int    `help:"the number of records fetched at a time from the server side cursor each export query is read through, 0 to read them without a cursor"`
+	ExportWithCopy    bool   `help:"whether to export the records of each archive with a single COPY on a read only connection of its own rather than with queries, which is faster for large archives and doesn't page or use a cursor (default false)"`
+	PrepareStatements bool   `help:"whether to prepare the queries run for every archive once on each database connection and reuse them, which poolers in transaction mode such as PgBouncer don't support (default false)"`
+	RecordJSON        string `help:"where the JSON of exported records is built, db to build it in Postgres or go to build it in the archiver from plain columns"`
+
+	MaxExportRate int `help:"the maximum number of records per second each export query reads, 0 for no limit"`
+	MaxDeleteRate int `help:"the maximum number of records per second deleted for each archive, 0 for no limit"`
+
+	Org        string `help:"the id of the org to archive, or a comma separated list of them, can be repeated, all active orgs if empty"`
+	ExcludeOrg string `help:"the id of an org not to archive, or a comma separated list of them, can be repeated"`
+	OrgUUID    string `help:"the UUID of an org to archive, or a comma separated list of them, can be repeated, all active orgs if empty"`
+
+	OrgOrder string `help:"the order orgs are archived in, one of id, backlog (furthest behind first) or the name of a column of orgs_org to order by, highest first"`
+
+	OrgWorkers     int `help:"the number of orgs to archive concurrently, each using up to two database connections"`
+	ArchiveWorkers int `help:"the number of archive files to build and upload concurrently for each org, each using up to two database connections"`
+
+	InstanceLock       bool   `help:"whether to only archive while holding a database lock, so that only one instance runs at a time (default false)"`
+	RedisURL           string `help:"the URL of a Redis server to queue orgs on so that instances started together share a run, ie: redis://localhost:6379/15, disabled if empty, can be a file:// or env: reference"`
+	TaskRetries        int    `help:"the number of times an org which fails is retried at the end of a run, by any instance when queuing orgs on Redis"`
+	TaskTimeoutMinutes int    `help:"the number of minutes after which an org taken from Redis by an instance which stopped archiving it, ie: because it died, is queued again, 0 to never queue them again"`
+
+	ShutdownGraceSeconds int `help:"the number of seconds in flight archives are given to finish after SIGTERM before they are aborted"`
+	OrgBudgetMinutes     int `help:"the number of minutes after which no new archives are started for an org, 0 for no limit"`
+	RunBudgetMinutes     int `help:"the number of minutes after which no new orgs are started in a run, 0 for no limit"`
+
+	ContinuousMinutes int `help:"the number of minutes between checks for newly eligible days to archive while waiting for the next run, 0 to only archive once a day"`
+
 	ExitOnCompletion bool   `help:"whether archiver should exit after completing archiving job (default false)"`
+	Once             bool   `help:"whether to make a single pass over all orgs and exit, with an exit code of 3 if any org failed (default false)"`
+	DryRun           bool   `help:"whether to only print which archives would be built and records deleted, without writing files, uploading or modifying the database (default false)"`
 	StartTime        string `help:"what time archive jobs should run in UTC HH:MM "`
+	Now              string `help:"the date or RFC 3339 time to work out what to archive as of, which then advances as usual, ie: to reconstruct a past run, defaults to the current time"`
+
+	// the retention policy loaded when we were validated, so that it is only read once
+	retentionRules *RetentionPolicy
 }
 
 // NewConfig returns a new default configuration object
 func NewConfig() *Config {
 	config := Config{
-		DB:       "postgres://localhost/archiver_test?sslmode=disable",
-		LogLevel: "info",
+		DB:        "postgres://localhost/archiver_test?sslmode=disable",
+		LogLevel:  "info",
+		LogFormat: "text",
 
 		S3Endpoint:       "https://s3.amazonaws.com",
 		S3Region:         "us-east-1",
@@ -46,13 +185,305 @@ func NewConfig() *Config {
 		KeepFiles:  false,
 		UploadToS3: true,
 
-		ArchiveMessages:  true,
-		ArchiveRuns:      true,
-		RetentionPeriod:  90,
-		Delete:           false,
+		SkipEmptyArchiveFiles: false,
+
+		TempReserveMB: 256,
+
+		ArchiveMessages: true,
+		ArchiveRuns:     true,
+		Types:           "",
+		Periods:         "",
+		RetentionPeriod: 90,
+		Delete:          false,
+		DeleteDryRun:    false,
+
+		DeleteQuarantineDays: 0,
+
+		ConfirmAbove: 0,
+		Yes:          false,
+
+		RecordChainHash:  false,
+		ValidateArchives: false,
+		WriteManifests:   false,
+
+		AthenaPrefix: "athena",
+
+		ElasticPrefix: "archives",
+
+		ArchiveAttachments: false,
+		MediaBucket:        "",
+		MediaURL:           "",
+		AttachmentsPrefix:  "media",
+
+		PurgeDailiesOnRollup: false,
+		PurgeDailiesAfter:    0,
+		PurgeMonthliesAfter:  0,
+
+		StatsdAddress: "",
+		StatsdPrefix:  "archiver",
+		StatsdTags:    false,
+
+		StatusAddress: "",
+		StatusToken:   "",
+		MaxLagDays:    0,
+		RunSummary:    "",
+		RunHistory:    false,
+		WebhookURL:    "",
+		WebhookSecret: "",
+
+		EmailReports: false,
+		EmailFrom:    "",
+		SMTPServer:   "",
+		SMTPUsername: "",
+		SMTPPassword: "",
+
+		SlowTaskMinutes: 30,
+
+		ExportPageSize:    10000,
+		ExportFetchSize:   1000,
+		PrepareStatements: false,
+		RecordJSON:        RecordJSONDB,
+
+		MaxExportRate: 0,
+		MaxDeleteRate: 0,
+
+		Org:        "",
+		ExcludeOrg: "",
+		OrgUUID:    "",
+
+		OrgOrder: "id",
+
+		OrgWorkers:     1,
+		ArchiveWorkers: 1,
+
+		InstanceLock:       false,
+		RedisURL:           "",
+		TaskRetries:        2,
+		TaskTimeoutMinutes: 10,
+
+		ShutdownGraceSeconds: 300,
+		OrgBudgetMinutes:     0,
+		RunBudgetMinutes:     0,
+
+		ContinuousMinutes: 0,
+
 		ExitOnCompletion: false,
+		Once:             false,
+		DryRun:           false,
 		StartTime:        "00:01",
 	}
 
 	return &config
 }
+
+// MinDBConns returns the fewest connections to the database we can work with, each org worker needs one for its lock
+// and one to archive with, and our instance lock needs one more
+func (c *Config) MinDBConns() int {
+	return c.OrgWorkers*2 + 1
+}
+
+// slowTaskDuration returns how long an export query or upload can run before we warn about it, 0 if we never warn
+func (c *Config) slowTaskDuration() time.Duration {
+	return time.Duration(c.SlowTaskMinutes) * time.Minute
+}
+
+// ArchiveTypes returns the types of archives we build, either those listed in our types setting, or those enabled by
+// our archive messages and archive runs settings
+func (c *Config) ArchiveTypes() ([]ArchiveType, error) {
+	types := make([]ArchiveType, 0, 2)
+	if c.Types == "" {
+		if c.ArchiveMessages {
+			types = append(types, MessageType)
+		}
+		if c.ArchiveRuns {
+			types = append(types, RunType)
+		}
+		return types, nil
+	}
+
+	for _, v := range splitList(c.Types) {
+		archiveType, err := ParseArchiveType(v)
+		if err != nil {
+			return nil, err
+		}
+		types = append(types, archiveType)
+	}
+	return types, nil
+}
+
+// ArchivePeriods returns the periods of archives we build, by default both days and months
+func (c *Config) ArchivePeriods() ([]ArchivePeriod, error) {
+	if c.Periods == "" {
+		return []ArchivePeriod{DayPeriod, MonthPeriod}, nil
+	}
+
+	periods := make([]ArchivePeriod, 0, 2)
+	for _, v := range splitList(c.Periods) {
+		period, err := ParseArchivePeriod(v)
+		if err != nil {
+			return nil, err
+		}
+		periods = append(periods, period)
+	}
+	return periods, nil
+}
+
+// s3Prefix returns the prefix of the keys of all archives in our bucket, without leading or trailing slashes
+func (c *Config) s3Prefix() string {
+	return strings.Trim(c.S3Prefix, "/")
+}
+
+// buildsPeriod returns whether we build archives of the passed in period, invalid periods are caught at startup
+func (c *Config) buildsPeriod(period ArchivePeriod) bool {
+	periods, _ := c.ArchivePeriods()
+	for _, p := range periods {
+		if p == period {
+			return true
+		}
+	}
+	return false
+}
+
+// PublishesEvents returns whether we've been configured with an SQS queue, SNS topic or Kafka topic to publish archive
+// events to. Records can't be published without one unless a Publisher is passed in the options of archiving.
+func (c *Config) PublishesEvents() bool {
+	return c.EventsQueueURL != "" || c.EventsTopicARN != "" || c.KafkaBrokers != ""
+}
+
+// Validate checks our settings, returning every problem found with them. This doesn't check that the database or S3
+// are reachable, only that the settings make sense. Our retention policy is loaded as it is checked, and used from then
+// on rather than read again for every org.
+func (c *Config) Validate() []error {
+	problems := make([]error, 0)
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if c.KeepFiles && !c.UploadToS3 {
+		add("cannot delete archives and also not upload to s3")
+	}
+	if c.OrgWorkers < 1 || c.ArchiveWorkers < 1 {
+		add("must have at least one org worker and one archive worker")
+	}
+	if c.DBMaxOpenConns != 0 && c.DBMaxOpenConns < c.MinDBConns() {
+		add("db max open conns must be at least %d for %d org workers, each needs one for its lock and one to archive with, plus one for our instance lock", c.MinDBConns(), c.OrgWorkers)
+	}
+	if c.ExportPageSize < 0 || c.ExportFetchSize < 0 || c.DBMaxIdleConns < 0 || c.DBConnMaxLifetimeMinutes < 0 || c.ExportTimeoutSeconds < 0 || c.WriteTimeoutSeconds < 0 || c.DeleteTimeoutSeconds < 0 {
+		add("db pool settings and statement timeouts can't be negative")
+	}
+	if c.PurgeDailiesOnRollup && c.PurgeDailiesAfter > 0 {
+		add("cannot purge daily archives both on rollup and a number of days after it")
+	}
+	if c.TaskTimeoutMinutes < 0 {
+		add("task timeout can't be negative")
+	}
+	if c.BuildDeadlineMinutes < 0 || c.UploadDeadlineMinutes < 0 || c.DeleteDeadlineMinutes < 0 {
+		add("build, upload and delete deadlines can't be negative")
+	}
+	if c.ArchiveAttachments && (c.MediaBucket == "" || !c.UploadToS3) {
+		add("cannot archive attachments without a media bucket and uploading to s3")
+	}
+	if c.AthenaDatabase != "" && (!c.UploadToS3 || strings.Trim(c.AthenaPrefix, "/") == "") {
+		add("cannot register athena partitions without uploading to s3 and an athena prefix")
+	}
+	if c.ElasticURL != "" && (!c.UploadToS3 || c.ElasticPrefix == "" || strings.ToLower(c.ElasticPrefix) != c.ElasticPrefix) {
+		add("cannot index archives in elasticsearch without uploading to s3 and a lowercase elastic prefix")
+	}
+	publishers := 0
+	for _, configured := range []bool{c.EventsQueueURL != "", c.EventsTopicARN != "", c.KafkaBrokers != ""} {
+		if configured {
+			publishers++
+		}
+	}
+	if publishers > 1 {
+		add("cannot publish archive events to more than one of an SQS queue, an SNS topic and Kafka")
+	}
+	if (c.KafkaBrokers == "") != (c.KafkaTopic == "") {
+		add("cannot publish archive events to kafka without both kafka brokers and a kafka topic")
+	}
+	if c.SnowflakeAccount != "" && (!c.UploadToS3 || c.SnowflakeUser == "" || c.SnowflakePrivateKey == "" || c.SnowflakeStage == "") {
+		add("cannot load archives into snowflake without uploading to s3 and a snowflake user, private key and stage")
+	}
+	if c.StatusToken != "" && c.StatusAddress == "" {
+		add("cannot queue archive jobs without a status address to serve them on")
+	}
+	if c.EmailReports && (c.SMTPServer == "" || c.EmailFrom == "") {
+		add("cannot email org reports without an SMTP server and from address")
+	}
+
+	selection, err := ParseOrgSelection(c)
+	if err != nil {
+		add("invalid org selection: %s", err)
+	} else if !selection.IsEmpty() && c.RedisURL != "" {
+		add("cannot select orgs to archive when sharing runs on Redis")
+	}
+
+	if _, err := NewLogFormatter(c.LogFormat); err != nil {
+		problems = append(problems, err)
+	}
+	if _, err := logrus.ParseLevel(c.LogLevel); err != nil {
+		add("invalid log level '%s'", c.LogLevel)
+	}
+	if strings.Contains(c.DB, "TimeZone") {
+		add("invalid db connection string, do not specify a timezone, archiver always uses UTC")
+	}
+	if strings.Contains(c.ReplicaDB, "TimeZone") {
+		add("invalid replica db connection string, do not specify a timezone, archiver always uses UTC")
+	}
+	if _, err := time.Parse("15:04", c.StartTime); err != nil {
+		add("invalid start time '%s', format: HH:mm", c.StartTime)
+	}
+	if c.Now != "" {
+		if _, err := ParseNow(c.Now); err != nil {
+			add("invalid now '%s', format: 2006-01-02 or 2006-01-02T15:04:05Z", c.Now)
+		}
+	}
+
+	if _, err := c.ArchiveTypes(); err != nil {
+		add("invalid archive types: %s", err)
+	}
+	if _, err := c.ArchivePeriods(); err != nil {
+		add("invalid archive periods: %s", err)
+	}
+	if c.OrgOrder != "" && c.OrgOrder != OrgOrderID && c.OrgOrder != OrgOrderBacklog && !orgOrderFieldRegex.MatchString(c.OrgOrder) {
+		add("invalid org order: %s", c.OrgOrder)
+	}
+	if c.RecordJSON != RecordJSONDB && c.RecordJSON != RecordJSONGo {
+		add("invalid record json '%s', must be db or go", c.RecordJSON)
+	}
+	if c.ExportWithCopy && c.exportsGoJSON() {
+		add("cannot export with copy when building record json in go")
+	}
+	if c.RetentionPolicy != "" {
+		if policy, err := LoadRetentionPolicy(c.RetentionPolicy); err != nil {
+			add("invalid retention policy: %s", err)
+		} else {
+			c.retentionRules = policy
+		}
+	}
+	if c.PseudonymizeAnonURNs && c.RedactionSalt == "" {
+		add("cannot pseudonymize anonymous urns without a redaction salt")
+	}
+	if c.Redaction != "" {
+		if policy, err := LoadRedactionPolicy(c.Redaction); err != nil {
+			add("invalid redaction policy: %s", err)
+		} else if policy.hashes() && c.RedactionSalt == "" {
+			add("cannot hash redacted fields without a redaction salt")
+		}
+	}
+	if c.SchemaProfile != "" {
+		if _, err := LoadSchemaProfile(c.SchemaProfile); err != nil {
+			add("invalid schema profile: %s", err)
+		}
+	}
+	if c.Databases != "" {
+		if _, err := LoadDatabases(c.Databases); err != nil {
+			add("invalid databases: %s", err)
+		}
+		if c.ContinuousMinutes > 0 {
+			add("cannot archive continuously when archiving more than one database")
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,77 @@
+package archiver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigArchiveTypesAndPeriods(t *testing.T) {
+	config := NewConfig()
+
+	types, err := config.ArchiveTypes()
+	assert.NoError(t, err)
+	assert.Equal(t, []ArchiveType{MessageType, RunType}, types)
+
+	config.ArchiveMessages = false
+	types, err = config.ArchiveTypes()
+	assert.NoError(t, err)
+	assert.Equal(t, []ArchiveType{RunType}, types)
+
+	// listing types overrides the archive messages and runs settings
+	config.Types = "msg, runs"
+	types, err = config.ArchiveTypes()
+	assert.NoError(t, err)
+	assert.Equal(t, []ArchiveType{MessageType, RunType}, types)
+
+	config.Types = "msg,session"
+	_, err = config.ArchiveTypes()
+	assert.EqualError(t, err, "invalid archive type 'session', must be message or run")
+
+	periods, err := config.ArchivePeriods()
+	assert.NoError(t, err)
+	assert.Equal(t, []ArchivePeriod{DayPeriod, MonthPeriod}, periods)
+	assert.True(t, config.buildsPeriod(DayPeriod))
+
+	config.Periods = "month"
+	periods, err = config.ArchivePeriods()
+	assert.NoError(t, err)
+	assert.Equal(t, []ArchivePeriod{MonthPeriod}, periods)
+	assert.False(t, config.buildsPeriod(DayPeriod))
+	assert.True(t, config.buildsPeriod(MonthPeriod))
+
+	config.Periods = "day,week"
+	_, err = config.ArchivePeriods()
+	assert.EqualError(t, err, "invalid archive period 'week', must be day or month")
+}
+
+func TestConfigValidate(t *testing.T) {
+	config := NewConfig()
+	assert.Equal(t, 0, len(config.Validate()))
+
+	config.KeepFiles = true
+	config.UploadToS3 = false
+	config.OrgWorkers = 0
+	config.Org = "5,abc"
+	config.LogLevel = "loud"
+	config.DB = "postgres://localhost/temba?TimeZone=Africa/Kigali"
+	config.StartTime = "noon"
+	config.Periods = "year"
+	config.OrgOrder = "created_on; DROP TABLE orgs_org"
+
+	problems := config.Validate()
+	messages := make([]string, len(problems))
+	for i, p := range problems {
+		messages[i] = p.Error()
+	}
+	assert.Equal(t, []string{
+		"cannot delete archives and also not upload to s3",
+		"must have at least one org worker and one archive worker",
+		"invalid org selection: invalid org id: abc",
+		"invalid log level 'loud'",
+		"invalid db connection string, do not specify a timezone, archiver always uses UTC",
+		"invalid start time 'noon', format: HH:mm",
+		"invalid archive periods: invalid archive period 'year', must be day or month",
+		"invalid org order: created_on; DROP TABLE orgs_org",
+	}, messages)
+}
@@ -0,0 +1,160 @@
+package archiver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Settings are loaded from a config file, then environment variables, then command line flags, each overriding the
+// last. TOML config files are loaded by ezconf, YAML ones by us before it, using the same snake case keys, ie:
+// s3_bucket. Secrets can be given as references rather than values, file:///run/secrets/aws_key to read one from a
+// file or env:AWS_SECRET to read one from another environment variable, so they needn't be on the command line.
+
+// IsYAMLConfigFile returns whether the passed in config file is YAML, which we load ourselves, rather than TOML
+func IsYAMLConfigFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// LoadYAMLConfig loads the settings in the passed in YAML file into the passed in config
+func LoadYAMLConfig(config *Config, path string) error {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "error reading config file: %s", path)
+	}
+
+	settings := make(map[string]interface{})
+	err = yaml.Unmarshal(contents, &settings)
+	if err != nil {
+		return errors.Wrapf(err, "error parsing config file: %s", path)
+	}
+
+	fields := make(map[string]reflect.Value)
+	v := reflect.ValueOf(config).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		fields[snakeCase(v.Type().Field(i).Name)] = v.Field(i)
+	}
+
+	for key, value := range settings {
+		field, found := fields[key]
+		if !found {
+			return errors.Errorf("unknown setting '%s' in config file: %s", key, path)
+		}
+
+		ok := false
+		switch field.Kind() {
+		case reflect.String:
+			switch value.(type) {
+			case string, int, float64, bool:
+				field.SetString(fmt.Sprint(value))
+				ok = true
+			}
+		case reflect.Int:
+			if i, isInt := value.(int); isInt {
+				field.SetInt(int64(i))
+				ok = true
+			}
+		case reflect.Bool:
+			if b, isBool := value.(bool); isBool {
+				field.SetBool(b)
+				ok = true
+			}
+		}
+		if !ok {
+			return errors.Errorf("invalid value for setting '%s' in config file: %s, must be a %s", key, path, field.Kind())
+		}
+	}
+	return nil
+}
+
+// snakeCase converts the passed in field name to the key used for it in config files, ie: AWSAccessKeyID becomes
+// aws_access_key_id, the same as its environment variable without the ARCHIVER_ prefix
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// ResolveSecrets replaces any of our secret settings which are references with the values they refer to, and sets
+// the password of our database connection string if it was given separately
+func (c *Config) ResolveSecrets() error {
+	secrets := []struct {
+		name  string
+		value *string
+	}{
+		{"db", &c.DB},
+		{"db-password", &c.DBPassword},
+		{"replica-db", &c.ReplicaDB},
+		{"sentry-dsn", &c.SentryDSN},
+		{"aws-access-key-id", &c.AWSAccessKeyID},
+		{"aws-secret-access-key", &c.AWSSecretAccessKey},
+		{"webhook-secret", &c.WebhookSecret},
+		{"status-token", &c.StatusToken},
+		{"smtp-password", &c.SMTPPassword},
+		{"redis-url", &c.RedisURL},
+		{"redaction-salt", &c.RedactionSalt},
+		{"snowflake-private-key", &c.SnowflakePrivateKey},
+		{"elastic-url", &c.ElasticURL},
+	}
+
+	for _, s := range secrets {
+		resolved, err := resolveSecret(*s.value)
+		if err != nil {
+			return errors.Wrapf(err, "error resolving %s", s.name)
+		}
+		*s.value = resolved
+	}
+
+	if c.DBPassword != "" {
+		dsn, err := url.Parse(c.DB)
+		if err != nil || dsn.Scheme == "" || dsn.User == nil {
+			return errors.New("db password can only be used with a db connection string of the form postgres://user@host/db")
+		}
+		dsn.User = url.UserPassword(dsn.User.Username(), c.DBPassword)
+		c.DB = dsn.String()
+	}
+	return nil
+}
+
+// resolveSecret returns the value of the passed in secret, reading it from a file for file:// references or from
+// another environment variable for env: references
+func resolveSecret(value string) (string, error) {
+	if strings.HasPrefix(value, "file://") {
+		path := strings.TrimPrefix(value, "file://")
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", errors.Wrapf(err, "error reading secret file: %s", path)
+		}
+		return strings.TrimRight(string(contents), "\r\n"), nil
+	}
+
+	if strings.HasPrefix(value, "env:") {
+		name := strings.TrimPrefix(value, "env:")
+		secret, found := os.LookupEnv(name)
+		if !found {
+			return "", errors.Errorf("secret environment variable %s isn't set", name)
+		}
+		return secret, nil
+	}
+
+	return value, nil
+}
@@ -0,0 +1,67 @@
+package archiver
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ErrRemovalNotConfirmed is the cause of the error returned when more records would be deleted or purged for an org
+// than can be without confirmation
+var ErrRemovalNotConfirmed = errors.New("too many records to remove without confirmation")
+
+// confirmRemoval returns an error if the passed in number of records is more than can be deleted or purged for an org
+// and type without confirmation, and we haven't been given it
+func confirmRemoval(config *Config, org Org, archiveType ArchiveType, action string, count int) error {
+	if config.ConfirmAbove <= 0 || config.Yes || count <= config.ConfirmAbove {
+		return nil
+	}
+	return errors.Wrapf(ErrRemovalNotConfirmed, "%d %s records would be %s for org %d, more than %d, run with --yes to confirm", count, archiveType, action, org.ID, config.ConfirmAbove)
+}
+
+// countPendingDeletion returns how many records would be deleted for the passed in org and type, those of the archives
+// needing deletion which are old enough under its retention rule and not under a legal hold
+func countPendingDeletion(ctx context.Context, now time.Time, config *Config, db *sqlx.DB, org Org, archiveType ArchiveType) (int, error) {
+	archives, err := GetArchivesNeedingDeletion(ctx, db, org, archiveType)
+	if err != nil {
+		return 0, err
+	}
+
+	holds, err := GetActiveLegalHolds(ctx, db, org, archiveType)
+	if err != nil {
+		return 0, err
+	}
+
+	rule := RetentionRuleFor(config, org, archiveType)
+	count := 0
+	for _, a := range archives {
+		if rule.eligibleForDeletion(now, a) && heldBy(holds, a) == nil {
+			count += a.RecordCount
+		}
+	}
+	return count, nil
+}
+
+// countPendingPurge returns how many records would be lost by purging archives for the passed in org and type, rolled
+// up dailies don't count as their records are still in their monthly
+func countPendingPurge(ctx context.Context, now time.Time, config *Config, db *sqlx.DB, org Org, archiveType ArchiveType) (int, error) {
+	archives, err := GetArchivesNeedingPurge(ctx, now, config, db, org, archiveType)
+	if err != nil {
+		return 0, err
+	}
+
+	holds, err := GetActiveLegalHolds(ctx, db, org, archiveType)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, a := range archives {
+		if a.Rollup == nil && heldBy(holds, a) == nil {
+			count += a.RecordCount
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,56 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfirmRemoval(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	config.UploadToS3 = false
+	config.Periods = "day"
+	config.Delete = true
+
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	// org 2's existing daily is waiting for its records to be deleted
+	db.MustExec(`UPDATE archives_archive SET record_count = 10 WHERE org_id = 2`)
+
+	count, err := countPendingDeletion(ctx, now, config, db, orgs[1], MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, count)
+
+	// no limit by default
+	assert.NoError(t, confirmRemoval(config, orgs[1], MessageType, "deleted", count))
+
+	config.ConfirmAbove = 5
+	err = confirmRemoval(config, orgs[1], MessageType, "deleted", count)
+	assert.Equal(t, ErrRemovalNotConfirmed, errors.Cause(err))
+	assert.EqualError(t, err, "10 message records would be deleted for org 2, more than 5, run with --yes to confirm: too many records to remove without confirmation")
+
+	// archiving the org fails before deleting anything
+	_, _, err = ArchiveOrg(ctx, now, config, db, nil, orgs[1], MessageType, nil)
+	assert.Equal(t, ErrRemovalNotConfirmed, errors.Cause(err))
+
+	needing, err := GetArchivesNeedingDeletion(ctx, db, orgs[1], MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(needing))
+
+	// unless we confirm it
+	config.Yes = true
+	assert.NoError(t, confirmRemoval(config, orgs[1], MessageType, "deleted", count))
+
+	// or are under the limit
+	config.Yes = false
+	config.ConfirmAbove = 10
+	assert.NoError(t, confirmRemoval(config, orgs[1], MessageType, "deleted", count))
+}
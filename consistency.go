@@ -0,0 +1,197 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// IssueKind is the kind of inconsistency found in an org's archives
+type IssueKind string
+
+const (
+	// IssueGap is a range of days not covered by any archive
+	IssueGap = IssueKind("gap")
+
+	// IssueOverlap is an archive whose period is also covered by another archive
+	IssueOverlap = IssueKind("overlap")
+
+	// IssueMissingDailies is a rolled up monthly archive which is missing some of its dailies
+	IssueMissingDailies = IssueKind("missing_dailies")
+
+	// IssueMissingObject is an archive whose S3 object can't be found
+	IssueMissingObject = IssueKind("missing_object")
+
+	// IssueBoundaryRecord is a record exactly on a day boundary which is in no archive or in more than one
+	IssueBoundaryRecord = IssueKind("boundary_record")
+
+	// IssueOutOfRange is an archive containing records whose timestamps fall outside of its period
+	IssueOutOfRange = IssueKind("out_of_range")
+
+	// IssueAPIIncompatible is an archive which RapidPro's archives API can't list or link to as it expects
+	IssueAPIIncompatible = IssueKind("api_incompatible")
+)
+
+// ConsistencyIssue is a single problem found with the archives for an org and type
+type ConsistencyIssue struct {
+	OrgID       int
+	ArchiveType ArchiveType
+	Kind        IssueKind
+	ArchiveID   int
+	StartDate   time.Time
+	Detail      string
+}
+
+func (i *ConsistencyIssue) String() string {
+	return fmt.Sprintf("org %d %s %s on %s: %s", i.OrgID, i.ArchiveType, i.Kind, i.StartDate.Format("2006-01-02"), i.Detail)
+}
+
+const lookupOverlappingArchives = `
+SELECT a.id, a.start_date::timestamp with time zone as start_date, a.period, o.id as other_id, o.period as other_period
+FROM archives_archive a
+JOIN archives_archive o ON o.org_id = a.org_id AND o.archive_type = a.archive_type AND o.id != a.id
+WHERE a.org_id = $1 AND a.archive_type = $2 AND (
+	-- the same period archived twice
+	(o.period = a.period AND o.start_date = a.start_date AND o.id < a.id) OR
+	-- a daily covered by a monthly it wasn't rolled up into
+	(a.period = 'D' AND o.period = 'M' AND a.start_date >= o.start_date AND a.start_date < o.start_date + '1 month'::interval AND 
+		a.rollup_id IS DISTINCT FROM o.id)
+)
+ORDER BY a.start_date, a.id
+`
+
+const lookupIncompleteRollups = `
+SELECT m.id, m.start_date::timestamp with time zone as start_date, array_agg(to_char(d.start_date, 'YYYY-MM-DD') ORDER BY d.start_date) as days
+FROM archives_archive m
+JOIN archives_archive d ON d.rollup_id = m.id
+WHERE m.org_id = $1 AND m.archive_type = $2 AND m.period = 'M'
+GROUP BY m.id, m.start_date
+ORDER BY m.start_date
+`
+
+const lookupArchivesOnS3 = selectArchiveFields + `
+WHERE org_id = $1 AND archive_type = $2 AND url != '' AND purged_on IS NULL
+ORDER BY start_date, period desc
+`
+
+// CheckOrgConsistency scans the archives for the passed in org and type, returning any gaps in coverage, periods
+// covered by more than one archive, and rolled up monthlies which are missing dailies. If an S3 client is passed in,
+// the S3 object of each archive is also checked to exist.
+func CheckOrgConsistency(ctx context.Context, now time.Time, db *sqlx.DB, s3Client s3iface.S3API, org Org, archiveType ArchiveType) ([]*ConsistencyIssue, error) {
+	issues := make([]*ConsistencyIssue, 0)
+	newIssue := func(kind IssueKind, archiveID int, startDate time.Time, detail string, args ...interface{}) {
+		issues = append(issues, &ConsistencyIssue{
+			OrgID:       org.ID,
+			ArchiveType: archiveType,
+			Kind:        kind,
+			ArchiveID:   archiveID,
+			StartDate:   startDate,
+			Detail:      fmt.Sprintf(detail, args...),
+		})
+	}
+
+	// first the days we should have archived but haven't
+	orgUTC := org.CreatedOn.In(time.UTC)
+	firstDay := time.Date(orgUTC.Year(), orgUTC.Month(), orgUTC.Day(), 0, 0, 0, 0, time.UTC)
+	lastDay := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -org.RetentionPeriod)
+
+	gaps, err := CoverageGaps(ctx, db, org, archiveType, DateRange{Start: firstDay, End: lastDay.AddDate(0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	for _, gap := range gaps {
+		newIssue(IssueGap, 0, gap.Start, "%d days not archived until %s", gap.Days(), gap.End.Format("2006-01-02"))
+	}
+
+	// then periods covered by more than one archive
+	rows, err := db.QueryxContext(ctx, lookupOverlappingArchives, org.ID, archiveType)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up overlapping archives for org: %d", org.ID)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var archiveID, otherID int
+		var startDate time.Time
+		var period, otherPeriod ArchivePeriod
+		err = rows.Scan(&archiveID, &startDate, &period, &otherID, &otherPeriod)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error scanning overlapping archive for org: %d", org.ID)
+		}
+
+		if period == otherPeriod {
+			newIssue(IssueOverlap, archiveID, startDate, "archive %d duplicates archive %d", archiveID, otherID)
+		} else {
+			newIssue(IssueOverlap, archiveID, startDate, "daily archive %d is covered by monthly archive %d but not rolled up into it", archiveID, otherID)
+		}
+	}
+	rows.Close()
+
+	// then rolled up monthlies which don't have all their dailies
+	rows, err = db.QueryxContext(ctx, lookupIncompleteRollups, org.ID, archiveType)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up rolled up archives for org: %d", org.ID)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var archiveID int
+		var startDate time.Time
+		var days pq.StringArray
+		err = rows.Scan(&archiveID, &startDate, &days)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error scanning rolled up archive for org: %d", org.ID)
+		}
+
+		missing := missingRollupDays(startDate, firstDay, days)
+		if len(missing) > 0 {
+			newIssue(IssueMissingDailies, archiveID, startDate, "rolled up monthly archive %d is missing %d dailies, first %s",
+				archiveID, len(missing), missing[0].Format("2006-01-02"))
+		}
+	}
+	rows.Close()
+
+	// finally, check that all our S3 objects still exist
+	if s3Client != nil {
+		archives := make([]*Archive, 0)
+		err = db.SelectContext(ctx, &archives, lookupArchivesOnS3, org.ID, archiveType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error looking up archives on S3 for org: %d", org.ID)
+		}
+
+		for _, a := range archives {
+			_, err := GetS3FileETAG(ctx, s3Client, a.URL)
+			if err != nil {
+				newIssue(IssueMissingObject, a.ID, a.StartDate, "unable to find %s: %s", a.URL, err.Error())
+			}
+		}
+	}
+
+	return issues, nil
+}
+
+// missingRollupDays returns the days of the month starting on the passed in date, not before the passed in first day,
+// which are not in the passed in list of YYYY-MM-DD days
+func missingRollupDays(month time.Time, firstDay time.Time, days []string) []time.Time {
+	have := make(map[string]bool, len(days))
+	for _, d := range days {
+		have[d] = true
+	}
+
+	month = month.In(time.UTC)
+	missing := make([]time.Time, 0)
+	for day := month; day.Before(month.AddDate(0, 1, 0)); day = day.AddDate(0, 0, 1) {
+		if day.Before(firstDay) {
+			continue
+		}
+		if !have[day.Format("2006-01-02")] {
+			missing = append(missing, day)
+		}
+	}
+	return missing
+}
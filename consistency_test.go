@@ -0,0 +1,71 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckOrgConsistency(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+	config := NewConfig()
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	org, err := GetOrg(ctx, db, config, 3)
+	assert.NoError(t, err)
+
+	issues, err := CheckOrgConsistency(ctx, now, db, nil, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(issues))
+
+	// the rest of august and the start of october haven't been archived
+	assert.Equal(t, IssueGap, issues[0].Kind)
+	assert.Equal(t, time.Date(2017, 8, 11, 0, 0, 0, 0, time.UTC), issues[0].StartDate)
+	assert.Equal(t, "21 days not archived until 2017-09-01", issues[0].Detail)
+	assert.Equal(t, IssueGap, issues[1].Kind)
+	assert.Equal(t, time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC), issues[1].StartDate)
+
+	// and our september daily is covered by our september monthly without being rolled up into it
+	assert.Equal(t, IssueOverlap, issues[2].Kind)
+	assert.Equal(t, 2, issues[2].ArchiveID)
+	assert.Equal(t, "daily archive 2 is covered by monthly archive 3 but not rolled up into it", issues[2].Detail)
+
+	// roll it up, but now our monthly is missing the rest of its dailies
+	db.MustExec(`UPDATE archives_archive SET rollup_id = 3 WHERE id = 2`)
+
+	// and archive one of our days twice
+	db.MustExec(`INSERT INTO archives_archive(archive_type, created_on, start_date, period, record_count, size, hash, url, needs_deletion, build_time, org_id) 
+	VALUES('message', '2017-08-11', '2017-08-10', 'D', 0, 0, '', '', TRUE, 0, 3)`)
+
+	issues, err = CheckOrgConsistency(ctx, now, db, nil, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(issues))
+	assert.Equal(t, IssueOverlap, issues[2].Kind)
+	assert.Equal(t, 5, issues[2].ArchiveID)
+	assert.Equal(t, "archive 5 duplicates archive 1", issues[2].Detail)
+	assert.Equal(t, IssueMissingDailies, issues[3].Kind)
+	assert.Equal(t, 3, issues[3].ArchiveID)
+	assert.Equal(t, "rolled up monthly archive 3 is missing 29 dailies, first 2017-09-01", issues[3].Detail)
+
+	// runs haven't been archived at all
+	issues, err = CheckOrgConsistency(ctx, now, db, nil, org, RunType)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(issues))
+	assert.Equal(t, "62 days not archived until 2017-10-11", issues[0].Detail)
+}
+
+func TestMissingRollupDays(t *testing.T) {
+	month := time.Date(2017, 2, 1, 0, 0, 0, 0, time.UTC)
+	days := []string{"2017-02-01", "2017-02-02", "2017-02-04"}
+
+	missing := missingRollupDays(month, month, days)
+	assert.Equal(t, 25, len(missing))
+	assert.Equal(t, time.Date(2017, 2, 3, 0, 0, 0, 0, time.UTC), missing[0])
+
+	// days before our first day don't count
+	missing = missingRollupDays(month, time.Date(2017, 2, 27, 0, 0, 0, 0, time.UTC), days)
+	assert.Equal(t, 2, len(missing))
+}
@@ -0,0 +1,134 @@
+package archiver
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// the contact fields which a schema profile can include in the contact of each message and run record
+const (
+	contactLanguageField  = "contact.language"
+	contactGroupsField    = "contact.groups"
+	contactCreatedOnField = "contact.created_on"
+)
+
+// archiveContact is the contact of records in an archive, with the fields we can add to their records
+type archiveContact struct {
+	UUID      string    `db:"uuid"`
+	Language  *string   `db:"language"`
+	CreatedOn time.Time `db:"created_on"`
+	Groups    string    `db:"groups"`
+}
+
+const selectArchiveContacts = `
+SELECT cc.uuid, cc.language, cc.created_on, coalesce((
+	SELECT jsonb_agg(jsonb_build_object('uuid', g.uuid, 'name', g.name) ORDER BY g.id)
+	FROM contacts_contactgroup_contacts gc JOIN contacts_contactgroup g ON g.id = gc.contactgroup_id
+	WHERE gc.contact_id = cc.id
+), '[]'::jsonb)::text AS groups
+FROM contacts_contact cc
+`
+
+const lookupMessageContacts = selectArchiveContacts + `
+WHERE cc.id IN (SELECT DISTINCT contact_id FROM msgs_msg mm WHERE mm.org_id = $1 AND mm.created_on >= $2 AND mm.created_on < $3)
+`
+
+const lookupRunContacts = selectArchiveContacts + `
+WHERE cc.id IN (SELECT DISTINCT contact_id FROM flows_flowrun fr WHERE fr.org_id = $1 AND fr.modified_on >= $2 AND fr.modified_on < $3)
+`
+
+// contactFields returns the contact fields this profile includes in records of the passed in type
+func (p SchemaProfile) contactFields(archiveType ArchiveType) []string {
+	fields := make([]string, 0, 3)
+	for _, field := range []string{contactLanguageField, contactGroupsField, contactCreatedOnField} {
+		if p.includes(archiveType, field) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// contactEnricher returns a function which adds the contact fields this profile includes to records of the passed in
+// archive, nil if it doesn't include any. The contacts of every record in the archive's period are loaded up front, as
+// they are at the time it is built, so this must be called with the same snapshot as our export query.
+func (p SchemaProfile) contactEnricher(ctx context.Context, db sqlx.ExtContext, archive *Archive) (func(string) (string, error), error) {
+	fields := p.contactFields(archive.ArchiveType)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	query := lookupMessageContacts
+	if archive.ArchiveType == RunType {
+		query = lookupRunContacts
+	}
+
+	rows, err := db.QueryxContext(ctx, query, archive.Org.ID, archive.StartDate, archive.endDate())
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up contacts of archive")
+	}
+	defer rows.Close()
+
+	contacts := make(map[string]*archiveContact)
+	for rows.Next() {
+		contact := &archiveContact{}
+		err = rows.StructScan(contact)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error scanning contact of archive")
+		}
+		contacts[contact.UUID] = contact
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "error looking up contacts of archive")
+	}
+
+	return func(record string) (string, error) {
+		var ref struct {
+			Contact *struct {
+				UUID string `json:"uuid"`
+			} `json:"contact"`
+		}
+		err := json.Unmarshal([]byte(record), &ref)
+		if err != nil {
+			return "", errors.Wrapf(err, "error reading contact of record")
+		}
+		if ref.Contact == nil {
+			return record, nil
+		}
+
+		contact := contacts[ref.Contact.UUID]
+		for _, field := range fields {
+			record, err = withField(record, field, contact.value(field))
+			if err != nil {
+				return "", err
+			}
+		}
+		return record, nil
+	}, nil
+}
+
+// value returns the JSON of the passed in field of this contact, nil if we don't have it
+func (c *archiveContact) value(field string) *string {
+	if c == nil {
+		return nil
+	}
+
+	var value string
+	switch field {
+	case contactLanguageField:
+		if c.Language == nil {
+			return nil
+		}
+		value = jsonbString(*c.Language)
+	case contactGroupsField:
+		value = c.Groups
+	case contactCreatedOnField:
+		value = jsonbString(formatJSONTime(c.CreatedOn))
+	default:
+		return nil
+	}
+	return &value
+}
@@ -0,0 +1,80 @@
+package archiver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// Control lets a run be started before its scheduled time and us be paused and resumed over HTTP, on /run, /pause and
+// /resume, all requiring our status token
+type Control struct {
+	config  *Config
+	status  *Status
+	pauser  *Pauser
+	trigger *RunTrigger
+}
+
+// ControlPause is the JSON representation of whether we are paused, as returned by /pause and /resume
+type ControlPause struct {
+	Paused bool   `json:"paused"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// NewControl creates a new control which starts runs with the passed in trigger, refusing to while the passed in
+// status has a run in progress, and pauses and resumes the passed in pauser
+func NewControl(config *Config, status *Status, pauser *Pauser, trigger *RunTrigger) *Control {
+	return &Control{config: config, status: status, pauser: pauser, trigger: trigger}
+}
+
+// ServeHTTP requests a run when posted to /run, pauses us when posted to /pause, with an optional reason, and resumes
+// us when posted to /resume. Pauses in the database still apply once resumed, and only pause this instance.
+func (c *Control) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, c.config) {
+		return
+	}
+
+	switch {
+	case r.URL.Path == "/run" && r.Method == http.MethodPost:
+		if c.status.Report().Running {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "a run is already in progress"})
+			return
+		}
+		if !c.trigger.Trigger() {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "a run has already been requested"})
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]bool{"requested": true})
+
+	case r.URL.Path == "/pause" && r.Method == http.MethodGet:
+		c.writePause(w, r)
+
+	case r.URL.Path == "/pause" && r.Method == http.MethodPost:
+		request := &struct {
+			Reason string `json:"reason"`
+		}{}
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 10000)).Decode(request)
+		if err != nil && err != io.EOF {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: " + err.Error()})
+			return
+		}
+		if request.Reason == "" {
+			request.Reason = "paused over http"
+		}
+		c.pauser.PauseFor(request.Reason)
+		c.writePause(w, r)
+
+	case r.URL.Path == "/resume" && r.Method == http.MethodPost:
+		c.pauser.Resume()
+		c.writePause(w, r)
+
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// writePause writes whether we are paused, and why
+func (c *Control) writePause(w http.ResponseWriter, r *http.Request) {
+	paused, reason := c.pauser.Paused(r.Context())
+	writeJSON(w, http.StatusOK, &ControlPause{Paused: paused, Reason: reason})
+}
@@ -0,0 +1,76 @@
+package archiver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControl(t *testing.T) {
+	config := NewConfig()
+	config.StatusToken = "sesame"
+	status := NewStatus()
+	pauser := NewPauser(nil)
+	trigger := NewRunTrigger()
+	control := NewControl(config, status, pauser, trigger)
+
+	serve := func(method string, path string, token string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		control.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	// nothing can be done without our token
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/run", "", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/pause", "open", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/resume", "", "").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serve("GET", "/run", "sesame", "").Code)
+
+	// requesting a run triggers it, once until it starts
+	recorder := serve("POST", "/run", "sesame", "")
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+	assert.Equal(t, "{\"requested\":true}\n", recorder.Body.String())
+
+	recorder = serve("POST", "/run", "sesame", "")
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	assert.Equal(t, "{\"error\":\"a run has already been requested\"}\n", recorder.Body.String())
+
+	<-trigger.C()
+
+	// and not while a run is in progress
+	status.StartRun(2)
+	recorder = serve("POST", "/run", "sesame", "")
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	assert.Equal(t, "{\"error\":\"a run is already in progress\"}\n", recorder.Body.String())
+	status.FinishRun(MessageType)
+
+	// pausing with and without a reason
+	recorder = serve("GET", "/pause", "sesame", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "{\"paused\":false}\n", recorder.Body.String())
+
+	recorder = serve("POST", "/pause", "sesame", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "{\"paused\":true,\"reason\":\"paused over http\"}\n", recorder.Body.String())
+
+	recorder = serve("POST", "/pause", "sesame", `{"reason": "vacuuming msgs_msg"}`)
+	assert.Equal(t, "{\"paused\":true,\"reason\":\"vacuuming msgs_msg\"}\n", recorder.Body.String())
+	paused, reason := pauser.Paused(context.Background())
+	assert.True(t, paused)
+	assert.Equal(t, "vacuuming msgs_msg", reason)
+
+	assert.Equal(t, http.StatusBadRequest, serve("POST", "/pause", "sesame", `{"reason": `).Code)
+
+	// and resuming
+	recorder = serve("POST", "/resume", "sesame", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "{\"paused\":false}\n", recorder.Body.String())
+}
@@ -0,0 +1,151 @@
+package archiver
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+var copySourcesMutex sync.RWMutex
+var copySources = make(map[*sqlx.DB]string)
+
+// RegisterCopySource records the connection string of the passed in database, so that records can be exported from it
+// with COPY, which needs a connection of its own as lib/pq can't read what COPY sends. OpenDB registers every database
+// it opens.
+func RegisterCopySource(db *sqlx.DB, dsn string) {
+	copySourcesMutex.Lock()
+	copySources[db] = dsn
+	copySourcesMutex.Unlock()
+}
+
+// dialCopy opens a connection to copy the records of archives from the passed in database, which is read only and has
+// the export timeout of the passed in config
+func dialCopy(ctx context.Context, db *sqlx.DB, config *Config) (*pgConn, error) {
+	copySourcesMutex.RLock()
+	dsn, found := copySources[db]
+	copySourcesMutex.RUnlock()
+
+	if !found {
+		return nil, fmt.Errorf("cannot export with copy from a database which wasn't opened with OpenDB or registered with RegisterCopySource")
+	}
+
+	params := map[string]string{"default_transaction_read_only": "on"}
+	if config.ExportTimeoutSeconds > 0 {
+		params["statement_timeout"] = strconv.Itoa(config.ExportTimeoutSeconds * 1000)
+	}
+
+	conn, err := dialPG(ctx, dsn, params)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error opening copy connection")
+	}
+	return conn, nil
+}
+
+// copySQL returns the COPY which writes out what the passed in query returns with the passed in parameters, which are
+// written into it as COPY can't take any
+func copySQL(query string, params []interface{}) (string, error) {
+	query = strings.TrimRight(strings.TrimSpace(query), ";")
+
+	// replace from the last so that $1 doesn't match the start of $10
+	for i := len(params); i > 0; i-- {
+		literal, err := copyLiteral(params[i-1])
+		if err != nil {
+			return "", errors.Wrapf(err, "error writing parameter $%d", i)
+		}
+		query = strings.Replace(query, fmt.Sprintf("$%d", i), literal, -1)
+	}
+	return fmt.Sprintf("COPY (%s) TO STDOUT", query), nil
+}
+
+// copyLiteral returns the SQL literal of the passed in export query parameter, which are only ever numbers, booleans,
+// times or nulls so never need quoting
+func copyLiteral(param interface{}) (string, error) {
+	switch p := param.(type) {
+	case nil:
+		return "NULL", nil
+	case bool:
+		return strconv.FormatBool(p), nil
+	case int:
+		return strconv.Itoa(p), nil
+	case int64:
+		return strconv.FormatInt(p, 10), nil
+	case time.Time:
+		return fmt.Sprintf("'%s'::timestamptz", p.UTC().Format(time.RFC3339Nano)), nil
+	}
+	return "", fmt.Errorf("unsupported parameter type: %T", param)
+}
+
+// copyMessageRecords writes the messages in the archive's date range to the passed in writer, reading them with a single
+// COPY over the passed in connection
+func copyMessageRecords(ctx context.Context, db sqlx.ExtContext, conn *pgConn, archive *Archive, serialize func(string) (string, error), writer *bufio.Writer, progress *progressLogger, pace *pacer) (int, error) {
+	write, err := messageRecordWriter(ctx, db, archive, serialize, writer, progress, pace)
+	if err != nil {
+		return 0, err
+	}
+
+	query, err := copySQL(exportQuery(archive, false))
+	if err != nil {
+		return 0, errors.Wrapf(err, "error building message copy for org: %d", archive.Org.ID)
+	}
+
+	recordCount := 0
+	err = conn.copyOut(ctx, query, func(fields []*string) error {
+		if len(fields) != 5 || fields[0] == nil || fields[3] == nil {
+			return fmt.Errorf("invalid message row for org: %d", archive.Org.ID)
+		}
+		written, err := write(*fields[0], *fields[3], fields[4])
+		recordCount += written
+		return err
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "error copying messages for org: %d", archive.Org.ID)
+	}
+	return recordCount, nil
+}
+
+// copyRunRecords writes the runs in the archive's date range to the passed in writer, reading them with a single COPY
+// over the passed in connection
+func copyRunRecords(ctx context.Context, db sqlx.ExtContext, conn *pgConn, archive *Archive, serialize func(string) (string, error), writer *bufio.Writer, progress *progressLogger, pace *pacer) (int, error) {
+	write, err := runRecordWriter(ctx, db, archive, serialize, writer, progress, pace)
+	if err != nil {
+		return 0, err
+	}
+
+	query, err := copySQL(exportQuery(archive, false))
+	if err != nil {
+		return 0, errors.Wrapf(err, "error building run copy for org: %d", archive.Org.ID)
+	}
+
+	recordCount := 0
+	err = conn.copyOut(ctx, query, func(fields []*string) error {
+		if len(fields) != 4 || fields[3] == nil {
+			return fmt.Errorf("invalid run row for org: %d", archive.Org.ID)
+		}
+		written, err := write(*fields[3], fields[0] != nil)
+		recordCount += written
+		return err
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "error copying runs for org: %d", archive.Org.ID)
+	}
+	return recordCount, nil
+}
+
+// CheckCopy checks that records can be exported with COPY from the database with the passed in connection string, which
+// needs a connection of its own to it
+func CheckCopy(ctx context.Context, dsn string) error {
+	conn, err := dialPG(ctx, UTCConnectionString(dsn), map[string]string{"default_transaction_read_only": "on"})
+	if err != nil {
+		return errors.Wrapf(err, "error opening copy connection")
+	}
+	defer conn.Close()
+
+	return conn.copyOut(ctx, "COPY (SELECT 1) TO STDOUT", func([]*string) error { return nil })
+}
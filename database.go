@@ -0,0 +1,96 @@
+package archiver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Database is one of the RapidPro databases we archive in turn when archiving more than one, each has its own prefix
+// in our bucket so that the archives of orgs with the same id in different databases don't collide. It is loaded from
+// a JSON file such as:
+//
+//	[
+//	  {"name": "eu", "db": "postgres://archiver@eu-db/temba", "s3_prefix": "eu"},
+//	  {"name": "us", "db": "file:///run/secrets/us_db", "replica_db": "env:US_REPLICA_DB", "s3_prefix": "us"}
+//	]
+type Database struct {
+	Name      string `json:"name"`
+	DB        string `json:"db"`
+	ReplicaDB string `json:"replica_db"`
+	S3Prefix  string `json:"s3_prefix"`
+}
+
+var databaseNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_\-]*$`)
+
+// LoadDatabases loads and validates the databases in the passed in file, resolving any references to secrets
+func LoadDatabases(filename string) ([]*Database, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading databases file: %s", filename)
+	}
+
+	databases := make([]*Database, 0)
+	err = json.Unmarshal(contents, &databases)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing databases file: %s", filename)
+	}
+	if len(databases) == 0 {
+		return nil, errors.Errorf("no databases in databases file: %s", filename)
+	}
+
+	names := make(map[string]bool)
+	prefixes := make(map[string]bool)
+	for _, d := range databases {
+		if !databaseNameRegex.MatchString(d.Name) {
+			return nil, errors.Errorf("invalid database name '%s', must be lowercase letters, numbers, - and _", d.Name)
+		}
+		if names[d.Name] {
+			return nil, errors.Errorf("duplicate database name '%s'", d.Name)
+		}
+		names[d.Name] = true
+
+		if d.DB == "" {
+			return nil, errors.Errorf("missing db connection string for database '%s'", d.Name)
+		}
+
+		d.DB, err = resolveSecret(d.DB)
+		if err == nil {
+			d.ReplicaDB, err = resolveSecret(d.ReplicaDB)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "error resolving connection string for database '%s'", d.Name)
+		}
+
+		// the prefixes of databases can't be shared or the archives of their orgs could collide
+		if prefixes[d.S3Prefix] {
+			return nil, errors.Errorf("database '%s' has the same s3 prefix as another, each must have its own", d.Name)
+		}
+		prefixes[d.S3Prefix] = true
+	}
+
+	return databases, nil
+}
+
+// ConfigFor returns a copy of the passed in config for archiving this database, our prefix is within any prefix the
+// config already has
+func (d *Database) ConfigFor(config *Config) *Config {
+	c := *config
+	c.DB = d.DB
+	c.ReplicaDB = d.ReplicaDB
+	c.DBPassword = ""
+	c.Databases = ""
+	c.S3Prefix = config.s3Prefix() + "/" + d.S3Prefix
+	c.S3Prefix = c.s3Prefix()
+
+	// each database has its own run summary, so insert our name before the extension of any file or key
+	if c.RunSummary != "" && c.RunSummary != "-" {
+		ext := path.Ext(c.RunSummary)
+		c.RunSummary = strings.TrimSuffix(c.RunSummary, ext) + "-" + d.Name + ext
+	}
+	return &c
+}
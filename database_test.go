@@ -0,0 +1,74 @@
+package archiver
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadDatabases(t *testing.T) {
+	os.Setenv("TEST_US_DB", "postgres://archiver@us-db/temba")
+	defer os.Unsetenv("TEST_US_DB")
+
+	file, err := ioutil.TempFile("", "databases*.json")
+	assert.NoError(t, err)
+	defer os.Remove(file.Name())
+
+	load := func(contents string) ([]*Database, error) {
+		ioutil.WriteFile(file.Name(), []byte(contents), 0600)
+		return LoadDatabases(file.Name())
+	}
+
+	databases, err := load(`[
+		{"name": "eu", "db": "postgres://archiver@eu-db/temba", "s3_prefix": "eu"},
+		{"name": "us", "db": "env:TEST_US_DB", "replica_db": "postgres://archiver@us-replica/temba", "s3_prefix": "/regions/us/"}
+	]`)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Database{
+		{Name: "eu", DB: "postgres://archiver@eu-db/temba", S3Prefix: "eu"},
+		{Name: "us", DB: "postgres://archiver@us-db/temba", ReplicaDB: "postgres://archiver@us-replica/temba", S3Prefix: "/regions/us/"},
+	}, databases)
+
+	config := NewConfig()
+	config.Databases = file.Name()
+	config.DBPassword = "sesame"
+	config.RunSummary = "s3://archives/summaries/run.json"
+
+	us := databases[1].ConfigFor(config)
+	assert.Equal(t, "postgres://archiver@us-db/temba", us.DB)
+	assert.Equal(t, "postgres://archiver@us-replica/temba", us.ReplicaDB)
+	assert.Equal(t, "regions/us", us.S3Prefix)
+	assert.Equal(t, "", us.Databases)
+	assert.Equal(t, "", us.DBPassword)
+	assert.Equal(t, "s3://archives/summaries/run-us.json", us.RunSummary)
+
+	// the passed in config is unchanged
+	assert.Equal(t, "", config.S3Prefix)
+
+	// prefixes are within any we already have
+	config.S3Prefix = "rapidpro/"
+	assert.Equal(t, "rapidpro/eu", databases[0].ConfigFor(config).S3Prefix)
+
+	_, err = load(`[]`)
+	assert.EqualError(t, err, "no databases in databases file: "+file.Name())
+
+	_, err = load(`[{"name": "EU", "db": "postgres://eu-db/temba", "s3_prefix": "eu"}]`)
+	assert.EqualError(t, err, "invalid database name 'EU', must be lowercase letters, numbers, - and _")
+
+	_, err = load(`[{"name": "eu", "db": "postgres://eu-db/temba", "s3_prefix": "eu"}, {"name": "eu", "db": "postgres://eu2-db/temba", "s3_prefix": "eu2"}]`)
+	assert.EqualError(t, err, "duplicate database name 'eu'")
+
+	_, err = load(`[{"name": "eu", "s3_prefix": "eu"}]`)
+	assert.EqualError(t, err, "missing db connection string for database 'eu'")
+
+	_, err = load(`[{"name": "eu", "db": "env:TEST_MISSING_DB", "s3_prefix": "eu"}]`)
+	assert.EqualError(t, err, "error resolving connection string for database 'eu': secret environment variable TEST_MISSING_DB isn't set")
+
+	_, err = load(`[{"name": "eu", "db": "postgres://eu-db/temba", "s3_prefix": "shared"}, {"name": "us", "db": "postgres://us-db/temba", "s3_prefix": "shared"}]`)
+	assert.EqualError(t, err, "database 'us' has the same s3 prefix as another, each must have its own")
+
+	_, err = load(`{`)
+	assert.Error(t, err)
+}
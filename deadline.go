@@ -0,0 +1,48 @@
+package archiver
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// the deadlines of each phase of archiving if they aren't configured
+const (
+	defaultBuildDeadline  = time.Hour * 3
+	defaultUploadDeadline = time.Minute * 15
+	defaultDeleteDeadline = time.Hour * 3
+)
+
+// buildDeadline returns how long exporting and writing the file of an archive can take
+func (c *Config) buildDeadline() time.Duration {
+	return configuredDeadline(c.BuildDeadlineMinutes, defaultBuildDeadline)
+}
+
+// uploadDeadline returns how long uploading an archive to S3 can take
+func (c *Config) uploadDeadline() time.Duration {
+	return configuredDeadline(c.UploadDeadlineMinutes, defaultUploadDeadline)
+}
+
+// deleteDeadline returns how long deleting the archived records of an archive can take
+func (c *Config) deleteDeadline() time.Duration {
+	return configuredDeadline(c.DeleteDeadlineMinutes, defaultDeleteDeadline)
+}
+
+// configuredDeadline returns the passed in number of minutes as a duration, or the passed in default if it is zero
+func configuredDeadline(minutes int, def time.Duration) time.Duration {
+	if minutes <= 0 {
+		return def
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// checkDeadline wraps the passed in error from a phase run with the passed in context to say that it ran out of time,
+// if that is why it failed, so it isn't mistaken for a problem with the database or S3. Errors from a context which
+// was cancelled rather than timed out, ie: as we are shutting down, are returned as they are.
+func checkDeadline(ctx context.Context, err error, phase string, deadline time.Duration) error {
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return errors.Wrapf(err, "%s exceeded deadline of %s", phase, deadline)
+	}
+	return err
+}
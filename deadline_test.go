@@ -0,0 +1,99 @@
+package archiver
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+)
+
+// stuckS3 is an S3 client whose uploads never complete until they are cancelled
+type stuckS3 struct {
+	s3iface.S3API
+}
+
+func (s *stuckS3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestDeadlines(t *testing.T) {
+	config := NewConfig()
+	assert.Equal(t, time.Hour*3, config.buildDeadline())
+	assert.Equal(t, time.Minute*15, config.uploadDeadline())
+	assert.Equal(t, time.Hour*3, (&Config{}).deleteDeadline())
+
+	config.BuildDeadlineMinutes = 30
+	config.UploadDeadlineMinutes = 5
+	config.DeleteDeadlineMinutes = 60
+	assert.Equal(t, time.Minute*30, config.buildDeadline())
+	assert.Equal(t, time.Minute*5, config.uploadDeadline())
+	assert.Equal(t, time.Hour, config.deleteDeadline())
+	assert.Equal(t, 0, len(config.Validate()))
+
+	config.UploadDeadlineMinutes = -1
+	if problems := config.Validate(); assert.Equal(t, 1, len(problems)) {
+		assert.EqualError(t, problems[0], "build, upload and delete deadlines can't be negative")
+	}
+
+	// a stuck upload is abandoned once it runs out of time, saying so
+	file, err := ioutil.TempFile("", "archive*.jsonl.gz")
+	assert.NoError(t, err)
+	file.WriteString("contents")
+	file.Close()
+	defer os.Remove(file.Name())
+
+	archive := &Archive{Org: Org{ID: 1}, ArchiveType: MessageType, Period: DayPeriod, StartDate: time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC), ArchiveFile: file.Name(), Size: 8}
+	err = uploadArchiveWithin(context.Background(), &stuckS3{}, "bucket", archive, time.Millisecond*10)
+	assert.EqualError(t, err, "error uploading archive to S3: archive upload exceeded deadline of 10ms: context deadline exceeded")
+
+	// but if we're cancelled, ie: shutting down, that's not a deadline
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = uploadArchiveWithin(ctx, &stuckS3{}, "bucket", archive, time.Minute)
+	assert.EqualError(t, err, "error uploading archive to S3: context canceled")
+}
+
+func TestDeleteInBatchesDeadline(t *testing.T) {
+	defer func(size int) { deleteTransactionSize = size }(deleteTransactionSize)
+	deleteTransactionSize = 2
+
+	// our deletion runs out of time partway through its batches, each of which takes 30ms
+	deadline := time.Millisecond * 75
+	outer, cancel := context.WithTimeout(context.Background(), deadline)
+	defer cancel()
+	outerDeadline, _ := outer.Deadline()
+
+	deleted := make([]int64, 0)
+	err := deleteInBatches(outer, []int64{1, 2, 3, 4, 5, 6, 7}, nil, "message", func(ctx context.Context, batchIDs []int64) error {
+		// each batch is bounded by the deadline of the whole deletion, not only its own timeout
+		batchDeadline, _ := ctx.Deadline()
+		assert.False(t, batchDeadline.After(outerDeadline))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Millisecond * 30):
+		}
+		deleted = append(deleted, batchIDs...)
+		return nil
+	})
+	assert.EqualError(t, checkDeadline(outer, err, "message deletion", deadline), "message deletion exceeded deadline of 75ms: context deadline exceeded")
+	assert.Equal(t, []int64{1, 2, 3, 4}, deleted)
+
+	// with time to spare, every batch is deleted
+	deleted = make([]int64, 0)
+	err = deleteInBatches(context.Background(), []int64{1, 2, 3}, nil, "message", func(ctx context.Context, batchIDs []int64) error {
+		deleted = append(deleted, batchIDs...)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 2, 3}, deleted)
+}
@@ -0,0 +1,63 @@
+package archiver
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// DeletionBatch is an entry in our audit log of deleted records, one is written for every batch of records we delete
+// in the same transaction as the deletion itself
+type DeletionBatch struct {
+	ID          int64         `db:"id"`
+	ArchiveID   int           `db:"archive_id"`
+	OrgID       int           `db:"org_id"`
+	ArchiveType ArchiveType   `db:"archive_type"`
+	StartDate   time.Time     `db:"start_date"`
+	Period      ArchivePeriod `db:"period"`
+	FirstID     int64         `db:"first_id"`
+	LastID      int64         `db:"last_id"`
+	RecordCount int           `db:"record_count"`
+	ElapsedMS   int           `db:"elapsed_ms"`
+	DeletedOn   time.Time     `db:"deleted_on"`
+}
+
+const insertDeletionBatch = `
+INSERT INTO archive_deletions(archive_id, org_id, archive_type, start_date, period, first_id, last_id, record_count, elapsed_ms, deleted_on)
+VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+`
+
+// recordDeletionBatch adds an entry to our audit log for the passed in batch of ids deleted for an archive, the ids
+// are expected to be in ascending order
+func recordDeletionBatch(ctx context.Context, tx *sqlx.Tx, archive *Archive, ids []int64, elapsed time.Duration) error {
+	_, err := tx.ExecContext(ctx, insertDeletionBatch, archive.ID, archive.OrgID, archive.ArchiveType, archive.StartDate, archive.Period,
+		ids[0], ids[len(ids)-1], len(ids), int(elapsed/time.Millisecond))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+const lookupDeletionBatches = `
+SELECT id, archive_id, org_id, archive_type, start_date::timestamp with time zone as start_date, period, first_id, last_id,
+	record_count, elapsed_ms, deleted_on
+FROM archive_deletions
+WHERE archive_id = $1
+ORDER BY id
+`
+
+// GetDeletionBatches returns the audit log of deleted records for the passed in archive
+func GetDeletionBatches(ctx context.Context, db *sqlx.DB, archiveID int) ([]*DeletionBatch, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	batches := make([]*DeletionBatch, 0)
+	err := db.SelectContext(ctx, &batches, lookupDeletionBatches, archiveID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up deletions for archive: %d", archiveID)
+	}
+	return batches, nil
+}
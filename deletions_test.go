@@ -0,0 +1,41 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeletionBatches(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	archive, err := GetArchive(ctx, db, 4)
+	assert.NoError(t, err)
+
+	batches, err := GetDeletionBatches(ctx, db, archive.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(batches))
+
+	tx, err := db.BeginTxx(ctx, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, recordDeletionBatch(ctx, tx, archive, []int64{3, 6, 9}, time.Millisecond*1500))
+	assert.NoError(t, recordDeletionBatch(ctx, tx, archive, []int64{12}, time.Millisecond*20))
+	assert.NoError(t, tx.Commit())
+
+	batches, err = GetDeletionBatches(ctx, db, archive.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(batches))
+	assert.Equal(t, 2, batches[0].OrgID)
+	assert.Equal(t, MessageType, batches[0].ArchiveType)
+	assert.Equal(t, time.Date(2017, 10, 8, 0, 0, 0, 0, time.UTC), batches[0].StartDate.In(time.UTC))
+	assert.Equal(t, DayPeriod, batches[0].Period)
+	assert.Equal(t, int64(3), batches[0].FirstID)
+	assert.Equal(t, int64(9), batches[0].LastID)
+	assert.Equal(t, 3, batches[0].RecordCount)
+	assert.Equal(t, 1500, batches[0].ElapsedMS)
+	assert.Equal(t, int64(12), batches[1].FirstID)
+	assert.Equal(t, int64(12), batches[1].LastID)
+}
@@ -0,0 +1,159 @@
+package archiver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// the missing days of every org between its own first and last days, not covered by a daily archive or by a monthly
+// archive of their month
+const lookupAllMissingDailyArchives = `
+WITH org_days AS (
+  SELECT r.org_id, generate_series(r.first_day, r.last_day, '1 day')::date AS missing_day
+  FROM unnest($1::int[], $2::date[], $3::date[]) AS r(org_id, first_day, last_day)
+)
+SELECT d.org_id, d.missing_day::timestamp with time zone AS missing_day
+FROM org_days d
+WHERE NOT EXISTS (
+  SELECT 1 FROM archives_archive a
+  WHERE a.org_id = d.org_id AND a.archive_type = $4 AND (
+    (a.period = 'D' AND a.start_date = d.missing_day) OR
+    (a.period = 'M' AND a.start_date = date_trunc('month', d.missing_day)::date)
+  )
+)
+ORDER BY d.org_id, d.missing_day
+`
+
+const lookupOrgsWithArchives = `
+SELECT DISTINCT org_id
+FROM archives_archive
+WHERE org_id = ANY($1) AND archive_type = $2
+`
+
+// MissingArchives are the missing daily archives of a type for many orgs, found with a single query rather than one
+// for each org, see FindMissingArchives
+type MissingArchives struct {
+	ArchiveType ArchiveType
+
+	// the missing daily archives of each org, and the last day we looked for them up to
+	dailies  map[int][]*Archive
+	lastDays map[int]time.Time
+
+	// the orgs which have any archives of this type
+	archived map[int]bool
+
+	mutex sync.Mutex
+}
+
+// FindMissingArchives finds the missing daily archives of the passed in type for all the passed in orgs as of the
+// passed in time, the same as calling GetMissingDailyArchives for each
+func FindMissingArchives(ctx context.Context, db *sqlx.DB, now time.Time, orgs []Org, archiveType ArchiveType) (*MissingArchives, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+
+	missing := &MissingArchives{
+		ArchiveType: archiveType,
+		dailies:     make(map[int][]*Archive, len(orgs)),
+		lastDays:    make(map[int]time.Time, len(orgs)),
+		archived:    make(map[int]bool, len(orgs)),
+	}
+
+	orgIDs := make([]int64, len(orgs))
+	firstDays := make([]string, len(orgs))
+	lastDays := make([]string, len(orgs))
+	orgsByID := make(map[int]Org, len(orgs))
+	for i, org := range orgs {
+		orgUTC := org.CreatedOn.In(time.UTC)
+		firstDay := time.Date(orgUTC.Year(), orgUTC.Month(), orgUTC.Day(), 0, 0, 0, 0, time.UTC)
+		lastDay := newestEligibleDay(now, org)
+
+		orgIDs[i] = int64(org.ID)
+		firstDays[i] = firstDay.Format("2006-01-02")
+		lastDays[i] = lastDay.Format("2006-01-02")
+		orgsByID[org.ID] = org
+
+		missing.dailies[org.ID] = make([]*Archive, 0)
+		missing.lastDays[org.ID] = lastDay
+	}
+
+	rows, err := db.QueryxContext(ctx, lookupAllMissingDailyArchives, pq.Array(orgIDs), pq.Array(firstDays), pq.Array(lastDays), archiveType)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error finding missing daily archives of type: %s", archiveType)
+	}
+	defer rows.Close()
+
+	var orgID int
+	var missingDay time.Time
+	for rows.Next() {
+		err = rows.Scan(&orgID, &missingDay)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error scanning missing daily archive of type: %s", archiveType)
+		}
+
+		org := orgsByID[orgID]
+		missing.dailies[orgID] = append(missing.dailies[orgID], &Archive{
+			Org:         org,
+			OrgID:       org.ID,
+			StartDate:   missingDay,
+			ArchiveType: archiveType,
+			Period:      DayPeriod,
+		})
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrapf(err, "error reading missing daily archives of type: %s", archiveType)
+	}
+
+	archived := make([]int, 0)
+	err = db.SelectContext(ctx, &archived, lookupOrgsWithArchives, pq.Array(orgIDs), archiveType)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error finding orgs with archives of type: %s", archiveType)
+	}
+	for _, id := range archived {
+		missing.archived[id] = true
+	}
+
+	return missing, nil
+}
+
+// Count returns the total number of missing daily archives across all orgs not yet taken
+func (m *MissingArchives) Count() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	count := 0
+	for _, dailies := range m.dailies {
+		count += len(dailies)
+	}
+	return count
+}
+
+// take returns the missing daily archives of the passed in org as of the passed in time, and whether it has any
+// archives at all. Each org's archives can only be taken once, as once built they are no longer missing. If the org
+// wasn't included, has already been taken, or its retention period has changed so that we'd look up to a different
+// day, found is false and the caller should look them up itself.
+func (m *MissingArchives) take(now time.Time, org Org) (dailies []*Archive, archived bool, found bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	lastDay, found := m.lastDays[org.ID]
+	if !found {
+		return nil, false, false
+	}
+	delete(m.lastDays, org.ID)
+	if !lastDay.Equal(newestEligibleDay(now, org)) {
+		return nil, false, false
+	}
+
+	// give the caller archives for the org as they have it
+	dailies = m.dailies[org.ID]
+	for _, d := range dailies {
+		d.Org = org
+	}
+	delete(m.dailies, org.ID)
+	return dailies, m.archived[org.ID], true
+}
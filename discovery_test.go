@@ -0,0 +1,115 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindMissingArchives(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	for _, archiveType := range []ArchiveType{MessageType, RunType} {
+		missing, err := FindMissingArchives(ctx, db, now, orgs, archiveType)
+		assert.NoError(t, err)
+
+		// we find the same archives as looking up each org
+		total := 0
+		for _, org := range orgs {
+			expected, err := GetMissingDailyArchives(ctx, db, now, org, archiveType)
+			assert.NoError(t, err)
+			count, err := GetCurrentArchiveCount(ctx, db, org, archiveType)
+			assert.NoError(t, err)
+			total += len(expected)
+
+			dailies, archived, found := missing.take(now, org)
+			assert.True(t, found)
+			assert.Equal(t, count > 0, archived)
+			if assert.Equal(t, len(expected), len(dailies), "missing mismatch for org %d %ss", org.ID, archiveType) {
+				for i := range expected {
+					assert.True(t, expected[i].StartDate.Equal(dailies[i].StartDate))
+					assert.Equal(t, DayPeriod, dailies[i].Period)
+					assert.Equal(t, archiveType, dailies[i].ArchiveType)
+					assert.Equal(t, org.ID, dailies[i].Org.ID)
+				}
+			}
+
+			// an org can only be taken once
+			_, _, found = missing.take(now, org)
+			assert.False(t, found)
+		}
+		assert.True(t, total > 0)
+		assert.Equal(t, 0, missing.Count())
+	}
+
+	// an org whose retention has changed since, or which wasn't included, has to be looked up
+	missing, err := FindMissingArchives(ctx, db, now, orgs[:2], MessageType)
+	assert.NoError(t, err)
+
+	changed := orgs[0]
+	changed.RetentionPeriod += 30
+	_, _, found := missing.take(now, changed)
+	assert.False(t, found)
+
+	_, _, found = missing.take(now, orgs[2])
+	assert.False(t, found)
+
+	// as does an org once a new day has become eligible
+	_, _, found = missing.take(now.AddDate(0, 0, 1), orgs[1])
+	assert.False(t, found)
+
+	// without any in our options there's nothing to take
+	_, _, found = (&Options{}).takeMissingArchives(now, orgs[1], MessageType)
+	assert.False(t, found)
+}
+
+func TestCreateOrgArchivesWithMissingArchives(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	config.UploadToS3 = false
+
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	runs, err := FindMissingArchives(ctx, db, now, orgs, RunType)
+	assert.NoError(t, err)
+	msgs, err := FindMissingArchives(ctx, db, now, orgs, MessageType)
+	assert.NoError(t, err)
+	opts := &Options{Missing: []*MissingArchives{runs, msgs}}
+
+	// we build the same archives as when we look them up for the org
+	created, err := CreateOrgArchives(ctx, now, config, db, nil, orgs[2], RunType, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 12, len(created))
+	assert.Equal(t, 1, created[0].RecordCount)
+	assert.Equal(t, "074de71dfb619c78dbac5b6709dd66c2", created[0].Hash)
+	assert.Equal(t, 1, created[11].RecordCount)
+	assert.Equal(t, "bf08041cef314492fee2910357ec4189", created[11].Hash)
+
+	created, err = CreateOrgArchives(ctx, now, config, db, nil, orgs[1], MessageType, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 61, len(created))
+	for _, archive := range created {
+		assert.Nil(t, archive.BuildError)
+		assert.Equal(t, DayPeriod, archive.Period)
+	}
+
+	// and leave nothing missing
+	remaining, err := GetMissingDailyArchives(ctx, db, now, orgs[2], RunType)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(remaining))
+	remaining, err = GetMissingDailyArchives(ctx, db, now, orgs[1], MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(remaining))
+}
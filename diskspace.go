@@ -0,0 +1,105 @@
+package archiver
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ErrInsufficientDiskSpace is the cause of the error returned when the temp directory doesn't have room for an archive
+var ErrInsufficientDiskSpace = errors.New("not enough free space in temp directory")
+
+// compressed bytes per record we assume for orgs without any archives to estimate from, on the generous side as these
+// vary a lot with the length of messages and paths of runs
+var defaultRecordBytes = map[ArchiveType]int64{
+	MessageType: 512,
+	RunType:     2048,
+}
+
+const lookupRecentArchiveSizes = `
+SELECT COALESCE(SUM(size), 0) AS size, COALESCE(SUM(record_count), 0) AS record_count FROM (
+	SELECT size, record_count
+	FROM archives_archive
+	WHERE org_id = $1 AND archive_type = $2 AND record_count > 0 AND size > 0
+	ORDER BY start_date DESC
+	LIMIT 10
+) a
+`
+
+// estimateArchiveSize estimates the compressed size of the passed in archive with the passed in number of records,
+// from the average size of a record in the org's most recent archives of the same type
+func estimateArchiveSize(ctx context.Context, db *sqlx.DB, archive *Archive, records int) (int64, error) {
+	recent := struct {
+		Size        int64 `db:"size"`
+		RecordCount int64 `db:"record_count"`
+	}{}
+	err := db.GetContext(ctx, &recent, lookupRecentArchiveSizes, archive.Org.ID, archive.ArchiveType)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error looking up recent archive sizes for org: %d", archive.Org.ID)
+	}
+
+	perRecord := defaultRecordBytes[archive.ArchiveType]
+	if recent.RecordCount > 0 {
+		perRecord = recent.Size/recent.RecordCount + 1
+	}
+	return int64(records) * perRecord, nil
+}
+
+// checkDiskSpace returns an error if the passed in directory doesn't have room for a file of the passed in estimated
+// size with the passed in number of megabytes to spare, a reserve of zero disables the check
+func checkDiskSpace(path string, estimated int64, reserveMB int) error {
+	if reserveMB <= 0 {
+		return nil
+	}
+
+	free, err := FreeDiskSpace(path)
+	if err != nil {
+		return err
+	}
+
+	// we can't tell how much space is free on this platform, so can only find out by running out
+	if free < 0 {
+		return nil
+	}
+
+	needed := estimated + int64(reserveMB)*1024*1024
+	if free < needed {
+		return errors.Wrapf(ErrInsufficientDiskSpace, "%s has %d MB free but archive needs ~%d MB plus a reserve of %d MB", path, free/(1024*1024), estimated/(1024*1024), reserveMB)
+	}
+	return nil
+}
+
+// matches the names of the temp files we write archives to, ie: message_3_D20170810_123456 or run_3_M_2017_09_123456
+var tempArchiveFileRegex = regexp.MustCompile(`^(message|run)_\d+_(D|M)(\d{8}|_\d{4}_\d{2})_\d+$`)
+
+// CleanTempArchiveDirectory removes archive files left in the passed in directory by archivers which didn't get to clean
+// up after themselves, such as when killed or out of disk space. Only files older than the passed in age are removed,
+// so that we don't remove those of another archiver sharing the directory which is still writing to them. Returns the
+// number of files removed and their total size.
+func CleanTempArchiveDirectory(path string, olderThan time.Duration) (int, int64, error) {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return 0, 0, errors.Wrapf(err, "error reading temp dir: %s", path)
+	}
+
+	removed, size := 0, int64(0)
+	for _, f := range files {
+		if f.IsDir() || !tempArchiveFileRegex.MatchString(f.Name()) || time.Since(f.ModTime()) < olderThan {
+			continue
+		}
+
+		err = os.Remove(filepath.Join(path, f.Name()))
+		if err != nil {
+			return removed, size, errors.Wrapf(err, "error removing orphaned archive file: %s", f.Name())
+		}
+		removed++
+		size += f.Size()
+	}
+	return removed, size, nil
+}
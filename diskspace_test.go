@@ -0,0 +1,79 @@
+package archiver
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateArchiveSize(t *testing.T) {
+	ctx := context.Background()
+	db := setup(t)
+
+	orgs, err := GetActiveOrgs(ctx, db, NewConfig())
+	assert.NoError(t, err)
+
+	// org 3 has message archives to estimate from
+	archive := &Archive{Org: orgs[2], ArchiveType: MessageType}
+	estimated, err := estimateArchiveSize(ctx, db, archive, 100)
+	assert.NoError(t, err)
+	assert.True(t, estimated > 0)
+	assert.NotEqual(t, int64(100)*defaultRecordBytes[MessageType], estimated)
+
+	// org 1 has none so we use our default
+	archive = &Archive{Org: orgs[0], ArchiveType: RunType}
+	estimated, err = estimateArchiveSize(ctx, db, archive, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100)*defaultRecordBytes[RunType], estimated)
+}
+
+func TestCheckDiskSpace(t *testing.T) {
+	free, err := FreeDiskSpace(os.TempDir())
+	assert.NoError(t, err)
+	assert.True(t, free > 0)
+
+	assert.NoError(t, checkDiskSpace(os.TempDir(), 1024, 1))
+	assert.NoError(t, checkDiskSpace(os.TempDir(), free*2, 0))
+
+	err = checkDiskSpace(os.TempDir(), free*2, 1)
+	assert.Equal(t, ErrInsufficientDiskSpace, errors.Cause(err))
+
+	_, err = FreeDiskSpace("/does/not/exist")
+	assert.Error(t, err)
+}
+
+func TestCleanTempArchiveDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archiver")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	old := time.Now().Add(-time.Hour * 2)
+	write := func(name string, modified time.Time) {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, ioutil.WriteFile(path, []byte("archive"), 0600))
+		assert.NoError(t, os.Chtimes(path, modified, modified))
+	}
+
+	write("message_3_D20170810_123456", old)
+	write("run_3_M_2017_09_654321", old)
+	write("run_3_M20170901_111", time.Now())
+	write("notes.txt", old)
+
+	removed, size, err := CleanTempArchiveDirectory(dir, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	assert.Equal(t, int64(14), size)
+
+	remaining, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(remaining))
+
+	_, _, err = CleanTempArchiveDirectory(filepath.Join(dir, "missing"), time.Hour)
+	assert.Error(t, err)
+}
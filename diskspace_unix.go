@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package archiver
+
+import (
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// FreeDiskSpace returns the number of bytes available to us on the filesystem of the passed in path
+func FreeDiskSpace(path string) (int64, error) {
+	stat := syscall.Statfs_t{}
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error getting free space of: %s", path)
+	}
+	return int64(stat.Bavail) * int64(stat.Bsize), nil
+}
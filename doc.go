@@ -0,0 +1,29 @@
+// Package archiver archives the messages and flow runs of RapidPro orgs to S3 and deletes them from the database once
+// archived. It is the engine of the rp-archiver command, and nothing in it reads flags or the environment, a Config is
+// built with NewConfig and its fields set directly, or loaded from a file with LoadYAMLConfig. Its API isn't yet stable.
+//
+// Runs: a Runner archives every active org of one or more RunDatabases with a pool of workers per database, retrying
+// orgs which fail, sharing the orgs of a run with other instances through a TaskQueue in Redis if a database has one,
+// and recording, summarizing and reporting each run. Between runs ArchiveIncrementally archives the days which become
+// eligible, and stops early when a run is requested with its RunTrigger, ie: by a Control serving /run, /pause and
+// /resume. The command only decides when a Runner runs and how it exits.
+//
+// Archives: GetActiveOrgs and GetOrg load orgs with their settings applied, ArchiveOrg builds, rolls up, deletes and
+// purges the archives of an org and type in one call, and CreateOrgArchives, RollupOrgArchives and
+// DeleteArchivedOrgRecords do each of those alone. ListArchives and GetCoveringArchives find existing archives.
+//
+// Storage: OpenDB opens a correctly configured connection pool, which records can also be exported from with COPY, see
+// RegisterCopySource, NewS3Client an S3 client, and UploadArchive, GetS3File and DeleteS3File move archive files to and
+// from S3. ArchiveStore and RecordSource let the database be replaced, Hooks let callers follow archives through each
+// stage, a Publisher streams an event for each committed archive and a RecordSerializer per archive type changes the
+// shape of the records written, all set in the Options passed to ArchiveOrg and the functions it is made of.
+//
+// Reading: OpenArchive and OpenArchiveWithHash return an ArchiveReader over the records of an archive on S3, and
+// NewArchiveReader over those of a downloaded file, verifying its hash once the last record has been read. Msg and Run
+// are the records of message and run archives.
+//
+// Deletion: DeleteArchivedMessages and DeleteArchivedRuns delete the records of a single archive, PurgeOrgArchives
+// removes archives which have outlived their retention and EraseContact rewrites archives without a contact's records.
+//
+// Errors are classified with ErrorClass and IsRetryable so callers can decide whether to retry what failed.
+package archiver
@@ -0,0 +1,31 @@
+package archiver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// duckdbViews are the names of the views over the archives of each type in our DuckDB scripts
+var duckdbViews = map[ArchiveType]string{MessageType: "messages", RunType: "runs"}
+
+// DuckDBScript returns a script for the DuckDB CLI which creates a messages and a runs view over the passed in local
+// archive files of each type, gzipped or not, and then runs the passed in query against them. Types without any files
+// have no view, so queries of them fail rather than silently returning nothing.
+func DuckDBScript(files map[ArchiveType][]string, query string) string {
+	script := &strings.Builder{}
+	for _, archiveType := range []ArchiveType{MessageType, RunType} {
+		if len(files[archiveType]) == 0 {
+			continue
+		}
+
+		quoted := make([]string, len(files[archiveType]))
+		for i, f := range files[archiveType] {
+			quoted[i] = "'" + strings.Replace(f, "'", "''", -1) + "'"
+		}
+		fmt.Fprintf(script, "CREATE VIEW %s AS SELECT * FROM read_json_auto([%s], format = 'newline_delimited');\n", duckdbViews[archiveType], strings.Join(quoted, ", "))
+	}
+
+	script.WriteString(strings.TrimRight(strings.TrimSpace(query), ";"))
+	script.WriteString(";\n")
+	return script.String()
+}
@@ -0,0 +1,23 @@
+package archiver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuckDBScript(t *testing.T) {
+	files := map[ArchiveType][]string{
+		MessageType: {"/tmp/query/message_D20170812_e7ed.jsonl.gz", "/tmp/query's/message_M20170901_aa3c.jsonl.gz"},
+		RunType:     {},
+	}
+
+	// only types with files have views, and trailing semicolons are left to us
+	assert.Equal(t, "CREATE VIEW messages AS SELECT * FROM read_json_auto(['/tmp/query/message_D20170812_e7ed.jsonl.gz', '/tmp/query''s/message_M20170901_aa3c.jsonl.gz'], format = 'newline_delimited');\n"+
+		"SELECT count(*) FROM messages WHERE direction = 'in';\n", DuckDBScript(files, " SELECT count(*) FROM messages WHERE direction = 'in'; \n"))
+
+	files[RunType] = []string{"/tmp/query/run_D20170812_0f3a.jsonl.gz"}
+	assert.Equal(t, "CREATE VIEW messages AS SELECT * FROM read_json_auto(['/tmp/query/message_D20170812_e7ed.jsonl.gz', '/tmp/query''s/message_M20170901_aa3c.jsonl.gz'], format = 'newline_delimited');\n"+
+		"CREATE VIEW runs AS SELECT * FROM read_json_auto(['/tmp/query/run_D20170812_0f3a.jsonl.gz'], format = 'newline_delimited');\n"+
+		"SELECT flow.name, count(*) FROM runs GROUP BY 1;\n", DuckDBScript(files, "SELECT flow.name, count(*) FROM runs GROUP BY 1"))
+}
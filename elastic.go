@@ -0,0 +1,179 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// the most records and bytes we send in a single bulk request
+const (
+	elasticBulkRecords = 1000
+	elasticBulkBytes   = 5 * 1024 * 1024
+)
+
+// Elastic indexes the records of archives in Elasticsearch, in an index for each org, type and month, ie:
+// archives-messages-5-2017.08, so that archived records can be searched without scanning archive files. Records are
+// indexed by their id, so indexing an archive again replaces its documents rather than duplicating them. All methods
+// are safe to call on a nil Elastic, in which case they do nothing.
+type Elastic struct {
+	url    string
+	prefix string
+	client *http.Client
+}
+
+// NewElastic creates a new Elastic from the passed in config, returning nil if no Elasticsearch URL is configured
+func NewElastic(config *Config) *Elastic {
+	if config.ElasticURL == "" {
+		return nil
+	}
+	return &Elastic{url: strings.TrimRight(config.ElasticURL, "/"), prefix: config.ElasticPrefix, client: &http.Client{Timeout: time.Minute}}
+}
+
+// ElasticIndex returns the name of the index the records of the passed in archive are indexed in
+func ElasticIndex(prefix string, archive *Archive) string {
+	return fmt.Sprintf("%s-%ss-%d-%s", prefix, archive.ArchiveType, archive.OrgID, archive.StartDate.In(time.UTC).Format("2006.01"))
+}
+
+// IndexArchives reads each of the passed in archives back from S3 and indexes its records. Monthly archives which were
+// just rolled up from dailies aren't indexed, as their records were indexed with the dailies, nor are archives without
+// any records or which aren't on S3.
+func (e *Elastic) IndexArchives(ctx context.Context, s3Client s3iface.S3API, archives []*Archive) error {
+	if e == nil {
+		return nil
+	}
+
+	for _, archive := range archives {
+		if len(archive.Dailies) > 0 || archive.RecordCount == 0 || archive.URL == "" || archive.PurgedOn != nil {
+			continue
+		}
+
+		start := time.Now()
+		count, err := e.indexArchive(ctx, s3Client, archive)
+		if err != nil {
+			return errors.Wrapf(err, "error indexing archive: %d", archive.ID)
+		}
+		logrus.WithField("archive_id", archive.ID).WithField("org_id", archive.OrgID).WithField("record_count", count).WithField("elapsed", time.Since(start)).Debug("indexed archive")
+	}
+	return nil
+}
+
+// indexArchive indexes the records of the passed in archive in bulk, returning how many were indexed
+func (e *Elastic) indexArchive(ctx context.Context, s3Client s3iface.S3API, archive *Archive) (int, error) {
+	reader, err := OpenArchiveWithHash(ctx, s3Client, archive.URL, archive.Hash)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	index := ElasticIndex(e.prefix, archive)
+	body := &bytes.Buffer{}
+	batched := 0
+
+	record := &struct {
+		ID json.Number `json:"id"`
+	}{}
+
+	for reader.Next() {
+		record.ID = ""
+		if err := reader.Decode(record); err != nil {
+			return 0, err
+		}
+		if record.ID == "" {
+			return 0, errors.Errorf("record %d has no id", reader.Count())
+		}
+
+		action, _ := json.Marshal(map[string]interface{}{"index": map[string]string{"_index": index, "_id": record.ID.String()}})
+		body.Write(action)
+		body.WriteByte('\n')
+		body.Write(reader.Record())
+		body.WriteByte('\n')
+		batched++
+
+		if batched == elasticBulkRecords || body.Len() >= elasticBulkBytes {
+			if err := e.bulk(ctx, body.Bytes()); err != nil {
+				return 0, err
+			}
+			body.Reset()
+			batched = 0
+		}
+	}
+	if err := reader.Err(); err != nil {
+		return 0, err
+	}
+
+	if batched > 0 {
+		if err := e.bulk(ctx, body.Bytes()); err != nil {
+			return 0, err
+		}
+	}
+	return reader.Count(), nil
+}
+
+// elasticBulkResponse is the part of the response to a bulk request we look at, whether any item failed and why
+type elasticBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string `json:"_id"`
+		Status int    `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// bulk makes a bulk request with the passed in body, returning an error if it or any of its items fail
+func (e *Elastic) bulk(ctx context.Context, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, e.url+"/_bulk", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "error creating elasticsearch request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error calling elasticsearch")
+	}
+	defer resp.Body.Close()
+
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrapf(err, "error reading elasticsearch response")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("elasticsearch returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(contents)))
+	}
+
+	response := &elasticBulkResponse{}
+	if err := json.Unmarshal(contents, response); err != nil {
+		return errors.Wrapf(err, "error decoding elasticsearch response")
+	}
+	if !response.Errors {
+		return nil
+	}
+
+	failed := 0
+	var first string
+	for _, item := range response.Items {
+		for _, result := range item {
+			if result.Error != nil {
+				if failed == 0 {
+					first = fmt.Sprintf("%s: %s: %s", result.ID, result.Error.Type, result.Error.Reason)
+				}
+				failed++
+			}
+		}
+	}
+	return errors.Errorf("elasticsearch failed to index %d records, first %s", failed, first)
+}
@@ -0,0 +1,80 @@
+package archiver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestElastic(t *testing.T) {
+	ctx := context.Background()
+
+	file := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(file)
+	gzipWriter.Write([]byte("{\"id\":1,\"text\":\"hi\"}\n{\"id\":2,\"text\":\"there\"}\n"))
+	gzipWriter.Close()
+	sum := md5.Sum(file.Bytes())
+	client := &fileS3{file: file.Bytes()}
+
+	archive := &Archive{
+		ID:          3,
+		OrgID:       2,
+		ArchiveType: MessageType,
+		Period:      DayPeriod,
+		StartDate:   time.Date(2017, 8, 12, 0, 0, 0, 0, time.UTC),
+		RecordCount: 2,
+		Hash:        hex.EncodeToString(sum[:]),
+		URL:         "https://dl-archiver-test.s3.amazonaws.com/2/message_D20170812.jsonl.gz",
+	}
+	assert.Equal(t, "archives-messages-2-2017.08", ElasticIndex("archives", archive))
+
+	// no URL, no elastic, but we can still index
+	config := NewConfig()
+	elastic := NewElastic(config)
+	assert.Nil(t, elastic)
+	assert.NoError(t, elastic.IndexArchives(ctx, client, []*Archive{archive}))
+
+	bodies := make([]string, 0)
+	response := `{"took": 3, "errors": false, "items": []}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/_bulk", r.URL.Path)
+		assert.Equal(t, "application/x-ndjson", r.Header.Get("Content-Type"))
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.Write([]byte(response))
+	}))
+	defer server.Close()
+
+	config.ElasticURL = server.URL + "/"
+	assert.Equal(t, 0, len(config.Validate()))
+	elastic = NewElastic(config)
+
+	// records are indexed by id, but not those of rollups, empty archives or archives not on S3
+	rollup := &Archive{ID: 4, OrgID: 2, ArchiveType: MessageType, RecordCount: 2, URL: archive.URL, Dailies: []*Archive{archive}}
+	empty := &Archive{ID: 5, OrgID: 2, ArchiveType: MessageType, URL: archive.URL}
+	assert.NoError(t, elastic.IndexArchives(ctx, client, []*Archive{archive, rollup, empty}))
+	assert.Equal(t, []string{
+		`{"index":{"_id":"1","_index":"archives-messages-2-2017.08"}}` + "\n" + `{"id":1,"text":"hi"}` + "\n" +
+			`{"index":{"_id":"2","_index":"archives-messages-2-2017.08"}}` + "\n" + `{"id":2,"text":"there"}` + "\n",
+	}, bodies)
+
+	// records which fail to be indexed fail the archive
+	response = `{"took": 3, "errors": true, "items": [
+		{"index": {"_id": "1", "status": 201}},
+		{"index": {"_id": "2", "status": 400, "error": {"type": "mapper_parsing_exception", "reason": "failed to parse field [text]"}}}
+	]}`
+	err := elastic.IndexArchives(ctx, client, []*Archive{archive})
+	assert.EqualError(t, err, "error indexing archive: 3: elasticsearch failed to index 1 records, first 2: mapper_parsing_exception: failed to parse field [text]")
+
+	config.ElasticPrefix = "Archives"
+	assert.Contains(t, config.Validate()[0].Error(), "cannot index archives in elasticsearch without uploading to s3 and a lowercase elastic prefix")
+}
@@ -0,0 +1,99 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+const countDailyMessages = `
+SELECT to_char(mm.created_on AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day, count(*) AS count
+FROM msgs_msg mm
+WHERE mm.org_id = $1 AND mm.created_on >= $2 AND mm.created_on < $3 AND mm.visibility != 'D'
+GROUP BY 1
+`
+
+const countDailyRuns = `
+SELECT to_char(fr.modified_on AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day, count(*) AS count
+FROM flows_flowrun fr
+WHERE fr.org_id = $1 AND fr.modified_on >= $2 AND fr.modified_on < $3
+GROUP BY 1
+`
+
+// countDailyRecords counts the records of the passed in type for the passed in org on each day between the passed in
+// dates, using the same predicates we use to write them, returning a map of each day with records to its count
+func countDailyRecords(ctx context.Context, db *sqlx.DB, org Org, archiveType ArchiveType, startDate time.Time, endDate time.Time) (map[string]int, error) {
+	var query string
+	switch archiveType {
+	case MessageType:
+		query = countDailyMessages
+	case RunType:
+		query = countDailyRuns
+	default:
+		return nil, fmt.Errorf("unknown archive type: %s", archiveType)
+	}
+
+	rows, err := preparing(db, nil).QueryxContext(ctx, query, org.ID, startDate, endDate)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error counting daily records for org: %d", org.ID)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var day string
+		var count int
+		err = rows.Scan(&day, &count)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading daily record count for org: %d", org.ID)
+		}
+		counts[day] = count
+	}
+	return counts, rows.Err()
+}
+
+// precountArchives counts the records in the periods of all the passed in archives, which must be of the same type,
+// with a single query, so that those with none can be built without querying for their records
+func precountArchives(ctx context.Context, db *sqlx.DB, org Org, archives []*Archive) error {
+	if len(archives) == 0 {
+		return nil
+	}
+
+	startDate, endDate := archives[0].StartDate, archives[0].endDate()
+	for _, a := range archives[1:] {
+		if a.StartDate.Before(startDate) {
+			startDate = a.StartDate
+		}
+		if a.endDate().After(endDate) {
+			endDate = a.endDate()
+		}
+	}
+
+	counts, err := countDailyRecords(ctx, db, org, archives[0].ArchiveType, startDate, endDate)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range archives {
+		count := 0
+		for day := a.StartDate; day.Before(a.endDate()); day = day.AddDate(0, 0, 1) {
+			count += counts[day.Format("2006-01-02")]
+		}
+		a.counted = &count
+	}
+	return nil
+}
+
+// skipArchiveFile clears the file of the passed in archive, which has no records, so that it is recorded without one,
+// any local file is left for the caller to remove
+func skipArchiveFile(archive *Archive) {
+	archive.RecordCount = 0
+	archive.Size = 0
+	archive.Hash = ""
+	archive.URL = ""
+	archive.ChainHash = nil
+	archive.NeedsDeletion = false
+}
@@ -0,0 +1,99 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrecountArchives(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	for _, archiveType := range []ArchiveType{MessageType, RunType} {
+		dailies, err := GetMissingDailyArchives(ctx, db, now, orgs[1], archiveType)
+		assert.NoError(t, err)
+		monthlies, err := GetMissingMonthlyArchives(ctx, db, now, orgs[1], archiveType)
+		assert.NoError(t, err)
+
+		// a single query gives us the same counts as counting each archive
+		for _, archives := range [][]*Archive{dailies, monthlies} {
+			err = precountArchives(ctx, db, orgs[1], archives)
+			assert.NoError(t, err)
+
+			for _, archive := range archives {
+				count, err := countArchivableRecords(ctx, db, archive)
+				assert.NoError(t, err)
+				if assert.NotNil(t, archive.counted) {
+					assert.Equal(t, count, *archive.counted, "count mismatch for %s archive of %s", archiveType, archive.StartDate)
+				}
+			}
+		}
+	}
+
+	// nothing to count is fine
+	assert.NoError(t, precountArchives(ctx, db, orgs[1], []*Archive{}))
+
+	// archives counted as empty are still written as empty files
+	dailies, err := GetMissingDailyArchives(ctx, db, now, orgs[1], MessageType)
+	assert.NoError(t, err)
+	err = precountArchives(ctx, db, orgs[1], dailies)
+	assert.NoError(t, err)
+
+	task := dailies[0]
+	assert.Equal(t, 0, *task.counted)
+	err = createArchiveFile(ctx, db, task, "/tmp", config, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, task.RecordCount)
+	assert.Equal(t, int64(23), task.Size)
+	assert.Equal(t, "f0d79988b7772c003d04a28bd7417a62", task.Hash)
+	DeleteArchiveFile(task)
+}
+
+func TestSkipEmptyArchiveFiles(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	config.UploadToS3 = false
+	config.SkipEmptyArchiveFiles = true
+
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	created, err := CreateOrgArchives(ctx, now, config, db, nil, orgs[1], MessageType, nil)
+	assert.NoError(t, err)
+	assert.True(t, len(created) > 0)
+
+	// archives with records are built as usual, those without are only recorded
+	empty := 0
+	for _, archive := range created {
+		assert.Nil(t, archive.BuildError)
+		assert.NotEqual(t, 0, archive.ID)
+
+		if archive.RecordCount == 0 {
+			assert.Equal(t, int64(0), archive.Size)
+			assert.Equal(t, "", archive.Hash)
+			assert.Equal(t, "", archive.URL)
+			assert.Equal(t, "", archive.ArchiveFile)
+			assert.False(t, archive.NeedsDeletion)
+			empty++
+		} else {
+			assert.NotEqual(t, "", archive.Hash)
+		}
+	}
+	assert.True(t, empty > 0)
+
+	// and they're not missing anymore
+	missing, err := GetMissingDailyArchives(ctx, db, now, orgs[1], MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(missing))
+}
@@ -0,0 +1,307 @@
+package archiver
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ErasureOptions is whose records we erase from archives and how
+type ErasureOptions struct {
+	ContactUUID string
+
+	// whether to mask the contact's records rather than remove them, keeping how many there were
+	Redact bool
+
+	// whether to only report which archives have the contact's records, without rewriting them
+	DryRun bool
+}
+
+// erasureRedaction is what is recorded as the redaction profile of archives whose records have been masked by erasure
+const erasureRedaction = "erasure"
+
+// erasureMasks are the fields of the records of each archive type which are masked when redacting a contact's records
+var erasureMasks = map[ArchiveType][]string{
+	MessageType: {"contact.*", "urn", "text", "attachments.url"},
+	RunType:     {"contact.*", "values.*.input", "values.*.value", "events.msg.text", "events.msg.urn"},
+}
+
+// ErasedArchive is what erasing a contact's records did to an archive which had some
+type ErasedArchive struct {
+	ArchiveID   int           `json:"archive_id"`
+	ArchiveType ArchiveType   `json:"archive_type"`
+	StartDate   time.Time     `json:"start_date"`
+	Period      ArchivePeriod `json:"period"`
+
+	// the number of the contact's records found and how many of those were erased, which is none if it is held
+	Found  int `json:"found"`
+	Erased int `json:"erased"`
+
+	// the legal hold which prevented the archive being rewritten, if any
+	HeldBy *int `json:"held_by,omitempty"`
+
+	RecordCount int    `json:"record_count"`
+	OldHash     string `json:"old_hash"`
+	NewHash     string `json:"new_hash,omitempty"`
+	NewSize     int64  `json:"new_size,omitempty"`
+	NewURL      string `json:"new_url,omitempty"`
+}
+
+// ErasureReport is the record of erasing a contact's records from the archives of an org, listing every archive which
+// had any of them
+type ErasureReport struct {
+	OrgID       int              `json:"org_id"`
+	ContactUUID string           `json:"contact_uuid"`
+	Redact      bool             `json:"redact"`
+	DryRun      bool             `json:"dry_run"`
+	StartedOn   time.Time        `json:"started_on"`
+	FinishedOn  time.Time        `json:"finished_on"`
+	Archives    []*ErasedArchive `json:"archives"`
+	Found       int              `json:"found"`
+	Erased      int              `json:"erased"`
+}
+
+// EraseContact rewrites every archive of the passed in org which has records of a contact, daily and monthly, with
+// those records removed or masked, uploading each new archive and updating its hash, size and record count before
+// deleting the old one from S3. Archives under a legal hold or which have been purged are left as they are. Returns
+// a report of every archive which had any of the contact's records, which is also returned with what was done so far
+// on error.
+func EraseContact(ctx context.Context, db *sqlx.DB, s3Client s3iface.S3API, config *Config, org Org, options *ErasureOptions) (*ErasureReport, error) {
+	report := &ErasureReport{
+		OrgID:       org.ID,
+		ContactUUID: options.ContactUUID,
+		Redact:      options.Redact,
+		DryRun:      options.DryRun,
+		StartedOn:   time.Now(),
+		Archives:    make([]*ErasedArchive, 0),
+	}
+
+	for _, archiveType := range []ArchiveType{MessageType, RunType} {
+		archives, err := ListArchives(ctx, db, org, archiveType, DateRange{End: time.Now().AddDate(0, 1, 0)})
+		if err != nil {
+			return report, err
+		}
+
+		holds, err := GetActiveLegalHolds(ctx, db, org, archiveType)
+		if err != nil {
+			return report, err
+		}
+
+		for _, archive := range archives {
+			if archive.PurgedOn != nil || archive.URL == "" || archive.RecordCount == 0 {
+				continue
+			}
+
+			erased, err := eraseArchive(ctx, db, s3Client, config, archive, options, heldBy(holds, archive))
+			if erased != nil {
+				report.Archives = append(report.Archives, erased)
+				report.Found += erased.Found
+				report.Erased += erased.Erased
+			}
+			if err != nil {
+				report.FinishedOn = time.Now()
+				return report, err
+			}
+		}
+	}
+
+	report.FinishedOn = time.Now()
+	return report, nil
+}
+
+const updateErasedArchive = `
+UPDATE archives_archive
+SET hash = $2, size = $3, record_count = $4, url = $5, chain_hash = $6, redaction = $7
+WHERE id = $1
+`
+
+// eraseArchive rewrites the passed in archive without the records of the contact in the passed in options, unless it
+// is held by the passed in hold, returning what was done or nil if it has none of the contact's records
+func eraseArchive(ctx context.Context, db *sqlx.DB, s3Client s3iface.S3API, config *Config, archive *Archive, options *ErasureOptions, hold *LegalHold) (*ErasedArchive, error) {
+	log := logrus.WithFields(logrus.Fields{
+		"archive_id":   archive.ID,
+		"org_id":       archive.OrgID,
+		"archive_type": archive.ArchiveType,
+		"start_date":   archive.StartDate,
+		"period":       archive.Period,
+	})
+
+	file, err := ioutil.TempFile(config.TempDir, fmt.Sprintf("erasure_%d_", archive.ID))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating temp file for erasure of archive: %d", archive.ID)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	// read the decompressed archive through a pipe once it has been downloaded and its hash verified
+	reader, pipeWriter := io.Pipe()
+	go func() {
+		pipeWriter.CloseWithError(streamArchive(ctx, s3Client, archive, config.TempDir, pipeWriter))
+	}()
+
+	hash := md5.New()
+	gzWriter := gzip.NewWriter(io.MultiWriter(file, hash))
+	var chain *RecordChain
+	var records io.Writer = gzWriter
+	if archive.ChainHash != nil {
+		chain = NewRecordChain()
+		records = io.MultiWriter(gzWriter, chain)
+	}
+	writer := bufio.NewWriter(records)
+
+	found, kept, err := eraseRecords(archive, reader, options, writer)
+	reader.Close()
+	if err != nil {
+		return nil, err
+	}
+	if found == 0 {
+		return nil, nil
+	}
+
+	erased := &ErasedArchive{
+		ArchiveID:   archive.ID,
+		ArchiveType: archive.ArchiveType,
+		StartDate:   archive.StartDate,
+		Period:      archive.Period,
+		Found:       found,
+		RecordCount: archive.RecordCount,
+		OldHash:     archive.Hash,
+	}
+	if hold != nil {
+		erased.HeldBy = &hold.ID
+		log.WithField("hold_id", hold.ID).Warn("archive has records of erased contact but is under legal hold, skipping")
+		return erased, nil
+	}
+	if options.DryRun {
+		return erased, nil
+	}
+
+	if err = writer.Flush(); err != nil {
+		return erased, errors.Wrapf(err, "error flushing erased archive file")
+	}
+	if err = gzWriter.Close(); err != nil {
+		return erased, errors.Wrapf(err, "error closing erased archive gzip writer")
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		return erased, errors.Wrapf(err, "error calculating erased archive size")
+	}
+
+	// upload our rewritten archive alongside the old one, it has a different hash so a different key
+	oldURL := archive.URL
+	rewritten := *archive
+	rewritten.ArchiveFile = file.Name()
+	rewritten.Hash = hex.EncodeToString(hash.Sum(nil))
+	rewritten.Size = stat.Size()
+	rewritten.RecordCount = kept
+	if chain != nil {
+		chainHash := chain.Hash()
+		rewritten.ChainHash = &chainHash
+	}
+	if options.Redact {
+		rewritten.Redaction = erasureProfile(archive.Redaction)
+	}
+
+	err = UploadToS3(ctx, s3Client, config.S3Bucket, archiveS3Path(&rewritten), &rewritten)
+	if err != nil {
+		return erased, errors.Wrapf(err, "error uploading erased archive: %d", archive.ID)
+	}
+
+	_, err = db.ExecContext(ctx, updateErasedArchive, archive.ID, rewritten.Hash, rewritten.Size, rewritten.RecordCount, rewritten.URL, rewritten.ChainHash, rewritten.Redaction)
+	if err != nil {
+		return erased, errors.Wrapf(err, "error updating erased archive: %d", archive.ID)
+	}
+	*archive = rewritten
+	archive.ArchiveFile = ""
+
+	erased.Erased = found
+	erased.RecordCount = kept
+	erased.NewHash = rewritten.Hash
+	erased.NewSize = rewritten.Size
+	erased.NewURL = rewritten.URL
+
+	// only now that nothing points at it can we remove the old archive
+	if oldURL != rewritten.URL {
+		err = DeleteS3File(ctx, s3Client, oldURL)
+		if err != nil {
+			return erased, errors.Wrapf(err, "error deleting S3 object: %s", oldURL)
+		}
+	}
+
+	log.WithFields(logrus.Fields{"erased": found, "record_count": kept, "url": rewritten.URL}).Info("erased contact records from archive")
+	return erased, nil
+}
+
+// eraseRecords copies the passed in decompressed records of an archive to the passed in writer, removing or masking
+// those of the contact in the passed in options. Returns how many of the contact's records were found and how many
+// records were written.
+func eraseRecords(archive *Archive, reader io.Reader, options *ErasureOptions, writer io.Writer) (int, int, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxArchivedRecordSize)
+
+	found, kept := 0, 0
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		record := &searchedRecord{}
+		err := json.Unmarshal(line, record)
+		if err != nil {
+			return found, kept, errors.Wrapf(err, "error parsing record in archive: %d", archive.ID)
+		}
+
+		if record.Contact.UUID == options.ContactUUID {
+			found++
+			if !options.Redact {
+				continue
+			}
+			for _, path := range erasureMasks[archive.ArchiveType] {
+				line, err = redactPath(line, strings.Split(path, "."), RedactMask, nil)
+				if err != nil {
+					return found, kept, errors.Wrapf(err, "error masking record in archive: %d", archive.ID)
+				}
+			}
+		}
+
+		_, err = writer.Write(line)
+		if err == nil {
+			_, err = writer.Write([]byte{'\n'})
+		}
+		if err != nil {
+			return found, kept, errors.Wrapf(err, "error writing erased archive record")
+		}
+		kept++
+	}
+	if scanner.Err() != nil {
+		return found, kept, errors.Wrapf(scanner.Err(), "error reading archive: %d", archive.ID)
+	}
+	return found, kept, nil
+}
+
+// erasureProfile returns the redaction profile to record on an archive with the passed in profile once records have
+// been masked by erasure
+func erasureProfile(profile *string) *string {
+	name := erasureRedaction
+	if profile != nil && *profile != erasureRedaction && !strings.HasSuffix(*profile, ","+erasureRedaction) {
+		name = *profile + "," + erasureRedaction
+	} else if profile != nil {
+		name = *profile
+	}
+	return &name
+}
@@ -0,0 +1,60 @@
+package archiver
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEraseRecords(t *testing.T) {
+	records := strings.Join([]string{
+		`{"id":1,"contact":{"uuid":"c1","name":"Bob"},"urn":"tel:+250788123123","text":"hi","attachments":[{"content_type":"image/jpeg","url":"http://x/1.jpg"}]}`,
+		`{"id":2,"contact":{"uuid":"c2","name":"Ann"},"urn":"tel:+250788000000","text":"hello","attachments":[]}`,
+		`{"id":3,"contact":{"uuid":"c1","name":"Bob"},"urn":"tel:+250788123123","text":"bye","attachments":[]}`,
+	}, "\n") + "\n"
+	archive := &Archive{ID: 12, ArchiveType: MessageType}
+
+	// records of the contact are removed
+	out := &bytes.Buffer{}
+	found, kept, err := eraseRecords(archive, strings.NewReader(records), &ErasureOptions{ContactUUID: "c1"}, out)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, found)
+	assert.Equal(t, 1, kept)
+	assert.Equal(t, `{"id":2,"contact":{"uuid":"c2","name":"Ann"},"urn":"tel:+250788000000","text":"hello","attachments":[]}`+"\n", out.String())
+
+	// or masked, keeping their place
+	out.Reset()
+	found, kept, err = eraseRecords(archive, strings.NewReader(records), &ErasureOptions{ContactUUID: "c1", Redact: true}, out)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, found)
+	assert.Equal(t, 3, kept)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if assert.Equal(t, 3, len(lines)) {
+		assert.NotContains(t, lines[0], "Bob")
+		assert.NotContains(t, lines[0], "+250788123123")
+		assert.NotContains(t, lines[0], "1.jpg")
+		assert.NotContains(t, lines[0], `"hi"`)
+		assert.Contains(t, lines[1], "Ann")
+		assert.NotContains(t, lines[2], "bye")
+	}
+
+	// archives without the contact's records are untouched
+	out.Reset()
+	found, kept, err = eraseRecords(archive, strings.NewReader(records), &ErasureOptions{ContactUUID: "c3"}, out)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, found)
+	assert.Equal(t, 3, kept)
+	assert.Equal(t, records, out.String())
+
+	_, _, err = eraseRecords(archive, strings.NewReader("{\"id\":\n"), &ErasureOptions{ContactUUID: "c1"}, out)
+	assert.EqualError(t, err, "error parsing record in archive: 12: unexpected end of JSON input")
+
+	// redacting an archive adds erasure to its redaction profile once
+	profile := "strict"
+	assert.Equal(t, "erasure", *erasureProfile(nil))
+	assert.Equal(t, "strict,erasure", *erasureProfile(&profile))
+	profile = "strict,erasure"
+	assert.Equal(t, "strict,erasure", *erasureProfile(&profile))
+}
@@ -0,0 +1,178 @@
+package archiver
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// the classes of errors, which callers can use to decide whether what failed is worth retrying
+var (
+	// ErrTransient is the class of errors which are likely to go away if retried, such as deadlocks, lost connections
+	// and deadlines exceeded
+	ErrTransient = errors.New("transient error")
+
+	// ErrSchemaMismatch is the class of errors from a database whose schema we don't support, which won't go away until
+	// it is migrated
+	ErrSchemaMismatch = errors.New("database schema mismatch")
+
+	// ErrUploadFailed is the class of errors uploading archives to S3
+	ErrUploadFailed = errors.New("upload failed")
+
+	// ErrVerification is the class of errors from archives which don't match what they should, such as a record count
+	// which doesn't match the database or an invalid file, which retrying straight away is unlikely to fix
+	ErrVerification = errors.New("verification failed")
+)
+
+// classifiedError is an error marked with its class where it happened
+type classifiedError struct {
+	class error
+	cause error
+}
+
+func (e *classifiedError) Error() string { return e.cause.Error() }
+func (e *classifiedError) Cause() error  { return e.cause }
+func (e *classifiedError) Unwrap() error { return e.cause }
+func (e *classifiedError) Is(target error) bool {
+	return target == e.class
+}
+
+// classify marks the passed in error as being of the passed in class, returning nil if it is nil
+func classify(class error, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{class: class, cause: err}
+}
+
+// ArchiveError is an error building an archive, with which archive it was and the class of the error, if known
+type ArchiveError struct {
+	Class       error
+	OrgID       int
+	ArchiveType ArchiveType
+	Period      ArchivePeriod
+	StartDate   time.Time
+	Err         error
+}
+
+// newArchiveError wraps the passed in error building the passed in archive with its org, type and period, and its class
+func newArchiveError(archive *Archive, err error) *ArchiveError {
+	return &ArchiveError{
+		Class:       ErrorClass(err),
+		OrgID:       archive.OrgID,
+		ArchiveType: archive.ArchiveType,
+		Period:      archive.Period,
+		StartDate:   archive.StartDate,
+		Err:         err,
+	}
+}
+
+func (e *ArchiveError) Error() string {
+	period := "day " + e.StartDate.In(time.UTC).Format("2006-01-02")
+	if e.Period == MonthPeriod {
+		period = "month " + e.StartDate.In(time.UTC).Format("2006-01")
+	}
+	return fmt.Sprintf("error building %s archive of org %d for %s: %s", e.ArchiveType, e.OrgID, period, e.Err.Error())
+}
+
+// Cause returns the error which failed the build, for errors.Cause
+func (e *ArchiveError) Cause() error { return e.Err }
+
+// Unwrap returns the error which failed the build, for errors.Is and errors.As
+func (e *ArchiveError) Unwrap() error { return e.Err }
+
+// Is returns whether the passed in target is the class of this error
+func (e *ArchiveError) Is(target error) bool {
+	return e.Class != nil && target == e.Class
+}
+
+// ErrorClass returns the class of the passed in error, one of ErrTransient, ErrSchemaMismatch, ErrUploadFailed or
+// ErrVerification, or nil if it isn't one we know. Errors marked with a class where they happened have that class,
+// otherwise database errors which are worth retrying, network errors and exceeded deadlines are transient.
+func ErrorClass(err error) error {
+	for err != nil {
+		switch e := err.(type) {
+		case *classifiedError:
+			return e.class
+		case *ArchiveError:
+			if e.Class != nil {
+				return e.Class
+			}
+		}
+
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+
+	if isTransient(err) {
+		return ErrTransient
+	}
+	return nil
+}
+
+// IsRetryable returns whether what failed with the passed in error is worth retrying straight away. Schema mismatches,
+// verification failures and removals which need confirming won't go away by themselves, everything else is retried.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Cause(err) == ErrRemovalNotConfirmed {
+		return false
+	}
+	class := ErrorClass(err)
+	return class != ErrSchemaMismatch && class != ErrVerification
+}
+
+// the classes of Postgres errors which are worth retrying: serialization failures and deadlocks, lost connections,
+// too many connections, cancelled statements and the server shutting down
+var transientPostgresCodes = []string{"40", "08", "53300", "57014", "57P01", "57P02", "57P03"}
+
+// isTransient returns whether the passed in error, the root cause of a failure, is likely to go away if retried
+func isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == context.DeadlineExceeded || err == driver.ErrBadConn {
+		return true
+	}
+	if pqErr, ok := err.(*pq.Error); ok {
+		for _, code := range transientPostgresCodes {
+			if strings.HasPrefix(string(pqErr.Code), code) {
+				return true
+			}
+		}
+		return false
+	}
+	_, isNetError := err.(net.Error)
+	return isNetError
+}
+
+// failureReason returns the reason the passed in error failed an archive, without the archive it was as that is
+// recorded alongside it
+func failureReason(err error) string {
+	if archiveErr, ok := err.(*ArchiveError); ok {
+		return archiveErr.Err.Error()
+	}
+	return err.Error()
+}
+
+// errorClassName returns the name of the class of the passed in error, empty if it doesn't have one
+func errorClassName(err error) string {
+	switch ErrorClass(err) {
+	case ErrTransient:
+		return "transient"
+	case ErrSchemaMismatch:
+		return "schema_mismatch"
+	case ErrUploadFailed:
+		return "upload_failed"
+	case ErrVerification:
+		return "verification"
+	}
+	return ""
+}
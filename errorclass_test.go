@@ -0,0 +1,51 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorClass(t *testing.T) {
+	archive := &Archive{OrgID: 5, ArchiveType: RunType, Period: DayPeriod, StartDate: time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)}
+
+	for _, tc := range []struct {
+		err       error
+		class     error
+		retryable bool
+	}{
+		{fmt.Errorf("boom"), nil, true},
+		{errors.Wrap(&pq.Error{Code: "40P01"}, "error deleting"), ErrTransient, true},
+		{errors.Wrap(&pq.Error{Code: "57014"}, "error exporting"), ErrTransient, true},
+		{errors.Wrap(&pq.Error{Code: "42703"}, "error exporting"), nil, true},
+		{errors.Wrap(&net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}, "error connecting"), ErrTransient, true},
+		{errors.Wrap(context.DeadlineExceeded, "error exporting"), ErrTransient, true},
+		{errors.Wrap(classify(ErrUploadFailed, context.DeadlineExceeded), "error writing archive to s3"), ErrUploadFailed, true},
+		{errors.Wrap(classify(ErrVerification, fmt.Errorf("record count mismatch")), "error writing archive"), ErrVerification, false},
+		{classify(ErrSchemaMismatch, fmt.Errorf("missing: msgs_msg.uuid")), ErrSchemaMismatch, false},
+		{errors.Wrap(ErrRemovalNotConfirmed, "error confirming deletion"), nil, false},
+	} {
+		assert.Equal(t, tc.class, ErrorClass(tc.err), "class mismatch for %s", tc.err)
+		assert.Equal(t, tc.retryable, IsRetryable(tc.err), "retryable mismatch for %s", tc.err)
+	}
+	assert.False(t, IsRetryable(nil))
+	assert.Nil(t, classify(ErrTransient, nil))
+
+	// errors building archives carry which archive it was and their class
+	err := newArchiveError(archive, errors.Wrap(classify(ErrVerification, fmt.Errorf("record count mismatch")), "error writing archive"))
+	assert.EqualError(t, err, "error building run archive of org 5 for day 2017-10-01: error writing archive: record count mismatch")
+	assert.Equal(t, ErrVerification, err.Class)
+	assert.Equal(t, ErrVerification, ErrorClass(errors.Wrap(err, "error creating archives")))
+	assert.Equal(t, "error writing archive: record count mismatch", failureReason(err))
+	assert.Equal(t, "verification", errorClassName(err))
+	assert.Equal(t, "", errorClassName(fmt.Errorf("boom")))
+
+	archive.Period = MonthPeriod
+	assert.EqualError(t, newArchiveError(archive, fmt.Errorf("boom")), "error building run archive of org 5 for month 2017-10: boom")
+}
@@ -0,0 +1,139 @@
+package archiver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// events we publish
+const (
+	EventArchiveCommitted = "archive_committed"
+	EventRecordArchived   = "record_archived"
+)
+
+// Publisher publishes messages to a stream or queue, such as a Kafka topic, so that consumers hear about archives as
+// they are created without polling S3. Messages are keyed by org, so a partitioned stream keeps those of each org in
+// order. It is called synchronously as each archive is committed, so must be safe to call concurrently.
+type Publisher interface {
+	Publish(ctx context.Context, key string, value []byte) error
+}
+
+// NewPublisher creates a publisher to the Kafka topic, SQS queue or SNS topic of the passed in config, returning nil if
+// none is configured
+func NewPublisher(config *Config) (Publisher, error) {
+	if config.KafkaBrokers != "" {
+		return NewKafkaPublisher(config), nil
+	}
+	return NewAWSPublisher(config)
+}
+
+// ArchiveEvent is the message published for each archive once it has been committed
+type ArchiveEvent struct {
+	Event       string        `json:"event"`
+	ArchiveID   int           `json:"archive_id"`
+	OrgID       int           `json:"org_id"`
+	ArchiveType ArchiveType   `json:"archive_type"`
+	Period      ArchivePeriod `json:"period"`
+	StartDate   string        `json:"start_date"`
+	EndDate     string        `json:"end_date"`
+	URL         string        `json:"url"`
+	Hash        string        `json:"hash"`
+	RecordCount int           `json:"record_count"`
+	Size        int64         `json:"size"`
+	Rollup      bool          `json:"rollup"`
+	CommittedOn time.Time     `json:"committed_on"`
+}
+
+// NewArchiveEvent returns the event of the passed in archive, which has been committed
+func NewArchiveEvent(archive *Archive) *ArchiveEvent {
+	return &ArchiveEvent{
+		Event:       EventArchiveCommitted,
+		ArchiveID:   archive.ID,
+		OrgID:       archive.OrgID,
+		ArchiveType: archive.ArchiveType,
+		Period:      archive.Period,
+		StartDate:   archive.StartDate.In(time.UTC).Format("2006-01-02"),
+		EndDate:     archive.endDate().In(time.UTC).Format("2006-01-02"),
+		URL:         archive.URL,
+		Hash:        archive.Hash,
+		RecordCount: archive.RecordCount,
+		Size:        archive.Size,
+		Rollup:      len(archive.Dailies) > 0,
+		CommittedOn: archive.CreatedOn,
+	}
+}
+
+// RecordEvent is the message published for each record of an archive once it has been committed, if we publish records
+type RecordEvent struct {
+	Event       string          `json:"event"`
+	ArchiveID   int             `json:"archive_id"`
+	OrgID       int             `json:"org_id"`
+	ArchiveType ArchiveType     `json:"archive_type"`
+	Record      json.RawMessage `json:"record"`
+}
+
+// publishArchive publishes the event of the passed in archive, which has just been committed, to the passed in
+// publisher, if there is one, after its records if we are configured to publish them. The records of
+// rollups aren't published, as they were with the dailies they were rolled up from, nor are those of archives which
+// weren't uploaded, as they are read back from S3.
+func publishArchive(ctx context.Context, config *Config, s3Client s3iface.S3API, publisher Publisher, archive *Archive) error {
+	if publisher == nil {
+		return nil
+	}
+
+	key := fmt.Sprint(archive.OrgID)
+	if config.PublishRecords && archive.URL != "" && archive.RecordCount > 0 && len(archive.Dailies) == 0 {
+		err := publishRecords(ctx, publisher, s3Client, key, archive)
+		if err != nil {
+			return err
+		}
+	}
+
+	event, err := json.Marshal(NewArchiveEvent(archive))
+	if err != nil {
+		return errors.Wrapf(err, "error marshalling archive event")
+	}
+	err = publisher.Publish(ctx, key, event)
+	if err != nil {
+		return errors.Wrapf(err, "error publishing event of archive: %d", archive.ID)
+	}
+	return nil
+}
+
+// publishRecords reads the passed in archive back from S3 and publishes each of its records as it is read. If the file
+// doesn't match its hash, we only find out once it has all been read, so its records have been published but not its
+// event, which consumers should wait for before acting on them.
+func publishRecords(ctx context.Context, publisher Publisher, s3Client s3iface.S3API, key string, archive *Archive) error {
+	reader, err := OpenArchiveWithHash(ctx, s3Client, archive.URL, archive.Hash)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	event := &RecordEvent{Event: EventRecordArchived, ArchiveID: archive.ID, OrgID: archive.OrgID, ArchiveType: archive.ArchiveType}
+	for reader.Next() {
+		event.Record = reader.Record()
+		value, err := json.Marshal(event)
+		if err != nil {
+			return errors.Wrapf(err, "error marshalling record %d of archive: %d", reader.Count(), archive.ID)
+		}
+		err = publisher.Publish(ctx, key, value)
+		if err != nil {
+			return errors.Wrapf(err, "error publishing record %d of archive: %d", reader.Count(), archive.ID)
+		}
+	}
+	return reader.Err()
+}
+
+// logPublishError logs an error publishing the passed in archive, which doesn't undo it having been committed
+func logPublishError(archive *Archive, err error) {
+	if err != nil {
+		logrus.WithError(err).WithField("archive_id", archive.ID).WithField("org_id", archive.OrgID).Error("error publishing archive")
+	}
+}
@@ -0,0 +1,136 @@
+package archiver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+)
+
+// testPublisher is a publisher which records the messages published to it, failing if told to
+type testPublisher struct {
+	mutex    sync.Mutex
+	keys     []string
+	messages []map[string]interface{}
+	fail     bool
+}
+
+func (p *testPublisher) Publish(ctx context.Context, key string, value []byte) error {
+	if p.fail {
+		return errors.New("broker unavailable")
+	}
+	message := make(map[string]interface{})
+	if err := json.Unmarshal(value, &message); err != nil {
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.keys = append(p.keys, key)
+	p.messages = append(p.messages, message)
+	return nil
+}
+
+// fileS3 is an S3 client which serves a single file for every object
+type fileS3 struct {
+	s3iface.S3API
+	file []byte
+}
+
+func (c *fileS3) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(c.file))}, nil
+}
+
+func TestPublishArchive(t *testing.T) {
+	file := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(file)
+	gzipWriter.Write([]byte("{\"id\":1,\"text\":\"hi\"}\n{\"id\":2,\"text\":\"there\"}\n"))
+	gzipWriter.Close()
+	sum := md5.Sum(file.Bytes())
+
+	config := NewConfig()
+	client := &fileS3{file: file.Bytes()}
+	archive := &Archive{
+		ID:          3,
+		OrgID:       2,
+		ArchiveType: MessageType,
+		Period:      DayPeriod,
+		StartDate:   time.Date(2017, 8, 12, 0, 0, 0, 0, time.UTC),
+		CreatedOn:   time.Date(2017, 8, 13, 1, 0, 0, 0, time.UTC),
+		RecordCount: 2,
+		Size:        int64(file.Len()),
+		Hash:        hex.EncodeToString(sum[:]),
+		URL:         "https://dl-archiver-test.s3.amazonaws.com/2/message_D20170812.jsonl.gz",
+	}
+
+	// nothing is published without a publisher
+	assert.NoError(t, publishArchive(context.Background(), config, client, nil, archive))
+
+	publisher := &testPublisher{}
+	ctx := context.Background()
+	assert.NoError(t, publishArchive(ctx, config, client, publisher, archive))
+	assert.Equal(t, []string{"2"}, publisher.keys)
+	assert.Equal(t, map[string]interface{}{
+		"event":        "archive_committed",
+		"archive_id":   float64(3),
+		"org_id":       float64(2),
+		"archive_type": "message",
+		"period":       "D",
+		"start_date":   "2017-08-12",
+		"end_date":     "2017-08-13",
+		"url":          "https://dl-archiver-test.s3.amazonaws.com/2/message_D20170812.jsonl.gz",
+		"hash":         archive.Hash,
+		"record_count": float64(2),
+		"size":         float64(file.Len()),
+		"rollup":       false,
+		"committed_on": "2017-08-13T01:00:00Z",
+	}, publisher.messages[0])
+
+	// records are published before the event of their archive
+	config.PublishRecords = true
+	publisher = &testPublisher{}
+	assert.NoError(t, publishArchive(ctx, config, client, publisher, archive))
+	assert.Equal(t, []string{"2", "2", "2"}, publisher.keys)
+	assert.Equal(t, map[string]interface{}{
+		"event":        "record_archived",
+		"archive_id":   float64(3),
+		"org_id":       float64(2),
+		"archive_type": "message",
+		"record":       map[string]interface{}{"id": float64(1), "text": "hi"},
+	}, publisher.messages[0])
+	assert.Equal(t, float64(2), publisher.messages[1]["record"].(map[string]interface{})["id"])
+	assert.Equal(t, "archive_committed", publisher.messages[2]["event"])
+
+	// but not those of rollups, which were published with their dailies
+	publisher.keys = nil
+	rollup := *archive
+	rollup.Period = MonthPeriod
+	rollup.Dailies = []*Archive{archive}
+	assert.NoError(t, publishArchive(ctx, config, client, publisher, &rollup))
+	assert.Equal(t, []string{"2"}, publisher.keys)
+
+	// archives which don't match their hash have their records published but not their event
+	publisher.keys = nil
+	archive.Hash = "d41d8cd98f00b204e9800998ecf8427e"
+	err := publishArchive(ctx, config, client, publisher, archive)
+	assert.EqualError(t, err, "archive hash mismatch, expected d41d8cd98f00b204e9800998ecf8427e, got "+hex.EncodeToString(sum[:]))
+	assert.Equal(t, []string{"2", "2"}, publisher.keys)
+
+	publisher.fail = true
+	config.PublishRecords = false
+	err = publishArchive(ctx, config, client, publisher, archive)
+	assert.EqualError(t, err, "error publishing event of archive: 3: broker unavailable")
+}
@@ -0,0 +1,144 @@
+package archiver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ExportPlan is how Postgres plans to run the query which exports the records of an archive
+type ExportPlan struct {
+	Archive *Archive
+
+	// the estimated total cost and rows of the plan
+	Cost float64
+	Rows float64
+
+	// the archived tables the plan reads with a sequential scan rather than an index
+	SeqScans []string
+
+	// the plan itself as returned by EXPLAIN in JSON
+	Plan string
+}
+
+// planNode is a node of a plan returned by EXPLAIN (FORMAT JSON), with only the fields we look at
+type planNode struct {
+	NodeType     string     `json:"Node Type"`
+	RelationName string     `json:"Relation Name"`
+	TotalCost    float64    `json:"Total Cost"`
+	PlanRows     float64    `json:"Plan Rows"`
+	Plans        []planNode `json:"Plans"`
+}
+
+// archivedTables are the tables which records of each type are exported from
+var archivedTables = map[ArchiveType]string{
+	MessageType: "msgs_msg",
+	RunType:     "flows_flowrun",
+}
+
+// ExplainExport asks Postgres how it plans to run the query which exports the first page of records of the passed in
+// archive with the passed in config, without running it. A sequential scan over msgs_msg or flows_flowrun means an
+// export will read the whole table for every page, which on a large database can take hours.
+func ExplainExport(ctx context.Context, db *sqlx.DB, config *Config, archive *Archive) (*ExportPlan, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	query, params := exportQuery(archive, config.exportsGoJSON())
+	if query == "" {
+		return nil, fmt.Errorf("unknown archive type: %s", archive.ArchiveType)
+	}
+	if config.ExportPageSize > 0 {
+		params[len(params)-1] = config.ExportPageSize
+	}
+
+	var output string
+	err := db.GetContext(ctx, &output, "EXPLAIN (FORMAT JSON) "+query, params...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error explaining export query for org: %d", archive.Org.ID)
+	}
+
+	return parseExportPlan(archive, output)
+}
+
+// parseExportPlan parses the passed in output of EXPLAIN (FORMAT JSON) for the export of the passed in archive
+func parseExportPlan(archive *Archive, output string) (*ExportPlan, error) {
+	explained := []struct {
+		Plan planNode `json:"Plan"`
+	}{}
+	err := json.Unmarshal([]byte(output), &explained)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing export query plan")
+	}
+	if len(explained) == 0 {
+		return nil, errors.New("export query plan is empty")
+	}
+
+	root := explained[0].Plan
+	plan := &ExportPlan{
+		Archive:  archive,
+		Cost:     root.TotalCost,
+		Rows:     root.PlanRows,
+		SeqScans: findSeqScans(root, archivedTables[archive.ArchiveType], nil),
+		Plan:     output,
+	}
+	return plan, nil
+}
+
+// findSeqScans appends the passed in table to found for every sequential scan of it in the passed in plan node or
+// its children, parallel scans have the same node type
+func findSeqScans(node planNode, table string, found []string) []string {
+	if node.NodeType == "Seq Scan" && node.RelationName == table {
+		found = append(found, node.RelationName)
+	}
+	for _, child := range node.Plans {
+		found = findSeqScans(child, table, found)
+	}
+	return found
+}
+
+// HasSeqScans returns whether this plan reads an archived table with a sequential scan
+func (p *ExportPlan) HasSeqScans() bool {
+	return len(p.SeqScans) > 0
+}
+
+// Suggestion returns the index we suggest creating to avoid the sequential scans in this plan, or an empty string if
+// there are none
+func (p *ExportPlan) Suggestion() string {
+	if !p.HasSeqScans() {
+		return ""
+	}
+
+	table := p.SeqScans[0]
+	for _, idx := range ArchiveIndexes {
+		if idx.Table == table {
+			return fmt.Sprintf("create %s with `rp-archiver indexes create`, or a permanent index on %s(%s)", idx.Name, idx.Table, idx.Columns)
+		}
+	}
+	return fmt.Sprintf("add an index on %s", table)
+}
+
+// ExplainOrgExport explains the export query for the oldest missing daily archive of the passed in org and type, which
+// is the next one a run would build, or if it has none, for the newest day which can be archived, see ExplainExport
+func ExplainOrgExport(ctx context.Context, now time.Time, config *Config, db *sqlx.DB, org Org, archiveType ArchiveType) (*ExportPlan, error) {
+	missing, err := GetMissingDailyArchives(ctx, db, now, org, archiveType)
+	if err != nil {
+		return nil, err
+	}
+
+	archive := &Archive{
+		Org:         org,
+		OrgID:       org.ID,
+		StartDate:   newestEligibleDay(now, org),
+		ArchiveType: archiveType,
+		Period:      DayPeriod,
+	}
+	if len(missing) > 0 {
+		archive = missing[0]
+	}
+
+	return ExplainExport(ctx, db, config, archive)
+}
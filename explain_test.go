@@ -0,0 +1,79 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplainExport(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	for _, recordJSON := range []string{RecordJSONDB, RecordJSONGo} {
+		config.RecordJSON = recordJSON
+		for _, archiveType := range []ArchiveType{MessageType, RunType} {
+			plan, err := ExplainOrgExport(ctx, now, config, db, orgs[1], archiveType)
+			assert.NoError(t, err)
+			assert.NotEqual(t, "", plan.Plan)
+			assert.True(t, plan.Cost > 0)
+
+			// we explain the oldest missing archive
+			missing, err := GetMissingDailyArchives(ctx, db, now, orgs[1], archiveType)
+			assert.NoError(t, err)
+			assert.True(t, missing[0].StartDate.Equal(plan.Archive.StartDate))
+		}
+	}
+
+	// without a page size we explain the query without a limit
+	config.ExportPageSize = 0
+	plan, err := ExplainOrgExport(ctx, now, config, db, orgs[2], RunType)
+	assert.NoError(t, err)
+	assert.NotEqual(t, "", plan.Plan)
+}
+
+func TestParseExportPlan(t *testing.T) {
+	archive := &Archive{ArchiveType: MessageType}
+
+	plan, err := parseExportPlan(archive, `[{"Plan": {
+		"Node Type": "Limit", "Total Cost": 1234.5, "Plan Rows": 100, "Plans": [
+			{"Node Type": "Index Scan", "Relation Name": "msgs_msg", "Plans": [
+				{"Node Type": "Seq Scan", "Relation Name": "contacts_contact"}
+			]}
+		]
+	}}]`)
+	assert.NoError(t, err)
+	assert.Equal(t, 1234.5, plan.Cost)
+	assert.Equal(t, float64(100), plan.Rows)
+	assert.False(t, plan.HasSeqScans())
+	assert.Equal(t, "", plan.Suggestion())
+
+	plan, err = parseExportPlan(archive, `[{"Plan": {
+		"Node Type": "Limit", "Plans": [
+			{"Node Type": "Sort", "Plans": [
+				{"Node Type": "Seq Scan", "Relation Name": "msgs_msg", "Parallel Aware": true}
+			]}
+		]
+	}}]`)
+	assert.NoError(t, err)
+	assert.True(t, plan.HasSeqScans())
+	assert.Equal(t, []string{"msgs_msg"}, plan.SeqScans)
+	assert.Equal(t, "create archiver_msgs_msg_org_created with `rp-archiver indexes create`, or a permanent index on msgs_msg(org_id, created_on, id)", plan.Suggestion())
+
+	// runs only care about scans of runs
+	plan, err = parseExportPlan(&Archive{ArchiveType: RunType}, `[{"Plan": {"Node Type": "Seq Scan", "Relation Name": "msgs_msg"}}]`)
+	assert.NoError(t, err)
+	assert.False(t, plan.HasSeqScans())
+
+	_, err = parseExportPlan(archive, `[]`)
+	assert.Error(t, err)
+	_, err = parseExportPlan(archive, `not json`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,248 @@
+package archiver
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const lookupOrg = `
+SELECT o.id, o.name, l.iso_code as language, o.created_on, o.is_anon
+FROM orgs_org o
+LEFT JOIN orgs_language l ON l.id = primary_language_id
+WHERE o.id = $1
+`
+
+// GetOrg returns the org with the passed in id
+func GetOrg(ctx context.Context, db *sqlx.DB, conf *Config, orgID int) (Org, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	org := Org{RetentionPeriod: conf.RetentionPeriod, S3Prefix: conf.s3Prefix()}
+	err := db.GetContext(ctx, &org, lookupOrg, orgID)
+	if err != nil {
+		return org, errors.Wrapf(err, "error fetching org: %d", orgID)
+	}
+
+	orgs := []Org{org}
+	err = applyRetentionPolicy(conf, orgs)
+	if err != nil {
+		return org, errors.Wrapf(err, "error applying retention policy")
+	}
+
+	err = applyRedactionPolicy(conf, orgs)
+	if err != nil {
+		return org, errors.Wrapf(err, "error applying redaction policy")
+	}
+
+	err = applySchemaProfile(conf, orgs)
+	if err != nil {
+		return org, errors.Wrapf(err, "error applying schema profile")
+	}
+	return orgs[0], nil
+}
+
+// GetCoveringArchives returns the smallest set of archives for the passed in org and type which cover the date range
+// [start, end), monthly archives are used in preference to their dailies and archives overlapping the range are included
+// in full
+func GetCoveringArchives(ctx context.Context, db *sqlx.DB, org Org, archiveType ArchiveType, start time.Time, end time.Time) ([]*Archive, error) {
+	archives, err := ListArchives(ctx, db, org, archiveType, DateRange{Start: start, End: end})
+	if err != nil {
+		return nil, err
+	}
+
+	// monthlies sort before dailies with the same start, so skip anything already covered
+	covering := make([]*Archive, 0, len(archives))
+	coveredUntil := time.Time{}
+	for _, a := range archives {
+		if a.StartDate.Before(coveredUntil) {
+			continue
+		}
+
+		covering = append(covering, a)
+		coveredUntil = a.endDate()
+	}
+
+	return covering, nil
+}
+
+// GetStreamableArchives returns the archives with the passed in ids, erroring if any don't exist or have been purged
+// from S3. They are sorted by org, type and start date so that the days of a month are streamed in order regardless of
+// the order their ids were passed in.
+func GetStreamableArchives(ctx context.Context, db *sqlx.DB, ids []int) ([]*Archive, error) {
+	archives := make([]*Archive, 0, len(ids))
+	for _, id := range ids {
+		archive, err := GetArchive(ctx, db, id)
+		if err != nil {
+			return nil, err
+		}
+		if archive == nil {
+			return nil, errors.Errorf("no archive with id: %d", id)
+		}
+		if archive.PurgedOn != nil {
+			return nil, errors.Errorf("archive %d has been purged from S3", id)
+		}
+		archives = append(archives, archive)
+	}
+
+	sort.SliceStable(archives, func(i, j int) bool {
+		a, b := archives[i], archives[j]
+		if a.OrgID != b.OrgID {
+			return a.OrgID < b.OrgID
+		}
+		if a.ArchiveType != b.ArchiveType {
+			return a.ArchiveType < b.ArchiveType
+		}
+		return a.StartDate.Before(b.StartDate)
+	})
+	return archives, nil
+}
+
+// StreamArchives downloads each of the passed in archives in turn, writing their decompressed contents to the passed
+// in writer as one continuous JSONL stream. Each archive is downloaded to the passed in temp directory and its hash
+// verified before any of it is written, and we stop with an error at the first archive that doesn't match. Returns
+// the number of records written.
+func StreamArchives(ctx context.Context, s3Client s3iface.S3API, archives []*Archive, tempDir string, writer io.Writer) (int, error) {
+	records := 0
+	for _, archive := range archives {
+		// nothing to read in empty archives
+		if archive.RecordCount == 0 {
+			continue
+		}
+
+		start := time.Now()
+
+		err := streamArchive(ctx, s3Client, archive, tempDir, writer)
+		if err != nil {
+			return records, err
+		}
+		records += archive.RecordCount
+
+		logrus.WithFields(logrus.Fields{
+			"archive_id":   archive.ID,
+			"org_id":       archive.OrgID,
+			"archive_type": archive.ArchiveType,
+			"start_date":   archive.StartDate,
+			"period":       archive.Period,
+			"record_count": archive.RecordCount,
+			"elapsed":      time.Since(start),
+		}).Debug("streamed archive")
+	}
+
+	return records, nil
+}
+
+// streamArchive writes the decompressed contents of a single archive to the passed in writer, once it has been
+// downloaded to the passed in temp directory and its hash verified
+func streamArchive(ctx context.Context, s3Client s3iface.S3API, archive *Archive, tempDir string, writer io.Writer) error {
+	reader, err := GetS3File(ctx, s3Client, archive.URL)
+	if err != nil {
+		return errors.Wrapf(err, "error reading S3 URL: %s", archive.URL)
+	}
+	defer reader.Close()
+
+	return streamContents(archive, reader, tempDir, writer)
+}
+
+// streamContents downloads the passed in gzipped archive contents to a temp file in the passed in directory and, only
+// if their hash matches, writes them decompressed to the passed in writer
+func streamContents(archive *Archive, reader io.Reader, tempDir string, writer io.Writer) error {
+	file, err := ioutil.TempFile(tempDir, fmt.Sprintf("stream_%d_", archive.ID))
+	if err != nil {
+		return errors.Wrapf(err, "error creating temp file for archive: %d", archive.ID)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	hash := md5.New()
+	_, err = io.Copy(io.MultiWriter(file, hash), reader)
+	if err != nil {
+		return errors.Wrapf(err, "error downloading URL: %s", archive.URL)
+	}
+
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if actual != archive.Hash {
+		return fmt.Errorf("archive %d hash mismatch. expected: %s, got %s", archive.ID, archive.Hash, actual)
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		return errors.Wrapf(err, "error reading downloaded archive: %d", archive.ID)
+	}
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return errors.Wrapf(err, "error creating gzip reader for URL: %s", archive.URL)
+	}
+	defer gzipReader.Close()
+
+	_, err = io.Copy(writer, gzipReader)
+	if err != nil {
+		return errors.Wrapf(err, "error streaming URL: %s", archive.URL)
+	}
+	return nil
+}
+
+// DownloadArchive downloads the passed in archive from S3 to the passed in writer, gzipped as it was stored or, if
+// decompress is set, decompressed. The hash and size of the object are verified as it is read, and an error is
+// returned if they don't match those recorded for the archive, in which case what was written can't be trusted.
+func DownloadArchive(ctx context.Context, s3Client s3iface.S3API, archive *Archive, writer io.Writer, decompress bool) error {
+	reader, err := GetS3File(ctx, s3Client, archive.URL)
+	if err != nil {
+		return errors.Wrapf(err, "error reading S3 URL: %s", archive.URL)
+	}
+	defer reader.Close()
+
+	return downloadContents(archive, reader, writer, decompress)
+}
+
+// downloadContents copies the passed in gzipped archive contents to the passed in writer, verifying their hash and size
+func downloadContents(archive *Archive, reader io.Reader, writer io.Writer, decompress bool) error {
+	hash := md5.New()
+	counted := &countingReader{reader: io.TeeReader(reader, hash)}
+
+	if decompress {
+		gzipReader, err := gzip.NewReader(counted)
+		if err != nil {
+			return errors.Wrapf(err, "error creating gzip reader for URL: %s", archive.URL)
+		}
+		defer gzipReader.Close()
+
+		_, err = io.Copy(writer, gzipReader)
+		if err != nil {
+			return errors.Wrapf(err, "error decompressing URL: %s", archive.URL)
+		}
+
+		// drain anything left after the gzip trailer so our hash and size cover the whole object
+		_, err = io.Copy(ioutil.Discard, counted)
+		if err != nil {
+			return errors.Wrapf(err, "error reading URL: %s", archive.URL)
+		}
+	} else {
+		_, err := io.Copy(writer, counted)
+		if err != nil {
+			return errors.Wrapf(err, "error downloading URL: %s", archive.URL)
+		}
+	}
+
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if actual != archive.Hash {
+		return fmt.Errorf("archive %d hash mismatch. expected: %s, got %s", archive.ID, archive.Hash, actual)
+	}
+	if counted.count != archive.Size {
+		return fmt.Errorf("archive %d size mismatch. expected: %d, got %d", archive.ID, archive.Size, counted.count)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package archiver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetCoveringArchives(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+	config := NewConfig()
+
+	org, err := GetOrg(ctx, db, config, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "Org 3", org.Name)
+
+	// our monthly for september should be used instead of the daily it covers
+	archives, err := GetCoveringArchives(ctx, db, org, MessageType, time.Date(2017, 8, 1, 0, 0, 0, 0, time.UTC), time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(archives))
+	assert.Equal(t, time.Date(2017, 8, 10, 0, 0, 0, 0, time.UTC), archives[0].StartDate)
+	assert.Equal(t, DayPeriod, archives[0].Period)
+	assert.Equal(t, time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC), archives[1].StartDate)
+	assert.Equal(t, MonthPeriod, archives[1].Period)
+
+	// a range in the middle of a month still includes the monthly
+	archives, err = GetCoveringArchives(ctx, db, org, MessageType, time.Date(2017, 9, 15, 0, 0, 0, 0, time.UTC), time.Date(2017, 9, 16, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(archives))
+	assert.Equal(t, MonthPeriod, archives[0].Period)
+
+	// nothing for runs
+	archives, err = GetCoveringArchives(ctx, db, org, RunType, time.Date(2017, 8, 1, 0, 0, 0, 0, time.UTC), time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(archives))
+}
+
+func TestGetStreamableArchives(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	// archives are sorted by org, then by start date, regardless of the order of their ids
+	archives, err := GetStreamableArchives(ctx, db, []int{2, 4, 1, 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(archives))
+	assert.Equal(t, 4, archives[0].ID)
+	assert.Equal(t, 1, archives[1].ID)
+	assert.Equal(t, 3, archives[2].ID)
+	assert.Equal(t, 2, archives[3].ID)
+
+	_, err = GetStreamableArchives(ctx, db, []int{1, 1000})
+	assert.EqualError(t, err, "no archive with id: 1000")
+
+	db.MustExec(`UPDATE archives_archive SET purged_on = NOW() WHERE id = 2`)
+
+	_, err = GetStreamableArchives(ctx, db, []int{1, 2})
+	assert.EqualError(t, err, "archive 2 has been purged from S3")
+}
+
+func TestDownloadContents(t *testing.T) {
+	contents := &bytes.Buffer{}
+	gz := gzip.NewWriter(contents)
+	gz.Write([]byte("{\"id\": 1}\n{\"id\": 2}\n"))
+	gz.Close()
+
+	hash := md5.Sum(contents.Bytes())
+	archive := &Archive{ID: 1, Hash: hex.EncodeToString(hash[:]), Size: int64(contents.Len()), RecordCount: 2}
+
+	// gzipped as stored
+	out := &bytes.Buffer{}
+	err := downloadContents(archive, bytes.NewReader(contents.Bytes()), out, false)
+	assert.NoError(t, err)
+	assert.Equal(t, contents.Bytes(), out.Bytes())
+
+	// decompressed
+	out.Reset()
+	err = downloadContents(archive, bytes.NewReader(contents.Bytes()), out, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"id\": 1}\n{\"id\": 2}\n", out.String())
+
+	// hash doesn't match
+	archive = &Archive{ID: 1, Hash: "abc", Size: int64(contents.Len())}
+	err = downloadContents(archive, bytes.NewReader(contents.Bytes()), &bytes.Buffer{}, false)
+	assert.EqualError(t, err, fmt.Sprintf("archive 1 hash mismatch. expected: abc, got %s", hex.EncodeToString(hash[:])))
+
+	// size doesn't match
+	archive = &Archive{ID: 1, Hash: hex.EncodeToString(hash[:]), Size: 10}
+	err = downloadContents(archive, bytes.NewReader(contents.Bytes()), &bytes.Buffer{}, true)
+	assert.EqualError(t, err, fmt.Sprintf("archive 1 size mismatch. expected: 10, got %d", contents.Len()))
+
+	// not gzipped
+	err = downloadContents(archive, bytes.NewReader([]byte("not gzipped")), &bytes.Buffer{}, true)
+	assert.Error(t, err)
+}
+
+func TestStreamContents(t *testing.T) {
+	contents := &bytes.Buffer{}
+	gz := gzip.NewWriter(contents)
+	gz.Write([]byte("{\"id\": 1}\n{\"id\": 2}\n"))
+	gz.Close()
+
+	hash := md5.Sum(contents.Bytes())
+	archive := &Archive{ID: 1, Hash: hex.EncodeToString(hash[:]), RecordCount: 2}
+
+	out := &bytes.Buffer{}
+	err := streamContents(archive, bytes.NewReader(contents.Bytes()), "/tmp", out)
+	assert.NoError(t, err)
+	assert.Equal(t, "{\"id\": 1}\n{\"id\": 2}\n", out.String())
+
+	// nothing is written if the hash doesn't match
+	out.Reset()
+	archive.Hash = "abc"
+	err = streamContents(archive, bytes.NewReader(contents.Bytes()), "/tmp", out)
+	assert.EqualError(t, err, fmt.Sprintf("archive 1 hash mismatch. expected: abc, got %s", hex.EncodeToString(hash[:])))
+	assert.Equal(t, "", out.String())
+}
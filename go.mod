@@ -7,6 +7,7 @@ require (
 	github.com/evalphobia/logrus_sentry v0.4.5
 	github.com/getsentry/raven-go v0.0.0-20180430182053-263040ce1a36 // indirect
 	github.com/go-ini/ini v1.36.0 // indirect
+	github.com/gomodule/redigo v1.8.2
 	github.com/jmespath/go-jmespath v0.0.0-20160202185014-0b12d6b521d8 // indirect
 	github.com/jmoiron/sqlx v1.2.0
 	github.com/kylelemons/godebug v1.1.0 // indirect
@@ -18,12 +19,13 @@ require (
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/sirupsen/logrus v1.0.5
 	github.com/smartystreets/goconvey v1.6.4 // indirect
-	github.com/stretchr/testify v1.2.1
+	github.com/stretchr/testify v1.5.1
 	golang.org/x/net v0.0.0-20191119073136-fc4aabc6c914 // indirect
 	google.golang.org/appengine v1.6.5 // indirect
 	gopkg.in/airbrake/gobrake.v2 v2.0.9 // indirect
 	gopkg.in/gemnasium/logrus-airbrake-hook.v2 v2.1.2 // indirect
 	gopkg.in/ini.v1 v1.51.0 // indirect
+	gopkg.in/yaml.v2 v2.2.4
 )
 
 go 1.13
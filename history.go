@@ -0,0 +1,198 @@
+package archiver
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ArchiverRun is an entry in our history of archiving runs, ie: a single pass of our loop over all active orgs
+type ArchiverRun struct {
+	ID              int64      `db:"id"`
+	StartedOn       time.Time  `db:"started_on"`
+	FinishedOn      *time.Time `db:"finished_on"`
+	Config          string     `db:"config"`
+	OrgsProcessed   int        `db:"orgs_processed"`
+	ArchivesCreated int        `db:"archives_created"`
+	RecordsArchived int        `db:"records_archived"`
+	ArchivesDeleted int        `db:"archives_deleted"`
+	RecordsDeleted  int        `db:"records_deleted"`
+	FailureCount    int        `db:"failure_count"`
+}
+
+// ArchiverRunOrg is the outcome of archiving a single org and type during a run
+type ArchiverRunOrg struct {
+	ID              int64       `db:"id"`
+	RunID           int64       `db:"run_id"`
+	OrgID           int         `db:"org_id"`
+	ArchiveType     ArchiveType `db:"archive_type"`
+	StartedOn       time.Time   `db:"started_on"`
+	FinishedOn      time.Time   `db:"finished_on"`
+	Succeeded       bool        `db:"succeeded"`
+	ArchivesCreated int         `db:"archives_created"`
+	ArchivesFailed  int         `db:"archives_failed"`
+	RecordsArchived int         `db:"records_archived"`
+	ArchivesDeleted int         `db:"archives_deleted"`
+	RecordsDeleted  int         `db:"records_deleted"`
+	Error           *string     `db:"error"`
+}
+
+// runConfigSnapshot returns the JSON of the passed in config with anything secret blanked out, this is what we store
+// with each run so that we can tell which settings it ran with
+func runConfigSnapshot(config *Config) (string, error) {
+	snapshot := *config
+	snapshot.DB = ""
+	snapshot.DBPassword = ""
+	snapshot.ReplicaDB = ""
+	snapshot.SentryDSN = ""
+	snapshot.AWSAccessKeyID = ""
+	snapshot.AWSSecretAccessKey = ""
+	snapshot.WebhookSecret = ""
+	snapshot.SMTPPassword = ""
+	snapshot.RedisURL = ""
+	snapshot.RedactionSalt = ""
+
+	contents, err := json.Marshal(snapshot)
+	if err != nil {
+		return "", err
+	}
+	return string(contents), nil
+}
+
+const insertArchiverRun = `
+INSERT INTO archiver_runs(started_on, config, orgs_processed, archives_created, records_archived, archives_deleted, records_deleted, failure_count)
+VALUES($1, $2, 0, 0, 0, 0, 0, 0)
+RETURNING id
+`
+
+// StartArchiverRun adds a new run started at the passed in time to our history, returning its id
+func StartArchiverRun(ctx context.Context, db *sqlx.DB, config *Config, startedOn time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	snapshot, err := runConfigSnapshot(config)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error snapshotting config for run")
+	}
+
+	var id int64
+	err = db.GetContext(ctx, &id, insertArchiverRun, startedOn, snapshot)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error inserting archiver run")
+	}
+	return id, nil
+}
+
+const insertArchiverRunOrg = `
+INSERT INTO archiver_run_orgs(run_id, org_id, archive_type, started_on, finished_on, succeeded, archives_created, archives_failed,
+	records_archived, archives_deleted, records_deleted, error)
+VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+`
+
+// RecordArchiverRunOrg adds the outcome of archiving the passed in org and type, ie: the result of ArchiveOrg, to the
+// history of the passed in run. It only succeeded if there was no error and every archive it tried to build was built.
+func RecordArchiverRunOrg(ctx context.Context, db *sqlx.DB, runID int64, org Org, archiveType ArchiveType, startedOn time.Time, finishedOn time.Time, created []*Archive, deleted []*Archive, err error) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	outcome := &ArchiverRunOrg{RunID: runID, OrgID: org.ID, ArchiveType: archiveType, StartedOn: startedOn, FinishedOn: finishedOn}
+	problems := make([]string, 0)
+
+	for _, a := range created {
+		if a.ID == 0 {
+			outcome.ArchivesFailed++
+			if a.BuildError != nil {
+				problems = append(problems, a.StartDate.In(time.UTC).Format("2006-01-02")+": "+failureReason(a.BuildError))
+			}
+			continue
+		}
+		outcome.ArchivesCreated++
+		outcome.RecordsArchived += a.RecordCount
+	}
+	for _, a := range deleted {
+		outcome.ArchivesDeleted++
+		outcome.RecordsDeleted += a.RecordCount
+	}
+
+	if err != nil {
+		problems = append([]string{err.Error()}, problems...)
+	}
+	outcome.Succeeded = err == nil && outcome.ArchivesFailed == 0
+	if len(problems) > 0 {
+		summary := strings.Join(problems, "\n")
+		outcome.Error = &summary
+	}
+
+	_, err = db.ExecContext(ctx, insertArchiverRunOrg, outcome.RunID, outcome.OrgID, outcome.ArchiveType, outcome.StartedOn, outcome.FinishedOn,
+		outcome.Succeeded, outcome.ArchivesCreated, outcome.ArchivesFailed, outcome.RecordsArchived, outcome.ArchivesDeleted,
+		outcome.RecordsDeleted, outcome.Error)
+	if err != nil {
+		return errors.Wrapf(err, "error recording outcome of run: %d for org: %d and type: %s", runID, org.ID, archiveType)
+	}
+	return nil
+}
+
+const updateArchiverRunFinished = `
+UPDATE archiver_runs
+SET finished_on = $2, orgs_processed = $3, archives_created = $4, records_archived = $5, archives_deleted = $6, records_deleted = $7, failure_count = $8
+WHERE id = $1
+`
+
+// FinishArchiverRun marks the passed in run as finished, recording the totals from the passed in summary of it
+func FinishArchiverRun(ctx context.Context, db *sqlx.DB, runID int64, summary *RunSummary) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, updateArchiverRunFinished, runID, summary.FinishedOn, summary.OrgsProcessed, summary.ArchivesCreated,
+		summary.RecordsArchived, summary.ArchivesDeleted, summary.RecordsDeleted, len(summary.Failures))
+	if err != nil {
+		return errors.Wrapf(err, "error finishing archiver run: %d", runID)
+	}
+	return nil
+}
+
+const lookupArchiverRun = `
+SELECT id, started_on, finished_on, config::text as config, orgs_processed, archives_created, records_archived, archives_deleted,
+	records_deleted, failure_count
+FROM archiver_runs
+WHERE id = $1
+`
+
+// GetArchiverRun returns the run with the passed in id from our history
+func GetArchiverRun(ctx context.Context, db *sqlx.DB, runID int64) (*ArchiverRun, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	run := &ArchiverRun{}
+	err := db.GetContext(ctx, run, lookupArchiverRun, runID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up archiver run: %d", runID)
+	}
+	return run, nil
+}
+
+const lookupOrgRunHistory = `
+SELECT id, run_id, org_id, archive_type, started_on, finished_on, succeeded, archives_created, archives_failed, records_archived,
+	archives_deleted, records_deleted, error
+FROM archiver_run_orgs
+WHERE org_id = $1
+ORDER BY finished_on DESC, id DESC
+LIMIT $2
+`
+
+// GetOrgRunHistory returns the most recent outcomes of archiving the passed in org, newest first
+func GetOrgRunHistory(ctx context.Context, db *sqlx.DB, orgID int, limit int) ([]*ArchiverRunOrg, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	outcomes := make([]*ArchiverRunOrg, 0)
+	err := db.SelectContext(ctx, &outcomes, lookupOrgRunHistory, orgID, limit)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up run history for org: %d", orgID)
+	}
+	return outcomes, nil
+}
@@ -0,0 +1,85 @@
+package archiver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunHistory(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	config.AWSSecretAccessKey = "sesame"
+
+	start := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+	runID, err := StartArchiverRun(ctx, db, config, start)
+	assert.NoError(t, err)
+
+	run, err := GetArchiverRun(ctx, db, runID)
+	assert.NoError(t, err)
+	assert.True(t, start.Equal(run.StartedOn))
+	assert.Nil(t, run.FinishedOn)
+	assert.Contains(t, run.Config, `"RetentionPeriod": 90`)
+	assert.NotContains(t, run.Config, "sesame")
+
+	org := Org{ID: 2}
+	created := []*Archive{
+		{ID: 5, Period: DayPeriod, StartDate: time.Date(2017, 10, 9, 0, 0, 0, 0, time.UTC), RecordCount: 3},
+		{ID: 0, Period: DayPeriod, StartDate: time.Date(2017, 10, 10, 0, 0, 0, 0, time.UTC), BuildError: errors.New("error writing archive file")},
+	}
+	deleted := []*Archive{{ID: 4, RecordCount: 2}}
+
+	err = RecordArchiverRunOrg(ctx, db, runID, org, MessageType, start, start.Add(time.Minute), created, deleted, nil)
+	assert.NoError(t, err)
+	err = RecordArchiverRunOrg(ctx, db, runID, org, RunType, start.Add(time.Minute), start.Add(2*time.Minute), nil, nil, nil)
+	assert.NoError(t, err)
+
+	summary := NewRunSummary(start)
+	summary.AddOrg(org, MessageType, created, deleted, nil)
+	summary.CompleteOrg()
+	summary.FinishedOn = start.Add(2 * time.Minute)
+	assert.NoError(t, FinishArchiverRun(ctx, db, runID, summary))
+
+	run, err = GetArchiverRun(ctx, db, runID)
+	assert.NoError(t, err)
+	assert.True(t, summary.FinishedOn.Equal(*run.FinishedOn))
+	assert.Equal(t, 1, run.OrgsProcessed)
+	assert.Equal(t, 1, run.ArchivesCreated)
+	assert.Equal(t, 3, run.RecordsArchived)
+	assert.Equal(t, 1, run.ArchivesDeleted)
+	assert.Equal(t, 2, run.RecordsDeleted)
+	assert.Equal(t, 1, run.FailureCount)
+
+	history, err := GetOrgRunHistory(ctx, db, 2, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(history))
+
+	// newest first, runs have nothing to archive so succeeded
+	assert.Equal(t, RunType, history[0].ArchiveType)
+	assert.True(t, history[0].Succeeded)
+	assert.Nil(t, history[0].Error)
+
+	// messages had an archive fail to build so did not
+	assert.Equal(t, MessageType, history[1].ArchiveType)
+	assert.False(t, history[1].Succeeded)
+	assert.Equal(t, 1, history[1].ArchivesCreated)
+	assert.Equal(t, 1, history[1].ArchivesFailed)
+	assert.Equal(t, 3, history[1].RecordsArchived)
+	assert.Equal(t, 1, history[1].ArchivesDeleted)
+	assert.Equal(t, 2, history[1].RecordsDeleted)
+	assert.Equal(t, "2017-10-10: error writing archive file", *history[1].Error)
+
+	// an error for the whole org and type comes first
+	err = RecordArchiverRunOrg(ctx, db, runID, Org{ID: 3}, MessageType, start, start, created, nil, errors.New("error rolling up archives"))
+	assert.NoError(t, err)
+
+	history, err = GetOrgRunHistory(ctx, db, 3, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(history))
+	assert.Equal(t, "error rolling up archives\n2017-10-10: error writing archive file", *history[0].Error)
+}
@@ -0,0 +1,64 @@
+package archiver
+
+import "context"
+
+// Hooks are functions called as archives move through the stages of archiving, so that embedders can add their own
+// metrics, notifications or catalog registration without changing ArchiveOrg. Any can be nil. They are called
+// synchronously from whichever goroutine is working on the archive, so must be safe to call concurrently and should
+// return quickly, and they are passed the archive itself so shouldn't modify it. All methods are safe to call on nil
+// hooks, in which case they do nothing.
+type Hooks struct {
+	// OnTaskStart is called before we start building an archive, daily, monthly or rolled up
+	OnTaskStart func(ctx context.Context, archive *Archive)
+
+	// OnArchiveBuilt is called once the file of an archive has been written, with its hash, size and record count set
+	OnArchiveBuilt func(ctx context.Context, archive *Archive)
+
+	// OnUploaded is called once the file of an archive has been uploaded, with its URL set
+	OnUploaded func(ctx context.Context, archive *Archive)
+
+	// OnCommitted is called once an archive has been written to the database, with its id set
+	OnCommitted func(ctx context.Context, archive *Archive)
+
+	// OnDeleted is called once the archived records of an archive have been deleted from the database
+	OnDeleted func(ctx context.Context, archive *Archive)
+
+	// OnError is called when building, writing or deleting the records of an archive fails
+	OnError func(ctx context.Context, archive *Archive, err error)
+}
+
+func (h *Hooks) taskStarted(ctx context.Context, archive *Archive) {
+	if h != nil && h.OnTaskStart != nil {
+		h.OnTaskStart(ctx, archive)
+	}
+}
+
+func (h *Hooks) archiveBuilt(ctx context.Context, archive *Archive) {
+	if h != nil && h.OnArchiveBuilt != nil {
+		h.OnArchiveBuilt(ctx, archive)
+	}
+}
+
+func (h *Hooks) uploaded(ctx context.Context, archive *Archive) {
+	if h != nil && h.OnUploaded != nil {
+		h.OnUploaded(ctx, archive)
+	}
+}
+
+func (h *Hooks) committed(ctx context.Context, archive *Archive) {
+	if h != nil && h.OnCommitted != nil {
+		h.OnCommitted(ctx, archive)
+	}
+}
+
+func (h *Hooks) deleted(ctx context.Context, archive *Archive) {
+	if h != nil && h.OnDeleted != nil {
+		h.OnDeleted(ctx, archive)
+	}
+}
+
+func (h *Hooks) failed(ctx context.Context, archive *Archive, err error) {
+	if h != nil && h.OnError != nil {
+		h.OnError(ctx, archive, err)
+	}
+}
@@ -0,0 +1,72 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingArchiveStore struct {
+	testArchiveStore
+}
+
+func (s *failingArchiveStore) WriteArchive(ctx context.Context, archive *Archive) error {
+	if archive.Period == MonthPeriod {
+		return fmt.Errorf("boom")
+	}
+	return s.testArchiveStore.WriteArchive(ctx, archive)
+}
+
+func TestHooks(t *testing.T) {
+	org := Org{ID: 1, Name: "Test", CreatedOn: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), RetentionPeriod: 90}
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+	jan := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	oct1 := time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	store := &failingArchiveStore{testArchiveStore{
+		monthly: []*Archive{{Org: org, OrgID: org.ID, ArchiveType: MessageType, Period: MonthPeriod, StartDate: jan}},
+		daily:   []*Archive{{Org: org, OrgID: org.ID, ArchiveType: MessageType, Period: DayPeriod, StartDate: oct1}},
+	}}
+	source := &testRecordSource{records: map[time.Time][]string{jan: {`{"id":1}`}, oct1: {`{"id":2}`}}}
+
+	var mutex sync.Mutex
+	events := make([]string, 0)
+	record := func(event string) func(context.Context, *Archive) {
+		return func(ctx context.Context, archive *Archive) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			events = append(events, fmt.Sprintf("%s %s", event, archive.Period))
+		}
+	}
+	hooks := &Hooks{
+		OnTaskStart:    record("start"),
+		OnArchiveBuilt: record("built"),
+		OnUploaded:     record("uploaded"),
+		OnCommitted:    record("committed"),
+		OnError: func(ctx context.Context, archive *Archive, err error) {
+			record("error")(ctx, archive)
+			assert.EqualError(t, err, "error building message archive of org 1 for month 2017-01: error writing record to db: boom")
+		},
+	}
+
+	config := NewConfig()
+	config.UploadToS3 = false
+
+	ctx := context.Background()
+	_, err := CreateOrgArchives(ctx, now, config, nil, nil, org, MessageType, &Options{Store: store, Source: source, Hooks: hooks})
+	assert.NoError(t, err)
+
+	// archives are built concurrently with the previous being written, so the events of each are only ordered among themselves
+	assert.ElementsMatch(t, []string{"start M", "built M", "error M", "start D", "built D", "committed D"}, events)
+
+	// hooks are optional
+	var none *Hooks
+	none.taskStarted(ctx, store.daily[0])
+	none.failed(ctx, store.daily[0], err)
+	(&Hooks{}).deleted(ctx, store.daily[0])
+	assert.Nil(t, (*Options)(nil).hooks())
+}
@@ -0,0 +1,178 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ArchiveIndex is a partial index which only covers the rows still waiting to be archived, these speed up
+// our export and deletion queries during a backfill without paying for a permanent index on the whole table
+type ArchiveIndex struct {
+	Name    string
+	Table   string
+	Columns string
+	Column  string
+}
+
+// ArchiveIndexes are the partial indexes we recommend creating before a large backfill
+var ArchiveIndexes = []ArchiveIndex{
+	{Name: "archiver_msgs_msg_org_created", Table: "msgs_msg", Columns: "org_id, created_on, id", Column: "created_on"},
+	{Name: "archiver_flows_flowrun_org_modified", Table: "flows_flowrun", Columns: "org_id, modified_on, id", Column: "modified_on"},
+}
+
+// IndexStatus is the current state of one of our archive indexes in the database
+type IndexStatus struct {
+	Name   string `db:"name"`
+	Table  string `db:"table_name"`
+	Exists bool   `db:"index_exists"`
+	Valid  bool   `db:"is_valid"`
+	Size   int64  `db:"size"`
+}
+
+// IndexCutoff returns the date our partial indexes should cover up to, this is the first of the month after the
+// last date we would archive given the passed in retention period so that the index covers the whole backfill
+func IndexCutoff(now time.Time, retentionPeriod int) time.Time {
+	lastActive := now.AddDate(0, 0, -retentionPeriod)
+	return time.Date(lastActive.Year(), lastActive.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0)
+}
+
+const lookupIndexStatus = `
+SELECT
+	$1::text as name,
+	$2::text as table_name,
+	i.indexrelid IS NOT NULL as index_exists,
+	coalesce(i.indisvalid, FALSE) as is_valid,
+	coalesce(pg_relation_size(i.indexrelid), 0) as size
+FROM (SELECT 1) AS d
+LEFT JOIN pg_class c ON c.relname = $1 AND c.relkind = 'i'
+LEFT JOIN pg_index i ON i.indexrelid = c.oid
+`
+
+// GetArchiveIndexStatus returns the status of each of our recommended archive indexes
+func GetArchiveIndexStatus(ctx context.Context, db *sqlx.DB) ([]*IndexStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	statuses := make([]*IndexStatus, 0, len(ArchiveIndexes))
+	for _, idx := range ArchiveIndexes {
+		status := &IndexStatus{}
+		err := db.GetContext(ctx, status, lookupIndexStatus, idx.Name, idx.Table)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error looking up status of index: %s", idx.Name)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+const lookupIndexProgress = `
+SELECT phase, blocks_done, blocks_total, tuples_done, tuples_total
+FROM pg_stat_progress_create_index
+WHERE relid = $1::regclass
+`
+
+// logIndexProgress polls postgres for the progress of an index build until the passed in channel is closed, this
+// view is only available on Postgres 12 and above, on older versions we just log that we are still building
+func logIndexProgress(ctx context.Context, db *sqlx.DB, idx ArchiveIndex, done chan bool) {
+	log := logrus.WithField("index", idx.Name).WithField("table", idx.Table)
+	start := time.Now()
+	supported := true
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(time.Second * 30):
+		}
+
+		if supported {
+			var phase string
+			var blocksDone, blocksTotal, tuplesDone, tuplesTotal int64
+			err := db.QueryRowxContext(ctx, lookupIndexProgress, idx.Table).Scan(&phase, &blocksDone, &blocksTotal, &tuplesDone, &tuplesTotal)
+			if err == nil {
+				log.WithFields(logrus.Fields{
+					"phase":        phase,
+					"blocks_done":  blocksDone,
+					"blocks_total": blocksTotal,
+					"tuples_done":  tuplesDone,
+					"tuples_total": tuplesTotal,
+					"elapsed":      time.Since(start),
+				}).Info("building index")
+				continue
+			}
+
+			log.WithError(err).Debug("unable to read index progress, falling back to elapsed time")
+			supported = false
+		}
+
+		log.WithField("elapsed", time.Since(start)).Info("building index")
+	}
+}
+
+// CreateArchiveIndexes concurrently creates our partial indexes covering rows created before the passed in cutoff,
+// any invalid indexes left behind by a previously interrupted build are dropped and rebuilt
+func CreateArchiveIndexes(ctx context.Context, db *sqlx.DB, cutoff time.Time) error {
+	statuses, err := GetArchiveIndexStatus(ctx, db)
+	if err != nil {
+		return err
+	}
+
+	for i, idx := range ArchiveIndexes {
+		log := logrus.WithField("index", idx.Name).WithField("table", idx.Table).WithField("cutoff", cutoff)
+
+		if statuses[i].Exists && statuses[i].Valid {
+			log.Info("index already exists, skipping")
+			continue
+		}
+
+		if statuses[i].Exists {
+			log.Warn("dropping invalid index left by an interrupted build")
+			_, err = db.ExecContext(ctx, fmt.Sprintf(`DROP INDEX CONCURRENTLY IF EXISTS %s`, idx.Name))
+			if err != nil {
+				return errors.Wrapf(err, "error dropping invalid index: %s", idx.Name)
+			}
+		}
+
+		start := time.Now()
+		log.Info("creating index")
+
+		done := make(chan bool)
+		go logIndexProgress(ctx, db, idx, done)
+
+		// our cutoff is a date we format ourselves, postgres requires partial index predicates to be constants
+		_, err = db.ExecContext(ctx, fmt.Sprintf(
+			`CREATE INDEX CONCURRENTLY IF NOT EXISTS %s ON %s(%s) WHERE %s < '%s'`,
+			idx.Name, idx.Table, idx.Columns, idx.Column, cutoff.Format("2006-01-02"),
+		))
+		close(done)
+
+		if err != nil {
+			return errors.Wrapf(err, "error creating index: %s", idx.Name)
+		}
+
+		log.WithField("elapsed", time.Since(start)).Info("index created")
+	}
+
+	return nil
+}
+
+// DropArchiveIndexes concurrently drops our partial indexes, this should be done once a backfill is complete
+func DropArchiveIndexes(ctx context.Context, db *sqlx.DB) error {
+	for _, idx := range ArchiveIndexes {
+		start := time.Now()
+
+		_, err := db.ExecContext(ctx, fmt.Sprintf(`DROP INDEX CONCURRENTLY IF EXISTS %s`, idx.Name))
+		if err != nil {
+			return errors.Wrapf(err, "error dropping index: %s", idx.Name)
+		}
+
+		logrus.WithField("index", idx.Name).WithField("elapsed", time.Since(start)).Info("index dropped")
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveIndexes(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2017, 11, 1, 0, 0, 0, 0, time.UTC), IndexCutoff(now, 90))
+	assert.Equal(t, time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), IndexCutoff(now, 30))
+
+	statuses, err := GetArchiveIndexStatus(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, len(ArchiveIndexes), len(statuses))
+	for _, s := range statuses {
+		assert.False(t, s.Exists)
+	}
+
+	err = CreateArchiveIndexes(ctx, db, IndexCutoff(now, 90))
+	assert.NoError(t, err)
+
+	statuses, err = GetArchiveIndexStatus(ctx, db)
+	assert.NoError(t, err)
+	for _, s := range statuses {
+		assert.True(t, s.Exists)
+		assert.True(t, s.Valid)
+	}
+
+	// creating again is a noop
+	err = CreateArchiveIndexes(ctx, db, IndexCutoff(now, 90))
+	assert.NoError(t, err)
+
+	err = DropArchiveIndexes(ctx, db)
+	assert.NoError(t, err)
+
+	statuses, err = GetArchiveIndexStatus(ctx, db)
+	assert.NoError(t, err)
+	for _, s := range statuses {
+		assert.False(t, s.Exists)
+	}
+}
@@ -0,0 +1,132 @@
+package archiver
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// InventoryStatus is the status of an object in an inventory of our bucket
+type InventoryStatus string
+
+// the statuses of objects in an inventory, objects are only present, missing or orphaned once diffed with a listing
+const (
+	InventoryExpected = InventoryStatus("expected")
+	InventoryPresent  = InventoryStatus("present")
+	InventoryMissing  = InventoryStatus("missing")
+	InventoryOrphaned = InventoryStatus("orphaned")
+)
+
+// archiveObjectKey matches the keys of archive files in our bucket, ie: 5/message_D20170812_<hash>.jsonl.gz, which
+// our manifests, Athena partitions and copied attachments don't
+var archiveObjectKey = regexp.MustCompile(`(^|/)\d+/(message|run)_[DM]\d{6,8}_[^/]*\.jsonl\.gz$`)
+
+// InventoryObject is an S3 object in an inventory of our bucket, either the file of a committed archive or, when
+// diffed with a listing of the bucket, an archive file which no archive refers to
+type InventoryObject struct {
+	Bucket      string          `json:"bucket"`
+	Key         string          `json:"key"`
+	ArchiveID   int             `json:"archive_id,omitempty"`
+	OrgID       int             `json:"org_id,omitempty"`
+	ArchiveType ArchiveType     `json:"archive_type,omitempty"`
+	Period      ArchivePeriod   `json:"period,omitempty"`
+	StartDate   string          `json:"start_date,omitempty"`
+	Size        int64           `json:"size"`
+	Hash        string          `json:"hash,omitempty"`
+	Status      InventoryStatus `json:"status"`
+}
+
+const lookupInventoryArchives = selectArchiveFields + `
+WHERE url != '' AND purged_on IS NULL
+ORDER BY org_id asc, archive_type asc, start_date asc, period desc
+`
+
+// GetArchiveInventory returns the S3 object every committed archive expects to have, that is every archive with a URL
+// which hasn't been purged, of every org, in the order of their orgs, types and dates. Keys don't have a leading slash,
+// as they are listed by S3.
+func GetArchiveInventory(ctx context.Context, db *sqlx.DB) ([]*InventoryObject, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+
+	archives := make([]*Archive, 0)
+	err := db.SelectContext(ctx, &archives, lookupInventoryArchives)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing archives for inventory")
+	}
+
+	objects := make([]*InventoryObject, 0, len(archives))
+	for _, a := range archives {
+		u, err := url.Parse(a.URL)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing URL of archive: %d", a.ID)
+		}
+		objects = append(objects, &InventoryObject{
+			Bucket:      strings.Split(u.Host, ".")[0],
+			Key:         strings.TrimPrefix(u.Path, "/"),
+			ArchiveID:   a.ID,
+			OrgID:       a.OrgID,
+			ArchiveType: a.ArchiveType,
+			Period:      a.Period,
+			StartDate:   a.StartDate.In(time.UTC).Format("2006-01-02"),
+			Size:        a.Size,
+			Hash:        a.Hash,
+			Status:      InventoryExpected,
+		})
+	}
+	return objects, nil
+}
+
+// DiffArchiveInventory diffs the passed in inventory with a listing of the passed in bucket under the passed in prefix,
+// marking each object expected in that bucket as present or missing, and adding any archive files which no archive
+// refers to as orphaned, after the expected objects and in the order of their keys. Objects expected in other buckets
+// are left as they are, and objects in the bucket which aren't archive files are ignored.
+func DiffArchiveInventory(ctx context.Context, s3Client s3iface.S3API, bucket string, prefix string, objects []*InventoryObject) ([]*InventoryObject, error) {
+	listed := make(map[string]int64)
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(bucket)}
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		input.Prefix = aws.String(prefix + "/")
+	}
+
+	err := s3Client.ListObjectsV2PagesWithContext(ctx, input, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, o := range page.Contents {
+			listed[aws.StringValue(o.Key)] = aws.Int64Value(o.Size)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing bucket: %s", bucket)
+	}
+
+	diffed := make([]*InventoryObject, 0, len(objects))
+	expected := make(map[string]bool, len(objects))
+	for _, o := range objects {
+		if o.Bucket == bucket {
+			expected[o.Key] = true
+			if _, found := listed[o.Key]; found {
+				o.Status = InventoryPresent
+			} else {
+				o.Status = InventoryMissing
+			}
+		}
+		diffed = append(diffed, o)
+	}
+
+	orphaned := make([]*InventoryObject, 0)
+	for key, size := range listed {
+		if !expected[key] && archiveObjectKey.MatchString(key) {
+			orphaned = append(orphaned, &InventoryObject{Bucket: bucket, Key: key, Size: size, Status: InventoryOrphaned})
+		}
+	}
+	sort.Slice(orphaned, func(i, j int) bool { return orphaned[i].Key < orphaned[j].Key })
+
+	return append(diffed, orphaned...), nil
+}
@@ -0,0 +1,72 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+)
+
+// listingS3 is an S3 client which lists the passed in keys and sizes, a page per key
+type listingS3 struct {
+	s3iface.S3API
+	keys   []string
+	prefix string
+}
+
+func (c *listingS3) ListObjectsV2PagesWithContext(ctx aws.Context, input *s3.ListObjectsV2Input, fn func(*s3.ListObjectsV2Output, bool) bool, opts ...request.Option) error {
+	c.prefix = aws.StringValue(input.Prefix)
+	for i, key := range c.keys {
+		page := &s3.ListObjectsV2Output{Contents: []*s3.Object{{Key: aws.String(key), Size: aws.Int64(int64(i + 1))}}}
+		if !fn(page, i == len(c.keys)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestInventory(t *testing.T) {
+	ctx := context.Background()
+	db := setup(t)
+
+	objects, err := GetArchiveInventory(ctx, db)
+	assert.NoError(t, err)
+	for _, o := range objects {
+		assert.Equal(t, InventoryExpected, o.Status)
+		assert.NotEqual(t, "/", o.Key[:1])
+	}
+}
+
+func TestDiffArchiveInventory(t *testing.T) {
+	ctx := context.Background()
+
+	objects := []*InventoryObject{
+		{Bucket: "dl-archiver-test", Key: "archives/2/message_D20170812_f9a8b7.jsonl.gz", ArchiveID: 1, OrgID: 2, Status: InventoryExpected},
+		{Bucket: "dl-archiver-test", Key: "archives/2/message_D20170813_0c1d2e.jsonl.gz", ArchiveID: 2, OrgID: 2, Status: InventoryExpected},
+		{Bucket: "old-archives", Key: "2/run_M201707_3b4c5d.jsonl.gz", ArchiveID: 3, OrgID: 2, Status: InventoryExpected},
+	}
+	client := &listingS3{keys: []string{
+		"archives/2/message_D20170812_f9a8b7.jsonl.gz",
+		"archives/2/message_D20170814_aaaaaa.jsonl.gz",
+		"archives/2/manifest.json",
+		"archives/athena/messages/org_id=2/month=2017-08/message_D20170812_f9a8b7.jsonl.gz",
+		"archives/3/run_M201707_bbbbbb.jsonl.gz",
+	}}
+
+	diffed, err := DiffArchiveInventory(ctx, client, "dl-archiver-test", "/archives/", objects)
+	assert.NoError(t, err)
+	assert.Equal(t, "archives/", client.prefix)
+
+	// archive files no archive refers to are orphaned, but not manifests or Athena partitions
+	assert.Equal(t, []*InventoryObject{
+		{Bucket: "dl-archiver-test", Key: "archives/2/message_D20170812_f9a8b7.jsonl.gz", ArchiveID: 1, OrgID: 2, Status: InventoryPresent},
+		{Bucket: "dl-archiver-test", Key: "archives/2/message_D20170813_0c1d2e.jsonl.gz", ArchiveID: 2, OrgID: 2, Status: InventoryMissing},
+		{Bucket: "old-archives", Key: "2/run_M201707_3b4c5d.jsonl.gz", ArchiveID: 3, OrgID: 2, Status: InventoryExpected},
+		{Bucket: "dl-archiver-test", Key: "archives/2/message_D20170814_aaaaaa.jsonl.gz", Size: 2, Status: InventoryOrphaned},
+		{Bucket: "dl-archiver-test", Key: "archives/3/run_M201707_bbbbbb.jsonl.gz", Size: 5, Status: InventoryOrphaned},
+	}, diffed)
+}
@@ -0,0 +1,326 @@
+package archiver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// how long finished jobs can still be polled for, and how long a job waits before trying again to take its org's lock
+var (
+	jobRetention    = time.Hour * 24
+	jobLockInterval = time.Second * 30
+)
+
+// JobState is the state of an on-demand archive job
+type JobState string
+
+// the states an on-demand archive job goes through
+const (
+	JobQueued    = JobState("queued")
+	JobRunning   = JobState("running")
+	JobCompleted = JobState("completed")
+	JobFailed    = JobState("failed")
+)
+
+// JobRequest is a request to archive the records of an org and type for a range of days, as posted to /archive. From
+// and To are each a day (2017-08-12) or a month (2017-08), and To is inclusive, defaulting to From.
+type JobRequest struct {
+	OrgID int    `json:"org"`
+	Type  string `json:"type"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// Job is an on-demand archive job, as returned by /archive when it is queued and /archive/<id> when it is polled. Its
+// end date is exclusive, and it counts the archives it built and their records.
+type Job struct {
+	ID          string      `json:"id"`
+	OrgID       int         `json:"org_id"`
+	ArchiveType ArchiveType `json:"archive_type"`
+	StartDate   time.Time   `json:"start_date"`
+	EndDate     time.Time   `json:"end_date"`
+	State       JobState    `json:"state"`
+	Archives    int         `json:"archives"`
+	RecordCount int         `json:"record_count"`
+	Error       string      `json:"error,omitempty"`
+	CreatedOn   time.Time   `json:"created_on"`
+	StartedOn   *time.Time  `json:"started_on,omitempty"`
+	FinishedOn  *time.Time  `json:"finished_on,omitempty"`
+}
+
+// Jobs is a queue of on-demand archive jobs which builds the missing daily archives of an org and type for a range of
+// days, running one job at a time alongside our regular runs. Jobs take the lock of their org, waiting for any run
+// archiving it to finish first, and are only kept in memory, so are lost if we are restarted. Like our runs, jobs wait
+// while we are paused and aren't started once we are shutting down.
+type Jobs struct {
+	ctx      context.Context
+	config   *Config
+	db       *sqlx.DB
+	s3Client s3iface.S3API
+	opts     *Options
+
+	mutex   sync.Mutex
+	jobs    map[string]*Job
+	queue   []*Job
+	working bool
+}
+
+// NewJobs creates a new job queue which runs its jobs with the passed in context and options, ie: the context of our
+// runs, which drains when we shut down, and options with our pauser, replica and publisher
+func NewJobs(ctx context.Context, config *Config, db *sqlx.DB, s3Client s3iface.S3API, opts *Options) *Jobs {
+	return &Jobs{ctx: ctx, config: config, db: db, s3Client: s3Client, opts: opts, jobs: make(map[string]*Job)}
+}
+
+// Queue validates the passed in request and queues a job for it, returning a copy of the job
+func (j *Jobs) Queue(request *JobRequest) (*Job, error) {
+	if request.OrgID <= 0 {
+		return nil, errors.Errorf("missing org")
+	}
+	archiveType, err := ParseArchiveType(request.Type)
+	if err != nil {
+		return nil, err
+	}
+	dates, err := parseJobDates(request.From, request.To)
+	if err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, errors.Wrapf(err, "error generating job id")
+	}
+
+	job := &Job{
+		ID:          hex.EncodeToString(id),
+		OrgID:       request.OrgID,
+		ArchiveType: archiveType,
+		StartDate:   dates.Start,
+		EndDate:     dates.End,
+		State:       JobQueued,
+		CreatedOn:   time.Now().In(time.UTC),
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	// forget about jobs which finished long enough ago that nobody should still be polling them
+	for id, old := range j.jobs {
+		if old.FinishedOn != nil && time.Since(*old.FinishedOn) > jobRetention {
+			delete(j.jobs, id)
+		}
+	}
+
+	j.jobs[job.ID] = job
+	j.queue = append(j.queue, job)
+	if !j.working {
+		j.working = true
+		go j.work()
+	}
+
+	copied := *job
+	return &copied, nil
+}
+
+// Get returns a copy of the job with the passed in id, nil if there isn't one
+func (j *Jobs) Get(id string) *Job {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	job := j.jobs[id]
+	if job == nil {
+		return nil
+	}
+	copied := *job
+	return &copied
+}
+
+// work runs our queued jobs in order until there are none left
+func (j *Jobs) work() {
+	for {
+		j.mutex.Lock()
+		if len(j.queue) == 0 {
+			j.working = false
+			j.mutex.Unlock()
+			return
+		}
+		job := j.queue[0]
+		j.queue = j.queue[1:]
+		j.mutex.Unlock()
+
+		archives, err := j.run(job)
+
+		j.mutex.Lock()
+		now := time.Now().In(time.UTC)
+		job.FinishedOn = &now
+		for _, a := range archives {
+			if a.State == ArchiveCommitted {
+				job.Archives++
+				job.RecordCount += a.RecordCount
+			} else if a.BuildError != nil && err == nil {
+				err = a.BuildError
+			}
+		}
+		if err != nil {
+			job.State = JobFailed
+			job.Error = err.Error()
+		} else {
+			job.State = JobCompleted
+		}
+		j.mutex.Unlock()
+
+		log := logrus.WithField("job_id", job.ID).WithField("org_id", job.OrgID).WithField("archive_type", job.ArchiveType).WithField("archives", job.Archives)
+		if err != nil {
+			log.WithError(err).Error("error running archive job")
+		} else {
+			log.Info("archive job completed")
+		}
+	}
+}
+
+// run runs the passed in job, returning the archives it built
+func (j *Jobs) run(job *Job) ([]*Archive, error) {
+	WaitWhilePaused(j.ctx, j.opts.pauser())
+	if reason := DrainReason(j.ctx); reason != nil {
+		return nil, reason
+	}
+
+	org, err := GetOrg(j.ctx, j.db, j.config, job.OrgID)
+	if err != nil {
+		return nil, err
+	}
+	org, err = ApplyOrgConfig(j.ctx, j.db, j.config, org)
+	if err != nil {
+		return nil, err
+	}
+
+	// wait for any run which is archiving this org to finish with it
+	var lock *Lock
+	for {
+		lock, err = TryLock(j.ctx, j.db, OrgLockKey(org.ID))
+		if err != nil {
+			return nil, err
+		}
+		if lock != nil {
+			break
+		}
+		if reason := DrainReason(j.ctx); reason != nil {
+			return nil, reason
+		}
+		select {
+		case <-j.ctx.Done():
+			return nil, j.ctx.Err()
+		case <-time.After(jobLockInterval):
+		}
+	}
+	defer func() {
+		err := lock.Release(context.Background())
+		if err != nil {
+			logrus.WithError(err).WithField("org_id", org.ID).Error("error releasing org lock")
+		}
+	}()
+
+	j.mutex.Lock()
+	now := time.Now().In(time.UTC)
+	job.State = JobRunning
+	job.StartedOn = &now
+	j.mutex.Unlock()
+
+	return CreateDateRangeArchives(j.ctx, j.config.Clock().Now(), j.config, j.db, j.s3Client, org, job.ArchiveType, DateRange{Start: job.StartDate, End: job.EndDate}, j.opts)
+}
+
+// ServeHTTP queues jobs posted to /archive and returns them from /archive/<id>, both requiring our status token
+func (j *Jobs) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, j.config) {
+		return
+	}
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/archive"), "/")
+	switch {
+	case id == "" && r.Method == http.MethodPost:
+		request := &JobRequest{}
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 10000)).Decode(request)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request: " + err.Error()})
+			return
+		}
+		job, err := j.Queue(request)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		w.Header().Set("Location", "/archive/"+job.ID)
+		writeJSON(w, http.StatusAccepted, job)
+
+	case id != "" && r.Method == http.MethodGet:
+		job := j.Get(id)
+		if job == nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "no such job"})
+			return
+		}
+		writeJSON(w, http.StatusOK, job)
+
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+}
+
+// authorize checks the passed in request has our status token as its bearer token, writing an error response if not
+func authorize(w http.ResponseWriter, r *http.Request, config *Config) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if config.StatusToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(config.StatusToken)) != 1 {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid or missing token"})
+		return false
+	}
+	return true
+}
+
+// parseJobDates parses the days or months of a job request into the range of days it covers
+func parseJobDates(from string, to string) (DateRange, error) {
+	if from == "" {
+		return DateRange{}, errors.Errorf("missing from date")
+	}
+	if to == "" {
+		to = from
+	}
+
+	start, _, err := parseJobDate(from)
+	if err != nil {
+		return DateRange{}, err
+	}
+	last, monthly, err := parseJobDate(to)
+	if err != nil {
+		return DateRange{}, err
+	}
+
+	end := last.AddDate(0, 0, 1)
+	if monthly {
+		end = last.AddDate(0, 1, 0)
+	}
+	if !start.Before(end) {
+		return DateRange{}, errors.Errorf("from date %s is after to date %s", from, to)
+	}
+	return DateRange{Start: start, End: end}, nil
+}
+
+// parseJobDate parses a day (2017-08-12) or month (2017-08), returning its start and whether it was a month
+func parseJobDate(value string) (time.Time, bool, error) {
+	if d, err := time.Parse("2006-01-02", value); err == nil {
+		return d, false, nil
+	}
+	if d, err := time.Parse("2006-01", value); err == nil {
+		return d, true, nil
+	}
+	return time.Time{}, false, errors.Errorf("invalid date %s, must be YYYY-MM-DD or YYYY-MM", value)
+}
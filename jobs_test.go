@@ -0,0 +1,105 @@
+package archiver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseJobDates(t *testing.T) {
+	dates, err := parseJobDates("2017-08-12", "")
+	assert.NoError(t, err)
+	assert.Equal(t, DateRange{Start: time.Date(2017, 8, 12, 0, 0, 0, 0, time.UTC), End: time.Date(2017, 8, 13, 0, 0, 0, 0, time.UTC)}, dates)
+
+	dates, err = parseJobDates("2017-08-12", "2017-09")
+	assert.NoError(t, err)
+	assert.Equal(t, DateRange{Start: time.Date(2017, 8, 12, 0, 0, 0, 0, time.UTC), End: time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)}, dates)
+
+	_, err = parseJobDates("", "2017-09")
+	assert.EqualError(t, err, "missing from date")
+	_, err = parseJobDates("2017-08-12", "2017-08-01")
+	assert.EqualError(t, err, "from date 2017-08-12 is after to date 2017-08-01")
+	_, err = parseJobDates("12/08/2017", "")
+	assert.EqualError(t, err, "invalid date 12/08/2017, must be YYYY-MM-DD or YYYY-MM")
+}
+
+func TestJobs(t *testing.T) {
+	db := setup(t)
+
+	config := NewConfig()
+	config.StatusToken = "sesame"
+	jobs := NewJobs(context.Background(), config, db, nil, nil)
+	server := NewStatusServer(":0", config, db, nil, NewStatus(), jobs, nil)
+
+	serve := func(method string, path string, token string, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		server.Handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	// jobs can't be queued or polled without our token
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/archive", "", `{"org": 1, "type": "message", "from": "2017-08"}`).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve("POST", "/archive", "open", `{"org": 1, "type": "message", "from": "2017-08"}`).Code)
+
+	// or with an invalid request
+	recorder := serve("POST", "/archive", "sesame", `{"org": 1, "type": "contact", "from": "2017-08"}`)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	assert.Equal(t, "{\"error\":\"invalid archive type 'contact', must be message or run\"}\n", recorder.Body.String())
+	assert.Equal(t, http.StatusBadRequest, serve("POST", "/archive", "sesame", `{"type": "message", "from": "2017-08"}`).Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serve("GET", "/archive", "sesame", "").Code)
+	assert.Equal(t, http.StatusNotFound, serve("GET", "/archive/1234", "sesame", "").Code)
+
+	// a job for an org which doesn't exist is queued, but fails
+	recorder = serve("POST", "/archive", "sesame", `{"org": 999, "type": "message", "from": "2017-08-01", "to": "2017-08-12"}`)
+	assert.Equal(t, http.StatusAccepted, recorder.Code)
+
+	job := &Job{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), job))
+	assert.Equal(t, "/archive/"+job.ID, recorder.Header().Get("Location"))
+	assert.Equal(t, 999, job.OrgID)
+	assert.Equal(t, MessageType, job.ArchiveType)
+	assert.Equal(t, time.Date(2017, 8, 13, 0, 0, 0, 0, time.UTC), job.EndDate)
+
+	for i := 0; i < 100 && jobs.Get(job.ID).FinishedOn == nil; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	recorder = serve("GET", "/archive/"+job.ID, "sesame", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), job))
+	assert.Equal(t, JobFailed, job.State)
+	assert.Contains(t, job.Error, "error fetching org: 999")
+}
+
+func TestJobsDraining(t *testing.T) {
+	db := setup(t)
+	config := NewConfig()
+
+	// jobs queued once we are shutting down aren't started
+	drain := make(chan struct{})
+	close(drain)
+	ctx := WithDrain(context.Background(), drain, ErrShuttingDown)
+
+	jobs := NewJobs(ctx, config, db, nil, &Options{Pauser: NewPauser(db)})
+	job, err := jobs.Queue(&JobRequest{OrgID: 1, Type: "message", From: "2017-08"})
+	assert.NoError(t, err)
+
+	for i := 0; i < 100 && jobs.Get(job.ID).FinishedOn == nil; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	job = jobs.Get(job.ID)
+	assert.Equal(t, JobFailed, job.State)
+	assert.Equal(t, ErrShuttingDown.Error(), job.Error)
+	assert.Nil(t, job.StartedOn)
+}
@@ -0,0 +1,507 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// the APIs of Kafka's protocol we use, and their versions, the oldest still supported by brokers since Kafka 4.0
+const (
+	kafkaProduceKey      = 0
+	kafkaProduceVersion  = 3
+	kafkaMetadataKey     = 3
+	kafkaMetadataVersion = 4
+	kafkaClientID        = "rp-archiver"
+)
+
+// how long we wait for brokers when our context has no deadline, and for all in sync replicas to acknowledge a message
+var kafkaTimeout = time.Second * 30
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// KafkaPublisher publishes messages to a Kafka topic, each to the partition its key hashes to with the same hash as
+// Kafka's own default partitioner, so that the events of each org are kept in order. It speaks just enough of Kafka's
+// protocol to produce a message at a time, without TLS or SASL, each acknowledged by all in sync replicas.
+type KafkaPublisher struct {
+	brokers []string
+	topic   string
+
+	mutex       sync.Mutex
+	leaders     []int32
+	addresses   map[int32]string
+	conns       map[int32]*kafkaConn
+	correlation int32
+}
+
+// NewKafkaPublisher creates a publisher to the Kafka topic of the passed in config, connecting to its brokers as it
+// first publishes, returning nil if it has no brokers
+func NewKafkaPublisher(config *Config) *KafkaPublisher {
+	if config.KafkaBrokers == "" {
+		return nil
+	}
+
+	brokers := make([]string, 0)
+	for _, broker := range strings.Split(config.KafkaBrokers, ",") {
+		if broker = strings.TrimSpace(broker); broker != "" {
+			brokers = append(brokers, broker)
+		}
+	}
+	return &KafkaPublisher{brokers: brokers, topic: config.KafkaTopic, conns: make(map[int32]*kafkaConn)}
+}
+
+// Publish produces the passed in message to the partition of our topic its key hashes to. If that fails, the leaders
+// of our partitions are looked up again and it is tried once more, in case they've moved.
+func (p *KafkaPublisher) Publish(ctx context.Context, key string, value []byte) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	err := p.produce(ctx, key, value)
+	if err != nil && ctx.Err() == nil {
+		p.reset()
+		err = p.produce(ctx, key, value)
+	}
+	if err != nil {
+		p.reset()
+		return errors.Wrapf(err, "error producing message to Kafka topic: %s", p.topic)
+	}
+	return nil
+}
+
+// Close closes our connections to our brokers
+func (p *KafkaPublisher) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.reset()
+}
+
+// produce produces the passed in message, looking up the leaders of our partitions if we don't know them
+func (p *KafkaPublisher) produce(ctx context.Context, key string, value []byte) error {
+	if p.leaders == nil {
+		err := p.lookupLeaders(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	partition := kafkaPartition([]byte(key), len(p.leaders))
+	conn, err := p.conn(ctx, p.leaders[partition])
+	if err != nil {
+		return err
+	}
+
+	batch := kafkaRecordBatch([]byte(key), value, time.Now())
+
+	request := &kafkaEncoder{}
+	request.int16(-1) // no transactional id
+	request.int16(-1) // acknowledged by all in sync replicas
+	request.int32(int32(kafkaTimeout / time.Millisecond))
+	request.int32(1)
+	request.string(p.topic)
+	request.int32(1)
+	request.int32(int32(partition))
+	request.int32(int32(len(batch)))
+	request.Write(batch)
+
+	p.correlation++
+	response, err := conn.roundTrip(ctx, kafkaProduceKey, kafkaProduceVersion, p.correlation, request.Bytes())
+	if err != nil {
+		return err
+	}
+
+	decoder := &kafkaDecoder{b: response}
+	for topics := decoder.int32(); topics > 0 && decoder.err == nil; topics-- {
+		name := decoder.string()
+		for partitions := decoder.int32(); partitions > 0 && decoder.err == nil; partitions-- {
+			index, code := decoder.int32(), decoder.int16()
+			decoder.int64() // base offset
+			decoder.int64() // log append time
+			if name == p.topic && index == int32(partition) && decoder.err == nil {
+				if code != 0 {
+					return kafkaError(code)
+				}
+				return nil
+			}
+		}
+	}
+	if decoder.err != nil {
+		return decoder.err
+	}
+	return errors.Errorf("no response for partition %d", partition)
+}
+
+// lookupLeaders looks up the leaders of the partitions of our topic, and the addresses of the brokers, from the first
+// of our brokers which answers
+func (p *KafkaPublisher) lookupLeaders(ctx context.Context) error {
+	request := &kafkaEncoder{}
+	request.int32(1)
+	request.string(p.topic)
+	request.int8(1) // allow the topic to be created if brokers auto create topics
+
+	var lastErr error
+	for _, broker := range p.brokers {
+		conn, err := dialKafka(ctx, broker)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		p.correlation++
+		response, err := conn.roundTrip(ctx, kafkaMetadataKey, kafkaMetadataVersion, p.correlation, request.Bytes())
+		conn.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return p.readLeaders(response)
+	}
+	if lastErr == nil {
+		return errors.New("no Kafka brokers to look up metadata from")
+	}
+	return errors.Wrapf(lastErr, "error looking up metadata from Kafka brokers")
+}
+
+// readLeaders reads the leaders of our partitions, and the addresses of the brokers, from the passed in metadata
+func (p *KafkaPublisher) readLeaders(response []byte) error {
+	decoder := &kafkaDecoder{b: response}
+	decoder.int32() // throttle time
+
+	addresses := make(map[int32]string)
+	for brokers := decoder.int32(); brokers > 0 && decoder.err == nil; brokers-- {
+		id, host, port := decoder.int32(), decoder.string(), decoder.int32()
+		decoder.string() // rack
+		addresses[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	decoder.string() // cluster id
+	decoder.int32()  // controller id
+
+	var leaders []int32
+	for topics := decoder.int32(); topics > 0 && decoder.err == nil; topics-- {
+		code, name := decoder.int16(), decoder.string()
+		decoder.int8() // is internal
+
+		partitions := make(map[int32]int32)
+		for count := decoder.int32(); count > 0 && decoder.err == nil; count-- {
+			decoder.int16() // partition error
+			index, leader := decoder.int32(), decoder.int32()
+			decoder.int32s() // replicas
+			decoder.int32s() // in sync replicas
+			partitions[index] = leader
+		}
+		if name != p.topic {
+			continue
+		}
+		if code != 0 {
+			return kafkaError(code)
+		}
+
+		leaders = make([]int32, len(partitions))
+		for i := range leaders {
+			leader, found := partitions[int32(i)]
+			if !found || leader < 0 {
+				return errors.Errorf("partition %d has no leader", i)
+			}
+			leaders[i] = leader
+		}
+	}
+	if decoder.err != nil {
+		return decoder.err
+	}
+	if len(leaders) == 0 {
+		return errors.Errorf("no partitions found for topic")
+	}
+
+	p.leaders, p.addresses = leaders, addresses
+	return nil
+}
+
+// conn returns our connection to the broker with the passed in id, connecting to it if we aren't already
+func (p *KafkaPublisher) conn(ctx context.Context, broker int32) (*kafkaConn, error) {
+	if conn := p.conns[broker]; conn != nil {
+		return conn, nil
+	}
+	address, found := p.addresses[broker]
+	if !found {
+		return nil, errors.Errorf("no address for broker %d", broker)
+	}
+
+	conn, err := dialKafka(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	p.conns[broker] = conn
+	return conn, nil
+}
+
+// reset closes our connections and forgets the leaders of our partitions, so they are looked up again
+func (p *KafkaPublisher) reset() {
+	for id, conn := range p.conns {
+		conn.Close()
+		delete(p.conns, id)
+	}
+	p.leaders = nil
+}
+
+// kafkaConn is a connection to a Kafka broker, which we send one request at a time to
+type kafkaConn struct {
+	net.Conn
+}
+
+// dialKafka connects to the Kafka broker at the passed in address
+func dialKafka(ctx context.Context, address string) (*kafkaConn, error) {
+	dialer := &net.Dialer{Timeout: kafkaTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error connecting to Kafka broker: %s", address)
+	}
+	return &kafkaConn{Conn: conn}, nil
+}
+
+// roundTrip sends a request to our broker for the passed in API and version, returning the body of its response
+func (c *kafkaConn) roundTrip(ctx context.Context, apiKey int16, apiVersion int16, correlation int32, body []byte) ([]byte, error) {
+	deadline, hasDeadline := ctx.Deadline()
+	if !hasDeadline {
+		deadline = time.Now().Add(kafkaTimeout * 2)
+	}
+	c.SetDeadline(deadline)
+
+	request := &kafkaEncoder{}
+	request.int32(int32(2 + 2 + 4 + 2 + len(kafkaClientID) + len(body)))
+	request.int16(apiKey)
+	request.int16(apiVersion)
+	request.int32(correlation)
+	request.string(kafkaClientID)
+	request.Write(body)
+
+	_, err := c.Write(request.Bytes())
+	if err != nil {
+		return nil, errors.Wrapf(err, "error writing Kafka request")
+	}
+
+	var size [4]byte
+	_, err = io.ReadFull(c, size[:])
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading Kafka response")
+	}
+	response := make([]byte, binary.BigEndian.Uint32(size[:]))
+	_, err = io.ReadFull(c, response)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading Kafka response")
+	}
+
+	decoder := &kafkaDecoder{b: response}
+	if received := decoder.int32(); decoder.err != nil || received != correlation {
+		return nil, errors.Errorf("Kafka response to request %d received for request %d", received, correlation)
+	}
+	return decoder.b, nil
+}
+
+// kafkaRecordBatch returns a batch, in Kafka's v2 message format, of a single uncompressed record with the passed in
+// key, value and timestamp
+func kafkaRecordBatch(key []byte, value []byte, timestamp time.Time) []byte {
+	record := &kafkaEncoder{}
+	record.int8(0)   // attributes
+	record.varint(0) // timestamp delta
+	record.varint(0) // offset delta
+	record.varbytes(key)
+	record.varbytes(value)
+	record.varint(0) // headers
+
+	// everything after the checksum is covered by it
+	checked := &kafkaEncoder{}
+	checked.int16(0) // attributes, no compression
+	checked.int32(0) // last offset delta
+	checked.int64(timestamp.UnixNano() / int64(time.Millisecond))
+	checked.int64(timestamp.UnixNano() / int64(time.Millisecond))
+	checked.int64(-1) // producer id
+	checked.int16(-1) // producer epoch
+	checked.int32(-1) // base sequence
+	checked.int32(1)
+	checked.varint(int64(record.Len()))
+	checked.Write(record.Bytes())
+
+	batch := &kafkaEncoder{}
+	batch.int64(0)                                // base offset
+	batch.int32(int32(4 + 1 + 4 + checked.Len())) // length of everything after it
+	batch.int32(-1)                               // partition leader epoch
+	batch.int8(2)                                 // magic
+	batch.int32(int32(crc32.Checksum(checked.Bytes(), castagnoli)))
+	batch.Write(checked.Bytes())
+	return batch.Bytes()
+}
+
+// kafkaPartition returns the partition the passed in key is produced to, the same as Kafka's default partitioner
+func kafkaPartition(key []byte, partitions int) int {
+	return int(uint32(murmur2(key))&0x7fffffff) % partitions
+}
+
+// murmur2 is the hash of keys used by Kafka's default partitioner
+func murmur2(data []byte) int32 {
+	const seed, m, r = uint32(0x9747b28c), uint32(0x5bd1e995), 24
+
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// the names of the Kafka error codes we're most likely to see
+var kafkaErrors = map[int16]string{
+	3:  "unknown topic or partition",
+	5:  "leader not available",
+	6:  "not leader for partition",
+	7:  "request timed out",
+	10: "message too large",
+	19: "not enough replicas",
+	20: "not enough replicas after append",
+	29: "topic authorization failed",
+}
+
+// kafkaError returns an error for the passed in Kafka error code
+func kafkaError(code int16) error {
+	name, found := kafkaErrors[code]
+	if !found {
+		name = fmt.Sprintf("error code %d", code)
+	}
+	return errors.Errorf("Kafka error: %s", name)
+}
+
+// kafkaEncoder encodes the big endian integers, strings and varints of Kafka's protocol
+type kafkaEncoder struct {
+	bytes.Buffer
+}
+
+func (e *kafkaEncoder) int8(v int8) { e.WriteByte(byte(v)) }
+
+func (e *kafkaEncoder) int16(v int16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], uint16(v))
+	e.Write(b[:])
+}
+
+func (e *kafkaEncoder) int32(v int32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(v))
+	e.Write(b[:])
+}
+
+func (e *kafkaEncoder) int64(v int64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(v))
+	e.Write(b[:])
+}
+
+func (e *kafkaEncoder) string(v string) {
+	e.int16(int16(len(v)))
+	e.WriteString(v)
+}
+
+// varint writes a zigzag encoded varint, as used by the records of the v2 message format
+func (e *kafkaEncoder) varint(v int64) {
+	var b [binary.MaxVarintLen64]byte
+	e.Write(b[:binary.PutVarint(b[:], v)])
+}
+
+func (e *kafkaEncoder) varbytes(v []byte) {
+	e.varint(int64(len(v)))
+	e.Write(v)
+}
+
+// kafkaDecoder decodes Kafka's protocol, once it has failed everything read is zero and err is set
+type kafkaDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *kafkaDecoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.b) {
+		d.err = errors.New("Kafka response is truncated")
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	if b := d.take(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if b := d.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if b := d.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if b := d.take(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads a string, null strings are read as empty
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+
+func (d *kafkaDecoder) int32s() []int32 {
+	n := d.int32()
+	values := make([]int32, 0)
+	for i := int32(0); i < n && d.err == nil; i++ {
+		values = append(values, d.int32())
+	}
+	return values
+}
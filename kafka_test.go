@@ -0,0 +1,259 @@
+package archiver
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testKafkaBroker is a single Kafka broker which leads every partition of its topic, recording what is produced to it
+type testKafkaBroker struct {
+	listener   net.Listener
+	topic      string
+	partitions int
+
+	mutex      sync.Mutex
+	metadatas  int
+	produced   []testKafkaRecord
+	produceErr []int16
+}
+
+type testKafkaRecord struct {
+	partition int32
+	key       string
+	value     string
+}
+
+func newTestKafkaBroker(t *testing.T, topic string, partitions int) *testKafkaBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	broker := &testKafkaBroker{listener: listener, topic: topic, partitions: partitions}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go broker.serve(t, conn)
+		}
+	}()
+	return broker
+}
+
+func (b *testKafkaBroker) serve(t *testing.T, conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		request := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+
+		decoder := &kafkaDecoder{b: request}
+		apiKey, apiVersion, correlation := decoder.int16(), decoder.int16(), decoder.int32()
+		assert.Equal(t, kafkaClientID, decoder.string())
+
+		response := &kafkaEncoder{}
+		response.int32(correlation)
+		if apiKey == kafkaMetadataKey {
+			assert.Equal(t, int16(kafkaMetadataVersion), apiVersion)
+			b.writeMetadata(decoder, response)
+		} else {
+			assert.Equal(t, int16(kafkaProduceKey), apiKey)
+			assert.Equal(t, int16(kafkaProduceVersion), apiVersion)
+			b.writeProduce(t, decoder, response)
+		}
+
+		framed := &kafkaEncoder{}
+		framed.int32(int32(response.Len()))
+		framed.Write(response.Bytes())
+		if _, err := conn.Write(framed.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+func (b *testKafkaBroker) writeMetadata(request *kafkaDecoder, response *kafkaEncoder) {
+	b.mutex.Lock()
+	b.metadatas++
+	b.mutex.Unlock()
+
+	request.int32()
+	topic := request.string()
+
+	host, port, _ := net.SplitHostPort(b.listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+
+	response.int32(0) // throttle time
+	response.int32(1)
+	response.int32(1)
+	response.string(host)
+	response.int32(int32(portNum))
+	response.int16(-1) // rack
+	response.int16(-1) // cluster id
+	response.int32(1)  // controller
+
+	response.int32(1)
+	if topic != b.topic {
+		response.int16(3)
+		response.string(topic)
+		response.int8(0)
+		response.int32(0)
+		return
+	}
+	response.int16(0)
+	response.string(topic)
+	response.int8(0)
+	response.int32(int32(b.partitions))
+	for i := 0; i < b.partitions; i++ {
+		response.int16(0)
+		response.int32(int32(i))
+		response.int32(1)
+		response.int32(1)
+		response.int32(1)
+		response.int32(1)
+		response.int32(1)
+	}
+}
+
+func (b *testKafkaBroker) writeProduce(t *testing.T, request *kafkaDecoder, response *kafkaEncoder) {
+	assert.Equal(t, int16(-1), request.int16()) // transactional id
+	assert.Equal(t, int16(-1), request.int16()) // acks
+	request.int32()
+	assert.Equal(t, int32(1), request.int32())
+	topic := request.string()
+	assert.Equal(t, int32(1), request.int32())
+	partition := request.int32()
+	batch := &kafkaDecoder{b: request.take(int(request.int32()))}
+	assert.NoError(t, request.err)
+
+	// check our batch is well formed and its checksum is correct
+	assert.Equal(t, int64(0), batch.int64())
+	assert.Equal(t, len(batch.b)-4, int(batch.int32()))
+	batch.int32()
+	assert.Equal(t, int8(2), batch.int8())
+	crc := uint32(batch.int32())
+	assert.Equal(t, crc32.Checksum(batch.b, castagnoli), crc)
+	batch.take(2 + 4 + 8 + 8 + 8 + 2 + 4)
+	assert.Equal(t, int32(1), batch.int32())
+
+	records := batch.b
+	length, n := binary.Varint(records)
+	record := records[n : n+int(length)]
+	record = record[1:]          // attributes
+	_, n = binary.Varint(record) // timestamp delta
+	record = record[n:]
+	_, n = binary.Varint(record) // offset delta
+	record = record[n:]
+	keyLength, n := binary.Varint(record)
+	key := string(record[n : n+int(keyLength)])
+	record = record[n+int(keyLength):]
+	valueLength, n := binary.Varint(record)
+	value := string(record[n : n+int(valueLength)])
+
+	b.mutex.Lock()
+	code := int16(0)
+	if len(b.produceErr) > 0 {
+		code, b.produceErr = b.produceErr[0], b.produceErr[1:]
+	} else {
+		b.produced = append(b.produced, testKafkaRecord{partition: partition, key: key, value: value})
+	}
+	b.mutex.Unlock()
+
+	response.int32(1)
+	response.string(topic)
+	response.int32(1)
+	response.int32(partition)
+	response.int16(code)
+	response.int64(0)
+	response.int64(-1)
+	response.int32(0) // throttle time
+}
+
+func TestMurmur2(t *testing.T) {
+	// the same hashes as Kafka's own
+	assert.Equal(t, int32(-973932308), murmur2([]byte("21")))
+	assert.Equal(t, int32(-790332482), murmur2([]byte("foobar")))
+	assert.Equal(t, int32(-985981536), murmur2([]byte("a-little-bit-long-string")))
+	assert.Equal(t, int32(-1486304829), murmur2([]byte("a-little-bit-longer-string")))
+	assert.Equal(t, int32(-58897971), murmur2([]byte("lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8")))
+	assert.Equal(t, int32(479470107), murmur2([]byte("abc")))
+
+	for _, key := range []string{"1", "3", "1234", "abc"} {
+		partition := kafkaPartition([]byte(key), 6)
+		assert.True(t, partition >= 0 && partition < 6)
+	}
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	ctx := context.Background()
+	broker := newTestKafkaBroker(t, "archives", 6)
+	defer broker.listener.Close()
+
+	config := NewConfig()
+	assert.Nil(t, NewKafkaPublisher(config))
+
+	// brokers need a topic
+	config.KafkaBrokers = "localhost:9092"
+	assert.Contains(t, config.Validate()[0].Error(), "cannot publish archive events to kafka without both kafka brokers and a kafka topic")
+
+	// the first broker which answers is asked for our topic's partitions
+	config.KafkaBrokers = "127.0.0.1:1, " + broker.listener.Addr().String()
+	config.KafkaTopic = "archives"
+	assert.Equal(t, 0, len(config.Validate()))
+	assert.True(t, config.PublishesEvents())
+
+	publisher, err := NewPublisher(config)
+	assert.NoError(t, err)
+	kafka := publisher.(*KafkaPublisher)
+	defer kafka.Close()
+
+	assert.NoError(t, publisher.Publish(ctx, "3", []byte(`{"event":"archive_committed"}`)))
+	assert.NoError(t, publisher.Publish(ctx, "3", []byte(`{"event":"record_archived"}`)))
+	assert.NoError(t, publisher.Publish(ctx, "42", []byte(`{"event":"archive_committed"}`)))
+
+	// each org's messages are produced to its own partition in order
+	assert.Equal(t, []testKafkaRecord{
+		{partition: int32(kafkaPartition([]byte("3"), 6)), key: "3", value: `{"event":"archive_committed"}`},
+		{partition: int32(kafkaPartition([]byte("3"), 6)), key: "3", value: `{"event":"record_archived"}`},
+		{partition: int32(kafkaPartition([]byte("42"), 6)), key: "42", value: `{"event":"archive_committed"}`},
+	}, broker.produced)
+	assert.Equal(t, 1, broker.metadatas)
+
+	// if a partition's leader has moved, its partitions are looked up again and the message produced again
+	broker.produceErr = []int16{6}
+	assert.NoError(t, publisher.Publish(ctx, "3", []byte(`{"event":"archive_committed"}`)))
+	assert.Equal(t, 4, len(broker.produced))
+	assert.Equal(t, 2, broker.metadatas)
+
+	// but only once
+	broker.produceErr = []int16{19, 19}
+	err = publisher.Publish(ctx, "3", []byte(`{"event":"archive_committed"}`))
+	assert.EqualError(t, err, "error producing message to Kafka topic: archives: Kafka error: not enough replicas")
+
+	// topics which don't exist can't be produced to
+	config.KafkaTopic = "missing"
+	missing := NewKafkaPublisher(config)
+	err = missing.Publish(ctx, "3", []byte(`{}`))
+	assert.EqualError(t, err, "error producing message to Kafka topic: missing: Kafka error: unknown topic or partition")
+
+	// nor can brokers which aren't there
+	config.KafkaBrokers = "127.0.0.1:1"
+	unreachable := NewKafkaPublisher(config)
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	err = unreachable.Publish(ctx, "3", []byte(`{}`))
+	assert.Contains(t, err.Error(), "error looking up metadata from Kafka brokers: error connecting to Kafka broker: 127.0.0.1:1")
+}
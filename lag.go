@@ -0,0 +1,63 @@
+package archiver
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ArchiveLag is how far behind the newest archive it could have the archives of an org and type are
+type ArchiveLag struct {
+	OrgID       int
+	ArchiveType ArchiveType
+
+	// the last day we could have archived and the last day covered by an archive, if any
+	NewestPossible time.Time
+	NewestArchived *time.Time
+
+	// the number of days between the two, zero if we are up to date
+	Days int
+}
+
+// Exceeds returns whether this lag is more than the passed in maximum number of days, a maximum of zero is no maximum
+func (l *ArchiveLag) Exceeds(maxDays int) bool {
+	return maxDays > 0 && l.Days > maxDays
+}
+
+const lookupNewestArchivedDay = `
+SELECT max(start_date + CASE WHEN period = 'M' THEN '1 month'::interval ELSE '1 day'::interval END - '1 day'::interval)::timestamp with time zone
+FROM archives_archive
+WHERE org_id = $1 AND archive_type = $2
+`
+
+// GetOrgArchiveLag returns how many days behind the newest archive it could have the passed in org and type are. Note
+// that this only considers the newest archive, gaps before it are reported by CheckOrgConsistency.
+func GetOrgArchiveLag(ctx context.Context, db *sqlx.DB, now time.Time, org Org, archiveType ArchiveType) (*ArchiveLag, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	// same as the last day we look for missing archives for
+	newestPossible := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -org.RetentionPeriod)
+
+	var newestArchived *time.Time
+	err := db.GetContext(ctx, &newestArchived, lookupNewestArchivedDay, org.ID, archiveType)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up newest archive for org: %d and type: %s", org.ID, archiveType)
+	}
+
+	// without any archives, we are behind since the day before the org was created
+	behindSince := newestArchived
+	if behindSince == nil {
+		orgUTC := org.CreatedOn.In(time.UTC)
+		created := time.Date(orgUTC.Year(), orgUTC.Month(), orgUTC.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+		behindSince = &created
+	}
+
+	lag := &ArchiveLag{OrgID: org.ID, ArchiveType: archiveType, NewestPossible: newestPossible, NewestArchived: newestArchived}
+	if behindSince.Before(newestPossible) {
+		lag.Days = int(newestPossible.Sub(behindSince.In(time.UTC)).Hours() / 24)
+	}
+	return lag, nil
+}
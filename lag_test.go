@@ -0,0 +1,48 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetOrgArchiveLag(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+	newestPossible := time.Date(2017, 10, 10, 0, 0, 0, 0, time.UTC)
+
+	// org 3's newest archive is a monthly for September
+	lag, err := GetOrgArchiveLag(ctx, db, now, orgs[2], MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, newestPossible, lag.NewestPossible)
+	assert.Equal(t, time.Date(2017, 9, 30, 0, 0, 0, 0, time.UTC), lag.NewestArchived.In(time.UTC))
+	assert.Equal(t, 10, lag.Days)
+	assert.True(t, lag.Exceeds(7))
+	assert.False(t, lag.Exceeds(10))
+	assert.False(t, lag.Exceeds(0))
+
+	// org 2's newest archive is a daily
+	lag, err = GetOrgArchiveLag(ctx, db, now, orgs[1], MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2017, 10, 8, 0, 0, 0, 0, time.UTC), lag.NewestArchived.In(time.UTC))
+	assert.Equal(t, 2, lag.Days)
+
+	// without any archives, we're behind since the org was created
+	lag, err = GetOrgArchiveLag(ctx, db, now, orgs[1], RunType)
+	assert.NoError(t, err)
+	assert.Nil(t, lag.NewestArchived)
+	assert.Equal(t, 62, lag.Days)
+
+	// unless it was created too recently to have any archives
+	lag, err = GetOrgArchiveLag(ctx, db, now, orgs[0], MessageType)
+	assert.NoError(t, err)
+	assert.Nil(t, lag.NewestArchived)
+	assert.Equal(t, 0, lag.Days)
+}
@@ -0,0 +1,144 @@
+package archiver
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// LegalHold prevents the deletion of records and the purging of archives for an org, either entirely or for a single
+// archive type and/or date range, regardless of what our retention settings say
+type LegalHold struct {
+	// ArchiveType, StartDate and EndDate narrow what is held, a nil value holds everything, EndDate is exclusive
+	ID          int          `db:"id"`
+	OrgID       int          `db:"org_id"`
+	ArchiveType *ArchiveType `db:"archive_type"`
+	StartDate   *time.Time   `db:"start_date"`
+	EndDate     *time.Time   `db:"end_date"`
+	Reason      string       `db:"reason"`
+	CreatedOn   time.Time    `db:"created_on"`
+	ReleasedOn  *time.Time   `db:"released_on"`
+}
+
+// Covers returns whether this hold applies to the passed in archive, that is it is for the same type (or all types)
+// and its dates (if any) overlap the archive's period
+func (h *LegalHold) Covers(archive *Archive) bool {
+	if h.ReleasedOn != nil || h.OrgID != archive.OrgID {
+		return false
+	}
+	if h.ArchiveType != nil && *h.ArchiveType != archive.ArchiveType {
+		return false
+	}
+	if h.StartDate != nil && !archive.endDate().After(*h.StartDate) {
+		return false
+	}
+	if h.EndDate != nil && !archive.StartDate.Before(*h.EndDate) {
+		return false
+	}
+	return true
+}
+
+// heldBy returns the first of the passed in holds which covers the passed in archive, if any
+func heldBy(holds []*LegalHold, archive *Archive) *LegalHold {
+	for _, h := range holds {
+		if h.Covers(archive) {
+			return h
+		}
+	}
+	return nil
+}
+
+const selectLegalHoldFields = `
+SELECT id, org_id, archive_type, start_date::timestamp with time zone as start_date, end_date::timestamp with time zone as end_date, reason, created_on, released_on
+FROM archiver_legal_hold
+`
+
+const lookupActiveLegalHolds = selectLegalHoldFields + `
+WHERE org_id = $1 AND released_on IS NULL AND (archive_type IS NULL OR archive_type = $2)
+ORDER BY id
+`
+
+// GetActiveLegalHolds returns the unreleased legal holds for the passed in org which apply to the passed in type
+func GetActiveLegalHolds(ctx context.Context, db *sqlx.DB, org Org, archiveType ArchiveType) ([]*LegalHold, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	holds := make([]*LegalHold, 0)
+	err := db.SelectContext(ctx, &holds, lookupActiveLegalHolds, org.ID, archiveType)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error selecting legal holds for org: %d", org.ID)
+	}
+	return holds, nil
+}
+
+const lookupLegalHolds = selectLegalHoldFields + `
+WHERE ($1 = 0 OR org_id = $1) AND ($2 OR released_on IS NULL)
+ORDER BY id
+`
+
+// ListLegalHolds returns the legal holds for the passed in org, or all orgs if zero, optionally including released holds
+func ListLegalHolds(ctx context.Context, db *sqlx.DB, orgID int, includeReleased bool) ([]*LegalHold, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	holds := make([]*LegalHold, 0)
+	err := db.SelectContext(ctx, &holds, lookupLegalHolds, orgID, includeReleased)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error listing legal holds")
+	}
+	return holds, nil
+}
+
+const insertLegalHold = `
+INSERT INTO archiver_legal_hold(org_id, archive_type, start_date, end_date, reason, created_on)
+VALUES(:org_id, :archive_type, :start_date, :end_date, :reason, :created_on)
+RETURNING id
+`
+
+// CreateLegalHold inserts the passed in legal hold, setting its id
+func CreateLegalHold(ctx context.Context, db *sqlx.DB, hold *LegalHold) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	hold.CreatedOn = time.Now()
+
+	rows, err := db.NamedQueryContext(ctx, insertLegalHold, hold)
+	if err != nil {
+		return errors.Wrapf(err, "error inserting legal hold")
+	}
+	defer rows.Close()
+
+	rows.Next()
+	err = rows.Scan(&hold.ID)
+	if err != nil {
+		return errors.Wrapf(err, "error reading new legal hold id")
+	}
+	return nil
+}
+
+const releaseLegalHold = `
+UPDATE archiver_legal_hold
+SET released_on = $2
+WHERE id = $1 AND released_on IS NULL
+`
+
+// ReleaseLegalHold releases the legal hold with the passed in id, returning an error if there is no such active hold
+func ReleaseLegalHold(ctx context.Context, db *sqlx.DB, holdID int) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, releaseLegalHold, holdID, time.Now())
+	if err != nil {
+		return errors.Wrapf(err, "error releasing legal hold: %d", holdID)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrapf(err, "error releasing legal hold: %d", holdID)
+	}
+	if affected != 1 {
+		return errors.Errorf("no active legal hold with id: %d", holdID)
+	}
+	return nil
+}
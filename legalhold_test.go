@@ -0,0 +1,127 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLegalHolds(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+	config := NewConfig()
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	org, err := GetOrg(ctx, db, config, 2)
+	assert.NoError(t, err)
+
+	// no holds to start with
+	holds, err := GetActiveLegalHolds(ctx, db, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(holds))
+
+	// hold our runs for october, this doesn't apply to messages
+	runType := RunType
+	oct := time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)
+	nov := time.Date(2017, 11, 1, 0, 0, 0, 0, time.UTC)
+	runHold := &LegalHold{OrgID: 2, ArchiveType: &runType, StartDate: &oct, EndDate: &nov, Reason: "audit"}
+	err = CreateLegalHold(ctx, db, runHold)
+	assert.NoError(t, err)
+	assert.NotEqual(t, 0, runHold.ID)
+
+	holds, err = GetActiveLegalHolds(ctx, db, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(holds))
+
+	reports, err := ReportArchivedOrgDeletions(ctx, now, config, db, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(reports))
+
+	// hold all our messages for september, which doesn't cover our october daily
+	sep := time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC)
+	sepHold := &LegalHold{OrgID: 2, StartDate: &sep, EndDate: &oct, Reason: "case 123"}
+	err = CreateLegalHold(ctx, db, sepHold)
+	assert.NoError(t, err)
+
+	holds, err = GetActiveLegalHolds(ctx, db, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(holds))
+
+	reports, err = ReportArchivedOrgDeletions(ctx, now, config, db, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(reports))
+
+	// hold everything for the org
+	orgHold := &LegalHold{OrgID: 2, Reason: "litigation"}
+	err = CreateLegalHold(ctx, db, orgHold)
+	assert.NoError(t, err)
+
+	reports, err = ReportArchivedOrgDeletions(ctx, now, config, db, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(reports))
+
+	config.Delete = true
+	deleted, err := DeleteArchivedOrgRecords(ctx, now, config, db, nil, org, MessageType, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(deleted))
+	assertCount(t, db, 1, `SELECT count(*) FROM msgs_msg WHERE id = 6`)
+	assertCount(t, db, 1, `SELECT count(*) FROM archives_archive WHERE id = 4 AND needs_deletion = TRUE`)
+
+	// held archives aren't purged either
+	db.MustExec(`UPDATE archives_archive SET needs_deletion = FALSE WHERE id = 4`)
+	config.PurgeMonthliesAfter = 30
+	config.PurgeDailiesAfter = 30
+	db.MustExec(`UPDATE archives_archive SET url = 'https://s3.amazonaws.com/bucket/4', rollup_id = 3 WHERE id = 4`)
+	purged, err := PurgeOrgArchives(ctx, now, config, db, nil, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(purged))
+
+	// release our org hold and only our other holds are listed as active
+	err = ReleaseLegalHold(ctx, db, orgHold.ID)
+	assert.NoError(t, err)
+
+	err = ReleaseLegalHold(ctx, db, orgHold.ID)
+	assert.EqualError(t, err, "no active legal hold with id: 3")
+
+	active, err := ListLegalHolds(ctx, db, 2, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(active))
+
+	all, err := ListLegalHolds(ctx, db, 0, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(all))
+	assert.NotNil(t, all[2].ReleasedOn)
+}
+
+func TestLegalHoldCovers(t *testing.T) {
+	msgType := MessageType
+	sep := time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC)
+	oct := time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	daily := &Archive{OrgID: 2, ArchiveType: MessageType, Period: DayPeriod, StartDate: time.Date(2017, 9, 30, 0, 0, 0, 0, time.UTC)}
+	nextDaily := &Archive{OrgID: 2, ArchiveType: MessageType, Period: DayPeriod, StartDate: oct}
+	monthly := &Archive{OrgID: 2, ArchiveType: MessageType, Period: MonthPeriod, StartDate: time.Date(2017, 8, 1, 0, 0, 0, 0, time.UTC)}
+	runs := &Archive{OrgID: 2, ArchiveType: RunType, Period: DayPeriod, StartDate: daily.StartDate}
+	otherOrg := &Archive{OrgID: 3, ArchiveType: MessageType, Period: DayPeriod, StartDate: daily.StartDate}
+
+	hold := &LegalHold{OrgID: 2, ArchiveType: &msgType, StartDate: &sep, EndDate: &oct}
+	assert.True(t, hold.Covers(daily))
+	assert.False(t, hold.Covers(nextDaily))
+	assert.False(t, hold.Covers(monthly))
+	assert.False(t, hold.Covers(runs))
+	assert.False(t, hold.Covers(otherOrg))
+
+	// a hold starting partway through a month covers that month's archive
+	mid := time.Date(2017, 8, 20, 0, 0, 0, 0, time.UTC)
+	hold = &LegalHold{OrgID: 2, StartDate: &mid}
+	assert.True(t, hold.Covers(monthly))
+	assert.True(t, hold.Covers(runs))
+	assert.True(t, hold.Covers(nextDaily))
+
+	released := time.Now()
+	hold.ReleasedOn = &released
+	assert.False(t, hold.Covers(monthly))
+	assert.Nil(t, heldBy([]*LegalHold{hold}, monthly))
+}
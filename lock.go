@@ -0,0 +1,71 @@
+package archiver
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// InstanceLockKey is the key of the lock held by the archiver instance which is currently running
+const InstanceLockKey = "archiver:instance"
+
+// OrgLockKey returns the key of the lock held while archiving the passed in org
+func OrgLockKey(orgID int) string {
+	return fmt.Sprintf("archiver:org:%d", orgID)
+}
+
+// Lock is a Postgres session level advisory lock, as these belong to the connection that took them, each lock holds
+// onto its own connection until it is released
+type Lock struct {
+	key  string
+	conn *sql.Conn
+}
+
+const tryAdvisoryLock = `SELECT pg_try_advisory_lock(hashtext($1))`
+
+const releaseAdvisoryLock = `SELECT pg_advisory_unlock(hashtext($1))`
+
+// TryLock tries to take the lock with the passed in key without waiting, returning nil if it is held elsewhere
+func TryLock(ctx context.Context, db *sqlx.DB, key string) (*Lock, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting connection for lock: %s", key)
+	}
+
+	locked := false
+	err = conn.QueryRowContext(ctx, tryAdvisoryLock, key).Scan(&locked)
+	if err != nil || !locked {
+		conn.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "error taking lock: %s", key)
+		}
+		return nil, nil
+	}
+
+	return &Lock{key: key, conn: conn}, nil
+}
+
+// Release releases this lock and returns its connection to the pool
+func (l *Lock) Release(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	defer l.conn.Close()
+
+	released := false
+	err := l.conn.QueryRowContext(ctx, releaseAdvisoryLock, l.key).Scan(&released)
+	if err != nil {
+		return errors.Wrapf(err, "error releasing lock: %s", l.key)
+	}
+	if !released {
+		return errors.Errorf("lock %s was not held", l.key)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocks(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	assert.Equal(t, "archiver:org:3", OrgLockKey(3))
+
+	lock, err := TryLock(ctx, db, OrgLockKey(3))
+	assert.NoError(t, err)
+	assert.NotNil(t, lock)
+
+	// can't take it again while it's held, even from the same process
+	other, err := TryLock(ctx, db, OrgLockKey(3))
+	assert.NoError(t, err)
+	assert.Nil(t, other)
+
+	// but other keys are independent
+	other, err = TryLock(ctx, db, InstanceLockKey)
+	assert.NoError(t, err)
+	assert.NotNil(t, other)
+	assert.NoError(t, other.Release(ctx))
+
+	// once released it can be taken again
+	assert.NoError(t, lock.Release(ctx))
+
+	lock, err = TryLock(ctx, db, OrgLockKey(3))
+	assert.NoError(t, err)
+	assert.NotNil(t, lock)
+	assert.NoError(t, lock.Release(ctx))
+}
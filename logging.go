@@ -0,0 +1,74 @@
+package archiver
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// jsonFieldNames maps the names used for fields in our log calls to the consistent names used in structured output
+var jsonFieldNames = map[string]string{
+	"elapsed":      "duration",
+	"record_count": "records",
+	"file_size":    "size",
+	"file_hash":    "hash",
+}
+
+// dateFields are fields which are always whole days and so are output as dates
+var dateFields = map[string]bool{
+	"start_date": true,
+	"end_date":   true,
+}
+
+// JSONFormatter formats log entries as JSON objects, one per line, with field names made consistent across all our
+// log calls so that they can be parsed by a log pipeline. Durations are output as seconds and archive dates as
+// YYYY-MM-DD.
+type JSONFormatter struct {
+	logrus.JSONFormatter
+}
+
+// NewJSONFormatter creates a new JSON formatter
+func NewJSONFormatter() *JSONFormatter {
+	return &JSONFormatter{logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano}}
+}
+
+// Format formats the passed in entry
+func (f *JSONFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	data := make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		name, renamed := jsonFieldNames[k]
+		if !renamed {
+			name = k
+		}
+
+		switch value := v.(type) {
+		case time.Duration:
+			data[name] = value.Seconds()
+		case time.Time:
+			if dateFields[k] {
+				data[name] = value.In(time.UTC).Format("2006-01-02")
+			} else {
+				data[name] = value
+			}
+		default:
+			data[name] = v
+		}
+	}
+
+	formatted := *entry
+	formatted.Data = data
+	return f.JSONFormatter.Format(&formatted)
+}
+
+// NewLogFormatter returns the log formatter for the passed in format, one of text or json
+func NewLogFormatter(format string) (logrus.Formatter, error) {
+	switch format {
+	case "", "text":
+		return &logrus.TextFormatter{}, nil
+	case "json":
+		return NewJSONFormatter(), nil
+	default:
+		return nil, fmt.Errorf("invalid log format '%s', must be text or json", format)
+	}
+}
@@ -0,0 +1,57 @@
+package archiver
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONFormatter(t *testing.T) {
+	formatter := NewJSONFormatter()
+
+	entry := logrus.NewEntry(logrus.StandardLogger()).WithFields(logrus.Fields{
+		"org_id":       5,
+		"archive_type": MessageType,
+		"period":       DayPeriod,
+		"start_date":   time.Date(2017, 8, 12, 0, 0, 0, 0, time.UTC),
+		"elapsed":      time.Millisecond * 1500,
+		"record_count": 10,
+		"file_size":    int64(1024),
+		"file_hash":    "f0d79988b7772c003d04a28bd7417a62",
+	})
+	entry.Time = time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+	entry.Level = logrus.InfoLevel
+	entry.Message = "archive complete"
+
+	output, err := formatter.Format(entry)
+	assert.NoError(t, err)
+
+	parsed := make(map[string]interface{})
+	assert.NoError(t, json.Unmarshal(output, &parsed))
+	assert.Equal(t, map[string]interface{}{
+		"org_id":       float64(5),
+		"archive_type": "message",
+		"period":       "D",
+		"start_date":   "2017-08-12",
+		"duration":     1.5,
+		"records":      float64(10),
+		"size":         float64(1024),
+		"hash":         "f0d79988b7772c003d04a28bd7417a62",
+		"level":        "info",
+		"msg":          "archive complete",
+		"time":         "2018-01-08T12:30:00Z",
+	}, parsed)
+
+	// our original entry is unchanged
+	assert.Equal(t, time.Millisecond*1500, entry.Data["elapsed"])
+
+	_, err = NewLogFormatter("json")
+	assert.NoError(t, err)
+	_, err = NewLogFormatter("text")
+	assert.NoError(t, err)
+	_, err = NewLogFormatter("xml")
+	assert.EqualError(t, err, "invalid log format 'xml', must be text or json")
+}
@@ -0,0 +1,102 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Manifest lists the archives available for an org, it is written to S3 alongside the archives themselves so that
+// they can be discovered without access to our database
+type Manifest struct {
+	OrgID       int              `json:"org_id"`
+	GeneratedOn time.Time        `json:"generated_on"`
+	Archives    []*ManifestEntry `json:"archives"`
+}
+
+// ManifestEntry describes a single archive in a manifest
+type ManifestEntry struct {
+	ArchiveType ArchiveType   `json:"archive_type"`
+	Period      ArchivePeriod `json:"period"`
+	StartDate   string        `json:"start_date"`
+	EndDate     string        `json:"end_date"`
+	URL         string        `json:"url"`
+	Hash        string        `json:"hash"`
+	RecordCount int           `json:"record_count"`
+	Size        int64         `json:"size"`
+	RolledUp    bool          `json:"rolled_up"`
+}
+
+const lookupManifestArchives = selectArchiveFields + `
+WHERE org_id = $1 AND purged_on IS NULL
+ORDER BY archive_type, start_date, period desc
+`
+
+// BuildOrgManifest builds the manifest of all the archives for the passed in org which are still available on S3
+func BuildOrgManifest(ctx context.Context, db *sqlx.DB, now time.Time, org Org) (*Manifest, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	archives := make([]*Archive, 0)
+	err := db.SelectContext(ctx, &archives, lookupManifestArchives, org.ID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error selecting archives for manifest of org: %d", org.ID)
+	}
+
+	manifest := &Manifest{OrgID: org.ID, GeneratedOn: now, Archives: make([]*ManifestEntry, 0, len(archives))}
+	for _, a := range archives {
+		manifest.Archives = append(manifest.Archives, &ManifestEntry{
+			ArchiveType: a.ArchiveType,
+			Period:      a.Period,
+			StartDate:   a.StartDate.In(time.UTC).Format("2006-01-02"),
+			EndDate:     a.endDate().In(time.UTC).Format("2006-01-02"),
+			URL:         a.URL,
+			Hash:        a.Hash,
+			RecordCount: a.RecordCount,
+			Size:        a.Size,
+			RolledUp:    a.Rollup != nil,
+		})
+	}
+	return manifest, nil
+}
+
+// manifestPath returns the path of the manifest for the passed in org in our bucket
+func manifestPath(org Org) string {
+	return org.s3Path(fmt.Sprintf("/%d/manifest.json", org.ID))
+}
+
+// WriteOrgManifest builds the manifest for the passed in org and writes it to S3, replacing any previous manifest
+func WriteOrgManifest(ctx context.Context, db *sqlx.DB, s3Client s3iface.S3API, bucket string, now time.Time, org Org) (*Manifest, error) {
+	manifest, err := BuildOrgManifest(ctx, db, now, org)
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshalling manifest for org: %d", org.ID)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+
+	url, err := UploadStreamToS3(ctx, s3Client, bucket, manifestPath(org), "application/json", bytes.NewReader(contents))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error writing manifest for org: %d", org.ID)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"org_id":        org.ID,
+		"url":           url,
+		"archive_count": len(manifest.Archives),
+	}).Info("wrote org manifest")
+
+	return manifest, nil
+}
@@ -0,0 +1,61 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildOrgManifest(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	db.MustExec(`UPDATE archives_archive SET url = 'https://s3.amazonaws.com/bucket/' || id, hash = 'abc' || id, record_count = id, size = id * 10`)
+	db.MustExec(`UPDATE archives_archive SET rollup_id = 3 WHERE id = 2`)
+	db.MustExec(`UPDATE archives_archive SET purged_on = NOW() WHERE id = 1`)
+
+	manifest, err := BuildOrgManifest(ctx, db, now, orgs[2])
+	assert.NoError(t, err)
+	assert.Equal(t, 3, manifest.OrgID)
+	assert.Equal(t, now, manifest.GeneratedOn)
+
+	// our purged daily isn't included, our monthly sorts before the daily it rolled up
+	assert.Equal(t, 2, len(manifest.Archives))
+	assert.Equal(t, &ManifestEntry{
+		ArchiveType: MessageType,
+		Period:      MonthPeriod,
+		StartDate:   "2017-09-01",
+		EndDate:     "2017-10-01",
+		URL:         "https://s3.amazonaws.com/bucket/3",
+		Hash:        "abc3",
+		RecordCount: 3,
+		Size:        30,
+		RolledUp:    false,
+	}, manifest.Archives[0])
+	assert.Equal(t, &ManifestEntry{
+		ArchiveType: MessageType,
+		Period:      DayPeriod,
+		StartDate:   "2017-09-10",
+		EndDate:     "2017-09-11",
+		URL:         "https://s3.amazonaws.com/bucket/2",
+		Hash:        "abc2",
+		RecordCount: 2,
+		Size:        20,
+		RolledUp:    true,
+	}, manifest.Archives[1])
+
+	// orgs without archives have an empty manifest
+	manifest, err = BuildOrgManifest(ctx, db, now, orgs[0])
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(manifest.Archives))
+
+	assert.Equal(t, "/3/manifest.json", manifestPath(Org{ID: 3}))
+	assert.Equal(t, "/acme/3/manifest.json", manifestPath(Org{ID: 3, S3Prefix: "acme"}))
+}
@@ -0,0 +1,118 @@
+package archiver
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Statsd sends our metrics to a StatsD agent over UDP, optionally tagging them using the Datadog extension to the
+// protocol. All methods are safe to call on a nil client, in which case they do nothing, so that callers don't need
+// to check whether metrics are enabled.
+type Statsd struct {
+	conn   net.Conn
+	prefix string
+	tags   bool
+}
+
+// NewStatsd creates a new StatsD client from the passed in config, returning nil if no StatsD address is configured
+func NewStatsd(config *Config) (*Statsd, error) {
+	if config.StatsdAddress == "" {
+		return nil, nil
+	}
+
+	conn, err := net.Dial("udp", config.StatsdAddress)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error connecting to statsd: %s", config.StatsdAddress)
+	}
+
+	prefix := config.StatsdPrefix
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+
+	return &Statsd{conn: conn, prefix: prefix, tags: config.StatsdTags}, nil
+}
+
+// Count increments the counter with the passed in name by the passed in value
+func (s *Statsd) Count(name string, value int64, tags ...string) {
+	s.send(name, fmt.Sprintf("%d|c", value), tags)
+}
+
+// Gauge sets the gauge with the passed in name to the passed in value
+func (s *Statsd) Gauge(name string, value float64, tags ...string) {
+	s.send(name, fmt.Sprintf("%g|g", value), tags)
+}
+
+// Timing records the passed in duration, in milliseconds, for the timer with the passed in name
+func (s *Statsd) Timing(name string, duration time.Duration, tags ...string) {
+	s.send(name, fmt.Sprintf("%d|ms", int64(duration/time.Millisecond)), tags)
+}
+
+// Close closes our connection to the StatsD agent
+func (s *Statsd) Close() error {
+	if s == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// send writes a single metric, tags are only included if enabled. As metrics are sent over UDP, failures are only
+// logged at debug level and never returned.
+func (s *Statsd) send(name string, value string, tags []string) {
+	if s == nil {
+		return
+	}
+
+	line := &bytes.Buffer{}
+	line.WriteString(s.prefix)
+	line.WriteString(name)
+	line.WriteString(":")
+	line.WriteString(value)
+	if s.tags && len(tags) > 0 {
+		line.WriteString("|#")
+		line.WriteString(strings.Join(tags, ","))
+	}
+
+	_, err := s.conn.Write(line.Bytes())
+	if err != nil {
+		logrus.WithError(err).WithField("metric", name).Debug("error sending metric to statsd")
+	}
+}
+
+// ReportOrgArchival sends the statistics of archiving the passed in org and type, ie: the result of ArchiveOrg
+func (s *Statsd) ReportOrgArchival(org Org, archiveType ArchiveType, created []*Archive, deleted []*Archive, elapsed time.Duration) {
+	tags := []string{fmt.Sprintf("org_id:%d", org.ID), fmt.Sprintf("archive_type:%s", archiveType)}
+
+	// archives which failed are returned without an id
+	createdCount, createdRecords, createdBytes := 0, 0, int64(0)
+	for _, a := range created {
+		if a.ID != 0 {
+			createdCount++
+			createdRecords += a.RecordCount
+			createdBytes += a.Size
+		}
+	}
+
+	deletedRecords := 0
+	for _, a := range deleted {
+		deletedRecords += a.RecordCount
+	}
+
+	s.Count("archives_created", int64(createdCount), tags...)
+	s.Count("records_archived", int64(createdRecords), tags...)
+	s.Count("bytes_archived", createdBytes, tags...)
+	s.Count("archives_deleted", int64(len(deleted)), tags...)
+	s.Count("records_deleted", int64(deletedRecords), tags...)
+	s.Timing("org_elapsed", elapsed, tags...)
+}
+
+// ReportArchiveLag sends how many days behind the newest archive it could have an org and type are
+func (s *Statsd) ReportArchiveLag(lag *ArchiveLag) {
+	s.Gauge("archive_lag_days", float64(lag.Days), fmt.Sprintf("org_id:%d", lag.OrgID), fmt.Sprintf("archive_type:%s", lag.ArchiveType))
+}
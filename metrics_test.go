@@ -0,0 +1,66 @@
+package archiver
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readMetrics reads the next n metrics sent to the passed in listener
+func readMetrics(t *testing.T, listener net.PacketConn, n int) []string {
+	metrics := make([]string, 0, n)
+	buf := make([]byte, 1024)
+	for i := 0; i < n; i++ {
+		listener.SetReadDeadline(time.Now().Add(time.Second))
+		read, _, err := listener.ReadFrom(buf)
+		assert.NoError(t, err)
+		metrics = append(metrics, string(buf[:read]))
+	}
+	return metrics
+}
+
+func TestStatsd(t *testing.T) {
+	// no address means no client, which is safe to use
+	config := NewConfig()
+	stats, err := NewStatsd(config)
+	assert.NoError(t, err)
+	assert.Nil(t, stats)
+	stats.Count("archives_created", 1)
+	assert.NoError(t, stats.Close())
+
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	config.StatsdAddress = listener.LocalAddr().String()
+	stats, err = NewStatsd(config)
+	assert.NoError(t, err)
+	defer stats.Close()
+
+	stats.Count("org_errors", 2, "org_id:1")
+	stats.Gauge("orgs", 3.5)
+	stats.Timing("run_elapsed", time.Second*2)
+	assert.Equal(t, []string{"archiver.org_errors:2|c", "archiver.orgs:3.5|g", "archiver.run_elapsed:2000|ms"}, readMetrics(t, listener, 3))
+
+	// with tags enabled, org stats are tagged with org and type
+	config.StatsdPrefix = "rp.archiver."
+	config.StatsdTags = true
+	tagged, err := NewStatsd(config)
+	assert.NoError(t, err)
+	defer tagged.Close()
+
+	created := []*Archive{{ID: 1, RecordCount: 10, Size: 100}, {ID: 0, RecordCount: 5, Size: 50}}
+	deleted := []*Archive{{ID: 2, RecordCount: 3}}
+	tagged.ReportOrgArchival(Org{ID: 5}, MessageType, created, deleted, time.Millisecond*1500)
+
+	assert.Equal(t, []string{
+		"rp.archiver.archives_created:1|c|#org_id:5,archive_type:message",
+		"rp.archiver.records_archived:10|c|#org_id:5,archive_type:message",
+		"rp.archiver.bytes_archived:100|c|#org_id:5,archive_type:message",
+		"rp.archiver.archives_deleted:1|c|#org_id:5,archive_type:message",
+		"rp.archiver.records_deleted:3|c|#org_id:5,archive_type:message",
+		"rp.archiver.org_elapsed:1500|ms|#org_id:5,archive_type:message",
+	}, readMetrics(t, listener, 6))
+}
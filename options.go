@@ -0,0 +1,111 @@
+package archiver
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Options are what callers can add to or replace in archiving, passed explicitly to ArchiveOrg and the functions it
+// is made of. Every field is optional, and nil options archive with our database and nothing else, the same as empty
+// ones do.
+type Options struct {
+	// Store is where missing archives are found and those built are recorded, the archives_archive table if nil
+	Store ArchiveStore
+
+	// Source is where the records of new archives are read from, our database, or Replica, if nil
+	Source RecordSource
+
+	// Replica is a read replica of our database which the records of new archives are exported from once it has
+	// replayed past the end of their period, writes and deletions still go to the primary
+	Replica *sqlx.DB
+
+	// Serializers change the shape of the records written to the archives of each type
+	Serializers map[ArchiveType]RecordSerializer
+
+	// Hooks are called as archives move through each stage of archiving
+	Hooks *Hooks
+
+	// Publisher is given an event for each archive once it is committed, and for each of its records if we are
+	// configured to publish records
+	Publisher Publisher
+
+	// Pauser is consulted before starting each new archive or deletion
+	Pauser *Pauser
+
+	// Missing are the missing archives of every org found at the start of a run, which are used instead of looking up
+	// those of each org as it is archived
+	Missing []*MissingArchives
+}
+
+// archiveStore returns our archive store, or one backed by the passed in database if we have none
+func (o *Options) archiveStore(db *sqlx.DB, config *Config) ArchiveStore {
+	if o != nil && o.Store != nil {
+		return o.Store
+	}
+	return NewDBArchiveStore(db, config)
+}
+
+// customSource returns whether the records of new archives are read from somewhere other than our database
+func (o *Options) customSource() bool {
+	return o != nil && o.Source != nil
+}
+
+// recordSource returns our record source, or one which exports from the passed in database, checking there is room
+// for records in the passed in path and serializing them with our serializers, if we have none
+func (o *Options) recordSource(db *sqlx.DB, archivePath string, config *Config) RecordSource {
+	if o.customSource() {
+		return o.Source
+	}
+	source := &DBRecordSource{db: db, config: config, archivePath: archivePath}
+	if o != nil {
+		source.serializers = o.Serializers
+	}
+	return source
+}
+
+// hooks returns our hooks, nil if we have none, which are safe to call
+func (o *Options) hooks() *Hooks {
+	if o == nil {
+		return nil
+	}
+	return o.Hooks
+}
+
+// publisher returns our publisher, nil if we have none
+func (o *Options) publisher() Publisher {
+	if o == nil {
+		return nil
+	}
+	return o.Publisher
+}
+
+// replica returns our read replica, nil if we have none
+func (o *Options) replica() *sqlx.DB {
+	if o == nil {
+		return nil
+	}
+	return o.Replica
+}
+
+// pauser returns our pauser, nil if we have none, which is never paused
+func (o *Options) pauser() *Pauser {
+	if o == nil {
+		return nil
+	}
+	return o.Pauser
+}
+
+// takeMissingArchives takes the missing daily archives of the passed in org and type from those found at the start of
+// our run, if we have them, see MissingArchives.take
+func (o *Options) takeMissingArchives(now time.Time, org Org, archiveType ArchiveType) ([]*Archive, bool, bool) {
+	if o == nil {
+		return nil, false, false
+	}
+	for _, missing := range o.Missing {
+		if missing.ArchiveType == archiveType {
+			return missing.take(now, org)
+		}
+	}
+	return nil, false, false
+}
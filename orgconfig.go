@@ -0,0 +1,80 @@
+package archiver
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// OrgConfig is a row of archiver_org_config, the settings of an org which override our own, those which are null
+// aren't overridden
+type OrgConfig struct {
+	OrgID           int     `db:"org_id"`
+	RetentionPeriod *int    `db:"retention_period"`
+	DeleteArchived  *bool   `db:"delete_archived"`
+	DeleteAfterDays *int    `db:"delete_after_days"`
+	S3Prefix        *string `db:"s3_prefix"`
+}
+
+const lookupOrgConfig = `
+SELECT org_id, retention_period, delete_archived, delete_after_days, s3_prefix
+FROM archiver_org_config
+WHERE org_id = $1
+`
+
+// ApplyOrgConfig looks up the settings of the passed in org in archiver_org_config and returns a copy of the org with
+// them applied. Deletion settings override those of our retention policy, keeping its flow labels.
+func ApplyOrgConfig(ctx context.Context, db *sqlx.DB, conf *Config, org Org) (Org, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	orgConfig := &OrgConfig{}
+	err := db.GetContext(ctx, orgConfig, lookupOrgConfig, org.ID)
+	if err == sql.ErrNoRows {
+		return org, nil
+	}
+	if err != nil {
+		return org, errors.Wrapf(err, "error looking up config for org: %d", org.ID)
+	}
+
+	return orgConfig.apply(conf, org), nil
+}
+
+// apply returns a copy of the passed in org with these settings applied
+func (c *OrgConfig) apply(conf *Config, org Org) Org {
+	if c.RetentionPeriod != nil {
+		org.RetentionPeriod = *c.RetentionPeriod
+	}
+	if c.S3Prefix != nil {
+		org.S3Prefix = strings.Trim(conf.s3Prefix()+"/"+strings.Trim(*c.S3Prefix, "/"), "/")
+	}
+
+	if c.DeleteArchived != nil || c.DeleteAfterDays != nil {
+		// rules can be shared between orgs so we override copies of them
+		rules := make(map[ArchiveType]*RetentionRule)
+		for _, archiveType := range []ArchiveType{MessageType, RunType} {
+			rule := *RetentionRuleFor(conf, org, archiveType)
+			if c.DeleteArchived != nil {
+				rule.Delete = *c.DeleteArchived
+			}
+			if c.DeleteAfterDays != nil {
+				rule.DeleteAfterDays = *c.DeleteAfterDays
+			}
+			rules[archiveType] = &rule
+		}
+		org.RetentionRules = rules
+	}
+	return org
+}
+
+// s3Path returns the passed in path within this org's prefix in our bucket, if it has one
+func (o *Org) s3Path(path string) string {
+	if o.S3Prefix == "" {
+		return path
+	}
+	return "/" + o.S3Prefix + path
+}
@@ -0,0 +1,53 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyOrgConfig(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	config.Delete = true
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+
+	// orgs without a config are unchanged
+	org, err := ApplyOrgConfig(ctx, db, config, orgs[1])
+	assert.NoError(t, err)
+	assert.Equal(t, orgs[1], org)
+
+	db.MustExec(`INSERT INTO archiver_org_config(org_id, retention_period, delete_archived, s3_prefix) VALUES(2, 30, FALSE, '/tenants/acme/')`)
+	db.MustExec(`INSERT INTO archiver_org_config(org_id, delete_after_days) VALUES(3, 365)`)
+
+	org, err = ApplyOrgConfig(ctx, db, config, orgs[1])
+	assert.NoError(t, err)
+	assert.Equal(t, 30, org.RetentionPeriod)
+	assert.Equal(t, "tenants/acme", org.S3Prefix)
+	assert.Equal(t, &RetentionRule{Delete: false}, RetentionRuleFor(config, org, MessageType))
+	assert.Equal(t, &RetentionRule{Delete: false}, RetentionRuleFor(config, org, RunType))
+	assert.Equal(t, "/tenants/acme/2/message_D20171008_abc.jsonl.gz", org.s3Path("/2/message_D20171008_abc.jsonl.gz"))
+
+	// the passed in org isn't changed
+	assert.Equal(t, 90, orgs[1].RetentionPeriod)
+	assert.Equal(t, "", orgs[1].S3Prefix)
+
+	// settings which aren't set keep our own, and rules from our retention policy keep their flow labels
+	orgs[2].RetentionRules = map[ArchiveType]*RetentionRule{RunType: {Delete: true, KeepFlowLabels: []string{"research"}}}
+
+	org, err = ApplyOrgConfig(ctx, db, config, orgs[2])
+	assert.NoError(t, err)
+	assert.Equal(t, 90, org.RetentionPeriod)
+	assert.Equal(t, "", org.S3Prefix)
+	assert.Equal(t, &RetentionRule{Delete: true, DeleteAfterDays: 365}, RetentionRuleFor(config, org, MessageType))
+	assert.Equal(t, &RetentionRule{Delete: true, DeleteAfterDays: 365, KeepFlowLabels: []string{"research"}}, RetentionRuleFor(config, org, RunType))
+	assert.Equal(t, 0, orgs[2].RetentionRules[RunType].DeleteAfterDays)
+
+	// but can't delete anything without our delete setting
+	config.Delete = false
+	assert.Equal(t, &RetentionRule{Delete: false, DeleteAfterDays: 365}, RetentionRuleFor(config, org, MessageType))
+}
@@ -0,0 +1,52 @@
+package archiver
+
+import (
+	"context"
+	"time"
+)
+
+// pacingSlack is how far ahead of its rate a paced task can get before we make it wait, so that we sleep now and then
+// rather than after every record
+const pacingSlack = time.Millisecond * 100
+
+// pacer paces a task, such as reading the records of an export query or deleting batches of records, to a maximum
+// number of records per second so that we don't overload a busy database. A nil pacer doesn't limit anything.
+type pacer struct {
+	rate    int
+	start   time.Time
+	records int
+}
+
+// newPacer creates a new pacer for the passed in maximum number of records per second, returning nil if that is zero
+func newPacer(rate int) *pacer {
+	if rate <= 0 {
+		return nil
+	}
+	return &pacer{rate: rate, start: time.Now()}
+}
+
+// wait notes that the passed in number of records have been processed and, if that puts us ahead of our rate, waits
+// until we are back on it, or returns an error if the passed in context is done first
+func (p *pacer) wait(ctx context.Context, records int) error {
+	if p == nil {
+		return nil
+	}
+	p.records += records
+
+	ahead := p.earliest().Sub(time.Now())
+	if ahead < pacingSlack {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(ahead):
+		return nil
+	}
+}
+
+// earliest returns the earliest time the records processed so far could have been processed at our rate
+func (p *pacer) earliest() time.Time {
+	return p.start.Add(time.Duration(float64(p.records) / float64(p.rate) * float64(time.Second)))
+}
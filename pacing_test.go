@@ -0,0 +1,33 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacer(t *testing.T) {
+	ctx := context.Background()
+
+	// no rate is no pacing
+	pace := newPacer(0)
+	assert.Nil(t, pace)
+	assert.NoError(t, pace.wait(ctx, 1000000))
+
+	// staying within our slack doesn't wait
+	pace = newPacer(1000)
+	start := time.Now()
+	assert.NoError(t, pace.wait(ctx, 50))
+	assert.True(t, time.Since(start) < pacingSlack)
+
+	// but getting ahead of our rate waits until we're back on it
+	assert.NoError(t, pace.wait(ctx, 250))
+	assert.True(t, time.Since(start) >= time.Millisecond*300)
+
+	// unless our context is done first
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	assert.Equal(t, context.Canceled, pace.wait(cancelled, 10000))
+}
@@ -0,0 +1,116 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// exportKey is the position of a record in the order we export them, the time we page on, created_on for messages and
+// modified_on for runs, and its id to order records with the same time
+type exportKey struct {
+	at time.Time
+	id int64
+}
+
+// exportPages runs the passed in export query a page at a time, starting from the passed in time, calling the passed
+// in function with each row which returns its key. The query takes the passed in params followed by the key to start
+// after and the page size, and paging stops once a page is short. A page size of zero reads every record at once.
+// Each page is read through a server side cursor with the passed in fetch size, see queryRows.
+func exportPages(ctx context.Context, db sqlx.ExtContext, query string, params []interface{}, start time.Time, pageSize int, fetchSize int, each func(*sqlx.Rows) (exportKey, error)) error {
+	// a null limit is no limit at all
+	var limit interface{}
+	if pageSize > 0 {
+		limit = pageSize
+	}
+
+	after := exportKey{at: start}
+	for page := 1; ; page++ {
+		args := append(append(make([]interface{}, 0, len(params)+3), params...), after.at, after.id, limit)
+		count, err := queryRows(ctx, db, query, args, fetchSize, func(rows *sqlx.Rows) error {
+			var err error
+			after, err = each(rows)
+			return err
+		})
+		if err != nil {
+			return errors.Wrapf(err, "error reading page %d", page)
+		}
+
+		if pageSize == 0 || count < pageSize {
+			return nil
+		}
+	}
+}
+
+// exportCursor is the name of the cursor export queries are read through
+const exportCursor = "export_records"
+
+const closeCursorSQL = "CLOSE " + exportCursor
+
+// declareCursorSQL returns the SQL to declare our export cursor for the passed in query
+func declareCursorSQL(query string) string {
+	return fmt.Sprintf("DECLARE %s NO SCROLL CURSOR FOR %s", exportCursor, query)
+}
+
+// fetchCursorSQL returns the SQL to fetch the passed in number of rows from our export cursor
+func fetchCursorSQL(fetchSize int) string {
+	return fmt.Sprintf("FETCH FORWARD %d FROM %s", fetchSize, exportCursor)
+}
+
+// queryRows runs the passed in query, calling the passed in function with each row, and returns how many rows were
+// read. With a fetch size the query is read through a server side cursor that many rows at a time, so its results are
+// sent to us in batches we ask for as we write them rather than all at once, and the passed in db must be a transaction.
+func queryRows(ctx context.Context, db sqlx.ExtContext, query string, args []interface{}, fetchSize int, each func(*sqlx.Rows) error) (int, error) {
+	if fetchSize == 0 {
+		rows, err := db.QueryxContext(ctx, query, args...)
+		if err != nil {
+			return 0, errors.Wrapf(err, "error querying records")
+		}
+		return scanRows(rows, each)
+	}
+
+	_, err := db.ExecContext(ctx, declareCursorSQL(query), args...)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error declaring export cursor")
+	}
+
+	total := 0
+	for {
+		rows, err := db.QueryxContext(ctx, fetchCursorSQL(fetchSize))
+		if err != nil {
+			return total, errors.Wrapf(err, "error fetching from export cursor")
+		}
+		count, err := scanRows(rows, each)
+		total += count
+		if err != nil {
+			return total, err
+		}
+		if count < fetchSize {
+			break
+		}
+	}
+
+	_, err = db.ExecContext(ctx, closeCursorSQL)
+	if err != nil {
+		return total, errors.Wrapf(err, "error closing export cursor")
+	}
+	return total, nil
+}
+
+// scanRows calls the passed in function with each of the passed in rows, closing them when done
+func scanRows(rows *sqlx.Rows, each func(*sqlx.Rows) error) (int, error) {
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		err := each(rows)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, rows.Err()
+}
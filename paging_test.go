@@ -0,0 +1,86 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportPages(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	orgs, err := GetActiveOrgs(ctx, db, NewConfig())
+	assert.NoError(t, err)
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	msgTasks, err := GetMissingDailyArchives(ctx, db, now, orgs[1], MessageType)
+	assert.NoError(t, err)
+	runTasks, err := GetMissingDailyArchives(ctx, db, now, orgs[1], RunType)
+	assert.NoError(t, err)
+
+	// however many pages our records are read in, and however many rows are fetched from the cursor at a time, we write
+	// the same archive as a single query
+	for _, pageSize := range []int{0, 1, 2, 3, 10000} {
+		for _, fetchSize := range []int{0, 1, 2, 1000} {
+			config := &Config{ExportPageSize: pageSize, ExportFetchSize: fetchSize}
+
+			task := msgTasks[2]
+			err = createArchiveFile(ctx, db, task, "/tmp", config, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, 3, task.RecordCount)
+			assert.Equal(t, "6fe9265860425cf1f9757ba3d91b1a05", task.Hash)
+			assertArchiveFile(t, task, "messages1.jsonl")
+			DeleteArchiveFile(task)
+			task.ArchiveFile = ""
+
+			task = runTasks[2]
+			err = createArchiveFile(ctx, db, task, "/tmp", config, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, 2, task.RecordCount)
+			assert.Equal(t, "f793f863f5e060b9d67c5688a555da6a", task.Hash)
+			DeleteArchiveFile(task)
+			task.ArchiveFile = ""
+		}
+	}
+}
+
+func TestQueryRowsCursor(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	query := `SELECT id FROM msgs_msg WHERE org_id = $1 ORDER BY id`
+	ids := make([]int64, 0)
+	collect := func(rows *sqlx.Rows) error {
+		var id int64
+		err := rows.Scan(&id)
+		ids = append(ids, id)
+		return err
+	}
+
+	// without a fetch size we can query outside of a transaction
+	count, err := queryRows(ctx, db, query, []interface{}{2}, 0, collect)
+	assert.NoError(t, err)
+	assert.Equal(t, len(ids), count)
+	expected := ids
+
+	// but a cursor needs one
+	_, err = queryRows(ctx, db, query, []interface{}{2}, 2, collect)
+	assert.Error(t, err)
+
+	tx, err := db.BeginTxx(ctx, nil)
+	assert.NoError(t, err)
+	defer tx.Rollback()
+
+	// fetching in batches gives us the same rows, and closes the cursor so the next query can declare it again
+	for _, fetchSize := range []int{1, 2, 1000} {
+		ids = make([]int64, 0)
+		count, err = queryRows(ctx, tx, query, []interface{}{2}, fetchSize, collect)
+		assert.NoError(t, err)
+		assert.Equal(t, len(expected), count)
+		assert.Equal(t, expected, ids)
+	}
+}
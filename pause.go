@@ -0,0 +1,173 @@
+package archiver
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// pauseCheckInterval is how often we check the database for whether we've been paused
+var pauseCheckInterval = time.Second * 15
+
+// ArchiverPause is a row in archiver_pause, while there are any the archiver doesn't start new work
+type ArchiverPause struct {
+	ID       int       `db:"id"`
+	Reason   string    `db:"reason"`
+	PausedOn time.Time `db:"paused_on"`
+}
+
+const insertArchiverPause = `
+INSERT INTO archiver_pause(reason, paused_on)
+VALUES($1, NOW())
+RETURNING id, reason, paused_on
+`
+
+// PauseArchiver pauses all archivers using the passed in database for the passed in reason until they are resumed
+func PauseArchiver(ctx context.Context, db *sqlx.DB, reason string) (*ArchiverPause, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	pause := &ArchiverPause{}
+	err := db.GetContext(ctx, pause, insertArchiverPause, reason)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error pausing archiver")
+	}
+	return pause, nil
+}
+
+const deleteArchiverPauses = `DELETE FROM archiver_pause`
+
+// ResumeArchiver resumes all archivers using the passed in database, returning how many pauses were removed
+func ResumeArchiver(ctx context.Context, db *sqlx.DB) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, deleteArchiverPauses)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error resuming archiver")
+	}
+	removed, _ := result.RowsAffected()
+	return int(removed), nil
+}
+
+const lookupArchiverPause = `
+SELECT id, reason, paused_on
+FROM archiver_pause
+ORDER BY paused_on DESC, id DESC
+LIMIT 1
+`
+
+// GetArchiverPause returns the most recent pause of archivers using the passed in database, or nil if they aren't paused
+func GetArchiverPause(ctx context.Context, db *sqlx.DB) (*ArchiverPause, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	pause := &ArchiverPause{}
+	err := db.GetContext(ctx, pause, lookupArchiverPause)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up archiver pause")
+	}
+	return pause, nil
+}
+
+// Pauser decides whether we should wait before starting new work, which is the case while we've been paused by a
+// signal or by a row in archiver_pause. Work already started carries on regardless. A nil pauser is never paused.
+type Pauser struct {
+	db *sqlx.DB
+
+	mutex       sync.Mutex
+	paused      bool
+	pauseReason string
+	dbPause     *ArchiverPause
+	checkedOn   time.Time
+}
+
+// NewPauser creates a new pauser which checks the passed in database for pauses, if any
+func NewPauser(db *sqlx.DB) *Pauser {
+	return &Pauser{db: db}
+}
+
+// Pause pauses us until Resume is called, such as on SIGUSR1
+func (p *Pauser) Pause() {
+	p.PauseFor("paused by signal")
+}
+
+// PauseFor pauses us for the passed in reason until Resume is called, such as when paused over HTTP
+func (p *Pauser) PauseFor(reason string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.paused = true
+	p.pauseReason = reason
+}
+
+// Resume resumes us after a call to Pause or PauseFor, such as on SIGUSR2, pauses in the database still apply
+func (p *Pauser) Resume() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.paused = false
+	p.pauseReason = ""
+}
+
+// Paused returns whether we are currently paused, and why
+func (p *Pauser) Paused(ctx context.Context) (bool, string) {
+	if p == nil {
+		return false, ""
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.paused {
+		return true, p.pauseReason
+	}
+
+	if p.db != nil && time.Since(p.checkedOn) >= pauseCheckInterval {
+		pause, err := GetArchiverPause(ctx, p.db)
+		if err != nil {
+			// if we can't check, we stay as we were
+			logrus.WithError(err).Error("error checking for archiver pause")
+		} else {
+			p.dbPause = pause
+		}
+		p.checkedOn = time.Now()
+	}
+
+	if p.dbPause != nil {
+		return true, p.dbPause.Reason
+	}
+	return false, ""
+}
+
+// WaitWhilePaused waits until the passed in pauser, if any, is no longer paused, or until we're asked to stop starting
+// new work or the context is done
+func WaitWhilePaused(ctx context.Context, p *Pauser) {
+	paused, reason := p.Paused(ctx)
+	if !paused {
+		return
+	}
+
+	logrus.WithField("reason", reason).Warn("paused, not starting new work until resumed")
+	start := time.Now()
+
+	for paused && !Draining(ctx) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+		paused, _ = p.Paused(ctx)
+	}
+
+	if !paused {
+		logrus.WithField("paused_for", time.Since(start)).Info("resumed")
+	}
+}
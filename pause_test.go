@@ -0,0 +1,88 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiverPause(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	pause, err := GetArchiverPause(ctx, db)
+	assert.NoError(t, err)
+	assert.Nil(t, pause)
+
+	created, err := PauseArchiver(ctx, db, "vacuuming msgs_msg")
+	assert.NoError(t, err)
+	assert.Equal(t, "vacuuming msgs_msg", created.Reason)
+
+	pause, err = GetArchiverPause(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, created.ID, pause.ID)
+
+	removed, err := ResumeArchiver(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+
+	pause, err = GetArchiverPause(ctx, db)
+	assert.NoError(t, err)
+	assert.Nil(t, pause)
+}
+
+func TestPauser(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	defer func(interval time.Duration) { pauseCheckInterval = interval }(pauseCheckInterval)
+	pauseCheckInterval = 0
+
+	// a nil pauser is never paused
+	var nilPauser *Pauser
+	paused, _ := nilPauser.Paused(ctx)
+	assert.False(t, paused)
+	WaitWhilePaused(ctx, nilPauser)
+
+	pauser := NewPauser(db)
+	paused, _ = pauser.Paused(ctx)
+	assert.False(t, paused)
+
+	// pausing by signal
+	pauser.Pause()
+	paused, reason := pauser.Paused(ctx)
+	assert.True(t, paused)
+	assert.Equal(t, "paused by signal", reason)
+	pauser.Resume()
+
+	// or for a reason of our own
+	pauser.PauseFor("restoring backups")
+	paused, reason = pauser.Paused(ctx)
+	assert.True(t, paused)
+	assert.Equal(t, "restoring backups", reason)
+	pauser.Resume()
+
+	// pausing in the database
+	_, err := PauseArchiver(ctx, db, "vacuuming msgs_msg")
+	assert.NoError(t, err)
+	paused, reason = pauser.Paused(ctx)
+	assert.True(t, paused)
+	assert.Equal(t, "vacuuming msgs_msg", reason)
+
+	// waiting while paused returns once we're resumed
+	go func() {
+		time.Sleep(time.Millisecond * 500)
+		ResumeArchiver(ctx, db)
+	}()
+	start := time.Now()
+	WaitWhilePaused(ctx, pauser)
+	assert.True(t, time.Since(start) >= time.Millisecond*500)
+
+	// or once we're asked to stop starting new work
+	pauser.Pause()
+	drain := make(chan struct{})
+	close(drain)
+	WaitWhilePaused(WithDrain(ctx, drain, ErrShuttingDown), pauser)
+}
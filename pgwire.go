@@ -0,0 +1,528 @@
+package archiver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os/user"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// the protocol version we speak and the code of the request to switch to TLS, which go where the version does
+const (
+	pgProtocolVersion = 196608
+	pgSSLRequestCode  = 80877103
+)
+
+// pgDriverSettings are the settings of a connection string which configure how we connect rather than being run time
+// parameters sent to the server, the same as lib/pq
+var pgDriverSettings = map[string]bool{
+	"host": true, "port": true, "password": true, "sslmode": true, "sslcert": true, "sslkey": true, "sslrootcert": true,
+	"fallback_application_name": true, "connect_timeout": true, "disable_prepared_binary_result": true, "binary_parameters": true,
+}
+
+// pgConn is a connection to Postgres of our own, which speaks just enough of its protocol to connect the same way as
+// lib/pq and read the rows a COPY ... TO STDOUT sends, which lib/pq can't
+type pgConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	header [5]byte
+	buffer []byte
+}
+
+// dialPG connects to the database with the passed in connection string, with the passed in run time parameters as
+// well as those in the connection string
+func dialPG(ctx context.Context, dsn string, params map[string]string) (*pgConn, error) {
+	opts, err := parsePGConnString(dsn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid db connection string")
+	}
+	for k, v := range params {
+		opts[k] = v
+	}
+
+	network, address := "tcp", net.JoinHostPort(opts["host"], opts["port"])
+	if strings.HasPrefix(opts["host"], "/") {
+		network, address = "unix", path.Join(opts["host"], ".s.PGSQL."+opts["port"])
+		opts["sslmode"] = "disable"
+	}
+
+	tlsConfig, err := pgTLSConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error connecting to database")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c := &pgConn{conn: conn}
+	if tlsConfig != nil {
+		c.conn, err = c.startTLS(tlsConfig)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	c.reader = bufio.NewReaderSize(c.conn, 64*1024)
+
+	err = c.startup(opts)
+	if err != nil {
+		c.conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// parsePGConnString parses the passed in connection string, a URL or a list of key=value settings, into its settings,
+// with the same defaults as lib/pq
+func parsePGConnString(dsn string) (map[string]string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		var err error
+		dsn, err = pq.ParseURL(dsn)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	opts := map[string]string{"host": "localhost", "port": "5432", "extra_float_digits": "2"}
+
+	runes := []rune(dsn)
+	for i := 0; i < len(runes); {
+		for i < len(runes) && unicode.IsSpace(runes[i]) {
+			i++
+		}
+		if i == len(runes) {
+			break
+		}
+
+		start := i
+		for i < len(runes) && runes[i] != '=' && !unicode.IsSpace(runes[i]) {
+			i++
+		}
+		key := string(runes[start:i])
+		for i < len(runes) && unicode.IsSpace(runes[i]) {
+			i++
+		}
+		if i == len(runes) || runes[i] != '=' {
+			return nil, fmt.Errorf("missing \"=\" after %q in connection info string", key)
+		}
+		i++
+		for i < len(runes) && unicode.IsSpace(runes[i]) {
+			i++
+		}
+
+		var value []rune
+		if i < len(runes) && runes[i] == '\'' {
+			i++
+			for ; i < len(runes) && runes[i] != '\''; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				value = append(value, runes[i])
+			}
+			if i == len(runes) {
+				return nil, fmt.Errorf("unterminated quoted string in connection info string")
+			}
+			i++
+		} else {
+			for ; i < len(runes) && !unicode.IsSpace(runes[i]); i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				value = append(value, runes[i])
+			}
+		}
+		opts[key] = string(value)
+	}
+
+	if fallback, ok := opts["fallback_application_name"]; ok && opts["application_name"] == "" {
+		opts["application_name"] = fallback
+	}
+	opts["client_encoding"] = "UTF8"
+	opts["datestyle"] = "ISO, MDY"
+
+	if opts["user"] == "" {
+		u, err := user.Current()
+		if err != nil {
+			return nil, errors.Wrapf(err, "error looking up user to connect as")
+		}
+		opts["user"] = u.Username
+	}
+	return opts, nil
+}
+
+// pgTLSConfig returns the TLS config for the sslmode of the passed in settings, nil if we shouldn't use TLS, which
+// like lib/pq is required unless disabled, verifies the server's certificate against our root certificate only for
+// verify-ca, or when one is given with require, and verifies its host name as well for verify-full
+func pgTLSConfig(opts map[string]string) (*tls.Config, error) {
+	config := &tls.Config{}
+	verifyCA := false
+
+	switch mode := opts["sslmode"]; mode {
+	case "", "require":
+		config.InsecureSkipVerify = true
+		verifyCA = opts["sslrootcert"] != ""
+	case "verify-ca":
+		config.InsecureSkipVerify = true
+		verifyCA = true
+	case "verify-full":
+		config.ServerName = opts["host"]
+	case "disable":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported sslmode %q; only \"require\" (default), \"verify-full\", \"verify-ca\", and \"disable\" supported", mode)
+	}
+
+	if opts["sslcert"] != "" && opts["sslkey"] != "" {
+		cert, err := tls.LoadX509KeyPair(opts["sslcert"], opts["sslkey"])
+		if err != nil {
+			return nil, errors.Wrapf(err, "error loading ssl client certificate")
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	if opts["sslrootcert"] != "" {
+		pem, err := ioutil.ReadFile(opts["sslrootcert"])
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading ssl root certificate")
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("couldn't parse ssl root certificate: %s", opts["sslrootcert"])
+		}
+	}
+
+	if verifyCA {
+		roots := config.RootCAs
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			certs := make([]*x509.Certificate, len(rawCerts))
+			for i, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					return err
+				}
+				certs[i] = cert
+			}
+			if len(certs) == 0 {
+				return fmt.Errorf("server sent no ssl certificate")
+			}
+			verify := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+			for _, cert := range certs[1:] {
+				verify.Intermediates.AddCert(cert)
+			}
+			_, err := certs[0].Verify(verify)
+			return err
+		}
+	}
+	return config, nil
+}
+
+// startTLS asks the server to switch our connection to TLS, returning the TLS connection
+func (c *pgConn) startTLS(config *tls.Config) (net.Conn, error) {
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request[0:], 8)
+	binary.BigEndian.PutUint32(request[4:], pgSSLRequestCode)
+	if _, err := c.conn.Write(request); err != nil {
+		return nil, errors.Wrapf(err, "error requesting ssl")
+	}
+
+	answer := make([]byte, 1)
+	if _, err := io.ReadFull(c.conn, answer); err != nil {
+		return nil, errors.Wrapf(err, "error requesting ssl")
+	}
+	if answer[0] != 'S' {
+		return nil, fmt.Errorf("pq: SSL is not enabled on the server")
+	}
+
+	conn := tls.Client(c.conn, config)
+	if err := conn.Handshake(); err != nil {
+		return nil, errors.Wrapf(err, "error starting ssl")
+	}
+	return conn, nil
+}
+
+// startup sends our startup message with the run time parameters of the passed in settings, authenticates and waits
+// for the server to be ready for queries
+func (c *pgConn) startup(opts map[string]string) error {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint32(message[4:], pgProtocolVersion)
+	for k, v := range opts {
+		if pgDriverSettings[k] {
+			continue
+		}
+		if k == "dbname" {
+			k = "database"
+		}
+		message = append(append(append(append(message, k...), 0), v...), 0)
+	}
+	message = append(message, 0)
+	binary.BigEndian.PutUint32(message[0:], uint32(len(message)))
+	if _, err := c.conn.Write(message); err != nil {
+		return errors.Wrapf(err, "error sending startup message")
+	}
+
+	for {
+		kind, body, err := c.receive()
+		if err != nil {
+			return err
+		}
+
+		switch kind {
+		case 'R':
+			err = c.authenticate(body, opts)
+			if err != nil {
+				return err
+			}
+		case 'E':
+			return pgError(body)
+		case 'Z':
+			return nil
+		case 'S', 'K', 'N':
+			// parameter statuses, our backend's key and notices aren't anything we need
+		default:
+			return fmt.Errorf("unexpected message '%c' starting up", kind)
+		}
+	}
+}
+
+// authenticate answers the passed in authentication request with the password of the passed in settings, in clear
+// text or hashed with MD5, the methods lib/pq supports
+func (c *pgConn) authenticate(body []byte, opts map[string]string) error {
+	if len(body) < 4 {
+		return fmt.Errorf("invalid authentication request")
+	}
+
+	var password string
+	switch code := binary.BigEndian.Uint32(body); code {
+	case 0:
+		return nil
+	case 3:
+		password = opts["password"]
+	case 5:
+		if len(body) < 8 {
+			return fmt.Errorf("invalid md5 authentication request")
+		}
+		password = "md5" + md5Hex(md5Hex(opts["password"]+opts["user"])+string(body[4:8]))
+	default:
+		return fmt.Errorf("unsupported authentication method: %d", code)
+	}
+
+	return c.send('p', append([]byte(password), 0))
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// copyOut runs the passed in COPY ... TO STDOUT in text format and calls the passed in function with the fields of
+// each row it sends, nil for nulls. Our connection can't be used again if this fails.
+func (c *pgConn) copyOut(ctx context.Context, query string, each func([]*string) error) error {
+	// give up on the connection if we're cancelled, which unblocks any read we're waiting on
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	err := c.copyRows(query, each)
+	if err != nil && ctx.Err() != nil {
+		return errors.Wrapf(ctx.Err(), "error reading copy")
+	}
+	return err
+}
+
+func (c *pgConn) copyRows(query string, each func([]*string) error) error {
+	err := c.send('Q', append([]byte(query), 0))
+	if err != nil {
+		return errors.Wrapf(err, "error sending copy")
+	}
+
+	var failed error
+	var fields []*string
+	for {
+		kind, body, err := c.receive()
+		if err != nil {
+			return err
+		}
+
+		switch kind {
+		case 'H':
+			if len(body) < 1 || body[0] != 0 {
+				return fmt.Errorf("copy isn't in text format")
+			}
+		case 'd':
+			fields = parseCopyRow(body, fields[:0])
+			err = each(fields)
+			if err != nil {
+				return err
+			}
+		case 'E':
+			failed = pgError(body)
+		case 'Z':
+			return failed
+		case 'c', 'C', 'S', 'N':
+			// the end of the copy and its command, parameter statuses and notices aren't anything we need
+		default:
+			return fmt.Errorf("unexpected message '%c' reading copy", kind)
+		}
+	}
+}
+
+// Close tells the server we're done and closes our connection
+func (c *pgConn) Close() error {
+	c.send('X', nil)
+	return c.conn.Close()
+}
+
+// send sends a message of the passed in kind with the passed in body
+func (c *pgConn) send(kind byte, body []byte) error {
+	message := make([]byte, 5, 5+len(body))
+	message[0] = kind
+	binary.BigEndian.PutUint32(message[1:], uint32(4+len(body)))
+	_, err := c.conn.Write(append(message, body...))
+	return err
+}
+
+// receive reads the next message the server sends us, whose body is only valid until the next is read
+func (c *pgConn) receive() (byte, []byte, error) {
+	header := c.header[:]
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return 0, nil, errors.Wrapf(err, "error reading from database")
+	}
+
+	size := int(binary.BigEndian.Uint32(header[1:])) - 4
+	if size < 0 {
+		return 0, nil, fmt.Errorf("invalid message length from database")
+	}
+	if cap(c.buffer) < size {
+		c.buffer = make([]byte, size)
+	}
+	body := c.buffer[:size]
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return 0, nil, errors.Wrapf(err, "error reading from database")
+	}
+	return header[0], body, nil
+}
+
+// pgError returns the error in the passed in error response, a *pq.Error like those of lib/pq so it is classified the
+// same way
+func pgError(body []byte) error {
+	err := &pq.Error{}
+	for len(body) > 1 {
+		field := body[0]
+		end := bytes.IndexByte(body, 0)
+		if end < 0 {
+			end = len(body)
+		}
+		value := string(body[1:end])
+
+		switch field {
+		case 'S':
+			err.Severity = value
+		case 'C':
+			err.Code = pq.ErrorCode(value)
+		case 'M':
+			err.Message = value
+		case 'D':
+			err.Detail = value
+		case 'H':
+			err.Hint = value
+		case 'W':
+			err.Where = value
+		}
+
+		if end >= len(body) {
+			break
+		}
+		body = body[end+1:]
+	}
+	return err
+}
+
+// parseCopyRow appends the fields of the passed in row of a copy in text format to the passed in slice, nil for nulls
+func parseCopyRow(row []byte, fields []*string) []*string {
+	if len(row) > 0 && row[len(row)-1] == '\n' {
+		row = row[:len(row)-1]
+	}
+
+	for {
+		end := bytes.IndexByte(row, '\t')
+		if end < 0 {
+			end = len(row)
+		}
+
+		field := row[:end]
+		if len(field) == 2 && field[0] == '\\' && field[1] == 'N' {
+			fields = append(fields, nil)
+		} else {
+			value := unescapeCopyField(field)
+			fields = append(fields, &value)
+		}
+
+		if end == len(row) {
+			return fields
+		}
+		row = row[end+1:]
+	}
+}
+
+// unescapeCopyField returns the value of the passed in field of a copy in text format, which only escapes backslashes
+// and control characters when it writes them
+func unescapeCopyField(field []byte) string {
+	if bytes.IndexByte(field, '\\') < 0 {
+		return string(field)
+	}
+
+	var value strings.Builder
+	value.Grow(len(field))
+	for i := 0; i < len(field); i++ {
+		if field[i] != '\\' || i == len(field)-1 {
+			value.WriteByte(field[i])
+			continue
+		}
+		i++
+		switch field[i] {
+		case 'b':
+			value.WriteByte('\b')
+		case 'f':
+			value.WriteByte('\f')
+		case 'n':
+			value.WriteByte('\n')
+		case 'r':
+			value.WriteByte('\r')
+		case 't':
+			value.WriteByte('\t')
+		case 'v':
+			value.WriteByte('\v')
+		default:
+			value.WriteByte(field[i])
+		}
+	}
+	return value.String()
+}
@@ -0,0 +1,137 @@
+package archiver
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// piiPatterns are the patterns of likely PII we look for in the free text of the records of anonymous orgs, by name
+var piiPatterns = map[string]*regexp.Regexp{
+	"email":       regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9\-]+(\.[A-Za-z0-9\-]+)*\.[A-Za-z]{2,}`),
+	"phone":       regexp.MustCompile(`(\+|\b00)\d[\d \-]{6,15}\d\b`),
+	"national_id": regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b|\b\d{11,13}\b`),
+}
+
+// piiFields are the paths of the free text fields of the records of each archive type which we scan for PII
+var piiFields = map[ArchiveType][]string{
+	MessageType: {"text"},
+	RunType:     {"values.*.input", "values.*.value"},
+}
+
+// PIIScanner counts the records of an archive with free text which looks like PII, so that it can be checked that the
+// archives of anonymous orgs really are anonymous. Like RecordChain, it is written the uncompressed JSONL of an
+// archive, one record per line.
+type PIIScanner struct {
+	fields  [][]string
+	pending []byte
+	records int
+	found   map[string]int
+}
+
+// NewPIIScanner returns a new PII scanner for records of the passed in type
+func NewPIIScanner(archiveType ArchiveType) *PIIScanner {
+	fields := make([][]string, 0, len(piiFields[archiveType]))
+	for _, path := range piiFields[archiveType] {
+		fields = append(fields, strings.Split(path, "."))
+	}
+	return &PIIScanner{fields: fields, found: make(map[string]int)}
+}
+
+// Write scans the records in the passed in bytes, records which span writes are buffered until complete
+func (s *PIIScanner) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		idx := bytes.IndexByte(p, '\n')
+		if idx < 0 {
+			s.pending = append(s.pending, p...)
+			break
+		}
+
+		if len(s.pending) > 0 {
+			s.pending = append(s.pending, p[:idx]...)
+			s.scan(s.pending)
+			s.pending = s.pending[:0]
+		} else {
+			s.scan(p[:idx])
+		}
+		p = p[idx+1:]
+	}
+	return written, nil
+}
+
+// scan counts the patterns found in the free text of the passed in record, each pattern once per record
+func (s *PIIScanner) scan(record []byte) {
+	s.records++
+
+	var value interface{}
+	if json.Unmarshal(record, &value) != nil {
+		return
+	}
+
+	texts := make([]string, 0, 1)
+	for _, path := range s.fields {
+		texts = collectStrings(value, path, texts)
+	}
+
+	for name, pattern := range piiPatterns {
+		for _, text := range texts {
+			if pattern.MatchString(text) {
+				s.found[name]++
+				break
+			}
+		}
+	}
+}
+
+// collectStrings appends the strings at the passed in path within the passed in decoded JSON value to the passed in
+// slice, arrays are walked element by element and * matches any key, the same as redacted paths
+func collectStrings(value interface{}, path []string, texts []string) []string {
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			texts = collectStrings(item, path, texts)
+		}
+	case map[string]interface{}:
+		if len(path) == 0 {
+			return texts
+		}
+		for key, child := range v {
+			if key == path[0] || path[0] == "*" {
+				texts = collectStrings(child, path[1:], texts)
+			}
+		}
+	case string:
+		if len(path) == 0 {
+			texts = append(texts, v)
+		}
+	}
+	return texts
+}
+
+// Records returns the number of complete records scanned
+func (s *PIIScanner) Records() int {
+	return s.records
+}
+
+// Found returns the number of records in which each pattern of PII was found, only patterns found in any are included
+func (s *PIIScanner) Found() map[string]int {
+	return s.found
+}
+
+// logFound logs how many records had each pattern of PII, as a warning if any had some
+func (s *PIIScanner) logFound(log *logrus.Entry) {
+	fields := logrus.Fields{"records_scanned": s.records}
+	for name, count := range s.found {
+		fields["pii_"+name] = count
+	}
+
+	if len(s.found) == 0 {
+		log.WithFields(fields).Debug("no likely pii found in archive of anonymous org")
+	} else {
+		log.WithFields(fields).Warn("likely pii found in archive of anonymous org")
+	}
+}
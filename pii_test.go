@@ -0,0 +1,27 @@
+package archiver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPIIScanner(t *testing.T) {
+	scanner := NewPIIScanner(MessageType)
+
+	// records can span writes
+	scanner.Write([]byte(`{"id":1,"text":"write to me at bob@example.com"}` + "\n" + `{"id":2,"text":"call +250 788 123`))
+	scanner.Write([]byte(`456 or bob@example.org"}` + "\n" + `{"id":3,"text":"my ssn is 123-45-6789"}` + "\n"))
+	scanner.Write([]byte(`{"id":4,"text":"I have 3 cows and 12 goats"}` + "\n" + `{"id":5,"text":null}` + "\n" + `{"id":6,"text":"bob@`))
+
+	assert.Equal(t, 5, scanner.Records())
+	assert.Equal(t, map[string]int{"email": 2, "phone": 1, "national_id": 1}, scanner.Found())
+
+	// only the free text fields of runs are scanned
+	scanner = NewPIIScanner(RunType)
+	scanner.Write([]byte(`{"id":1,"contact":{"name":"bob@example.com"},"values":{"email":{"input":"bob@example.com","value":"bob@example.com"},"age":{"input":"12","value":"12"}}}` + "\n"))
+	scanner.Write([]byte(`{"id":2,"values":{}}` + "\n" + `not json` + "\n"))
+
+	assert.Equal(t, 3, scanner.Records())
+	assert.Equal(t, map[string]int{"email": 1}, scanner.Found())
+}
@@ -0,0 +1,141 @@
+package archiver
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// PlannedArchive is an archive which a run would build, or an existing archive whose records it would delete
+type PlannedArchive struct {
+	Archive *Archive
+
+	// whether the archive would be built, and if so whether by rolling up its dailies
+	Build  bool
+	Rollup bool
+
+	// our estimate of the records in the archive, and of those which would be deleted from the database
+	Records int
+	Deletes int
+}
+
+// PlanOrgArchives works out what archiving the passed in org and type would do, which archives would be built and
+// which records deleted, without writing files, uploading or modifying the database. Record counts are estimated with
+// count queries over the period of each archive, so may differ a little from what is actually archived.
+func PlanOrgArchives(ctx context.Context, now time.Time, config *Config, db *sqlx.DB, org Org, archiveType ArchiveType) ([]*PlannedArchive, error) {
+	archiveCount, err := GetCurrentArchiveCount(ctx, db, org, archiveType)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error getting current archive count")
+	}
+
+	planned := make([]*PlannedArchive, 0)
+
+	// like a run, if there are no existing archives we build full months first
+	monthlies := make([]*Archive, 0)
+	if archiveCount == 0 && config.buildsPeriod(MonthPeriod) {
+		monthlies, err = GetMissingMonthlyArchives(ctx, db, now, org, archiveType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting missing monthly archives")
+		}
+	}
+
+	// then the dailies which aren't covered by those months
+	dailies := make([]*Archive, 0)
+	if config.buildsPeriod(DayPeriod) {
+		missing, err := GetMissingDailyArchives(ctx, db, now, org, archiveType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting missing daily archives")
+		}
+		for _, daily := range missing {
+			if !coveredByAny(monthlies, daily.StartDate) {
+				dailies = append(dailies, daily)
+			}
+		}
+	}
+
+	for _, archive := range append(monthlies, dailies...) {
+		count, err := countArchivableRecords(ctx, db, archive)
+		if err != nil {
+			return nil, err
+		}
+		planned = append(planned, &PlannedArchive{Archive: archive, Build: true, Records: count})
+	}
+
+	// then any other missing months are rolled up from their dailies, existing or planned
+	if config.buildsPeriod(MonthPeriod) {
+		rollups, err := GetMissingMonthlyArchives(ctx, db, now, org, archiveType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error getting missing monthly archives")
+		}
+		for _, monthly := range rollups {
+			if coveredByAny(monthlies, monthly.StartDate) {
+				continue
+			}
+
+			records, err := plannedRollupRecords(ctx, db, org, archiveType, monthly, planned)
+			if err != nil {
+				return nil, err
+			}
+			planned = append(planned, &PlannedArchive{Archive: monthly, Build: true, Rollup: true, Records: records})
+		}
+	}
+
+	rule := RetentionRuleFor(config, org, archiveType)
+	if !rule.Delete || config.DeleteDryRun {
+		return planned, nil
+	}
+
+	// records of the archives we build are deleted in the same run once uploaded, if old enough and not held
+	holds, err := GetActiveLegalHolds(ctx, db, org, archiveType)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range planned {
+		if config.UploadToS3 && !p.Rollup && rule.eligibleForDeletion(now, p.Archive) && heldBy(holds, p.Archive) == nil {
+			p.Deletes = p.Records
+		}
+	}
+
+	// as are those of existing archives still needing deletion
+	reports, err := ReportArchivedOrgDeletions(ctx, now, config, db, org, archiveType)
+	if err != nil {
+		return nil, err
+	}
+	for _, report := range reports {
+		planned = append(planned, &PlannedArchive{Archive: report.Archive, Records: report.Archive.RecordCount, Deletes: report.Count})
+	}
+
+	return planned, nil
+}
+
+// coveredByAny returns whether any of the passed in archives covers the passed in date
+func coveredByAny(archives []*Archive, d time.Time) bool {
+	for _, a := range archives {
+		if a.coversDate(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// plannedRollupRecords estimates the records of a monthly rolled up from its existing dailies and any we plan to build
+func plannedRollupRecords(ctx context.Context, db *sqlx.DB, org Org, archiveType ArchiveType, monthly *Archive, planned []*PlannedArchive) (int, error) {
+	// dates are inclusive, so end just before the next month as we do when building rollups
+	existing, err := GetDailyArchivesForDateRange(ctx, db, org, archiveType, monthly.StartDate, monthly.endDate().Add(time.Nanosecond*-1))
+	if err != nil {
+		return 0, err
+	}
+
+	records := 0
+	for _, daily := range existing {
+		records += daily.RecordCount
+	}
+	for _, p := range planned {
+		if p.Archive.Period == DayPeriod && monthly.coversDate(p.Archive.StartDate) {
+			records += p.Records
+		}
+	}
+	return records, nil
+}
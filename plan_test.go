@@ -0,0 +1,76 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanOrgArchives(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	missing, err := GetMissingDailyArchives(ctx, db, now, orgs[1], MessageType)
+	assert.NoError(t, err)
+
+	planned, err := PlanOrgArchives(ctx, now, config, db, orgs[1], MessageType)
+	assert.NoError(t, err)
+
+	// org 2 already has an archive so we plan its missing dailies, then roll up august and september
+	dailies, rollups := 0, 0
+	for _, p := range planned {
+		assert.True(t, p.Build)
+		assert.Equal(t, 0, p.Deletes)
+
+		if p.Rollup {
+			assert.Equal(t, MonthPeriod, p.Archive.Period)
+			rollups++
+		} else {
+			assert.Equal(t, DayPeriod, p.Archive.Period)
+			dailies++
+		}
+
+		if p.Archive.Period == DayPeriod && p.Archive.StartDate.Equal(time.Date(2017, 8, 12, 0, 0, 0, 0, time.UTC)) {
+			assert.Equal(t, 3, p.Records)
+		}
+		if p.Archive.Period == MonthPeriod && p.Archive.StartDate.Equal(time.Date(2017, 8, 1, 0, 0, 0, 0, time.UTC)) {
+			assert.True(t, p.Records >= 3)
+		}
+	}
+	assert.Equal(t, len(missing), dailies)
+	assert.Equal(t, 2, rollups)
+
+	// nothing was written
+	count, err := GetCurrentArchiveCount(ctx, db, orgs[1], MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// with deletion on, the records of the dailies we build would be deleted, as would those of our existing daily
+	config.Delete = true
+	planned, err = PlanOrgArchives(ctx, now, config, db, orgs[1], MessageType)
+	assert.NoError(t, err)
+	existing := 0
+	for _, p := range planned {
+		if !p.Build {
+			assert.Equal(t, time.Date(2017, 10, 8, 0, 0, 0, 0, time.UTC), p.Archive.StartDate)
+			existing++
+		} else if p.Rollup {
+			assert.Equal(t, 0, p.Deletes)
+		} else {
+			assert.Equal(t, p.Records, p.Deletes)
+		}
+	}
+	assert.Equal(t, 1, existing)
+
+	// org 1 is too new to have anything to build
+	planned, err = PlanOrgArchives(ctx, now, config, db, orgs[0], MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(planned))
+}
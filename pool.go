@@ -0,0 +1,62 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// OpenDB opens a connection pool to the database with the passed in connection string, in UTC as nothing works right
+// otherwise, sized from our config or with the passed in maximum number of open connections if we haven't been given
+// one. It doesn't check that it can connect. Records can be exported from it with COPY, see RegisterCopySource.
+func OpenDB(dsn string, config *Config, defaultMaxOpen int) (*sqlx.DB, error) {
+	db, err := sqlx.Open("postgres", UTCConnectionString(dsn))
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid db connection string")
+	}
+	ConfigurePool(db, config, defaultMaxOpen)
+	RegisterCopySource(db, UTCConnectionString(dsn))
+	return db, nil
+}
+
+// UTCConnectionString returns the passed in database connection string with its timezone set to UTC
+func UTCConnectionString(dsn string) string {
+	if strings.Contains(dsn, "?") {
+		return dsn + "&TimeZone=UTC"
+	}
+	return dsn + "?TimeZone=UTC"
+}
+
+// ConfigurePool sizes the connection pool of the passed in database from our config, using the passed in maximum
+// number of open connections if we haven't been given one
+func ConfigurePool(db *sqlx.DB, config *Config, defaultMaxOpen int) {
+	maxOpen := config.DBMaxOpenConns
+	if maxOpen == 0 {
+		maxOpen = defaultMaxOpen
+	}
+	db.SetMaxOpenConns(maxOpen)
+
+	if config.DBMaxIdleConns > 0 {
+		db.SetMaxIdleConns(config.DBMaxIdleConns)
+	}
+	db.SetConnMaxLifetime(time.Duration(config.DBConnMaxLifetimeMinutes) * time.Minute)
+}
+
+// setStatementTimeout sets the statement timeout for the rest of the passed in transaction, so that the database
+// cancels any of its queries which take longer, a timeout of zero leaves the database default
+func setStatementTimeout(ctx context.Context, tx *sqlx.Tx, timeout time.Duration) error {
+	if timeout <= 0 {
+		return nil
+	}
+
+	// SET doesn't take parameters, but our timeout is only ever a number of milliseconds
+	_, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout/time.Millisecond))
+	if err != nil {
+		return errors.Wrapf(err, "error setting statement timeout")
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigurePool(t *testing.T) {
+	db := setup(t)
+
+	config := NewConfig()
+	ConfigurePool(db, config, 13)
+	assert.Equal(t, 13, db.Stats().MaxOpenConnections)
+
+	config.DBMaxOpenConns = 7
+	config.DBMaxIdleConns = 3
+	config.DBConnMaxLifetimeMinutes = 30
+	ConfigurePool(db, config, 13)
+	assert.Equal(t, 7, db.Stats().MaxOpenConnections)
+
+	// too small a pool for our workers is a problem
+	config.OrgWorkers = 4
+	assert.Equal(t, 9, config.MinDBConns())
+	assert.Equal(t, 1, len(config.Validate()))
+
+	config.DBMaxOpenConns = 0
+	config.DeleteTimeoutSeconds = -1
+	assert.Equal(t, 1, len(config.Validate()))
+}
+
+func TestSetStatementTimeout(t *testing.T) {
+	ctx := context.Background()
+	db := setup(t)
+
+	tx, err := db.BeginTxx(ctx, nil)
+	assert.NoError(t, err)
+	defer tx.Rollback()
+
+	// a zero timeout leaves the database default
+	assert.NoError(t, setStatementTimeout(ctx, tx, 0))
+
+	assert.NoError(t, setStatementTimeout(ctx, tx, time.Millisecond*250))
+	timeout := ""
+	assert.NoError(t, tx.GetContext(ctx, &timeout, "SHOW statement_timeout"))
+	assert.Equal(t, "250ms", timeout)
+
+	_, err = tx.ExecContext(ctx, "SELECT pg_sleep(1)")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "statement timeout")
+}
+
+func TestOpenDB(t *testing.T) {
+	assert.Equal(t, "postgres://localhost/temba?TimeZone=UTC", UTCConnectionString("postgres://localhost/temba"))
+	assert.Equal(t, "postgres://localhost/temba?sslmode=disable&TimeZone=UTC", UTCConnectionString("postgres://localhost/temba?sslmode=disable"))
+
+	// opening doesn't connect, so this works without a database
+	config := NewConfig()
+	db, err := OpenDB("postgres://localhost/temba", config, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, db.Stats().MaxOpenConnections)
+	db.Close()
+}
@@ -0,0 +1,140 @@
+package archiver
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// statementCache is the statements prepared for a database, by their SQL
+type statementCache struct {
+	stmts map[string]*sqlx.Stmt
+}
+
+var preparedMutex sync.RWMutex
+var prepared = make(map[*sqlx.DB]*statementCache)
+
+// PrepareStatements prepares the queries we run for every archive, to export its records, count them and write it to
+// the database, so that they are parsed and planned once on each connection of the passed in database and reused by
+// every archive after that rather than for each. Queries are only prepared for the export mode of the passed in config.
+func PrepareStatements(ctx context.Context, db *sqlx.DB, config *Config) error {
+	insert, _, err := db.BindNamed(insertArchive, &Archive{})
+	if err != nil {
+		return errors.Wrapf(err, "error binding archive insert")
+	}
+
+	return prepareStatements(ctx, db, append(exportStatements(config), lockArchivePeriod, lookupArchiveForPeriod, insert))
+}
+
+// PrepareReplicaStatements prepares the queries we run against a read replica for every archive, which are those
+// that export and count its records, see PrepareStatements
+func PrepareReplicaStatements(ctx context.Context, replica *sqlx.DB, config *Config) error {
+	return prepareStatements(ctx, replica, exportStatements(config))
+}
+
+// exportStatements returns the queries run to export and count the records of each archive with the passed in config
+func exportStatements(config *Config) []string {
+	queries := []string{countArchivableMessages, countArchivableRuns, countDailyMessages, countDailyRuns}
+
+	exports := []string{lookupMsgs, lookupFlowRuns}
+	if config.exportsGoJSON() {
+		exports = []string{lookupMsgFields, lookupRunFields}
+	}
+	for _, query := range exports {
+		if config.ExportFetchSize > 0 {
+			queries = append(queries, declareCursorSQL(query))
+		} else {
+			queries = append(queries, query)
+		}
+	}
+	if config.ExportFetchSize > 0 {
+		queries = append(queries, fetchCursorSQL(config.ExportFetchSize), closeCursorSQL)
+	}
+	return queries
+}
+
+// prepareStatements prepares the passed in queries for the passed in database, replacing any it already had
+func prepareStatements(ctx context.Context, db *sqlx.DB, queries []string) error {
+	cache := &statementCache{stmts: make(map[string]*sqlx.Stmt, len(queries))}
+	for _, query := range queries {
+		stmt, err := db.PreparexContext(ctx, query)
+		if err != nil {
+			for _, s := range cache.stmts {
+				s.Close()
+			}
+			return errors.Wrapf(err, "error preparing statement: %s", query)
+		}
+		cache.stmts[query] = stmt
+	}
+
+	preparedMutex.Lock()
+	prepared[db] = cache
+	preparedMutex.Unlock()
+	return nil
+}
+
+// preparing returns a way of running queries against the passed in transaction, or the passed in database if it is
+// nil, which uses the statements prepared for the database, if there are any, see PrepareStatements
+func preparing(db *sqlx.DB, tx *sqlx.Tx) sqlx.ExtContext {
+	var ext sqlx.ExtContext = db
+	if tx != nil {
+		ext = tx
+	}
+
+	preparedMutex.RLock()
+	cache := prepared[db]
+	preparedMutex.RUnlock()
+
+	if cache == nil {
+		return ext
+	}
+	return &preparedExt{ExtContext: ext, tx: tx, cache: cache}
+}
+
+// preparedExt runs queries which have been prepared using their statements and any others as usual
+type preparedExt struct {
+	sqlx.ExtContext
+	tx    *sqlx.Tx
+	cache *statementCache
+}
+
+// stmt returns the prepared statement for the passed in query, within our transaction if we have one, nil if it
+// hasn't been prepared
+func (p *preparedExt) stmt(ctx context.Context, query string) *sqlx.Stmt {
+	stmt := p.cache.stmts[query]
+	if stmt != nil && p.tx != nil {
+		return p.tx.StmtxContext(ctx, stmt)
+	}
+	return stmt
+}
+
+func (p *preparedExt) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if stmt := p.stmt(ctx, query); stmt != nil {
+		return stmt.QueryContext(ctx, args...)
+	}
+	return p.ExtContext.QueryContext(ctx, query, args...)
+}
+
+func (p *preparedExt) QueryxContext(ctx context.Context, query string, args ...interface{}) (*sqlx.Rows, error) {
+	if stmt := p.stmt(ctx, query); stmt != nil {
+		return stmt.QueryxContext(ctx, args...)
+	}
+	return p.ExtContext.QueryxContext(ctx, query, args...)
+}
+
+func (p *preparedExt) QueryRowxContext(ctx context.Context, query string, args ...interface{}) *sqlx.Row {
+	if stmt := p.stmt(ctx, query); stmt != nil {
+		return stmt.QueryRowxContext(ctx, args...)
+	}
+	return p.ExtContext.QueryRowxContext(ctx, query, args...)
+}
+
+func (p *preparedExt) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if stmt := p.stmt(ctx, query); stmt != nil {
+		return stmt.ExecContext(ctx, args...)
+	}
+	return p.ExtContext.ExecContext(ctx, query, args...)
+}
@@ -0,0 +1,89 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareStatements(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	// without preparing we run queries as usual
+	_, isPrepared := preparing(db, nil).(*preparedExt)
+	assert.False(t, isPrepared)
+
+	err = PrepareStatements(ctx, db, config)
+	assert.NoError(t, err)
+	_, isPrepared = preparing(db, nil).(*preparedExt)
+	assert.True(t, isPrepared)
+
+	// with them prepared, we build and write the same archives, however many times we do so
+	for i := 0; i < 2; i++ {
+		tasks, err := GetMissingDailyArchives(ctx, db, now, orgs[1], MessageType)
+		assert.NoError(t, err)
+
+		task := tasks[2]
+		err = createArchiveFile(ctx, db, task, "/tmp", config, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, task.RecordCount)
+		assert.Equal(t, "6fe9265860425cf1f9757ba3d91b1a05", task.Hash)
+		assertArchiveFile(t, task, "messages1.jsonl")
+		DeleteArchiveFile(task)
+
+		err = precountArchives(ctx, db, orgs[1], tasks)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, *tasks[2].counted)
+
+		runs, err := GetMissingDailyArchives(ctx, db, now, orgs[1], RunType)
+		assert.NoError(t, err)
+
+		task = runs[2]
+		err = createArchiveFile(ctx, db, task, "/tmp", config, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, task.RecordCount)
+		assert.Equal(t, "f793f863f5e060b9d67c5688a555da6a", task.Hash)
+		DeleteArchiveFile(task)
+	}
+
+	// writing archives uses our prepared insert
+	tasks, err := GetMissingDailyArchives(ctx, db, now, orgs[1], MessageType)
+	assert.NoError(t, err)
+	for _, task := range tasks[:2] {
+		err = createArchiveFile(ctx, db, task, "/tmp", config, nil)
+		assert.NoError(t, err)
+		err = WriteArchiveToDB(ctx, db, task)
+		assert.NoError(t, err)
+		assert.NotEqual(t, 0, task.ID)
+		DeleteArchiveFile(task)
+	}
+	err = WriteArchiveToDB(ctx, db, tasks[0])
+	assert.Equal(t, ErrArchiveExists, err)
+
+	// queries we haven't prepared are run as usual
+	count := 0
+	err = sqlx.GetContext(ctx, preparing(db, nil), &count, `SELECT count(*) FROM archives_archive WHERE org_id = $1`, orgs[1].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	// a replica only prepares what is exported from it, with the config it is exported with
+	config.ExportFetchSize = 0
+	config.RecordJSON = RecordJSONGo
+	err = PrepareReplicaStatements(ctx, db, config)
+	assert.NoError(t, err)
+
+	task := tasks[2]
+	err = createArchiveFile(ctx, db, task, "/tmp", config, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "6fe9265860425cf1f9757ba3d91b1a05", task.Hash)
+	DeleteArchiveFile(task)
+}
@@ -0,0 +1,94 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// the orders we can archive orgs in, anything else is the name of a column of orgs_org to order by
+const (
+	OrgOrderID      = "id"
+	OrgOrderBacklog = "backlog"
+)
+
+var orgOrderFieldRegex = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// SortOrgs sorts the passed in orgs, which are sorted by id, into the order configured for archiving them. Ordering by
+// backlog puts the orgs which are furthest behind across the passed in types first, while ordering by a column of
+// orgs_org puts the orgs with the highest values first. Ties keep their order by id.
+func SortOrgs(ctx context.Context, db *sqlx.DB, config *Config, now time.Time, orgs []Org, archiveTypes []ArchiveType) error {
+	switch config.OrgOrder {
+	case "", OrgOrderID:
+		return nil
+	case OrgOrderBacklog:
+		return sortOrgsByBacklog(ctx, db, now, orgs, archiveTypes)
+	default:
+		return sortOrgsByField(ctx, db, config.OrgOrder, orgs)
+	}
+}
+
+// sortOrgsByBacklog sorts the passed in orgs by the total number of days they are behind across the passed in types
+func sortOrgsByBacklog(ctx context.Context, db *sqlx.DB, now time.Time, orgs []Org, archiveTypes []ArchiveType) error {
+	backlogs := make(map[int]int, len(orgs))
+	for _, org := range orgs {
+		for _, archiveType := range archiveTypes {
+			lag, err := GetOrgArchiveLag(ctx, db, now, org, archiveType)
+			if err != nil {
+				return err
+			}
+			backlogs[org.ID] += lag.Days
+		}
+	}
+
+	sort.SliceStable(orgs, func(i, j int) bool { return backlogs[orgs[i].ID] > backlogs[orgs[j].ID] })
+	return nil
+}
+
+const lookupOrgsByField = `
+SELECT id
+FROM orgs_org
+WHERE id = ANY($1)
+ORDER BY %s DESC NULLS LAST, id
+`
+
+// sortOrgsByField sorts the passed in orgs by the passed in column of orgs_org, highest first
+func sortOrgsByField(ctx context.Context, db *sqlx.DB, field string, orgs []Org) error {
+	if !orgOrderFieldRegex.MatchString(field) {
+		return errors.Errorf("invalid org order: %s", field)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	orgIDs := make([]int64, len(orgs))
+	for i, org := range orgs {
+		orgIDs[i] = int64(org.ID)
+	}
+
+	ordered := make([]int, 0, len(orgs))
+	err := db.SelectContext(ctx, &ordered, fmt.Sprintf(lookupOrgsByField, field), pq.Array(orgIDs))
+	if err != nil {
+		return errors.Wrapf(err, "error ordering orgs by: %s", field)
+	}
+
+	positions := make(map[int]int, len(ordered))
+	for i, id := range ordered {
+		positions[id] = i
+	}
+	position := func(org Org) int {
+		p, found := positions[org.ID]
+		if !found {
+			return len(positions)
+		}
+		return p
+	}
+	sort.SliceStable(orgs, func(i, j int) bool { return position(orgs[i]) < position(orgs[j]) })
+	return nil
+}
@@ -0,0 +1,49 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortOrgs(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+	archiveTypes := []ArchiveType{MessageType, RunType}
+
+	config := NewConfig()
+	orgIDs := func(orgs []Org) []int {
+		ids := make([]int, len(orgs))
+		for i, org := range orgs {
+			ids[i] = org.ID
+		}
+		return ids
+	}
+
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+
+	// by id leaves them as they are
+	assert.NoError(t, SortOrgs(ctx, db, config, now, orgs, archiveTypes))
+	assert.Equal(t, []int{1, 2, 3}, orgIDs(orgs))
+
+	// org 3 is 72 days behind, org 2 64 and org 1, created after our newest possible archive, isn't behind at all
+	config.OrgOrder = OrgOrderBacklog
+	assert.NoError(t, SortOrgs(ctx, db, config, now, orgs, archiveTypes))
+	assert.Equal(t, []int{3, 2, 1}, orgIDs(orgs))
+
+	// only org 3 is anonymous, the rest keep their order by id
+	orgs, err = GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+	config.OrgOrder = "is_anon"
+	assert.NoError(t, SortOrgs(ctx, db, config, now, orgs, archiveTypes))
+	assert.Equal(t, []int{3, 1, 2}, orgIDs(orgs))
+
+	// we don't order by anything which isn't a column
+	config.OrgOrder = "id; DROP TABLE orgs_org"
+	assert.EqualError(t, SortOrgs(ctx, db, config, now, orgs, archiveTypes), "invalid org order: id; DROP TABLE orgs_org")
+	assert.Equal(t, []int{3, 1, 2}, orgIDs(orgs))
+}
@@ -0,0 +1,66 @@
+package archiver
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// progressInterval is how often we log the progress of long running tasks
+var progressInterval = time.Minute
+
+// progressLogger periodically logs the progress of a long running task over a known number of records, such as
+// writing or deleting the records of an archive, so that a slow task can be told apart from a hung one
+type progressLogger struct {
+	log   *logrus.Entry
+	task  string
+	total int
+
+	start   time.Time
+	lastLog time.Time
+	records int
+	bytes   int64
+}
+
+// newProgressLogger creates a new progress logger for the passed in task over the passed in total number of records
+func newProgressLogger(log *logrus.Entry, task string, total int) *progressLogger {
+	now := time.Now()
+	return &progressLogger{log: log, task: task, total: total, start: now, lastLog: now}
+}
+
+// add notes that the passed in number of records and bytes have been processed, logging our progress if it has been
+// long enough since we last did
+func (p *progressLogger) add(records int, bytes int64) {
+	p.records += records
+	p.bytes += bytes
+
+	now := time.Now()
+	if now.Sub(p.lastLog) < progressInterval {
+		return
+	}
+	p.lastLog = now
+
+	p.log.WithFields(p.fields(now)).Info(p.task)
+}
+
+// fields returns the fields describing our progress as of the passed in time
+func (p *progressLogger) fields(now time.Time) logrus.Fields {
+	elapsed := now.Sub(p.start)
+	rate := float64(p.records) / elapsed.Seconds()
+
+	fields := logrus.Fields{
+		"records":            p.records,
+		"total_count":        p.total,
+		"elapsed":            elapsed,
+		"records_per_second": rate,
+	}
+	if p.bytes > 0 {
+		fields["bytes"] = p.bytes
+	}
+
+	// we can only estimate how long is left if we've made some progress and know how far we have to go
+	if rate > 0 && p.total > p.records {
+		fields["eta"] = time.Duration(float64(p.total-p.records) / rate * float64(time.Second))
+	}
+	return fields
+}
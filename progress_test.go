@@ -0,0 +1,51 @@
+package archiver
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressLogger(t *testing.T) {
+	output := &bytes.Buffer{}
+	logger := logrus.New()
+	logger.Out = output
+	log := logrus.NewEntry(logger)
+
+	progress := newProgressLogger(log, "writing archive file", 100)
+
+	// nothing logged until our interval has passed
+	progress.add(10, 1000)
+	assert.Equal(t, "", output.String())
+
+	// pretend we started 10 seconds ago
+	progress.start = progress.start.Add(-10 * time.Second)
+	fields := progress.fields(progress.start.Add(10 * time.Second))
+	assert.Equal(t, 10, fields["records"])
+	assert.Equal(t, 100, fields["total_count"])
+	assert.Equal(t, int64(1000), fields["bytes"])
+	assert.Equal(t, 1.0, fields["records_per_second"])
+	assert.Equal(t, 90*time.Second, fields["eta"])
+
+	defer func(interval time.Duration) { progressInterval = interval }(progressInterval)
+	progressInterval = 0
+
+	progress.add(10, 1000)
+	assert.Contains(t, output.String(), "writing archive file")
+	assert.Contains(t, output.String(), "records=20")
+
+	// no ETA once we're done, or if we don't know how many records there are
+	progress.add(80, 0)
+	_, found := progress.fields(time.Now())["eta"]
+	assert.False(t, found)
+
+	progress = newProgressLogger(log, "deleting runs", 0)
+	progress.add(10, 0)
+	_, found = progress.fields(time.Now())["eta"]
+	assert.False(t, found)
+	_, found = progress.fields(time.Now())["bytes"]
+	assert.False(t, found)
+}
@@ -0,0 +1,215 @@
+package archiver
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const lookupArchivesNeedingPurge = `
+SELECT a.id, a.org_id, a.start_date::timestamp with time zone as start_date, a.period, a.archive_type, a.hash, a.size, a.record_count, a.url, a.rollup_id, a.needs_deletion
+FROM archives_archive a
+LEFT JOIN archives_archive r ON r.id = a.rollup_id
+WHERE a.org_id = $1 AND a.archive_type = $2 AND a.purged_on IS NULL AND a.url != '' AND (a.needs_deletion = FALSE OR $5) AND (
+	(a.period = 'D' AND $3::timestamp with time zone IS NOT NULL AND r.created_on < $3) OR
+	(a.period = 'M' AND $4::timestamp with time zone IS NOT NULL AND a.start_date + '1 month'::interval < $4)
+)
+ORDER BY a.start_date asc, a.period desc
+`
+
+// GetArchivesNeedingPurge returns the archives for the passed in org and type whose S3 objects have outlived our storage
+// retention. Daily archives are purged, if enabled, as soon as they are rolled up or a number of days after, monthlies a
+// number of days after the end of their period. Archives whose records are still waiting to be deleted are only purged if the org's retention
+// rule doesn't delete records at all.
+func GetArchivesNeedingPurge(ctx context.Context, now time.Time, config *Config, db *sqlx.DB, org Org, archiveType ArchiveType) ([]*Archive, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	var dailiesBefore, monthliesBefore *time.Time
+	if config.PurgeDailiesOnRollup {
+		dailiesBefore = &now
+	} else if config.PurgeDailiesAfter > 0 {
+		d := now.AddDate(0, 0, -config.PurgeDailiesAfter)
+		dailiesBefore = &d
+	}
+	if config.PurgeMonthliesAfter > 0 {
+		m := now.AddDate(0, 0, -config.PurgeMonthliesAfter)
+		monthliesBefore = &m
+	}
+
+	ignoreDeletion := !RetentionRuleFor(config, org, archiveType).Delete
+
+	archives := make([]*Archive, 0, 1)
+	err := db.SelectContext(ctx, &archives, lookupArchivesNeedingPurge, org.ID, archiveType, dailiesBefore, monthliesBefore, ignoreDeletion)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error selecting archives needing purge for org: %d and type: %s", org.ID, archiveType)
+	}
+
+	for _, a := range archives {
+		a.Org = org
+	}
+	return archives, nil
+}
+
+const setArchivePurged = `
+UPDATE archives_archive
+SET purged_on = $2
+WHERE id = $1
+`
+
+// PurgeArchive deletes the S3 object for the passed in archive and marks it as purged, the archive row itself is kept
+// so that we don't try to rebuild it
+func PurgeArchive(ctx context.Context, db *sqlx.DB, s3Client s3iface.S3API, archive *Archive) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+
+	err := DeleteS3File(ctx, s3Client, archive.URL)
+	if err != nil {
+		return errors.Wrapf(err, "error deleting S3 object: %s", archive.URL)
+	}
+
+	purgedOn := time.Now()
+	_, err = db.ExecContext(ctx, setArchivePurged, archive.ID, purgedOn)
+	if err != nil {
+		return errors.Wrapf(err, "error marking archive %d as purged", archive.ID)
+	}
+	archive.PurgedOn = &purgedOn
+
+	return nil
+}
+
+// verifyRollup checks that the monthly archive with the passed in id is still present on S3 and matches its hash, so
+// that it is safe to purge the dailies rolled up into it
+func verifyRollup(ctx context.Context, db *sqlx.DB, s3Client s3iface.S3API, rollupID int) error {
+	rollup, err := GetArchive(ctx, db, rollupID)
+	if err != nil {
+		return err
+	}
+	if rollup == nil {
+		return errors.Errorf("rollup archive %d does not exist", rollupID)
+	}
+	if rollup.PurgedOn != nil {
+		return errors.Errorf("rollup archive %d has been purged", rollupID)
+	}
+
+	etag, err := GetS3FileETAG(ctx, s3Client, rollup.URL)
+	if err != nil {
+		return errors.Wrapf(err, "error checking rollup archive %d on S3", rollupID)
+	}
+	if etag != rollup.Hash {
+		return errors.Errorf("rollup archive %d md5: %s and s3 etag: %s do not match", rollupID, rollup.Hash, etag)
+	}
+	return nil
+}
+
+// PurgeOrgArchives purges all the archives for the passed in org and type which have outlived our storage retention,
+// daily archives are only purged once the monthly archive they were rolled up into has been verified
+func PurgeOrgArchives(ctx context.Context, now time.Time, config *Config, db *sqlx.DB, s3Client s3iface.S3API, org Org, archiveType ArchiveType) ([]*Archive, error) {
+	archives, err := GetArchivesNeedingPurge(ctx, now, config, db, org, archiveType)
+	if err != nil {
+		return nil, err
+	}
+
+	holds, err := GetActiveLegalHolds(ctx, db, org, archiveType)
+	if err != nil {
+		return nil, err
+	}
+
+	// the result of verifying each rollup, so we only check each once
+	rollups := make(map[int]error)
+
+	purged := make([]*Archive, 0, len(archives))
+	for _, a := range archives {
+		log := logrus.WithFields(logrus.Fields{
+			"archive_id":   a.ID,
+			"org_id":       a.OrgID,
+			"archive_type": a.ArchiveType,
+			"start_date":   a.StartDate,
+			"period":       a.Period,
+			"url":          a.URL,
+		})
+
+		// archives under a legal hold are kept regardless of our storage retention
+		if hold := heldBy(holds, a); hold != nil {
+			log.WithField("hold_id", hold.ID).Info("archive under legal hold, not purging")
+			continue
+		}
+
+		if a.Period == DayPeriod && a.Rollup != nil {
+			verifyErr, checked := rollups[*a.Rollup]
+			if !checked {
+				verifyErr = verifyRollup(ctx, db, s3Client, *a.Rollup)
+				rollups[*a.Rollup] = verifyErr
+			}
+			if verifyErr != nil {
+				log.WithError(verifyErr).Error("unable to verify rollup, not purging daily archive")
+				continue
+			}
+		}
+
+		err := PurgeArchive(ctx, db, s3Client, a)
+		if err != nil {
+			log.WithError(err).Error("error purging archive")
+			continue
+		}
+
+		purged = append(purged, a)
+		log.Debug("purged archive")
+	}
+
+	if len(purged) > 0 {
+		logrus.WithFields(logrus.Fields{
+			"org_id":       org.ID,
+			"archive_type": archiveType,
+			"count":        len(purged),
+		}).Info("completed purging archives")
+	}
+
+	return purged, nil
+}
+
+// ReportOrgPurges logs the archives for the passed in org and type which PurgeOrgArchives would purge, without deleting
+// their S3 objects, for dry runs. Returns the archives which would be purged.
+func ReportOrgPurges(ctx context.Context, now time.Time, config *Config, db *sqlx.DB, org Org, archiveType ArchiveType) ([]*Archive, error) {
+	archives, err := GetArchivesNeedingPurge(ctx, now, config, db, org, archiveType)
+	if err != nil {
+		return nil, err
+	}
+
+	holds, err := GetActiveLegalHolds(ctx, db, org, archiveType)
+	if err != nil {
+		return nil, err
+	}
+
+	reported := make([]*Archive, 0, len(archives))
+	for _, a := range archives {
+		if heldBy(holds, a) != nil {
+			continue
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"archive_id":   a.ID,
+			"org_id":       a.OrgID,
+			"archive_type": a.ArchiveType,
+			"start_date":   a.StartDate,
+			"period":       a.Period,
+			"url":          a.URL,
+		}).Info("dry run, would purge archive")
+
+		reported = append(reported, a)
+	}
+
+	if len(reported) > 0 {
+		logrus.WithFields(logrus.Fields{
+			"org_id":       org.ID,
+			"archive_type": archiveType,
+			"count":        len(reported),
+		}).Info("dry run, completed purge report for org")
+	}
+
+	return reported, nil
+}
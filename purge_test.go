@@ -0,0 +1,118 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetArchivesNeedingPurge(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+	config := NewConfig()
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	// roll our september daily up into our september monthly
+	db.MustExec(`UPDATE archives_archive SET url = 'https://s3.amazonaws.com/bucket/' || id, rollup_id = 3 WHERE id = 2`)
+	db.MustExec(`UPDATE archives_archive SET url = 'https://s3.amazonaws.com/bucket/' || id WHERE id = 3`)
+
+	org, err := GetOrg(ctx, db, config, 3)
+	assert.NoError(t, err)
+
+	// nothing purged by default
+	archives, err := GetArchivesNeedingPurge(ctx, now, config, db, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(archives))
+
+	// our daily was rolled up long enough ago to purge
+	config.PurgeDailiesAfter = 30
+	archives, err = GetArchivesNeedingPurge(ctx, now, config, db, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(archives))
+	assert.Equal(t, 2, archives[0].ID)
+
+	// but not if we are deleting records and it still needs deletion
+	config.Delete = true
+	archives, err = GetArchivesNeedingPurge(ctx, now, config, db, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(archives))
+
+	db.MustExec(`UPDATE archives_archive SET needs_deletion = FALSE WHERE id IN (2, 3)`)
+	config.PurgeMonthliesAfter = 30
+	archives, err = GetArchivesNeedingPurge(ctx, now, config, db, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(archives))
+	assert.Equal(t, 3, archives[0].ID)
+	assert.Equal(t, 2, archives[1].ID)
+
+	// purged archives aren't purged again
+	db.MustExec(`UPDATE archives_archive SET purged_on = NOW() WHERE id = 2`)
+	archives, err = GetArchivesNeedingPurge(ctx, now, config, db, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(archives))
+	assert.Equal(t, 3, archives[0].ID)
+
+	// dailies aren't purged without a delay
+	db.MustExec(`UPDATE archives_archive SET purged_on = NULL, needs_deletion = FALSE WHERE id = 2`)
+	db.MustExec(`UPDATE archives_archive SET created_on = $1 WHERE id = 3`, now.Add(-time.Hour))
+	config.PurgeDailiesAfter = 0
+	config.PurgeMonthliesAfter = 0
+	archives, err = GetArchivesNeedingPurge(ctx, now, config, db, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(archives))
+
+	// unless they are purged as soon as they are rolled up
+	config.PurgeDailiesOnRollup = true
+	archives, err = GetArchivesNeedingPurge(ctx, now, config, db, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(archives))
+	assert.Equal(t, 2, archives[0].ID)
+
+	config.PurgeDailiesAfter = 30
+	assert.Contains(t, config.Validate()[0].Error(), "cannot purge daily archives both on rollup and a number of days after it")
+	config.PurgeDailiesAfter = 0
+
+	// too young to purge
+	db.MustExec(`UPDATE archives_archive SET purged_on = NOW() WHERE id = 2`)
+	config.PurgeMonthliesAfter = 365
+	archives, err = GetArchivesNeedingPurge(ctx, now, config, db, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(archives))
+}
+
+func TestReportOrgPurges(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+	config := NewConfig()
+	config.DeleteDryRun = true
+	config.PurgeDailiesAfter = 30
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	db.MustExec(`UPDATE archives_archive SET url = 'https://s3.amazonaws.com/bucket/' || id, rollup_id = 3, needs_deletion = FALSE WHERE id = 2`)
+	db.MustExec(`UPDATE archives_archive SET url = 'https://s3.amazonaws.com/bucket/' || id, needs_deletion = FALSE WHERE id = 3`)
+
+	org, err := GetOrg(ctx, db, config, 3)
+	assert.NoError(t, err)
+
+	// our daily would be purged, but is only reported
+	reported, err := ReportOrgPurges(ctx, now, config, db, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(reported))
+	assert.Equal(t, 2, reported[0].ID)
+
+	archive, err := GetArchive(ctx, db, 2)
+	assert.NoError(t, err)
+	assert.Nil(t, archive.PurgedOn)
+
+	// and a dry run of the whole org purges nothing either, without needing S3
+	config.Periods = "day"
+	config.UploadToS3 = false
+	_, _, err = ArchiveOrg(ctx, now, config, db, nil, org, MessageType, &Options{Store: &testArchiveStore{}})
+	assert.NoError(t, err)
+
+	archive, err = GetArchive(ctx, db, 2)
+	assert.NoError(t, err)
+	assert.Nil(t, archive.PurgedOn)
+}
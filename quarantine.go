@@ -0,0 +1,238 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// When quarantine is enabled, records are copied as JSON into the archiver_quarantine table in the same transaction
+// that deletes them, and only removed from there once our quarantine period has passed. This gives operators a window
+// in which the records for an archive can be restored if the archive turns out to be bad. Messages are quarantined
+// along with their labels and channel logs. The recent run entries of runs are not, as RapidPro only uses them to show
+// the most recent runs through a flow, so they are lost when runs are deleted even if the runs are restored.
+
+const quarantineMessages = `
+INSERT INTO archiver_quarantine(org_id, archive_id, table_name, record_id, record, quarantined_on)
+SELECT mm.org_id, ?::integer, 'msgs_msg', mm.id, to_jsonb(mm), NOW()
+FROM msgs_msg mm
+WHERE mm.id IN(?)
+`
+
+const quarantineMessageLabels = `
+INSERT INTO archiver_quarantine(org_id, archive_id, table_name, record_id, record, quarantined_on)
+SELECT mm.org_id, ?::integer, 'msgs_msg_labels', ml.id, to_jsonb(ml), NOW()
+FROM msgs_msg_labels ml
+JOIN msgs_msg mm ON mm.id = ml.msg_id
+WHERE ml.msg_id IN(?)
+`
+
+const quarantineMessageLogs = `
+INSERT INTO archiver_quarantine(org_id, archive_id, table_name, record_id, record, quarantined_on)
+SELECT mm.org_id, ?::integer, 'channels_channellog', cl.id, to_jsonb(cl), NOW()
+FROM channels_channellog cl
+JOIN msgs_msg mm ON mm.id = cl.msg_id
+WHERE cl.msg_id IN(?)
+`
+
+const quarantineRuns = `
+INSERT INTO archiver_quarantine(org_id, archive_id, table_name, record_id, record, quarantined_on)
+SELECT fr.org_id, ?::integer, 'flows_flowrun', fr.id, to_jsonb(fr), NOW()
+FROM flows_flowrun fr
+WHERE fr.id IN(?)
+`
+
+// quarantinedTables are the tables we quarantine records from, in the order they must be restored, along with an
+// expression for the fields which are cleared on restore as they may reference records which no longer exist, such as
+// the broadcast of a message which is deleted once all of its messages are
+var quarantinedTables = []struct {
+	table   string
+	cleared string
+}{
+	{"msgs_msg", `'{"response_to_id": null}'::jsonb || CASE WHEN EXISTS (SELECT 1 FROM msgs_broadcast WHERE id = (q.record->>'broadcast_id')::integer) THEN '{}'::jsonb ELSE '{"broadcast_id": null}'::jsonb END`},
+	{"msgs_msg_labels", `'{}'::jsonb`},
+	{"channels_channellog", `'{}'::jsonb`},
+	{"flows_flowrun", `'{"parent_id": null}'::jsonb`},
+}
+
+// QuarantineStatus describes the records quarantined for an archive from a single table
+type QuarantineStatus struct {
+	ArchiveID     int       `db:"archive_id"`
+	OrgID         int       `db:"org_id"`
+	Table         string    `db:"table_name"`
+	Count         int       `db:"record_count"`
+	QuarantinedOn time.Time `db:"quarantined_on"`
+}
+
+const lookupQuarantineStatus = `
+SELECT archive_id, org_id, table_name, count(*) as record_count, min(quarantined_on) as quarantined_on
+FROM archiver_quarantine
+WHERE ($1 = 0 OR org_id = $1)
+GROUP BY archive_id, org_id, table_name
+ORDER BY archive_id, table_name
+`
+
+// GetQuarantineStatus returns what is currently quarantined for the passed in org, or all orgs if zero
+func GetQuarantineStatus(ctx context.Context, db *sqlx.DB, orgID int) ([]*QuarantineStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+
+	statuses := make([]*QuarantineStatus, 0)
+	err := db.SelectContext(ctx, &statuses, lookupQuarantineStatus, orgID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up quarantined records")
+	}
+	return statuses, nil
+}
+
+const restoreQuarantinedRecords = `
+INSERT INTO %s
+SELECT r.*
+FROM archiver_quarantine q, jsonb_populate_record(NULL::%s, q.record || %s) r
+WHERE q.archive_id = $1 AND q.table_name = '%s'
+ORDER BY q.record_id
+ON CONFLICT DO NOTHING
+`
+
+const deleteQuarantinedArchive = `
+DELETE FROM archiver_quarantine
+WHERE archive_id = $1
+`
+
+const setArchiveNeedsDeletion = `
+UPDATE archives_archive
+SET needs_deletion = TRUE, deleted_on = NULL, deletion_last_id = NULL
+WHERE id = $1
+`
+
+// RestoreQuarantinedRecords puts the quarantined records for the passed in archive back into their tables and marks the
+// archive as needing deletion again, returning the number of records restored. Records which already exist again, ie:
+// because they were restored from the archive itself, are skipped. Links between records which may have since been
+// deleted, such as message responses, broadcasts and parent runs, are not restored.
+func RestoreQuarantinedRecords(ctx context.Context, db *sqlx.DB, archiveID int) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Hour)
+	defer cancel()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	restored := 0
+	for _, t := range quarantinedTables {
+		result, err := tx.ExecContext(ctx, fmt.Sprintf(restoreQuarantinedRecords, t.table, t.table, t.cleared, t.table), archiveID)
+		if err != nil {
+			tx.Rollback()
+			return 0, errors.Wrapf(err, "error restoring quarantined records to %s for archive: %d", t.table, archiveID)
+		}
+		count, _ := result.RowsAffected()
+		restored += int(count)
+	}
+
+	_, err = tx.ExecContext(ctx, deleteQuarantinedArchive, archiveID)
+	if err != nil {
+		tx.Rollback()
+		return 0, errors.Wrapf(err, "error removing quarantined records for archive: %d", archiveID)
+	}
+
+	_, err = tx.ExecContext(ctx, setArchiveNeedsDeletion, archiveID)
+	if err != nil {
+		tx.Rollback()
+		return 0, errors.Wrapf(err, "error marking archive %d as needing deletion", archiveID)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, errors.Wrapf(err, "error committing restore of archive: %d", archiveID)
+	}
+
+	return restored, nil
+}
+
+const selectExpiredQuarantineArchives = `
+SELECT DISTINCT q.archive_id
+FROM archiver_quarantine q
+JOIN archives_archive a ON a.id = q.archive_id
+WHERE q.org_id = $1 AND a.archive_type = $2 AND q.quarantined_on < $3
+ORDER BY q.archive_id
+`
+
+const deleteExpiredQuarantineBatch = `
+DELETE FROM archiver_quarantine
+WHERE id IN (
+	SELECT id FROM archiver_quarantine WHERE archive_id = ANY($1) AND quarantined_on < $2 LIMIT 10000
+)
+`
+
+// ExpireQuarantinedRecords permanently removes the quarantined records for the passed in org and type which have been
+// quarantined for longer than our quarantine period, unless they are covered by a legal hold. Returns the number of
+// records removed. Nothing is removed if quarantine is disabled, as every record would otherwise have expired, or on
+// a delete dry run.
+func ExpireQuarantinedRecords(ctx context.Context, now time.Time, config *Config, db *sqlx.DB, org Org, archiveType ArchiveType) (int, error) {
+	if config.DeleteQuarantineDays <= 0 || config.DeleteDryRun {
+		return 0, nil
+	}
+
+	start := time.Now()
+	expiredBefore := now.AddDate(0, 0, -config.DeleteQuarantineDays)
+
+	archiveIDs := make([]int64, 0)
+	err := db.SelectContext(ctx, &archiveIDs, selectExpiredQuarantineArchives, org.ID, archiveType, expiredBefore)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error selecting expired quarantined archives for org: %d", org.ID)
+	}
+	if len(archiveIDs) == 0 {
+		return 0, nil
+	}
+
+	holds, err := GetActiveLegalHolds(ctx, db, org, archiveType)
+	if err != nil {
+		return 0, err
+	}
+
+	expiredIDs := make([]int64, 0, len(archiveIDs))
+	for _, id := range archiveIDs {
+		archive, err := GetArchive(ctx, db, int(id))
+		if err != nil {
+			return 0, err
+		}
+		if archive != nil {
+			if hold := heldBy(holds, archive); hold != nil {
+				logrus.WithField("archive_id", id).WithField("hold_id", hold.ID).Info("archive under legal hold, keeping quarantined records")
+				continue
+			}
+		}
+		expiredIDs = append(expiredIDs, id)
+	}
+
+	// delete in batches so we never hold locks on the quarantine table for too long
+	expired := 0
+	for {
+		result, err := db.ExecContext(ctx, deleteExpiredQuarantineBatch, pq.Array(expiredIDs), expiredBefore)
+		if err != nil {
+			return expired, errors.Wrapf(err, "error removing expired quarantined records for org: %d", org.ID)
+		}
+		count, _ := result.RowsAffected()
+		if count == 0 {
+			break
+		}
+		expired += int(count)
+	}
+
+	if expired > 0 {
+		logrus.WithFields(logrus.Fields{
+			"org_id":       org.ID,
+			"archive_type": archiveType,
+			"archives":     len(expiredIDs),
+			"count":        expired,
+			"elapsed":      time.Since(start),
+		}).Info("removed expired quarantined records")
+	}
+
+	return expired, nil
+}
@@ -0,0 +1,116 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuarantine(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+	config := NewConfig()
+	config.DeleteQuarantineDays = 30
+	now := time.Now()
+
+	org, err := GetOrg(ctx, db, config, 2)
+	assert.NoError(t, err)
+
+	// quarantine and delete the message for our daily archive like deletion does
+	tx, err := db.BeginTxx(ctx, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, executeInQuery(ctx, tx, quarantineMessages, 4, []int64{6}))
+	assert.NoError(t, executeInQuery(ctx, tx, quarantineMessageLabels, 4, []int64{6}))
+	assert.NoError(t, executeInQuery(ctx, tx, quarantineMessageLogs, 4, []int64{6}))
+	assert.NoError(t, executeInQuery(ctx, tx, deleteMessageLogs, []int64{6}))
+	assert.NoError(t, executeInQuery(ctx, tx, deleteMessageLabels, []int64{6}))
+	assert.NoError(t, executeInQuery(ctx, tx, deleteMessages, []int64{6}))
+	assert.NoError(t, tx.Commit())
+	db.MustExec(`UPDATE archives_archive SET needs_deletion = FALSE, deleted_on = NOW() WHERE id = 4`)
+
+	assertCount(t, db, 0, `SELECT count(*) FROM msgs_msg WHERE id = 6`)
+	assertCount(t, db, 0, `SELECT count(*) FROM channels_channellog WHERE msg_id = 6`)
+
+	statuses, err := GetQuarantineStatus(ctx, db, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(statuses))
+	assert.Equal(t, 4, statuses[0].ArchiveID)
+	assert.Equal(t, "channels_channellog", statuses[0].Table)
+	assert.Equal(t, 1, statuses[0].Count)
+	assert.Equal(t, 4, statuses[1].ArchiveID)
+	assert.Equal(t, "msgs_msg", statuses[1].Table)
+	assert.Equal(t, 1, statuses[1].Count)
+
+	// not expired yet
+	expired, err := ExpireQuarantinedRecords(ctx, now, config, db, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, expired)
+
+	// its broadcast is deleted now it has no messages
+	db.MustExec(`DELETE FROM msgs_broadcast WHERE id = 2`)
+
+	// restore puts our message and its channel log back, without its broadcast, and marks the archive as needing
+	// deletion again
+	restored, err := RestoreQuarantinedRecords(ctx, db, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, restored)
+	assertCount(t, db, 1, `SELECT count(*) FROM msgs_msg WHERE id = 6 AND delete_reason IS NULL AND broadcast_id IS NULL`)
+	assertCount(t, db, 1, `SELECT count(*) FROM channels_channellog WHERE id = 6 AND msg_id = 6`)
+	assertCount(t, db, 1, `SELECT count(*) FROM archives_archive WHERE id = 4 AND needs_deletion = TRUE AND deleted_on IS NULL`)
+	assertCount(t, db, 0, `SELECT count(*) FROM archiver_quarantine`)
+
+	// restoring records which already exist skips them
+	tx, err = db.BeginTxx(ctx, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, executeInQuery(ctx, tx, quarantineMessages, 4, []int64{6}))
+	assert.NoError(t, tx.Commit())
+
+	restored, err = RestoreQuarantinedRecords(ctx, db, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, restored)
+	assertCount(t, db, 0, `SELECT count(*) FROM archiver_quarantine`)
+
+	// quarantine it again, this time long enough ago to have expired
+	tx, err = db.BeginTxx(ctx, nil)
+	assert.NoError(t, err)
+	assert.NoError(t, executeInQuery(ctx, tx, quarantineMessages, 4, []int64{6}))
+	assert.NoError(t, tx.Commit())
+	db.MustExec(`UPDATE archiver_quarantine SET quarantined_on = $1`, now.AddDate(0, 0, -31))
+
+	// but not while the org is under legal hold
+	hold := &LegalHold{OrgID: 2, Reason: "litigation"}
+	assert.NoError(t, CreateLegalHold(ctx, db, hold))
+
+	expired, err = ExpireQuarantinedRecords(ctx, now, config, db, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, expired)
+
+	assert.NoError(t, ReleaseLegalHold(ctx, db, hold.ID))
+
+	// runs for this org aren't touched
+	expired, err = ExpireQuarantinedRecords(ctx, now, config, db, org, RunType)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, expired)
+
+	// or if quarantine has since been disabled
+	config.DeleteQuarantineDays = 0
+	expired, err = ExpireQuarantinedRecords(ctx, now, config, db, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, expired)
+
+	// or on a dry run
+	config.DeleteQuarantineDays = 30
+	config.DeleteDryRun = true
+	expired, err = ExpireQuarantinedRecords(ctx, now, config, db, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, expired)
+	assertCount(t, db, 1, `SELECT count(*) FROM archiver_quarantine`)
+
+	config.DeleteDryRun = false
+	expired, err = ExpireQuarantinedRecords(ctx, now, config, db, org, MessageType)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, expired)
+	assertCount(t, db, 0, `SELECT count(*) FROM archiver_quarantine`)
+}
@@ -0,0 +1,222 @@
+package archiver
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// OrgTask is a task to archive a single org, queued in Redis so that it can be taken by any instance
+type OrgTask struct {
+	OrgID    int       `json:"org_id"`
+	Attempts int       `json:"attempts"`
+	QueuedOn time.Time `json:"queued_on"`
+
+	// the JSON this task was queued as, which is how we find it again in our lists
+	raw string
+}
+
+// QueueDepth is how many tasks are in each of the lists of our queue
+type QueueDepth struct {
+	Queued int
+	Taken  int
+	Failed int
+}
+
+// TaskQueue is a queue of org tasks in Redis shared by all instances. Tasks move from the queued list to the taken
+// list while a worker archives them and are then either removed, requeued to be retried or moved to the failed list.
+// When each task was taken, or last renewed by the worker archiving it, is kept alongside so that the tasks of workers
+// which died can be queued again.
+type TaskQueue struct {
+	redis *Redis
+	name  string
+}
+
+// NewTaskQueue creates a new task queue with the passed in name
+func NewTaskQueue(redis *Redis, name string) *TaskQueue {
+	return &TaskQueue{redis: redis, name: name}
+}
+
+func (q *TaskQueue) queuedKey() string  { return q.name + ":queued" }
+func (q *TaskQueue) takenKey() string   { return q.name + ":taken" }
+func (q *TaskQueue) failedKey() string  { return q.name + ":failed" }
+func (q *TaskQueue) renewedKey() string { return q.name + ":renewed" }
+
+func (q *TaskQueue) runKey(runKey string) string { return q.name + ":run:" + runKey }
+
+// StartRun queues a task for each of the passed in orgs, unless a run with the passed in key was already started by
+// another instance, in which case there is nothing to queue and we should just take tasks. The key should identify
+// the run across instances, such as when it was scheduled to start, and forcing starts the run even if one with the
+// same key was started. Anything left in the queue from a previous run, such as tasks taken by an instance that died,
+// is cleared first. Returns whether we queued.
+func (q *TaskQueue) StartRun(runKey string, orgs []Org, force bool) (bool, error) {
+	args := []interface{}{q.runKey(runKey), time.Now().Unix(), "EX", 86400}
+	if !force {
+		args = append(args, "NX")
+	}
+	reply, err := q.redis.Do(time.Minute, "SET", args...)
+	if err != nil {
+		return false, err
+	}
+	if reply == nil {
+		return false, nil
+	}
+
+	_, err = q.redis.Do(time.Minute, "DEL", q.queuedKey(), q.takenKey(), q.failedKey(), q.renewedKey())
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	for _, org := range orgs {
+		err = q.push(q.queuedKey(), &OrgTask{OrgID: org.ID, QueuedOn: now})
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// FinishRun clears the key of the passed in run once none of its tasks are queued or taken, so that starting a run with
+// the same key again, such as after a failed run, queues its orgs again. Returns whether the run was finished.
+func (q *TaskQueue) FinishRun(runKey string) (bool, error) {
+	depth, err := q.Depth()
+	if err != nil {
+		return false, err
+	}
+	if depth.Queued > 0 || depth.Taken > 0 {
+		return false, nil
+	}
+
+	_, err = q.redis.Do(time.Minute, "DEL", q.runKey(runKey))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Pop takes the next task from our queue, waiting up to the passed in duration for one, returning nil if there is none
+func (q *TaskQueue) Pop(wait time.Duration) (*OrgTask, error) {
+	raw, err := redis.String(q.redis.Do(wait+time.Minute, "BRPOPLPUSH", q.queuedKey(), q.takenKey(), int(wait/time.Second)))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	task := &OrgTask{raw: raw}
+	err = json.Unmarshal([]byte(raw), task)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error unmarshalling task: %s", raw)
+	}
+	// if we can't record when we took the task, it's left for Requeue to find
+	err = q.Renew(task)
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// Renew records that the passed in task is still being archived, so that it isn't queued again by Requeue. Workers
+// should renew their task more often than the timeout passed to Requeue.
+func (q *TaskQueue) Renew(task *OrgTask) error {
+	_, err := q.redis.Do(time.Minute, "ZADD", q.renewedKey(), time.Now().Unix(), task.raw)
+	return err
+}
+
+// Requeue queues the tasks in our taken list which haven't been renewed within the passed in timeout again, as the
+// workers which took them have died. Tasks taken by a worker which died before it could first renew them are given
+// the timeout from now. Returns the number of tasks queued again.
+func (q *TaskQueue) Requeue(timeout time.Duration) (int, error) {
+	taken, err := redis.Strings(q.redis.Do(time.Minute, "LRANGE", q.takenKey(), 0, -1))
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	for _, raw := range taken {
+		_, err = q.redis.Do(time.Minute, "ZADD", q.renewedKey(), "NX", now.Unix(), raw)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	expired, err := redis.Strings(q.redis.Do(time.Minute, "ZRANGEBYSCORE", q.renewedKey(), "-inf", now.Add(-timeout).Unix()))
+	if err != nil {
+		return 0, err
+	}
+	requeued := 0
+	for _, raw := range expired {
+		// only whichever instance removes the task from our taken list queues it, to the front of our queue as it was
+		// taken before everything in it
+		removed, err := redis.Int(q.redis.Do(time.Minute, "LREM", q.takenKey(), 1, raw))
+		if err != nil {
+			return requeued, err
+		}
+		if removed > 0 {
+			_, err = q.redis.Do(time.Minute, "RPUSH", q.queuedKey(), raw)
+			if err != nil {
+				return requeued, err
+			}
+			requeued++
+		}
+		_, err = q.redis.Do(time.Minute, "ZREM", q.renewedKey(), raw)
+		if err != nil {
+			return requeued, err
+		}
+	}
+	return requeued, nil
+}
+
+// Complete removes the passed in task, which has been archived, from our taken list
+func (q *TaskQueue) Complete(task *OrgTask) error {
+	_, err := q.redis.Do(time.Minute, "LREM", q.takenKey(), 1, task.raw)
+	if err != nil {
+		return err
+	}
+	_, err = q.redis.Do(time.Minute, "ZREM", q.renewedKey(), task.raw)
+	return err
+}
+
+// Retry removes the passed in task, which failed, from our taken list and either queues it to be tried again, or if
+// it has already been retried the passed in number of times, moves it to our failed list. Returns whether it was
+// queued again.
+func (q *TaskQueue) Retry(task *OrgTask, maxRetries int) (bool, error) {
+	err := q.Complete(task)
+	if err != nil {
+		return false, err
+	}
+
+	task.Attempts++
+	if task.Attempts > maxRetries {
+		return false, q.push(q.failedKey(), task)
+	}
+	return true, q.push(q.queuedKey(), task)
+}
+
+// Depth returns how many tasks are in each of our lists
+func (q *TaskQueue) Depth() (*QueueDepth, error) {
+	depth := &QueueDepth{}
+	for key, count := range map[string]*int{q.queuedKey(): &depth.Queued, q.takenKey(): &depth.Taken, q.failedKey(): &depth.Failed} {
+		length, err := redis.Int(q.redis.Do(time.Minute, "LLEN", key))
+		if err != nil {
+			return nil, err
+		}
+		*count = length
+	}
+	return depth, nil
+}
+
+// push adds the passed in task to the list with the passed in key
+func (q *TaskQueue) push(key string, task *OrgTask) error {
+	contents, err := json.Marshal(task)
+	if err != nil {
+		return errors.Wrapf(err, "error marshalling task for org: %d", task.OrgID)
+	}
+	task.raw = string(contents)
+
+	_, err = q.redis.Do(time.Minute, "LPUSH", key, task.raw)
+	return err
+}
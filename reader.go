@@ -0,0 +1,164 @@
+package archiver
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/pkg/errors"
+)
+
+// ArchiveReader reads the records of an archive file one at a time, so that other programs can consume archives
+// without decoding them themselves. The hash of the file is verified once its last record has been read, if a record
+// can't be trusted until then, read the archive to the end before acting on any of it.
+//
+//	reader, err := archiver.OpenArchive(ctx, s3Client, url)
+//	defer reader.Close()
+//	for reader.Next() {
+//		msg, err := reader.DecodeMsg()
+//	}
+//	if err := reader.Err(); err != nil {
+//		...
+//	}
+type ArchiveReader struct {
+	body    io.Reader
+	closer  io.Closer
+	hash    hash.Hash
+	expect  string
+	gzip    *gzip.Reader
+	scanner *bufio.Scanner
+
+	record []byte
+	count  int
+	err    error
+}
+
+// OpenArchive opens the archive file at the passed in S3 URL for reading. Its hash is verified against the MD5 which S3
+// keeps for it, which is the hash we recorded for it unless it was uploaded in parts, in which case it can't be and
+// only its gzip checksum is verified. Use OpenArchiveWithHash to verify it against the hash recorded for the archive.
+func OpenArchive(ctx context.Context, s3Client s3iface.S3API, url string) (*ArchiveReader, error) {
+	etag, err := GetS3FileETAG(ctx, s3Client, url)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading ETAG of S3 URL: %s", url)
+	}
+
+	// the ETAG of objects uploaded in parts isn't the MD5 of the object
+	if strings.Contains(etag, "-") {
+		etag = ""
+	}
+	return OpenArchiveWithHash(ctx, s3Client, url, etag)
+}
+
+// OpenArchiveWithHash opens the archive file at the passed in S3 URL for reading, verifying it has the passed in hash
+func OpenArchiveWithHash(ctx context.Context, s3Client s3iface.S3API, url string, hash string) (*ArchiveReader, error) {
+	body, err := GetS3File(ctx, s3Client, url)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading S3 URL: %s", url)
+	}
+
+	reader, err := NewArchiveReader(body, hash)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	reader.closer = body
+	return reader, nil
+}
+
+// NewArchiveReader returns a reader of the records of the passed in gzipped archive file, verifying it has the passed
+// in hash once it has been read, unless that is empty
+func NewArchiveReader(file io.Reader, hash string) (*ArchiveReader, error) {
+	r := &ArchiveReader{body: file, hash: md5.New(), expect: hash}
+
+	var err error
+	r.gzip, err = gzip.NewReader(io.TeeReader(file, r.hash))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error creating gzip reader")
+	}
+
+	r.scanner = bufio.NewScanner(r.gzip)
+	r.scanner.Buffer(make([]byte, 64*1024), maxArchivedRecordSize)
+	return r, nil
+}
+
+// Next reads the next record of the archive, returning false once there are no more or reading fails, when the
+// archive has been verified or Err returns why it couldn't be
+func (r *ArchiveReader) Next() bool {
+	if r.err != nil || r.scanner == nil {
+		return false
+	}
+
+	for r.scanner.Scan() {
+		r.record = r.scanner.Bytes()
+		if len(r.record) > 0 {
+			r.count++
+			return true
+		}
+	}
+
+	r.record = nil
+	err := r.scanner.Err()
+	r.scanner = nil
+	if err != nil {
+		r.err = errors.Wrapf(err, "error reading record %d", r.count+1)
+		return false
+	}
+	r.err = r.verify()
+	return false
+}
+
+// verify drains anything left after the gzip trailer, so our hash covers the whole file, and checks its hash
+func (r *ArchiveReader) verify() error {
+	_, err := io.Copy(r.hash, r.body)
+	if err != nil {
+		return errors.Wrapf(err, "error reading archive")
+	}
+
+	actual := hex.EncodeToString(r.hash.Sum(nil))
+	if r.expect != "" && actual != r.expect {
+		return classify(ErrVerification, errors.Errorf("archive hash mismatch, expected %s, got %s", r.expect, actual))
+	}
+	return nil
+}
+
+// Record returns the JSON of the current record, which is only valid until Next is called again
+func (r *ArchiveReader) Record() json.RawMessage {
+	return r.record
+}
+
+// Decode decodes the JSON of the current record into the passed in value
+func (r *ArchiveReader) Decode(v interface{}) error {
+	if r.record == nil {
+		return errors.New("no current record, call Next first")
+	}
+	err := json.Unmarshal(r.record, v)
+	if err != nil {
+		return errors.Wrapf(err, "error decoding record %d", r.count)
+	}
+	return nil
+}
+
+// Count returns the number of records read so far
+func (r *ArchiveReader) Count() int {
+	return r.count
+}
+
+// Err returns the error which stopped reading, if any, including the archive not matching its hash
+func (r *ArchiveReader) Err() error {
+	return r.err
+}
+
+// Close closes the archive file, which should be done whether or not it was read to the end
+func (r *ArchiveReader) Close() error {
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
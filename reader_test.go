@@ -0,0 +1,62 @@
+package archiver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveReader(t *testing.T) {
+	file := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(file)
+	gzipWriter.Write([]byte("{\"id\":1,\"text\":\"hi\"}\n\n{\"id\":2,\"text\":\"there\"}\n"))
+	gzipWriter.Close()
+
+	sum := md5.Sum(file.Bytes())
+	hash := hex.EncodeToString(sum[:])
+
+	type msg struct {
+		ID   int    `json:"id"`
+		Text string `json:"text"`
+	}
+
+	reader, err := NewArchiveReader(bytes.NewReader(file.Bytes()), hash)
+	assert.NoError(t, err)
+	assert.EqualError(t, reader.Decode(&msg{}), "no current record, call Next first")
+
+	msgs := make([]msg, 0)
+	for reader.Next() {
+		m := msg{}
+		assert.NoError(t, reader.Decode(&m))
+		msgs = append(msgs, m)
+	}
+	assert.NoError(t, reader.Err())
+	assert.NoError(t, reader.Close())
+	assert.Equal(t, []msg{{1, "hi"}, {2, "there"}}, msgs)
+	assert.Equal(t, 2, reader.Count())
+	assert.False(t, reader.Next())
+
+	// a file which doesn't match its hash fails once read
+	reader, err = NewArchiveReader(bytes.NewReader(file.Bytes()), "d41d8cd98f00b204e9800998ecf8427e")
+	assert.NoError(t, err)
+	assert.True(t, reader.Next())
+	assert.Equal(t, `{"id":1,"text":"hi"}`, string(reader.Record()))
+	assert.True(t, reader.Next())
+	assert.False(t, reader.Next())
+	assert.EqualError(t, reader.Err(), "archive hash mismatch, expected d41d8cd98f00b204e9800998ecf8427e, got "+hash)
+	assert.Equal(t, ErrVerification, ErrorClass(reader.Err()))
+
+	// as does one which is truncated
+	reader, err = NewArchiveReader(bytes.NewReader(file.Bytes()[:file.Len()-4]), "")
+	assert.NoError(t, err)
+	for reader.Next() {
+	}
+	assert.Error(t, reader.Err())
+
+	_, err = NewArchiveReader(bytes.NewReader([]byte("this is not a gzip file")), "")
+	assert.EqualError(t, err, "error creating gzip reader: gzip: invalid header")
+}
@@ -0,0 +1,586 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// The JSON of exported records can be built by Postgres, with row_to_json, or by us from plain columns, which moves the
+// work of building it off the database at the cost of more data crossing the wire. Records built by us are the same
+// as those built by Postgres, nested objects are written the way Postgres writes jsonb, with their keys sorted by
+// length and then bytes, so archives built either way have the same hash.
+
+// ways of building the JSON of exported records
+const (
+	RecordJSONDB = "db"
+	RecordJSONGo = "go"
+)
+
+// exportsGoJSON returns whether we build the JSON of exported records ourselves
+func (c *Config) exportsGoJSON() bool {
+	return c.RecordJSON == RecordJSONGo
+}
+
+const lookupMsgFields = `
+SELECT
+	mm.id,
+	mm.broadcast_id,
+	contact.uuid AS contact_uuid,
+	contact.name AS contact_name,
+	CASE WHEN $1 THEN null ELSE ccu.identity END AS urn,
+	channel.uuid AS channel_uuid,
+	channel.name AS channel_name,
+	mm.direction,
+	mm.msg_type,
+	mm.status,
+	mm.visibility,
+	mm.text,
+	mm.attachments,
+	labels_agg.uuids AS label_uuids,
+	labels_agg.names AS label_names,
+	mm.created_on,
+	mm.sent_on,
+	mm.modified_on,
+	CASE WHEN $2 THEN mm.metadata ELSE NULL END AS metadata
+FROM msgs_msg mm
+	JOIN LATERAL (select uuid, name from contacts_contact cc where cc.id = mm.contact_id) as contact ON True
+	LEFT JOIN contacts_contacturn ccu ON mm.contact_urn_id = ccu.id
+	LEFT JOIN LATERAL (select uuid, name from channels_channel ch where ch.id = mm.channel_id) as channel ON True
+	LEFT JOIN LATERAL (select array_agg(uuid) as uuids, array_agg(name) as names from (select uuid, name from msgs_label ml INNER JOIN msgs_msg_labels mml ON ml.id = mml.label_id AND mml.msg_id = mm.id) as label_row) as labels_agg ON True
+WHERE mm.org_id = $3 AND mm.created_on >= $4 AND mm.created_on < $5 AND (mm.created_on, mm.id) > ($6, $7)
+ORDER BY mm.created_on ASC, mm.id ASC
+LIMIT $8
+`
+
+const lookupRunFields = `
+SELECT
+	fr.id,
+	fr.uuid,
+	flow_struct.uuid AS flow_uuid,
+	flow_struct.name AS flow_name,
+	contact_struct.uuid AS contact_uuid,
+	contact_struct.name AS contact_name,
+	fr.responded,
+	fr.path,
+	fr.results,
+	CASE WHEN $1 THEN '[]' ELSE coalesce(fr.events, '[]'::jsonb)::text END AS events,
+	fr.created_on,
+	fr.modified_on,
+	fr.exited_on,
+	fr.exit_type,
+	a.username AS submitted_by
+FROM flows_flowrun fr
+	LEFT JOIN auth_user a ON a.id = fr.submitted_by_id
+	JOIN LATERAL (SELECT uuid, name FROM flows_flow WHERE flows_flow.id = fr.flow_id) AS flow_struct ON True
+	JOIN LATERAL (SELECT uuid, name FROM contacts_contact cc WHERE cc.id = fr.contact_id) AS contact_struct ON True
+WHERE fr.org_id = $2 AND fr.modified_on >= $3 AND fr.modified_on < $4 AND (fr.modified_on, fr.id) > ($5, $6)
+ORDER BY fr.modified_on ASC, fr.id ASC
+LIMIT $7
+`
+
+var msgDirections = map[string]string{"I": "in", "O": "out"}
+var msgTypes = map[string]string{"F": "flow", "V": "ivr", "I": "inbox"}
+var msgStatuses = map[string]string{
+	"I": "initializing", "P": "queued", "Q": "queued", "W": "wired", "D": "delivered", "H": "handled",
+	"E": "errored", "F": "failed", "S": "sent", "R": "resent",
+}
+var msgVisibilities = map[string]string{"V": "visible", "A": "archived", "D": "deleted"}
+var runExitTypes = map[string]string{"C": "completed", "I": "interrupted", "E": "expired"}
+
+// splits attachments into their content type and URL as our export query does
+var attachmentRegex = regexp.MustCompile(`(?s)^(.*?):(.*)$`)
+
+// msgFields are the plain columns of a message we build its record from
+type msgFields struct {
+	ID          int64
+	Broadcast   *int64
+	ContactUUID *string
+	ContactName *string
+	URN         *string
+	ChannelUUID *string
+	ChannelName *string
+	Direction   *string
+	MsgType     *string
+	Status      *string
+	Visibility  *string
+	Text        *string
+	Attachments pq.StringArray
+	LabelUUIDs  pq.StringArray
+	LabelNames  pq.StringArray
+	CreatedOn   time.Time
+	SentOn      *time.Time
+	ModifiedOn  time.Time
+	Metadata    *string
+}
+
+// scanMsgFields scans the current row of our message fields query
+func scanMsgFields(rows *sqlx.Rows, m *msgFields) error {
+	return rows.Scan(
+		&m.ID, &m.Broadcast, &m.ContactUUID, &m.ContactName, &m.URN, &m.ChannelUUID, &m.ChannelName, &m.Direction,
+		&m.MsgType, &m.Status, &m.Visibility, &m.Text, &m.Attachments, &m.LabelUUIDs, &m.LabelNames, &m.CreatedOn,
+		&m.SentOn, &m.ModifiedOn, &m.Metadata,
+	)
+}
+
+// visibility returns the visibility of this message as it appears in its record
+func (m *msgFields) visibility() string {
+	if m.Visibility == nil {
+		return ""
+	}
+	return msgVisibilities[*m.Visibility]
+}
+
+// record builds the JSON record of this message, the same as our export query builds it
+func (m *msgFields) record() string {
+	r := &rowJSON{}
+	r.key("id").integer(&m.ID)
+	r.key("broadcast").integer(m.Broadcast)
+
+	r.key("contact")
+	contact := &rowJSON{}
+	contact.key("uuid").str(m.ContactUUID)
+	contact.key("name").str(m.ContactName)
+	r.raw(contact.String())
+
+	r.key("urn").str(m.URN)
+
+	r.key("channel")
+	if m.ChannelUUID != nil {
+		channel := &rowJSON{}
+		channel.key("uuid").str(m.ChannelUUID)
+		channel.key("name").str(m.ChannelName)
+		r.raw(channel.String())
+	} else {
+		r.raw("null")
+	}
+
+	r.key("direction").str(lookupCode(msgDirections, m.Direction))
+	r.key("type").str(lookupCode(msgTypes, m.MsgType))
+	r.key("status").str(lookupCode(msgStatuses, m.Status))
+	r.key("visibility").str(lookupCode(msgVisibilities, m.Visibility))
+	r.key("text").str(m.Text)
+
+	attachments := make([]interface{}, 0, len(m.Attachments))
+	for _, a := range m.Attachments {
+		if parts := attachmentRegex.FindStringSubmatch(a); parts != nil {
+			attachments = append(attachments, map[string]interface{}{"content_type": parts[1], "url": parts[2]})
+		}
+	}
+	r.key("attachments").raw(jsonbString(attachments))
+
+	labels := make([]interface{}, 0, len(m.LabelUUIDs))
+	for i := range m.LabelUUIDs {
+		labels = append(labels, map[string]interface{}{"uuid": m.LabelUUIDs[i], "name": m.LabelNames[i]})
+	}
+	r.key("labels").raw(jsonbString(labels))
+
+	r.key("created_on").timestamp(&m.CreatedOn)
+	r.key("sent_on").timestamp(m.SentOn)
+	r.key("modified_on").timestamp(&m.ModifiedOn)
+	return r.String()
+}
+
+// runFields are the plain columns of a run we build its record from
+type runFields struct {
+	ID          int64
+	UUID        *string
+	FlowUUID    *string
+	FlowName    *string
+	ContactUUID *string
+	ContactName *string
+	Responded   *bool
+	Path        *string
+	Results     *string
+	Events      string
+	CreatedOn   *time.Time
+	ModifiedOn  time.Time
+	ExitedOn    *time.Time
+	ExitType    *string
+	SubmittedBy *string
+}
+
+// scanRunFields scans the current row of our run fields query
+func scanRunFields(rows *sqlx.Rows, r *runFields) error {
+	return rows.Scan(
+		&r.ID, &r.UUID, &r.FlowUUID, &r.FlowName, &r.ContactUUID, &r.ContactName, &r.Responded, &r.Path, &r.Results,
+		&r.Events, &r.CreatedOn, &r.ModifiedOn, &r.ExitedOn, &r.ExitType, &r.SubmittedBy,
+	)
+}
+
+// record builds the JSON record of this run, the same as our export query builds it
+func (f *runFields) record() (string, error) {
+	path, err := runPathJSON(f.Path)
+	if err != nil {
+		return "", errors.Wrapf(err, "error building path of run: %d", f.ID)
+	}
+	values, err := runValuesJSON(f.Results)
+	if err != nil {
+		return "", errors.Wrapf(err, "error building values of run: %d", f.ID)
+	}
+
+	r := &rowJSON{}
+	r.key("id").integer(&f.ID)
+	r.key("uuid").str(f.UUID)
+
+	r.key("flow")
+	flow := &rowJSON{}
+	flow.key("uuid").str(f.FlowUUID)
+	flow.key("name").str(f.FlowName)
+	r.raw(flow.String())
+
+	r.key("contact")
+	contact := &rowJSON{}
+	contact.key("uuid").str(f.ContactUUID)
+	contact.key("name").str(f.ContactName)
+	r.raw(contact.String())
+
+	r.key("responded").boolean(f.Responded)
+	r.key("path").raw(path)
+	r.key("values").raw(values)
+	r.key("events").raw(f.Events)
+	r.key("created_on").timestamp(f.CreatedOn)
+	r.key("modified_on").timestamp(&f.ModifiedOn)
+	r.key("exited_on").timestamp(f.ExitedOn)
+	r.key("exit_type").str(lookupCode(runExitTypes, f.ExitType))
+	r.key("submitted_by").str(f.SubmittedBy)
+	return r.String(), nil
+}
+
+// runPathJSON builds the path of a run's record from its path column, the node and time of each step
+func runPathJSON(column *string) (string, error) {
+	steps := make([]map[string]interface{}, 0)
+	if column != nil {
+		err := decodeJSON(*column, &steps)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	path := make([]interface{}, len(steps))
+	for i, step := range steps {
+		arrivedOn, err := jsonTimestamp(step["arrived_on"])
+		if err != nil {
+			return "", err
+		}
+		path[i] = map[string]interface{}{"node": jsonText(step["node_uuid"]), "time": arrivedOn}
+	}
+	return jsonbString(path), nil
+}
+
+// runValuesJSON builds the values of a run's record from its results column, keyed by the key of each result, each
+// normalized from whichever format it was written in, see normalizeResult
+func runValuesJSON(column *string) (string, error) {
+	results := make(map[string]interface{})
+	if column != nil {
+		err := decodeJSON(*column, &results)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	values := make(map[string]interface{}, len(results))
+	for key, value := range results {
+		result, _ := value.(map[string]interface{})
+		createdOn, err := jsonTimestamp(result["created_on"])
+		if err != nil {
+			return "", err
+		}
+		values[key] = normalizeResult(result, createdOn)
+	}
+	return jsonbString(values), nil
+}
+
+// normalizeResult returns the value of a run's record for the passed in result, which may have been written by any of
+// the versions of the flow spec results have been stored in. The format of each result is detected from its keys
+// rather than the current spec version of its flow, as that may have changed since it was written:
+//
+//   - current flows write the category as a string, the input and the node_uuid
+//   - legacy flows wrote the category as a map of its translations, of which we take the base, or only category_localized
+//   - the earliest flows wrote the input as text and the node as node
+//
+// Our export query normalizes results the same way.
+func normalizeResult(result map[string]interface{}, createdOn interface{}) map[string]interface{} {
+	input, found := result["input"]
+	if !found {
+		input = result["text"]
+	}
+	node, found := result["node_uuid"]
+	if !found {
+		node = result["node"]
+	}
+	category, found := result["category"]
+	if translations, isMap := category.(map[string]interface{}); isMap {
+		category = translations["base"]
+	} else if !found {
+		category = result["category_localized"]
+	}
+
+	return map[string]interface{}{
+		"name":     result["name"],
+		"value":    result["value"],
+		"input":    input,
+		"time":     createdOn,
+		"category": category,
+		"node":     node,
+	}
+}
+
+// RecordJSONBenchmark is how long it took to export an archive building its record JSON one way, with queries or COPY
+type RecordJSONBenchmark struct {
+	RecordJSON string
+	Copy       bool
+	Records    int
+	Size       int64
+	Hash       string
+	Elapsed    time.Duration
+}
+
+// BenchmarkRecordJSON exports the passed in archive to a temp file the passed in number of times for each way of
+// building record JSON, and with COPY if the passed in database can be copied from, returning the fastest time for
+// each. Nothing is written to the database or uploaded.
+func BenchmarkRecordJSON(ctx context.Context, db *sqlx.DB, config *Config, archive *Archive, runs int) ([]*RecordJSONBenchmark, error) {
+	ways := []RecordJSONBenchmark{{RecordJSON: RecordJSONDB}, {RecordJSON: RecordJSONGo}}
+
+	copySourcesMutex.RLock()
+	if _, found := copySources[db]; found {
+		ways = append(ways, RecordJSONBenchmark{RecordJSON: RecordJSONDB, Copy: true})
+	}
+	copySourcesMutex.RUnlock()
+
+	benchmarks := make([]*RecordJSONBenchmark, 0, len(ways))
+	for _, way := range ways {
+		conf := *config
+		conf.RecordJSON = way.RecordJSON
+		conf.ExportWithCopy = way.Copy
+
+		var best *RecordJSONBenchmark
+		for i := 0; i < runs; i++ {
+			a := *archive
+			a.ArchiveFile = ""
+
+			start := time.Now()
+			err := createArchiveFile(ctx, db, &a, conf.TempDir, &conf, nil)
+			if err != nil {
+				if way.Copy {
+					return nil, errors.Wrapf(err, "error exporting archive with copy")
+				}
+				return nil, errors.Wrapf(err, "error exporting archive with %s record json", way.RecordJSON)
+			}
+			elapsed := time.Since(start)
+			DeleteArchiveFile(&a)
+
+			if best == nil || elapsed < best.Elapsed {
+				best = &RecordJSONBenchmark{RecordJSON: way.RecordJSON, Copy: way.Copy, Records: a.RecordCount, Size: a.Size, Hash: a.Hash, Elapsed: elapsed}
+			}
+		}
+		benchmarks = append(benchmarks, best)
+	}
+	return benchmarks, nil
+}
+
+// lookupCode returns the name of the passed in code, nil if it has none
+func lookupCode(names map[string]string, code *string) *string {
+	if code == nil {
+		return nil
+	}
+	name, found := names[*code]
+	if !found {
+		return nil
+	}
+	return &name
+}
+
+// decodeJSON decodes the passed in JSON, keeping numbers as they were written
+func decodeJSON(value string, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader([]byte(value)))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// jsonText returns the passed in decoded JSON value as Postgres' ->> operator would, strings are unquoted and
+// anything else is written as JSON
+func jsonText(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return t
+	default:
+		return jsonbString(t)
+	}
+}
+
+// jsonTimestamp parses the passed in decoded JSON string as a timestamp, as casting it to a timestamptz would
+func jsonTimestamp(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	s, isString := v.(string)
+	if !isString {
+		return nil, fmt.Errorf("invalid timestamp: %v", v)
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid timestamp: %s", s)
+	}
+	return t, nil
+}
+
+// formatJSONTime formats the passed in time the way Postgres writes a timestamptz in JSON in UTC
+func formatJSONTime(t time.Time) string {
+	return t.Round(time.Microsecond).In(time.UTC).Format("2006-01-02T15:04:05.999999-07:00")
+}
+
+// rowJSON builds a JSON object the way row_to_json does, with its keys in the order they are added and no spaces
+type rowJSON struct {
+	b bytes.Buffer
+}
+
+func (r *rowJSON) key(k string) *rowJSON {
+	if r.b.Len() == 0 {
+		r.b.WriteByte('{')
+	} else {
+		r.b.WriteByte(',')
+	}
+	writeJSONString(&r.b, k)
+	r.b.WriteByte(':')
+	return r
+}
+
+func (r *rowJSON) raw(v string) { r.b.WriteString(v) }
+
+func (r *rowJSON) str(v *string) {
+	if v == nil {
+		r.raw("null")
+		return
+	}
+	writeJSONString(&r.b, *v)
+}
+
+func (r *rowJSON) integer(v *int64) {
+	if v == nil {
+		r.raw("null")
+		return
+	}
+	r.raw(strconv.FormatInt(*v, 10))
+}
+
+func (r *rowJSON) boolean(v *bool) {
+	if v == nil {
+		r.raw("null")
+		return
+	}
+	r.raw(strconv.FormatBool(*v))
+}
+
+func (r *rowJSON) timestamp(v *time.Time) {
+	if v == nil {
+		r.raw("null")
+		return
+	}
+	writeJSONString(&r.b, formatJSONTime(*v))
+}
+
+func (r *rowJSON) String() string {
+	if r.b.Len() == 0 {
+		return "{}"
+	}
+	return r.b.String() + "}"
+}
+
+// jsonbString writes the passed in value the way Postgres writes jsonb, with spaces after separators and the keys of
+// objects sorted by length and then bytes
+func jsonbString(v interface{}) string {
+	b := &bytes.Buffer{}
+	writeJSONB(b, v)
+	return b.String()
+}
+
+func writeJSONB(b *bytes.Buffer, v interface{}) {
+	switch t := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		b.WriteString(strconv.FormatBool(t))
+	case json.Number:
+		b.WriteString(t.String())
+	case string:
+		writeJSONString(b, t)
+	case time.Time:
+		writeJSONString(b, formatJSONTime(t))
+	case []interface{}:
+		b.WriteByte('[')
+		for i, item := range t {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeJSONB(b, item)
+		}
+		b.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if len(keys[i]) != len(keys[j]) {
+				return len(keys[i]) < len(keys[j])
+			}
+			return keys[i] < keys[j]
+		})
+
+		b.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeJSONString(b, k)
+			b.WriteString(": ")
+			writeJSONB(b, t[k])
+		}
+		b.WriteByte('}')
+	default:
+		writeJSONString(b, fmt.Sprint(t))
+	}
+}
+
+// writeJSONString writes the passed in string as a JSON string, escaped the way Postgres escapes them
+func writeJSONString(b *bytes.Buffer, s string) {
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		default:
+			if c < ' ' {
+				fmt.Fprintf(b, `\u%04x`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+}
@@ -0,0 +1,128 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordJSON(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	orgs, err := GetActiveOrgs(ctx, db, NewConfig())
+	assert.NoError(t, err)
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	msgTasks, err := GetMissingDailyArchives(ctx, db, now, orgs[1], MessageType)
+	assert.NoError(t, err)
+	runTasks, err := GetMissingDailyArchives(ctx, db, now, orgs[1], RunType)
+	assert.NoError(t, err)
+	anonMsgTasks, err := GetMissingDailyArchives(ctx, db, now, orgs[2], MessageType)
+	assert.NoError(t, err)
+	anonRunTasks, err := GetMissingDailyArchives(ctx, db, now, orgs[2], RunType)
+	assert.NoError(t, err)
+
+	// building our records in Go should give us byte for byte the same archives as Postgres does
+	for _, pageSize := range []int{0, 2} {
+		config := &Config{RecordJSON: RecordJSONGo, ExportPageSize: pageSize}
+
+		tcs := []struct {
+			task     *Archive
+			count    int
+			hash     string
+			filename string
+		}{
+			{msgTasks[2], 3, "6fe9265860425cf1f9757ba3d91b1a05", "messages1.jsonl"},
+			{anonMsgTasks[0], 1, "a719c7ec64c516a6e159d26a70cb4225", "messages2.jsonl"},
+			{runTasks[2], 2, "f793f863f5e060b9d67c5688a555da6a", "runs1.jsonl"},
+			{anonRunTasks[0], 1, "074de71dfb619c78dbac5b6709dd66c2", "runs2.jsonl"},
+		}
+		for _, tc := range tcs {
+			err = createArchiveFile(ctx, db, tc.task, "/tmp", config, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.count, tc.task.RecordCount)
+			assert.Equal(t, tc.hash, tc.task.Hash)
+			assertArchiveFile(t, tc.task, tc.filename)
+			DeleteArchiveFile(tc.task)
+			tc.task.ArchiveFile = ""
+		}
+	}
+
+	// benchmarking exports each way, leaving nothing behind
+	benchmarks, err := BenchmarkRecordJSON(ctx, db, &Config{TempDir: "/tmp"}, msgTasks[2], 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(benchmarks))
+	for _, b := range benchmarks {
+		assert.Equal(t, 3, b.Records)
+		assert.Equal(t, "6fe9265860425cf1f9757ba3d91b1a05", b.Hash)
+	}
+	assert.Equal(t, RecordJSONDB, benchmarks[0].RecordJSON)
+	assert.Equal(t, RecordJSONGo, benchmarks[1].RecordJSON)
+	assert.Equal(t, "", msgTasks[2].ArchiveFile)
+}
+
+func TestJSONBString(t *testing.T) {
+	tcs := []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, `null`},
+		{"a\"b\\c\n\x01é", `"a\"b\\c\n\u0001é"`},
+		{[]interface{}{}, `[]`},
+		{map[string]interface{}{}, `{}`},
+		{map[string]interface{}{"name": "Bob", "id": json.Number("1"), "age": nil}, `{"id": 1, "age": null, "name": "Bob"}`},
+		{[]interface{}{map[string]interface{}{"zz": true, "b": false}}, `[{"b": false, "zz": true}]`},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.expected, jsonbString(tc.value), "unexpected jsonb for %v", tc.value)
+	}
+}
+
+func TestFormatJSONTime(t *testing.T) {
+	loc := time.FixedZone("", 2*3600)
+
+	assert.Equal(t, "2017-08-12T19:11:59.890662+00:00", formatJSONTime(time.Date(2017, 8, 12, 21, 11, 59, 890662000, loc)))
+	assert.Equal(t, "2017-08-12T19:11:59.89+00:00", formatJSONTime(time.Date(2017, 8, 12, 21, 11, 59, 890000400, loc)))
+	assert.Equal(t, "2017-08-12T19:11:59+00:00", formatJSONTime(time.Date(2017, 8, 12, 21, 11, 59, 0, loc)))
+}
+
+func TestRunPathAndValuesJSON(t *testing.T) {
+	path := `[{"uuid": "c3d0b417-db75-417c-8050-33776ec8f620", "node_uuid": "10896d63-8df7-4022-88dd-a9d93edf355b", "arrived_on": "2017-08-12T15:07:24.049815+02:00", "exit_uuid": "2f890507-2ad2-4bd1-92fc-0ca031155fca"}]`
+	pathJSON, err := runPathJSON(&path)
+	assert.NoError(t, err)
+	assert.Equal(t, `[{"node": "10896d63-8df7-4022-88dd-a9d93edf355b", "time": "2017-08-12T13:07:24.049815+00:00"}]`, pathJSON)
+
+	results := `{"agree": {"category": "Strongly agree", "node_uuid": "a0434c54-3e26-4eb0-bafc-46cdeaf435ac", "name": "Do you agree?", "value": "A", "created_on": "2017-05-03T12:25:21.714339+00:00", "input": "A"}}`
+	valuesJSON, err := runValuesJSON(&results)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"agree": {"name": "Do you agree?", "node": "a0434c54-3e26-4eb0-bafc-46cdeaf435ac", "time": "2017-05-03T12:25:21.714339+00:00", "input": "A", "value": "A", "category": "Strongly agree"}}`, valuesJSON)
+
+	// results written by legacy flows are normalized to the same format
+	results = `{"agree": {"category": {"base": "Strongly agree", "spa": "Muy de acuerdo"}, "category_localized": "Muy de acuerdo", "node_uuid": "a0434c54-3e26-4eb0-bafc-46cdeaf435ac", "name": "Do you agree?", "value": "A", "created_on": "2017-05-03T12:25:21.714339+00:00", "input": "A"}}`
+	valuesJSON, err = runValuesJSON(&results)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"agree": {"name": "Do you agree?", "node": "a0434c54-3e26-4eb0-bafc-46cdeaf435ac", "time": "2017-05-03T12:25:21.714339+00:00", "input": "A", "value": "A", "category": "Strongly agree"}}`, valuesJSON)
+
+	results = `{"agree": {"category_localized": "Muy de acuerdo", "node": "a0434c54-3e26-4eb0-bafc-46cdeaf435ac", "name": "Do you agree?", "value": "A", "created_on": "2017-05-03T12:25:21.714339+00:00", "text": "A"}}`
+	valuesJSON, err = runValuesJSON(&results)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"agree": {"name": "Do you agree?", "node": "a0434c54-3e26-4eb0-bafc-46cdeaf435ac", "time": "2017-05-03T12:25:21.714339+00:00", "input": "A", "value": "A", "category": "Muy de acuerdo"}}`, valuesJSON)
+
+	// no path or results gives us empty JSON
+	pathJSON, err = runPathJSON(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `[]`, pathJSON)
+
+	_, err = runValuesJSON(&path)
+	assert.Error(t, err)
+
+	b := &bytes.Buffer{}
+	writeJSONString(b, "tab\there")
+	assert.Equal(t, `"tab\there"`, b.String())
+}
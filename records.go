@@ -0,0 +1,103 @@
+package archiver
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Msg is a message as it is written to message archives, one per line, and can be used to decode the records read
+// from them. Fields which can be null in archives are pointers. Fields excluded by a schema profile or dropped by
+// redaction are left empty, and those which are masked or hashed keep their type.
+type Msg struct {
+	ID          int64            `json:"id"`
+	BroadcastID *int64           `json:"broadcast"`
+	Contact     RecordContact    `json:"contact"`
+	URN         *string          `json:"urn"`
+	Channel     *RecordRef       `json:"channel"`
+	Direction   string           `json:"direction"`
+	Type        string           `json:"type"`
+	Status      string           `json:"status"`
+	Visibility  string           `json:"visibility"`
+	Text        *string          `json:"text"`
+	Attachments []MsgAttachment  `json:"attachments"`
+	Labels      []RecordRef      `json:"labels"`
+	CreatedOn   time.Time        `json:"created_on"`
+	SentOn      *time.Time       `json:"sent_on"`
+	ModifiedOn  time.Time        `json:"modified_on"`
+	Metadata    *json.RawMessage `json:"metadata,omitempty"`
+}
+
+// MsgAttachment is an attachment of an archived message
+type MsgAttachment struct {
+	ContentType string `json:"content_type"`
+	URL         string `json:"url"`
+}
+
+// Run is a flow run as it is written to run archives, one per line, and can be used to decode the records read from
+// them, the same as Msg
+type Run struct {
+	ID          int64               `json:"id"`
+	UUID        string              `json:"uuid"`
+	Flow        RecordRef           `json:"flow"`
+	Contact     RecordContact       `json:"contact"`
+	Responded   bool                `json:"responded"`
+	Path        []RunStep           `json:"path"`
+	Values      map[string]RunValue `json:"values"`
+	Events      []json.RawMessage   `json:"events"`
+	CreatedOn   *time.Time          `json:"created_on"`
+	ModifiedOn  time.Time           `json:"modified_on"`
+	ExitedOn    *time.Time          `json:"exited_on"`
+	ExitType    *string             `json:"exit_type"`
+	SubmittedBy *string             `json:"submitted_by"`
+}
+
+// RunStep is a step of the path of an archived run, the node it arrived at and when
+type RunStep struct {
+	Node string    `json:"node"`
+	Time time.Time `json:"time"`
+}
+
+// RunValue is the value of a result of an archived run, normalized from whichever version of the flow spec it was
+// written in
+type RunValue struct {
+	Name     string    `json:"name"`
+	Value    *string   `json:"value"`
+	Input    *string   `json:"input"`
+	Category *string   `json:"category"`
+	Node     string    `json:"node"`
+	Time     time.Time `json:"time"`
+}
+
+// RecordRef is a reference to a channel, flow or label in an archived record
+type RecordRef struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+}
+
+// RecordContact is the contact of an archived message or run. Its language, groups and created on are only included if
+// a schema profile asks for them.
+type RecordContact struct {
+	UUID      string      `json:"uuid"`
+	Name      *string     `json:"name"`
+	Language  *string     `json:"language,omitempty"`
+	Groups    []RecordRef `json:"groups,omitempty"`
+	CreatedOn *time.Time  `json:"created_on,omitempty"`
+}
+
+// DecodeMsg decodes the current record of this reader, which must be of a message archive
+func (r *ArchiveReader) DecodeMsg() (*Msg, error) {
+	msg := &Msg{}
+	if err := r.Decode(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// DecodeRun decodes the current record of this reader, which must be of a run archive
+func (r *ArchiveReader) DecodeRun() (*Run, error) {
+	run := &Run{}
+	if err := r.Decode(run); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
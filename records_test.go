@@ -0,0 +1,81 @@
+package archiver
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// decodeStrictly decodes the passed in record into the passed in value, failing on any field it doesn't have
+func decodeStrictly(record []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(record))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+func TestRecordTypes(t *testing.T) {
+	// every field of our expected archives has a field in our record types
+	for _, tc := range []struct {
+		file   string
+		record func() interface{}
+	}{
+		{"testdata/messages1.jsonl", func() interface{} { return &Msg{} }},
+		{"testdata/messages2.jsonl", func() interface{} { return &Msg{} }},
+		{"testdata/runs1.jsonl", func() interface{} { return &Run{} }},
+		{"testdata/runs2.jsonl", func() interface{} { return &Run{} }},
+	} {
+		contents, err := ioutil.ReadFile(tc.file)
+		require.NoError(t, err)
+
+		scanner := bufio.NewScanner(bytes.NewReader(contents))
+		for scanner.Scan() {
+			assert.NoError(t, decodeStrictly(scanner.Bytes(), tc.record()), "decoding record in %s", tc.file)
+		}
+	}
+
+	// including the optional fields a schema profile adds
+	msg := &Msg{}
+	err := decodeStrictly([]byte(`{"id":5,"broadcast":null,"contact":{"uuid":"3e814add-e614-41f7-8b5d-a07f670a698f","name":null,"language":"eng","groups":[{"uuid":"4ea0f313-2f62-4e57-bdf0-232b5191dd57","name":"Doctors"}],"created_on":"2017-08-01T10:00:00+00:00"},"urn":null,"channel":null,"direction":"in","type":"inbox","status":"handled","visibility":"visible","text":null,"attachments":[{"content_type":"image/png","url":"https://foo.bar/image1.png"}],"labels":[],"created_on":"2017-08-12T21:11:59.890662+00:00","sent_on":null,"modified_on":"2017-08-12T21:11:59.890662+00:00","metadata":{"quick_replies":["yes"]}}`), msg)
+	assert.NoError(t, err)
+	assert.Equal(t, "eng", *msg.Contact.Language)
+	assert.Equal(t, []RecordRef{{UUID: "4ea0f313-2f62-4e57-bdf0-232b5191dd57", Name: "Doctors"}}, msg.Contact.Groups)
+	assert.Equal(t, []MsgAttachment{{ContentType: "image/png", URL: "https://foo.bar/image1.png"}}, msg.Attachments)
+	assert.Equal(t, `{"quick_replies":["yes"]}`, string(*msg.Metadata))
+	assert.Nil(t, msg.Text)
+
+	// and records can be decoded as they are read
+	file := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(file)
+	runs, err := ioutil.ReadFile("testdata/runs1.jsonl")
+	require.NoError(t, err)
+	gzipWriter.Write(runs)
+	gzipWriter.Close()
+
+	reader, err := NewArchiveReader(file, "")
+	require.NoError(t, err)
+	require.True(t, reader.Next())
+	require.True(t, reader.Next())
+
+	run, err := reader.DecodeRun()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), run.ID)
+	assert.Equal(t, "Flow 1", run.Flow.Name)
+	if assert.Equal(t, 1, len(run.Path)) {
+		assert.Equal(t, "10896d63-8df7-4022-88dd-a9d93edf355b", run.Path[0].Node)
+		assert.True(t, run.Path[0].Time.Equal(time.Date(2017, 8, 12, 13, 7, 24, 49815000, time.UTC)))
+	}
+	assert.Equal(t, "Strongly agree", *run.Values["agree"].Category)
+	assert.Equal(t, 1, len(run.Events))
+	assert.Equal(t, "completed", *run.ExitType)
+
+	assert.False(t, reader.Next())
+	_, err = reader.DecodeMsg()
+	assert.EqualError(t, err, "no current record, call Next first")
+}
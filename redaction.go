@@ -0,0 +1,357 @@
+package archiver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// RedactionAction is what is done to a field of archived records when it is redacted
+type RedactionAction string
+
+const (
+	// RedactDrop removes the field from the record
+	RedactDrop RedactionAction = "drop"
+
+	// RedactMask replaces the value of the field with a mask, leaving it null if it was null
+	RedactMask RedactionAction = "mask"
+
+	// RedactHash replaces the value of the field with a hex HMAC-SHA256 of it, keyed by the org's salt, so records
+	// with the same value can still be matched up without revealing it
+	RedactHash RedactionAction = "hash"
+)
+
+// redactionMask is what masked values are replaced with
+const redactionMask = `"***"`
+
+// Redaction is a named profile of the fields to redact from the records of each archive type, by their paths within
+// records, ie: text or contact.name. Arrays are redacted element by element, so attachments.url redacts the URL of
+// every attachment, and * matches any key, so values.*.input redacts the input of every result.
+type Redaction struct {
+	Name   string
+	Fields map[ArchiveType]map[string]RedactionAction
+
+	// the secret hashed values are keyed by, combined with the org id so each org has its own salt
+	salt string
+}
+
+// RedactionPolicy is a set of named redaction profiles, with a default profile and the profiles of particular orgs, it
+// is loaded from a JSON file such as:
+//
+//	{
+//	  "profiles": {
+//	    "strict": {
+//	      "message": {"text": "drop", "urn": "hash", "contact.name": "mask"},
+//	      "run": {"values.*.input": "mask", "contact.name": "mask"}
+//	    }
+//	  },
+//	  "default": "",
+//	  "orgs": {"42": "strict"}
+//	}
+type RedactionPolicy struct {
+	Profiles map[string]map[ArchiveType]map[string]RedactionAction `json:"profiles"`
+	Default  string                                                `json:"default"`
+	Orgs     map[string]string                                     `json:"orgs"`
+}
+
+// LoadRedactionPolicy loads and validates the redaction policy in the passed in file
+func LoadRedactionPolicy(filename string) (*RedactionPolicy, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading redaction policy file: %s", filename)
+	}
+
+	policy := &RedactionPolicy{}
+	err = json.Unmarshal(contents, policy)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing redaction policy file: %s", filename)
+	}
+
+	for name, fields := range policy.Profiles {
+		if name == "" || len(name) > 64 || strings.Contains(name, ",") {
+			return nil, errors.Errorf("invalid redaction profile name '%s', must be 1 to 64 characters without commas", name)
+		}
+		for archiveType, actions := range fields {
+			for path, action := range actions {
+				err = validateRedaction(archiveType, path, action)
+				if err != nil {
+					return nil, errors.Wrapf(err, "invalid redaction in profile %s", name)
+				}
+			}
+		}
+	}
+	if policy.Default != "" && policy.Profiles[policy.Default] == nil {
+		return nil, errors.Errorf("unknown default redaction profile '%s'", policy.Default)
+	}
+	for orgID, name := range policy.Orgs {
+		_, err := strconv.Atoi(orgID)
+		if err != nil {
+			return nil, errors.Errorf("invalid org id '%s' in redaction policy", orgID)
+		}
+		if name != "" && policy.Profiles[name] == nil {
+			return nil, errors.Errorf("unknown redaction profile '%s' for org %s", name, orgID)
+		}
+	}
+
+	return policy, nil
+}
+
+// validateRedaction checks the passed in redaction of a field of the passed in archive type, the fields every record
+// must have can't be redacted themselves, though fields within them can
+func validateRedaction(archiveType ArchiveType, path string, action RedactionAction) error {
+	required, found := requiredFields[archiveType]
+	if !found {
+		return errors.Errorf("unknown archive type: %s", archiveType)
+	}
+	if action != RedactDrop && action != RedactMask && action != RedactHash {
+		return errors.Errorf("unknown action '%s' for %s, must be drop, mask or hash", action, path)
+	}
+	for _, part := range strings.Split(path, ".") {
+		if part == "" {
+			return errors.Errorf("invalid field path '%s'", path)
+		}
+	}
+	for _, field := range required {
+		if path == field || path == "*" {
+			return errors.Errorf("required field '%s' of %s records can't be redacted", path, archiveType)
+		}
+	}
+	return nil
+}
+
+// hashes returns whether any profile of this policy hashes fields
+func (p *RedactionPolicy) hashes() bool {
+	for _, fields := range p.Profiles {
+		for _, actions := range fields {
+			for _, action := range actions {
+				if action == RedactHash {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// redactionForOrg returns the redaction profile which applies to the passed in org, nil if it has none
+func (p *RedactionPolicy) redactionForOrg(orgID int, salt string) *Redaction {
+	name, found := p.Orgs[strconv.Itoa(orgID)]
+	if !found {
+		name = p.Default
+	}
+	if name == "" {
+		return nil
+	}
+	return &Redaction{Name: name, Fields: p.Profiles[name], salt: salt}
+}
+
+// applyRedactionPolicy loads our redaction policy, if configured, and sets the redaction profile of each of the passed
+// in orgs, and if we pseudonymize the URNs of anonymous orgs, the salt of each of those
+func applyRedactionPolicy(conf *Config, orgs []Org) error {
+	if conf.PseudonymizeAnonURNs {
+		for i := range orgs {
+			if orgs[i].IsAnon {
+				orgs[i].urnSalt = orgSalt(conf.RedactionSalt, orgs[i].ID)
+			}
+		}
+	}
+
+	if conf.Redaction == "" {
+		return nil
+	}
+
+	policy, err := LoadRedactionPolicy(conf.Redaction)
+	if err != nil {
+		return err
+	}
+
+	for i := range orgs {
+		orgs[i].Redaction = policy.redactionForOrg(orgs[i].ID, conf.RedactionSalt)
+	}
+	return nil
+}
+
+// profileFor returns the name of this redaction profile if it redacts any fields of the passed in archive type, nil if
+// it doesn't, this is what is recorded on archives of that type
+func (r *Redaction) profileFor(archiveType ArchiveType) *string {
+	if r == nil || len(r.Fields[archiveType]) == 0 {
+		return nil
+	}
+	name := r.Name
+	return &name
+}
+
+// redactor returns a function which redacts records of the passed in type for the passed in org with this profile,
+// nil if it doesn't redact any fields of that type
+func (r *Redaction) redactor(orgID int, archiveType ArchiveType) func(string) (string, error) {
+	if r.profileFor(archiveType) == nil {
+		return nil
+	}
+
+	// apply our redactions in a consistent order
+	actions := r.Fields[archiveType]
+	paths := make([]string, 0, len(actions))
+	for path := range actions {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	salt := orgSalt(r.salt, orgID)
+
+	return func(record string) (string, error) {
+		redacted := []byte(record)
+		for _, path := range paths {
+			var err error
+			redacted, err = redactPath(redacted, strings.Split(path, "."), actions[path], salt)
+			if err != nil {
+				return "", errors.Wrapf(err, "error redacting %s", path)
+			}
+		}
+		return string(redacted), nil
+	}
+}
+
+// orgSalt returns the salt values of the passed in org are hashed with, derived from the passed in secret
+func orgSalt(secret string, orgID int) []byte {
+	key := hmac.New(sha256.New, []byte(secret))
+	key.Write([]byte(strconv.Itoa(orgID)))
+	return key.Sum(nil)
+}
+
+// leavesOutURNs returns whether the URNs of this org are left out of the records of its messages, which they are for
+// anonymous orgs unless we pseudonymize them
+func (o *Org) leavesOutURNs() bool {
+	return o.IsAnon && o.urnSalt == nil
+}
+
+// pseudonymizeURN replaces the URN in the passed in message record with a hash of it keyed by the salt of its org, the
+// same as redacting it with hash, so that the messages of a contact can be matched up across archives without
+// revealing the URN. Records of orgs whose URNs aren't pseudonymized are returned as they are.
+func (o *Org) pseudonymizeURN(record string) (string, error) {
+	if !o.IsAnon || o.urnSalt == nil {
+		return record, nil
+	}
+	pseudonymized, err := redactPath([]byte(record), []string{"urn"}, RedactHash, o.urnSalt)
+	if err != nil {
+		return "", errors.Wrapf(err, "error pseudonymizing urn")
+	}
+	return string(pseudonymized), nil
+}
+
+// redactPath applies the passed in action to the field at the passed in path within the passed in JSON value,
+// returning the value unchanged if it isn't an object or array. Only the objects and arrays along the path are
+// rebuilt, the rest of the value is kept exactly as it was.
+func redactPath(value []byte, path []string, action RedactionAction, salt []byte) ([]byte, error) {
+	value = bytes.TrimSpace(value)
+	if len(value) == 0 || (value[0] != '{' && value[0] != '[') {
+		return value, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+
+	out := &bytes.Buffer{}
+	out.WriteByte(value[0])
+	first := true
+	for decoder.More() {
+		var key string
+		if value[0] == '{' {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, _ = token.(string)
+		}
+
+		var child json.RawMessage
+		if err := decoder.Decode(&child); err != nil {
+			return nil, err
+		}
+		redacted := []byte(child)
+
+		var err error
+		if value[0] == '[' {
+			// arrays are redacted element by element
+			redacted, err = redactPath(redacted, path, action, salt)
+		} else if key == path[0] || path[0] == "*" {
+			if len(path) > 1 {
+				redacted, err = redactPath(redacted, path[1:], action, salt)
+			} else if action == RedactDrop {
+				continue
+			} else {
+				redacted, err = redactValue(redacted, action, salt)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !first {
+			out.WriteByte(',')
+		}
+		first = false
+		if value[0] == '{' {
+			out.WriteString(jsonbString(key))
+			out.WriteByte(':')
+		}
+		out.Write(redacted)
+	}
+	if value[0] == '{' {
+		out.WriteByte('}')
+	} else {
+		out.WriteByte(']')
+	}
+	return out.Bytes(), nil
+}
+
+// redactValue masks or hashes the passed in JSON value, nulls are left as they are
+func redactValue(value []byte, action RedactionAction, salt []byte) ([]byte, error) {
+	if string(value) == "null" {
+		return value, nil
+	}
+	if action == RedactMask {
+		return []byte(redactionMask), nil
+	}
+
+	// strings are hashed by their contents, anything else by its JSON
+	hashed := value
+	if value[0] == '"' {
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			return nil, err
+		}
+		hashed = []byte(s)
+	}
+
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(hashed)
+	return []byte(jsonbString(hex.EncodeToString(mac.Sum(nil)))), nil
+}
+
+// rollupRedaction returns the redaction profile to record on a monthly archive rolled up from the passed in dailies,
+// which is the profiles of its dailies, in order and comma separated if they differ, nil if none were redacted
+func rollupRedaction(dailies []*Archive) *string {
+	names := make([]string, 0, 1)
+	seen := make(map[string]bool)
+	for _, d := range dailies {
+		if d.Redaction != nil && !seen[*d.Redaction] {
+			names = append(names, *d.Redaction)
+			seen[*d.Redaction] = true
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	name := strings.Join(names, ",")
+	return &name
+}
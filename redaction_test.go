@@ -0,0 +1,227 @@
+package archiver
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactionPolicy(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	policyFile, err := ioutil.TempFile("", "redaction*.json")
+	assert.NoError(t, err)
+	defer os.Remove(policyFile.Name())
+
+	policyFile.WriteString(`{
+		"profiles": {
+			"strict": {"message": {"text": "drop", "urn": "hash", "contact.name": "mask"}},
+			"names": {"message": {"contact.name": "mask"}, "run": {"contact.name": "mask"}}
+		},
+		"default": "names",
+		"orgs": {"2": "strict", "3": ""}
+	}`)
+	policyFile.Close()
+
+	config := NewConfig()
+	config.Redaction = policyFile.Name()
+
+	// hashing needs a salt
+	assert.Equal(t, 1, len(config.Validate()))
+	config.RedactionSalt = "secret"
+	assert.Equal(t, 0, len(config.Validate()))
+
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+
+	// org 1 gets our default, org 2 its own profile and org 3 has none
+	assert.Equal(t, "names", orgs[0].Redaction.Name)
+	assert.Equal(t, "strict", *orgs[1].Redaction.profileFor(MessageType))
+	assert.Nil(t, orgs[1].Redaction.profileFor(RunType))
+	assert.Nil(t, orgs[1].Redaction.redactor(orgs[1].ID, RunType))
+	assert.Nil(t, orgs[2].Redaction)
+	assert.Nil(t, orgs[2].Redaction.profileFor(MessageType))
+
+	org, err := GetOrg(ctx, db, config, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "strict", org.Redaction.Name)
+
+	// our messages are redacted as they are archived and the profile recorded on the archive
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+	tasks, err := GetMissingDailyArchives(ctx, db, now, orgs[1], MessageType)
+	assert.NoError(t, err)
+	task := tasks[2]
+
+	for _, recordJSON := range []string{RecordJSONDB, RecordJSONGo} {
+		config.RecordJSON = recordJSON
+
+		err = createArchiveFile(ctx, db, task, "/tmp", config, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, task.RecordCount)
+		assert.Equal(t, "strict", *task.Redaction)
+
+		f, err := os.Open(task.ArchiveFile)
+		assert.NoError(t, err)
+		gz, err := gzip.NewReader(f)
+		assert.NoError(t, err)
+
+		records := make([]map[string]interface{}, 0)
+		scanner := bufio.NewScanner(gz)
+		for scanner.Scan() {
+			record := make(map[string]interface{})
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+			records = append(records, record)
+		}
+		f.Close()
+
+		if assert.Equal(t, 3, len(records)) {
+			assert.NotContains(t, records[0], "text")
+			assert.Equal(t, "a9fb8b73638f4907fe0260a39212c6e2e1356ed2e4c29a78438c89f9aa22b8a0", records[0]["urn"])
+			assert.Equal(t, map[string]interface{}{"uuid": "3e814add-e614-41f7-8b5d-a07f670a698f", "name": "***"}, records[0]["contact"])
+			assert.Equal(t, float64(3), records[1]["id"])
+			assert.Equal(t, 2, len(records[1]["attachments"].([]interface{})))
+			assert.Nil(t, records[2]["urn"])
+		}
+	}
+
+	err = WriteArchiveToDB(ctx, db, task)
+	assert.NoError(t, err)
+	DeleteArchiveFile(task)
+
+	archive, err := GetArchive(ctx, db, task.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "strict", *archive.Redaction)
+
+	// redacted archives can't be restored
+	_, err = RestoreArchive(ctx, db, nil, archive, "public", false)
+	assert.EqualError(t, err, fmt.Sprintf("cannot restore archive %d, its records were redacted with profile: strict", archive.ID))
+
+	// invalid policies are rejected
+	config.Redaction = "missing.json"
+	_, err = GetActiveOrgs(ctx, db, config)
+	assert.Error(t, err)
+}
+
+func TestLoadRedactionPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		policy string
+		err    string
+	}{
+		{`{"profiles": {"p": {"message": {"text": "erase"}}}}`, "invalid redaction in profile p: unknown action 'erase' for text, must be drop, mask or hash"},
+		{`{"profiles": {"p": {"message": {"id": "mask"}}}}`, "invalid redaction in profile p: required field 'id' of message records can't be redacted"},
+		{`{"profiles": {"p": {"run": {"contact..name": "mask"}}}}`, "invalid redaction in profile p: invalid field path 'contact..name'"},
+		{`{"profiles": {"p": {"event": {"text": "mask"}}}}`, "invalid redaction in profile p: unknown archive type: event"},
+		{`{"profiles": {"a,b": {"message": {"text": "mask"}}}}`, "invalid redaction profile name 'a,b', must be 1 to 64 characters without commas"},
+		{`{"profiles": {}, "default": "strict"}`, "unknown default redaction profile 'strict'"},
+		{`{"profiles": {}, "orgs": {"abc": ""}}`, "invalid org id 'abc' in redaction policy"},
+		{`{"profiles": {}, "orgs": {"2": "strict"}}`, "unknown redaction profile 'strict' for org 2"},
+	} {
+		policyFile, err := ioutil.TempFile("", "redaction*.json")
+		assert.NoError(t, err)
+		policyFile.WriteString(tc.policy)
+		policyFile.Close()
+
+		_, err = LoadRedactionPolicy(policyFile.Name())
+		assert.EqualError(t, err, tc.err)
+		os.Remove(policyFile.Name())
+	}
+}
+
+func TestRedactRecords(t *testing.T) {
+	redaction := &Redaction{
+		Name: "test",
+		Fields: map[ArchiveType]map[string]RedactionAction{
+			RunType: {"values.*.input": RedactMask, "contact.name": RedactHash, "path": RedactDrop, "events.msg.urn": RedactMask},
+		},
+		salt: "secret",
+	}
+	redact := redaction.redactor(2, RunType)
+
+	// only the objects along the paths of redacted fields are rebuilt
+	redacted, err := redact(`{"id":1,"contact":{"uuid":"c1","name":null},"path":[{"node": "n1"}],"values":{"color": {"input": "red", "value": "red"}, "age": {"input": null, "value": "12"}},"events":[{"type": "msg_created", "msg": {"urn": "tel:+12067797777", "text": "hi"}}, {"type": "flow_entered"}]}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":1,"contact":{"uuid":"c1","name":null},"values":{"color":{"input":"***","value":"red"},"age":{"input":null,"value":"12"}},"events":[{"type":"msg_created","msg":{"urn":"***","text":"hi"}},{"type":"flow_entered"}]}`, redacted)
+
+	// hashes are keyed by org
+	redacted, err = redact(`{"id":1,"contact":{"uuid":"c1","name":"tel:+12067797777"}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":1,"contact":{"uuid":"c1","name":"a9fb8b73638f4907fe0260a39212c6e2e1356ed2e4c29a78438c89f9aa22b8a0"}}`, redacted)
+
+	redacted, err = redaction.redactor(3, RunType)(`{"id":1,"contact":{"uuid":"c1","name":"tel:+12067797777"}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":1,"contact":{"uuid":"c1","name":"9e3d896eec523be4feeaa0f50602fc8e26090767343032e043f847c633ec0011"}}`, redacted)
+
+	_, err = redact(`{"id":1,"contact":{"uuid":`)
+	assert.Error(t, err)
+
+	// monthlies record the profiles of their dailies
+	strict, names := "strict", "names"
+	assert.Nil(t, rollupRedaction([]*Archive{{}, {}}))
+	assert.Equal(t, "strict", *rollupRedaction([]*Archive{{Redaction: &strict}, {Redaction: &strict}}))
+	assert.Equal(t, "strict,names", *rollupRedaction([]*Archive{{Redaction: &strict}, {}, {Redaction: &names}, {Redaction: &strict}}))
+}
+
+func TestPseudonymizeAnonURNs(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	config.PseudonymizeAnonURNs = true
+
+	// pseudonymizing needs a salt
+	assert.Equal(t, 1, len(config.Validate()))
+	config.RedactionSalt = "secret"
+	assert.Equal(t, 0, len(config.Validate()))
+
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+
+	// only anonymous orgs are pseudonymized
+	assert.False(t, orgs[0].leavesOutURNs())
+	assert.Nil(t, orgs[0].urnSalt)
+	assert.False(t, orgs[2].leavesOutURNs())
+	assert.NotNil(t, orgs[2].urnSalt)
+
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+	tasks, err := GetMissingDailyArchives(ctx, db, now, orgs[2], MessageType)
+	assert.NoError(t, err)
+	task := tasks[0]
+
+	for _, recordJSON := range []string{RecordJSONDB, RecordJSONGo} {
+		config.RecordJSON = recordJSON
+
+		err = createArchiveFile(ctx, db, task, "/tmp", config, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, task.RecordCount)
+		assert.Nil(t, task.Redaction)
+
+		f, err := os.Open(task.ArchiveFile)
+		assert.NoError(t, err)
+		gz, err := gzip.NewReader(f)
+		assert.NoError(t, err)
+		record := make(map[string]interface{})
+		assert.NoError(t, json.NewDecoder(gz).Decode(&record))
+		f.Close()
+		DeleteArchiveFile(task)
+
+		// the URN is hashed the same as redacting it with hash would
+		assert.Equal(t, "2c8ee2dd0740b52073678b6455f1f87b4ea36d816b93628efddde6f117232dc4", record["urn"])
+	}
+
+	// without pseudonymizing, URNs of anonymous orgs are left out
+	orgs[2].urnSalt = nil
+	assert.True(t, orgs[2].leavesOutURNs())
+
+	record, err := orgs[2].pseudonymizeURN(`{"id":5,"urn":null}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":5,"urn":null}`, record)
+}
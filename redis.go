@@ -0,0 +1,66 @@
+package archiver
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+// Redis is a pool of connections to the Redis server our task queue is kept on. Connections are reused between
+// commands, and each blocking command holds its own, so blocking commands from different workers never wait on each
+// other.
+type Redis struct {
+	pool *redis.Pool
+}
+
+// NewRedis creates a new Redis client from the passed in URL, ie: redis://:password@localhost:6379/15, connecting over
+// TLS if its scheme is rediss. No connection is made until the first command is sent.
+func NewRedis(redisURL string) (*Redis, error) {
+	u, err := url.Parse(redisURL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Host == "" {
+		return nil, errors.Errorf("invalid redis URL: %s", redisURL)
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if _, err := strconv.Atoi(db); err != nil {
+			return nil, errors.Errorf("invalid redis database in URL: %s", redisURL)
+		}
+	}
+
+	pool := &redis.Pool{
+		MaxIdle:     4,
+		IdleTimeout: time.Minute * 4,
+		Dial: func() (redis.Conn, error) {
+			return redis.DialURL(redisURL, redis.DialConnectTimeout(time.Second*10), redis.DialReadTimeout(time.Minute), redis.DialWriteTimeout(time.Minute))
+		},
+		TestOnBorrow: func(conn redis.Conn, lastUsed time.Time) error {
+			if time.Since(lastUsed) < time.Minute {
+				return nil
+			}
+			_, err := conn.Do("PING")
+			return err
+		},
+	}
+	return &Redis{pool: pool}, nil
+}
+
+// Do sends the passed in command on a connection from our pool and returns its reply. The timeout should allow for
+// however long a blocking command may block.
+func (r *Redis) Do(timeout time.Duration, command string, args ...interface{}) (interface{}, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	reply, err := redis.DoWithTimeout(conn, timeout, command, args...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error running redis command: %s", command)
+	}
+	return reply, nil
+}
+
+// Close closes the connections of our pool
+func (r *Redis) Close() error {
+	return r.pool.Close()
+}
@@ -0,0 +1,139 @@
+package archiver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRedis(t *testing.T) {
+	_, err := NewRedis("redis://localhost:6379/15")
+	assert.NoError(t, err)
+
+	_, err = NewRedis("redis://:sesame@redis.example.com")
+	assert.NoError(t, err)
+
+	_, err = NewRedis("rediss://:sesame@redis.example.com:6380/2")
+	assert.NoError(t, err)
+
+	_, err = NewRedis("http://localhost:6379")
+	assert.EqualError(t, err, "invalid redis URL: http://localhost:6379")
+
+	_, err = NewRedis("redis://localhost:6379/foo")
+	assert.EqualError(t, err, "invalid redis database in URL: redis://localhost:6379/foo")
+}
+
+func TestTaskQueue(t *testing.T) {
+	redis, err := NewRedis("redis://localhost:6379/15")
+	assert.NoError(t, err)
+
+	// only run against a local Redis if there is one
+	if _, err := redis.Do(time.Second, "FLUSHDB"); err != nil {
+		t.Skip("no local redis")
+	}
+
+	queue := NewTaskQueue(redis, "test:orgs")
+	orgs := []Org{{ID: 1}, {ID: 2}}
+
+	queued, err := queue.StartRun("2018-01-08T02:00", orgs, false)
+	assert.NoError(t, err)
+	assert.True(t, queued)
+
+	// a second instance starting the same run doesn't queue again
+	queued, err = queue.StartRun("2018-01-08T02:00", orgs, false)
+	assert.NoError(t, err)
+	assert.False(t, queued)
+
+	// and the run isn't finished while it still has tasks
+	finished, err := queue.FinishRun("2018-01-08T02:00")
+	assert.NoError(t, err)
+	assert.False(t, finished)
+
+	depth, err := queue.Depth()
+	assert.NoError(t, err)
+	assert.Equal(t, &QueueDepth{Queued: 2}, depth)
+
+	// tasks come out in the order they were queued
+	task1, err := queue.Pop(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, task1.OrgID)
+
+	task2, err := queue.Pop(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, task2.OrgID)
+
+	depth, err = queue.Depth()
+	assert.NoError(t, err)
+	assert.Equal(t, &QueueDepth{Taken: 2}, depth)
+
+	assert.NoError(t, queue.Complete(task1))
+
+	// org 2 fails, is retried once then fails for good
+	requeued, err := queue.Retry(task2, 1)
+	assert.NoError(t, err)
+	assert.True(t, requeued)
+
+	task2, err = queue.Pop(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, task2.OrgID)
+	assert.Equal(t, 1, task2.Attempts)
+
+	requeued, err = queue.Retry(task2, 1)
+	assert.NoError(t, err)
+	assert.False(t, requeued)
+
+	depth, err = queue.Depth()
+	assert.NoError(t, err)
+	assert.Equal(t, &QueueDepth{Failed: 1}, depth)
+
+	// nothing left to take
+	task, err := queue.Pop(time.Second)
+	assert.NoError(t, err)
+	assert.Nil(t, task)
+
+	// so the run is finished, and starting it again, ie: after it failed, queues again
+	finished, err = queue.FinishRun("2018-01-08T02:00")
+	assert.NoError(t, err)
+	assert.True(t, finished)
+
+	queued, err = queue.StartRun("2018-01-08T02:00", orgs, false)
+	assert.NoError(t, err)
+	assert.True(t, queued)
+
+	// as does forcing a run which was already started
+	queued, err = queue.StartRun("2018-01-08T02:00", orgs, true)
+	assert.NoError(t, err)
+	assert.True(t, queued)
+
+	depth, err = queue.Depth()
+	assert.NoError(t, err)
+	assert.Equal(t, &QueueDepth{Queued: 2}, depth)
+
+	// tasks which are still being renewed aren't queued again
+	_, err = redis.Do(time.Second, "FLUSHDB")
+	assert.NoError(t, err)
+	_, err = queue.StartRun("2018-01-09T02:00", orgs, false)
+	assert.NoError(t, err)
+
+	task1, err = queue.Pop(time.Second)
+	assert.NoError(t, err)
+	reaped, err := queue.Requeue(time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, reaped)
+
+	// but those of instances which have stopped renewing them are, ahead of those never taken
+	_, err = redis.Do(time.Second, "ZADD", "test:orgs:renewed", time.Now().Add(-time.Hour).Unix(), task1.raw)
+	assert.NoError(t, err)
+	reaped, err = queue.Requeue(time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reaped)
+
+	depth, err = queue.Depth()
+	assert.NoError(t, err)
+	assert.Equal(t, &QueueDepth{Queued: 2}, depth)
+
+	task, err = queue.Pop(time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, task.OrgID)
+}
@@ -0,0 +1,57 @@
+package archiver
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// on a replica this is the commit time of the last transaction it replayed, which is null until it replays one, and
+// on a primary it is now as it is never behind
+const lookupReplicaReplayed = `
+SELECT CASE WHEN pg_is_in_recovery() THEN pg_last_xact_replay_timestamp() ELSE NOW() END
+`
+
+// GetReplicaReplayed returns how far the passed in replica has replayed the primary, ie: the time of the last
+// transaction it has applied, nil if it hasn't applied any since it started
+func GetReplicaReplayed(ctx context.Context, replica *sqlx.DB) (*time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	var replayed *time.Time
+	err := replica.GetContext(ctx, &replayed, lookupReplicaReplayed)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up replica replay position")
+	}
+	return replayed, nil
+}
+
+// exportDB returns the database the records of the passed in archive should be exported from, the passed in replica if
+// there is one and it has replayed past the end of the archive's period, otherwise the primary
+func exportDB(ctx context.Context, db *sqlx.DB, replica *sqlx.DB, archive *Archive) *sqlx.DB {
+	if replica == nil {
+		return db
+	}
+
+	log := logrus.WithFields(logrus.Fields{
+		"org_id":       archive.Org.ID,
+		"archive_type": archive.ArchiveType,
+		"start_date":   archive.StartDate,
+		"end_date":     archive.endDate(),
+		"period":       archive.Period,
+	})
+
+	replayed, err := GetReplicaReplayed(ctx, replica)
+	if err != nil {
+		log.WithError(err).Warn("unable to check replica lag, exporting from primary")
+		return db
+	}
+	if replayed == nil || replayed.Before(archive.endDate()) {
+		log.WithField("replayed", replayed).Warn("replica is behind the end of the archive period, exporting from primary")
+		return db
+	}
+	return replica
+}
@@ -0,0 +1,235 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// reportLinkExpiration is how long the download links in org reports are valid for, the longest S3 allows
+const reportLinkExpiration = time.Hour * 24 * 7
+
+// sendMail is how we send email, replaced in tests
+var sendMail = smtp.SendMail
+
+// OrgReport is a summary of what was archived and purged for an org over a month
+type OrgReport struct {
+	Org      Org
+	Month    DateRange
+	Archived []*Archive
+	Purged   []*Archive
+}
+
+// IsEmpty returns whether nothing was archived or purged for the org in this report's month
+func (r *OrgReport) IsEmpty() bool {
+	return len(r.Archived) == 0 && len(r.Purged) == 0
+}
+
+const lookupArchivesCreatedInRange = selectArchiveFields + `
+WHERE org_id = $1 AND created_on >= $2 AND created_on < $3
+ORDER BY archive_type, start_date, period
+`
+
+const lookupArchivesPurgedInRange = selectArchiveFields + `
+WHERE org_id = $1 AND purged_on >= $2 AND purged_on < $3
+ORDER BY archive_type, start_date, period
+`
+
+// BuildOrgReport builds the report of what was archived and purged for the passed in org during the passed in month
+func BuildOrgReport(ctx context.Context, db *sqlx.DB, org Org, month DateRange) (*OrgReport, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	report := &OrgReport{Org: org, Month: month, Archived: make([]*Archive, 0), Purged: make([]*Archive, 0)}
+
+	err := db.SelectContext(ctx, &report.Archived, lookupArchivesCreatedInRange, org.ID, month.Start, month.End)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up archives created for org: %d", org.ID)
+	}
+
+	err = db.SelectContext(ctx, &report.Purged, lookupArchivesPurgedInRange, org.ID, month.Start, month.End)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up archives purged for org: %d", org.ID)
+	}
+
+	return report, nil
+}
+
+const lookupOrgAdminEmails = `
+SELECT u.email
+FROM orgs_org_administrators a
+JOIN auth_user u ON u.id = a.user_id
+WHERE a.org_id = $1 AND u.is_active = TRUE AND u.email != ''
+ORDER BY u.email
+`
+
+// GetOrgAdminEmails returns the email addresses of the active administrators of the passed in org
+func GetOrgAdminEmails(ctx context.Context, db *sqlx.DB, orgID int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	emails := make([]string, 0)
+	err := db.SelectContext(ctx, &emails, lookupOrgAdminEmails, orgID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up administrators for org: %d", orgID)
+	}
+	return emails, nil
+}
+
+// RenderOrgReport renders the passed in report as the subject and plain text body of an email. Archived files are
+// linked to directly, using presigned URLs if we have an S3 client.
+func RenderOrgReport(s3Client s3iface.S3API, report *OrgReport) (string, string, error) {
+	month := report.Month.Start.In(time.UTC).Format("January 2006")
+	subject := fmt.Sprintf("Archive report for %s, %s", report.Org.Name, month)
+
+	body := &bytes.Buffer{}
+	fmt.Fprintf(body, "This is a summary of what was archived for %s during %s.\n\n", report.Org.Name, month)
+
+	if len(report.Archived) == 0 {
+		fmt.Fprintf(body, "No new archives were created.\n")
+	} else {
+		fmt.Fprintf(body, "%d archives were created, containing %d records:\n\n", len(report.Archived), countRecords(report.Archived))
+		for _, a := range report.Archived {
+			link := a.URL
+			if s3Client != nil && link != "" {
+				presigned, err := PresignS3File(s3Client, a.URL, reportLinkExpiration)
+				if err != nil {
+					return "", "", errors.Wrapf(err, "error creating download link for archive: %d", a.ID)
+				}
+				link = presigned
+			}
+			fmt.Fprintf(body, " * %s\n   %s\n", describeReportArchive(a), link)
+		}
+		if s3Client != nil {
+			fmt.Fprintf(body, "\nThese download links expire after %d days.\n", int(reportLinkExpiration.Hours()/24))
+		}
+	}
+
+	if len(report.Purged) > 0 {
+		fmt.Fprintf(body, "\n%d archives were purged and can no longer be downloaded:\n\n", len(report.Purged))
+		for _, a := range report.Purged {
+			fmt.Fprintf(body, " * %s\n", describeReportArchive(a))
+		}
+	}
+
+	return subject, body.String(), nil
+}
+
+// describeReportArchive returns a one line description of the passed in archive for our reports
+func describeReportArchive(a *Archive) string {
+	period := "daily"
+	date := a.StartDate.In(time.UTC).Format("2006-01-02")
+	if a.Period == MonthPeriod {
+		period = "monthly"
+		date = a.StartDate.In(time.UTC).Format("2006-01")
+	}
+	return fmt.Sprintf("%s %s archive for %s (%d records)", period, a.ArchiveType, date, a.RecordCount)
+}
+
+// countRecords returns the total number of records in the passed in archives
+func countRecords(archives []*Archive) int {
+	count := 0
+	for _, a := range archives {
+		count += a.RecordCount
+	}
+	return count
+}
+
+// SendEmail sends a plain text email with the passed in subject and body using our configured SMTP server
+func SendEmail(config *Config, to []string, subject string, body string) error {
+	host, _, err := net.SplitHostPort(config.SMTPServer)
+	if err != nil {
+		return errors.Wrapf(err, "invalid SMTP server: %s", config.SMTPServer)
+	}
+
+	var auth smtp.Auth
+	if config.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, host)
+	}
+
+	msg := &bytes.Buffer{}
+	fmt.Fprintf(msg, "From: %s\r\n", config.EmailFrom)
+	fmt.Fprintf(msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+
+	err = sendMail(config.SMTPServer, auth, config.EmailFrom, to, msg.Bytes())
+	if err != nil {
+		return errors.Wrapf(err, "error sending email to: %s", strings.Join(to, ", "))
+	}
+	return nil
+}
+
+const lookupOrgReportSent = `
+SELECT EXISTS(SELECT 1 FROM archiver_org_reports WHERE org_id = $1 AND month = $2)
+`
+
+const insertOrgReportSent = `
+INSERT INTO archiver_org_reports(org_id, month, recipients, sent_on)
+VALUES($1, $2, $3, NOW())
+`
+
+// SendOrgReport emails the administrators of the passed in org the report for the month before the passed in time, if
+// it hasn't already been sent. Reports with nothing in them, or orgs without administrators, are recorded as handled
+// without sending anything. Returns whether an email was sent.
+func SendOrgReport(ctx context.Context, db *sqlx.DB, config *Config, s3Client s3iface.S3API, now time.Time, org Org) (bool, error) {
+	nowUTC := now.In(time.UTC)
+	thisMonth := time.Date(nowUTC.Year(), nowUTC.Month(), 1, 0, 0, 0, 0, time.UTC)
+	month := DateRange{Start: thisMonth.AddDate(0, -1, 0), End: thisMonth}
+
+	log := logrus.WithField("org_id", org.ID).WithField("month", month.Start.Format("2006-01"))
+
+	var sent bool
+	err := db.GetContext(ctx, &sent, lookupOrgReportSent, org.ID, month.Start)
+	if err != nil && err != sql.ErrNoRows {
+		return false, errors.Wrapf(err, "error checking for report for org: %d", org.ID)
+	}
+	if sent {
+		return false, nil
+	}
+
+	report, err := BuildOrgReport(ctx, db, org, month)
+	if err != nil {
+		return false, err
+	}
+
+	emails, err := GetOrgAdminEmails(ctx, db, org.ID)
+	if err != nil {
+		return false, err
+	}
+
+	recipients := 0
+	if !report.IsEmpty() && len(emails) > 0 {
+		subject, body, err := RenderOrgReport(s3Client, report)
+		if err != nil {
+			return false, err
+		}
+
+		err = SendEmail(config, emails, subject, body)
+		if err != nil {
+			return false, err
+		}
+		recipients = len(emails)
+		log.WithField("recipients", recipients).Info("sent org archive report")
+	}
+
+	_, err = db.ExecContext(ctx, insertOrgReportSent, org.ID, month.Start, recipients)
+	if err != nil {
+		return recipients > 0, errors.Wrapf(err, "error recording report for org: %d", org.ID)
+	}
+
+	return recipients > 0, nil
+}
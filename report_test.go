@@ -0,0 +1,76 @@
+package archiver
+
+import (
+	"context"
+	"net/smtp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrgReports(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	// the first of our archives for org 3 was purged in September
+	db.MustExec(`UPDATE archives_archive SET purged_on = '2017-09-20 10:00:00+00', record_count = 12 WHERE id = 1`)
+	db.MustExec(`UPDATE archives_archive SET url = 'https://s3-bucket.s3.amazonaws.com/3/message_D20170910_abc.jsonl.gz', record_count = 5 WHERE id = 2`)
+
+	emails, err := GetOrgAdminEmails(ctx, db, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ann@nyaruka.com"}, emails)
+
+	org := Org{ID: 3, Name: "Org 3"}
+	september := DateRange{Start: time.Date(2017, 9, 1, 0, 0, 0, 0, time.UTC), End: time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)}
+
+	report, err := BuildOrgReport(ctx, db, org, september)
+	assert.NoError(t, err)
+	assert.False(t, report.IsEmpty())
+	assert.Equal(t, 2, len(report.Archived))
+	assert.Equal(t, 1, len(report.Purged))
+	assert.Equal(t, 1, report.Purged[0].ID)
+
+	subject, body, err := RenderOrgReport(nil, report)
+	assert.NoError(t, err)
+	assert.Equal(t, "Archive report for Org 3, September 2017", subject)
+	assert.Contains(t, body, "2 archives were created, containing 5 records:")
+	assert.Contains(t, body, " * daily message archive for 2017-09-10 (5 records)\n   https://s3-bucket.s3.amazonaws.com/3/message_D20170910_abc.jsonl.gz\n")
+	assert.Contains(t, body, " * monthly message archive for 2017-09 (0 records)\n")
+	assert.Contains(t, body, "1 archives were purged and can no longer be downloaded:\n\n * daily message archive for 2017-08-10 (12 records)\n")
+
+	// capture our emails instead of sending them
+	var sentTo []string
+	var sentMsg string
+	defer func(f func(string, smtp.Auth, string, []string, []byte) error) { sendMail = f }(sendMail)
+	sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		sentTo = to
+		sentMsg = string(msg)
+		return nil
+	}
+
+	config := NewConfig()
+	config.SMTPServer = "smtp.nyaruka.com:587"
+	config.EmailFrom = "archiver@nyaruka.com"
+	now := time.Date(2017, 10, 5, 12, 0, 0, 0, time.UTC)
+
+	sent, err := SendOrgReport(ctx, db, config, nil, now, org)
+	assert.NoError(t, err)
+	assert.True(t, sent)
+	assert.Equal(t, []string{"greg@gmail.com"}, sentTo)
+	assert.Contains(t, sentMsg, "From: archiver@nyaruka.com\r\n")
+	assert.Contains(t, sentMsg, "Subject: Archive report for Org 3, September 2017\r\n")
+
+	// only ever sent once for a month
+	sentTo = nil
+	sent, err = SendOrgReport(ctx, db, config, nil, now.AddDate(0, 0, 1), org)
+	assert.NoError(t, err)
+	assert.False(t, sent)
+	assert.Nil(t, sentTo)
+
+	// nothing happened for org 2 in September, so nothing is sent
+	sent, err = SendOrgReport(ctx, db, config, nil, now, Org{ID: 2, Name: "Org 2"})
+	assert.NoError(t, err)
+	assert.False(t, sent)
+	assert.Nil(t, sentTo)
+}
@@ -0,0 +1,272 @@
+package archiver
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Restoring puts the records of archives back into the database, either into the live tables or into copies of them
+// in a staging schema from where they can be inspected and copied over selectively. Archives don't contain everything
+// in the original rows, so contacts, channels, URNs, flows, labels and users are looked up again by their UUIDs or
+// names, and fields which weren't archived are given defaults. Broadcasts are deleted once all of their messages are,
+// so messages whose broadcast no longer exists are restored without one. Records whose contact or flow no longer exists can't be
+// restored and are skipped, as are records whose id is already taken, unless we've been asked to fail instead. URNs
+// and run events aren't archived for anonymous orgs so can't be restored for them.
+
+// restoreBatchSize is how many records we insert at a time when restoring an archive
+const restoreBatchSize = 1000
+
+// maxArchivedRecordSize is the largest single record we can read from an archive when restoring or searching it
+const maxArchivedRecordSize = 16 * 1024 * 1024
+
+var restoreSchemaRegex = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// RestoreResult is the result of restoring the records of a single archive
+type RestoreResult struct {
+	Archive  *Archive
+	Records  int
+	Restored int
+	Skipped  int
+}
+
+// restoredTables are the tables records are restored to for each archive type, in the order they must be created
+var restoredTables = map[ArchiveType][]string{
+	MessageType: {"msgs_msg", "msgs_msg_labels"},
+	RunType:     {"flows_flowrun"},
+}
+
+const createRestoreSchema = `CREATE SCHEMA IF NOT EXISTS %s`
+
+const createRestoreTable = `CREATE TABLE IF NOT EXISTS %s.%s (LIKE public.%s INCLUDING DEFAULTS INCLUDING INDEXES)`
+
+const restoreMessages = `
+INSERT INTO %s.msgs_msg(id, broadcast_id, text, high_priority, created_on, modified_on, sent_on, direction, status, visibility,
+	msg_type, msg_count, error_count, next_attempt, attachments, channel_id, contact_id, contact_urn_id, org_id)
+SELECT
+	(r->>'id')::integer,
+	b.id,
+	r->>'text',
+	FALSE,
+	(r->>'created_on')::timestamptz,
+	(r->>'modified_on')::timestamptz,
+	(r->>'sent_on')::timestamptz,
+	CASE r->>'direction' WHEN 'in' THEN 'I' ELSE 'O' END,
+	CASE r->>'status'
+		WHEN 'initializing' THEN 'I'
+		WHEN 'queued' THEN 'Q'
+		WHEN 'wired' THEN 'W'
+		WHEN 'delivered' THEN 'D'
+		WHEN 'handled' THEN 'H'
+		WHEN 'errored' THEN 'E'
+		WHEN 'failed' THEN 'F'
+		WHEN 'sent' THEN 'S'
+		WHEN 'resent' THEN 'R'
+	END,
+	CASE r->>'visibility' WHEN 'archived' THEN 'A' ELSE 'V' END,
+	CASE r->>'type' WHEN 'flow' THEN 'F' WHEN 'ivr' THEN 'V' WHEN 'inbox' THEN 'I' END,
+	1,
+	0,
+	(r->>'created_on')::timestamptz,
+	ARRAY(SELECT (a->>'content_type') || ':' || (a->>'url') FROM jsonb_array_elements(r->'attachments') a),
+	ch.id,
+	c.id,
+	u.id,
+	$1
+FROM jsonb_array_elements($2::jsonb) r
+	JOIN contacts_contact c ON c.uuid = r->'contact'->>'uuid' AND c.org_id = $1
+	LEFT JOIN channels_channel ch ON ch.uuid = r->'channel'->>'uuid'
+	LEFT JOIN contacts_contacturn u ON u.identity = r->>'urn' AND u.contact_id = c.id
+	LEFT JOIN msgs_broadcast b ON b.id = (r->>'broadcast')::integer
+%s
+RETURNING id
+`
+
+const restoreMessageLabels = `
+INSERT INTO %s.msgs_msg_labels(msg_id, label_id)
+SELECT (r->>'id')::integer, ml.id
+FROM jsonb_array_elements($1::jsonb) r
+	CROSS JOIN LATERAL jsonb_array_elements(r->'labels') l
+	JOIN msgs_label ml ON ml.uuid = l->>'uuid'
+WHERE (r->>'id')::integer = ANY($2)
+`
+
+const restoreRuns = `
+INSERT INTO %s.flows_flowrun(id, is_active, uuid, responded, contact_id, flow_id, org_id, results, path, events, created_on,
+	modified_on, exited_on, submitted_by_id, exit_type)
+SELECT
+	(r->>'id')::integer,
+	FALSE,
+	r->>'uuid',
+	(r->>'responded')::boolean,
+	c.id,
+	f.id,
+	$1,
+	(SELECT coalesce(jsonb_object_agg(v.key, jsonb_build_object('name', v.value->'name', 'value', v.value->'value', 'input', v.value->'input', 'created_on', v.value->'time', 'category', v.value->'category', 'node_uuid', v.value->'node')), '{}'::jsonb)
+		FROM jsonb_each(r->'values') v)::text,
+	(SELECT coalesce(jsonb_agg(jsonb_build_object('node_uuid', p->'node', 'arrived_on', p->'time')), '[]'::jsonb)
+		FROM jsonb_array_elements(r->'path') p)::text,
+	coalesce(r->'events', '[]'::jsonb),
+	(r->>'created_on')::timestamptz,
+	(r->>'modified_on')::timestamptz,
+	(r->>'exited_on')::timestamptz,
+	a.id,
+	CASE r->>'exit_type' WHEN 'completed' THEN 'C' WHEN 'interrupted' THEN 'I' WHEN 'expired' THEN 'E' END
+FROM jsonb_array_elements($2::jsonb) r
+	JOIN contacts_contact c ON c.uuid = r->'contact'->>'uuid' AND c.org_id = $1
+	JOIN flows_flow f ON f.uuid = r->'flow'->>'uuid'
+	LEFT JOIN auth_user a ON a.username = r->>'submitted_by'
+%s
+RETURNING id
+`
+
+// skipConflicts is added to our restore inserts unless we've been asked to fail on records which already exist
+const skipConflicts = `ON CONFLICT (id) DO NOTHING`
+
+// RestoreArchive downloads the passed in archive and inserts its records into the tables of the passed in schema, which
+// are created as copies of the live tables if they don't exist, or into the live tables if schema is public. Records
+// whose id is already taken are skipped, or if failOnConflict is set, nothing is restored and an error is returned.
+// The archive is restored in a single transaction so either all of its records are restored or none are. Archives
+// whose records were redacted can't be restored.
+func RestoreArchive(ctx context.Context, db *sqlx.DB, s3Client s3iface.S3API, archive *Archive, schema string, failOnConflict bool) (*RestoreResult, error) {
+	// redacted records are missing fields or have values which aren't their own
+	if archive.Redaction != nil {
+		return nil, errors.Errorf("cannot restore archive %d, its records were redacted with profile: %s", archive.ID, *archive.Redaction)
+	}
+
+	// read the decompressed archive through a pipe, its hash is only verified once we reach the end of it, at which
+	// point our reader gets the error and we roll back
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(DownloadArchive(ctx, s3Client, archive, writer, true))
+	}()
+	defer reader.Close()
+
+	return restoreRecords(ctx, db, archive, reader, schema, failOnConflict)
+}
+
+// restoreRecords inserts the passed in decompressed records of the passed in archive into the tables of the passed in schema
+func restoreRecords(ctx context.Context, db *sqlx.DB, archive *Archive, reader io.Reader, schema string, failOnConflict bool) (*RestoreResult, error) {
+	if !restoreSchemaRegex.MatchString(schema) {
+		return nil, errors.Errorf("invalid restore schema: %s", schema)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Hour)
+	defer cancel()
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if schema != "public" {
+		err = createRestoreTables(ctx, tx, archive.ArchiveType, schema)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	conflicts := skipConflicts
+	if failOnConflict {
+		conflicts = ""
+	}
+
+	result := &RestoreResult{Archive: archive}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxArchivedRecordSize)
+
+	batch := make([]string, 0, restoreBatchSize)
+	for {
+		more := scanner.Scan()
+		if more && len(scanner.Bytes()) > 0 {
+			batch = append(batch, scanner.Text())
+		}
+
+		if len(batch) == restoreBatchSize || (!more && len(batch) > 0) {
+			restored, err := restoreBatch(ctx, tx, archive, schema, conflicts, batch)
+			if err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			result.Records += len(batch)
+			result.Restored += restored
+			batch = batch[:0]
+		}
+
+		if !more {
+			break
+		}
+	}
+	if scanner.Err() != nil {
+		tx.Rollback()
+		return nil, errors.Wrapf(scanner.Err(), "error reading archive: %d", archive.ID)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, errors.Wrapf(err, "error committing restore of archive: %d", archive.ID)
+	}
+
+	result.Skipped = result.Records - result.Restored
+	return result, nil
+}
+
+// createRestoreTables creates the tables we restore the passed in type of archive to in the passed in schema
+func createRestoreTables(ctx context.Context, tx *sqlx.Tx, archiveType ArchiveType, schema string) error {
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(createRestoreSchema, schema))
+	if err != nil {
+		return errors.Wrapf(err, "error creating restore schema: %s", schema)
+	}
+
+	for _, table := range restoredTables[archiveType] {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(createRestoreTable, schema, table, table))
+		if err != nil {
+			return errors.Wrapf(err, "error creating restore table: %s.%s", schema, table)
+		}
+	}
+	return nil
+}
+
+// restoreBatch inserts the passed in batch of records, returning how many were restored
+func restoreBatch(ctx context.Context, tx *sqlx.Tx, archive *Archive, schema string, conflicts string, batch []string) (int, error) {
+	records := "[" + strings.Join(batch, ",") + "]"
+
+	query := restoreMessages
+	if archive.ArchiveType == RunType {
+		query = restoreRuns
+	}
+
+	restoredIDs := make([]int64, 0, len(batch))
+	err := tx.SelectContext(ctx, &restoredIDs, fmt.Sprintf(query, schema, conflicts), archive.OrgID, records)
+	if err != nil {
+		return 0, errors.Wrapf(err, "error restoring %s records of archive: %d", archive.ArchiveType, archive.ID)
+	}
+
+	if archive.ArchiveType == MessageType && len(restoredIDs) > 0 {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(restoreMessageLabels, schema), records, pq.Array(restoredIDs))
+		if err != nil {
+			return 0, errors.Wrapf(err, "error restoring message labels of archive: %d", archive.ID)
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"archive_id":   archive.ID,
+		"org_id":       archive.OrgID,
+		"archive_type": archive.ArchiveType,
+		"records":      len(batch),
+		"restored":     len(restoredIDs),
+	}).Debug("restored batch")
+
+	return len(restoredIDs), nil
+}
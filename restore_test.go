@@ -0,0 +1,93 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestoreRecords(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	db.MustExec(`DROP SCHEMA IF EXISTS restored CASCADE`)
+
+	messages, err := ioutil.ReadFile("testdata/messages1.jsonl")
+	assert.NoError(t, err)
+	archive := &Archive{ID: 1, OrgID: 2, ArchiveType: MessageType}
+
+	// restore our messages to a staging schema
+	result, err := restoreRecords(ctx, db, archive, bytes.NewReader(messages), "restored", false)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.Records)
+	assert.Equal(t, 3, result.Restored)
+	assert.Equal(t, 0, result.Skipped)
+
+	var count int
+	db.Get(&count, `SELECT count(*) FROM restored.msgs_msg WHERE org_id = 2 AND id IN (1, 3, 9)`)
+	assert.Equal(t, 3, count)
+	db.Get(&count, `SELECT count(*) FROM restored.msgs_msg_labels`)
+	assert.Equal(t, 3, count)
+
+	// message 3 has an outgoing direction, its attachments and its URN looked up again
+	var direction, status string
+	var attachments []byte
+	var urnID *int
+	row := db.QueryRow(`SELECT direction, status, array_to_json(attachments), contact_urn_id FROM restored.msgs_msg WHERE id = 3`)
+	assert.NoError(t, row.Scan(&direction, &status, &attachments, &urnID))
+	assert.Equal(t, "O", direction)
+	assert.Equal(t, "H", status)
+	assert.Equal(t, `["image/png:https://foo.bar/image1.png","image/png:https://foo.bar/image2.png"]`, string(attachments))
+	assert.NotNil(t, urnID)
+
+	// restoring again skips them all as they already exist
+	result, err = restoreRecords(ctx, db, archive, bytes.NewReader(messages), "restored", false)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, result.Restored)
+	assert.Equal(t, 3, result.Skipped)
+
+	// unless we ask to fail, in which case nothing is restored
+	_, err = restoreRecords(ctx, db, archive, bytes.NewReader(messages), "public", true)
+	assert.Error(t, err)
+
+	// messages of broadcasts which have since been deleted are restored without their broadcast
+	db.MustExec(`DELETE FROM msgs_broadcast WHERE id = 1`)
+	message := strings.SplitN(string(messages), "\n", 2)[0]
+	broadcasted := strings.Replace(message, `"id":1,"broadcast":null`, `"id":100,"broadcast":1`, 1) + "\n" +
+		strings.Replace(message, `"id":1,"broadcast":null`, `"id":101,"broadcast":2`, 1) + "\n"
+
+	result, err = restoreRecords(ctx, db, archive, strings.NewReader(broadcasted), "public", false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Restored)
+
+	var broadcastID *int
+	db.Get(&broadcastID, `SELECT broadcast_id FROM msgs_msg WHERE id = 100`)
+	assert.Nil(t, broadcastID)
+	db.Get(&broadcastID, `SELECT broadcast_id FROM msgs_msg WHERE id = 101`)
+	assert.Equal(t, 2, *broadcastID)
+
+	// restore runs to the live tables after deleting them
+	runs, err := ioutil.ReadFile("testdata/runs1.jsonl")
+	assert.NoError(t, err)
+	archive = &Archive{ID: 2, OrgID: 2, ArchiveType: RunType}
+
+	db.MustExec(`DELETE FROM flows_flowrun WHERE id IN (1, 2)`)
+
+	result, err = restoreRecords(ctx, db, archive, bytes.NewReader(runs), "public", false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Restored)
+
+	var exitType, results string
+	row = db.QueryRow(`SELECT exit_type, results FROM flows_flowrun WHERE id = 2`)
+	assert.NoError(t, row.Scan(&exitType, &results))
+	assert.Equal(t, "C", exitType)
+	assert.Contains(t, results, `"category": "Strongly agree"`)
+
+	// invalid schemas are rejected
+	_, err = restoreRecords(ctx, db, archive, bytes.NewReader(runs), "public; DROP TABLE x", false)
+	assert.EqualError(t, err, "invalid restore schema: public; DROP TABLE x")
+}
@@ -0,0 +1,167 @@
+package archiver
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// RetentionRule describes if and when the archived records of one type are deleted for an org
+type RetentionRule struct {
+	// Delete is whether archived records are deleted at all
+	Delete bool `json:"delete"`
+
+	// DeleteAfterDays is the minimum age in days of the end of an archive's period before its records are deleted
+	DeleteAfterDays int `json:"delete_after_days"`
+
+	// KeepFlowLabels are flow labels whose runs are never deleted, ie: ["research"]
+	KeepFlowLabels []string `json:"keep_flow_labels"`
+}
+
+// RetentionPolicy is a set of retention rules, with defaults per archive type and overrides per org and archive type,
+// it is loaded from a JSON file such as:
+//
+//	{
+//	  "default": {"message": {"delete": true}, "run": {"delete": true}},
+//	  "orgs": {
+//	    "42": {
+//	      "message": {"delete": true, "delete_after_days": 365},
+//	      "run": {"delete": true, "delete_after_days": 730, "keep_flow_labels": ["research"]}
+//	    }
+//	  }
+//	}
+type RetentionPolicy struct {
+	Default map[ArchiveType]*RetentionRule            `json:"default"`
+	Orgs    map[string]map[ArchiveType]*RetentionRule `json:"orgs"`
+}
+
+// LoadRetentionPolicy loads and validates the retention policy in the passed in file
+func LoadRetentionPolicy(filename string) (*RetentionPolicy, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading retention policy file: %s", filename)
+	}
+
+	policy := &RetentionPolicy{}
+	err = json.Unmarshal(contents, policy)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing retention policy file: %s", filename)
+	}
+
+	for orgID, rules := range policy.Orgs {
+		_, err := strconv.Atoi(orgID)
+		if err != nil {
+			return nil, errors.Errorf("invalid org id '%s' in retention policy", orgID)
+		}
+		for archiveType, rule := range rules {
+			err = rule.validate(archiveType)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid retention rule for org %s", orgID)
+			}
+		}
+	}
+	for archiveType, rule := range policy.Default {
+		err = rule.validate(archiveType)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid default retention rule")
+		}
+	}
+
+	return policy, nil
+}
+
+func (r *RetentionRule) validate(archiveType ArchiveType) error {
+	if archiveType != MessageType && archiveType != RunType {
+		return errors.Errorf("unknown archive type: %s", archiveType)
+	}
+	if r.DeleteAfterDays < 0 {
+		return errors.Errorf("delete_after_days must be positive")
+	}
+	if archiveType != RunType && len(r.KeepFlowLabels) > 0 {
+		return errors.Errorf("keep_flow_labels is only valid for runs")
+	}
+	return nil
+}
+
+// rulesForOrg returns the rules which apply to the passed in org, org specific rules take precedence over defaults
+func (p *RetentionPolicy) rulesForOrg(orgID int) map[ArchiveType]*RetentionRule {
+	rules := make(map[ArchiveType]*RetentionRule)
+	for archiveType, rule := range p.Default {
+		rules[archiveType] = rule
+	}
+	for archiveType, rule := range p.Orgs[strconv.Itoa(orgID)] {
+		rules[archiveType] = rule
+	}
+	return rules
+}
+
+// applyRetentionPolicy sets the rules of our retention policy, if configured, for each of the passed in orgs. The
+// policy is the one loaded when our config was validated, configs which weren't validated load it each time.
+func applyRetentionPolicy(conf *Config, orgs []Org) error {
+	if conf.RetentionPolicy == "" {
+		return nil
+	}
+
+	policy := conf.retentionRules
+	if policy == nil {
+		var err error
+		policy, err = LoadRetentionPolicy(conf.RetentionPolicy)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i := range orgs {
+		orgs[i].RetentionRules = policy.rulesForOrg(orgs[i].ID)
+	}
+	return nil
+}
+
+// RetentionRuleFor returns the retention rule for the passed in org and type, orgs without a rule from our retention
+// policy fall back to our global delete setting. Rules can only keep records which our global delete setting would
+// delete, never delete those it keeps.
+func RetentionRuleFor(conf *Config, org Org, archiveType ArchiveType) *RetentionRule {
+	rule := org.RetentionRules[archiveType]
+	if rule == nil {
+		return &RetentionRule{Delete: conf.Delete}
+	}
+	if rule.Delete && !conf.Delete {
+		// rules can be shared between orgs so we return a copy
+		kept := *rule
+		kept.Delete = false
+		return &kept
+	}
+	return rule
+}
+
+// eligibleForDeletion returns whether the passed in archive is old enough for its records to be deleted under this rule
+func (r *RetentionRule) eligibleForDeletion(now time.Time, archive *Archive) bool {
+	return !archive.endDate().After(now.AddDate(0, 0, -r.DeleteAfterDays))
+}
+
+const selectFlowsWithLabels = `
+SELECT DISTINCT ffl.flow_id
+FROM flows_flow_labels ffl
+JOIN flows_flowlabel fl ON fl.id = ffl.flowlabel_id
+WHERE fl.org_id = $1 AND fl.name = ANY($2)
+`
+
+// keptFlowIDs returns the ids of the flows in the passed in org whose runs should never be deleted under this rule
+func (r *RetentionRule) keptFlowIDs(ctx context.Context, db *sqlx.DB, orgID int) ([]int64, error) {
+	flowIDs := make([]int64, 0)
+	if len(r.KeepFlowLabels) == 0 {
+		return flowIDs, nil
+	}
+
+	err := db.SelectContext(ctx, &flowIDs, selectFlowsWithLabels, orgID, pq.Array(r.KeepFlowLabels))
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up flows with labels for org: %d", orgID)
+	}
+	return flowIDs, nil
+}
@@ -0,0 +1,83 @@
+package archiver
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetentionPolicy(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	policyFile, err := ioutil.TempFile("", "retention*.json")
+	assert.NoError(t, err)
+	defer os.Remove(policyFile.Name())
+
+	policyFile.WriteString(`{
+		"default": {"message": {"delete": true}},
+		"orgs": {
+			"3": {
+				"message": {"delete": false},
+				"run": {"delete": true, "delete_after_days": 30, "keep_flow_labels": ["Research"]}
+			}
+		}
+	}`)
+	policyFile.Close()
+
+	config := NewConfig()
+	config.Delete = true
+	config.RetentionPolicy = policyFile.Name()
+
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+
+	// org 2 gets our default for messages and falls back to our delete setting for runs
+	assert.True(t, RetentionRuleFor(config, orgs[1], MessageType).Delete)
+	assert.True(t, RetentionRuleFor(config, orgs[1], RunType).Delete)
+
+	// org 3 has its own rules
+	assert.False(t, RetentionRuleFor(config, orgs[2], MessageType).Delete)
+	rule := RetentionRuleFor(config, orgs[2], RunType)
+	assert.True(t, rule.Delete)
+	assert.Equal(t, 30, rule.DeleteAfterDays)
+
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+	assert.True(t, rule.eligibleForDeletion(now, &Archive{StartDate: time.Date(2017, 12, 8, 0, 0, 0, 0, time.UTC), Period: DayPeriod}))
+	assert.False(t, rule.eligibleForDeletion(now, &Archive{StartDate: time.Date(2017, 12, 9, 0, 0, 0, 0, time.UTC), Period: DayPeriod}))
+	assert.False(t, rule.eligibleForDeletion(now, &Archive{StartDate: time.Date(2017, 12, 1, 0, 0, 0, 0, time.UTC), Period: MonthPeriod}))
+
+	// runs for flows labeled research are kept
+	flowIDs, err := rule.keptFlowIDs(ctx, db, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2}, flowIDs)
+
+	// rules can't delete anything our delete setting doesn't
+	config.Delete = false
+	assert.False(t, RetentionRuleFor(config, orgs[1], MessageType).Delete)
+	rule = RetentionRuleFor(config, orgs[2], RunType)
+	assert.False(t, rule.Delete)
+	assert.Equal(t, 30, rule.DeleteAfterDays)
+	assert.True(t, orgs[2].RetentionRules[RunType].Delete)
+
+	// our policy is loaded once when our config is validated, and not read again
+	assert.Equal(t, 0, len(config.Validate()))
+	os.Remove(policyFile.Name())
+	orgs, err = GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+	assert.Equal(t, 30, RetentionRuleFor(config, orgs[2], RunType).DeleteAfterDays)
+
+	// invalid policies are rejected
+	config = NewConfig()
+	config.RetentionPolicy = "missing.json"
+	_, err = GetActiveOrgs(ctx, db, config)
+	assert.Error(t, err)
+
+	ioutil.WriteFile(policyFile.Name(), []byte(`{"orgs": {"3": {"message": {"keep_flow_labels": ["Research"]}}}}`), 0600)
+	_, err = LoadRetentionPolicy(policyFile.Name())
+	assert.EqualError(t, err, "invalid retention rule for org 3: keep_flow_labels is only valid for runs")
+}
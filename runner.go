@@ -0,0 +1,757 @@
+package archiver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/glue/glueiface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// RunDatabase is one of the RapidPro databases a Runner archives, with its own config, connections, queue and where
+// the archives it creates are published and sent. Only DB and Config are required.
+type RunDatabase struct {
+	// Name is the name of the database in our databases file, empty if we archive a single database
+	Name   string
+	Config *Config
+	DB     *sqlx.DB
+
+	// Replica is a read replica records are exported from rather than DB
+	Replica *sqlx.DB
+
+	// Queue is a Redis queue shared with other instances which orgs are taken from, orgs are archived by this instance
+	// alone if nil
+	Queue *TaskQueue
+
+	// Publisher is given an event for each archive committed
+	Publisher Publisher
+
+	// Sinks are where archives are sent besides S3
+	Sinks *Sinks
+}
+
+// Log returns a logger for this database, with its name if it has one
+func (d *RunDatabase) Log() *logrus.Entry {
+	if d.Name == "" {
+		return logrus.NewEntry(logrus.StandardLogger())
+	}
+	return logrus.WithField("database", d.Name)
+}
+
+// QueueName returns the name of the Redis queue used to share runs of this database with other instances, each
+// database has its own as the ids of their orgs can be the same
+func (d *RunDatabase) QueueName() string {
+	if d.Name == "" {
+		return "archiver:orgs"
+	}
+	return "archiver:orgs:" + d.Name
+}
+
+// Options returns the options this database is archived with, exporting records from its read replica if it has one,
+// publishing events with its publisher and pausing with the passed in pauser
+func (d *RunDatabase) Options(pauser *Pauser) *Options {
+	return &Options{Replica: d.Replica, Pauser: pauser, Publisher: d.Publisher}
+}
+
+// Sinks are where the archives a Runner creates are sent besides S3, each only if it has been configured
+type Sinks struct {
+	Glue      glueiface.GlueAPI
+	Snowflake *Snowflake
+	Elastic   *Elastic
+}
+
+// NewSinks creates the sinks of the passed in config, if one can't be created the error is logged and archives aren't
+// sent to it
+func NewSinks(config *Config, log *logrus.Entry) *Sinks {
+	sinks := &Sinks{Elastic: NewElastic(config)}
+
+	var err error
+	if config.AthenaDatabase != "" {
+		sinks.Glue, err = NewGlueClient(config)
+		if err != nil {
+			log.WithError(err).Error("error creating glue client")
+		}
+	}
+
+	sinks.Snowflake, err = NewSnowflake(config)
+	if err != nil {
+		log.WithError(err).Error("error creating snowflake client")
+	}
+	return sinks
+}
+
+// Send registers the passed in archives, just created for an org and type, with Athena, loads them into Snowflake and
+// indexes their records in Elasticsearch, as each has been asked for. Failures are logged, the archives are still
+// created. It is safe to call on nil sinks.
+func (s *Sinks) Send(ctx context.Context, config *Config, db *sqlx.DB, s3Client s3iface.S3API, org Org, archiveType ArchiveType, created []*Archive, log *logrus.Entry) {
+	if s == nil || len(created) == 0 {
+		return
+	}
+
+	if config.AthenaDatabase != "" {
+		err := RegisterAthenaPartitions(ctx, db, s3Client, s.Glue, config, org, archiveType, created)
+		if err != nil {
+			log.WithError(err).WithField("archive_type", archiveType).Error("error registering athena partitions")
+		}
+	}
+
+	err := s.Snowflake.LoadArchives(ctx, archiveType, created)
+	if err != nil {
+		log.WithError(err).WithField("archive_type", archiveType).Error("error loading archives into snowflake")
+	}
+
+	err = s.Elastic.IndexArchives(ctx, s3Client, created)
+	if err != nil {
+		log.WithError(err).WithField("archive_type", archiveType).Error("error indexing archives in elasticsearch")
+	}
+}
+
+// RunTrigger lets a run be started before its scheduled time, ie: when requested over HTTP, it is safe for concurrent
+// use and a nil trigger is never triggered
+type RunTrigger struct {
+	ch chan struct{}
+}
+
+// NewRunTrigger creates a new run trigger
+func NewRunTrigger() *RunTrigger {
+	return &RunTrigger{ch: make(chan struct{}, 1)}
+}
+
+// Trigger requests a run, returning false if one has already been requested which hasn't started yet
+func (t *RunTrigger) Trigger() bool {
+	select {
+	case t.ch <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// C returns a channel which receives once for each run requested, nil if we are a nil trigger
+func (t *RunTrigger) C() <-chan struct{} {
+	if t == nil {
+		return nil
+	}
+	return t.ch
+}
+
+// Runner archives every active org of one or more databases in runs, with a pool of workers per database which retry
+// orgs that fail, or share the orgs of a run with other instances through a Redis queue, and in between runs can
+// archive the days which become eligible continuously. Only Databases is required.
+type Runner struct {
+	Databases    []*RunDatabase
+	S3Client     s3iface.S3API
+	Pauser       *Pauser
+	Stats        *Statsd
+	Status       *Status
+	Webhook      *Webhook
+	Selection    *OrgSelection
+	ArchiveTypes []ArchiveType
+
+	// Trigger stops us archiving incrementally when a run is requested before the next one is due
+	Trigger *RunTrigger
+}
+
+// RunResult is the outcome of a run over all of a Runner's databases
+type RunResult struct {
+	// Orgs are the orgs of the last database archived, those archived continuously after the run
+	Orgs []Org
+
+	OrgCount       int
+	ErrorCount     int
+	LaggingCount   int
+	RemainingCount int
+
+	// Completed is whether every org of every database was processed
+	Completed bool
+}
+
+// Run archives each of our databases in turn, the passed in key identifying this run to other instances sharing our
+// queues. If we can't get the orgs of a database we move on to the next, counting it as an error, unless it is our
+// only database when that error is returned.
+func (r *Runner) Run(ctx context.Context, start time.Time, runKey string) (*RunResult, error) {
+	result := &RunResult{Completed: true}
+
+	for _, d := range r.Databases {
+		if Draining(ctx) {
+			result.Completed = false
+			break
+		}
+
+		run, err := r.archiveDatabase(ctx, d, start, runKey)
+		if err != nil {
+			if len(r.Databases) == 1 {
+				return nil, err
+			}
+			d.Log().WithError(err).Error("error getting active orgs")
+			result.ErrorCount++
+			continue
+		}
+
+		result.Orgs = run.orgs
+		result.OrgCount += len(run.orgs)
+		result.ErrorCount += run.errorCount
+		result.LaggingCount += run.laggingCount
+		result.RemainingCount += len(run.summary.OrgsRemaining)
+		if run.summary.OrgsProcessed < len(run.orgs) {
+			result.Completed = false
+		}
+	}
+
+	r.Stats.Gauge("orgs", float64(result.OrgCount))
+	r.Stats.Count("org_errors", int64(result.ErrorCount))
+	r.Stats.Timing("run_elapsed", time.Since(start))
+
+	return result, nil
+}
+
+// databaseRun is the outcome of archiving a single database during a run
+type databaseRun struct {
+	orgs         []Org
+	summary      *RunSummary
+	errorCount   int
+	laggingCount int
+}
+
+// archiveDatabase archives the active orgs of the passed in database which we've been asked to archive, recording and
+// reporting the run. An error is only returned if we couldn't get its orgs to archive.
+func (r *Runner) archiveDatabase(ctx context.Context, d *RunDatabase, start time.Time, runKey string) (*databaseRun, error) {
+	config, db, taskQueue := d.Config, d.DB, d.Queue
+	now := config.Clock().Now()
+
+	// get our active orgs, limited to those we've been asked to archive
+	listCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	orgs, err := GetActiveOrgs(listCtx, db, config)
+	if err == nil {
+		orgs, err = FilterOrgs(listCtx, db, r.Selection, orgs)
+	}
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	// put them in the order we archive them, if we can't we archive them by id
+	sortCtx, cancel := context.WithTimeout(context.Background(), time.Minute*10)
+	err = SortOrgs(sortCtx, db, config, now, orgs, r.ArchiveTypes)
+	cancel()
+	if err != nil {
+		d.Log().WithError(err).WithField("org_order", config.OrgOrder).Error("error ordering orgs")
+	}
+
+	opts := d.Options(r.Pauser)
+
+	// find the missing archives of all our orgs at once, rather than with queries for each org as we archive it
+	for _, archiveType := range r.ArchiveTypes {
+		findCtx, cancel := context.WithTimeout(context.Background(), time.Minute*5)
+		missing, err := FindMissingArchives(findCtx, db, now, orgs, archiveType)
+		cancel()
+		if err != nil {
+			d.Log().WithError(err).WithField("archive_type", archiveType).Error("error finding missing archives, looking them up for each org instead")
+			continue
+		}
+		opts.Missing = append(opts.Missing, missing)
+		d.Log().WithField("archive_type", archiveType).WithField("missing", missing.Count()).Info("found missing archives")
+	}
+
+	errorCount, laggingCount := 0, 0
+	r.Status.StartRun(len(orgs))
+	summary := NewRunSummary(start)
+	summary.Database = d.Name
+
+	// record this run in our history if asked to, if we can't we still archive
+	var runID int64
+	if config.RunHistory {
+		runID, err = StartArchiverRun(context.Background(), db, config, start)
+		if err != nil {
+			d.Log().WithError(err).Error("error recording start of run")
+		}
+	}
+
+	// archive our orgs with a pool of workers, each pulling orgs off our queue until it is empty, which is either
+	// a channel fed with our orgs, or a queue in Redis shared with other instances
+	run := &orgRun{
+		database:     d,
+		s3Client:     r.S3Client,
+		opts:         opts,
+		stats:        r.Stats,
+		status:       r.Status,
+		summary:      summary,
+		runID:        runID,
+		archiveTypes: r.ArchiveTypes,
+		completed:    make(map[int]bool, len(orgs)),
+	}
+
+	// once our run budget is used up we stop starting new orgs, those already started carry on
+	runCtx, stopBudget := WithBudget(ctx, time.Duration(config.RunBudgetMinutes)*time.Minute, ErrRunBudgetExceeded)
+
+	countsMutex := sync.Mutex{}
+	record := func(failed int, lagging int) {
+		countsMutex.Lock()
+		errorCount += failed
+		laggingCount += lagging
+		countsMutex.Unlock()
+	}
+
+	if taskQueue != nil {
+		// when sharing a queue with other instances, the first to start this run queues all orgs for all to work on,
+		// unless we're running once, when we always start a new run
+		queued, err := taskQueue.StartRun(runKey, orgs, config.Once)
+		if err != nil {
+			d.Log().WithError(err).Error("error queuing orgs")
+		}
+
+		orgsByID := make(map[int]Org, len(orgs))
+		for _, org := range orgs {
+			orgsByID[org.ID] = org
+		}
+
+		depth, err := taskQueue.Depth()
+		if err == nil {
+			d.Log().WithField("queued", queued).WithField("queue_depth", depth.Queued).Info("taking orgs from queue")
+			r.Stats.Gauge("queue_depth", float64(depth.Queued))
+		}
+
+		waitGroup := sync.WaitGroup{}
+		for i := 0; i < config.OrgWorkers; i++ {
+			waitGroup.Add(1)
+			go func() {
+				defer waitGroup.Done()
+				run.archiveQueuedOrgs(runCtx, taskQueue, orgsByID, record)
+			}()
+		}
+		waitGroup.Wait()
+
+		// once every instance has finished with our queue, a later run with the same key queues our orgs again
+		finished, err := taskQueue.FinishRun(runKey)
+		if err != nil {
+			d.Log().WithError(err).Error("error finishing queued run")
+		} else if finished {
+			d.Log().WithField("run", runKey).Info("finished queued run")
+		}
+	} else {
+		// orgs which fail are retried at the end of the run, up to our configured number of retries
+		pending := orgs
+		for attempt := 0; ; attempt++ {
+			failed, failedCount, laggingCount := run.archiveOrgs(runCtx, pending, record)
+			if len(failed) == 0 || attempt >= config.TaskRetries || Draining(runCtx) {
+				record(failedCount, laggingCount)
+				break
+			}
+
+			d.Log().WithField("orgs", len(failed)).WithField("attempt", attempt+1).Info("retrying failed orgs")
+			pending = failed
+		}
+	}
+	stopBudget()
+
+	if DrainReason(runCtx) == ErrRunBudgetExceeded {
+		summary.OrgsRemaining = run.remaining(orgs)
+		d.Log().WithField("budget_minutes", config.RunBudgetMinutes).WithField("orgs_remaining", summary.OrgsRemaining).Warnf("run budget exceeded with %d orgs not archived", len(summary.OrgsRemaining))
+	}
+
+	r.Status.FinishRun(r.ArchiveTypes...)
+
+	summary.FinishedOn = time.Now()
+
+	if runID != 0 {
+		err = FinishArchiverRun(context.Background(), db, runID, summary)
+		if err != nil {
+			d.Log().WithError(err).Error("error recording end of run")
+		}
+	}
+
+	if config.RunSummary != "" {
+		err = WriteRunSummary(context.Background(), r.S3Client, config.RunSummary, summary)
+		if err != nil {
+			d.Log().WithError(err).Error("error writing run summary")
+		}
+	}
+
+	notifyCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	err = r.Webhook.NotifyRun(notifyCtx, summary)
+	cancel()
+	if err != nil {
+		d.Log().WithError(err).Error("error notifying webhook of run")
+	}
+
+	if Draining(ctx) && summary.OrgsProcessed < len(orgs) {
+		d.Log().WithField("orgs_processed", summary.OrgsProcessed).Warn("shut down before completing run of database")
+	}
+
+	return &databaseRun{orgs: orgs, summary: summary, errorCount: errorCount, laggingCount: laggingCount}, nil
+}
+
+// ArchiveIncrementally wakes up every ContinuousMinutes of the config of our first database until the passed in time,
+// archiving the days of the passed in orgs which have become eligible since the last time it did, starting with the
+// passed in time. As days only become eligible at midnight UTC, most wake ups have nothing to do. Returns early if a
+// run is requested with our trigger, and false if the passed in channel was closed, or the context drained, as we were
+// asked to shut down. Only our first database is archived continuously.
+func (r *Runner) ArchiveIncrementally(ctx context.Context, drain <-chan struct{}, orgs []Org, since time.Time, until time.Time) bool {
+	d := r.Databases[0]
+	interval := time.Duration(d.Config.ContinuousMinutes) * time.Minute
+	opts := d.Options(r.Pauser)
+
+	for {
+		wake := time.Now().Add(interval)
+		if wake.After(until) {
+			wake = until
+		}
+
+		select {
+		case <-drain:
+			return false
+		case <-r.Trigger.C():
+			return true
+		case <-time.After(time.Until(wake)):
+		}
+
+		if !wake.Before(until) {
+			return true
+		}
+
+		now := d.Config.Clock().Now()
+		if now.Format("2006-01-02") == since.In(time.UTC).Format("2006-01-02") {
+			continue
+		}
+
+		for _, org := range orgs {
+			WaitWhilePaused(ctx, r.Pauser)
+			if Draining(ctx) {
+				return false
+			}
+			r.archiveNewlyEligible(ctx, d, opts, org, since, now)
+		}
+		since = now
+	}
+}
+
+// archiveNewlyEligible archives the days of the passed in org which have become eligible between the passed in times,
+// unless another instance is archiving the org
+func (r *Runner) archiveNewlyEligible(ctx context.Context, d *RunDatabase, opts *Options, org Org, since time.Time, now time.Time) {
+	log := d.Log().WithField("org", org.Name).WithField("org_id", org.ID)
+
+	lock, err := TryLock(ctx, d.DB, OrgLockKey(org.ID))
+	if err != nil {
+		log.WithError(err).Error("error taking org lock")
+		return
+	}
+	if lock == nil {
+		return
+	}
+	defer func() {
+		err := lock.Release(context.Background())
+		if err != nil {
+			log.WithError(err).Error("error releasing org lock")
+		}
+	}()
+
+	for _, archiveType := range r.ArchiveTypes {
+		start := time.Now()
+		created, err := CreateNewlyEligibleArchives(ctx, since, now, d.Config, d.DB, r.S3Client, org, archiveType, opts)
+		if err != nil {
+			log.WithError(err).WithField("archive_type", archiveType).Errorf("error archiving newly eligible org %ss", archiveType)
+			continue
+		}
+		d.Sinks.Send(ctx, d.Config, d.DB, r.S3Client, org, archiveType, created, log)
+
+		if len(created) > 0 {
+			r.Stats.ReportOrgArchival(org, archiveType, created, nil, time.Since(start))
+			log.WithField("archive_type", archiveType).WithField("archives", len(created)).Info("archived newly eligible days")
+		}
+	}
+}
+
+// orgRun is everything needed to archive each org of a database during a single run, it is shared by all our workers
+type orgRun struct {
+	database     *RunDatabase
+	s3Client     s3iface.S3API
+	opts         *Options
+	stats        *Statsd
+	status       *Status
+	summary      *RunSummary
+	runID        int64
+	archiveTypes []ArchiveType
+
+	completedMutex sync.Mutex
+	completed      map[int]bool
+}
+
+// remaining returns the ids of the passed in orgs which this run hasn't archived
+func (r *orgRun) remaining(orgs []Org) []int {
+	r.completedMutex.Lock()
+	defer r.completedMutex.Unlock()
+
+	remaining := make([]int, 0)
+	for _, org := range orgs {
+		if !r.completed[org.ID] {
+			remaining = append(remaining, org.ID)
+		}
+	}
+	return remaining
+}
+
+// archiveOrg archives all types for the passed in org, unless we are shutting down, returning the number of types which
+// failed and the number which are lagging behind. A panic is treated as a failure of the org so that it doesn't take
+// down other workers.
+func (r *orgRun) archiveOrg(ctx context.Context, org Org) (failed int, lagging int, retry bool) {
+	config, db := r.database.Config, r.database.DB
+
+	// no single org should take more than 12 hours
+	ctx, cancel := context.WithTimeout(ctx, time.Hour*12)
+	defer cancel()
+
+	log := r.database.Log().WithField("org", org.Name).WithField("org_id", org.ID)
+
+	if Draining(ctx) {
+		return 0, 0, false
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			log.WithField("panic", p).Error("panic archiving org")
+			failed++
+			retry = true
+		}
+		// orgs which are retried are only counted once
+		r.completedMutex.Lock()
+		retried := r.completed[org.ID]
+		r.completed[org.ID] = true
+		r.completedMutex.Unlock()
+
+		if !retried {
+			r.status.CompleteOrg()
+			r.summary.CompleteOrg()
+		}
+	}()
+
+	// skip this org if another instance is already archiving it
+	lock, err := TryLock(ctx, db, OrgLockKey(org.ID))
+	if err != nil {
+		log.WithError(err).Error("error taking org lock")
+		return 1, 0, true
+	}
+	if lock == nil {
+		log.Info("org lock held by another archiver, skipping")
+		return 0, 0, false
+	}
+	defer func() {
+		err := lock.Release(context.Background())
+		if err != nil {
+			log.WithError(err).Error("error releasing org lock")
+		}
+	}()
+
+	// settings for this org in archiver_org_config override our own, these can change between runs
+	org, err = ApplyOrgConfig(ctx, db, config, org)
+	if err != nil {
+		log.WithError(err).Error("error loading org config")
+		return 1, 0, IsRetryable(err)
+	}
+
+	// once this org's budget is used up we stop starting new archives for it, and its remaining types are failures
+	ctx, stopBudget := WithBudget(ctx, time.Duration(config.OrgBudgetMinutes)*time.Minute, ErrOrgBudgetExceeded)
+	defer stopBudget()
+
+	for _, archiveType := range r.archiveTypes {
+		if reason := DrainReason(ctx); reason != nil {
+			log.WithField("archive_type", archiveType).Warn(reason.Error())
+			if reason != ErrShuttingDown {
+				failed++
+				retry = true
+			}
+			continue
+		}
+		r.status.StartOrg(org, archiveType)
+		orgStart := time.Now()
+
+		created, deleted, err := ArchiveOrg(ctx, config.Clock().Now(), config, db, r.s3Client, org, archiveType, r.opts)
+		if err != nil {
+			// errors which won't go away by themselves, ie: a failed verification, aren't retried until the next run
+			retryable := IsRetryable(err)
+			log.WithError(err).WithField("archive_type", archiveType).WithField("retryable", retryable).Errorf("error archiving org %ss", archiveType)
+			failed++
+			retry = retry || retryable
+		}
+
+		r.database.Sinks.Send(ctx, config, db, r.s3Client, org, archiveType, created, log)
+
+		r.stats.ReportOrgArchival(org, archiveType, created, deleted, time.Since(orgStart))
+		r.status.FinishOrg(org, archiveType, err != nil)
+		r.summary.AddOrg(org, archiveType, created, deleted, err)
+
+		if r.runID != 0 {
+			historyErr := RecordArchiverRunOrg(ctx, db, r.runID, org, archiveType, orgStart, time.Now(), created, deleted, err)
+			if historyErr != nil {
+				log.WithError(historyErr).WithField("archive_type", archiveType).Error("error recording run history for org")
+			}
+		}
+
+		lag, err := GetOrgArchiveLag(ctx, db, config.Clock().Now(), org, archiveType)
+		if err != nil {
+			log.WithError(err).WithField("archive_type", archiveType).Error("error calculating archive lag")
+			continue
+		}
+		r.stats.ReportArchiveLag(lag)
+		if lag.Exceeds(config.MaxLagDays) {
+			log.WithField("archive_type", archiveType).WithField("lag_days", lag.Days).Error("org archives lagging behind")
+			lagging++
+		}
+	}
+
+	if config.WriteManifests && config.UploadToS3 {
+		_, err := WriteOrgManifest(ctx, db, r.s3Client, config.S3Bucket, config.Clock().Now(), org)
+		if err != nil {
+			log.WithError(err).Error("error writing org manifest")
+		}
+	}
+	if config.EmailReports {
+		_, err := SendOrgReport(ctx, db, config, r.s3Client, config.Clock().Now(), org)
+		if err != nil {
+			log.WithError(err).Error("error sending org report")
+		}
+	}
+
+	return failed, lagging, retry
+}
+
+// archiveOrgs archives the passed in orgs with our pool of workers until they are all archived or we stop starting new
+// work, recording the outcome of each org which doesn't fail or whose failures retrying won't fix. Orgs which fail are
+// returned, along with the total number of types of them which failed and which are lagging behind, for the caller to
+// retry or record.
+func (r *orgRun) archiveOrgs(ctx context.Context, orgs []Org, record func(int, int)) ([]Org, int, int) {
+	failedOrgs := make([]Org, 0)
+	failedCount, laggingCount := 0, 0
+	failedMutex := sync.Mutex{}
+
+	queue := make(chan Org)
+	waitGroup := sync.WaitGroup{}
+
+	for i := 0; i < r.database.Config.OrgWorkers; i++ {
+		waitGroup.Add(1)
+		go func() {
+			defer waitGroup.Done()
+
+			for org := range queue {
+				failed, lagging, retry := r.archiveOrg(ctx, org)
+				if failed == 0 || !retry {
+					record(failed, lagging)
+					continue
+				}
+
+				failedMutex.Lock()
+				failedOrgs = append(failedOrgs, org)
+				failedCount += failed
+				laggingCount += lagging
+				failedMutex.Unlock()
+			}
+		}()
+	}
+
+	for _, org := range orgs {
+		WaitWhilePaused(ctx, r.opts.Pauser)
+		if Draining(ctx) {
+			break
+		}
+		queue <- org
+	}
+	close(queue)
+	waitGroup.Wait()
+
+	return failedOrgs, failedCount, laggingCount
+}
+
+// archiveQueuedOrgs takes tasks from the passed in queue and archives their orgs until the queue is empty or we are
+// shutting down. Orgs which fail are queued again to be retried, by any instance, up to our configured number of
+// retries, as are those taken by instances which have stopped renewing them within our task timeout.
+func (r *orgRun) archiveQueuedOrgs(ctx context.Context, queue *TaskQueue, orgs map[int]Org, record func(int, int)) {
+	config := r.database.Config
+	timeout := time.Duration(config.TaskTimeoutMinutes) * time.Minute
+	for {
+		WaitWhilePaused(ctx, r.opts.Pauser)
+		if Draining(ctx) {
+			return
+		}
+
+		if timeout > 0 {
+			requeued, err := queue.Requeue(timeout)
+			if err != nil {
+				logrus.WithError(err).Error("error requeuing orgs of stopped instances")
+			}
+			if requeued > 0 {
+				logrus.WithField("orgs", requeued).Warn("requeued orgs taken by instances which stopped archiving them")
+			}
+		}
+
+		task, err := queue.Pop(time.Second * 5)
+		if err != nil {
+			logrus.WithError(err).Error("error taking org from queue")
+			return
+		}
+		if task == nil {
+			return
+		}
+
+		// orgs which aren't active for us, such as those deactivated since they were queued, are dropped
+		org, found := orgs[task.OrgID]
+		if !found {
+			logrus.WithField("org_id", task.OrgID).Warn("queued org not active, skipping")
+			err = queue.Complete(task)
+			if err != nil {
+				logrus.WithError(err).WithField("org_id", task.OrgID).Error("error completing queued org")
+			}
+			continue
+		}
+
+		stopRenewing := renewTask(queue, task, timeout)
+		failed, lagging, retry := r.archiveOrg(ctx, org)
+		stopRenewing()
+
+		if failed == 0 || !retry {
+			err = queue.Complete(task)
+			if err != nil {
+				logrus.WithError(err).WithField("org_id", org.ID).Error("error completing queued org")
+			}
+			record(failed, lagging)
+			continue
+		}
+
+		requeued, err := queue.Retry(task, config.TaskRetries)
+		if err != nil {
+			logrus.WithError(err).WithField("org_id", org.ID).Error("error retrying queued org")
+		}
+		if requeued {
+			logrus.WithField("org_id", org.ID).WithField("attempts", task.Attempts).Info("queued org to be retried")
+			continue
+		}
+		record(failed, lagging)
+	}
+}
+
+// renewTask renews the passed in task at a third of the passed in timeout until the returned function is called, so that
+// it isn't queued again while we're still archiving its org
+func renewTask(queue *TaskQueue, task *OrgTask, timeout time.Duration) func() {
+	if timeout <= 0 {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(timeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				err := queue.Renew(task)
+				if err != nil {
+					logrus.WithError(err).WithField("org_id", task.OrgID).Error("error renewing queued org")
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
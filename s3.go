@@ -9,6 +9,7 @@ import (
 	"net/url"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -17,12 +18,13 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
 var s3BucketURL = "https://%s.s3.amazonaws.com%s"
 
-// NewS3Client creates a new s3 client from the passed in config, testing it as necessary
+// NewS3Client creates a new s3 client from the passed in config, returning an error if our bucket isn't reachable with it
 func NewS3Client(config *Config) (s3iface.S3API, error) {
 	s3Session, err := session.NewSession(&aws.Config{
 		Credentials:      credentials.NewStaticCredentials(config.AWSAccessKeyID, config.AWSSecretAccessKey, ""),
@@ -43,8 +45,7 @@ func NewS3Client(config *Config) (s3iface.S3API, error) {
 	// test out our S3 credentials
 	err = TestS3(s3Client, config.S3Bucket)
 	if err != nil {
-		logrus.WithError(err).Fatal("s3 bucket not reachable")
-		return nil, err
+		return nil, errors.Wrapf(err, "s3 bucket %s not reachable", config.S3Bucket)
 	}
 
 	logrus.Info("s3 bucket ok")
@@ -64,6 +65,32 @@ func TestS3(s3Client s3iface.S3API, bucket string) error {
 	return nil
 }
 
+// TestS3Write tests whether we can write to and delete from the passed in bucket by writing a small canary object and
+// then deleting it
+func TestS3Write(ctx context.Context, s3Client s3iface.S3API, bucket string) error {
+	path := fmt.Sprintf("/archiver-canary-%d.txt", time.Now().UnixNano())
+
+	_, err := s3Client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Body:        strings.NewReader("archiver canary"),
+		Key:         aws.String(path),
+		ContentType: aws.String("text/plain"),
+		ACL:         aws.String(s3.BucketCannedACLPrivate),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error writing canary object %s to bucket: %s", path, bucket)
+	}
+
+	_, err = s3Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(path),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error deleting canary object %s from bucket: %s", path, bucket)
+	}
+	return nil
+}
+
 // UploadToS3 writes the passed in archive
 func UploadToS3(ctx context.Context, s3Client s3iface.S3API, bucket string, path string, archive *Archive) error {
 	f, err := os.Open(archive.ArchiveFile)
@@ -121,6 +148,31 @@ func UploadToS3(ctx context.Context, s3Client s3iface.S3API, bucket string, path
 	return nil
 }
 
+// UploadStreamToS3 uploads everything read from the passed in reader to the passed in bucket and path, returning the
+// URL of the new object. As we don't know the size up front, this always uses a multipart upload.
+func UploadStreamToS3(ctx context.Context, s3Client s3iface.S3API, bucket string, path string, contentType string, reader io.Reader) (string, error) {
+	uploader := s3manager.NewUploaderWithClient(
+		s3Client,
+		func(u *s3manager.Uploader) {
+			u.PartSize = 1e8 // 100 megs per part
+		},
+	)
+	params := &s3manager.UploadInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(path),
+		Body:        reader,
+		ContentType: aws.String(contentType),
+		ACL:         aws.String(s3.BucketCannedACLPrivate),
+	}
+
+	_, err := uploader.UploadWithContext(ctx, params)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(s3BucketURL, bucket, path), nil
+}
+
 func withAcceptEncoding(e string) request.Option {
 	return func(r *request.Request) {
 		r.HTTPRequest.Header.Add("Accept-Encoding", e)
@@ -158,6 +210,26 @@ func GetS3FileETAG(ctx context.Context, s3Client s3iface.S3API, fileURL string)
 	return etag, nil
 }
 
+// DeleteS3File deletes the object at the passed in URL
+func DeleteS3File(ctx context.Context, s3Client s3iface.S3API, fileURL string) error {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return err
+	}
+
+	bucket := strings.Split(u.Host, ".")[0]
+	path := u.Path
+
+	_, err = s3Client.DeleteObjectWithContext(
+		ctx,
+		&s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(path),
+		},
+	)
+	return err
+}
+
 // GetS3File return an io.ReadCloser for the passed in bucket and path
 func GetS3File(ctx context.Context, s3Client s3iface.S3API, fileURL string) (io.ReadCloser, error) {
 	u, err := url.Parse(fileURL)
@@ -183,3 +255,21 @@ func GetS3File(ctx context.Context, s3Client s3iface.S3API, fileURL string) (io.
 
 	return output.Body, nil
 }
+
+// PresignS3File returns a URL which can be used to download the object at the passed in URL without credentials until
+// the passed in duration has passed
+func PresignS3File(s3Client s3iface.S3API, fileURL string, expires time.Duration) (string, error) {
+	u, err := url.Parse(fileURL)
+	if err != nil {
+		return "", err
+	}
+
+	bucket := strings.Split(u.Host, ".")[0]
+	path := u.Path
+
+	req, _ := s3Client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(path),
+	})
+	return req.Presign(expires)
+}
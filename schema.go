@@ -0,0 +1,294 @@
+package archiver
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// the archiver's own additions to the RapidPro schema, these are applied in order by the migrate command so must be
+// idempotent
+var schemaStatements = []string{
+	`ALTER TABLE archives_archive ADD COLUMN IF NOT EXISTS purged_on timestamp with time zone NULL`,
+	`ALTER TABLE archives_archive ADD COLUMN IF NOT EXISTS deletion_last_id bigint NULL`,
+	`ALTER TABLE archives_archive ADD COLUMN IF NOT EXISTS verified_on timestamp with time zone NULL`,
+	`ALTER TABLE archives_archive ADD COLUMN IF NOT EXISTS verify_problems text NULL`,
+	`ALTER TABLE archives_archive ADD COLUMN IF NOT EXISTS chain_hash varchar(64) NULL`,
+	`ALTER TABLE archives_archive ADD COLUMN IF NOT EXISTS redaction varchar(255) NULL`,
+	`ALTER TABLE archives_archive ADD COLUMN IF NOT EXISTS state varchar(16) NULL`,
+	`ALTER TABLE archives_archive ADD COLUMN IF NOT EXISTS state_error text NULL`,
+	`CREATE TABLE IF NOT EXISTS archiver_legal_hold (
+		id serial primary key,
+		org_id integer NOT NULL,
+		archive_type varchar(16) NULL,
+		start_date date NULL,
+		end_date date NULL,
+		reason text NOT NULL,
+		created_on timestamp with time zone NOT NULL,
+		released_on timestamp with time zone NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS archiver_quarantine (
+		id bigserial primary key,
+		org_id integer NOT NULL,
+		archive_id integer NOT NULL,
+		table_name varchar(64) NOT NULL,
+		record_id bigint NOT NULL,
+		record jsonb NOT NULL,
+		quarantined_on timestamp with time zone NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS archiver_quarantine_archive ON archiver_quarantine(archive_id, quarantined_on)`,
+	`CREATE INDEX IF NOT EXISTS archiver_quarantine_org ON archiver_quarantine(org_id, quarantined_on)`,
+	`CREATE TABLE IF NOT EXISTS archive_deletions (
+		id bigserial primary key,
+		archive_id integer NOT NULL,
+		org_id integer NOT NULL,
+		archive_type varchar(16) NOT NULL,
+		start_date date NOT NULL,
+		period varchar(1) NOT NULL,
+		first_id bigint NOT NULL,
+		last_id bigint NOT NULL,
+		record_count integer NOT NULL,
+		elapsed_ms integer NOT NULL,
+		deleted_on timestamp with time zone NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS archive_deletions_archive ON archive_deletions(archive_id)`,
+	`CREATE INDEX IF NOT EXISTS archive_deletions_org ON archive_deletions(org_id, deleted_on)`,
+	`CREATE TABLE IF NOT EXISTS archiver_runs (
+		id bigserial primary key,
+		started_on timestamp with time zone NOT NULL,
+		finished_on timestamp with time zone NULL,
+		config jsonb NOT NULL,
+		orgs_processed integer NOT NULL,
+		archives_created integer NOT NULL,
+		records_archived integer NOT NULL,
+		archives_deleted integer NOT NULL,
+		records_deleted integer NOT NULL,
+		failure_count integer NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS archiver_run_orgs (
+		id bigserial primary key,
+		run_id bigint NOT NULL REFERENCES archiver_runs(id),
+		org_id integer NOT NULL,
+		archive_type varchar(16) NOT NULL,
+		started_on timestamp with time zone NOT NULL,
+		finished_on timestamp with time zone NOT NULL,
+		succeeded boolean NOT NULL,
+		archives_created integer NOT NULL,
+		archives_failed integer NOT NULL,
+		records_archived integer NOT NULL,
+		archives_deleted integer NOT NULL,
+		records_deleted integer NOT NULL,
+		error text NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS archiver_run_orgs_run ON archiver_run_orgs(run_id)`,
+	`CREATE INDEX IF NOT EXISTS archiver_run_orgs_org ON archiver_run_orgs(org_id, archive_type, finished_on)`,
+	`CREATE TABLE IF NOT EXISTS archiver_org_reports (
+		id serial primary key,
+		org_id integer NOT NULL,
+		month date NOT NULL,
+		recipients integer NOT NULL,
+		sent_on timestamp with time zone NOT NULL
+	)`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS archiver_org_reports_org_month ON archiver_org_reports(org_id, month)`,
+	`CREATE TABLE IF NOT EXISTS archiver_pause (
+		id serial primary key,
+		reason text NOT NULL,
+		paused_on timestamp with time zone NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS archiver_org_config (
+		org_id integer primary key,
+		retention_period integer NULL CHECK (retention_period >= 0),
+		delete_archived boolean NULL,
+		delete_after_days integer NULL CHECK (delete_after_days >= 0),
+		s3_prefix varchar(255) NULL,
+		modified_on timestamp with time zone NOT NULL DEFAULT NOW()
+	)`,
+}
+
+// MigrateSchema applies those of the archiver's own additions to the database schema which don't already exist,
+// returning what was added. Altering archives_archive takes an exclusive lock on it even when the column exists, so
+// this is only run by the migrate command and additions which are already in place are skipped.
+func MigrateSchema(ctx context.Context, db *sqlx.DB) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	tables, columns, err := lookupSchema(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make([]string, 0)
+	for _, stmt := range schemaStatements {
+		addition := schemaAddition(stmt)
+		if addition != "" && (tables[addition] || columns[addition]) {
+			continue
+		}
+
+		_, err := db.ExecContext(ctx, stmt)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error applying schema statement: %s", stmt)
+		}
+		if addition != "" {
+			applied = append(applied, addition)
+		}
+	}
+	return applied, nil
+}
+
+// rapidProTables are the RapidPro tables we read from or delete from, which must exist for us to work
+var rapidProTables = []string{
+	"archives_archive", "auth_user", "channels_channel", "channels_channellog", "contacts_contact", "contacts_contacturn",
+	"flows_flow", "flows_flow_labels", "flows_flowpathrecentrun", "flows_flowrun", "flows_flowsession", "msgs_broadcast",
+	"msgs_label", "msgs_msg", "msgs_msg_labels", "orgs_language", "orgs_org",
+}
+
+// rapidProColumns are the columns of RapidPro tables which archiving and deleting depend on, if any of these are missing
+// the database is of a RapidPro version we don't support
+var rapidProColumns = []string{
+	"orgs_org.id", "orgs_org.is_anon", "orgs_org.is_active", "orgs_org.created_on",
+	"msgs_msg.id", "msgs_msg.broadcast_id", "msgs_msg.text", "msgs_msg.created_on", "msgs_msg.modified_on", "msgs_msg.sent_on",
+	"msgs_msg.direction", "msgs_msg.status", "msgs_msg.visibility", "msgs_msg.msg_type", "msgs_msg.attachments",
+	"msgs_msg.channel_id", "msgs_msg.contact_id", "msgs_msg.contact_urn_id", "msgs_msg.org_id", "msgs_msg.response_to_id",
+	"flows_flowrun.id", "flows_flowrun.is_active", "flows_flowrun.uuid", "flows_flowrun.responded", "flows_flowrun.contact_id",
+	"flows_flowrun.flow_id", "flows_flowrun.org_id", "flows_flowrun.results", "flows_flowrun.path", "flows_flowrun.events",
+	"flows_flowrun.parent_id", "flows_flowrun.created_on", "flows_flowrun.modified_on", "flows_flowrun.exited_on",
+	"flows_flowrun.submitted_by_id", "flows_flowrun.exit_type", "flows_flowrun.session_id", "flows_flowsession.status",
+}
+
+// the range of RapidPro versions whose schema we support
+const (
+	MinRapidProVersion = "v6.0"
+	MaxRapidProVersion = "v6.2"
+)
+
+var (
+	schemaTableRegex  = regexp.MustCompile(`^CREATE TABLE IF NOT EXISTS (\w+)`)
+	schemaColumnRegex = regexp.MustCompile(`^ALTER TABLE (\w+) ADD COLUMN IF NOT EXISTS (\w+)`)
+)
+
+// SchemaStatus is what we found when checking the database schema without changing it
+type SchemaStatus struct {
+	// RapidPro tables and columns which don't exist, we can't work without these
+	MissingTables  []string
+	MissingColumns []string
+
+	// our own tables and columns which don't exist yet, these are added by the migrate command
+	PendingAdditions []string
+}
+
+const lookupSchemaColumns = `
+SELECT table_name, column_name
+FROM information_schema.columns
+WHERE table_schema = current_schema()
+`
+
+// lookupSchema returns the tables and the columns, as table.column, of the database's current schema
+func lookupSchema(ctx context.Context, db *sqlx.DB) (map[string]bool, map[string]bool, error) {
+	rows, err := db.QueryxContext(ctx, lookupSchemaColumns)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "error looking up schema")
+	}
+	defer rows.Close()
+
+	tables := make(map[string]bool)
+	columns := make(map[string]bool)
+	var table, column string
+	for rows.Next() {
+		err = rows.Scan(&table, &column)
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "error scanning schema column")
+		}
+		tables[table] = true
+		columns[table+"."+column] = true
+	}
+	return tables, columns, rows.Err()
+}
+
+// schemaAddition returns the table or table.column added by the passed in schema statement, or "" if it adds an index
+func schemaAddition(stmt string) string {
+	if m := schemaTableRegex.FindStringSubmatch(stmt); m != nil {
+		return m[1]
+	}
+	if m := schemaColumnRegex.FindStringSubmatch(stmt); m != nil {
+		return m[1] + "." + m[2]
+	}
+	return ""
+}
+
+// CheckSchema checks that the RapidPro tables we need exist and whether our own additions to them have been applied,
+// without applying them
+func CheckSchema(ctx context.Context, db *sqlx.DB) (*SchemaStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	tables, columns, err := lookupSchema(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &SchemaStatus{MissingTables: make([]string, 0), MissingColumns: make([]string, 0), PendingAdditions: make([]string, 0)}
+	for _, t := range rapidProTables {
+		if !tables[t] {
+			status.MissingTables = append(status.MissingTables, t)
+		}
+	}
+	for _, c := range rapidProColumns {
+		if tables[strings.Split(c, ".")[0]] && !columns[c] {
+			status.MissingColumns = append(status.MissingColumns, c)
+		}
+	}
+
+	for _, stmt := range schemaStatements {
+		addition := schemaAddition(stmt)
+		if addition != "" && !tables[addition] && !columns[addition] {
+			status.PendingAdditions = append(status.PendingAdditions, addition)
+		}
+	}
+
+	return status, nil
+}
+
+// Compatible returns whether the RapidPro schema we found is one we can work with
+func (s *SchemaStatus) Compatible() bool {
+	return len(s.MissingTables) == 0 && len(s.MissingColumns) == 0
+}
+
+// Migrated returns whether all of our own additions to the schema have been applied
+func (s *SchemaStatus) Migrated() bool {
+	return len(s.PendingAdditions) == 0
+}
+
+// CheckSchemaCompatibility returns an error describing what is missing if the database isn't of a RapidPro version we
+// support, so we can fail at startup rather than with SQL errors part way through archiving
+func CheckSchemaCompatibility(ctx context.Context, db *sqlx.DB) error {
+	status, err := CheckSchema(ctx, db)
+	if err != nil {
+		return err
+	}
+	if status.Compatible() {
+		return nil
+	}
+
+	missing := append(append([]string{}, status.MissingTables...), status.MissingColumns...)
+	return classify(ErrSchemaMismatch, errors.Errorf("database schema isn't compatible, this archiver supports RapidPro %s to %s, missing: %s",
+		MinRapidProVersion, MaxRapidProVersion, strings.Join(missing, ", ")))
+}
+
+// CheckSchemaMigrated returns an error listing our own additions to the schema which haven't been applied, these are
+// applied by the migrate command rather than at startup so that running doesn't need to alter RapidPro's tables
+func CheckSchemaMigrated(ctx context.Context, db *sqlx.DB) error {
+	status, err := CheckSchema(ctx, db)
+	if err != nil {
+		return err
+	}
+	if status.Migrated() {
+		return nil
+	}
+
+	return classify(ErrSchemaMismatch, errors.Errorf("database is missing archiver additions to its schema, run `rp-archiver migrate` to apply them, missing: %s",
+		strings.Join(status.PendingAdditions, ", ")))
+}
@@ -0,0 +1,67 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckSchema(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	status, err := CheckSchema(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, status.MissingTables)
+	assert.Equal(t, []string{}, status.MissingColumns)
+	assert.Equal(t, []string{}, status.PendingAdditions)
+	assert.True(t, status.Compatible())
+	assert.True(t, status.Migrated())
+	assert.NoError(t, CheckSchemaCompatibility(ctx, db))
+	assert.NoError(t, CheckSchemaMigrated(ctx, db))
+
+	// remove one of our additions and one of the RapidPro tables we need
+	db.MustExec(`ALTER TABLE archives_archive DROP COLUMN chain_hash`)
+	db.MustExec(`DROP TABLE archiver_pause`)
+	db.MustExec(`DROP TABLE orgs_language CASCADE`)
+
+	status, err = CheckSchema(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"orgs_language"}, status.MissingTables)
+	assert.Equal(t, []string{"archives_archive.chain_hash", "archiver_pause"}, status.PendingAdditions)
+	assert.True(t, status.Compatible())
+
+	// a column we read from being missing means this is a RapidPro version we don't support
+	db.MustExec(`ALTER TABLE flows_flowrun DROP COLUMN session_id`)
+
+	status, err = CheckSchema(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"orgs_language"}, status.MissingTables)
+	assert.Equal(t, []string{"flows_flowrun.session_id"}, status.MissingColumns)
+	assert.False(t, status.Compatible())
+
+	err = CheckSchemaCompatibility(ctx, db)
+	assert.EqualError(t, err, "database schema isn't compatible, this archiver supports RapidPro v6.0 to v6.2, missing: orgs_language, flows_flowrun.session_id")
+
+	// a missing addition of our own means we need migrating
+	err = CheckSchemaMigrated(ctx, db)
+	assert.EqualError(t, err, "database is missing archiver additions to its schema, run `rp-archiver migrate` to apply them, missing: archives_archive.chain_hash, archiver_pause")
+	assert.Equal(t, ErrSchemaMismatch, ErrorClass(err))
+
+	// which only applies the additions which are missing
+	applied, err := MigrateSchema(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"archives_archive.chain_hash", "archiver_pause"}, applied)
+
+	status, err = CheckSchema(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, status.PendingAdditions)
+	assert.True(t, status.Migrated())
+	assert.NoError(t, CheckSchemaMigrated(ctx, db))
+
+	// and migrating again does nothing
+	applied, err = MigrateSchema(ctx, db)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{}, applied)
+}
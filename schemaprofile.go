@@ -0,0 +1,214 @@
+package archiver
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// RecordFields are the changes a schema profile makes to the fields of the records of an archive type, the optional
+// fields it includes and the paths of the fields it excludes
+type RecordFields struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
+
+// SchemaProfile is which fields are included in the records of each archive type. Optional fields, such as the metadata
+// of messages or the language, groups and created_on of their contacts, are only included if listed, and any field but those every record needs can be excluded by its path
+// within records, the same as redacted fields are. It is loaded from a JSON file such as:
+//
+//	{
+//	  "message": {"include": ["metadata", "contact.language"], "exclude": ["labels"]},
+//	  "run": {"include": ["contact.groups"], "exclude": ["path"]}
+//	}
+type SchemaProfile map[ArchiveType]*RecordFields
+
+// optionalFields are the fields of each archive type which are only included in records if a schema profile asks
+var optionalFields = map[ArchiveType][]string{
+	MessageType: {"metadata", contactLanguageField, contactGroupsField, contactCreatedOnField},
+	RunType:     {contactLanguageField, contactGroupsField, contactCreatedOnField},
+}
+
+// LoadSchemaProfile loads and validates the schema profile in the passed in file
+func LoadSchemaProfile(filename string) (SchemaProfile, error) {
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading schema profile file: %s", filename)
+	}
+
+	profile := make(SchemaProfile)
+	err = json.Unmarshal(contents, &profile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing schema profile file: %s", filename)
+	}
+
+	for archiveType, fields := range profile {
+		optional, found := optionalFields[archiveType]
+		if !found {
+			return nil, errors.Errorf("unknown archive type: %s", archiveType)
+		}
+		if fields == nil {
+			continue
+		}
+		for _, field := range fields.Include {
+			if !containsString(optional, field) {
+				return nil, errors.Errorf("unknown optional field '%s' of %s records", field, archiveType)
+			}
+		}
+		for _, path := range fields.Exclude {
+			for _, part := range strings.Split(path, ".") {
+				if part == "" {
+					return nil, errors.Errorf("invalid field path '%s'", path)
+				}
+			}
+			if path == "*" || containsString(requiredFields[archiveType], path) {
+				return nil, errors.Errorf("required field '%s' of %s records can't be excluded", path, archiveType)
+			}
+			if containsString(fields.Include, path) {
+				return nil, errors.Errorf("field '%s' of %s records can't be both included and excluded", path, archiveType)
+			}
+		}
+	}
+
+	return profile, nil
+}
+
+// applySchemaProfile loads our schema profile, if configured, and sets it on each of the passed in orgs
+func applySchemaProfile(conf *Config, orgs []Org) error {
+	if conf.SchemaProfile == "" {
+		return nil
+	}
+
+	profile, err := LoadSchemaProfile(conf.SchemaProfile)
+	if err != nil {
+		return err
+	}
+
+	for i := range orgs {
+		orgs[i].Schema = profile
+	}
+	return nil
+}
+
+// includes returns whether this profile includes the passed in optional field in records of the passed in type
+func (p SchemaProfile) includes(archiveType ArchiveType, field string) bool {
+	fields := p[archiveType]
+	return fields != nil && containsString(fields.Include, field)
+}
+
+// projector returns a function which removes the fields this profile excludes from records of the passed in type, nil
+// if it doesn't exclude any
+func (p SchemaProfile) projector(archiveType ArchiveType) func(string) (string, error) {
+	fields := p[archiveType]
+	if fields == nil || len(fields.Exclude) == 0 {
+		return nil
+	}
+
+	return func(record string) (string, error) {
+		projected := []byte(record)
+		for _, path := range fields.Exclude {
+			var err error
+			projected, err = redactPath(projected, strings.Split(path, "."), RedactDrop, nil)
+			if err != nil {
+				return "", errors.Wrapf(err, "error excluding %s", path)
+			}
+		}
+		return string(projected), nil
+	}
+}
+
+// withField returns the passed in JSON object record with the field at the passed in path set to the passed in value,
+// added to the end of its object if it isn't already there. The value is set as compact JSON if it is valid JSON and as
+// a string if it isn't.
+func withField(record string, path string, value *string) (string, error) {
+	raw := []byte("null")
+	if value != nil {
+		compacted := &bytes.Buffer{}
+		if json.Compact(compacted, []byte(*value)) == nil {
+			raw = compacted.Bytes()
+		} else {
+			raw = []byte(jsonbString(*value))
+		}
+	}
+
+	set, err := setPath([]byte(record), strings.Split(path, "."), raw)
+	if err != nil {
+		return "", errors.Wrapf(err, "error setting %s", path)
+	}
+	return string(set), nil
+}
+
+// setPath sets the field at the passed in path within the passed in JSON object to the passed in raw JSON value,
+// returning the value unchanged if it isn't an object. As with redactPath, only the objects along the path are rebuilt.
+func setPath(value []byte, path []string, raw []byte) ([]byte, error) {
+	value = bytes.TrimSpace(value)
+	if len(value) == 0 || value[0] != '{' {
+		return value, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+
+	out := &bytes.Buffer{}
+	out.WriteByte('{')
+	found := false
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := token.(string)
+
+		var child json.RawMessage
+		if err := decoder.Decode(&child); err != nil {
+			return nil, err
+		}
+		set := []byte(child)
+
+		if key == path[0] {
+			found = true
+			if len(path) > 1 {
+				set, err = setPath(set, path[1:], raw)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				set = raw
+			}
+		}
+
+		if out.Len() > 1 {
+			out.WriteByte(',')
+		}
+		out.WriteString(jsonbString(key))
+		out.WriteByte(':')
+		out.Write(set)
+	}
+
+	// fields missing along the path are only added if they are the field being set
+	if !found && len(path) == 1 {
+		if out.Len() > 1 {
+			out.WriteByte(',')
+		}
+		out.WriteString(jsonbString(path[0]))
+		out.WriteByte(':')
+		out.Write(raw)
+	}
+	out.WriteByte('}')
+	return out.Bytes(), nil
+}
+
+// containsString returns whether the passed in slice contains the passed in string
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
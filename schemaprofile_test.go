@@ -0,0 +1,165 @@
+package archiver
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaProfile(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	profileFile, err := ioutil.TempFile("", "schema*.json")
+	assert.NoError(t, err)
+	defer os.Remove(profileFile.Name())
+
+	profileFile.WriteString(`{
+		"message": {"include": ["metadata", "contact.language", "contact.groups", "contact.created_on"], "exclude": ["labels", "channel.name"]},
+		"run": {"exclude": ["path"]}
+	}`)
+	profileFile.Close()
+
+	config := NewConfig()
+	config.SchemaProfile = profileFile.Name()
+	assert.Equal(t, 0, len(config.Validate()))
+
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+	tasks, err := GetMissingDailyArchives(ctx, db, now, orgs[1], MessageType)
+	assert.NoError(t, err)
+	task := tasks[2]
+
+	for _, recordJSON := range []string{RecordJSONDB, RecordJSONGo} {
+		config.RecordJSON = recordJSON
+
+		err = createArchiveFile(ctx, db, task, "/tmp", config, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, task.RecordCount)
+
+		f, err := os.Open(task.ArchiveFile)
+		assert.NoError(t, err)
+		gz, err := gzip.NewReader(f)
+		assert.NoError(t, err)
+
+		records := make([]map[string]interface{}, 0)
+		scanner := bufio.NewScanner(gz)
+		for scanner.Scan() {
+			record := make(map[string]interface{})
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+			records = append(records, record)
+		}
+		f.Close()
+		DeleteArchiveFile(task)
+
+		if assert.Equal(t, 3, len(records)) {
+			assert.Contains(t, records[0], "metadata")
+			assert.Nil(t, records[0]["metadata"])
+			assert.NotContains(t, records[0], "labels")
+			assert.Equal(t, map[string]interface{}{"uuid": "60f2ed5b-05f2-4156-9ff0-e44e90da1b85"}, records[0]["channel"])
+			assert.Equal(t, "message 1", records[0]["text"])
+			assert.Equal(t, map[string]interface{}{
+				"uuid":       "3e814add-e614-41f7-8b5d-a07f670a698f",
+				"name":       "Ajodinabiff Dane",
+				"language":   nil,
+				"groups":     []interface{}{},
+				"created_on": "2015-10-30T19:42:27.001837+00:00",
+			}, records[0]["contact"])
+		}
+	}
+
+	// invalid profiles are rejected
+	config.SchemaProfile = "missing.json"
+	assert.Equal(t, 1, len(config.Validate()))
+	_, err = GetActiveOrgs(ctx, db, config)
+	assert.Error(t, err)
+}
+
+func TestLoadSchemaProfile(t *testing.T) {
+	for _, tc := range []struct {
+		profile string
+		err     string
+	}{
+		{`{"event": {"exclude": ["text"]}}`, "unknown archive type: event"},
+		{`{"message": {"include": ["geometry"]}}`, "unknown optional field 'geometry' of message records"},
+		{`{"run": {"include": ["metadata"]}}`, "unknown optional field 'metadata' of run records"},
+		{`{"run": {"exclude": ["id"]}}`, "required field 'id' of run records can't be excluded"},
+		{`{"run": {"exclude": ["*"]}}`, "required field '*' of run records can't be excluded"},
+		{`{"message": {"exclude": ["channel..name"]}}`, "invalid field path 'channel..name'"},
+		{`{"message": {"include": ["metadata"], "exclude": ["metadata"]}}`, "field 'metadata' of message records can't be both included and excluded"},
+	} {
+		profileFile, err := ioutil.TempFile("", "schema*.json")
+		assert.NoError(t, err)
+		profileFile.WriteString(tc.profile)
+		profileFile.Close()
+
+		_, err = LoadSchemaProfile(profileFile.Name())
+		assert.EqualError(t, err, tc.err)
+		os.Remove(profileFile.Name())
+	}
+}
+
+func TestProjectRecords(t *testing.T) {
+	profile := SchemaProfile{
+		MessageType: {Include: []string{"metadata"}},
+		RunType:     {Exclude: []string{"path", "values.*.input"}},
+	}
+
+	assert.True(t, profile.includes(MessageType, "metadata"))
+	assert.False(t, profile.includes(RunType, "metadata"))
+	assert.Nil(t, profile.projector(MessageType))
+	assert.False(t, SchemaProfile(nil).includes(MessageType, "metadata"))
+	assert.Nil(t, SchemaProfile(nil).projector(RunType))
+
+	projected, err := profile.projector(RunType)(`{"id":1,"path":[{"node": "n1"}],"values":{"color": {"input": "red", "value": "red"}}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":1,"values":{"color":{"value":"red"}}}`, projected)
+
+	// optional fields are added as compact JSON, or as strings if they aren't JSON
+	metadata := "{\"quick_replies\": [\"yes\",\n \"no\"]}"
+	record, err := withField(`{"id":1}`, "metadata", &metadata)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":1,"metadata":{"quick_replies":["yes","no"]}}`, record)
+
+	metadata = "not json"
+	record, err = withField(`{"id":1}`, "metadata", &metadata)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":1,"metadata":"not json"}`, record)
+
+	record, err = withField(`{}`, "metadata", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"metadata":null}`, record)
+
+	// fields within objects can be set too, but missing objects aren't created
+	language := `"eng"`
+	record, err = withField(`{"id":1,"contact":{"uuid":"c1","language":null}}`, "contact.language", &language)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":1,"contact":{"uuid":"c1","language":"eng"}}`, record)
+
+	record, err = withField(`{"id":1,"contact":null}`, "contact.language", &language)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":1,"contact":null}`, record)
+
+	_, err = withField(`{"id":1,"contact":{"uuid":`, "contact.language", &language)
+	assert.Error(t, err)
+
+	// contact fields are only looked up if included
+	assert.Equal(t, []string{"contact.language", "contact.created_on"}, SchemaProfile{RunType: {Include: []string{"contact.created_on", "contact.language"}}}.contactFields(RunType))
+	assert.Equal(t, []string{}, profile.contactFields(MessageType))
+
+	eng := "eng"
+	contact := &archiveContact{Language: &eng, CreatedOn: time.Date(2015, 10, 30, 19, 42, 27, 1837000, time.UTC), Groups: `[{"name": "Group 1", "uuid": "g1"}]`}
+	assert.Equal(t, `"eng"`, *contact.value(contactLanguageField))
+	assert.Equal(t, `"2015-10-30T19:42:27.001837+00:00"`, *contact.value(contactCreatedOnField))
+	assert.Equal(t, `[{"name": "Group 1", "uuid": "g1"}]`, *contact.value(contactGroupsField))
+	assert.Nil(t, (*archiveContact)(nil).value(contactLanguageField))
+}
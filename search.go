@@ -0,0 +1,131 @@
+package archiver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// SearchFilter is which records we look for when searching archives, empty fields match any record
+type SearchFilter struct {
+	ContactUUID string
+	URN         string
+
+	// records must also be within [Start, End), as archives overlapping a date range are searched in full
+	Start time.Time
+	End   time.Time
+}
+
+// searchedRecord is the part of an archived message or run we need to decide whether it matches a search
+type searchedRecord struct {
+	Contact struct {
+		UUID string `json:"uuid"`
+	} `json:"contact"`
+	URN        *string   `json:"urn"`
+	CreatedOn  time.Time `json:"created_on"`
+	ModifiedOn time.Time `json:"modified_on"`
+}
+
+// matches returns whether the passed in record of the passed in type matches this filter
+func (f *SearchFilter) matches(archiveType ArchiveType, r *searchedRecord) bool {
+	if f.ContactUUID != "" && r.Contact.UUID != f.ContactUUID {
+		return false
+	}
+	if f.URN != "" && (r.URN == nil || *r.URN != f.URN) {
+		return false
+	}
+
+	// messages are archived by when they were created, runs by when they were last modified
+	date := r.CreatedOn
+	if archiveType == RunType {
+		date = r.ModifiedOn
+	}
+	if !f.Start.IsZero() && date.Before(f.Start) {
+		return false
+	}
+	if !f.End.IsZero() && !date.Before(f.End) {
+		return false
+	}
+	return true
+}
+
+// SearchArchives downloads each of the passed in archives in turn, writing those of their records which match the
+// passed in filter to the passed in writer as JSONL. Each archive is downloaded to the passed in temp directory and its
+// hash verified before it is searched, and we stop with an error at the first archive that doesn't match. Returns the
+// number of records written.
+func SearchArchives(ctx context.Context, s3Client s3iface.S3API, archives []*Archive, filter *SearchFilter, tempDir string, writer io.Writer) (int, error) {
+	matched := 0
+	for _, archive := range archives {
+		// nothing to search in empty archives
+		if archive.RecordCount == 0 {
+			continue
+		}
+
+		start := time.Now()
+
+		// read the decompressed archive through a pipe once it has been downloaded and its hash verified
+		reader, pipeWriter := io.Pipe()
+		go func(archive *Archive) {
+			pipeWriter.CloseWithError(streamArchive(ctx, s3Client, archive, tempDir, pipeWriter))
+		}(archive)
+
+		archiveMatched, err := searchRecords(archive, reader, filter, writer)
+		reader.Close()
+		matched += archiveMatched
+		if err != nil {
+			return matched, err
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"archive_id":   archive.ID,
+			"org_id":       archive.OrgID,
+			"archive_type": archive.ArchiveType,
+			"start_date":   archive.StartDate,
+			"period":       archive.Period,
+			"record_count": archive.RecordCount,
+			"matched":      archiveMatched,
+			"elapsed":      time.Since(start),
+		}).Debug("searched archive")
+	}
+
+	return matched, nil
+}
+
+// searchRecords writes those of the passed in decompressed records of an archive which match our filter to the passed in writer
+func searchRecords(archive *Archive, reader io.Reader, filter *SearchFilter, writer io.Writer) (int, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), maxArchivedRecordSize)
+
+	matched := 0
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+
+		record := &searchedRecord{}
+		err := json.Unmarshal(line, record)
+		if err != nil {
+			return matched, errors.Wrapf(err, "error parsing record in archive: %d", archive.ID)
+		}
+		if !filter.matches(archive.ArchiveType, record) {
+			continue
+		}
+
+		_, err = writer.Write(append(line, '\n'))
+		if err != nil {
+			return matched, errors.Wrapf(err, "error writing matching record")
+		}
+		matched++
+	}
+	if scanner.Err() != nil {
+		return matched, errors.Wrapf(scanner.Err(), "error reading archive: %d", archive.ID)
+	}
+	return matched, nil
+}
@@ -0,0 +1,118 @@
+package archiver
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// OrgSelection is which orgs we archive, from our org, exclude org and org UUID settings
+type OrgSelection struct {
+	OrgIDs     []int
+	ExcludeIDs []int
+	OrgUUIDs   []string
+}
+
+// IsEmpty returns whether this selection doesn't limit which orgs we archive
+func (s *OrgSelection) IsEmpty() bool {
+	return len(s.OrgIDs) == 0 && len(s.ExcludeIDs) == 0 && len(s.OrgUUIDs) == 0
+}
+
+// ParseOrgSelection parses the orgs selected for archiving from the passed in config
+func ParseOrgSelection(config *Config) (*OrgSelection, error) {
+	selection := &OrgSelection{OrgUUIDs: splitList(config.OrgUUID)}
+
+	var err error
+	selection.OrgIDs, err = parseOrgIDs(config.Org)
+	if err != nil {
+		return nil, err
+	}
+	selection.ExcludeIDs, err = parseOrgIDs(config.ExcludeOrg)
+	if err != nil {
+		return nil, err
+	}
+	return selection, nil
+}
+
+// parseOrgIDs parses a comma separated list of org ids
+func parseOrgIDs(value string) ([]int, error) {
+	ids := make([]int, 0)
+	for _, v := range splitList(value) {
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			return nil, errors.Errorf("invalid org id: %s", v)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// splitList splits a comma separated list, ignoring whitespace and empty items
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			items = append(items, v)
+		}
+	}
+	return items
+}
+
+const lookupOrgIDsByUUID = `
+SELECT id
+FROM orgs_org
+WHERE uuid = ANY($1)
+`
+
+// FilterOrgs returns those of the passed in orgs which are selected by the passed in selection, keeping their order.
+// Orgs selected by id or UUID are only archived if they are active.
+func FilterOrgs(ctx context.Context, db *sqlx.DB, selection *OrgSelection, orgs []Org) ([]Org, error) {
+	if selection.IsEmpty() {
+		return orgs, nil
+	}
+
+	included := make(map[int]bool)
+	for _, id := range selection.OrgIDs {
+		included[id] = true
+	}
+
+	if len(selection.OrgUUIDs) > 0 {
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+
+		ids := make([]int, 0, len(selection.OrgUUIDs))
+		err := db.SelectContext(ctx, &ids, lookupOrgIDsByUUID, pq.Array(selection.OrgUUIDs))
+		if err != nil {
+			return nil, errors.Wrapf(err, "error looking up orgs by UUID")
+		}
+		if len(ids) < len(selection.OrgUUIDs) {
+			return nil, errors.Errorf("no orgs found for some of UUIDs: %s", strings.Join(selection.OrgUUIDs, ", "))
+		}
+		for _, id := range ids {
+			included[id] = true
+		}
+	}
+
+	excluded := make(map[int]bool)
+	for _, id := range selection.ExcludeIDs {
+		excluded[id] = true
+	}
+
+	filtered := make([]Org, 0, len(orgs))
+	for _, org := range orgs {
+		if len(included) > 0 && !included[org.ID] {
+			continue
+		}
+		if excluded[org.ID] {
+			continue
+		}
+		filtered = append(filtered, org)
+	}
+	return filtered, nil
+}
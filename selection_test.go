@@ -0,0 +1,73 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOrgSelection(t *testing.T) {
+	config := NewConfig()
+	selection, err := ParseOrgSelection(config)
+	assert.NoError(t, err)
+	assert.True(t, selection.IsEmpty())
+
+	config.Org = "1, 3,"
+	config.ExcludeOrg = "2"
+	config.OrgUUID = "7a3c6a52-5ba6-4d4e-8c0e-0b5a3e0c4f8b"
+	selection, err = ParseOrgSelection(config)
+	assert.NoError(t, err)
+	assert.Equal(t, &OrgSelection{OrgIDs: []int{1, 3}, ExcludeIDs: []int{2}, OrgUUIDs: []string{"7a3c6a52-5ba6-4d4e-8c0e-0b5a3e0c4f8b"}}, selection)
+	assert.False(t, selection.IsEmpty())
+
+	config.Org = "1,org5"
+	_, err = ParseOrgSelection(config)
+	assert.EqualError(t, err, "invalid org id: org5")
+}
+
+func TestFilterOrgs(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	config := NewConfig()
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+
+	orgIDs := func(orgs []Org) []int {
+		ids := make([]int, len(orgs))
+		for i, org := range orgs {
+			ids[i] = org.ID
+		}
+		return ids
+	}
+
+	tcs := []struct {
+		selection *OrgSelection
+		orgIDs    []int
+		err       string
+	}{
+		{&OrgSelection{}, []int{1, 2, 3}, ""},
+		{&OrgSelection{OrgIDs: []int{3, 1}}, []int{1, 3}, ""},
+		{&OrgSelection{ExcludeIDs: []int{2}}, []int{1, 3}, ""},
+		{&OrgSelection{OrgIDs: []int{2, 3}, ExcludeIDs: []int{2}}, []int{3}, ""},
+		{&OrgSelection{OrgUUIDs: []string{"e2e24ea1-3b1c-4d1c-8bc6-1a1bbc1a1a2d"}}, []int{2}, ""},
+		{&OrgSelection{OrgIDs: []int{1}, OrgUUIDs: []string{"e2e24ea1-3b1c-4d1c-8bc6-1a1bbc1a1a2d"}}, []int{1, 2}, ""},
+
+		// inactive orgs are never archived
+		{&OrgSelection{OrgIDs: []int{4}}, []int{}, ""},
+		{&OrgSelection{OrgUUIDs: []string{"c3d1f2a0-9f3e-4b6f-a7c2-5d3e1f0a9b84"}}, []int{}, ""},
+
+		{&OrgSelection{OrgUUIDs: []string{"00000000-0000-0000-0000-000000000000"}}, nil, "no orgs found for some of UUIDs: 00000000-0000-0000-0000-000000000000"},
+	}
+
+	for _, tc := range tcs {
+		filtered, err := FilterOrgs(ctx, db, tc.selection, orgs)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tc.orgIDs, orgIDs(filtered))
+	}
+}
@@ -0,0 +1,45 @@
+package archiver
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// RecordSerializer turns the records of an archive into the lines written to its file, so that embedders can archive
+// records in their own shape or with extra fields, while we still handle exporting, writing, uploading and recording
+// the archive. It is passed each record as the JSON we would otherwise write, after any schema profile, redaction and
+// pseudonymization has been applied.
+type RecordSerializer interface {
+	SerializeRecord(archive *Archive, record []byte) ([]byte, error)
+}
+
+// RecordSerializerFunc is a function which serializes records
+type RecordSerializerFunc func(archive *Archive, record []byte) ([]byte, error)
+
+// SerializeRecord calls our function with the passed in archive and record
+func (f RecordSerializerFunc) SerializeRecord(archive *Archive, record []byte) ([]byte, error) {
+	return f(archive, record)
+}
+
+// recordSerializer returns a function which serializes records of the passed in archive with the passed in serializer
+// for its type, nil if there is none
+func recordSerializer(serializers map[ArchiveType]RecordSerializer, archive *Archive) func(string) (string, error) {
+	serializer := serializers[archive.ArchiveType]
+	if serializer == nil {
+		return nil
+	}
+
+	return func(record string) (string, error) {
+		serialized, err := serializer.SerializeRecord(archive, []byte(record))
+		if err != nil {
+			return "", err
+		}
+
+		// archives have a record per line, so what we write can't span several
+		if bytes.IndexByte(serialized, '\n') >= 0 {
+			return "", errors.Errorf("serialized record contains a newline")
+		}
+		return string(serialized), nil
+	}
+}
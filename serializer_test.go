@@ -0,0 +1,43 @@
+package archiver
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordSerializer(t *testing.T) {
+	messages := &Archive{ArchiveType: MessageType, Org: Org{ID: 3}}
+	runs := &Archive{ArchiveType: RunType, Org: Org{ID: 3}}
+
+	assert.Nil(t, recordSerializer(nil, messages))
+
+	// only the types given a serializer use one
+	serializers := map[ArchiveType]RecordSerializer{}
+	serializers[MessageType] = RecordSerializerFunc(func(archive *Archive, record []byte) ([]byte, error) {
+		msg := make(map[string]interface{})
+		if err := json.Unmarshal(record, &msg); err != nil {
+			return nil, err
+		}
+		return json.Marshal(map[string]interface{}{"org": archive.Org.ID, "msg_id": msg["id"]})
+	})
+	assert.Nil(t, recordSerializer(serializers, runs))
+
+	serialize := recordSerializer(serializers, messages)
+	record, err := serialize(`{"id":12,"text":"hi"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"msg_id":12,"org":3}`, record)
+
+	_, err = serialize(`{"id":`)
+	assert.Error(t, err)
+
+	// serializers for other types are added alongside
+	serializers[RunType] = RecordSerializerFunc(func(archive *Archive, record []byte) ([]byte, error) {
+		return []byte(fmt.Sprintf("%s\n", record)), nil
+	})
+	assert.NotNil(t, recordSerializer(serializers, messages))
+	_, err = recordSerializer(serializers, runs)(`{"id":1}`)
+	assert.EqualError(t, err, "serialized record contains a newline")
+}
@@ -0,0 +1,66 @@
+package archiver
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// reasons we stop starting new work, these are the build errors of archives which weren't started because of them
+var (
+	ErrShuttingDown      = errors.New("not started as archiver is shutting down")
+	ErrOrgBudgetExceeded = errors.New("not started as org time budget exceeded")
+	ErrRunBudgetExceeded = errors.New("not started as run time budget exceeded")
+)
+
+// isDrainReason returns whether the passed in error is one of the reasons we stop starting new work
+func isDrainReason(err error) bool {
+	return err == ErrShuttingDown || err == ErrOrgBudgetExceeded || err == ErrRunBudgetExceeded
+}
+
+type drainKey struct{}
+
+// drain is a channel which is closed when we should stop starting new work, and why
+type drain struct {
+	ch     <-chan struct{}
+	reason error
+}
+
+// WithDrain returns a context which carries the passed in channel, which is closed when we should stop starting new
+// work for the passed in reason, such as being asked to shut down. Work already started carries on until the context
+// itself is cancelled. Drains of parent contexts still apply.
+func WithDrain(ctx context.Context, ch <-chan struct{}, reason error) context.Context {
+	drains, _ := ctx.Value(drainKey{}).([]drain)
+	drains = append(drains[:len(drains):len(drains)], drain{ch: ch, reason: reason})
+	return context.WithValue(ctx, drainKey{}, drains)
+}
+
+// WithBudget returns a context which starts draining once the passed in budget has passed, along with a function to
+// stop the timer when done, a budget of zero is no budget
+func WithBudget(ctx context.Context, budget time.Duration, reason error) (context.Context, func()) {
+	if budget <= 0 {
+		return ctx, func() {}
+	}
+
+	ch := make(chan struct{})
+	timer := time.AfterFunc(budget, func() { close(ch) })
+	return WithDrain(ctx, ch, reason), func() { timer.Stop() }
+}
+
+// Draining returns whether the passed in context has been asked to stop starting new work
+func Draining(ctx context.Context) bool {
+	return DrainReason(ctx) != nil
+}
+
+// DrainReason returns why the passed in context has been asked to stop starting new work, or nil if it hasn't
+func DrainReason(ctx context.Context) error {
+	drains, _ := ctx.Value(drainKey{}).([]drain)
+	for _, d := range drains {
+		select {
+		case <-d.ch:
+			return d.reason
+		default:
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDraining(t *testing.T) {
+	ctx := context.Background()
+	assert.False(t, Draining(ctx))
+
+	drain := make(chan struct{})
+	ctx = WithDrain(ctx, drain, ErrShuttingDown)
+	assert.False(t, Draining(ctx))
+
+	// drains of parents still apply to children with their own drains
+	budgetCtx, stop := WithBudget(ctx, time.Millisecond, ErrOrgBudgetExceeded)
+	defer stop()
+	assert.False(t, Draining(budgetCtx))
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, ErrOrgBudgetExceeded, DrainReason(budgetCtx))
+	assert.False(t, Draining(ctx))
+
+	close(drain)
+	assert.True(t, Draining(ctx))
+	assert.Equal(t, ErrShuttingDown, DrainReason(ctx))
+
+	// no budget, no drain
+	noBudgetCtx, stop := WithBudget(context.Background(), 0, ErrRunBudgetExceeded)
+	defer stop()
+	assert.False(t, Draining(noBudgetCtx))
+
+	// child contexts are draining too
+	child, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	assert.True(t, Draining(child))
+}
+
+func TestCreateOrgArchivesWhileDraining(t *testing.T) {
+	db := setup(t)
+
+	config := NewConfig()
+	config.UploadToS3 = false
+	config.ArchiveWorkers = 2
+
+	drain := make(chan struct{})
+	close(drain)
+	ctx := WithDrain(context.Background(), drain, ErrShuttingDown)
+
+	orgs, err := GetActiveOrgs(ctx, db, config)
+	assert.NoError(t, err)
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+
+	// nothing is started, and nothing is written to the database
+	created, err := CreateOrgArchives(ctx, now, config, db, nil, orgs[2], RunType, nil)
+	assert.NoError(t, err)
+	assert.True(t, len(created) > 0)
+	for _, archive := range created {
+		assert.Equal(t, 0, archive.ID)
+		assert.Equal(t, ErrShuttingDown, archive.BuildError)
+	}
+
+	count, err := GetCurrentArchiveCount(ctx, db, orgs[2], RunType)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
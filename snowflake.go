@@ -0,0 +1,279 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// snowflakeTables are the names of the Snowflake tables the archives of each type are loaded into, each has a file
+// column, the path of the archive a record was loaded from, and a record variant column
+var snowflakeTables = map[ArchiveType]string{MessageType: "messages", RunType: "runs"}
+
+// snowflakeMaxFiles is the most files a single COPY INTO statement can list
+const snowflakeMaxFiles = 1000
+
+// how often we check whether a statement which is still running has completed
+var snowflakePollInterval = time.Second * 2
+
+// Snowflake loads new archives into tables in Snowflake, running COPY INTO statements through its SQL API from an
+// external stage over our bucket. Snowflake remembers which files each table has loaded, so loading an archive again
+// doesn't duplicate its records. All methods are safe to call on a nil Snowflake, in which case they do nothing.
+type Snowflake struct {
+	endpoint  string
+	account   string
+	user      string
+	warehouse string
+	database  string
+	schema    string
+	stage     string
+
+	key         *rsa.PrivateKey
+	fingerprint string
+	client      *http.Client
+}
+
+// NewSnowflake creates a new Snowflake from the passed in config, returning nil if no Snowflake account is configured
+func NewSnowflake(config *Config) (*Snowflake, error) {
+	if config.SnowflakeAccount == "" {
+		return nil, nil
+	}
+
+	key, err := parseSnowflakeKey(config.SnowflakePrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error marshalling snowflake public key")
+	}
+	fingerprint := sha256.Sum256(public)
+
+	return &Snowflake{
+		endpoint:    fmt.Sprintf("https://%s.snowflakecomputing.com", strings.ToLower(config.SnowflakeAccount)),
+		account:     strings.ToUpper(strings.Split(config.SnowflakeAccount, ".")[0]),
+		user:        strings.ToUpper(config.SnowflakeUser),
+		warehouse:   config.SnowflakeWarehouse,
+		database:    config.SnowflakeDatabase,
+		schema:      config.SnowflakeSchema,
+		stage:       config.SnowflakeStage,
+		key:         key,
+		fingerprint: "SHA256:" + base64.StdEncoding.EncodeToString(fingerprint[:]),
+		client:      &http.Client{Timeout: time.Second * 30},
+	}, nil
+}
+
+// parseSnowflakeKey parses the passed in PEM encoded RSA private key, which can be PKCS#8 or PKCS#1 but not encrypted
+func parseSnowflakeKey(encoded string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, errors.New("snowflake private key isn't PEM encoded")
+	}
+
+	if block.Type == "RSA PRIVATE KEY" {
+		key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error parsing snowflake private key")
+		}
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error parsing snowflake private key, encrypted keys aren't supported")
+	}
+	key, isRSA := parsed.(*rsa.PrivateKey)
+	if !isRSA {
+		return nil, errors.New("snowflake private key isn't an RSA key")
+	}
+	return key, nil
+}
+
+// LoadArchives loads the passed in archives, which have just been written, into the Snowflake table of their type.
+// Monthly archives rolled up from dailies aren't loaded, as their records were loaded with the dailies, nor are
+// archives without any records or which weren't uploaded.
+func (s *Snowflake) LoadArchives(ctx context.Context, archiveType ArchiveType, archives []*Archive) error {
+	if s == nil {
+		return nil
+	}
+
+	files := make([]string, 0, len(archives))
+	for _, a := range archives {
+		if len(a.Dailies) > 0 || a.RecordCount == 0 || a.URL == "" {
+			continue
+		}
+		u, err := url.Parse(a.URL)
+		if err != nil {
+			return errors.Wrapf(err, "error parsing S3 URL: %s", a.URL)
+		}
+		files = append(files, strings.TrimLeft(u.Path, "/"))
+	}
+
+	for len(files) > 0 {
+		batch := files
+		if len(batch) > snowflakeMaxFiles {
+			batch = files[:snowflakeMaxFiles]
+		}
+		files = files[len(batch):]
+
+		statement, err := snowflakeCopyStatement(s.stage, archiveType, batch)
+		if err != nil {
+			return err
+		}
+		err = s.Execute(ctx, statement)
+		if err != nil {
+			return errors.Wrapf(err, "error loading %d %s archives into snowflake", len(batch), archiveType)
+		}
+	}
+	return nil
+}
+
+// snowflakeCopyStatement returns the COPY INTO statement which loads the passed in files, paths within the passed in
+// stage, into the table of the passed in archive type
+func snowflakeCopyStatement(stage string, archiveType ArchiveType, files []string) (string, error) {
+	table, found := snowflakeTables[archiveType]
+	if !found {
+		return "", errors.Errorf("unknown archive type: %s", archiveType)
+	}
+
+	quoted := make([]string, len(files))
+	for i, f := range files {
+		quoted[i] = "'" + strings.Replace(f, "'", "''", -1) + "'"
+	}
+
+	return fmt.Sprintf(
+		"COPY INTO %s (file, record) FROM (SELECT METADATA$FILENAME, $1 FROM @%s) FILES = (%s) FILE_FORMAT = (TYPE = JSON)",
+		table, stage, strings.Join(quoted, ", "),
+	), nil
+}
+
+// snowflakeStatement is the body of a request to run a statement with the SQL API
+type snowflakeStatement struct {
+	Statement string `json:"statement"`
+	Timeout   int    `json:"timeout"`
+	Warehouse string `json:"warehouse,omitempty"`
+	Database  string `json:"database,omitempty"`
+	Schema    string `json:"schema,omitempty"`
+}
+
+// snowflakeResponse is the part of a response of the SQL API we look at, the handle of the statement and why it
+// failed if it did
+type snowflakeResponse struct {
+	Code            string `json:"code"`
+	Message         string `json:"message"`
+	StatementHandle string `json:"statementHandle"`
+}
+
+// Execute runs the passed in statement, waiting for it to complete if it doesn't within the request, until our
+// context is done
+func (s *Snowflake) Execute(ctx context.Context, statement string) error {
+	if s == nil {
+		return nil
+	}
+
+	body, err := json.Marshal(&snowflakeStatement{
+		Statement: statement,
+		Timeout:   int((time.Minute * 10).Seconds()),
+		Warehouse: s.warehouse,
+		Database:  s.database,
+		Schema:    s.schema,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "error marshalling snowflake statement")
+	}
+
+	status, response, err := s.request(ctx, http.MethodPost, "/api/v2/statements", body)
+	for err == nil && status == http.StatusAccepted {
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "error waiting for snowflake statement: %s", response.StatementHandle)
+		case <-time.After(snowflakePollInterval):
+		}
+		status, response, err = s.request(ctx, http.MethodGet, "/api/v2/statements/"+url.PathEscape(response.StatementHandle), nil)
+	}
+	if err != nil {
+		return err
+	}
+
+	if status != http.StatusOK {
+		return errors.Errorf("snowflake returned status %d: %s %s", status, response.Code, response.Message)
+	}
+	logrus.WithField("statement_handle", response.StatementHandle).Debug("snowflake statement completed")
+	return nil
+}
+
+// request makes a request to the SQL API authenticated with a new JWT, returning its status and decoded response
+func (s *Snowflake) request(ctx context.Context, method string, path string, body []byte) (int, *snowflakeResponse, error) {
+	token, err := s.token(time.Now())
+	if err != nil {
+		return 0, nil, err
+	}
+
+	req, err := http.NewRequest(method, s.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "error creating snowflake request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Snowflake-Authorization-Token-Type", "KEYPAIR_JWT")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "error calling snowflake")
+	}
+	defer resp.Body.Close()
+
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "error reading snowflake response")
+	}
+
+	response := &snowflakeResponse{}
+	if err := json.Unmarshal(contents, response); err != nil {
+		response.Message = strings.TrimSpace(string(contents))
+	}
+	return resp.StatusCode, response, nil
+}
+
+// token returns a new JWT for our user, signed with our key and valid for an hour from the passed in time
+func (s *Snowflake) token(now time.Time) (string, error) {
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	claims := map[string]interface{}{
+		"iss": fmt.Sprintf("%s.%s.%s", s.account, s.user, s.fingerprint),
+		"sub": fmt.Sprintf("%s.%s", s.account, s.user),
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+
+	encode := func(v interface{}) string {
+		j, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(j)
+	}
+	unsigned := encode(header) + "." + encode(claims)
+
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", errors.Wrapf(err, "error signing snowflake token")
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,133 @@
+package archiver
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnowflake(t *testing.T) {
+	ctx := context.Background()
+
+	// no account, no snowflake, but we can still load into it
+	config := NewConfig()
+	snowflake, err := NewSnowflake(config)
+	assert.NoError(t, err)
+	assert.Nil(t, snowflake)
+	assert.NoError(t, snowflake.LoadArchives(ctx, MessageType, []*Archive{{RecordCount: 1, URL: "https://bucket.s3.amazonaws.com/1/a.jsonl.gz"}}))
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+
+	config.SnowflakeAccount = "myorg-myaccount"
+	config.SnowflakeUser = "archiver"
+	config.SnowflakeStage = "archives"
+	config.SnowflakeWarehouse = "loading"
+
+	config.SnowflakePrivateKey = "not a key"
+	_, err = NewSnowflake(config)
+	assert.EqualError(t, err, "snowflake private key isn't PEM encoded")
+
+	// keys can be PKCS#1 or PKCS#8
+	config.SnowflakePrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	_, err = NewSnowflake(config)
+	assert.NoError(t, err)
+
+	config.SnowflakePrivateKey = string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))
+	snowflake, err = NewSnowflake(config)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://myorg-myaccount.snowflakecomputing.com", snowflake.endpoint)
+
+	// our tokens are signed with our key and identify our public key by its fingerprint
+	token, err := snowflake.token(time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	parts := strings.Split(token, ".")
+	assert.Len(t, parts, 3)
+
+	claims := make(map[string]interface{})
+	decoded, _ := base64.RawURLEncoding.DecodeString(parts[1])
+	assert.NoError(t, json.Unmarshal(decoded, &claims))
+	assert.Equal(t, "MYORG-MYACCOUNT.ARCHIVER", claims["sub"])
+	assert.True(t, strings.HasPrefix(claims["iss"].(string), "MYORG-MYACCOUNT.ARCHIVER.SHA256:"))
+	assert.Equal(t, float64(1515414600+3600), claims["exp"])
+
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	assert.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature))
+
+	// statements are run against a fake of the SQL API, which takes a poll to complete them
+	snowflakePollInterval = time.Millisecond
+	defer func() { snowflakePollInterval = time.Second * 2 }()
+
+	requests := make([]string, 0)
+	statements := make([]*snowflakeStatement, 0)
+	failure := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		assert.Equal(t, "KEYPAIR_JWT", r.Header.Get("X-Snowflake-Authorization-Token-Type"))
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "Bearer "))
+
+		if r.Method == http.MethodGet {
+			w.Write([]byte(`{"code": "090001", "message": "Statement executed successfully.", "statementHandle": "abc-123"}`))
+			return
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		statement := &snowflakeStatement{}
+		assert.NoError(t, json.Unmarshal(body, statement))
+		statements = append(statements, statement)
+
+		if failure != "" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			w.Write([]byte(failure))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"code": "333334", "message": "Asynchronous execution in progress.", "statementHandle": "abc-123"}`))
+	}))
+	defer server.Close()
+	snowflake.endpoint = server.URL
+
+	archives := []*Archive{
+		{RecordCount: 3, URL: "https://bucket.s3.amazonaws.com/1/message_D20170812_e7ed.jsonl.gz"},
+		{RecordCount: 0, URL: "https://bucket.s3.amazonaws.com/1/message_D20170813_d41d.jsonl.gz"},
+		{RecordCount: 2, URL: ""},
+		{RecordCount: 3, URL: "https://bucket.s3.amazonaws.com/1/message_M20170801_aa3c.jsonl.gz", Dailies: []*Archive{{}}},
+		{RecordCount: 5, URL: "https://bucket.s3.amazonaws.com/1/message_D20170814_0f3a.jsonl.gz"},
+	}
+	assert.NoError(t, snowflake.LoadArchives(ctx, MessageType, archives))
+	assert.Equal(t, []string{"POST /api/v2/statements", "GET /api/v2/statements/abc-123"}, requests)
+	assert.Len(t, statements, 1)
+	assert.Equal(t, "loading", statements[0].Warehouse)
+	assert.Equal(t, "COPY INTO messages (file, record) FROM (SELECT METADATA$FILENAME, $1 FROM @archives) "+
+		"FILES = ('1/message_D20170812_e7ed.jsonl.gz', '1/message_D20170814_0f3a.jsonl.gz') FILE_FORMAT = (TYPE = JSON)", statements[0].Statement)
+
+	// nothing to load, nothing run
+	requests = requests[:0]
+	assert.NoError(t, snowflake.LoadArchives(ctx, RunType, archives[1:4]))
+	assert.Len(t, requests, 0)
+
+	// statements which fail return why
+	failure = `{"code": "002003", "message": "Table 'RUNS' does not exist or not authorized.", "statementHandle": "abc-456"}`
+	err = snowflake.LoadArchives(ctx, RunType, archives[:1])
+	assert.EqualError(t, err, "error loading 1 run archives into snowflake: snowflake returned status 422: 002003 Table 'RUNS' does not exist or not authorized.")
+
+	_, err = snowflakeCopyStatement("archives", ArchiveType("foo"), []string{"1/foo.jsonl.gz"})
+	assert.EqualError(t, err, "unknown archive type: foo")
+}
@@ -0,0 +1,53 @@
+package archiver
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ArchiveStats are the totals of the archives of an org and type which we still have, ie: haven't been purged
+type ArchiveStats struct {
+	OrgID       int         `db:"org_id"`
+	ArchiveType ArchiveType `db:"archive_type"`
+
+	Dailies   int `db:"dailies"`
+	Monthlies int `db:"monthlies"`
+
+	// the records in those archives, counting those of dailies rolled up into monthlies only once
+	Records int64 `db:"records"`
+
+	// the storage used by those archives by period
+	DailyBytes   int64 `db:"daily_bytes"`
+	MonthlyBytes int64 `db:"monthly_bytes"`
+}
+
+const lookupOrgArchiveStats = `
+SELECT
+	org_id,
+	archive_type,
+	count(*) FILTER (WHERE period = 'D') AS dailies,
+	count(*) FILTER (WHERE period = 'M') AS monthlies,
+	coalesce(sum(record_count) FILTER (WHERE period = 'M' OR rollup_id IS NULL), 0) AS records,
+	coalesce(sum(size) FILTER (WHERE period = 'D'), 0) AS daily_bytes,
+	coalesce(sum(size) FILTER (WHERE period = 'M'), 0) AS monthly_bytes
+FROM archives_archive
+WHERE org_id = $1 AND purged_on IS NULL
+GROUP BY org_id, archive_type
+ORDER BY archive_type
+`
+
+// GetOrgArchiveStats returns the totals of the archives of the passed in org for each type it has archives of
+func GetOrgArchiveStats(ctx context.Context, db *sqlx.DB, org Org) ([]*ArchiveStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	stats := make([]*ArchiveStats, 0, 2)
+	err := db.SelectContext(ctx, &stats, lookupOrgArchiveStats, org.ID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error looking up archive stats for org: %d", org.ID)
+	}
+	return stats, nil
+}
@@ -0,0 +1,37 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetOrgArchiveStats(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	// org 3 has two dailies and a monthly, one of which we roll up into it, and org 2 a single daily
+	db.MustExec(`UPDATE archives_archive SET record_count = 10, size = 100 WHERE org_id = 3 AND period = 'D'`)
+	db.MustExec(`UPDATE archives_archive SET record_count = 30, size = 250 WHERE org_id = 3 AND period = 'M'`)
+	db.MustExec(`UPDATE archives_archive SET rollup_id = (SELECT id FROM archives_archive WHERE org_id = 3 AND period = 'M') WHERE org_id = 3 AND start_date = '2017-09-10'`)
+
+	stats, err := GetOrgArchiveStats(ctx, db, Org{ID: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, []*ArchiveStats{
+		{OrgID: 3, ArchiveType: MessageType, Dailies: 2, Monthlies: 1, Records: 40, DailyBytes: 200, MonthlyBytes: 250},
+	}, stats)
+
+	// purged archives are no longer counted
+	db.MustExec(`UPDATE archives_archive SET purged_on = NOW() WHERE org_id = 3 AND start_date = '2017-09-10'`)
+
+	stats, err = GetOrgArchiveStats(ctx, db, Org{ID: 3})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats[0].Dailies)
+	assert.Equal(t, int64(40), stats[0].Records)
+	assert.Equal(t, int64(100), stats[0].DailyBytes)
+
+	stats, err = GetOrgArchiveStats(ctx, db, Org{ID: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(stats))
+}
@@ -0,0 +1,221 @@
+package archiver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// Status tracks what our archiving loop is doing so that it can be reported over HTTP, it is safe for concurrent use
+// and all methods are safe to call on a nil status
+type Status struct {
+	mutex sync.Mutex
+
+	runStarted    *time.Time
+	orgsTotal     int
+	orgsDone      int
+	archiving     []*StatusOrg
+	runFailures   map[ArchiveType]bool
+	lastSuccesses map[ArchiveType]time.Time
+}
+
+// StatusReport is the JSON representation of our status served on /status
+type StatusReport struct {
+	Running        bool                      `json:"running"`
+	RunStarted     *time.Time                `json:"run_started,omitempty"`
+	CurrentOrgID   int                       `json:"current_org_id,omitempty"`
+	CurrentOrg     string                    `json:"current_org,omitempty"`
+	CurrentType    ArchiveType               `json:"current_type,omitempty"`
+	OrgsRemaining  int                       `json:"orgs_remaining"`
+	Archiving      []*StatusOrg              `json:"archiving"`
+	LastSuccessful map[ArchiveType]time.Time `json:"last_successful"`
+}
+
+// StatusOrg is an org and type which is being archived
+type StatusOrg struct {
+	OrgID       int         `json:"org_id"`
+	Org         string      `json:"org"`
+	ArchiveType ArchiveType `json:"archive_type"`
+}
+
+// NewStatus creates a new empty status
+func NewStatus() *Status {
+	return &Status{
+		runFailures:   make(map[ArchiveType]bool),
+		lastSuccesses: make(map[ArchiveType]time.Time),
+	}
+}
+
+// StartRun notes that we've started a run over the passed in number of orgs
+func (s *Status) StartRun(orgs int) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	s.runStarted = &now
+	s.orgsTotal = orgs
+	s.orgsDone = 0
+	s.runFailures = make(map[ArchiveType]bool)
+}
+
+// StartOrg notes that we've started archiving the passed in org and type, as we may be archiving more than one org at
+// a time, the most recently started is reported as current
+func (s *Status) StartOrg(org Org, archiveType ArchiveType) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.archiving = append(s.archiving, &StatusOrg{OrgID: org.ID, Org: org.Name, ArchiveType: archiveType})
+}
+
+// FinishOrg notes that we've finished archiving the passed in org and type, and whether that failed
+func (s *Status) FinishOrg(org Org, archiveType ArchiveType, failed bool) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i, a := range s.archiving {
+		if a.OrgID == org.ID && a.ArchiveType == archiveType {
+			s.archiving = append(s.archiving[:i], s.archiving[i+1:]...)
+			break
+		}
+	}
+	if failed {
+		s.runFailures[archiveType] = true
+	}
+}
+
+// CompleteOrg notes that we've finished all types for an org
+func (s *Status) CompleteOrg() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.orgsDone++
+}
+
+// FinishRun notes that we've completed our run, recording it as the last successful run of each of the passed in
+// types which had no failures
+func (s *Status) FinishRun(archiveTypes ...ArchiveType) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for _, t := range archiveTypes {
+		if !s.runFailures[t] {
+			s.lastSuccesses[t] = now
+		}
+	}
+	s.runStarted = nil
+	s.orgsTotal = 0
+	s.orgsDone = 0
+}
+
+// Report returns a report of our current status
+func (s *Status) Report() *StatusReport {
+	if s == nil {
+		return &StatusReport{LastSuccessful: make(map[ArchiveType]time.Time)}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	report := &StatusReport{
+		Running:        s.runStarted != nil,
+		RunStarted:     s.runStarted,
+		OrgsRemaining:  s.orgsTotal - s.orgsDone,
+		Archiving:      make([]*StatusOrg, 0, len(s.archiving)),
+		LastSuccessful: make(map[ArchiveType]time.Time, len(s.lastSuccesses)),
+	}
+	for _, a := range s.archiving {
+		report.Archiving = append(report.Archiving, &StatusOrg{OrgID: a.OrgID, Org: a.Org, ArchiveType: a.ArchiveType})
+	}
+	if len(s.archiving) > 0 {
+		current := s.archiving[len(s.archiving)-1]
+		report.CurrentOrgID = current.OrgID
+		report.CurrentOrg = current.Org
+		report.CurrentType = current.ArchiveType
+	}
+	for t, d := range s.lastSuccesses {
+		report.LastSuccessful[t] = d
+	}
+	return report
+}
+
+// NewStatusServer creates an HTTP server on the passed in address which serves /health, checking that our database and
+// S3 bucket are reachable, and /status, reporting the passed in status. If a job queue is passed in, /archive serves it,
+// and if a control is, /run, /pause and /resume serve it.
+func NewStatusServer(address string, config *Config, db *sqlx.DB, s3Client s3iface.S3API, status *Status, jobs *Jobs, control *Control) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), time.Second*10)
+		defer cancel()
+
+		checks := make(map[string]string)
+		healthy := true
+
+		err := db.PingContext(ctx)
+		if err != nil {
+			checks["db"] = err.Error()
+			healthy = false
+		} else {
+			checks["db"] = "ok"
+		}
+
+		if s3Client != nil {
+			err = TestS3(s3Client, config.S3Bucket)
+			if err != nil {
+				checks["s3"] = err.Error()
+				healthy = false
+			} else {
+				checks["s3"] = "ok"
+			}
+		}
+
+		statusCode := http.StatusOK
+		if !healthy {
+			statusCode = http.StatusServiceUnavailable
+		}
+		writeJSON(w, statusCode, checks)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, status.Report())
+	})
+	if jobs != nil {
+		mux.Handle("/archive", jobs)
+		mux.Handle("/archive/", jobs)
+	}
+	if control != nil {
+		mux.Handle("/run", control)
+		mux.Handle("/pause", control)
+		mux.Handle("/resume", control)
+	}
+
+	return &http.Server{Addr: address, Handler: mux, ReadTimeout: time.Second * 15, WriteTimeout: time.Second * 15}
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		logrus.WithError(err).Error("error writing status response")
+	}
+}
@@ -0,0 +1,112 @@
+package archiver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatus(t *testing.T) {
+	status := NewStatus()
+
+	report := status.Report()
+	assert.False(t, report.Running)
+	assert.Equal(t, 0, report.OrgsRemaining)
+	assert.Equal(t, 0, len(report.LastSuccessful))
+
+	org1 := Org{ID: 1, Name: "Org 1"}
+	org2 := Org{ID: 2, Name: "Org 2"}
+	org3 := Org{ID: 3, Name: "Org 3"}
+
+	status.StartRun(3)
+	status.StartOrg(org1, MessageType)
+
+	report = status.Report()
+	assert.True(t, report.Running)
+	assert.NotNil(t, report.RunStarted)
+	assert.Equal(t, 1, report.CurrentOrgID)
+	assert.Equal(t, "Org 1", report.CurrentOrg)
+	assert.Equal(t, MessageType, report.CurrentType)
+	assert.Equal(t, 3, report.OrgsRemaining)
+
+	status.FinishOrg(org1, MessageType, false)
+	status.StartOrg(org1, RunType)
+	status.FinishOrg(org1, RunType, true)
+	status.CompleteOrg()
+
+	report = status.Report()
+	assert.Equal(t, 0, report.CurrentOrgID)
+	assert.Equal(t, ArchiveType(""), report.CurrentType)
+	assert.Equal(t, 0, len(report.Archiving))
+	assert.Equal(t, 2, report.OrgsRemaining)
+
+	// orgs can be archived concurrently, the most recently started is current
+	status.StartOrg(org2, MessageType)
+	status.StartOrg(org3, MessageType)
+
+	report = status.Report()
+	assert.Equal(t, 3, report.CurrentOrgID)
+	assert.Equal(t, []*StatusOrg{{OrgID: 2, Org: "Org 2", ArchiveType: MessageType}, {OrgID: 3, Org: "Org 3", ArchiveType: MessageType}}, report.Archiving)
+
+	status.FinishOrg(org3, MessageType, false)
+	status.FinishOrg(org2, MessageType, false)
+	status.StartOrg(org2, RunType)
+	status.FinishOrg(org2, RunType, false)
+	status.CompleteOrg()
+	status.CompleteOrg()
+	status.FinishRun(MessageType, RunType)
+
+	// runs failed for one org so only messages had a successful run
+	report = status.Report()
+	assert.False(t, report.Running)
+	assert.Equal(t, 0, report.OrgsRemaining)
+	assert.Equal(t, 1, len(report.LastSuccessful))
+	_, found := report.LastSuccessful[MessageType]
+	assert.True(t, found)
+
+	// a nil status is safe to use
+	var nilStatus *Status
+	nilStatus.StartRun(1)
+	nilStatus.StartOrg(org1, MessageType)
+	nilStatus.FinishOrg(org1, MessageType, false)
+	nilStatus.CompleteOrg()
+	nilStatus.FinishRun(MessageType)
+	assert.False(t, nilStatus.Report().Running)
+}
+
+func TestStatusServer(t *testing.T) {
+	db := setup(t)
+
+	status := NewStatus()
+	status.StartRun(3)
+	status.StartOrg(Org{ID: 2, Name: "Org 2"}, RunType)
+
+	server := NewStatusServer(":0", NewConfig(), db, nil, status, nil, nil)
+
+	recorder := httptest.NewRecorder()
+	server.Handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "{\"db\":\"ok\"}\n", recorder.Body.String())
+
+	recorder = httptest.NewRecorder()
+	server.Handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/status", nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	report := &StatusReport{}
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), report))
+	assert.True(t, report.Running)
+	assert.Equal(t, 2, report.CurrentOrgID)
+	assert.Equal(t, "Org 2", report.CurrentOrg)
+	assert.Equal(t, RunType, report.CurrentType)
+	assert.Equal(t, 3, report.OrgsRemaining)
+
+	// an unreachable database is unhealthy
+	db.Close()
+	recorder = httptest.NewRecorder()
+	server.Handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/health", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+}
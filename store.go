@@ -0,0 +1,103 @@
+package archiver
+
+import (
+	"bufio"
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// ArchiveStore is where we find which archives are missing and record those we build. By default this is the
+// archives_archive table of our database, but embedders can provide their own, ie: a fake in tests, as the Store of
+// their Options.
+type ArchiveStore interface {
+	// GetCurrentArchiveCount returns how many archives of the passed in type the passed in org has
+	GetCurrentArchiveCount(ctx context.Context, org Org, archiveType ArchiveType) (int, error)
+
+	// GetMissingDailyArchives returns the daily archives of the passed in org and type which need building
+	GetMissingDailyArchives(ctx context.Context, now time.Time, org Org, archiveType ArchiveType) ([]*Archive, error)
+
+	// GetMissingMonthlyArchives returns the monthly archives of the passed in org and type which need building
+	GetMissingMonthlyArchives(ctx context.Context, now time.Time, org Org, archiveType ArchiveType) ([]*Archive, error)
+
+	// WriteArchive records the passed in built archive, setting its id, returning ErrArchiveExists if it already is
+	WriteArchive(ctx context.Context, archive *Archive) error
+}
+
+// RecordSource is where the records of the archives we build are read from. By default this is our database, or its
+// read replica if it has caught up, but embedders can provide their own, ie: one reading from partitions, as the Source
+// of their Options.
+type RecordSource interface {
+	// CountRecords returns how many records the period of the passed in archive has
+	CountRecords(ctx context.Context, archive *Archive) (int, error)
+
+	// WriteRecords writes the records of the passed in archive to the passed in writer as JSONL, returning how many
+	// were written. The expected number of records is only used to log progress and can be zero if it isn't known.
+	WriteRecords(ctx context.Context, archive *Archive, writer *bufio.Writer, expected int) (int, error)
+}
+
+// DBArchiveStore is the archive store backed by the archives_archive table of our database
+type DBArchiveStore struct {
+	db     *sqlx.DB
+	config *Config
+}
+
+// NewDBArchiveStore returns a new archive store backed by the passed in database, with the write timeout in the
+// passed in config
+func NewDBArchiveStore(db *sqlx.DB, config *Config) *DBArchiveStore {
+	return &DBArchiveStore{db: db, config: config}
+}
+
+// GetCurrentArchiveCount returns how many archives of the passed in type the passed in org has
+func (s *DBArchiveStore) GetCurrentArchiveCount(ctx context.Context, org Org, archiveType ArchiveType) (int, error) {
+	return GetCurrentArchiveCount(ctx, s.db, org, archiveType)
+}
+
+// GetMissingDailyArchives returns the daily archives of the passed in org and type which need building
+func (s *DBArchiveStore) GetMissingDailyArchives(ctx context.Context, now time.Time, org Org, archiveType ArchiveType) ([]*Archive, error) {
+	return GetMissingDailyArchives(ctx, s.db, now, org, archiveType)
+}
+
+// GetMissingMonthlyArchives returns the monthly archives of the passed in org and type which need building
+func (s *DBArchiveStore) GetMissingMonthlyArchives(ctx context.Context, now time.Time, org Org, archiveType ArchiveType) ([]*Archive, error) {
+	return GetMissingMonthlyArchives(ctx, s.db, now, org, archiveType)
+}
+
+// WriteArchive writes the passed in archive to the database
+func (s *DBArchiveStore) WriteArchive(ctx context.Context, archive *Archive) error {
+	return writeArchiveToDB(ctx, s.db, archive, time.Duration(s.config.WriteTimeoutSeconds)*time.Second)
+}
+
+// DBRecordSource is the record source which exports records from our database with the queries, paging, pacing and
+// timeouts in its config
+type DBRecordSource struct {
+	db          *sqlx.DB
+	config      *Config
+	archivePath string
+	serializers map[ArchiveType]RecordSerializer
+}
+
+// NewDBRecordSource returns a new record source which exports records from the passed in database, checking there is
+// room for them in the temp directory of the passed in config
+func NewDBRecordSource(db *sqlx.DB, config *Config) *DBRecordSource {
+	return &DBRecordSource{db: db, config: config, archivePath: config.TempDir}
+}
+
+// CountRecords returns how many records the period of the passed in archive has in the database
+func (s *DBRecordSource) CountRecords(ctx context.Context, archive *Archive) (int, error) {
+	return countArchivableRecords(ctx, s.db, archive)
+}
+
+// WriteRecords writes the records of the passed in archive from the database to the passed in writer
+func (s *DBRecordSource) WriteRecords(ctx context.Context, archive *Archive, writer *bufio.Writer, expected int) (int, error) {
+	log := logrus.WithFields(logrus.Fields{
+		"org_id":       archive.Org.ID,
+		"archive_type": archive.ArchiveType,
+		"start_date":   archive.StartDate,
+		"end_date":     archive.endDate(),
+		"period":       archive.Period,
+	})
+	return exportRecords(ctx, s.db, archive, s.archivePath, s.config, recordSerializer(s.serializers, archive), log, writer, expected)
+}
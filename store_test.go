@@ -0,0 +1,94 @@
+package archiver
+
+import (
+	"bufio"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testArchiveStore struct {
+	monthly []*Archive
+	daily   []*Archive
+	written []*Archive
+}
+
+func (s *testArchiveStore) GetCurrentArchiveCount(ctx context.Context, org Org, archiveType ArchiveType) (int, error) {
+	return len(s.written), nil
+}
+
+func (s *testArchiveStore) GetMissingDailyArchives(ctx context.Context, now time.Time, org Org, archiveType ArchiveType) ([]*Archive, error) {
+	return s.daily, nil
+}
+
+func (s *testArchiveStore) GetMissingMonthlyArchives(ctx context.Context, now time.Time, org Org, archiveType ArchiveType) ([]*Archive, error) {
+	return s.monthly, nil
+}
+
+func (s *testArchiveStore) WriteArchive(ctx context.Context, archive *Archive) error {
+	s.written = append(s.written, archive)
+	archive.ID = len(s.written)
+	return nil
+}
+
+type testRecordSource struct {
+	records map[time.Time][]string
+}
+
+func (s *testRecordSource) CountRecords(ctx context.Context, archive *Archive) (int, error) {
+	return len(s.records[archive.StartDate]), nil
+}
+
+func (s *testRecordSource) WriteRecords(ctx context.Context, archive *Archive, writer *bufio.Writer, expected int) (int, error) {
+	for _, record := range s.records[archive.StartDate] {
+		writer.WriteString(record)
+		writer.WriteString("\n")
+	}
+	return len(s.records[archive.StartDate]), nil
+}
+
+func TestArchiveStoreAndRecordSource(t *testing.T) {
+	org := Org{ID: 1, Name: "Test", CreatedOn: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), RetentionPeriod: 90}
+	now := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+	jan := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	oct1 := time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)
+	oct2 := time.Date(2017, 10, 2, 0, 0, 0, 0, time.UTC)
+
+	store := &testArchiveStore{
+		monthly: []*Archive{{Org: org, OrgID: org.ID, ArchiveType: MessageType, Period: MonthPeriod, StartDate: jan}},
+		daily: []*Archive{
+			{Org: org, OrgID: org.ID, ArchiveType: MessageType, Period: DayPeriod, StartDate: oct1},
+			{Org: org, OrgID: org.ID, ArchiveType: MessageType, Period: DayPeriod, StartDate: oct2},
+		},
+	}
+	source := &testRecordSource{records: map[time.Time][]string{
+		jan:  {`{"id":1}`, `{"id":2}`},
+		oct1: {`{"id":3}`},
+	}}
+
+	config := NewConfig()
+	config.UploadToS3 = false
+
+	// no database is needed when both are provided
+	opts := &Options{Store: store, Source: source}
+	created, err := CreateOrgArchives(context.Background(), now, config, nil, nil, org, MessageType, opts)
+	assert.NoError(t, err)
+
+	if assert.Equal(t, 3, len(created)) && assert.Equal(t, 3, len(store.written)) {
+		assert.Equal(t, 1, store.written[0].ID)
+		assert.Equal(t, MonthPeriod, store.written[0].Period)
+		assert.Equal(t, 2, store.written[0].RecordCount)
+		assert.Equal(t, 1, store.written[1].RecordCount)
+		assert.Equal(t, 0, store.written[2].RecordCount)
+		assert.NotEqual(t, store.written[1].Hash, store.written[2].Hash)
+	}
+
+	// otherwise our database is used
+	var none *Options
+	assert.IsType(t, &DBArchiveStore{}, none.archiveStore(nil, config))
+	assert.IsType(t, &DBRecordSource{}, none.recordSource(nil, "/tmp", config))
+	assert.IsType(t, &DBRecordSource{}, (&Options{Store: store}).recordSource(nil, "/tmp", config))
+	assert.Equal(t, store, opts.archiveStore(nil, config))
+}
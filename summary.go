@@ -0,0 +1,157 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/pkg/errors"
+)
+
+// RunSummary is a machine readable summary of a single run of our archiving loop over all active orgs, it is safe to
+// add orgs to concurrently
+type RunSummary struct {
+	mutex sync.Mutex
+
+	Database        string          `json:"database,omitempty"`
+	StartedOn       time.Time       `json:"started_on"`
+	FinishedOn      time.Time       `json:"finished_on"`
+	OrgsProcessed   int             `json:"orgs_processed"`
+	ArchivesCreated int             `json:"archives_created"`
+	RecordsArchived int             `json:"records_archived"`
+	BytesArchived   int64           `json:"bytes_archived"`
+	ArchivesDeleted int             `json:"archives_deleted"`
+	RecordsDeleted  int             `json:"records_deleted"`
+	Orgs            []*RunOrgResult `json:"orgs"`
+	Failures        []*RunFailure   `json:"failures"`
+	OrgsRemaining   []int           `json:"orgs_remaining,omitempty"`
+}
+
+// RunOrgResult is the result of archiving a single org and type during a run
+type RunOrgResult struct {
+	OrgID           int         `json:"org_id"`
+	ArchiveType     ArchiveType `json:"archive_type"`
+	ArchivesCreated int         `json:"archives_created"`
+	ArchivesFailed  int         `json:"archives_failed"`
+	RecordsArchived int         `json:"records_archived"`
+	ArchivesDeleted int         `json:"archives_deleted"`
+	RecordsDeleted  int         `json:"records_deleted"`
+	Succeeded       bool        `json:"succeeded"`
+}
+
+// RunFailure is an org and type, or a single archive of them, which failed during a run
+type RunFailure struct {
+	OrgID       int           `json:"org_id"`
+	ArchiveType ArchiveType   `json:"archive_type"`
+	Period      ArchivePeriod `json:"period,omitempty"`
+	StartDate   string        `json:"start_date,omitempty"`
+	Reason      string        `json:"reason"`
+	Class       string        `json:"class,omitempty"`
+}
+
+// NewRunSummary creates a new empty summary for a run started at the passed in time
+func NewRunSummary(startedOn time.Time) *RunSummary {
+	return &RunSummary{StartedOn: startedOn, Orgs: make([]*RunOrgResult, 0), Failures: make([]*RunFailure, 0)}
+}
+
+// AddOrg adds the results of archiving the passed in org and type, ie: the result of ArchiveOrg, to this summary
+func (s *RunSummary) AddOrg(org Org, archiveType ArchiveType, created []*Archive, deleted []*Archive, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	result := &RunOrgResult{OrgID: org.ID, ArchiveType: archiveType}
+	s.Orgs = append(s.Orgs, result)
+
+	for _, a := range created {
+		if a.ID == 0 {
+			result.ArchivesFailed++
+			reason := "unknown error"
+			if a.BuildError != nil {
+				reason = failureReason(a.BuildError)
+			}
+			s.Failures = append(s.Failures, &RunFailure{
+				OrgID:       org.ID,
+				ArchiveType: archiveType,
+				Period:      a.Period,
+				StartDate:   a.StartDate.In(time.UTC).Format("2006-01-02"),
+				Reason:      reason,
+				Class:       errorClassName(a.BuildError),
+			})
+			continue
+		}
+
+		result.ArchivesCreated++
+		result.RecordsArchived += a.RecordCount
+		s.ArchivesCreated++
+		s.RecordsArchived += a.RecordCount
+		s.BytesArchived += a.Size
+	}
+
+	for _, a := range deleted {
+		result.ArchivesDeleted++
+		result.RecordsDeleted += a.RecordCount
+		s.ArchivesDeleted++
+		s.RecordsDeleted += a.RecordCount
+	}
+
+	result.Succeeded = err == nil && result.ArchivesFailed == 0
+	if err != nil {
+		s.Failures = append(s.Failures, &RunFailure{OrgID: org.ID, ArchiveType: archiveType, Reason: err.Error()})
+	}
+}
+
+// CompleteOrg notes that we've finished all types for an org
+func (s *RunSummary) CompleteOrg() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.OrgsProcessed++
+}
+
+// WriteRunSummary writes the passed in summary as JSON to the passed in destination, which is either - for stdout,
+// an s3://bucket/key URL or a local file path
+func WriteRunSummary(ctx context.Context, s3Client s3iface.S3API, destination string, summary *RunSummary) error {
+	contents, err := json.Marshal(summary)
+	if err != nil {
+		return errors.Wrapf(err, "error marshalling run summary")
+	}
+	contents = append(contents, '\n')
+
+	if destination == "-" {
+		_, err = os.Stdout.Write(contents)
+		return err
+	}
+
+	if strings.HasPrefix(destination, "s3://") {
+		if s3Client == nil {
+			return errors.Errorf("unable to write run summary to %s without uploading to S3", destination)
+		}
+
+		u, err := url.Parse(destination)
+		if err != nil {
+			return errors.Wrapf(err, "invalid S3 URL: %s", destination)
+		}
+
+		ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+		defer cancel()
+
+		_, err = UploadStreamToS3(ctx, s3Client, u.Host, u.Path, "application/json", bytes.NewReader(contents))
+		if err != nil {
+			return errors.Wrapf(err, "error writing run summary to: %s", destination)
+		}
+		return nil
+	}
+
+	err = ioutil.WriteFile(destination, contents, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "error writing run summary to: %s", destination)
+	}
+	return nil
+}
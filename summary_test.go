@@ -0,0 +1,69 @@
+package archiver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunSummary(t *testing.T) {
+	start := time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC)
+	summary := NewRunSummary(start)
+
+	org := Org{ID: 5}
+	created := []*Archive{
+		{ID: 1, Period: DayPeriod, StartDate: time.Date(2017, 8, 10, 0, 0, 0, 0, time.UTC), RecordCount: 10, Size: 100},
+		{ID: 0, Period: DayPeriod, StartDate: time.Date(2017, 8, 11, 0, 0, 0, 0, time.UTC), BuildError: errors.New("error writing archive file")},
+	}
+	deleted := []*Archive{{ID: 3, RecordCount: 7}}
+
+	summary.AddOrg(org, MessageType, created, deleted, nil)
+	summary.AddOrg(org, RunType, nil, nil, errors.New("error rolling up archives"))
+	summary.CompleteOrg()
+	summary.FinishedOn = start.Add(time.Minute)
+	summary.OrgsRemaining = []int{6, 7}
+
+	assert.Equal(t, 1, summary.OrgsProcessed)
+	assert.Equal(t, 1, summary.ArchivesCreated)
+	assert.Equal(t, 10, summary.RecordsArchived)
+	assert.Equal(t, int64(100), summary.BytesArchived)
+	assert.Equal(t, 1, summary.ArchivesDeleted)
+	assert.Equal(t, 7, summary.RecordsDeleted)
+	assert.Equal(t, []*RunOrgResult{
+		{OrgID: 5, ArchiveType: MessageType, ArchivesCreated: 1, ArchivesFailed: 1, RecordsArchived: 10, ArchivesDeleted: 1, RecordsDeleted: 7},
+		{OrgID: 5, ArchiveType: RunType},
+	}, summary.Orgs)
+	assert.Equal(t, []*RunFailure{
+		{OrgID: 5, ArchiveType: MessageType, Period: DayPeriod, StartDate: "2017-08-11", Reason: "error writing archive file"},
+		{OrgID: 5, ArchiveType: RunType, Reason: "error rolling up archives"},
+	}, summary.Failures)
+
+	// write it to a file and read it back
+	file, err := ioutil.TempFile("", "summary_")
+	assert.NoError(t, err)
+	file.Close()
+	defer os.Remove(file.Name())
+
+	err = WriteRunSummary(context.Background(), nil, file.Name(), summary)
+	assert.NoError(t, err)
+
+	contents, err := ioutil.ReadFile(file.Name())
+	assert.NoError(t, err)
+
+	parsed := &RunSummary{}
+	assert.NoError(t, json.Unmarshal(contents, parsed))
+	assert.Equal(t, summary.ArchivesCreated, parsed.ArchivesCreated)
+	assert.Equal(t, summary.Failures, parsed.Failures)
+	assert.Equal(t, []int{6, 7}, parsed.OrgsRemaining)
+	assert.True(t, summary.FinishedOn.Equal(parsed.FinishedOn))
+
+	// can't write to S3 without a client
+	err = WriteRunSummary(context.Background(), nil, "s3://bucket/summary.json", summary)
+	assert.EqualError(t, err, "unable to write run summary to s3://bucket/summary.json without uploading to S3")
+}
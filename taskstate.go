@@ -0,0 +1,126 @@
+package archiver
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ArchiveState is the stage of archiving an archive has reached
+type ArchiveState string
+
+// the stages of archiving, in the order archives move through them. An archive only has a row in the database once
+// it is committed, so it is only from then on that its state is persisted, before that a crash leaves nothing behind
+// and the archive is found to be missing and built again on the next run.
+const (
+	ArchivePending   = ArchiveState("pending")
+	ArchiveBuilding  = ArchiveState("building")
+	ArchiveBuilt     = ArchiveState("built")
+	ArchiveUploading = ArchiveState("uploading")
+	ArchiveUploaded  = ArchiveState("uploaded")
+	ArchiveCommitted = ArchiveState("committed")
+	ArchiveDeleting  = ArchiveState("deleting")
+	ArchiveDone      = ArchiveState("done")
+	ArchiveFailed    = ArchiveState("failed")
+)
+
+// the states each state can move to, archives which failed can only be resumed from deleting, as earlier stages are
+// started over with a new archive
+var archiveTransitions = map[ArchiveState][]ArchiveState{
+	ArchivePending:   {ArchiveBuilding, ArchiveFailed},
+	ArchiveBuilding:  {ArchiveBuilt, ArchiveFailed},
+	ArchiveBuilt:     {ArchiveUploading, ArchiveCommitted, ArchiveFailed},
+	ArchiveUploading: {ArchiveUploaded, ArchiveFailed},
+	ArchiveUploaded:  {ArchiveCommitted, ArchiveFailed},
+	ArchiveCommitted: {ArchiveDeleting},
+	ArchiveDeleting:  {ArchiveDone, ArchiveFailed},
+	ArchiveFailed:    {ArchiveDeleting},
+	ArchiveDone:      {},
+}
+
+// CanMoveTo returns whether an archive in this state can move to the passed in state
+func (s ArchiveState) CanMoveTo(to ArchiveState) bool {
+	if s == to {
+		return true
+	}
+	for _, t := range archiveTransitions[s] {
+		if t == to {
+			return true
+		}
+	}
+	return false
+}
+
+// CurrentState returns the state of this archive. Archives written before we persisted states don't have one, those
+// are committed or done depending on whether their records have been deleted.
+func (a *Archive) CurrentState() ArchiveState {
+	if a.State != "" {
+		return a.State
+	}
+	if a.ID == 0 {
+		return ArchivePending
+	}
+	if a.DeletedOn != nil {
+		return ArchiveDone
+	}
+	return ArchiveCommitted
+}
+
+// moveTo moves this archive to the passed in state, returning an error if it can't move there from its current one
+func (a *Archive) moveTo(to ArchiveState) error {
+	from := a.CurrentState()
+	if !from.CanMoveTo(to) {
+		return errors.Errorf("archive can't move from %s to %s", from, to)
+	}
+	a.State = to
+	return nil
+}
+
+// failed moves this archive to failed, from any state but done
+func (a *Archive) failed() {
+	if a.CurrentState() != ArchiveDone {
+		a.State = ArchiveFailed
+	}
+}
+
+const setArchiveState = `
+UPDATE archives_archive
+SET state = $2, state_error = $3
+WHERE id = $1
+`
+
+// recordArchiveState moves the passed in archive, which must have been committed, to the passed in state and writes it
+// to the database along with the error which failed it, if any
+func recordArchiveState(ctx context.Context, db *sqlx.DB, archive *Archive, to ArchiveState, failure error) error {
+	if to == ArchiveFailed {
+		archive.failed()
+	} else if err := archive.moveTo(to); err != nil {
+		return err
+	}
+
+	var stateError *string
+	if failure != nil {
+		reason := failureReason(failure)
+		stateError = &reason
+	}
+	archive.StateError = stateError
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, setArchiveState, archive.ID, archive.State, stateError)
+	if err != nil {
+		return errors.Wrapf(err, "error recording state of archive: %d", archive.ID)
+	}
+	return nil
+}
+
+// logStateError logs an error recording the state of the passed in archive, which doesn't stop us working on it
+func logStateError(archive *Archive, err error) {
+	if err != nil {
+		logrus.WithError(err).WithField("archive_id", archive.ID).WithField("state", archive.State).Error("error recording archive state")
+	}
+}
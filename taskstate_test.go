@@ -0,0 +1,69 @@
+package archiver
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveState(t *testing.T) {
+	assert.True(t, ArchivePending.CanMoveTo(ArchiveBuilding))
+	assert.True(t, ArchiveBuilt.CanMoveTo(ArchiveCommitted))
+	assert.True(t, ArchiveDeleting.CanMoveTo(ArchiveDeleting))
+	assert.True(t, ArchiveFailed.CanMoveTo(ArchiveDeleting))
+	assert.False(t, ArchivePending.CanMoveTo(ArchiveCommitted))
+	assert.False(t, ArchiveCommitted.CanMoveTo(ArchiveBuilding))
+	assert.False(t, ArchiveDone.CanMoveTo(ArchiveDeleting))
+
+	// archives without a state are pending until written, then committed or done
+	archive := &Archive{}
+	assert.Equal(t, ArchivePending, archive.CurrentState())
+	archive.ID = 3
+	assert.Equal(t, ArchiveCommitted, archive.CurrentState())
+	deletedOn := time.Now()
+	archive.DeletedOn = &deletedOn
+	assert.Equal(t, ArchiveDone, archive.CurrentState())
+	assert.EqualError(t, archive.moveTo(ArchiveDeleting), "archive can't move from done to deleting")
+	archive.failed()
+	assert.Equal(t, ArchiveDone, archive.CurrentState())
+
+	archive = &Archive{ID: 4, State: ArchiveFailed}
+	assert.NoError(t, archive.moveTo(ArchiveDeleting))
+	assert.Equal(t, ArchiveDeleting, archive.State)
+
+	// archives we create move through each stage, stopping at failed if they fail
+	org := Org{ID: 1, Name: "Test", CreatedOn: time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), RetentionPeriod: 90}
+	jan := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	oct1 := time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	store := &failingArchiveStore{testArchiveStore{
+		monthly: []*Archive{{Org: org, OrgID: org.ID, ArchiveType: MessageType, Period: MonthPeriod, StartDate: jan}},
+		daily:   []*Archive{{Org: org, OrgID: org.ID, ArchiveType: MessageType, Period: DayPeriod, StartDate: oct1}},
+	}}
+	source := &testRecordSource{records: map[time.Time][]string{jan: {`{"id":1}`}, oct1: {`{"id":2}`}}}
+
+	var mutex sync.Mutex
+	states := make(map[ArchivePeriod][]ArchiveState)
+	record := func(ctx context.Context, archive *Archive) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		states[archive.Period] = append(states[archive.Period], archive.State)
+	}
+	hooks := &Hooks{OnArchiveBuilt: record, OnCommitted: record, OnError: func(ctx context.Context, archive *Archive, err error) { record(ctx, archive) }}
+
+	config := NewConfig()
+	config.UploadToS3 = false
+	config.ArchiveWorkers = 1
+
+	opts := &Options{Store: store, Source: source, Hooks: hooks}
+	_, err := CreateOrgArchives(context.Background(), time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC), config, nil, nil, org, MessageType, opts)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []ArchiveState{ArchiveBuilt, ArchiveFailed}, states[MonthPeriod])
+	assert.Equal(t, []ArchiveState{ArchiveBuilt, ArchiveCommitted}, states[DayPeriod])
+	assert.Equal(t, ArchiveFailed, store.monthly[0].State)
+	assert.Equal(t, ArchiveCommitted, store.daily[0].State)
+}
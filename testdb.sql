@@ -1,5 +1,14 @@
 CREATE EXTENSION IF NOT EXISTS HSTORE;
 
+-- tables owned by the archiver itself, these are recreated by EnsureSchema
+DROP TABLE IF EXISTS archiver_pause CASCADE;
+DROP TABLE IF EXISTS archiver_legal_hold CASCADE;
+DROP TABLE IF EXISTS archiver_quarantine CASCADE;
+DROP TABLE IF EXISTS archive_deletions CASCADE;
+DROP TABLE IF EXISTS archiver_run_orgs CASCADE;
+DROP TABLE IF EXISTS archiver_runs CASCADE;
+DROP TABLE IF EXISTS archiver_org_reports CASCADE;
+
 DROP TABLE IF EXISTS orgs_language CASCADE;
 CREATE TABLE orgs_language (
     id serial primary key,
@@ -9,6 +18,7 @@ CREATE TABLE orgs_language (
 DROP TABLE IF EXISTS orgs_org CASCADE;
 CREATE TABLE orgs_org (
     id serial primary key,
+    uuid character varying(36) NOT NULL,
     name character varying(255) NOT NULL,
     primary_language_id integer references orgs_language(id) on delete cascade,
     is_anon boolean NOT NULL,
@@ -112,6 +122,8 @@ CREATE TABLE msgs_broadcast (
     org_id integer NOT NULL references orgs_org(id) on delete cascade
 );
 
+ALTER TABLE msgs_msg ADD CONSTRAINT msgs_msg_broadcast_id_fk FOREIGN KEY (broadcast_id) REFERENCES msgs_broadcast(id) DEFERRABLE INITIALLY DEFERRED;
+
 DROP TABLE IF EXISTS msgs_broadcast_contacts;
 CREATE TABLE msgs_broadcast_contacts (
     id serial primary key,
@@ -147,6 +159,14 @@ CREATE TABLE msgs_label (
     name character varying(64)
 );
 
+DROP TABLE IF EXISTS msgs_labelcount CASCADE;
+CREATE TABLE msgs_labelcount (
+    id serial primary key,
+    label_id integer NOT NULL references msgs_label(id),
+    "count" integer NOT NULL,
+    is_squashed boolean NOT NULL
+);
+
 CREATE TABLE msgs_msg_labels (
     id serial primary key,
     msg_id integer NOT NULL references msgs_msg(id),
@@ -160,16 +180,47 @@ CREATE TABLE flows_flow (
     name character varying(128) NOT NULL
 );
 
+DROP TABLE IF EXISTS flows_flowlabel CASCADE;
+CREATE TABLE flows_flowlabel (
+    id serial primary key,
+    uuid character varying(36) NOT NULL,
+    name character varying(64) NOT NULL,
+    org_id integer NOT NULL references orgs_org(id) on delete cascade
+);
+
+DROP TABLE IF EXISTS flows_flow_labels CASCADE;
+CREATE TABLE flows_flow_labels (
+    id serial primary key,
+    flow_id integer NOT NULL references flows_flow(id),
+    flowlabel_id integer NOT NULL references flows_flowlabel(id)
+);
+
 DROP TABLE IF EXISTS auth_user CASCADE;
 CREATE TABLE auth_user (
     id serial primary key,
-    username character varying(128) NOT NULL
+    username character varying(128) NOT NULL,
+    email character varying(254) NOT NULL,
+    is_active boolean NOT NULL
+);
+
+DROP TABLE IF EXISTS orgs_org_administrators CASCADE;
+CREATE TABLE orgs_org_administrators (
+    id serial primary key,
+    org_id integer NOT NULL references orgs_org(id),
+    user_id integer NOT NULL references auth_user(id)
 );
 
 DROP TABLE IF EXISTS api_webhookresult CASCADE;
 DROP TABLE IF EXISTS api_webhookevent CASCADE;
 DROP TABLE IF EXISTS flows_flowpathrecentrun CASCADE;
 DROP TABLE IF EXISTS flows_actionlog CASCADE;
+DROP TABLE IF EXISTS flows_flowsession CASCADE;
+CREATE TABLE flows_flowsession (
+    id serial primary key,
+    status character varying(1) NOT NULL,
+    org_id integer NOT NULL references orgs_org(id)
+);
+
 DROP TABLE IF EXISTS flows_flowrun CASCADE;
 CREATE TABLE flows_flowrun (
     id serial primary key,
@@ -188,7 +239,8 @@ CREATE TABLE flows_flowrun (
     exited_on timestamp with time zone NULL,
     submitted_by_id integer NULL references auth_user(id),
     exit_type varchar(1) NULL,
-    delete_reason char(1) NULL
+    delete_reason char(1) NULL,
+    session_id integer NULL references flows_flowsession(id)
 );
 
 DROP TABLE IF EXISTS archives_archive CASCADE;
@@ -222,11 +274,11 @@ CREATE TABLE flows_flowpathrecentrun (
 INSERT INTO orgs_language(id, iso_code) VALUES 
 (1, 'eng');
 
-INSERT INTO orgs_org(id, name, is_active, is_anon, created_on, primary_language_id) VALUES
-(1, 'Org 1', TRUE, FALSE, '2017-11-10 21:11:59.890662+00', 1),
-(2, 'Org 2', TRUE, FALSE, '2017-08-10 21:11:59.890662+00', 1),
-(3, 'Org 3', TRUE, TRUE, '2017-08-10 21:11:59.890662+00', NULL),
-(4, 'Org 4', FALSE, TRUE, '2017-08-10 21:11:59.890662+00', 1);
+INSERT INTO orgs_org(id, uuid, name, is_active, is_anon, created_on, primary_language_id) VALUES
+(1, '9a8b001e-a913-486c-80f4-1356e23f582e', 'Org 1', TRUE, FALSE, '2017-11-10 21:11:59.890662+00', 1),
+(2, 'e2e24ea1-3b1c-4d1c-8bc6-1a1bbc1a1a2d', 'Org 2', TRUE, FALSE, '2017-08-10 21:11:59.890662+00', 1),
+(3, '7a3c6a52-5ba6-4d4e-8c0e-0b5a3e0c4f8b', 'Org 3', TRUE, TRUE, '2017-08-10 21:11:59.890662+00', NULL),
+(4, 'c3d1f2a0-9f3e-4b6f-a7c2-5d3e1f0a9b84', 'Org 4', FALSE, TRUE, '2017-08-10 21:11:59.890662+00', 1);
 
 INSERT INTO channels_channel(id, uuid, name, org_id) VALUES
 (1, '8c1223c3-bd43-466b-81f1-e7266a9f4465', 'Channel 1', 1),
@@ -300,6 +352,10 @@ INSERT INTO msgs_msg_labels(id, msg_id, label_id) VALUES
 (3, 2, 2),
 (4, 3, 2);
 
+INSERT INTO msgs_labelcount(id, label_id, "count", is_squashed) VALUES
+(1, 1, 1, TRUE),
+(2, 2, 2, TRUE);
+
 INSERT INTO channels_channellog(id, msg_id) VALUES 
 (1, 1),
 (2, 2),
@@ -314,8 +370,21 @@ INSERT INTO flows_flow(id, uuid, name) VALUES
 (3, '3914b88e-625b-4603-bd9f-9319dc331c6b', 'Flow 3'),
 (4, 'cfa2371d-2f06-481d-84b2-d974f3803bb0', 'Flow 4');
 
-INSERT INTO auth_user(id, username) VALUES 
-(1, 'greg@gmail.com');
+INSERT INTO flows_flowlabel(id, uuid, name, org_id) VALUES
+(1, '0d8ad6c3-3d3b-4dd6-9b3e-6d7c3f0b2fbb', 'Research', 3);
+
+INSERT INTO flows_flow_labels(id, flow_id, flowlabel_id) VALUES
+(1, 2, 1);
+
+INSERT INTO auth_user(id, username, email, is_active) VALUES 
+(1, 'greg@gmail.com', 'greg@gmail.com', TRUE),
+(2, 'ann@nyaruka.com', 'ann@nyaruka.com', TRUE),
+(3, 'bob@nyaruka.com', 'bob@nyaruka.com', FALSE);
+
+INSERT INTO orgs_org_administrators(org_id, user_id) VALUES
+(2, 2),
+(2, 3),
+(3, 1);
 
 INSERT INTO flows_flowrun(id, uuid, responded, contact_id, flow_id, org_id, results, path, events, created_on, modified_on, exited_on, exit_type, parent_id, submitted_by_id) VALUES
 (1, '4ced1260-9cfe-4b7f-81dd-b637108f15b9', TRUE, 6, 1, 2, '{}', '[]', '[]', '2017-08-12 21:11:59.890662+02:00','2017-08-12 21:11:59.890662+02:00','2017-08-12 21:11:59.890662+02:00', 'C', NULL, NULL),
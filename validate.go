@@ -0,0 +1,74 @@
+package archiver
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// requiredFields are the fields which must be present and not null in every record of each archive type
+var requiredFields = map[ArchiveType][]string{
+	MessageType: {"id", "contact", "direction", "visibility", "created_on", "modified_on"},
+	RunType:     {"id", "uuid", "flow", "contact", "created_on", "modified_on", "exited_on"},
+}
+
+// maxRecordSize is the largest single record we will read when validating, this is well above anything we write
+const maxRecordSize = 64 * 1024 * 1024
+
+// ValidateArchiveFile re-reads the local file of the passed in archive, checking that it decompresses, that every line
+// is a JSON object with the required fields for its type and that it contains the number of records we wrote. This
+// lets us fail an archive before it is uploaded rather than find it is unreadable later.
+func ValidateArchiveFile(archive *Archive) error {
+	required, found := requiredFields[archive.ArchiveType]
+	if !found {
+		return fmt.Errorf("unknown archive type: %s", archive.ArchiveType)
+	}
+
+	file, err := os.Open(archive.ArchiveFile)
+	if err != nil {
+		return errors.Wrapf(err, "error opening archive file: %s", archive.ArchiveFile)
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return errors.Wrapf(err, "error creating gzip reader for archive file: %s", archive.ArchiveFile)
+	}
+	defer gzipReader.Close()
+
+	scanner := bufio.NewScanner(gzipReader)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+
+		record := make(map[string]json.RawMessage)
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			return errors.Wrapf(err, "invalid JSON on line %d", line)
+		}
+
+		for _, field := range required {
+			value, present := record[field]
+			if !present || string(value) == "null" {
+				return fmt.Errorf("missing required field '%s' on line %d", field, line)
+			}
+		}
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return errors.Wrapf(err, "error reading archive file: %s", archive.ArchiveFile)
+	}
+
+	if line != archive.RecordCount {
+		return fmt.Errorf("archive file has %d records, expected %d", line, archive.RecordCount)
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package archiver
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestArchiveFile(t *testing.T, contents string) string {
+	file, err := ioutil.TempFile("", "validate_")
+	assert.NoError(t, err)
+	defer file.Close()
+
+	gzWriter := gzip.NewWriter(file)
+	_, err = gzWriter.Write([]byte(contents))
+	assert.NoError(t, err)
+	assert.NoError(t, gzWriter.Close())
+	return file.Name()
+}
+
+func TestValidateArchiveFile(t *testing.T) {
+	msg := `{"id":1,"contact":{"uuid":"3e814add-e614-41f7-8b5d-a07f670a698f","name":"Ajodinabiff Dane"},"direction":"in","visibility":"visible","created_on":"2017-08-12T21:11:59.890662+00:00","modified_on":"2017-08-12T21:11:59.890662+00:00","sent_on":null}`
+	run := `{"id":1,"uuid":"4ced1260-9cfe-4b7f-81dd-b637108f15b9","flow":{"uuid":"6639286a-9120-45d4-aa39-03ae3942a4a6","name":"Flow 1"},"contact":{"uuid":"3e814add-e614-41f7-8b5d-a07f670a698f","name":"Ajodinabiff Dane"},"created_on":"2017-08-12T19:11:59.890662+00:00","modified_on":"2017-08-12T19:11:59.890662+00:00","exited_on":"2017-08-12T19:11:59.890662+00:00"}`
+
+	tcs := []struct {
+		archiveType ArchiveType
+		contents    string
+		recordCount int
+		err         string
+	}{
+		{MessageType, "", 0, ""},
+		{MessageType, msg + "\n" + msg + "\n", 2, ""},
+		{RunType, run + "\n", 1, ""},
+		{MessageType, msg + "\n" + `{"id":2,"contact"` + "\n", 2, "invalid JSON on line 2: unexpected end of JSON input"},
+		{MessageType, run + "\n", 1, "missing required field 'direction' on line 1"},
+		{RunType, `{"id":1,"uuid":"4ced1260-9cfe-4b7f-81dd-b637108f15b9","flow":{},"contact":{},"created_on":"2017-08-12","modified_on":"2017-08-12","exited_on":null}` + "\n", 1, "missing required field 'exited_on' on line 1"},
+		{MessageType, msg + "\n", 2, "archive file has 1 records, expected 2"},
+	}
+
+	for i, tc := range tcs {
+		filename := writeTestArchiveFile(t, tc.contents)
+		archive := &Archive{ArchiveType: tc.archiveType, ArchiveFile: filename, RecordCount: tc.recordCount}
+
+		err := ValidateArchiveFile(archive)
+		if tc.err == "" {
+			assert.NoError(t, err, "%d: unexpected error", i)
+		} else {
+			assert.EqualError(t, err, tc.err, "%d: error mismatch", i)
+		}
+		os.Remove(filename)
+	}
+
+	// lines which are valid JSON but not objects are rejected
+	filename := writeTestArchiveFile(t, "[1, 2]\n")
+	defer os.Remove(filename)
+
+	err := ValidateArchiveFile(&Archive{ArchiveType: MessageType, ArchiveFile: filename, RecordCount: 1})
+	assert.Error(t, err)
+
+	// files which aren't gzipped are rejected
+	file, err := ioutil.TempFile("", "validate_")
+	assert.NoError(t, err)
+	file.WriteString(msg + "\n")
+	file.Close()
+	defer os.Remove(file.Name())
+
+	err = ValidateArchiveFile(&Archive{ArchiveType: MessageType, ArchiveFile: file.Name(), RecordCount: 1})
+	assert.Error(t, err)
+}
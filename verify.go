@@ -0,0 +1,156 @@
+package archiver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ArchiveVerification is the result of downloading an archive and checking its contents against what we recorded
+// when it was built
+type ArchiveVerification struct {
+	Archive     *Archive
+	Hash        string
+	Size        int64
+	RecordCount int
+	Problems    []string
+}
+
+// OK returns whether the archive matched what we recorded
+func (v *ArchiveVerification) OK() bool {
+	return len(v.Problems) == 0
+}
+
+func (v *ArchiveVerification) addProblem(format string, args ...interface{}) {
+	v.Problems = append(v.Problems, fmt.Sprintf(format, args...))
+}
+
+// lineCounter is a writer which counts the newlines written to it
+type lineCounter struct {
+	lines int
+}
+
+func (c *lineCounter) Write(p []byte) (int, error) {
+	c.lines += bytes.Count(p, []byte("\n"))
+	return len(p), nil
+}
+
+// VerifyArchive downloads the passed in archive from S3, recomputing its hash, size and number of records and comparing
+// them to those recorded for it. Problems reading the archive are reported as problems with the archive rather than
+// returned as errors.
+func VerifyArchive(ctx context.Context, s3Client s3iface.S3API, archive *Archive) *ArchiveVerification {
+	reader, err := GetS3File(ctx, s3Client, archive.URL)
+	if err != nil {
+		v := &ArchiveVerification{Archive: archive}
+		v.addProblem("unable to read %s: %s", archive.URL, err.Error())
+		return v
+	}
+	defer reader.Close()
+
+	return verifyContents(archive, reader)
+}
+
+// verifyContents checks the passed in gzipped archive contents against what was recorded for the archive
+func verifyContents(archive *Archive, reader io.Reader) *ArchiveVerification {
+	v := &ArchiveVerification{Archive: archive}
+
+	hash := md5.New()
+	counted := &countingReader{reader: io.TeeReader(reader, hash)}
+	records := &lineCounter{}
+	chain := NewRecordChain()
+
+	gzipReader, err := gzip.NewReader(counted)
+	if err == nil {
+		_, err = io.Copy(io.MultiWriter(records, chain), gzipReader)
+	}
+	if err != nil {
+		v.addProblem("unable to decompress: %s", err.Error())
+	}
+
+	// drain anything left so our hash and size cover the whole object
+	_, err = io.Copy(ioutil.Discard, counted)
+	if err != nil {
+		v.addProblem("unable to read: %s", err.Error())
+		return v
+	}
+
+	v.Hash = hex.EncodeToString(hash.Sum(nil))
+	v.Size = counted.count
+	v.RecordCount = records.lines
+
+	if v.Hash != archive.Hash {
+		v.addProblem("hash mismatch, expected %s, got %s", archive.Hash, v.Hash)
+	}
+	if v.Size != archive.Size {
+		v.addProblem("size mismatch, expected %d, got %d", archive.Size, v.Size)
+	}
+	if v.RecordCount != archive.RecordCount {
+		v.addProblem("record count mismatch, expected %d, got %d", archive.RecordCount, v.RecordCount)
+	}
+	if archive.ChainHash != nil && *archive.ChainHash != chain.Hash() {
+		v.addProblem("record chain mismatch, expected %s, got %s", *archive.ChainHash, chain.Hash())
+	}
+	return v
+}
+
+// countingReader is a reader which counts the bytes read through it
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
+const lookupArchivesToVerify = selectArchiveFields + `
+WHERE ($1 = 0 OR org_id = $1) AND created_on >= $2 AND url != '' AND purged_on IS NULL
+ORDER BY org_id, archive_type, start_date, period desc
+`
+
+// GetArchivesToVerify returns the archives on S3 for the passed in org, or all orgs if zero, created since the passed in time
+func GetArchivesToVerify(ctx context.Context, db *sqlx.DB, orgID int, since time.Time) ([]*Archive, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute*5)
+	defer cancel()
+
+	archives := make([]*Archive, 0)
+	err := db.SelectContext(ctx, &archives, lookupArchivesToVerify, orgID, since)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error selecting archives to verify")
+	}
+	return archives, nil
+}
+
+const setArchiveVerified = `
+UPDATE archives_archive
+SET verified_on = $2, verify_problems = $3
+WHERE id = $1
+`
+
+// RecordArchiveVerification saves the result of verifying an archive, flagging any problems found on the archive itself
+func RecordArchiveVerification(ctx context.Context, db *sqlx.DB, v *ArchiveVerification) error {
+	var problems *string
+	if !v.OK() {
+		p := strings.Join(v.Problems, "\n")
+		problems = &p
+	}
+
+	_, err := db.ExecContext(ctx, setArchiveVerified, v.Archive.ID, time.Now(), problems)
+	if err != nil {
+		return errors.Wrapf(err, "error recording verification of archive: %d", v.Archive.ID)
+	}
+	return nil
+}
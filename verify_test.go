@@ -0,0 +1,72 @@
+package archiver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyContents(t *testing.T) {
+	contents := &bytes.Buffer{}
+	gz := gzip.NewWriter(contents)
+	gz.Write([]byte("{\"id\": 1}\n{\"id\": 2}\n"))
+	gz.Close()
+
+	hash := md5.Sum(contents.Bytes())
+	archive := &Archive{ID: 1, Hash: hex.EncodeToString(hash[:]), Size: int64(contents.Len()), RecordCount: 2}
+
+	v := verifyContents(archive, bytes.NewReader(contents.Bytes()))
+	assert.True(t, v.OK())
+	assert.Equal(t, archive.Hash, v.Hash)
+	assert.Equal(t, archive.Size, v.Size)
+	assert.Equal(t, 2, v.RecordCount)
+
+	archive = &Archive{ID: 1, Hash: "abc", Size: 10, RecordCount: 3}
+	v = verifyContents(archive, bytes.NewReader(contents.Bytes()))
+	assert.False(t, v.OK())
+	assert.Equal(t, 3, len(v.Problems))
+	assert.Contains(t, v.Problems[0], "hash mismatch")
+	assert.Contains(t, v.Problems[1], "size mismatch")
+	assert.Equal(t, "record count mismatch, expected 3, got 2", v.Problems[2])
+
+	v = verifyContents(archive, bytes.NewReader([]byte("not gzipped")))
+	assert.False(t, v.OK())
+	assert.Contains(t, v.Problems[0], "unable to decompress")
+}
+
+func TestRecordArchiveVerification(t *testing.T) {
+	db := setup(t)
+	ctx := context.Background()
+
+	db.MustExec(`UPDATE archives_archive SET url = 'https://s3.amazonaws.com/bucket/' || id WHERE id IN (2, 3, 4)`)
+	db.MustExec(`UPDATE archives_archive SET purged_on = NOW() WHERE id = 2`)
+
+	archives, err := GetArchivesToVerify(ctx, db, 0, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(archives))
+	assert.Equal(t, 4, archives[0].ID)
+	assert.Equal(t, 3, archives[1].ID)
+
+	archives, err = GetArchivesToVerify(ctx, db, 3, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(archives))
+
+	archives, err = GetArchivesToVerify(ctx, db, 0, time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(archives))
+	assert.Equal(t, 4, archives[0].ID)
+
+	v := &ArchiveVerification{Archive: archives[0], Problems: []string{"hash mismatch", "size mismatch"}}
+	assert.NoError(t, RecordArchiveVerification(ctx, db, v))
+	assertCount(t, db, 1, `SELECT count(*) FROM archives_archive WHERE id = 4 AND verified_on IS NOT NULL AND verify_problems = E'hash mismatch\nsize mismatch'`)
+
+	v = &ArchiveVerification{Archive: archives[0]}
+	assert.NoError(t, RecordArchiveVerification(ctx, db, v))
+	assertCount(t, db, 1, `SELECT count(*) FROM archives_archive WHERE id = 4 AND verified_on IS NOT NULL AND verify_problems IS NULL`)
+}
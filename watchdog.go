@@ -0,0 +1,47 @@
+package archiver
+
+import (
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// watchdog logs a warning each time a soft timeout passes while a task is still running, so that we can see what a
+// slow query or upload is doing well before the hard deadline of its context kills it
+type watchdog struct {
+	done chan bool
+}
+
+// startWatchdog starts a watchdog for the passed in task which warns every passed in duration until stopped, a zero
+// duration disables it, in which case nil is returned which is still safe to stop
+func startWatchdog(log *logrus.Entry, task string, after time.Duration) *watchdog {
+	if after <= 0 {
+		return nil
+	}
+
+	w := &watchdog{done: make(chan bool)}
+	start := time.Now()
+
+	go func() {
+		ticker := time.NewTicker(after)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.done:
+				return
+			case <-ticker.C:
+				log.WithField("elapsed", time.Since(start)).Warnf("%s is taking longer than expected", task)
+			}
+		}
+	}()
+
+	return w
+}
+
+// stop stops this watchdog, it must be called exactly once when the task completes
+func (w *watchdog) stop() {
+	if w != nil {
+		close(w.done)
+	}
+}
@@ -0,0 +1,55 @@
+package archiver
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// lockedBuffer is a buffer which is safe to write to from our watchdog goroutine while we read it
+type lockedBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+func TestWatchdog(t *testing.T) {
+	output := &lockedBuffer{}
+	logger := logrus.New()
+	logger.Out = output
+	log := logrus.NewEntry(logger).WithField("sql", "SELECT 1")
+
+	// disabled watchdogs are nil but can still be stopped
+	watchdog := startWatchdog(log, "export query", 0)
+	assert.Nil(t, watchdog)
+	watchdog.stop()
+
+	// a task that finishes in time logs nothing
+	watchdog = startWatchdog(log, "export query", time.Second)
+	watchdog.stop()
+	assert.Equal(t, "", output.String())
+
+	// a slow task is warned about, with its fields
+	watchdog = startWatchdog(log, "export query", 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	watchdog.stop()
+
+	assert.Contains(t, output.String(), "level=warning")
+	assert.Contains(t, output.String(), "export query is taking longer than expected")
+	assert.Contains(t, output.String(), `sql="SELECT 1"`)
+}
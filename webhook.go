@@ -0,0 +1,116 @@
+package archiver
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// webhook events we send
+const (
+	WebhookRunCompleted = "run_completed"
+	WebhookFatalError   = "fatal_error"
+)
+
+// WebhookSignatureHeader is the header which contains the hex HMAC-SHA256 of the payload body, keyed with our secret
+const WebhookSignatureHeader = "X-Archiver-Signature"
+
+// WebhookPayload is the JSON body posted to our webhook
+type WebhookPayload struct {
+	Event   string      `json:"event"`
+	SentOn  time.Time   `json:"sent_on"`
+	Summary *RunSummary `json:"summary,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// Webhook notifies an external URL of the results of each run and of fatal errors. All methods are safe to call on a
+// nil webhook, in which case they do nothing, so that callers don't need to check whether it is enabled. It is also a
+// logrus hook, so that fatal errors are sent before we exit.
+type Webhook struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+// NewWebhook creates a new webhook from the passed in config, returning nil if no webhook URL is configured
+func NewWebhook(config *Config) *Webhook {
+	if config.WebhookURL == "" {
+		return nil
+	}
+	return &Webhook{url: config.WebhookURL, secret: config.WebhookSecret, client: &http.Client{Timeout: time.Second * 30}}
+}
+
+// NotifyRun sends the passed in summary of a completed run
+func (w *Webhook) NotifyRun(ctx context.Context, summary *RunSummary) error {
+	return w.Send(ctx, &WebhookPayload{Event: WebhookRunCompleted, SentOn: time.Now(), Summary: summary})
+}
+
+// Send posts the passed in payload, signed with our secret if we have one, returning an error for any non-2XX response
+func (w *Webhook) Send(ctx context.Context, payload *WebhookPayload) error {
+	if w == nil {
+		return nil
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrapf(err, "error marshalling webhook payload")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "error creating webhook request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if w.secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(w.secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "error calling webhook")
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SignWebhookPayload returns the signature of the passed in payload body for the passed in secret
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Levels returns the log levels we send to our webhook as they happen
+func (w *Webhook) Levels() []logrus.Level {
+	return []logrus.Level{logrus.PanicLevel, logrus.FatalLevel}
+}
+
+// Fire sends the passed in fatal log entry to our webhook, this is called by logrus before exiting so must not log
+// anything at a fatal level itself
+func (w *Webhook) Fire(entry *logrus.Entry) error {
+	message := entry.Message
+	if err, ok := entry.Data[logrus.ErrorKey].(error); ok {
+		if message != "" {
+			message += ": "
+		}
+		message += err.Error()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	return w.Send(ctx, &WebhookPayload{Event: WebhookFatalError, SentOn: time.Now(), Error: message})
+}
@@ -0,0 +1,64 @@
+package archiver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhook(t *testing.T) {
+	ctx := context.Background()
+
+	// no URL, no webhook, but we can still notify it
+	config := NewConfig()
+	webhook := NewWebhook(config)
+	assert.Nil(t, webhook)
+	assert.NoError(t, webhook.NotifyRun(ctx, NewRunSummary(time.Now())))
+
+	var body []byte
+	var signature string
+	statusCode := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		signature = r.Header.Get(WebhookSignatureHeader)
+		w.WriteHeader(statusCode)
+	}))
+	defer server.Close()
+
+	config.WebhookURL = server.URL
+	config.WebhookSecret = "sesame"
+	webhook = NewWebhook(config)
+
+	summary := NewRunSummary(time.Date(2018, 1, 8, 12, 30, 0, 0, time.UTC))
+	summary.AddOrg(Org{ID: 2}, MessageType, []*Archive{{ID: 1, RecordCount: 3}}, nil, nil)
+	summary.CompleteOrg()
+
+	assert.NoError(t, webhook.NotifyRun(ctx, summary))
+	assert.Equal(t, SignWebhookPayload("sesame", body), signature)
+
+	payload := &WebhookPayload{}
+	assert.NoError(t, json.Unmarshal(body, payload))
+	assert.Equal(t, WebhookRunCompleted, payload.Event)
+	assert.Equal(t, 1, payload.Summary.OrgsProcessed)
+	assert.Equal(t, []*RunOrgResult{{OrgID: 2, ArchiveType: MessageType, ArchivesCreated: 1, RecordsArchived: 3, Succeeded: true}}, payload.Summary.Orgs)
+
+	// fatal errors are sent as they happen
+	assert.NoError(t, webhook.Fire(logrus.WithError(errors.New("connection refused")).WithField("org_id", 2)))
+	payload = &WebhookPayload{}
+	assert.NoError(t, json.Unmarshal(body, payload))
+	assert.Equal(t, WebhookFatalError, payload.Event)
+	assert.Equal(t, "connection refused", payload.Error)
+	assert.Nil(t, payload.Summary)
+
+	// non 2XX responses are errors
+	statusCode = http.StatusInternalServerError
+	assert.EqualError(t, webhook.NotifyRun(ctx, summary), "webhook returned status 500")
+}